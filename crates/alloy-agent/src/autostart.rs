@@ -0,0 +1,325 @@
+use std::{
+    collections::{BTreeMap, BTreeSet, HashMap},
+    time::{Duration, Instant},
+};
+
+use alloy_process::ProcessState;
+
+use crate::process_manager::ProcessManager;
+use crate::process_manager_support::env_u64;
+
+const DEFAULT_PRIORITY: u32 = 100;
+const MAX_DELAY_MS: u64 = 10 * 60 * 1000;
+
+#[derive(Debug, serde::Deserialize)]
+struct InstanceJsonForAutostart {
+    instance_id: String,
+    #[serde(default)]
+    params: BTreeMap<String, String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct AutostartEntry {
+    pub(crate) instance_id: String,
+    pub(crate) priority: u32,
+    pub(crate) delay_ms: u64,
+    pub(crate) after: Vec<String>,
+}
+
+fn autostart_enabled() -> bool {
+    !matches!(
+        std::env::var("ALLOY_AUTOSTART_ENABLED")
+            .unwrap_or_default()
+            .trim()
+            .to_ascii_lowercase()
+            .as_str(),
+        "0" | "false" | "no" | "off"
+    )
+}
+
+fn dependency_timeout() -> Duration {
+    Duration::from_millis(
+        env_u64("ALLOY_AUTOSTART_DEPENDENCY_TIMEOUT_MS")
+            .map(|v| v.clamp(1000, 30 * 60 * 1000))
+            .unwrap_or(180_000),
+    )
+}
+
+fn param_is_true(params: &BTreeMap<String, String>, key: &str) -> bool {
+    matches!(
+        params
+            .get(key)
+            .map(|v| v.trim().to_ascii_lowercase())
+            .as_deref(),
+        Some("1" | "true" | "yes" | "on")
+    )
+}
+
+pub(crate) fn parse_autostart_entry(
+    instance_id: &str,
+    params: &BTreeMap<String, String>,
+) -> Option<AutostartEntry> {
+    if !param_is_true(params, "autostart") {
+        return None;
+    }
+
+    let priority = params
+        .get("autostart_priority")
+        .and_then(|v| v.trim().parse::<u32>().ok())
+        .unwrap_or(DEFAULT_PRIORITY)
+        .min(1000);
+    let delay_ms = params
+        .get("autostart_delay_ms")
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .unwrap_or(0)
+        .min(MAX_DELAY_MS);
+
+    let mut after = Vec::<String>::new();
+    for dep in params
+        .get("autostart_after")
+        .map(|v| v.as_str())
+        .unwrap_or_default()
+        .split([',', ' '])
+    {
+        let dep = dep.trim();
+        if dep.is_empty() || dep == instance_id || after.iter().any(|d| d == dep) {
+            continue;
+        }
+        after.push(dep.to_string());
+    }
+
+    Some(AutostartEntry {
+        instance_id: instance_id.to_string(),
+        priority,
+        delay_ms,
+        after,
+    })
+}
+
+// Orders entries so dependencies start first; ties are broken by priority (lower first), then id.
+// Dependencies that are not part of the autostart set don't constrain the order.
+// Cycles are reported and the remaining entries fall back to plain priority order.
+pub(crate) fn plan_order(entries: Vec<AutostartEntry>) -> (Vec<AutostartEntry>, Vec<String>) {
+    let ids: BTreeSet<String> = entries.iter().map(|e| e.instance_id.clone()).collect();
+    let mut by_id: HashMap<String, AutostartEntry> = HashMap::new();
+    let mut pending_deps: HashMap<String, usize> = HashMap::new();
+    let mut dependents: HashMap<String, Vec<String>> = HashMap::new();
+
+    for e in entries {
+        let deps: Vec<&String> = e.after.iter().filter(|d| ids.contains(*d)).collect();
+        pending_deps.insert(e.instance_id.clone(), deps.len());
+        for d in deps {
+            dependents
+                .entry(d.clone())
+                .or_default()
+                .push(e.instance_id.clone());
+        }
+        by_id.insert(e.instance_id.clone(), e);
+    }
+
+    let mut ready: BTreeSet<(u32, String)> = by_id
+        .values()
+        .filter(|e| pending_deps.get(&e.instance_id).copied().unwrap_or(0) == 0)
+        .map(|e| (e.priority, e.instance_id.clone()))
+        .collect();
+
+    let mut out = Vec::<AutostartEntry>::new();
+    while let Some(next) = ready.pop_first() {
+        let (_, id) = next;
+        if let Some(children) = dependents.get(&id) {
+            for child in children {
+                if let Some(n) = pending_deps.get_mut(child) {
+                    *n = n.saturating_sub(1);
+                    if *n == 0
+                        && let Some(c) = by_id.get(child)
+                    {
+                        ready.insert((c.priority, c.instance_id.clone()));
+                    }
+                }
+            }
+        }
+        if let Some(e) = by_id.remove(&id) {
+            out.push(e);
+        }
+    }
+
+    let mut cyclic: Vec<AutostartEntry> = by_id.into_values().collect();
+    cyclic.sort_by(|a, b| {
+        a.priority
+            .cmp(&b.priority)
+            .then_with(|| a.instance_id.cmp(&b.instance_id))
+    });
+    let cyclic_ids = cyclic.iter().map(|e| e.instance_id.clone()).collect();
+    out.extend(cyclic);
+
+    (out, cyclic_ids)
+}
+
+async fn load_autostart_entries() -> Vec<AutostartEntry> {
+    let base = crate::minecraft::data_root().join("instances");
+    let mut rd = match tokio::fs::read_dir(&base).await {
+        Ok(v) => v,
+        Err(_) => return Vec::new(),
+    };
+
+    let mut out = Vec::new();
+    while let Ok(Some(de)) = rd.next_entry().await {
+        let raw = match tokio::fs::read(de.path().join("instance.json")).await {
+            Ok(v) => v,
+            Err(_) => continue,
+        };
+        let inst = match serde_json::from_slice::<InstanceJsonForAutostart>(&raw) {
+            Ok(v) => v,
+            Err(e) => {
+                tracing::warn!(
+                    path = %de.path().display(),
+                    error = %e,
+                    "autostart: skipping unreadable instance.json"
+                );
+                continue;
+            }
+        };
+        if let Some(entry) = parse_autostart_entry(&inst.instance_id, &inst.params) {
+            out.push(entry);
+        }
+    }
+    out
+}
+
+async fn wait_for_dependency(manager: &ProcessManager, dep: &str, timeout: Duration) -> bool {
+    let deadline = Instant::now() + timeout;
+    loop {
+        match manager.get_status(dep).await.map(|s| s.state) {
+            Some(ProcessState::Running) => return true,
+            Some(ProcessState::Exited | ProcessState::Failed) => return false,
+            _ => {}
+        }
+        if Instant::now() >= deadline {
+            return false;
+        }
+        tokio::time::sleep(Duration::from_millis(500)).await;
+    }
+}
+
+async fn run(manager: ProcessManager) {
+    let entries = load_autostart_entries().await;
+    if entries.is_empty() {
+        return;
+    }
+
+    let (order, cyclic) = plan_order(entries);
+    if !cyclic.is_empty() {
+        tracing::warn!(
+            instances = ?cyclic,
+            "autostart: dependency cycle detected; starting these in priority order"
+        );
+    }
+    tracing::info!(
+        order = ?order.iter().map(|e| e.instance_id.as_str()).collect::<Vec<_>>(),
+        "autostart: starting instances"
+    );
+
+    let timeout = dependency_timeout();
+    for entry in order {
+        for dep in &entry.after {
+            if manager.get_status(dep).await.is_none() {
+                tracing::warn!(
+                    instance_id = %entry.instance_id,
+                    dependency = %dep,
+                    "autostart: dependency is not running and not scheduled; ignoring"
+                );
+                continue;
+            }
+            if !wait_for_dependency(&manager, dep, timeout).await {
+                tracing::warn!(
+                    instance_id = %entry.instance_id,
+                    dependency = %dep,
+                    "autostart: dependency did not reach running state; starting anyway"
+                );
+            }
+        }
+
+        if entry.delay_ms > 0 {
+            tokio::time::sleep(Duration::from_millis(entry.delay_ms)).await;
+        }
+
+        match crate::instance_service::start_instance(&manager, &entry.instance_id).await {
+            Ok(_) => {
+                tracing::info!(instance_id = %entry.instance_id, "autostart: instance started")
+            }
+            Err(status) => tracing::warn!(
+                instance_id = %entry.instance_id,
+                error = %status.message(),
+                "autostart: failed to start instance"
+            ),
+        }
+    }
+}
+
+pub fn spawn(manager: ProcessManager) {
+    if !autostart_enabled() {
+        tracing::info!("autostart: disabled via ALLOY_AUTOSTART_ENABLED");
+        return;
+    }
+    tokio::spawn(run(manager));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(id: &str, priority: u32, after: &[&str]) -> AutostartEntry {
+        AutostartEntry {
+            instance_id: id.to_string(),
+            priority,
+            delay_ms: 0,
+            after: after.iter().map(|s| s.to_string()).collect(),
+        }
+    }
+
+    fn ids(v: &[AutostartEntry]) -> Vec<&str> {
+        v.iter().map(|e| e.instance_id.as_str()).collect()
+    }
+
+    #[test]
+    fn parse_autostart_entry_requires_flag() {
+        let mut params = BTreeMap::new();
+        assert!(parse_autostart_entry("a", &params).is_none());
+
+        params.insert("autostart".to_string(), "true".to_string());
+        params.insert("autostart_priority".to_string(), "5".to_string());
+        params.insert("autostart_delay_ms".to_string(), "1500".to_string());
+        params.insert("autostart_after".to_string(), "proxy, db,a,db".to_string());
+        let e = parse_autostart_entry("a", &params).unwrap();
+        assert_eq!(e.priority, 5);
+        assert_eq!(e.delay_ms, 1500);
+        assert_eq!(e.after, vec!["proxy".to_string(), "db".to_string()]);
+    }
+
+    #[test]
+    fn plan_order_respects_dependencies_then_priority() {
+        let (order, cyclic) = plan_order(vec![
+            entry("lobby", 10, &["proxy"]),
+            entry("proxy", 50, &["db"]),
+            entry("db", 90, &[]),
+            entry("batch", 1, &[]),
+            entry("survival", 10, &["proxy", "missing"]),
+        ]);
+        assert!(cyclic.is_empty());
+        assert_eq!(
+            ids(&order),
+            vec!["batch", "db", "proxy", "lobby", "survival"]
+        );
+    }
+
+    #[test]
+    fn plan_order_reports_cycles() {
+        let (order, cyclic) = plan_order(vec![
+            entry("a", 2, &["b"]),
+            entry("b", 1, &["a"]),
+            entry("c", 3, &[]),
+        ]);
+        assert_eq!(ids(&order), vec!["c", "b", "a"]);
+        assert_eq!(cyclic, vec!["b".to_string(), "a".to_string()]);
+    }
+}
@@ -0,0 +1,167 @@
+use std::{
+    path::{Path, PathBuf},
+    time::{Duration, SystemTime},
+};
+
+use tonic::Status;
+
+use crate::error_payload::{Localized, MessageKey};
+
+// Delete protection for backups. With ALLOY_BACKUP_PROTECT_HOURS set, a backup archive under
+// <data root>/backups can't be removed, renamed or overwritten until it is that old, so a
+// compromised panel account can't wipe the backups before corrupting the world. The window is read
+// from the agent's environment only; nothing reachable from the panel can shorten it.
+//
+// Checked wherever archives can go away: FilesystemService Remove/Rename/WriteFile (including
+// recursive removes of a parent directory) and backup_schedule pruning. Deleting an instance
+// leaves its backups alone.
+
+pub fn window() -> Option<Duration> {
+    let hours = std::env::var("ALLOY_BACKUP_PROTECT_HOURS")
+        .ok()
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .unwrap_or(0);
+    (hours > 0).then(|| Duration::from_secs(hours * 3600))
+}
+
+fn backups_dir() -> PathBuf {
+    let dir = crate::minecraft::data_root().join("backups");
+    // Callers pass canonical paths.
+    std::fs::canonicalize(&dir).unwrap_or(dir)
+}
+
+fn is_archive(path: &Path) -> bool {
+    path.extension().is_some_and(|e| e == "zip")
+}
+
+// How much longer the archive at `path` is protected; None once it is old enough.
+fn remaining(path: &Path, window: Duration, now: SystemTime) -> Option<Duration> {
+    let modified = std::fs::metadata(path).ok()?.modified().ok()?;
+    let age = now.duration_since(modified).unwrap_or_default();
+    window.checked_sub(age).filter(|d| !d.is_zero())
+}
+
+// The first protected archive at or under `path`, with its remaining protection. `path` may also
+// be a directory containing `backups` (e.g. a recursive remove of the data root).
+fn find_protected(
+    backups: &Path,
+    path: &Path,
+    window: Duration,
+    now: SystemTime,
+) -> Option<(PathBuf, Duration)> {
+    let scan = if path.starts_with(backups) {
+        path
+    } else if backups.starts_with(path) {
+        backups
+    } else {
+        return None;
+    };
+    if scan.is_file() {
+        if !is_archive(scan) {
+            return None;
+        }
+        return remaining(scan, window, now).map(|left| (scan.to_path_buf(), left));
+    }
+    crate::fs_hash::walk_files(scan)
+        .ok()?
+        .into_iter()
+        .map(|rel| scan.join(rel))
+        .filter(|p| is_archive(p))
+        .find_map(|p| remaining(&p, window, now).map(|left| (p, left)))
+}
+
+fn format_left(left: Duration) -> String {
+    let minutes = left.as_secs().div_ceil(60);
+    match (minutes / 60, minutes % 60) {
+        (0, m) => format!("{m}m"),
+        (h, 0) => format!("{h}h"),
+        (h, m) => format!("{h}h {m}m"),
+    }
+}
+
+fn protected_error(backups: &Path, path: &Path, left: Duration) -> Status {
+    let shown = path
+        .strip_prefix(backups.parent().unwrap_or(backups))
+        .unwrap_or(path)
+        .display()
+        .to_string();
+    let left = format_left(left);
+    Status::failed_precondition(crate::error_payload::encode_localized(
+        "backup_protected",
+        Localized::new(
+            MessageKey::new("backup.protected")
+                .param("path", shown.clone())
+                .param("remaining", left.clone()),
+            format!("{shown} is a protected backup for another {left}"),
+        ),
+        None,
+        Some(Localized::new(
+            MessageKey::new("hint.backup.protected"),
+            "Backups can't be deleted, renamed or overwritten during ALLOY_BACKUP_PROTECT_HOURS.",
+        )),
+    ))
+}
+
+// Refuses a remove, rename or overwrite of `path` (canonical) that would take a protected archive
+// with it.
+pub async fn check(path: &Path) -> Result<(), Status> {
+    let Some(window) = window() else {
+        return Ok(());
+    };
+    let path = path.to_path_buf();
+    tokio::task::spawn_blocking(move || {
+        let backups = backups_dir();
+        match find_protected(&backups, &path, window, SystemTime::now()) {
+            Some((archive, left)) => Err(protected_error(&backups, &archive, left)),
+            None => Ok(()),
+        }
+    })
+    .await
+    .map_err(|e| Status::internal(format!("backup protection check failed: {e}")))?
+}
+
+// For pruning: whether the archive at `path` must be kept for now.
+pub fn is_protected(path: &Path) -> bool {
+    window().is_some_and(|w| remaining(path, w, SystemTime::now()).is_some())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn finds_protected_archives_under_and_above_backups() {
+        let root = std::env::temp_dir().join(format!(
+            "alloy-backup-protection-test-{}",
+            std::process::id()
+        ));
+        let _ = std::fs::remove_dir_all(&root);
+        let backups = root.join("backups");
+        std::fs::create_dir_all(backups.join("a")).unwrap();
+        std::fs::create_dir_all(root.join("instances").join("a")).unwrap();
+        let archive = backups.join("a").join("a-1.zip");
+        std::fs::write(&archive, b"zip").unwrap();
+        std::fs::write(backups.join("a").join("notes.txt"), b"").unwrap();
+
+        let window = Duration::from_secs(24 * 3600);
+        let now = SystemTime::now();
+        for path in [&archive, &backups.join("a"), &backups, &root] {
+            let (found, left) = find_protected(&backups, path, window, now).unwrap();
+            assert_eq!(found, archive);
+            assert!(left > Duration::from_secs(23 * 3600));
+        }
+        assert!(find_protected(&backups, &root.join("instances"), window, now).is_none());
+        assert!(
+            find_protected(&backups, &backups.join("a").join("notes.txt"), window, now).is_none()
+        );
+        // Past the window.
+        let later = now + Duration::from_secs(25 * 3600);
+        assert!(find_protected(&backups, &root, window, later).is_none());
+
+        assert_eq!(format_left(Duration::from_secs(30)), "1m");
+        assert_eq!(format_left(Duration::from_secs(7200)), "2h");
+        assert_eq!(format_left(Duration::from_secs(5 * 3600 + 61)), "5h 2m");
+
+        let _ = std::fs::remove_dir_all(&root);
+    }
+}
@@ -0,0 +1,563 @@
+use std::{
+    collections::BTreeMap,
+    path::Path,
+    sync::{Mutex, OnceLock},
+    time::Duration,
+};
+
+use tokio::sync::Notify;
+
+use crate::process_manager::ProcessManager;
+
+// Scheduled backups. An instance is backed up every `backup_interval_minutes` (instance param), or
+// every `interval_minutes` from <data root>/backup-schedule.json when it sets none; `0` or `off`
+// on the instance opts it out. The same file sets how often schedules are re-read
+// (`poll_every_sec`) and how many archives to keep per instance (`keep`, overridable with the
+// `backup_keep` param).
+//
+// Schedules are re-read on every poll, when an instance is created or updated, and on
+// ReloadBackupSchedule. A changed interval takes effect right away: the next backup is due one new
+// interval from the reload instead of at the old due time. Invalid values are reported to the
+// caller and logged, and leave that schedule as it was.
+//
+// Backups need a stopped instance. A due backup of a running server waits and runs at the first
+// poll after it stops.
+
+pub(crate) const PARAM_INTERVAL: &str = "backup_interval_minutes";
+pub(crate) const PARAM_KEEP: &str = "backup_keep";
+const CONFIG_FILE: &str = "backup-schedule.json";
+const MIN_INTERVAL_MINUTES: u64 = 5;
+const DEFAULT_POLL_SEC: u64 = 60;
+const MIN_POLL_SEC: u64 = 5;
+
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Deserialize)]
+#[serde(deny_unknown_fields)]
+struct GlobalConfig {
+    #[serde(default)]
+    poll_every_sec: Option<u64>,
+    #[serde(default)]
+    interval_minutes: Option<u64>,
+    #[serde(default)]
+    keep: Option<u32>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ConfigError {
+    // `backup-schedule.json` or an instance id.
+    pub source: String,
+    pub message: String,
+}
+
+// What the config asks for, one entry per scheduled instance.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Wanted {
+    interval_minutes: u64,
+    keep: u32,
+    // From backup-schedule.json rather than the instance's params.
+    global: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Entry {
+    pub instance_id: String,
+    pub interval_minutes: u64,
+    pub keep: u32,
+    pub global: bool,
+    pub next_due_unix_ms: u64,
+    pub last_run_unix_ms: u64,
+    pub last_result: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct Snapshot {
+    pub poll_every: Duration,
+    pub entries: Vec<Entry>,
+    pub errors: Vec<ConfigError>,
+    // Instances whose schedule this reload added, changed or removed.
+    pub changed: Vec<String>,
+}
+
+#[derive(Default)]
+struct State {
+    poll_every: Option<Duration>,
+    global: GlobalConfig,
+    entries: BTreeMap<String, Entry>,
+    errors: Vec<ConfigError>,
+}
+
+struct Shared {
+    state: Mutex<State>,
+    wake: Notify,
+}
+
+fn shared() -> &'static Shared {
+    static SHARED: OnceLock<Shared> = OnceLock::new();
+    SHARED.get_or_init(|| Shared {
+        state: Mutex::new(State::default()),
+        wake: Notify::new(),
+    })
+}
+
+// `0`/`off` disable; anything else must be a whole number of minutes.
+fn parse_interval(raw: &str) -> Result<u64, String> {
+    let raw = raw.trim();
+    if raw.eq_ignore_ascii_case("off") {
+        return Ok(0);
+    }
+    let minutes = raw
+        .parse::<u64>()
+        .map_err(|_| format!("{PARAM_INTERVAL} must be a number of minutes or off, got {raw:?}"))?;
+    if minutes != 0 && minutes < MIN_INTERVAL_MINUTES {
+        return Err(format!(
+            "{PARAM_INTERVAL} must be at least {MIN_INTERVAL_MINUTES} minutes, got {minutes}"
+        ));
+    }
+    Ok(minutes)
+}
+
+fn parse_keep(raw: &str) -> Result<u32, String> {
+    raw.trim().parse::<u32>().map_err(|_| {
+        format!("{PARAM_KEEP} must be a number of archives (0 keeps all), got {raw:?}")
+    })
+}
+
+// Validates the schedule params of an instance; used when instances are created or updated.
+pub(crate) fn validate_params(params: &BTreeMap<String, String>) -> Result<(), String> {
+    if let Some(v) = params.get(PARAM_INTERVAL)
+        && !v.trim().is_empty()
+    {
+        parse_interval(v)?;
+    }
+    if let Some(v) = params.get(PARAM_KEEP)
+        && !v.trim().is_empty()
+    {
+        parse_keep(v)?;
+    }
+    Ok(())
+}
+
+fn wanted(
+    params: &BTreeMap<String, String>,
+    global: &GlobalConfig,
+) -> Result<Option<Wanted>, String> {
+    validate_params(params)?;
+    let own = params
+        .get(PARAM_INTERVAL)
+        .filter(|v| !v.trim().is_empty())
+        .map(|v| parse_interval(v))
+        .transpose()?;
+    let (interval_minutes, is_global) = match own {
+        Some(minutes) => (minutes, false),
+        None => (global.interval_minutes.unwrap_or(0), true),
+    };
+    if interval_minutes == 0 {
+        return Ok(None);
+    }
+    let keep = params
+        .get(PARAM_KEEP)
+        .filter(|v| !v.trim().is_empty())
+        .map(|v| parse_keep(v))
+        .transpose()?
+        .or(global.keep)
+        .unwrap_or(0);
+    Ok(Some(Wanted {
+        interval_minutes,
+        keep,
+        global: is_global,
+    }))
+}
+
+fn parse_global(raw: &[u8]) -> Result<GlobalConfig, String> {
+    let cfg: GlobalConfig =
+        serde_json::from_slice(raw).map_err(|e| format!("invalid {CONFIG_FILE}: {e}"))?;
+    if let Some(sec) = cfg.poll_every_sec
+        && sec < MIN_POLL_SEC
+    {
+        return Err(format!(
+            "poll_every_sec must be at least {MIN_POLL_SEC}, got {sec}"
+        ));
+    }
+    if let Some(minutes) = cfg.interval_minutes
+        && minutes != 0
+        && minutes < MIN_INTERVAL_MINUTES
+    {
+        return Err(format!(
+            "interval_minutes must be at least {MIN_INTERVAL_MINUTES}, got {minutes}"
+        ));
+    }
+    Ok(cfg)
+}
+
+// (instance id, its params or why they couldn't be read)
+type InstanceParams = Vec<(String, Result<BTreeMap<String, String>, String>)>;
+
+#[derive(serde::Deserialize)]
+struct InstanceJsonForSchedule {
+    instance_id: String,
+    #[serde(default)]
+    params: BTreeMap<String, String>,
+}
+
+// Reads the config. Unreadable or invalid sources come back as `Err` so their previous schedule
+// is kept.
+fn read_config(root: &Path) -> (Result<GlobalConfig, String>, InstanceParams) {
+    let global = match std::fs::read(root.join(CONFIG_FILE)) {
+        Ok(raw) => parse_global(&raw),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(GlobalConfig::default()),
+        Err(e) => Err(format!("failed to read {CONFIG_FILE}: {e}")),
+    };
+
+    let mut instances = Vec::new();
+    if let Ok(rd) = std::fs::read_dir(root.join("instances")) {
+        for de in rd.flatten() {
+            let Ok(raw) = std::fs::read(de.path().join("instance.json")) else {
+                continue;
+            };
+            match serde_json::from_slice::<InstanceJsonForSchedule>(&raw) {
+                Ok(inst) => instances.push((inst.instance_id, Ok(inst.params))),
+                Err(e) => instances.push((
+                    de.file_name().to_string_lossy().to_string(),
+                    Err(format!("unreadable instance.json: {e}")),
+                )),
+            }
+        }
+    }
+    (global, instances)
+}
+
+// Applies a freshly read config to `state`; returns the instances whose schedule changed.
+fn apply(
+    state: &mut State,
+    global: Result<GlobalConfig, String>,
+    instances: InstanceParams,
+    now_ms: u64,
+) -> Vec<String> {
+    let mut errors = Vec::new();
+    match global {
+        Ok(cfg) => state.global = cfg,
+        Err(message) => errors.push(ConfigError {
+            source: CONFIG_FILE.to_string(),
+            message,
+        }),
+    }
+    state.poll_every = Some(Duration::from_secs(
+        state.global.poll_every_sec.unwrap_or(DEFAULT_POLL_SEC),
+    ));
+
+    let mut changed = Vec::new();
+    let mut entries = BTreeMap::new();
+    for (id, params) in instances {
+        let wanted = params.and_then(|p| wanted(&p, &state.global));
+        let previous = state.entries.remove(&id);
+        let wanted = match wanted {
+            Ok(w) => w,
+            Err(message) => {
+                errors.push(ConfigError {
+                    source: id.clone(),
+                    message,
+                });
+                // Keep the last valid schedule until the config is fixed.
+                if let Some(prev) = previous {
+                    entries.insert(id, prev);
+                }
+                continue;
+            }
+        };
+        match (previous, wanted) {
+            (None, None) => {}
+            (Some(_), None) => changed.push(id),
+            (Some(prev), Some(w))
+                if prev.interval_minutes == w.interval_minutes && prev.keep == w.keep =>
+            {
+                entries.insert(
+                    id,
+                    Entry {
+                        global: w.global,
+                        ..prev
+                    },
+                );
+            }
+            (prev, Some(w)) => {
+                let (last_run_unix_ms, last_result) = prev
+                    .map(|p| (p.last_run_unix_ms, p.last_result))
+                    .unwrap_or_default();
+                entries.insert(
+                    id.clone(),
+                    Entry {
+                        instance_id: id.clone(),
+                        interval_minutes: w.interval_minutes,
+                        keep: w.keep,
+                        global: w.global,
+                        next_due_unix_ms: now_ms + w.interval_minutes * 60_000,
+                        last_run_unix_ms,
+                        last_result,
+                    },
+                );
+                changed.push(id);
+            }
+        }
+    }
+    // Schedules of deleted instances.
+    changed.extend(std::mem::take(&mut state.entries).into_keys());
+    changed.sort();
+    state.entries = entries;
+
+    if errors != state.errors {
+        for e in &errors {
+            tracing::warn!(
+                source = %e.source,
+                error = %e.message,
+                "backup schedule: invalid config"
+            );
+        }
+    }
+    state.errors = errors;
+    changed
+}
+
+fn snapshot(state: &State, changed: Vec<String>) -> Snapshot {
+    Snapshot {
+        poll_every: state
+            .poll_every
+            .unwrap_or(Duration::from_secs(DEFAULT_POLL_SEC)),
+        entries: state.entries.values().cloned().collect(),
+        errors: state.errors.clone(),
+        changed,
+    }
+}
+
+async fn reload_state() -> Snapshot {
+    let root = crate::minecraft::data_root();
+    let (global, instances) = tokio::task::spawn_blocking(move || read_config(&root))
+        .await
+        .unwrap_or_else(|e| (Err(format!("failed to read config: {e}")), Vec::new()));
+    let snapshot = {
+        let mut state = shared().state.lock().unwrap_or_else(|e| e.into_inner());
+        let changed = apply(
+            &mut state,
+            global,
+            instances,
+            crate::console_audit::now_unix_ms(),
+        );
+        if !changed.is_empty() {
+            tracing::info!(instances = ?changed, "backup schedule: reloaded");
+        }
+        snapshot(&state, changed)
+    };
+    snapshot
+}
+
+// Re-reads the config now and wakes the scheduler so it sleeps for the new interval.
+pub async fn reload() -> Snapshot {
+    let snapshot = reload_state().await;
+    wake();
+    snapshot
+}
+
+// Asks the scheduler to re-read its config, e.g. after an instance's params changed.
+pub fn wake() {
+    shared().wake.notify_one();
+}
+
+// Newest first by the timestamp in `<id>-scheduled-<unix_ms>.zip`; older scheduled archives beyond
+// `keep` are removed unless backup_protection still protects them. Manual backups are never pruned.
+fn prune(dir: &Path, instance_id: &str, keep: u32) -> usize {
+    if keep == 0 {
+        return 0;
+    }
+    let Ok(rd) = std::fs::read_dir(dir) else {
+        return 0;
+    };
+    let prefix = format!("{instance_id}-scheduled-");
+    let mut archives: Vec<(u64, std::path::PathBuf)> = rd
+        .flatten()
+        .filter_map(|de| {
+            let name = de.file_name().to_string_lossy().to_string();
+            let ms = name
+                .strip_prefix(&prefix)?
+                .strip_suffix(".zip")?
+                .parse::<u64>()
+                .ok()?;
+            Some((ms, de.path()))
+        })
+        .collect();
+    archives.sort_by(|a, b| b.0.cmp(&a.0));
+    let mut removed = 0;
+    for (_, path) in archives.into_iter().skip(keep as usize) {
+        // Kept past `keep` until its protection window ends.
+        if crate::backup_protection::is_protected(&path) {
+            continue;
+        }
+        if std::fs::remove_file(&path).is_ok() {
+            removed += 1;
+        }
+    }
+    removed
+}
+
+async fn run_due(manager: &ProcessManager) {
+    let now_ms = crate::console_audit::now_unix_ms();
+    let due: Vec<(String, u32)> = {
+        let state = shared().state.lock().unwrap_or_else(|e| e.into_inner());
+        state
+            .entries
+            .values()
+            .filter(|e| e.next_due_unix_ms <= now_ms)
+            .map(|e| (e.instance_id.clone(), e.keep))
+            .collect()
+    };
+
+    for (id, keep) in due {
+        let res = crate::instance_service::backup_instance(manager, &id, "scheduled").await;
+        let finished_ms = crate::console_audit::now_unix_ms();
+        let (ran, result) = match res {
+            Ok(resp) => {
+                let dir = crate::minecraft::data_root().join("backups").join(&id);
+                let removed = tokio::task::spawn_blocking({
+                    let id = id.clone();
+                    move || prune(&dir, &id, keep)
+                })
+                .await
+                .unwrap_or(0);
+                tracing::info!(
+                    instance_id = %id,
+                    path = %resp.path,
+                    removed,
+                    "scheduled backup written"
+                );
+                (true, format!("ok: {}", resp.path))
+            }
+            // Running; stays due until the server stops.
+            Err(status) if status.code() == tonic::Code::FailedPrecondition => {
+                (false, format!("waiting: {}", status.message()))
+            }
+            Err(status) => {
+                tracing::warn!(
+                    instance_id = %id,
+                    error = %status.message(),
+                    "scheduled backup failed"
+                );
+                (true, format!("failed: {}", status.message()))
+            }
+        };
+
+        let mut state = shared().state.lock().unwrap_or_else(|e| e.into_inner());
+        if let Some(e) = state.entries.get_mut(&id) {
+            e.last_result = result;
+            if ran {
+                e.last_run_unix_ms = finished_ms;
+                e.next_due_unix_ms = finished_ms + e.interval_minutes * 60_000;
+            }
+        }
+    }
+}
+
+pub fn spawn(manager: ProcessManager) {
+    tokio::spawn(async move {
+        loop {
+            let snapshot = reload_state().await;
+            run_due(&manager).await;
+
+            let now_ms = crate::console_audit::now_unix_ms();
+            let next_due = snapshot
+                .entries
+                .iter()
+                .map(|e| Duration::from_millis(e.next_due_unix_ms.saturating_sub(now_ms)))
+                .min()
+                .unwrap_or(snapshot.poll_every);
+            let sleep = snapshot
+                .poll_every
+                .min(next_due)
+                .max(Duration::from_secs(1));
+            tokio::select! {
+                _ = tokio::time::sleep(sleep) => {}
+                _ = shared().wake.notified() => {}
+            }
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn params(pairs: &[(&str, &str)]) -> BTreeMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn reschedules_changes_and_keeps_schedules_with_invalid_config() {
+        assert!(validate_params(&params(&[(PARAM_INTERVAL, "60")])).is_ok());
+        assert!(validate_params(&params(&[(PARAM_INTERVAL, "off")])).is_ok());
+        assert!(validate_params(&params(&[(PARAM_INTERVAL, "2")])).is_err());
+        assert!(validate_params(&params(&[(PARAM_KEEP, "-1")])).is_err());
+        assert!(parse_global(br#"{"poll_every_sec":1}"#).is_err());
+        assert!(parse_global(br#"{"poll_every":30}"#).is_err());
+
+        let mut state = State::default();
+        let changed = apply(
+            &mut state,
+            parse_global(br#"{"interval_minutes":1440,"keep":7}"#),
+            vec![
+                ("a".to_string(), Ok(params(&[(PARAM_INTERVAL, "60")]))),
+                ("b".to_string(), Ok(params(&[]))),
+                ("c".to_string(), Ok(params(&[(PARAM_INTERVAL, "0")]))),
+            ],
+            0,
+        );
+        assert_eq!(changed, ["a", "b"]);
+        assert_eq!(state.entries["a"].next_due_unix_ms, 3_600_000);
+        assert_eq!(state.entries["a"].keep, 7);
+        assert!(state.entries["b"].global);
+        assert!(!state.entries.contains_key("c"));
+
+        // Unchanged schedules keep their due time; a new interval counts from the reload.
+        let changed = apply(
+            &mut state,
+            parse_global(br#"{"interval_minutes":1440,"keep":7}"#),
+            vec![
+                ("a".to_string(), Ok(params(&[(PARAM_INTERVAL, "30")]))),
+                ("b".to_string(), Ok(params(&[]))),
+            ],
+            1_000,
+        );
+        assert_eq!(changed, ["a"]);
+        assert_eq!(state.entries["a"].next_due_unix_ms, 1_000 + 1_800_000);
+        assert_eq!(state.entries["b"].next_due_unix_ms, 1440 * 60_000);
+
+        // A typo is reported and leaves the previous schedule in place.
+        let changed = apply(
+            &mut state,
+            parse_global(br#"{"interval_minutes":1440,"keep":7}"#),
+            vec![
+                ("a".to_string(), Ok(params(&[(PARAM_INTERVAL, "30m")]))),
+                ("b".to_string(), Ok(params(&[]))),
+            ],
+            2_000,
+        );
+        assert!(changed.is_empty());
+        assert_eq!(state.entries["a"].interval_minutes, 30);
+        assert_eq!(state.errors.len(), 1);
+        assert_eq!(state.errors[0].source, "a");
+
+        let dir =
+            std::env::temp_dir().join(format!("alloy-backup-schedule-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+        for ms in [100, 300, 200] {
+            std::fs::write(dir.join(format!("a-scheduled-{ms}.zip")), b"").unwrap();
+        }
+        std::fs::write(dir.join("a-50.zip"), b"").unwrap();
+        std::fs::write(dir.join("a-notes.txt"), b"").unwrap();
+        assert_eq!(prune(&dir, "a", 2), 1);
+        assert!(!dir.join("a-scheduled-100.zip").exists());
+        assert!(dir.join("a-scheduled-200.zip").exists());
+        // Manual backups are left alone, however old.
+        assert!(dir.join("a-50.zip").exists());
+        assert!(dir.join("a-notes.txt").exists());
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,615 @@
+// alloyctl: manage the local agent over its unix socket, e.g. from SSH while the panel is down.
+
+use std::{path::PathBuf, time::Duration};
+
+use alloy_proto::agent_v1::{
+    BackupInstanceRequest, ConsoleClientMessage, ConsoleOpen, GetEnvReportRequest,
+    GetInstanceRequest, HibernateInstanceRequest, InstanceInfo, ListInstancesRequest,
+    ListTemplatesRequest, NodeInfoRequest, PerfAuditRequest, PlacementRequest, ProcessState,
+    ProcessStatus, ReloadBackupScheduleRequest, SelfTestRequest, SetLogLevelRequest,
+    StartInstanceRequest, StopInstanceRequest, SupportBundleRequest, TailLogsRequest,
+    agent_health_service_client::AgentHealthServiceClient, console_client_message,
+    instance_service_client::InstanceServiceClient, process_service_client::ProcessServiceClient,
+};
+use anyhow::Context;
+use tokio::io::AsyncBufReadExt;
+use tonic::transport::{Channel, Endpoint, Uri};
+
+const USAGE: &str = "\
+usage: alloyctl [--socket PATH] <command> [args]
+
+commands:
+  list                               list instances and their state
+  status <instance>                  show one instance
+  start <instance>                   start an instance
+  stop <instance> [--timeout-ms N]   stop an instance (default timeout 30s)
+  hibernate <instance> [--timeout-ms N]
+                                     checkpoint a server with CRIU (experimental); stops it
+                                     normally when checkpoints are unavailable
+  logs <instance> [-n N] [-f]        print the last N console lines (default 100); -f follows
+  attach <instance>                  interactive console: lines typed are sent to the server
+  backup <instance>                  zip a stopped instance into backups/<instance>/
+  env <instance> [--backup PATH]     show the Java, JVM flags, jar and mod hashes a Minecraft
+                                     server runs with; with --backup, what changed since then
+  support-bundle [instance...] [--keep-ips]
+                                     write a redacted support bundle into support-bundles/
+  perf-audit <instance>              flag Minecraft/Paper settings known to cause lag
+  selftest [--offline]               check sandbox, disk, Java, frpc, network, clock and ports
+  log-level [FILTER]                 show or set the agent's log filter, e.g. debug or
+                                     info,alloy_agent::frp=trace (until restart)
+  node-info                          show this node's id, name, labels and platform
+  schedule-reload                    re-read backup schedules now and list them; fails on
+                                     invalid schedule config
+  placement [--memory-mb N] [--disk-mb N] [--cpu-millicores N]
+                                     check whether a new instance with these needs fits here
+
+The socket defaults to $ALLOY_AGENT_SOCKET, else $ALLOY_DATA_ROOT/alloy-agent.sock.";
+
+fn default_socket() -> PathBuf {
+    if let Ok(v) = std::env::var("ALLOY_AGENT_SOCKET")
+        && !v.trim().is_empty()
+    {
+        return PathBuf::from(v.trim());
+    }
+    let root = std::env::var("ALLOY_DATA_ROOT").unwrap_or_else(|_| "./data".to_string());
+    PathBuf::from(root).join("alloy-agent.sock")
+}
+
+async fn connect(socket: PathBuf) -> anyhow::Result<Channel> {
+    // tonic needs a URI, but every connection goes to the socket.
+    Endpoint::try_from("http://localhost")?
+        .connect_with_connector(tower::service_fn(move |_: Uri| {
+            let socket = socket.clone();
+            async move {
+                let stream = tokio::net::UnixStream::connect(&socket).await?;
+                Ok::<_, std::io::Error>(hyper_util::rt::TokioIo::new(stream))
+            }
+        }))
+        .await
+        .context("connect to agent socket (is alloy-agent running?)")
+}
+
+fn status_error(status: tonic::Status) -> anyhow::Error {
+    anyhow::anyhow!("{}", status.message())
+}
+
+fn state_name(status: Option<&ProcessStatus>) -> String {
+    let Some(st) = status else {
+        return "stopped".to_string();
+    };
+    ProcessState::try_from(st.state)
+        .map(|s| {
+            s.as_str_name()
+                .trim_start_matches("PROCESS_STATE_")
+                .to_ascii_lowercase()
+        })
+        .unwrap_or_else(|_| "unknown".to_string())
+}
+
+fn print_instance(info: &InstanceInfo, secret_keys: &[String]) {
+    let Some(cfg) = info.config.as_ref() else {
+        return;
+    };
+    let st = info.status.as_ref();
+    println!("instance:  {}", cfg.instance_id);
+    if !cfg.display_name.is_empty() {
+        println!("name:      {}", cfg.display_name);
+    }
+    println!("template:  {}", cfg.template_id);
+    println!("state:     {}", state_name(st));
+    if let Some(st) = st {
+        if st.has_pid {
+            println!("pid:       {}", st.pid);
+        }
+        if !st.exit_reason.is_empty() {
+            println!("exit:      {} ({})", st.exit_reason, st.exit_category);
+        }
+        if !st.message.is_empty() {
+            println!("message:   {}", st.message);
+        }
+    }
+    let mut params: Vec<_> = cfg.params.iter().collect();
+    params.sort();
+    for (k, v) in params {
+        let shown = if secret_keys.contains(k) {
+            "<redacted>"
+        } else {
+            v.as_str()
+        };
+        println!("  {k} = {shown}");
+    }
+}
+
+async fn tail(channel: Channel, id: &str, lines: u32, follow: bool) -> anyhow::Result<()> {
+    let mut client = ProcessServiceClient::new(channel);
+    let mut cursor = String::new();
+    let mut limit = lines;
+    loop {
+        let resp = client
+            .tail_logs(TailLogsRequest {
+                process_id: id.to_string(),
+                limit,
+                cursor: cursor.clone(),
+            })
+            .await
+            .map_err(status_error)?
+            .into_inner();
+        for line in &resp.lines {
+            println!("{line}");
+        }
+        if !follow {
+            return Ok(());
+        }
+        let caught_up = resp.lines.len() < limit as usize;
+        cursor = resp.next_cursor;
+        limit = 500;
+        if caught_up {
+            tokio::select! {
+                _ = tokio::time::sleep(Duration::from_millis(500)) => {}
+                _ = tokio::signal::ctrl_c() => return Ok(()),
+            }
+        }
+    }
+}
+
+// Console output goes to stdout; every line read from stdin is sent as server input. The agent
+// records each line against this session in its console input audit.
+async fn attach(channel: Channel, id: &str) -> anyhow::Result<()> {
+    let (tx, rx) = tokio::sync::mpsc::channel::<ConsoleClientMessage>(16);
+    tx.send(ConsoleClientMessage {
+        msg: Some(console_client_message::Msg::Open(ConsoleOpen {
+            process_id: id.to_string(),
+            history_lines: 100,
+            actor: std::env::var("USER").unwrap_or_default(),
+        })),
+    })
+    .await?;
+
+    let mut output = ProcessServiceClient::new(channel)
+        .attach_console(tokio_stream::wrappers::ReceiverStream::new(rx))
+        .await
+        .map_err(status_error)?
+        .into_inner();
+
+    let input = tokio::spawn(async move {
+        let mut lines = tokio::io::BufReader::new(tokio::io::stdin()).lines();
+        while let Ok(Some(line)) = lines.next_line().await {
+            let msg = ConsoleClientMessage {
+                msg: Some(console_client_message::Msg::Input(line)),
+            };
+            if tx.send(msg).await.is_err() {
+                break;
+            }
+        }
+    });
+
+    let res = loop {
+        let msg = tokio::select! {
+            msg = output.message() => msg,
+            _ = tokio::signal::ctrl_c() => break Ok(()),
+        };
+        match msg {
+            Ok(Some(msg)) => {
+                if !msg.session_id.is_empty() {
+                    eprintln!(
+                        "attached to {id} (session {}); Ctrl-C to detach",
+                        msg.session_id
+                    );
+                }
+                if msg.dropped > 0 {
+                    eprintln!("[alloyctl] {} line(s) skipped", msg.dropped);
+                }
+                if !msg.error.is_empty() {
+                    eprintln!("[alloyctl] input rejected: {}", msg.error);
+                } else if msg.session_id.is_empty() {
+                    println!("{}", msg.line);
+                }
+            }
+            Ok(None) => break Ok(()),
+            Err(status) => break Err(status_error(status)),
+        }
+    };
+    input.abort();
+    res
+}
+
+fn take_flag_value(args: &mut Vec<String>, names: &[&str]) -> anyhow::Result<Option<String>> {
+    let Some(i) = args.iter().position(|a| names.contains(&a.as_str())) else {
+        return Ok(None);
+    };
+    if i + 1 >= args.len() {
+        anyhow::bail!("{} needs a value", args[i]);
+    }
+    let value = args.remove(i + 1);
+    args.remove(i);
+    Ok(Some(value))
+}
+
+fn take_flag(args: &mut Vec<String>, names: &[&str]) -> bool {
+    let before = args.len();
+    args.retain(|a| !names.contains(&a.as_str()));
+    args.len() != before
+}
+
+fn instance_arg(args: &[String]) -> anyhow::Result<String> {
+    match args {
+        [id] => Ok(id.clone()),
+        _ => anyhow::bail!("expected exactly one instance id\n\n{USAGE}"),
+    }
+}
+
+#[tokio::main]
+async fn main() -> anyhow::Result<()> {
+    let mut args: Vec<String> = std::env::args().skip(1).collect();
+    if args.is_empty() || take_flag(&mut args, &["-h", "--help"]) {
+        println!("{USAGE}");
+        return Ok(());
+    }
+    let socket = take_flag_value(&mut args, &["--socket"])?
+        .map(PathBuf::from)
+        .unwrap_or_else(default_socket);
+    if args.is_empty() {
+        anyhow::bail!("missing command\n\n{USAGE}");
+    }
+    let command = args.remove(0);
+
+    match command.as_str() {
+        "list" => {
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .list(ListInstancesRequest {})
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!(
+                "{:<24} {:<22} {:<10} {:<8} NAME",
+                "INSTANCE", "TEMPLATE", "STATE", "PID"
+            );
+            for info in &resp.instances {
+                let Some(cfg) = info.config.as_ref() else {
+                    continue;
+                };
+                let st = info.status.as_ref();
+                let pid = st
+                    .filter(|s| s.has_pid)
+                    .map(|s| s.pid.to_string())
+                    .unwrap_or_else(|| "-".to_string());
+                println!(
+                    "{:<24} {:<22} {:<10} {:<8} {}",
+                    cfg.instance_id,
+                    cfg.template_id,
+                    state_name(st),
+                    pid,
+                    cfg.display_name
+                );
+            }
+        }
+        "status" => {
+            let id = instance_arg(&args)?;
+            let channel = connect(socket).await?;
+            let resp = InstanceServiceClient::new(channel.clone())
+                .get(GetInstanceRequest { instance_id: id })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            let Some(info) = resp.info.as_ref() else {
+                return Ok(());
+            };
+            // Keys the template marks as secret (passwords, tokens) are not printed.
+            let template_id = info.config.as_ref().map(|c| c.template_id.clone());
+            let templates = ProcessServiceClient::new(channel)
+                .list_templates(ListTemplatesRequest {})
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            let secret_keys: Vec<String> = templates
+                .templates
+                .iter()
+                .filter(|t| Some(&t.template_id) == template_id.as_ref())
+                .flat_map(|t| t.params.iter().filter(|p| p.secret).map(|p| p.key.clone()))
+                .collect();
+            print_instance(info, &secret_keys);
+        }
+        "start" => {
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .start(StartInstanceRequest { instance_id: id })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!("{}", state_name(resp.status.as_ref()));
+        }
+        "stop" => {
+            let timeout_ms = take_flag_value(&mut args, &["--timeout-ms"])?
+                .map(|v| v.parse::<u32>().context("invalid --timeout-ms"))
+                .transpose()?
+                .unwrap_or(0);
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .stop(StopInstanceRequest {
+                    instance_id: id,
+                    timeout_ms,
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!("{}", state_name(resp.status.as_ref()));
+        }
+        "hibernate" => {
+            let timeout_ms = take_flag_value(&mut args, &["--timeout-ms"])?
+                .map(|v| v.parse::<u32>().context("invalid --timeout-ms"))
+                .transpose()?
+                .unwrap_or(0);
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .hibernate(HibernateInstanceRequest {
+                    instance_id: id,
+                    timeout_ms,
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            if resp.checkpointed {
+                println!(
+                    "hibernated ({} MiB checkpoint, {} ms)",
+                    resp.checkpoint_bytes / (1024 * 1024),
+                    resp.elapsed_ms
+                );
+            } else {
+                println!(
+                    "{} (not checkpointed: {})",
+                    state_name(resp.status.as_ref()),
+                    resp.fallback_reason
+                );
+            }
+        }
+        "logs" => {
+            let lines = take_flag_value(&mut args, &["-n", "--lines"])?
+                .map(|v| v.parse::<u32>().context("invalid -n"))
+                .transpose()?
+                .unwrap_or(100)
+                .clamp(1, 5000);
+            let follow = take_flag(&mut args, &["-f", "--follow"]);
+            let id = instance_arg(&args)?;
+            tail(connect(socket).await?, &id, lines, follow).await?;
+        }
+        "attach" => {
+            let id = instance_arg(&args)?;
+            attach(connect(socket).await?, &id).await?;
+            // The stdin reader is parked in a blocking read that would keep the runtime alive.
+            std::process::exit(0);
+        }
+        "backup" => {
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .backup(BackupInstanceRequest { instance_id: id })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!(
+                "{} ({} bytes in {} ms)",
+                resp.path, resp.size_bytes, resp.duration_ms
+            );
+        }
+        "env" => {
+            let backup_path = take_flag_value(&mut args, &["--backup"])?.unwrap_or_default();
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .get_env_report(GetEnvReportRequest {
+                    instance_id: id,
+                    backup_path: backup_path.clone(),
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            let current = resp.current.unwrap_or_default();
+            println!(
+                "java:     {}",
+                current.java_version.lines().next().unwrap_or("-")
+            );
+            println!("flags:    {}", current.jvm_flags.join(" "));
+            if let Some(jar) = &current.server_jar {
+                println!(
+                    "jar:      {} {} {}",
+                    jar.sha256, current.jar_provider, current.jar_version
+                );
+            }
+            for m in &current.mods {
+                println!("mod:      {} {}", m.sha256, m.path);
+            }
+            if !backup_path.is_empty() {
+                if resp.backup.is_none() {
+                    println!("backup has no environment report");
+                } else if resp.differences.is_empty() {
+                    println!("no differences from the backup");
+                } else {
+                    for d in &resp.differences {
+                        println!("changed:  {d}");
+                    }
+                }
+            }
+        }
+        "support-bundle" => {
+            let keep_ips = take_flag(&mut args, &["--keep-ips"]);
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .support_bundle(SupportBundleRequest {
+                    instance_ids: args,
+                    console_lines: 0,
+                    keep_ip_addresses: keep_ips,
+                    inline: false,
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!(
+                "{} ({} bytes, {} files, {} redactions)",
+                resp.path,
+                resp.size_bytes,
+                resp.files.len(),
+                resp.redactions
+            );
+        }
+        "perf-audit" => {
+            let id = instance_arg(&args)?;
+            let mut client = InstanceServiceClient::new(connect(socket).await?);
+            let resp = client
+                .perf_audit(PerfAuditRequest { instance_id: id })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!("checked: {}", resp.files.join(", "));
+            for f in &resp.findings {
+                let value = if f.is_default {
+                    format!("{} (default)", f.value)
+                } else {
+                    f.value.clone()
+                };
+                println!(
+                    "{:<8} {} {}: {} -> {}  {}",
+                    f.severity, f.file, f.key, value, f.suggested, f.message
+                );
+            }
+            if resp.findings.is_empty() {
+                println!("no findings");
+            }
+        }
+        "selftest" => {
+            let skip_network = take_flag(&mut args, &["--offline"]);
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .self_test(SelfTestRequest {
+                    skip_network,
+                    port_start: 0,
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            for c in &resp.checks {
+                println!("{:<8} {:<20} {}", c.outcome, c.name, c.message);
+            }
+            if !resp.ok {
+                anyhow::bail!("self-test failed");
+            }
+        }
+        "log-level" => {
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .set_log_level(SetLogLevelRequest {
+                    filter: args.join(","),
+                })
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            if resp.previous.is_empty() {
+                println!("{}", resp.filter);
+            } else {
+                println!("{} (was {})", resp.filter, resp.previous);
+            }
+        }
+        "schedule-reload" => {
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .reload_backup_schedule(ReloadBackupScheduleRequest {})
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            let now_ms = std::time::SystemTime::now()
+                .duration_since(std::time::UNIX_EPOCH)
+                .map(|d| d.as_millis() as u64)
+                .unwrap_or(0);
+            for s in &resp.schedules {
+                let changed = if resp.changed.contains(&s.instance_id) {
+                    " (changed)"
+                } else {
+                    ""
+                };
+                println!(
+                    "{}: every {} min, next in {} min{changed}{}",
+                    s.instance_id,
+                    s.interval_minutes,
+                    s.next_due_unix_ms.saturating_sub(now_ms) / 60_000,
+                    if s.last_result.is_empty() {
+                        String::new()
+                    } else {
+                        format!(", last {}", s.last_result)
+                    }
+                );
+            }
+            if resp.schedules.is_empty() {
+                println!("no backup schedules");
+            }
+            for e in &resp.errors {
+                eprintln!("error: {}: {}", e.source, e.message);
+            }
+            if !resp.errors.is_empty() {
+                anyhow::bail!("{} invalid schedule setting(s)", resp.errors.len());
+            }
+        }
+        "node-info" => {
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .node_info(NodeInfoRequest {})
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!("id:       {}", resp.node_id);
+            println!("name:     {}", resp.name);
+            let mut labels: Vec<_> = resp
+                .labels
+                .iter()
+                .map(|(k, v)| format!("{k}={v}"))
+                .collect();
+            labels.sort();
+            println!("labels:   {}", labels.join(","));
+            if !resp.public_address.is_empty() {
+                println!("address:  {}", resp.public_address);
+            }
+            println!(
+                "platform: {}/{} ({} cpus), agent {}",
+                resp.os, resp.arch, resp.cpus, resp.agent_version
+            );
+            println!("data:     {}", resp.data_root);
+            if resp.checkpoint_supported {
+                println!("criu:     supported");
+            } else {
+                println!("criu:     {}", resp.checkpoint_unsupported_reason);
+            }
+        }
+        "placement" => {
+            let mut amount = |flag: &str| -> anyhow::Result<u64> {
+                Ok(take_flag_value(&mut args, &[flag])?
+                    .map(|v| v.parse::<u64>().with_context(|| format!("invalid {flag}")))
+                    .transpose()?
+                    .unwrap_or(0))
+            };
+            let req = PlacementRequest {
+                memory_mb: amount("--memory-mb")?,
+                disk_mb: amount("--disk-mb")?,
+                cpu_millicores: amount("--cpu-millicores")?,
+            };
+            let mut client = AgentHealthServiceClient::new(connect(socket).await?);
+            let resp = client
+                .placement(req)
+                .await
+                .map_err(status_error)?
+                .into_inner();
+            println!(
+                "{} (score {})",
+                if resp.fits { "fits" } else { "does not fit" },
+                resp.score
+            );
+            for r in &resp.reasons {
+                println!("  {r}");
+            }
+        }
+        other => anyhow::bail!("unknown command: {other}\n\n{USAGE}"),
+    }
+
+    Ok(())
+}
@@ -0,0 +1,177 @@
+use std::{
+    collections::BTreeMap,
+    io::ErrorKind,
+    net::{IpAddr, TcpListener},
+    path::Path,
+};
+
+// Per-instance bind addresses for multi-homed hosts. ALLOY_BIND_POOL lists addresses of this host
+// that Minecraft instances can be pinned to through the `bind_address` param: `auto` takes the
+// first pool address no other instance is pinned to (kept in instance.json from the first start
+// on), an explicit address is used as given. The address is written to `server-ip`, and ports are
+// reserved per address, so instances on different addresses can all listen on 25565.
+
+pub const PARAM: &str = "bind_address";
+const AUTO: &str = "auto";
+const HINT: &str =
+    "Use an address of this host, `auto` to pick one from ALLOY_BIND_POOL, or leave it blank.";
+
+pub fn pool() -> Vec<IpAddr> {
+    let raw = std::env::var("ALLOY_BIND_POOL").unwrap_or_default();
+    let mut out: Vec<IpAddr> = Vec::new();
+    for entry in raw.split(',').map(str::trim).filter(|v| !v.is_empty()) {
+        match crate::netaddr::parse_ip(entry) {
+            Some(ip) if ip.is_unspecified() || ip.is_loopback() => {
+                tracing::warn!(%entry, "ignoring wildcard or loopback address in ALLOY_BIND_POOL");
+            }
+            Some(ip) if !out.contains(&ip) => out.push(ip),
+            Some(_) => {}
+            None => tracing::warn!(%entry, "ignoring invalid address in ALLOY_BIND_POOL"),
+        }
+    }
+    out
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Request {
+    Unset,
+    Auto,
+    Fixed(IpAddr),
+}
+
+fn invalid(msg: &str) -> anyhow::Error {
+    let mut fields = BTreeMap::new();
+    fields.insert(PARAM.to_string(), msg.to_string());
+    crate::error_payload::anyhow(
+        "invalid_param",
+        format!("invalid {PARAM}"),
+        Some(fields),
+        Some(HINT.to_string()),
+    )
+}
+
+pub fn requested(params: &BTreeMap<String, String>) -> anyhow::Result<Request> {
+    let raw = params.get(PARAM).map(|v| v.trim()).unwrap_or("");
+    if raw.is_empty() {
+        return Ok(Request::Unset);
+    }
+    if raw.eq_ignore_ascii_case(AUTO) {
+        return Ok(Request::Auto);
+    }
+    match crate::netaddr::parse_ip(raw) {
+        Some(ip) if ip.is_unspecified() => Err(invalid(
+            "Must be a single address; leave it blank to listen on all of them.",
+        )),
+        Some(ip) => Ok(Request::Fixed(ip)),
+        None => Err(invalid("Must be an IPv4 or IPv6 address, or auto.")),
+    }
+}
+
+// Addresses other instances are pinned to, with the instance holding each.
+fn pinned_elsewhere(instances_root: &Path, instance_id: &str) -> BTreeMap<IpAddr, String> {
+    let mut out = BTreeMap::new();
+    let Ok(rd) = std::fs::read_dir(instances_root) else {
+        return out;
+    };
+    for de in rd.flatten() {
+        let id = de.file_name().to_string_lossy().to_string();
+        if id == instance_id {
+            continue;
+        }
+        let Some(ip) = std::fs::read(de.path().join("instance.json"))
+            .ok()
+            .and_then(|raw| serde_json::from_slice::<serde_json::Value>(&raw).ok())
+            .and_then(|v| {
+                v.get("params")?
+                    .get(PARAM)?
+                    .as_str()
+                    .and_then(crate::netaddr::parse_ip)
+            })
+        else {
+            continue;
+        };
+        out.insert(ip, id);
+    }
+    out
+}
+
+fn pick(pool: &[IpAddr], taken: &BTreeMap<IpAddr, String>) -> anyhow::Result<IpAddr> {
+    if pool.is_empty() {
+        return Err(invalid("No address pool is configured (ALLOY_BIND_POOL)."));
+    }
+    pool.iter()
+        .copied()
+        .find(|ip| !taken.contains_key(ip))
+        .ok_or_else(|| invalid("Every address in ALLOY_BIND_POOL is taken by another instance."))
+}
+
+// Picks a pool address for `instance_id`, for `auto`.
+pub fn assign(instance_id: &str) -> anyhow::Result<IpAddr> {
+    let root = crate::minecraft::data_root().join("instances");
+    pick(&pool(), &pinned_elsewhere(&root, instance_id))
+}
+
+// Checks that `ip` belongs to this host, so the server fails here instead of inside Java.
+pub fn validate(ip: IpAddr) -> anyhow::Result<()> {
+    match TcpListener::bind((ip, 0)) {
+        Ok(_) => Ok(()),
+        Err(e) if e.kind() == ErrorKind::AddrNotAvailable => Err(invalid(&format!(
+            "{ip} is not configured on any interface of this host."
+        ))),
+        Err(e) => Err(invalid(&format!("cannot bind {ip}: {e}"))),
+    }
+}
+
+// The address `instance_id` is pinned to, if any. `auto` is normally resolved and saved before
+// the start; otherwise a pool address is picked for this run only.
+pub fn resolve(
+    instance_id: &str,
+    params: &BTreeMap<String, String>,
+) -> anyhow::Result<Option<IpAddr>> {
+    let ip = match requested(params)? {
+        Request::Unset => return Ok(None),
+        Request::Auto => assign(instance_id)?,
+        Request::Fixed(ip) => ip,
+    };
+    validate(ip)?;
+    Ok(Some(ip))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn picks_the_first_free_pool_address() {
+        let root =
+            std::env::temp_dir().join(format!("alloy-bind-pool-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        for (id, addr) in [("a", "192.0.2.10"), ("b", ""), ("c", "192.0.2.10")] {
+            std::fs::create_dir_all(root.join(id)).unwrap();
+            let inst = serde_json::json!({ "params": { PARAM: addr } });
+            std::fs::write(root.join(id).join("instance.json"), inst.to_string()).unwrap();
+        }
+
+        let pool: Vec<IpAddr> = ["192.0.2.10", "192.0.2.11"]
+            .iter()
+            .map(|v| v.parse().unwrap())
+            .collect();
+        assert_eq!(pick(&pool, &pinned_elsewhere(&root, "b")).unwrap(), pool[1]);
+        // Its own pin doesn't count against it.
+        let taken = pinned_elsewhere(&root, "a");
+        assert_eq!(taken.get(&pool[0]).map(String::as_str), Some("c"));
+        assert!(pick(&[pool[0]], &taken).is_err());
+        assert!(pick(&[], &BTreeMap::new()).is_err());
+
+        let params = |v: &str| BTreeMap::from([(PARAM.to_string(), v.to_string())]);
+        assert_eq!(requested(&params(" AUTO ")).unwrap(), Request::Auto);
+        assert_eq!(requested(&params("")).unwrap(), Request::Unset);
+        assert_eq!(
+            requested(&params("[2001:db8::10]")).unwrap(),
+            Request::Fixed("2001:db8::10".parse().unwrap())
+        );
+        assert!(requested(&params("0.0.0.0")).is_err());
+        assert!(requested(&params("eth1")).is_err());
+        let _ = std::fs::remove_dir_all(&root);
+    }
+}
@@ -0,0 +1,220 @@
+use std::{
+    net::{ToSocketAddrs, UdpSocket},
+    sync::Mutex,
+    time::{Duration, Instant, SystemTime, UNIX_EPOCH},
+};
+
+// Host clock checks. Crash records, backups and console timestamps use the wall clock, so a
+// skewed host makes them disagree with control and with players' reports. The agent compares its
+// clock against an NTP server (SNTP, one request) and reports the offset in the health check and
+// the self-test.
+
+// Seconds between the NTP epoch (1900) and the Unix epoch.
+const NTP_UNIX_OFFSET_SECS: u64 = 2_208_988_800;
+const QUERY_TIMEOUT: Duration = Duration::from_secs(5);
+// The health check refreshes the cached measurement at most this often.
+const REFRESH_INTERVAL_MS: u64 = 10 * 60 * 1000;
+pub const WARN_OFFSET_MS: i64 = 1_000;
+pub const FAIL_OFFSET_MS: i64 = 30_000;
+
+#[derive(Debug, Clone)]
+pub struct Skew {
+    pub server: String,
+    // Positive when the host clock is behind the server.
+    pub offset_ms: i64,
+    pub round_trip_ms: u64,
+    pub checked_at_unix_ms: u64,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct Status {
+    pub last: Option<Skew>,
+    pub error: Option<String>,
+    pub checked_at_unix_ms: u64,
+}
+
+static STATUS: Mutex<Option<Status>> = Mutex::new(None);
+
+pub fn ntp_server() -> Option<String> {
+    if crate::test_mode::enabled() {
+        return None;
+    }
+    let raw = std::env::var("ALLOY_NTP_SERVER").unwrap_or_default();
+    match raw.trim() {
+        "" => Some("pool.ntp.org".to_string()),
+        "off" | "0" | "false" => None,
+        server => Some(server.to_string()),
+    }
+}
+
+fn ntp_to_unix_ms(bytes: &[u8]) -> i64 {
+    let secs = u32::from_be_bytes([bytes[0], bytes[1], bytes[2], bytes[3]]) as i64;
+    let frac = u32::from_be_bytes([bytes[4], bytes[5], bytes[6], bytes[7]]) as i64;
+    (secs - NTP_UNIX_OFFSET_SECS as i64) * 1000 + ((frac * 1000) >> 32)
+}
+
+// Standard NTP offset from the client send/receive and server receive/transmit times.
+fn offset_ms(t1: i64, t2: i64, t3: i64, t4: i64) -> i64 {
+    ((t2 - t1) + (t3 - t4)) / 2
+}
+
+fn unix_ms(t: SystemTime) -> i64 {
+    t.duration_since(UNIX_EPOCH)
+        .map(|d| d.as_millis() as i64)
+        .unwrap_or_default()
+}
+
+// One SNTP request. Blocking; call from spawn_blocking.
+pub fn query(server: &str) -> anyhow::Result<Skew> {
+    let addr = (server, 123)
+        .to_socket_addrs()?
+        .next()
+        .ok_or_else(|| anyhow::anyhow!("{server} did not resolve"))?;
+    let bind = if addr.is_ipv6() {
+        "[::]:0"
+    } else {
+        "0.0.0.0:0"
+    };
+    let socket = UdpSocket::bind(bind)?;
+    socket.set_read_timeout(Some(QUERY_TIMEOUT))?;
+    socket.connect(addr)?;
+
+    let mut req = [0u8; 48];
+    // LI 0, version 4, mode 3 (client).
+    req[0] = 0x23;
+    let t1 = unix_ms(SystemTime::now());
+    let sent = Instant::now();
+    socket.send(&req)?;
+    let mut resp = [0u8; 48];
+    let n = socket
+        .recv(&mut resp)
+        .map_err(|e| anyhow::anyhow!("no reply from {server}: {e}"))?;
+    let round_trip = sent.elapsed();
+    // t4 from the monotonic clock, so a wall clock step during the query can't skew the result.
+    let t4 = t1 + round_trip.as_millis() as i64;
+    anyhow::ensure!(n >= 48, "short NTP reply from {server}");
+    anyhow::ensure!(resp[0] & 0x07 == 4, "unexpected NTP mode from {server}");
+    anyhow::ensure!(resp[1] != 0, "{server} sent a kiss-of-death reply");
+
+    let t2 = ntp_to_unix_ms(&resp[32..40]);
+    let t3 = ntp_to_unix_ms(&resp[40..48]);
+    Ok(Skew {
+        server: server.to_string(),
+        offset_ms: offset_ms(t1, t2, t3, t4),
+        round_trip_ms: round_trip.as_millis() as u64,
+        checked_at_unix_ms: crate::console_audit::now_unix_ms(),
+    })
+}
+
+// Queries the configured server and caches the result for the health check.
+pub async fn refresh() -> Option<Result<Skew, String>> {
+    let server = ntp_server()?;
+    let res = tokio::task::spawn_blocking(move || query(&server))
+        .await
+        .map_err(|e| e.to_string())
+        .and_then(|r| r.map_err(|e| format!("{e:#}")));
+    let mut status = STATUS.lock().unwrap_or_else(|e| e.into_inner());
+    let entry = status.get_or_insert_with(Status::default);
+    entry.checked_at_unix_ms = crate::console_audit::now_unix_ms();
+    match &res {
+        Ok(skew) => {
+            entry.last = Some(skew.clone());
+            entry.error = None;
+        }
+        Err(e) => entry.error = Some(e.clone()),
+    }
+    Some(res)
+}
+
+// The cached measurement; starts a refresh in the background when it is missing or stale.
+pub fn status() -> Status {
+    let current = STATUS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone()
+        .unwrap_or_default();
+    let stale = crate::console_audit::now_unix_ms().saturating_sub(current.checked_at_unix_ms)
+        > REFRESH_INTERVAL_MS;
+    if stale && ntp_server().is_some() {
+        // Mark it as checked so concurrent health checks don't all start a query.
+        if let Ok(mut s) = STATUS.lock() {
+            s.get_or_insert_with(Status::default).checked_at_unix_ms =
+                crate::console_audit::now_unix_ms();
+        }
+        tokio::spawn(refresh());
+    }
+    current
+}
+
+// The local timezone name (from TZ, /etc/timezone or the /etc/localtime link) and its current
+// offset from UTC in seconds.
+pub fn timezone() -> (String, i32) {
+    if crate::test_mode::enabled() {
+        return ("UTC".to_string(), 0);
+    }
+    let name = std::env::var("TZ")
+        .ok()
+        .map(|v| v.trim().trim_start_matches(':').to_string())
+        .filter(|v| !v.is_empty())
+        .or_else(|| {
+            std::fs::read_to_string("/etc/timezone")
+                .ok()
+                .map(|v| v.trim().to_string())
+                .filter(|v| !v.is_empty())
+        })
+        .or_else(|| {
+            let target = std::fs::read_link("/etc/localtime").ok()?;
+            let target = target.to_string_lossy();
+            target
+                .split_once("zoneinfo/")
+                .map(|(_, zone)| zone.to_string())
+        })
+        .unwrap_or_else(|| "UTC".to_string());
+    (name, utc_offset_secs())
+}
+
+// Fields that let a reader interpret wall clock timestamps in event metadata: the timezone and,
+// when measured, how far the host clock was off.
+pub fn metadata() -> serde_json::Map<String, serde_json::Value> {
+    let (timezone, utc_offset_seconds) = timezone();
+    let mut out = serde_json::Map::new();
+    out.insert("timezone".to_string(), timezone.into());
+    out.insert("utc_offset_seconds".to_string(), utc_offset_seconds.into());
+    if let Some(skew) = status().last {
+        out.insert("clock_offset_ms".to_string(), skew.offset_ms.into());
+    }
+    out
+}
+
+#[cfg(unix)]
+fn utc_offset_secs() -> i32 {
+    let now = unsafe { libc::time(std::ptr::null_mut()) };
+    let mut tm: libc::tm = unsafe { std::mem::zeroed() };
+    if unsafe { libc::localtime_r(&now, &mut tm) }.is_null() {
+        return 0;
+    }
+    tm.tm_gmtoff as i32
+}
+
+#[cfg(not(unix))]
+fn utc_offset_secs() -> i32 {
+    0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn computes_offsets_from_ntp_timestamps() {
+        // 2024-01-01T00:00:00Z is 3913056000 in NTP seconds; half a second of fraction.
+        let mut ts = [0u8; 8];
+        ts[..4].copy_from_slice(&3_913_056_000u32.to_be_bytes());
+        ts[4..].copy_from_slice(&(1u32 << 31).to_be_bytes());
+        assert_eq!(ntp_to_unix_ms(&ts), 1_704_067_200_500);
+
+        // Host 2s behind, 100 ms round trip split evenly.
+        assert_eq!(offset_ms(1_000, 3_050, 3_050, 1_100), 2_000);
+        assert_eq!(offset_ms(3_000, 1_050, 1_050, 3_100), -2_000);
+    }
+}
@@ -0,0 +1,228 @@
+use std::{
+    future::Future,
+    pin::Pin,
+    sync::OnceLock,
+    task::{Context, Poll},
+};
+
+use tonic::{Status, body::BoxBody, codegen::http};
+
+use crate::error_payload::{Localized, MessageKey};
+
+// Command families an operator can switch off with ALLOY_DISABLED_COMMANDS. Entries may also be
+// single methods ("FilesystemService/WriteFile") or whole services ("FilesystemService/*").
+const FAMILIES: &[(&str, &[&str])] = &[
+    (
+        "fs_read",
+        &[
+            "FilesystemService/ListDir",
+            "FilesystemService/StatBatch",
+            "FilesystemService/Hash",
+            "FilesystemService/ReadFile",
+            "FilesystemService/Search",
+            "LogsService/TailFile",
+        ],
+    ),
+    (
+        "fs_write",
+        &[
+            "FilesystemService/Mkdir",
+            "FilesystemService/WriteFile",
+            "FilesystemService/Rename",
+            "FilesystemService/Remove",
+            // Rewrites server.properties, like WriteFile would.
+            "InstanceService/ApplyPropertyProfiles",
+            "InstanceService/SavePropertyProfile",
+            "InstanceService/DeletePropertyProfile",
+            "InstanceService/ManageFile",
+            "InstanceService/UnmanageFile",
+        ],
+    ),
+    (
+        "exec",
+        &[
+            "ProcessService/StartFromTemplate",
+            "InstanceService/Start",
+            "InstanceService/ValidateStart",
+        ],
+    ),
+    (
+        "console",
+        &["ProcessService/SendInput", "ProcessService/AttachConsole"],
+    ),
+    (
+        "download",
+        &[
+            "InstanceService/ImportSaveFromUrl",
+            "InstanceService/InstallWebMap",
+            "ProcessService/WarmTemplateCache",
+        ],
+    ),
+    (
+        "delete",
+        &["InstanceService/Delete", "FilesystemService/Remove"],
+    ),
+    (
+        "frp",
+        &["InstanceService/FrpAdmin", "InstanceService/ExposeWebMap"],
+    ),
+    ("restore", &["InstanceService/RestorePlayerData"]),
+    ("paste", &["LogsService/PasteFile"]),
+    ("support", &["AgentHealthService/SupportBundle"]),
+];
+
+// The handshake itself must keep working, otherwise control cannot even learn the policy.
+const ALWAYS_ALLOWED: &[&str] = &[
+    "AgentHealthService/Check",
+    "FilesystemService/GetCapabilities",
+];
+
+const METHOD_PREFIX: &str = "/alloy.agent.v1.";
+
+#[derive(Debug, Default)]
+struct Policy {
+    // Normalized "Service/Method" or "Service/*" rules.
+    rules: Vec<String>,
+    // The entries as configured, reported to control.
+    entries: Vec<String>,
+}
+
+impl Policy {
+    fn parse(raw: &str) -> Self {
+        let mut policy = Policy::default();
+        for entry in raw.split([',', ' ', '\n', '\t']) {
+            let entry = entry.trim();
+            if entry.is_empty() {
+                continue;
+            }
+            if let Some((_, methods)) = FAMILIES
+                .iter()
+                .find(|(name, _)| name.eq_ignore_ascii_case(entry))
+            {
+                policy.rules.extend(methods.iter().map(|m| m.to_string()));
+            } else if entry.contains('/') {
+                let rule = entry
+                    .trim_start_matches('/')
+                    .trim_start_matches(&METHOD_PREFIX[1..])
+                    .to_string();
+                policy.rules.push(rule);
+            } else {
+                tracing::warn!(entry, "ignoring unknown entry in ALLOY_DISABLED_COMMANDS");
+                continue;
+            }
+            policy.entries.push(entry.to_string());
+        }
+        policy
+    }
+
+    fn denies(&self, method: &str) -> bool {
+        let method = method.strip_prefix(METHOD_PREFIX).unwrap_or(method);
+        if ALWAYS_ALLOWED.contains(&method) {
+            return false;
+        }
+        let service = method.split('/').next().unwrap_or_default();
+        self.rules.iter().any(|rule| match rule.strip_suffix("/*") {
+            Some(svc) => svc == service,
+            None => rule == method,
+        })
+    }
+}
+
+fn policy() -> &'static Policy {
+    static POLICY: OnceLock<Policy> = OnceLock::new();
+    POLICY.get_or_init(|| {
+        Policy::parse(&std::env::var("ALLOY_DISABLED_COMMANDS").unwrap_or_default())
+    })
+}
+
+// Entries of the active policy, advertised in the control handshake and GetCapabilities.
+pub fn disabled() -> Vec<String> {
+    policy().entries.clone()
+}
+
+pub fn check(method: &str) -> Result<(), Status> {
+    if !policy().denies(method) {
+        return Ok(());
+    }
+    let name = method.strip_prefix(METHOD_PREFIX).unwrap_or(method);
+    Err(Status::permission_denied(
+        crate::error_payload::encode_localized(
+            "command_disabled",
+            Localized::new(
+                MessageKey::new("command.disabled").param("method", name),
+                format!("command disabled by policy: {name}"),
+            ),
+            None,
+            Some(Localized::new(
+                MessageKey::new("hint.command.disabled"),
+                "This node's operator disabled it via ALLOY_DISABLED_COMMANDS.",
+            )),
+        ),
+    ))
+}
+
+// Rejects disabled methods on the direct gRPC listener before they reach a service.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct PolicyLayer;
+
+impl<S> tower::Layer<S> for PolicyLayer {
+    type Service = PolicyService<S>;
+
+    fn layer(&self, inner: S) -> Self::Service {
+        PolicyService { inner }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct PolicyService<S> {
+    inner: S,
+}
+
+impl<S, B> tower::Service<http::Request<B>> for PolicyService<S>
+where
+    S: tower::Service<http::Request<B>, Response = http::Response<BoxBody>>,
+    S::Future: Send + 'static,
+{
+    type Response = S::Response;
+    type Error = S::Error;
+    type Future = Pin<Box<dyn Future<Output = Result<Self::Response, Self::Error>> + Send>>;
+
+    fn poll_ready(&mut self, cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        self.inner.poll_ready(cx)
+    }
+
+    fn call(&mut self, req: http::Request<B>) -> Self::Future {
+        if let Err(status) = check(req.uri().path()) {
+            return Box::pin(async move { Ok(status.into_http()) });
+        }
+        Box::pin(self.inner.call(req))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn matches_families_methods_and_services() {
+        let p =
+            Policy::parse("fs_write, /alloy.agent.v1.InstanceService/Delete LogsService/*, bogus");
+        assert_eq!(
+            p.entries,
+            [
+                "fs_write",
+                "/alloy.agent.v1.InstanceService/Delete",
+                "LogsService/*"
+            ]
+        );
+        assert!(p.denies("/alloy.agent.v1.FilesystemService/WriteFile"));
+        assert!(p.denies("/alloy.agent.v1.InstanceService/Delete"));
+        assert!(p.denies("/alloy.agent.v1.LogsService/TailFile"));
+        assert!(!p.denies("/alloy.agent.v1.FilesystemService/ReadFile"));
+        assert!(!p.denies("/alloy.agent.v1.InstanceService/DeletePreview"));
+
+        let all = Policy::parse("FilesystemService/*,AgentHealthService/*");
+        assert!(!all.denies("/alloy.agent.v1.FilesystemService/GetCapabilities"));
+        assert!(!all.denies("/alloy.agent.v1.AgentHealthService/Check"));
+    }
+}
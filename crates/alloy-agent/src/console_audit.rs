@@ -0,0 +1,68 @@
+use std::path::PathBuf;
+
+use tokio::io::AsyncWriteExt;
+
+// Every console input line, with who sent it, appended to logs/console-input.jsonl under the
+// data root. Control keeps its own audit entries for panel users; this one also covers local
+// (alloyctl) and direct gRPC sessions.
+#[derive(Debug, Clone, serde::Serialize)]
+pub(crate) struct ConsoleInputRecord<'a> {
+    pub(crate) at_unix_ms: u64,
+    pub(crate) process_id: &'a str,
+    pub(crate) actor: &'a str,
+    pub(crate) session_id: &'a str,
+    pub(crate) line: &'a str,
+    pub(crate) ok: bool,
+    // Refused because the line starts with a command that needs confirmation (see console_guard).
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub(crate) unconfirmed: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(crate) request_id: Option<String>,
+}
+
+fn audit_path() -> PathBuf {
+    crate::minecraft::data_root()
+        .join("logs")
+        .join("console-input.jsonl")
+}
+
+pub(crate) fn now_unix_ms() -> u64 {
+    if let Some(ms) = crate::test_mode::now_unix_ms() {
+        return ms;
+    }
+    std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_millis() as u64)
+        .unwrap_or(0)
+}
+
+pub(crate) async fn record(rec: &ConsoleInputRecord<'_>) {
+    tracing::info!(
+        process_id = rec.process_id,
+        actor = rec.actor,
+        session_id = rec.session_id,
+        ok = rec.ok,
+        "console input"
+    );
+
+    let Ok(mut line) = serde_json::to_vec(rec) else {
+        return;
+    };
+    line.push(b'\n');
+    let path = audit_path();
+    if let Some(dir) = path.parent() {
+        let _ = tokio::fs::create_dir_all(dir).await;
+    }
+    let res = async {
+        let mut f = tokio::fs::OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&path)
+            .await?;
+        f.write_all(&line).await
+    }
+    .await;
+    if let Err(err) = res {
+        tracing::warn!(error = %err, path = %path.display(), "failed to write console input audit");
+    }
+}
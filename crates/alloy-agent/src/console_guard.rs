@@ -0,0 +1,231 @@
+use std::{
+    sync::OnceLock,
+    time::{Duration, Instant},
+};
+
+use tonic::Status;
+
+use crate::error_payload::{Localized, MessageKey};
+
+// Console input reaches the server as-is, past the command policy. Two guards sit in front of
+// it, both applied where input enters the agent (SendInput and AttachConsole) so they cover the
+// panel, alloyctl and direct gRPC alike:
+// - Lines starting with a guarded command (ALLOY_CONSOLE_CONFIRM_COMMANDS, by default `stop`,
+//   `op` and `whitelist off`) are refused until confirmed: SendInput with `confirmed` set, or the
+//   same line sent twice within CONFIRM_WINDOW on an attached console.
+// - ALLOY_CONSOLE_ATTRIBUTION names the sender in the game: `log` writes an agent line to the
+//   console output, `say` also broadcasts it with the server's `say` command. The default, `off`,
+//   leaves attribution to logs/console-input.jsonl.
+
+const CONFIRM_DEFAULT: &[&str] = &["stop", "op", "whitelist off"];
+pub const CONFIRM_WINDOW: Duration = Duration::from_secs(30);
+
+// Lowercased, without a leading `/` or `<namespace>:` and with runs of whitespace collapsed.
+fn normalize(line: &str) -> String {
+    let line = line
+        .trim()
+        .trim_start_matches('/')
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+        .to_ascii_lowercase();
+    strip_namespace(&line).to_string()
+}
+
+// `minecraft:stop` runs the same command as `stop`.
+fn strip_namespace(command: &str) -> &str {
+    let first = command.split(' ').next().unwrap_or_default();
+    match first.split_once(':') {
+        Some((ns, _))
+            if !ns.is_empty()
+                && ns
+                    .chars()
+                    .all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '.')) =>
+        {
+            &command[ns.len() + 1..]
+        }
+        _ => command,
+    }
+}
+
+// Unset means the defaults; `off`, `none` or an empty value guard nothing.
+fn parse_commands(raw: Option<&str>) -> Vec<String> {
+    let Some(raw) = raw else {
+        return CONFIRM_DEFAULT.iter().map(|c| c.to_string()).collect();
+    };
+    if matches!(
+        raw.trim().to_ascii_lowercase().as_str(),
+        "" | "off" | "none"
+    ) {
+        return Vec::new();
+    }
+    raw.split(',')
+        .map(normalize)
+        .filter(|c| !c.is_empty())
+        .collect()
+}
+
+fn commands() -> &'static [String] {
+    static COMMANDS: OnceLock<Vec<String>> = OnceLock::new();
+    COMMANDS.get_or_init(|| {
+        parse_commands(
+            std::env::var("ALLOY_CONSOLE_CONFIRM_COMMANDS")
+                .ok()
+                .as_deref(),
+        )
+    })
+}
+
+fn guarded_by<'a>(commands: &'a [String], line: &str) -> Option<&'a str> {
+    let line = normalize(line);
+    // `execute ... run <command>` runs whatever follows each `run`, so those count too.
+    let words: Vec<&str> = line.split(' ').collect();
+    let mut candidates = vec![line.clone()];
+    if words.first() == Some(&"execute") {
+        for (i, word) in words.iter().enumerate() {
+            if *word == "run" {
+                candidates.push(strip_namespace(&words[i + 1..].join(" ")).to_string());
+            }
+        }
+    }
+    commands
+        .iter()
+        .find(|c| {
+            candidates.iter().any(|line| {
+                line.strip_prefix(c.as_str())
+                    .is_some_and(|rest| rest.is_empty() || rest.starts_with(' '))
+            })
+        })
+        .map(String::as_str)
+}
+
+// The guarded command `line` starts with, or runs through `execute`, if any.
+pub fn guarded(line: &str) -> Option<&'static str> {
+    guarded_by(commands(), line)
+}
+
+pub fn confirmation_required(command: &str) -> Status {
+    Status::failed_precondition(crate::error_payload::encode_localized(
+        "confirmation_required",
+        Localized::new(
+            MessageKey::new("console.confirmation_required").param("command", command),
+            format!("`{command}` needs confirmation before it is sent to the server"),
+        ),
+        None,
+        Some(Localized::new(
+            MessageKey::new("hint.console.confirm"),
+            "Send it again with confirmed set to run it.",
+        )),
+    ))
+}
+
+// Confirmation on an attached console: a guarded line goes through when the same line was
+// refused on this session within CONFIRM_WINDOW.
+#[derive(Debug, Default)]
+pub struct SessionConfirm {
+    pending: Option<(String, Instant)>,
+}
+
+impl SessionConfirm {
+    pub fn confirm(&mut self, line: &str, now: Instant) -> bool {
+        let line = normalize(line);
+        match self.pending.take() {
+            Some((pending, at)) if pending == line && now.duration_since(at) <= CONFIRM_WINDOW => {
+                true
+            }
+            _ => {
+                self.pending = Some((line, now));
+                false
+            }
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Attribution {
+    Off,
+    Log,
+    Say,
+}
+
+pub fn attribution() -> Attribution {
+    match std::env::var("ALLOY_CONSOLE_ATTRIBUTION")
+        .unwrap_or_default()
+        .trim()
+        .to_ascii_lowercase()
+        .as_str()
+    {
+        "log" => Attribution::Log,
+        "say" => Attribution::Say,
+        _ => Attribution::Off,
+    }
+}
+
+// `say` exists on Minecraft and Terraria servers; elsewhere the line is only logged.
+pub fn say_line(template_id: &str, actor: &str, line: &str) -> Option<String> {
+    if !(template_id.starts_with("minecraft:") || template_id.starts_with("terraria:")) {
+        return None;
+    }
+    let actor: String = actor.chars().filter(|c| !c.is_control()).collect();
+    Some(format!("say [{actor}] {}", line.trim()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn matches_guarded_commands_and_confirms_repeats() {
+        let defaults = parse_commands(None);
+        assert_eq!(guarded_by(&defaults, "/stop"), Some("stop"));
+        assert_eq!(guarded_by(&defaults, "  OP  Notch"), Some("op"));
+        assert_eq!(
+            guarded_by(&defaults, "whitelist   OFF"),
+            Some("whitelist off")
+        );
+        assert_eq!(guarded_by(&defaults, "whitelist on"), None);
+        assert_eq!(guarded_by(&defaults, "stopsound @a"), None);
+        assert_eq!(guarded_by(&defaults, "say stop"), None);
+        assert_eq!(guarded_by(&defaults, "minecraft:stop"), Some("stop"));
+        assert_eq!(guarded_by(&defaults, "/minecraft:op Notch"), Some("op"));
+        assert_eq!(
+            guarded_by(&defaults, "minecraft:whitelist off"),
+            Some("whitelist off")
+        );
+        assert_eq!(guarded_by(&defaults, "execute run op Notch"), Some("op"));
+        assert_eq!(
+            guarded_by(
+                &defaults,
+                "minecraft:execute as @a at @s run minecraft:stop"
+            ),
+            Some("stop")
+        );
+        assert_eq!(
+            guarded_by(
+                &defaults,
+                "execute as @a run execute if entity @s run op @s"
+            ),
+            Some("op")
+        );
+        assert_eq!(guarded_by(&defaults, "execute as @a run say stop"), None);
+        assert_eq!(guarded_by(&defaults, "say minecraft:stop"), None);
+
+        assert!(parse_commands(Some("off")).is_empty());
+        assert_eq!(parse_commands(Some("/ban, kill @e")), ["ban", "kill @e"]);
+
+        let mut session = SessionConfirm::default();
+        let t0 = Instant::now();
+        assert!(!session.confirm("stop", t0));
+        assert!(session.confirm("/stop", t0 + Duration::from_secs(5)));
+        assert!(!session.confirm("stop", t0 + Duration::from_secs(6)));
+        assert!(!session.confirm("stop", t0 + Duration::from_secs(60)));
+        assert!(!session.confirm("op Notch", t0 + Duration::from_secs(61)));
+        assert!(!session.confirm("op jeb_", t0 + Duration::from_secs(62)));
+
+        assert_eq!(
+            say_line("minecraft:paper", "panel:alice", " op bob "),
+            Some("say [panel:alice] op bob".to_string())
+        );
+        assert_eq!(say_line("dst:vanilla", "panel:alice", "c_save()"), None);
+    }
+}
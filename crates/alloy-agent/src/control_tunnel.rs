@@ -1,4 +1,7 @@
-use std::time::Duration;
+use std::{
+    collections::{BTreeMap, HashMap},
+    time::Duration,
+};
 
 use base64::Engine;
 use futures_util::{SinkExt, StreamExt};
@@ -8,26 +11,48 @@ use tokio_tungstenite::tungstenite::{Message as WsMessage, client::IntoClientReq
 use tracing::{Instrument, info_span};
 
 use alloy_proto::agent_v1::{
-    ClearCacheRequest, CreateInstanceRequest, DeleteInstancePreviewRequest, DeleteInstanceRequest,
-    GetCacheStatsRequest, GetCapabilitiesRequest, GetInstanceRequest, GetStatusRequest,
-    GetWarmTemplateProgressRequest, HealthCheckRequest, ImportSaveFromUrlRequest,
-    ListDirRequest, ListInstancesRequest, ListProcessesRequest, ListTemplatesRequest,
-    MkdirRequest, ReadFileRequest, RenameRequest, StartFromTemplateRequest,
-    StartInstanceRequest, StopInstanceRequest, StopProcessRequest, TailFileRequest,
-    TailLogsRequest, UpdateInstanceRequest, WarmTemplateCacheRequest,
+    ApplyPropertyProfilesRequest, BackupInstanceRequest, ClearCacheRequest,
+    CreateFromGoldenRequest, CreateInstanceRequest, DeleteInstancePreviewRequest,
+    DeleteInstanceRequest, DeletePropertyProfileRequest, ExposeWebMapRequest, FrpAdminRequest,
+    GetCacheStatsRequest, GetCapabilitiesRequest, GetConfigSchemaRequest, GetDivergenceRequest,
+    GetEnvReportRequest, GetFrpStatsRequest, GetFrpStatusRequest, GetInstanceRequest,
+    GetLastCrashRequest, GetPlayerInventoryRequest, GetPlayerStatsRequest, GetStatusRequest,
+    GetWarmTemplateProgressRequest, GetWebMapStatusRequest, HashRequest, HealthCheckRequest,
+    HibernateInstanceRequest, ImportSaveFromUrlRequest, InstallWebMapRequest, ListDirRequest,
+    ListInstancesRequest, ListManagedFilesRequest, ListPlayerPositionsRequest,
+    ListProcessesRequest, ListPropertyProfilesRequest, ListTemplatesRequest, ManageFileRequest,
+    MkdirRequest, NodeInfoRequest, PasteFileRequest, PerfAuditRequest, PlacementRequest,
+    ReadFileRequest, ReloadBackupScheduleRequest, RenameRequest, RenderMapPreviewRequest,
+    RestorePlayerDataRequest, SavePropertyProfileRequest, SearchRequest, SelfTestRequest,
+    SendInputRequest, SetGoldenRequest, SetLogLevelRequest, StartFromTemplateRequest,
+    StartInstanceRequest, StatBatchRequest, StopInstanceRequest, StopProcessRequest,
+    SupportBundleRequest, TailFileRequest, TailLogsRequest, UnmanageFileRequest,
+    UpdateInstanceRequest, ValidateStartRequest, VerifyServerJarRequest, WarmTemplateCacheRequest,
     WriteFileRequest, agent_health_service_server::AgentHealthService,
     filesystem_service_server::FilesystemService, instance_service_server::InstanceService,
     logs_service_server::LogsService, process_service_server::ProcessService,
 };
 use tonic::{Request, Status};
 
+use crate::outbox;
 use crate::process_manager::ProcessManager;
+use crate::topics;
 
 #[derive(Debug, Clone, serde::Serialize)]
 #[serde(tag = "type")]
 enum AgentToControlFrame {
     #[serde(rename = "hello")]
-    Hello { node: String, agent_version: String },
+    Hello {
+        node: String,
+        agent_version: String,
+        // Command families and methods this node refuses (see command_policy).
+        disabled_commands: Vec<String>,
+        // See node_identity.
+        node_id: String,
+        labels: BTreeMap<String, String>,
+        #[serde(skip_serializing_if = "Option::is_none")]
+        public_address: Option<String>,
+    },
     #[serde(rename = "resp")]
     Resp {
         id: String,
@@ -35,6 +60,25 @@ enum AgentToControlFrame {
         payload_b64: Option<String>,
         status_code: Option<i32>,
         status_message: Option<String>,
+        // The request id the call ran under; only set on replies to `req` frames.
+        #[serde(skip_serializing_if = "Option::is_none")]
+        request_id: Option<String>,
+    },
+    #[serde(rename = "event")]
+    Event {
+        sub_id: String,
+        topic: String,
+        data: serde_json::Value,
+        dropped: u64,
+    },
+    // Replayed after every reconnect until control acks it.
+    #[serde(rename = "outbox")]
+    Outbox {
+        epoch: String,
+        seq: u64,
+        kind: String,
+        at_unix_ms: u64,
+        data: serde_json::Value,
     },
 }
 
@@ -46,7 +90,23 @@ enum ControlToAgentFrame {
         id: String,
         method: String,
         payload_b64: String,
+        // Control's request id; absent from older control builds.
+        #[serde(default)]
+        request_id: Option<String>,
     },
+    // Acknowledged with a `resp` frame carrying the same id; events then reference it as sub_id.
+    #[serde(rename = "sub")]
+    Sub {
+        id: String,
+        topic: String,
+        #[serde(default)]
+        filter: Option<String>,
+    },
+    #[serde(rename = "unsub")]
+    Unsub { id: String },
+    // Every outbox event up to and including `seq` is stored on the control side.
+    #[serde(rename = "ack")]
+    Ack { epoch: String, seq: u64 },
     #[serde(other)]
     Unknown,
 }
@@ -76,12 +136,55 @@ impl AgentRpc {
     }
 
     async fn dispatch(&self, method: &str, payload: &[u8]) -> Result<Vec<u8>, Status> {
+        crate::command_policy::check(method)?;
         match method {
             "/alloy.agent.v1.AgentHealthService/Check" => {
                 let req: HealthCheckRequest = self.decode_req(payload)?;
                 let resp = self.health.check(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.AgentHealthService/NodeInfo" => {
+                let req: NodeInfoRequest = self.decode_req(payload)?;
+                let resp = self.health.node_info(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.AgentHealthService/Placement" => {
+                let req: PlacementRequest = self.decode_req(payload)?;
+                let resp = self.health.placement(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.AgentHealthService/ReloadBackupSchedule" => {
+                let req: ReloadBackupScheduleRequest = self.decode_req(payload)?;
+                let resp = self
+                    .health
+                    .reload_backup_schedule(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.AgentHealthService/SelfTest" => {
+                let req: SelfTestRequest = self.decode_req(payload)?;
+                let resp = self.health.self_test(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.AgentHealthService/SetLogLevel" => {
+                let req: SetLogLevelRequest = self.decode_req(payload)?;
+                let resp = self
+                    .health
+                    .set_log_level(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.AgentHealthService/SupportBundle" => {
+                let req: SupportBundleRequest = self.decode_req(payload)?;
+                let resp = self
+                    .health
+                    .support_bundle(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
 
             "/alloy.agent.v1.FilesystemService/GetCapabilities" => {
                 let req: GetCapabilitiesRequest = self.decode_req(payload)?;
@@ -97,11 +200,26 @@ impl AgentRpc {
                 let resp = self.fs.list_dir(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.FilesystemService/StatBatch" => {
+                let req: StatBatchRequest = self.decode_req(payload)?;
+                let resp = self.fs.stat_batch(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.FilesystemService/Hash" => {
+                let req: HashRequest = self.decode_req(payload)?;
+                let resp = self.fs.hash(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
             "/alloy.agent.v1.FilesystemService/ReadFile" => {
                 let req: ReadFileRequest = self.decode_req(payload)?;
                 let resp = self.fs.read_file(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.FilesystemService/Search" => {
+                let req: SearchRequest = self.decode_req(payload)?;
+                let resp = self.fs.search(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
             "/alloy.agent.v1.FilesystemService/Mkdir" => {
                 let req: MkdirRequest = self.decode_req(payload)?;
                 let resp = self.fs.mkdir(Request::new(req)).await?.into_inner();
@@ -128,6 +246,11 @@ impl AgentRpc {
                 let resp = self.logs.tail_file(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.LogsService/PasteFile" => {
+                let req: PasteFileRequest = self.decode_req(payload)?;
+                let resp = self.logs.paste_file(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
 
             "/alloy.agent.v1.ProcessService/ListTemplates" => {
                 let req: ListTemplatesRequest = self.decode_req(payload)?;
@@ -188,6 +311,15 @@ impl AgentRpc {
                 let resp = self.process.stop(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.ProcessService/SendInput" => {
+                let req: SendInputRequest = self.decode_req(payload)?;
+                let resp = self
+                    .process
+                    .send_input(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
             "/alloy.agent.v1.ProcessService/ListProcesses" => {
                 let req: ListProcessesRequest = self.decode_req(payload)?;
                 let resp = self
@@ -269,6 +401,276 @@ impl AgentRpc {
                 let resp = self.instance.delete(Request::new(req)).await?.into_inner();
                 Ok(resp.encode_to_vec())
             }
+            "/alloy.agent.v1.InstanceService/Backup" => {
+                let req: BackupInstanceRequest = self.decode_req(payload)?;
+                let resp = self.instance.backup(Request::new(req)).await?.into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/SetGolden" => {
+                let req: SetGoldenRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .set_golden(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/CreateFromGolden" => {
+                let req: CreateFromGoldenRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .create_from_golden(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetDivergence" => {
+                let req: GetDivergenceRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_divergence(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/RenderMapPreview" => {
+                let req: RenderMapPreviewRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .render_map_preview(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/InstallWebMap" => {
+                let req: InstallWebMapRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .install_web_map(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/ExposeWebMap" => {
+                let req: ExposeWebMapRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .expose_web_map(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetWebMapStatus" => {
+                let req: GetWebMapStatusRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_web_map_status(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/ListPlayerPositions" => {
+                let req: ListPlayerPositionsRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .list_player_positions(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetPlayerInventory" => {
+                let req: GetPlayerInventoryRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_player_inventory(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/RestorePlayerData" => {
+                let req: RestorePlayerDataRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .restore_player_data(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetPlayerStats" => {
+                let req: GetPlayerStatsRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_player_stats(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetFrpStatus" => {
+                let req: GetFrpStatusRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_frp_status(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/FrpAdmin" => {
+                let req: FrpAdminRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .frp_admin(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetFrpStats" => {
+                let req: GetFrpStatsRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_frp_stats(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+            "/alloy.agent.v1.InstanceService/GetLastCrash" => {
+                let req: GetLastCrashRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_last_crash(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/ValidateStart" => {
+                let req: ValidateStartRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .validate_start(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/VerifyServerJar" => {
+                let req: VerifyServerJarRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .verify_server_jar(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/ListPropertyProfiles" => {
+                let req: ListPropertyProfilesRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .list_property_profiles(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/SavePropertyProfile" => {
+                let req: SavePropertyProfileRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .save_property_profile(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/DeletePropertyProfile" => {
+                let req: DeletePropertyProfileRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .delete_property_profile(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/ApplyPropertyProfiles" => {
+                let req: ApplyPropertyProfilesRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .apply_property_profiles(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/GetConfigSchema" => {
+                let req: GetConfigSchemaRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_config_schema(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/PerfAudit" => {
+                let req: PerfAuditRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .perf_audit(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/ListManagedFiles" => {
+                let req: ListManagedFilesRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .list_managed_files(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/ManageFile" => {
+                let req: ManageFileRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .manage_file(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/UnmanageFile" => {
+                let req: UnmanageFileRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .unmanage_file(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/Hibernate" => {
+                let req: HibernateInstanceRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .hibernate(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
+
+            "/alloy.agent.v1.InstanceService/GetEnvReport" => {
+                let req: GetEnvReportRequest = self.decode_req(payload)?;
+                let resp = self
+                    .instance
+                    .get_env_report(Request::new(req))
+                    .await?
+                    .into_inner();
+                Ok(resp.encode_to_vec())
+            }
 
             _ => Err(Status::unimplemented(format!("unknown method: {method}"))),
         }
@@ -291,20 +693,6 @@ fn parse_ws_url(raw: &str) -> Option<String> {
     Some(trimmed.to_string())
 }
 
-fn node_name() -> String {
-    std::env::var("ALLOY_NODE_NAME")
-        .ok()
-        .map(|v| v.trim().to_string())
-        .filter(|v| !v.is_empty())
-        .or_else(|| {
-            std::env::var("HOSTNAME")
-                .ok()
-                .map(|v| v.trim().to_string())
-                .filter(|v| !v.is_empty())
-        })
-        .unwrap_or_else(|| "default".to_string())
-}
-
 fn node_token() -> Option<String> {
     std::env::var("ALLOY_NODE_TOKEN")
         .ok()
@@ -320,9 +708,10 @@ pub fn spawn(manager: ProcessManager) {
         return;
     };
 
-    let node = node_name();
+    let node = crate::node_identity::get().name.clone();
     let token = node_token();
     let rpc = AgentRpc::new(manager);
+    outbox::enable(crate::minecraft::data_root().join("control-outbox.json"));
 
     tokio::spawn(async move {
         let span = info_span!("control_tunnel", node = %node, url = %url);
@@ -348,6 +737,64 @@ pub fn spawn(manager: ProcessManager) {
     });
 }
 
+// Relays one subscription to control. Waiting on the shared writer queue is what applies
+// backpressure: while control reads slowly, the subscription queue fills up and the broker drops
+// (and counts) events for this subscriber only.
+fn spawn_forwarder(
+    sub_id: String,
+    mut sub: topics::Subscription,
+    out_tx: mpsc::Sender<WsMessage>,
+) -> tokio::task::JoinHandle<()> {
+    tokio::spawn(async move {
+        while let Some(ev) = sub.recv().await {
+            let frame = AgentToControlFrame::Event {
+                sub_id: sub_id.clone(),
+                topic: ev.topic,
+                data: ev.data,
+                dropped: ev.dropped,
+            };
+            let Ok(text) = serde_json::to_string(&frame) else {
+                continue;
+            };
+            if out_tx.send(WsMessage::Text(text.into())).await.is_err() {
+                break;
+            }
+        }
+    })
+}
+
+// Sends outbox events in sequence order, starting over from the oldest unacked one on each
+// connection so nothing queued while control was unreachable is lost.
+fn spawn_outbox_sender(out_tx: mpsc::Sender<WsMessage>) -> Option<tokio::task::JoinHandle<()>> {
+    let mut latest = outbox::watch()?;
+    let epoch = outbox::epoch()?;
+    Some(tokio::spawn(async move {
+        let mut sent = 0;
+        loop {
+            latest.borrow_and_update();
+            for ev in outbox::pending_after(sent) {
+                sent = ev.seq;
+                let frame = AgentToControlFrame::Outbox {
+                    epoch: epoch.clone(),
+                    seq: ev.seq,
+                    kind: ev.kind,
+                    at_unix_ms: ev.at_unix_ms,
+                    data: ev.data,
+                };
+                let Ok(text) = serde_json::to_string(&frame) else {
+                    continue;
+                };
+                if out_tx.send(WsMessage::Text(text.into())).await.is_err() {
+                    return;
+                }
+            }
+            if latest.changed().await.is_err() {
+                return;
+            }
+        }
+    }))
+}
+
 async fn run_once(
     url: &str,
     node: &str,
@@ -363,9 +810,14 @@ async fn run_once(
     let (ws, _) = tokio_tungstenite::connect_async(req).await?;
     let (mut sink, mut stream) = ws.split();
 
+    let identity = crate::node_identity::get();
     let hello = AgentToControlFrame::Hello {
         node: node.to_string(),
         agent_version: env!("CARGO_PKG_VERSION").to_string(),
+        disabled_commands: crate::command_policy::disabled(),
+        node_id: identity.id.clone(),
+        labels: identity.labels.clone(),
+        public_address: identity.public_address.clone(),
     };
     sink.send(WsMessage::Text(serde_json::to_string(&hello)?.into()))
         .await?;
@@ -383,6 +835,10 @@ async fn run_once(
         }
     });
 
+    // Forwarding tasks per subscription id. Aborting a task drops its subscription.
+    let mut subscriptions: HashMap<String, tokio::task::JoinHandle<()>> = HashMap::new();
+    let outbox_sender = spawn_outbox_sender(out_tx.clone());
+
     while let Some(msg) = stream.next().await {
         let msg = msg?;
         match msg {
@@ -394,7 +850,12 @@ async fn run_once(
                         id,
                         method,
                         payload_b64,
+                        request_id,
                     } => {
+                        let request_id = request_id
+                            .as_deref()
+                            .and_then(crate::trace::sanitize)
+                            .unwrap_or_else(crate::trace::new_id);
                         let payload = match b64.decode(payload_b64.as_bytes()) {
                             Ok(v) => v,
                             Err(_) => {
@@ -406,6 +867,7 @@ async fn run_once(
                                         Status::invalid_argument("invalid base64").code() as i32,
                                     ),
                                     status_message: Some("invalid base64 payload".to_string()),
+                                    request_id: Some(request_id),
                                 };
                                 let _ = out_tx
                                     .send(WsMessage::Text(serde_json::to_string(&resp)?.into()))
@@ -416,10 +878,20 @@ async fn run_once(
 
                         let rpc = rpc.clone();
                         let out_tx = out_tx.clone();
-                        let span = info_span!("control_tunnel_req", id = %id, method = %method);
+                        let span = info_span!(
+                            "control_tunnel_req",
+                            id = %id,
+                            method = %method,
+                            request_id = %request_id
+                        );
                         tokio::spawn(
                             async move {
-                                let out = match rpc.dispatch(&method, &payload).await {
+                                let res = crate::trace::scope(
+                                    request_id.clone(),
+                                    rpc.dispatch(&method, &payload),
+                                )
+                                .await;
+                                let out = match res {
                                     Ok(bytes) => AgentToControlFrame::Resp {
                                         id,
                                         ok: true,
@@ -428,6 +900,7 @@ async fn run_once(
                                         ),
                                         status_code: None,
                                         status_message: None,
+                                        request_id: Some(request_id),
                                     },
                                     Err(status) => AgentToControlFrame::Resp {
                                         id,
@@ -435,6 +908,7 @@ async fn run_once(
                                         payload_b64: None,
                                         status_code: Some(status.code() as i32),
                                         status_message: Some(status.message().to_string()),
+                                        request_id: Some(request_id),
                                     },
                                 };
 
@@ -450,6 +924,35 @@ async fn run_once(
                             .instrument(span),
                         );
                     }
+                    ControlToAgentFrame::Sub { id, topic, filter } => {
+                        let result = match topics::subscribe(&topic, filter.as_deref()) {
+                            Ok(sub) => {
+                                let task = spawn_forwarder(id.clone(), sub, out_tx.clone());
+                                if let Some(old) = subscriptions.insert(id.clone(), task) {
+                                    old.abort();
+                                }
+                                Ok(())
+                            }
+                            Err(msg) => Err(Status::invalid_argument(msg)),
+                        };
+                        let resp = AgentToControlFrame::Resp {
+                            id,
+                            ok: result.is_ok(),
+                            payload_b64: None,
+                            status_code: result.as_ref().err().map(|s| s.code() as i32),
+                            status_message: result.err().map(|s| s.message().to_string()),
+                            request_id: None,
+                        };
+                        let _ = out_tx
+                            .send(WsMessage::Text(serde_json::to_string(&resp)?.into()))
+                            .await;
+                    }
+                    ControlToAgentFrame::Unsub { id } => {
+                        if let Some(task) = subscriptions.remove(&id) {
+                            task.abort();
+                        }
+                    }
+                    ControlToAgentFrame::Ack { epoch, seq } => outbox::ack(&epoch, seq),
                     ControlToAgentFrame::Unknown => {}
                 }
             }
@@ -462,6 +965,12 @@ async fn run_once(
         }
     }
 
+    for task in subscriptions.into_values() {
+        task.abort();
+    }
+    if let Some(task) = outbox_sender {
+        task.abort();
+    }
     drop(out_tx);
     writer.abort();
 
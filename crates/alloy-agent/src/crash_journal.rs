@@ -0,0 +1,103 @@
+use std::path::{Path, PathBuf};
+
+use anyhow::Context;
+use serde::{Deserialize, Serialize};
+
+use crate::process_manager_support::env_usize;
+
+// Number of final console lines kept per crash record.
+pub(crate) const JOURNAL_LINES: usize = 500;
+
+const DEFAULT_KEEP: usize = 10;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub(crate) struct CrashRecord {
+    pub(crate) process_id: String,
+    pub(crate) template_id: String,
+    pub(crate) exited_at_unix_ms: u64,
+    pub(crate) runtime_ms: u64,
+    pub(crate) exit_code: Option<i32>,
+    pub(crate) signal: Option<i32>,
+    pub(crate) exit_category: Option<String>,
+    pub(crate) exit_reason: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub(crate) hs_err_path: Option<String>,
+    // Request id of the start that launched this run.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub(crate) request_id: Option<String>,
+    pub(crate) lines: Vec<String>,
+}
+
+fn journal_keep() -> usize {
+    env_usize("ALLOY_CRASH_JOURNAL_KEEP")
+        .map(|v| v.clamp(1, 100))
+        .unwrap_or(DEFAULT_KEEP)
+}
+
+fn is_record_name(name: &str) -> bool {
+    name.starts_with("crash-") && name.ends_with(".json")
+}
+
+// Record names embed a zero-padded timestamp, so lexical order is chronological.
+async fn list_records(dir: &Path) -> Vec<PathBuf> {
+    let mut out = Vec::new();
+    let Ok(mut rd) = tokio::fs::read_dir(dir).await else {
+        return out;
+    };
+    while let Ok(Some(de)) = rd.next_entry().await {
+        if is_record_name(&de.file_name().to_string_lossy()) {
+            out.push(de.path());
+        }
+    }
+    out.sort();
+    out
+}
+
+pub(crate) async fn write(dir: &Path, record: &CrashRecord) -> anyhow::Result<PathBuf> {
+    tokio::fs::create_dir_all(dir)
+        .await
+        .with_context(|| format!("create {}", dir.display()))?;
+
+    let name = format!("crash-{:016}.json", record.exited_at_unix_ms);
+    let path = dir.join(&name);
+    let tmp = dir.join(format!("{name}.tmp"));
+    let data = serde_json::to_vec_pretty(record).context("serialize crash record")?;
+    tokio::fs::write(&tmp, &data)
+        .await
+        .with_context(|| format!("write {}", tmp.display()))?;
+    tokio::fs::rename(&tmp, &path)
+        .await
+        .with_context(|| format!("persist {}", path.display()))?;
+
+    let records = list_records(dir).await;
+    let excess = records.len().saturating_sub(journal_keep());
+    for old in records.into_iter().take(excess) {
+        let _ = tokio::fs::remove_file(old).await;
+    }
+
+    Ok(path)
+}
+
+// Returns the newest record across the given journal directories.
+pub(crate) async fn read_last(dirs: &[PathBuf]) -> anyhow::Result<Option<(PathBuf, CrashRecord)>> {
+    let mut newest: Option<PathBuf> = None;
+    for dir in dirs {
+        if let Some(p) = list_records(dir).await.pop()
+            && newest
+                .as_ref()
+                .is_none_or(|n| p.file_name() > n.file_name())
+        {
+            newest = Some(p);
+        }
+    }
+    let Some(path) = newest else {
+        return Ok(None);
+    };
+
+    let raw = tokio::fs::read(&path)
+        .await
+        .with_context(|| format!("read {}", path.display()))?;
+    let record = serde_json::from_slice::<CrashRecord>(&raw)
+        .with_context(|| format!("parse {}", path.display()))?;
+    Ok(Some((path, record)))
+}
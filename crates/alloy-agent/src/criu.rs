@@ -0,0 +1,334 @@
+use std::{
+    os::fd::{FromRawFd, OwnedFd},
+    path::{Path, PathBuf},
+    sync::OnceLock,
+    time::Duration,
+};
+
+use anyhow::Context;
+
+// Experimental hibernation: an idle Minecraft server is checkpointed with CRIU into
+// <instance>/.checkpoint and restored from there on its next start, which takes seconds instead of
+// a full boot. Off unless ALLOY_CRIU_ENABLED=true, and only for natively launched servers (CRIU
+// can't follow a process into bwrap or Docker). When it can't be used, callers fall back to a
+// normal stop or start.
+//
+// The server's stdin/stdout/stderr are pipes to the agent. They are recorded at dump time and
+// replaced with fresh pipes on restore (`--inherit-fd`), so the console keeps working. Player
+// connections are closed by the checkpoint.
+
+const DIR: &str = ".checkpoint";
+const META: &str = "checkpoint.json";
+const PIDFILE: &str = "restored.pid";
+const RESTORE_TIMEOUT: Duration = Duration::from_secs(120);
+
+pub fn enabled() -> bool {
+    matches!(
+        std::env::var("ALLOY_CRIU_ENABLED")
+            .unwrap_or_default()
+            .trim()
+            .to_ascii_lowercase()
+            .as_str(),
+        "1" | "true" | "yes" | "on"
+    )
+}
+
+fn probe() -> Result<(), String> {
+    if !cfg!(target_os = "linux") {
+        return Err("CRIU is only available on Linux".to_string());
+    }
+    if unsafe { libc::geteuid() } != 0 {
+        return Err("CRIU needs the agent to run as root".to_string());
+    }
+    let out = match std::process::Command::new("criu").arg("check").output() {
+        Ok(v) => v,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+            return Err("criu is not installed".to_string());
+        }
+        Err(e) => return Err(format!("failed to run criu: {e}")),
+    };
+    if !out.status.success() {
+        let stderr = String::from_utf8_lossy(&out.stderr);
+        let last = stderr.lines().rev().find(|l| !l.trim().is_empty());
+        return Err(format!(
+            "criu check failed: {}",
+            last.unwrap_or("no output").trim()
+        ));
+    }
+    Ok(())
+}
+
+// Why checkpoints can't be used on this host, or None when they can. `criu check` runs once.
+pub fn unsupported_reason() -> Option<String> {
+    if !enabled() {
+        return Some("checkpoints are disabled (set ALLOY_CRIU_ENABLED=true)".to_string());
+    }
+    static PROBE: OnceLock<Result<(), String>> = OnceLock::new();
+    PROBE.get_or_init(probe).clone().err()
+}
+
+#[derive(Debug, Clone, serde::Serialize, serde::Deserialize)]
+pub struct Meta {
+    pub created_at_unix_ms: u64,
+    pub template_id: String,
+    pub pid: u32,
+    pub port: u16,
+    // Pipe ids (`pipe:[inode]`) of the server's fds 0-2 when it was dumped.
+    pub stdio: Vec<String>,
+    pub size_bytes: u64,
+}
+
+fn images_dir(instance_dir: &Path) -> PathBuf {
+    instance_dir.join(DIR)
+}
+
+pub fn read_meta(instance_dir: &Path) -> Option<Meta> {
+    let raw = std::fs::read(images_dir(instance_dir).join(META)).ok()?;
+    serde_json::from_slice(&raw).ok()
+}
+
+pub fn discard(instance_dir: &Path) {
+    let _ = std::fs::remove_dir_all(images_dir(instance_dir));
+}
+
+fn dir_size(dir: &Path) -> u64 {
+    std::fs::read_dir(dir)
+        .map(|rd| {
+            rd.flatten()
+                .filter_map(|de| de.metadata().ok())
+                .filter(|m| m.is_file())
+                .map(|m| m.len())
+                .sum()
+        })
+        .unwrap_or(0)
+}
+
+// The last error lines of a CRIU log, for messages.
+fn log_tail(path: &Path) -> String {
+    let raw = std::fs::read_to_string(path).unwrap_or_default();
+    let lines: Vec<&str> = raw
+        .lines()
+        .filter(|l| l.contains("Error") || l.contains("error"))
+        .collect();
+    let tail = &lines[lines.len().saturating_sub(3)..];
+    if tail.is_empty() {
+        format!("see {}", path.display())
+    } else {
+        tail.join("; ")
+    }
+}
+
+fn stdio_pipes(pid: u32) -> anyhow::Result<Vec<String>> {
+    (0..3)
+        .map(|fd| {
+            let link = std::fs::read_link(format!("/proc/{pid}/fd/{fd}"))
+                .with_context(|| format!("read fd {fd} of pid {pid}"))?;
+            let link = link.to_string_lossy().to_string();
+            anyhow::ensure!(
+                link.starts_with("pipe:["),
+                "fd {fd} is {link}, not a pipe to the agent"
+            );
+            Ok(link)
+        })
+        .collect()
+}
+
+// Checkpoints the process tree rooted at `pid`; CRIU kills it once the images are written. On
+// failure the process keeps running.
+pub fn dump(instance_dir: &Path, pid: u32, template_id: &str, port: u16) -> anyhow::Result<Meta> {
+    let dir = images_dir(instance_dir);
+    discard(instance_dir);
+    std::fs::create_dir_all(&dir).context("create checkpoint dir")?;
+    let stdio = stdio_pipes(pid)?;
+
+    let out = std::process::Command::new("criu")
+        .arg("dump")
+        .args(["--tree", &pid.to_string()])
+        .arg("--images-dir")
+        .arg(&dir)
+        .args(["--shell-job", "--tcp-close", "--file-locks"])
+        .args(["--log-file", "dump.log", "-v2"])
+        .output()
+        .context("run criu dump")?;
+    if !out.status.success() {
+        let reason = log_tail(&dir.join("dump.log"));
+        discard(instance_dir);
+        anyhow::bail!("criu dump failed: {reason}");
+    }
+
+    let meta = Meta {
+        created_at_unix_ms: std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .unwrap_or_default()
+            .as_millis() as u64,
+        template_id: template_id.to_string(),
+        pid,
+        port,
+        stdio,
+        size_bytes: dir_size(&dir),
+    };
+    std::fs::write(dir.join(META), serde_json::to_vec_pretty(&meta)?)
+        .context("write checkpoint.json")?;
+    Ok(meta)
+}
+
+pub struct Restored {
+    // `criu restore` stays the parent of the restored server and exits after it.
+    pub child: tokio::process::Child,
+    pub pid: u32,
+    pub pgid: i32,
+    pub stdin: tokio::process::ChildStdin,
+    pub stdout: tokio::process::ChildStdout,
+    pub stderr: tokio::process::ChildStderr,
+}
+
+fn pipe() -> std::io::Result<(OwnedFd, OwnedFd)> {
+    let mut fds = [0; 2];
+    if unsafe { libc::pipe2(fds.as_mut_ptr(), libc::O_CLOEXEC) } == -1 {
+        return Err(std::io::Error::last_os_error());
+    }
+    Ok(unsafe { (OwnedFd::from_raw_fd(fds[0]), OwnedFd::from_raw_fd(fds[1])) })
+}
+
+// Restores the checkpoint in `instance_dir`. The images are removed once the server runs again;
+// a failed restore leaves them for the caller to discard.
+pub async fn restore(instance_dir: &Path) -> anyhow::Result<Restored> {
+    use std::os::fd::AsRawFd;
+
+    let dir = images_dir(instance_dir);
+    let meta = read_meta(instance_dir).context("no checkpoint")?;
+    anyhow::ensure!(meta.stdio.len() == 3, "checkpoint.json has no stdio pipes");
+    let _ = std::fs::remove_file(dir.join(PIDFILE));
+
+    let (stdin_r, stdin_w) = pipe()?;
+    let (stdout_r, stdout_w) = pipe()?;
+    let (stderr_r, stderr_w) = pipe()?;
+    let child_fds = [
+        stdin_r.as_raw_fd(),
+        stdout_w.as_raw_fd(),
+        stderr_w.as_raw_fd(),
+    ];
+
+    let mut cmd = tokio::process::Command::new("criu");
+    cmd.arg("restore")
+        .arg("--images-dir")
+        .arg(&dir)
+        .args(["--shell-job", "--tcp-close", "--file-locks"])
+        .args(["--pidfile", PIDFILE, "--log-file", "restore.log", "-v2"])
+        .stdin(std::process::Stdio::null())
+        .stdout(std::process::Stdio::null())
+        .stderr(std::process::Stdio::null())
+        .kill_on_drop(true);
+    for (i, pipe) in meta.stdio.iter().enumerate() {
+        cmd.arg("--inherit-fd").arg(format!("fd[{}]:{pipe}", 3 + i));
+    }
+    unsafe {
+        cmd.pre_exec(move || {
+            // Its own session and process group, which the restored server joins; stopping the
+            // instance signals that group.
+            if libc::setsid() == -1 {
+                return Err(std::io::Error::last_os_error());
+            }
+            #[cfg(target_os = "linux")]
+            if libc::prctl(libc::PR_SET_PDEATHSIG, libc::SIGTERM) == -1 {
+                return Err(std::io::Error::last_os_error());
+            }
+            // Move the pipe ends above 3..=5 first so the dup2 calls can't clobber each other.
+            let mut high = [0; 3];
+            for (i, fd) in child_fds.iter().enumerate() {
+                high[i] = libc::fcntl(*fd, libc::F_DUPFD, 10);
+                if high[i] == -1 {
+                    return Err(std::io::Error::last_os_error());
+                }
+            }
+            for (i, fd) in high.iter().enumerate() {
+                if libc::dup2(*fd, 3 + i as i32) == -1 {
+                    return Err(std::io::Error::last_os_error());
+                }
+            }
+            Ok(())
+        });
+    }
+    let mut child = cmd.spawn().context("run criu restore")?;
+    drop((stdin_r, stdout_w, stderr_w));
+    let pgid = child.id().context("criu restore exited immediately")? as i32;
+
+    let deadline = tokio::time::Instant::now() + RESTORE_TIMEOUT;
+    let pid = loop {
+        if let Some(status) = child.try_wait()? {
+            anyhow::bail!(
+                "criu restore failed ({status}): {}",
+                log_tail(&dir.join("restore.log"))
+            );
+        }
+        if let Ok(raw) = tokio::fs::read_to_string(dir.join(PIDFILE)).await
+            && let Ok(pid) = raw.trim().parse::<u32>()
+        {
+            break pid;
+        }
+        if tokio::time::Instant::now() >= deadline {
+            let _ = child.start_kill();
+            anyhow::bail!("criu restore did not finish within {RESTORE_TIMEOUT:?}");
+        }
+        tokio::time::sleep(Duration::from_millis(100)).await;
+    };
+
+    // The restored server holds its files open; the images are no longer needed.
+    let _ = tokio::fs::remove_dir_all(&dir).await;
+
+    Ok(Restored {
+        child,
+        pid,
+        pgid,
+        stdin: tokio::process::ChildStdin::from_std(std::process::ChildStdin::from(stdin_w))?,
+        stdout: tokio::process::ChildStdout::from_std(std::process::ChildStdout::from(stdout_r))?,
+        stderr: tokio::process::ChildStderr::from_std(std::process::ChildStderr::from(stderr_r))?,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn reads_meta_and_summarizes_logs() {
+        let dir = std::env::temp_dir().join(format!("alloy-criu-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        assert!(read_meta(&dir).is_none());
+
+        std::fs::create_dir_all(images_dir(&dir)).unwrap();
+        let meta = Meta {
+            created_at_unix_ms: 1,
+            template_id: "minecraft:vanilla".to_string(),
+            pid: 42,
+            port: 25565,
+            stdio: vec![
+                "pipe:[1]".to_string(),
+                "pipe:[2]".to_string(),
+                "pipe:[3]".to_string(),
+            ],
+            size_bytes: 0,
+        };
+        std::fs::write(
+            images_dir(&dir).join(META),
+            serde_json::to_vec(&meta).unwrap(),
+        )
+        .unwrap();
+        assert_eq!(read_meta(&dir).unwrap().stdio, meta.stdio);
+
+        let log = images_dir(&dir).join("dump.log");
+        std::fs::write(
+            &log,
+            "(00.1) Dumping\n(00.2) Error (criu/tty.c:1): tty: Unsupported\n(00.3) Error: Dumping FAILED.\n",
+        )
+        .unwrap();
+        assert_eq!(
+            log_tail(&log),
+            "(00.2) Error (criu/tty.c:1): tty: Unsupported; (00.3) Error: Dumping FAILED."
+        );
+
+        discard(&dir);
+        assert!(read_meta(&dir).is_none());
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,149 @@
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+    sync::{
+        Mutex, OnceLock,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::{Duration, Instant, SystemTime},
+};
+
+use alloy_proto::agent_v1::DirEntry;
+
+use crate::process_manager_support::{env_u64, env_usize};
+
+const DEFAULT_MAX_DIRS: usize = 256;
+const DEFAULT_TTL_MS: u64 = 5000;
+
+// Directory listings keyed by path and the directory's mtime, so the panel's auto-refresh of an
+// unchanged directory doesn't read it from disk every time.
+//
+// A directory's mtime only changes when entries are added, removed or renamed, not when a file in
+// it is rewritten. Listings are therefore also dropped after ALLOY_DIR_CACHE_TTL_MS, so sizes and
+// file mtimes are at most that stale.
+struct Cached {
+    dir_mtime: SystemTime,
+    stored_at: Instant,
+    entries: Vec<DirEntry>,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub(crate) struct DirCacheStats {
+    pub(crate) hits: u64,
+    pub(crate) misses: u64,
+    pub(crate) bypassed: u64,
+    pub(crate) dirs: u64,
+}
+
+static HITS: AtomicU64 = AtomicU64::new(0);
+static MISSES: AtomicU64 = AtomicU64::new(0);
+static BYPASSED: AtomicU64 = AtomicU64::new(0);
+
+fn cache() -> &'static Mutex<HashMap<PathBuf, Cached>> {
+    static CACHE: OnceLock<Mutex<HashMap<PathBuf, Cached>>> = OnceLock::new();
+    CACHE.get_or_init(|| Mutex::new(HashMap::new()))
+}
+
+// 0 disables the cache.
+fn max_dirs() -> usize {
+    env_usize("ALLOY_DIR_CACHE_MAX_DIRS")
+        .map(|v| v.min(100_000))
+        .unwrap_or(DEFAULT_MAX_DIRS)
+}
+
+fn ttl() -> Duration {
+    Duration::from_millis(
+        env_u64("ALLOY_DIR_CACHE_TTL_MS")
+            .map(|v| v.min(10 * 60 * 1000))
+            .unwrap_or(DEFAULT_TTL_MS),
+    )
+}
+
+pub(crate) fn note_bypass() {
+    BYPASSED.fetch_add(1, Ordering::Relaxed);
+}
+
+pub(crate) fn get(dir: &Path, dir_mtime: SystemTime) -> Option<Vec<DirEntry>> {
+    if max_dirs() == 0 {
+        return None;
+    }
+    let mut cache = cache().lock().unwrap_or_else(|e| e.into_inner());
+    let fresh = cache
+        .get(dir)
+        .is_some_and(|c| c.dir_mtime == dir_mtime && c.stored_at.elapsed() < ttl());
+    if !fresh {
+        cache.remove(dir);
+        MISSES.fetch_add(1, Ordering::Relaxed);
+        return None;
+    }
+    HITS.fetch_add(1, Ordering::Relaxed);
+    cache.get(dir).map(|c| c.entries.clone())
+}
+
+pub(crate) fn put(dir: PathBuf, dir_mtime: SystemTime, entries: Vec<DirEntry>) {
+    let max = max_dirs();
+    if max == 0 {
+        return;
+    }
+    let mut cache = cache().lock().unwrap_or_else(|e| e.into_inner());
+    if cache.len() >= max && !cache.contains_key(&dir) {
+        // Expired listings go first; if none are, the oldest one makes room.
+        let ttl = ttl();
+        cache.retain(|_, c| c.stored_at.elapsed() < ttl);
+        if cache.len() >= max
+            && let Some(oldest) = cache
+                .iter()
+                .min_by_key(|(_, c)| c.stored_at)
+                .map(|(k, _)| k.clone())
+        {
+            cache.remove(&oldest);
+        }
+    }
+    cache.insert(
+        dir,
+        Cached {
+            dir_mtime,
+            stored_at: Instant::now(),
+            entries,
+        },
+    );
+}
+
+// Called after writes through the filesystem API, which may not move a directory's mtime (e.g.
+// rewriting a file in place).
+pub(crate) fn clear() {
+    cache().lock().unwrap_or_else(|e| e.into_inner()).clear();
+}
+
+pub(crate) fn stats() -> DirCacheStats {
+    let dirs = cache().lock().unwrap_or_else(|e| e.into_inner()).len() as u64;
+    DirCacheStats {
+        hits: HITS.load(Ordering::Relaxed),
+        misses: MISSES.load(Ordering::Relaxed),
+        bypassed: BYPASSED.load(Ordering::Relaxed),
+        dirs,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn listing_is_reused_only_while_the_directory_mtime_matches() {
+        let dir = PathBuf::from("/alloy-dir-cache-test");
+        let t0 = SystemTime::UNIX_EPOCH + Duration::from_secs(100);
+        let t1 = t0 + Duration::from_secs(1);
+        let entries = vec![DirEntry {
+            name: "server.properties".to_string(),
+            ..Default::default()
+        }];
+
+        assert!(get(&dir, t0).is_none());
+        put(dir.clone(), t0, entries.clone());
+        assert_eq!(get(&dir, t0), Some(entries));
+        assert!(get(&dir, t1).is_none());
+        // The stale listing was dropped on the mismatch.
+        assert!(get(&dir, t0).is_none());
+    }
+}
@@ -140,6 +140,7 @@ fn http_client() -> &'static reqwest::Client {
     })
 }
 
+#[tracing::instrument(name = "http.download", skip(path), fields(url = %url))]
 async fn download_to_path(url: &str, path: &Path) -> anyhow::Result<()> {
     if let Some(parent) = path.parent() {
         tokio::fs::create_dir_all(parent).await?;
@@ -6,14 +6,101 @@ const MAX_MESSAGE_BYTES: usize = 32 * 1024;
 const MAX_HINT_BYTES: usize = 8 * 1024;
 const MAX_FIELD_ERROR_BYTES: usize = 4 * 1024;
 
+// Stable identifier for a message plus its parameters, so the panel can show daemon messages in
+// the user's language. The English text next to it stays the fallback.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct MessageKey {
+    pub key: String,
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub params: BTreeMap<String, String>,
+}
+
+impl MessageKey {
+    pub fn new(key: &str) -> Self {
+        Self {
+            key: key.to_string(),
+            params: BTreeMap::new(),
+        }
+    }
+
+    pub fn param(mut self, name: &str, value: impl ToString) -> Self {
+        self.params.insert(name.to_string(), value.to_string());
+        self
+    }
+}
+
+// English text together with its key.
+#[derive(Debug, Clone)]
+pub struct Localized {
+    pub text: String,
+    pub key: MessageKey,
+}
+
+impl Localized {
+    pub fn new(key: MessageKey, text: impl Into<String>) -> Self {
+        Self {
+            text: text.into(),
+            key,
+        }
+    }
+}
+
 #[derive(Debug, Clone, serde::Serialize)]
 pub struct ErrorPayload {
     pub code: String,
     pub message: String,
     #[serde(skip_serializing_if = "Option::is_none")]
+    pub message_key: Option<MessageKey>,
+    #[serde(skip_serializing_if = "Option::is_none")]
     pub field_errors: Option<BTreeMap<String, String>>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub hint: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub hint_key: Option<MessageKey>,
+}
+
+// Hints that are shared by many errors and take no parameters. Anything passed to encode() with
+// one of these texts gets its key without the call site having to spell it out.
+const KNOWN_HINTS: &[(&str, &str)] = &[
+    (
+        "Free up disk space under ALLOY_DATA_ROOT and try again.",
+        "hint.disk.free_space",
+    ),
+    (
+        "Fix the highlighted fields, then try again.",
+        "hint.fix_fields",
+    ),
+    (
+        "Try again; if it persists, clear cache and retry.",
+        "hint.download.retry_or_clear_cache",
+    ),
+    (
+        "Clear cache and retry extraction.",
+        "hint.download.clear_cache",
+    ),
+    (
+        "Check network connectivity, then try again.",
+        "hint.network.check",
+    ),
+    (
+        "Check network connectivity, or try again after clearing cache.",
+        "hint.network.check_or_clear_cache",
+    ),
+    (
+        "Pick another port, or leave it blank (0) to auto-assign a free port.",
+        "hint.port.pick_another",
+    ),
+    (
+        "Use different ports or set conflicting ones to 0 (auto).",
+        "hint.port.distinct",
+    ),
+];
+
+fn known_hint_key(hint: &str) -> Option<MessageKey> {
+    KNOWN_HINTS
+        .iter()
+        .find(|(text, _)| *text == hint)
+        .map(|(_, key)| MessageKey::new(key))
 }
 
 fn truncate_utf8(s: &str, max_bytes: usize) -> String {
@@ -38,7 +125,39 @@ pub fn encode(
     field_errors: Option<BTreeMap<String, String>>,
     hint: Option<String>,
 ) -> String {
-    let message = truncate_utf8(&message.into(), MAX_MESSAGE_BYTES);
+    let hint_key = hint.as_deref().and_then(known_hint_key);
+    encode_payload(code, message.into(), None, field_errors, hint, hint_key)
+}
+
+pub fn encode_localized(
+    code: &str,
+    message: Localized,
+    field_errors: Option<BTreeMap<String, String>>,
+    hint: Option<Localized>,
+) -> String {
+    let (hint, hint_key) = match hint {
+        Some(h) => (Some(h.text), Some(h.key)),
+        None => (None, None),
+    };
+    encode_payload(
+        code,
+        message.text,
+        Some(message.key),
+        field_errors,
+        hint,
+        hint_key,
+    )
+}
+
+fn encode_payload(
+    code: &str,
+    message: String,
+    message_key: Option<MessageKey>,
+    field_errors: Option<BTreeMap<String, String>>,
+    hint: Option<String>,
+    hint_key: Option<MessageKey>,
+) -> String {
+    let message = truncate_utf8(&message, MAX_MESSAGE_BYTES);
 
     let field_errors = field_errors.map(|mut m| {
         for v in m.values_mut() {
@@ -52,8 +171,10 @@ pub fn encode(
     let payload = ErrorPayload {
         code: code.to_string(),
         message,
+        message_key,
         field_errors,
         hint,
+        hint_key,
     };
 
     let json = serde_json::to_string(&payload)
@@ -71,6 +192,15 @@ pub fn anyhow(
     anyhow::anyhow!(encode(code, message, field_errors, hint))
 }
 
+pub fn anyhow_localized(
+    code: &str,
+    message: Localized,
+    field_errors: Option<BTreeMap<String, String>>,
+    hint: Option<Localized>,
+) -> anyhow::Error {
+    anyhow::anyhow!(encode_localized(code, message, field_errors, hint))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -111,4 +241,35 @@ mod tests {
         assert!(hint.len() <= MAX_HINT_BYTES);
         assert!(hint.ends_with("…(truncated)"));
     }
+
+    #[test]
+    fn encode_includes_message_and_hint_keys() {
+        let s = encode_localized(
+            "port_conflict",
+            Localized::new(
+                MessageKey::new("port.conflict")
+                    .param("port", 25565)
+                    .param("instance", "a"),
+                "port 25565/tcp is reserved by instance a",
+            ),
+            None,
+            None,
+        );
+        let v: serde_json::Value = serde_json::from_str(&s[PREFIX.len()..]).unwrap();
+        assert_eq!(v["message_key"]["key"], "port.conflict");
+        assert_eq!(v["message_key"]["params"]["port"], "25565");
+        assert!(v.get("hint_key").is_none());
+
+        // Shared hints get their key even through the plain encode().
+        let s = encode(
+            "download_failed",
+            "failed",
+            None,
+            Some("Check network connectivity, then try again.".to_string()),
+        );
+        let v: serde_json::Value = serde_json::from_str(&s[PREFIX.len()..]).unwrap();
+        assert!(v.get("message_key").is_none());
+        assert_eq!(v["hint_key"]["key"], "hint.network.check");
+        assert!(v["hint_key"].get("params").is_none());
+    }
 }
@@ -5,18 +5,20 @@ use alloy_proto::agent_v1::filesystem_service_server::{
     FilesystemService, FilesystemServiceServer,
 };
 use alloy_proto::agent_v1::{
-    DirEntry, GetCapabilitiesRequest, GetCapabilitiesResponse, ListDirRequest, ListDirResponse,
-    MkdirRequest, MkdirResponse, ReadFileRequest, ReadFileResponse, RemoveRequest, RemoveResponse,
-    RenameRequest, RenameResponse, WriteFileRequest, WriteFileResponse,
+    DirEntry, FileHash, GetCapabilitiesRequest, GetCapabilitiesResponse, HashRequest, HashResponse,
+    ListDirRequest, ListDirResponse, MkdirRequest, MkdirResponse, PathStat, ReadFileRequest,
+    ReadFileResponse, RemoveRequest, RemoveResponse, RenameRequest, RenameResponse, SearchRequest,
+    SearchResponse, StatBatchRequest, StatBatchResponse, WriteFileRequest, WriteFileResponse,
 };
 use tokio::io::{AsyncReadExt, AsyncSeekExt, AsyncWriteExt};
 use tonic::{Request, Response, Status};
 
-use crate::minecraft;
+use crate::{dir_cache, fs_hash, fs_search, minecraft};
 
 const DEFAULT_READ_LIMIT: u64 = 64 * 1024;
 const MAX_READ_LIMIT: u64 = 1024 * 1024;
 const MAX_WRITE_LIMIT: usize = 1024 * 1024;
+const MAX_STAT_BATCH_PATHS: usize = 256;
 
 #[derive(Debug, Default, Clone)]
 pub struct FilesystemApi;
@@ -98,6 +100,56 @@ async fn enforce_scoped_existing_path(p: &Path) -> Result<PathBuf, Status> {
     Ok(canon)
 }
 
+fn modified_unix_ms(m: &std::fs::Metadata) -> u64 {
+    m.modified()
+        .ok()
+        .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
+        .map(|d| {
+            let ms = d.as_millis();
+            if ms > u64::MAX as u128 {
+                u64::MAX
+            } else {
+                ms as u64
+            }
+        })
+        .unwrap_or(0)
+}
+
+// One entry of a batch stat. Problems with a single path are reported in its result so the rest
+// of the batch still succeeds; a missing path is just `exists = false`.
+async fn stat_one(rel: &str) -> PathStat {
+    let mut out = PathStat {
+        path: rel.to_string(),
+        ..Default::default()
+    };
+    let path = match scoped_path(rel) {
+        Ok(p) => p,
+        Err(e) => {
+            out.error = Status::from(e).message().to_string();
+            return out;
+        }
+    };
+    let meta = match tokio::fs::metadata(&path).await {
+        Ok(m) => m,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return out,
+        Err(e) => {
+            out.error = status_from_io("failed to stat path", e)
+                .message()
+                .to_string();
+            return out;
+        }
+    };
+    if let Err(status) = enforce_scoped_existing_path(&path).await {
+        out.error = status.message().to_string();
+        return out;
+    }
+    out.exists = true;
+    out.is_dir = meta.is_dir();
+    out.size_bytes = if meta.is_file() { meta.len() } else { 0 };
+    out.modified_unix_ms = modified_unix_ms(&meta);
+    out
+}
+
 fn fs_write_enabled() -> bool {
     matches!(
         std::env::var("ALLOY_FS_WRITE_ENABLED")
@@ -201,6 +253,9 @@ impl FilesystemService for FilesystemApi {
     ) -> Result<Response<GetCapabilitiesResponse>, Status> {
         Ok(Response::new(GetCapabilitiesResponse {
             write_enabled: fs_write_enabled(),
+            disabled_commands: crate::command_policy::disabled(),
+            backup_protect_hours: crate::backup_protection::window()
+                .map_or(0, |w| (w.as_secs() / 3600) as u32),
         }))
     }
 
@@ -220,6 +275,16 @@ impl FilesystemService for FilesystemApi {
 
         let dir = enforce_scoped_existing_path(&dir).await?;
 
+        // Without an mtime there is nothing to validate a cached listing against.
+        let dir_mtime = meta.modified().ok();
+        if req.no_cache {
+            dir_cache::note_bypass();
+        } else if let Some(mtime) = dir_mtime
+            && let Some(entries) = dir_cache::get(&dir, mtime)
+        {
+            return Ok(Response::new(ListDirResponse { entries }));
+        }
+
         let mut entries = Vec::new();
         let mut rd = tokio::fs::read_dir(&dir)
             .await
@@ -234,31 +299,141 @@ impl FilesystemService for FilesystemApi {
                 .metadata()
                 .await
                 .map_err(|e| status_from_io("failed to stat dir entry", e))?;
-            let modified_unix_ms = m
-                .modified()
-                .ok()
-                .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
-                .map(|d| {
-                    let ms = d.as_millis();
-                    if ms > u64::MAX as u128 {
-                        u64::MAX
-                    } else {
-                        ms as u64
-                    }
-                })
-                .unwrap_or(0);
             entries.push(DirEntry {
                 name,
                 is_dir: m.is_dir(),
                 size_bytes: if m.is_file() { m.len() } else { 0 },
-                modified_unix_ms,
+                modified_unix_ms: modified_unix_ms(&m),
             });
         }
 
         entries.sort_by(|a, b| a.name.cmp(&b.name));
+        if let Some(mtime) = dir_mtime {
+            dir_cache::put(dir, mtime, entries.clone());
+        }
         Ok(Response::new(ListDirResponse { entries }))
     }
 
+    async fn stat_batch(
+        &self,
+        request: Request<StatBatchRequest>,
+    ) -> Result<Response<StatBatchResponse>, Status> {
+        let req = request.into_inner();
+        if req.paths.len() > MAX_STAT_BATCH_PATHS {
+            return Err(Status::invalid_argument(format!(
+                "too many paths (max {MAX_STAT_BATCH_PATHS})"
+            )));
+        }
+
+        let results = futures_util::future::join_all(req.paths.iter().map(|p| stat_one(p))).await;
+        Ok(Response::new(StatBatchResponse { results }))
+    }
+
+    async fn hash(&self, request: Request<HashRequest>) -> Result<Response<HashResponse>, Status> {
+        let req = request.into_inner();
+        let alg = fs_hash::Algorithm::parse(&req.algorithm).ok_or_else(|| {
+            Status::invalid_argument(format!("unsupported algorithm: {}", req.algorithm))
+        })?;
+        let mode = if req.quick {
+            fs_hash::Mode::quick(req.quick_bytes)
+        } else {
+            fs_hash::Mode::Full
+        };
+
+        let path = scoped_path(&req.path).map_err(Status::from)?;
+        let meta = tokio::fs::metadata(&path)
+            .await
+            .map_err(|e| status_from_io("failed to stat path", e))?;
+        let path = enforce_scoped_existing_path(&path).await?;
+        let rel = normalize_rel_path(&req.path)
+            .map_err(Status::from)?
+            .to_string_lossy()
+            .to_string();
+
+        let files = if meta.is_dir() {
+            let workers = if req.workers == 0 {
+                fs_hash::default_workers()
+            } else {
+                req.workers as usize
+            };
+            fs_hash::hash_dir(path, rel, alg, mode, workers)
+                .await
+                .map_err(|e| {
+                    Status::failed_precondition(format!("failed to hash directory: {e}"))
+                })?
+        } else {
+            let (size_bytes, hash) =
+                tokio::task::spawn_blocking(move || fs_hash::hash_file(&path, alg, mode))
+                    .await
+                    .map_err(|e| Status::internal(format!("hash task failed: {e}")))?
+                    .map_err(|e| status_from_io("failed to hash file", e))?;
+            vec![FileHash {
+                path: rel,
+                size_bytes,
+                hash,
+                error: String::new(),
+            }]
+        };
+
+        Ok(Response::new(HashResponse {
+            algorithm: alg.as_str().to_string(),
+            quick: req.quick,
+            files,
+        }))
+    }
+
+    async fn search(
+        &self,
+        request: Request<SearchRequest>,
+    ) -> Result<Response<SearchResponse>, Status> {
+        let req = request.into_inner();
+        if req.query.is_empty() {
+            return Err(Status::invalid_argument("query is required"));
+        }
+        let export =
+            fs_search::ExportFormat::parse(&req.export).map_err(Status::invalid_argument)?;
+        let max_matches = if req.max_matches == 0 {
+            fs_search::DEFAULT_MAX_MATCHES
+        } else {
+            (req.max_matches as usize).min(fs_search::MAX_MATCHES)
+        };
+
+        let path = scoped_path(&req.path).map_err(Status::from)?;
+        let path = enforce_scoped_existing_path(&path).await?;
+        let rel = normalize_rel_path(&req.path)
+            .map_err(Status::from)?
+            .to_string_lossy()
+            .to_string();
+
+        let out = tokio::task::spawn_blocking(move || {
+            fs_search::search(
+                &data_root(),
+                &path,
+                &rel,
+                &req.query,
+                req.case_insensitive,
+                max_matches,
+                export,
+            )
+        })
+        .await
+        .map_err(|e| Status::internal(format!("search task failed: {e}")))?
+        .map_err(|e| Status::failed_precondition(format!("search failed: {e}")))?;
+        if out.export_path.is_some() {
+            dir_cache::clear();
+        }
+
+        Ok(Response::new(SearchResponse {
+            truncated: out.total_matches > out.matches.len() as u64,
+            matches: out.matches,
+            total_matches: out.total_matches,
+            files_scanned: out.files_scanned,
+            files_skipped: out.files_skipped,
+            export_path: out.export_path.unwrap_or_default(),
+            export_truncated: out.export_truncated,
+        }))
+    }
+
     async fn read_file(
         &self,
         request: Request<ReadFileRequest>,
@@ -315,6 +490,7 @@ impl FilesystemService for FilesystemApi {
         ensure_fs_write_enabled()?;
         let req = request.into_inner();
         mkdir_rel(&req.path, req.recursive).await?;
+        dir_cache::clear();
         Ok(Response::new(MkdirResponse { ok: true }))
     }
 
@@ -334,6 +510,7 @@ impl FilesystemService for FilesystemApi {
             .file_name()
             .ok_or_else(|| Status::invalid_argument("path must include filename"))?;
         let path = parent.join(file_name);
+        crate::backup_protection::check(&path).await?;
 
         let meta = tokio::fs::symlink_metadata(&path).await.ok();
         if let Some(m) = meta {
@@ -357,6 +534,7 @@ impl FilesystemService for FilesystemApi {
             .await
             .map_err(|e| status_from_io("failed to persist file", e))?;
 
+        dir_cache::clear();
         Ok(Response::new(WriteFileResponse { ok: true }))
     }
 
@@ -368,6 +546,7 @@ impl FilesystemService for FilesystemApi {
         let req = request.into_inner();
         let from = scoped_path(&req.from_path).map_err(Status::from)?;
         let from = enforce_scoped_existing_path(&from).await?;
+        crate::backup_protection::check(&from).await?;
 
         let to_parent = ensure_scoped_parent_dir(&req.to_path).await?;
         let to_rel = normalize_rel_path(&req.to_path).map_err(Status::from)?;
@@ -383,6 +562,7 @@ impl FilesystemService for FilesystemApi {
         tokio::fs::rename(&from, &to)
             .await
             .map_err(|e| status_from_io("rename failed", e))?;
+        dir_cache::clear();
         Ok(Response::new(RenameResponse { ok: true }))
     }
 
@@ -401,6 +581,7 @@ impl FilesystemService for FilesystemApi {
         if meta.file_type().is_symlink() {
             return Err(Status::invalid_argument("refusing to remove symlink"));
         }
+        crate::backup_protection::check(&path).await?;
 
         if meta.is_dir() {
             if req.recursive {
@@ -418,6 +599,7 @@ impl FilesystemService for FilesystemApi {
                 .map_err(|e| status_from_io("remove failed", e))?;
         }
 
+        dir_cache::clear();
         Ok(Response::new(RemoveResponse { ok: true }))
     }
 }
@@ -0,0 +1,253 @@
+use std::{
+    io::{Read, Seek, SeekFrom},
+    path::{Path, PathBuf},
+    sync::Arc,
+};
+
+use alloy_proto::agent_v1::FileHash;
+use sha2::Digest;
+use tokio::sync::Semaphore;
+
+const READ_BUF_BYTES: usize = 1024 * 1024;
+const DEFAULT_QUICK_BYTES: u64 = 4 * 1024 * 1024;
+const MAX_QUICK_BYTES: u64 = 256 * 1024 * 1024;
+const MAX_MANIFEST_FILES: usize = 100_000;
+const MAX_WORKERS: usize = 16;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Algorithm {
+    Sha256,
+    // xxh3-128: several times faster than sha256, but not cryptographic. Fine for change
+    // detection, not for verifying downloads.
+    Xxh3,
+}
+
+impl Algorithm {
+    pub(crate) fn parse(raw: &str) -> Option<Self> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" | "sha256" => Some(Self::Sha256),
+            "xxh3" | "xxh3-128" => Some(Self::Xxh3),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_str(self) -> &'static str {
+        match self {
+            Self::Sha256 => "sha256",
+            Self::Xxh3 => "xxh3",
+        }
+    }
+}
+
+enum Hasher {
+    Sha256(sha2::Sha256),
+    Xxh3(Box<xxhash_rust::xxh3::Xxh3>),
+}
+
+impl Hasher {
+    fn new(alg: Algorithm) -> Self {
+        match alg {
+            Algorithm::Sha256 => Self::Sha256(sha2::Sha256::new()),
+            Algorithm::Xxh3 => Self::Xxh3(Box::new(xxhash_rust::xxh3::Xxh3::new())),
+        }
+    }
+
+    fn update(&mut self, data: &[u8]) {
+        match self {
+            Self::Sha256(h) => h.update(data),
+            Self::Xxh3(h) => h.update(data),
+        }
+    }
+
+    fn finish(self) -> String {
+        match self {
+            Self::Sha256(h) => hex::encode(h.finalize()),
+            Self::Xxh3(h) => format!("{:032x}", h.digest128()),
+        }
+    }
+}
+
+// How much of each file to read. `Quick` hashes the size plus the first and last `n` bytes,
+// which catches appends and most rewrites of huge region files without reading them whole. Two
+// files with the same quick hash may still differ in the middle.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Mode {
+    Full,
+    Quick(u64),
+}
+
+impl Mode {
+    pub(crate) fn quick(bytes: u64) -> Self {
+        let n = if bytes == 0 {
+            DEFAULT_QUICK_BYTES
+        } else {
+            bytes.min(MAX_QUICK_BYTES)
+        };
+        Self::Quick(n)
+    }
+}
+
+fn copy_into(hasher: &mut Hasher, r: &mut impl Read, mut limit: u64) -> std::io::Result<()> {
+    let mut buf = vec![0u8; READ_BUF_BYTES];
+    while limit > 0 {
+        let want = buf.len().min(limit.min(usize::MAX as u64) as usize);
+        let n = r.read(&mut buf[..want])?;
+        if n == 0 {
+            break;
+        }
+        hasher.update(&buf[..n]);
+        limit -= n as u64;
+    }
+    Ok(())
+}
+
+// Blocking; run it on the blocking pool.
+pub(crate) fn hash_file(path: &Path, alg: Algorithm, mode: Mode) -> std::io::Result<(u64, String)> {
+    let mut f = std::fs::File::open(path)?;
+    let size = f.metadata()?.len();
+    let mut hasher = Hasher::new(alg);
+    match mode {
+        Mode::Full => copy_into(&mut hasher, &mut f, u64::MAX)?,
+        Mode::Quick(n) => {
+            hasher.update(&size.to_le_bytes());
+            copy_into(&mut hasher, &mut f, n)?;
+            if size > n {
+                let tail_start = size.saturating_sub(n).max(n);
+                f.seek(SeekFrom::Start(tail_start))?;
+                copy_into(&mut hasher, &mut f, size - tail_start)?;
+            }
+        }
+    }
+    Ok((size, hasher.finish()))
+}
+
+// Regular files under `dir`, relative to it, sorted. Symlinks are skipped so a manifest never
+// leaves the directory.
+pub(crate) fn walk_files(dir: &Path) -> std::io::Result<Vec<PathBuf>> {
+    let mut out = Vec::new();
+    let mut stack = vec![PathBuf::new()];
+    while let Some(rel) = stack.pop() {
+        for de in std::fs::read_dir(dir.join(&rel))? {
+            let de = de?;
+            let ft = de.file_type()?;
+            let child = rel.join(de.file_name());
+            if ft.is_dir() {
+                stack.push(child);
+            } else if ft.is_file() {
+                out.push(child);
+                if out.len() > MAX_MANIFEST_FILES {
+                    return Err(std::io::Error::other(format!(
+                        "more than {MAX_MANIFEST_FILES} files"
+                    )));
+                }
+            }
+        }
+    }
+    out.sort();
+    Ok(out)
+}
+
+pub(crate) fn default_workers() -> usize {
+    std::thread::available_parallelism()
+        .map(|n| n.get())
+        .unwrap_or(1)
+        .clamp(1, 4)
+}
+
+// Hashes every file under `dir` with up to `workers` files in flight. A file that can't be read
+// gets an error in its entry instead of failing the manifest. `prefix` is prepended to the
+// reported paths.
+pub(crate) async fn hash_dir(
+    dir: PathBuf,
+    prefix: String,
+    alg: Algorithm,
+    mode: Mode,
+    workers: usize,
+) -> std::io::Result<Vec<FileHash>> {
+    let files = {
+        let dir = dir.clone();
+        tokio::task::spawn_blocking(move || walk_files(&dir))
+            .await
+            .map_err(std::io::Error::other)??
+    };
+
+    let permits = Arc::new(Semaphore::new(workers.clamp(1, MAX_WORKERS)));
+    let mut tasks = Vec::with_capacity(files.len());
+    for rel in files {
+        let permits = permits.clone();
+        let abs = dir.join(&rel);
+        tasks.push(tokio::spawn(async move {
+            let _permit = permits.acquire_owned().await;
+            let res = tokio::task::spawn_blocking(move || hash_file(&abs, alg, mode)).await;
+            (rel, res)
+        }));
+    }
+
+    let mut out = Vec::with_capacity(tasks.len());
+    for task in tasks {
+        let (rel, res) = task.await.map_err(std::io::Error::other)?;
+        let path = Path::new(&prefix).join(&rel).to_string_lossy().to_string();
+        let entry = match res {
+            Ok(Ok((size_bytes, hash))) => FileHash {
+                path,
+                size_bytes,
+                hash,
+                error: String::new(),
+            },
+            Ok(Err(e)) => FileHash {
+                path,
+                error: e.to_string(),
+                ..Default::default()
+            },
+            Err(e) => FileHash {
+                path,
+                error: e.to_string(),
+                ..Default::default()
+            },
+        };
+        out.push(entry);
+    }
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn quick_hash_covers_size_head_and_tail_only() {
+        let dir = std::env::temp_dir().join(format!("alloy-fs-hash-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+        let a = dir.join("a.bin");
+        let b = dir.join("b.bin");
+
+        let mut data = vec![7u8; 64];
+        std::fs::write(&a, &data).unwrap();
+        data[32] = 8;
+        std::fs::write(&b, &data).unwrap();
+
+        let quick = Mode::quick(16);
+        for alg in [Algorithm::Sha256, Algorithm::Xxh3] {
+            // Same size, head and tail: quick mode can't tell them apart, full mode can.
+            let (size, qa) = hash_file(&a, alg, quick).unwrap();
+            let (_, qb) = hash_file(&b, alg, quick).unwrap();
+            assert_eq!(size, 64);
+            assert_eq!(qa, qb);
+            let (_, fa) = hash_file(&a, alg, Mode::Full).unwrap();
+            let (_, fb) = hash_file(&b, alg, Mode::Full).unwrap();
+            assert_ne!(fa, fb);
+        }
+
+        let (_, empty) = {
+            std::fs::write(&a, b"").unwrap();
+            hash_file(&a, Algorithm::Sha256, Mode::Full).unwrap()
+        };
+        assert_eq!(
+            empty,
+            "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+        );
+
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,346 @@
+use std::{
+    io::{BufRead, BufReader, Read, Write},
+    path::{Path, PathBuf},
+};
+
+use alloy_proto::agent_v1::SearchMatch;
+
+// Literal text search over the files under a directory, e.g. every config that still mentions an
+// old IP. The inline result stops at `max_matches`; an export writes every match to
+// <data root>/_exports/search-<unix_ms>.{json,csv} so audits aren't cut short.
+
+pub(crate) const DEFAULT_MAX_MATCHES: usize = 200;
+pub(crate) const MAX_MATCHES: usize = 1000;
+const MAX_EXPORT_MATCHES: u64 = 1_000_000;
+const MAX_FILE_BYTES: u64 = 64 * 1024 * 1024;
+const MAX_LINE_CHARS: usize = 512;
+const SNIFF_BYTES: usize = 8 * 1024;
+const EXPORT_DIR: &str = "_exports";
+const KEEP_EXPORTS: usize = 20;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum ExportFormat {
+    Json,
+    Csv,
+}
+
+impl ExportFormat {
+    // None for no export; Err for an unknown format.
+    pub(crate) fn parse(raw: &str) -> Result<Option<Self>, String> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" => Ok(None),
+            "json" => Ok(Some(Self::Json)),
+            "csv" => Ok(Some(Self::Csv)),
+            other => Err(format!("unsupported export format: {other}")),
+        }
+    }
+
+    fn ext(self) -> &'static str {
+        match self {
+            Self::Json => "json",
+            Self::Csv => "csv",
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+pub(crate) struct Outcome {
+    pub(crate) matches: Vec<SearchMatch>,
+    // Every match with an export; without one, counting stops past `max_matches`.
+    pub(crate) total_matches: u64,
+    pub(crate) files_scanned: u64,
+    // Binary, oversized or unreadable files.
+    pub(crate) files_skipped: u64,
+    // Relative to the data root.
+    pub(crate) export_path: Option<String>,
+    pub(crate) export_truncated: bool,
+}
+
+fn csv_field(s: &str) -> String {
+    if s.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", s.replace('"', "\"\""))
+    } else {
+        s.to_string()
+    }
+}
+
+struct Export {
+    format: ExportFormat,
+    out: std::io::BufWriter<std::fs::File>,
+    written: u64,
+}
+
+impl Export {
+    fn create(path: &Path, format: ExportFormat) -> std::io::Result<Self> {
+        let mut out = std::io::BufWriter::new(std::fs::File::create(path)?);
+        match format {
+            ExportFormat::Json => out.write_all(b"[")?,
+            ExportFormat::Csv => out.write_all(b"path,line,text\n")?,
+        }
+        Ok(Self {
+            format,
+            out,
+            written: 0,
+        })
+    }
+
+    fn push(&mut self, m: &SearchMatch) -> std::io::Result<()> {
+        match self.format {
+            ExportFormat::Json => {
+                let sep = if self.written == 0 { "\n" } else { ",\n" };
+                let entry = serde_json::json!({ "path": m.path, "line": m.line, "text": m.text });
+                write!(self.out, "{sep}{entry}")?;
+            }
+            ExportFormat::Csv => writeln!(
+                self.out,
+                "{},{},{}",
+                csv_field(&m.path),
+                m.line,
+                csv_field(&m.text)
+            )?,
+        }
+        self.written += 1;
+        Ok(())
+    }
+
+    fn finish(mut self) -> std::io::Result<()> {
+        if self.format == ExportFormat::Json {
+            self.out.write_all(b"\n]\n")?;
+        }
+        self.out.flush()
+    }
+}
+
+fn is_binary(f: &mut std::fs::File) -> std::io::Result<bool> {
+    let mut head = vec![0u8; SNIFF_BYTES];
+    let n = f.read(&mut head)?;
+    Ok(head[..n].contains(&0))
+}
+
+fn truncate_line(line: &str) -> String {
+    match line.char_indices().nth(MAX_LINE_CHARS) {
+        Some((i, _)) => format!("{}…", &line[..i]),
+        None => line.to_string(),
+    }
+}
+
+// Calls `hit` for each (line number, line) of `path` containing `needle`. `needle` is already
+// lowercased when `case_insensitive` is set.
+fn search_file(
+    path: &Path,
+    needle: &str,
+    case_insensitive: bool,
+    mut hit: impl FnMut(u64, &str),
+) -> std::io::Result<bool> {
+    let mut f = std::fs::File::open(path)?;
+    if f.metadata()?.len() > MAX_FILE_BYTES || is_binary(&mut f)? {
+        return Ok(false);
+    }
+    drop(f);
+
+    let mut reader = BufReader::new(std::fs::File::open(path)?);
+    let mut buf = Vec::new();
+    let mut line_no = 0u64;
+    loop {
+        buf.clear();
+        if reader.read_until(b'\n', &mut buf)? == 0 {
+            break;
+        }
+        line_no += 1;
+        let line = String::from_utf8_lossy(&buf);
+        let line = line.trim_end_matches(['\r', '\n']);
+        let found = if case_insensitive {
+            line.to_lowercase().contains(needle)
+        } else {
+            line.contains(needle)
+        };
+        if found {
+            hit(line_no, line);
+        }
+    }
+    Ok(true)
+}
+
+fn export_dir(root: &Path) -> PathBuf {
+    root.join(EXPORT_DIR)
+}
+
+// Keeps the newest KEEP_EXPORTS search exports.
+fn prune_exports(dir: &Path) {
+    let Ok(rd) = std::fs::read_dir(dir) else {
+        return;
+    };
+    let mut exports: Vec<PathBuf> = rd
+        .flatten()
+        .map(|de| de.path())
+        .filter(|p| {
+            p.file_name()
+                .and_then(|n| n.to_str())
+                .is_some_and(|n| n.starts_with("search-"))
+        })
+        .collect();
+    // Names embed the creation time, so they sort oldest first.
+    exports.sort();
+    let excess = exports.len().saturating_sub(KEEP_EXPORTS);
+    for p in exports.into_iter().take(excess) {
+        let _ = std::fs::remove_file(p);
+    }
+}
+
+// Blocking; run it on the blocking pool. `dir` is the directory to search and `prefix` its path
+// relative to `root` (the data root), which reported paths start with. `_exports` directories are
+// not searched.
+pub(crate) fn search(
+    root: &Path,
+    dir: &Path,
+    prefix: &str,
+    query: &str,
+    case_insensitive: bool,
+    max_matches: usize,
+    export: Option<ExportFormat>,
+) -> std::io::Result<Outcome> {
+    let needle = if case_insensitive {
+        query.to_lowercase()
+    } else {
+        query.to_string()
+    };
+    // (absolute path, path reported in matches)
+    let files: Vec<(PathBuf, String)> = if dir.is_dir() {
+        crate::fs_hash::walk_files(dir)?
+            .into_iter()
+            .filter(|rel| {
+                !rel.components()
+                    .any(|c| c.as_os_str() == std::ffi::OsStr::new(EXPORT_DIR))
+            })
+            .map(|rel| {
+                let shown = Path::new(prefix).join(&rel).to_string_lossy().to_string();
+                (dir.join(rel), shown)
+            })
+            .collect()
+    } else {
+        vec![(dir.to_path_buf(), prefix.to_string())]
+    };
+
+    let mut export = match export {
+        Some(format) => {
+            let dir = export_dir(root);
+            std::fs::create_dir_all(&dir)?;
+            let name = format!(
+                "search-{}.{}",
+                crate::console_audit::now_unix_ms(),
+                format.ext()
+            );
+            let tmp = dir.join(format!(".{name}.tmp"));
+            Some((Export::create(&tmp, format)?, tmp, dir.join(name)))
+        }
+        None => None,
+    };
+
+    let mut out = Outcome::default();
+    for (abs, path) in files {
+        let mut export_err = None;
+        let scanned = search_file(&abs, &needle, case_insensitive, |line, text| {
+            out.total_matches += 1;
+            let m = SearchMatch {
+                path: path.clone(),
+                line,
+                text: truncate_line(text),
+            };
+            if let Some((e, _, _)) = export.as_mut() {
+                if e.written >= MAX_EXPORT_MATCHES {
+                    out.export_truncated = true;
+                } else if let Err(err) = e.push(&m) {
+                    export_err = Some(err);
+                }
+            }
+            if out.matches.len() < max_matches {
+                out.matches.push(m);
+            }
+        });
+        // An export that can't be written fails the search rather than coming back short.
+        if let Some(err) = export_err {
+            if let Some((_, tmp, _)) = &export {
+                let _ = std::fs::remove_file(tmp);
+            }
+            return Err(err);
+        }
+        match scanned {
+            Ok(true) => out.files_scanned += 1,
+            Ok(false) | Err(_) => out.files_skipped += 1,
+        }
+        if export.is_none() && out.total_matches > max_matches as u64 {
+            // Without an export there's no need to read every remaining file just to count.
+            break;
+        }
+    }
+
+    if let Some((e, tmp, dest)) = export {
+        e.finish()?;
+        std::fs::rename(&tmp, &dest)?;
+        prune_exports(&export_dir(root));
+        out.export_path = dest
+            .strip_prefix(root)
+            .ok()
+            .map(|p| p.to_string_lossy().to_string());
+    }
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn finds_matches_and_exports_all_of_them() {
+        let root =
+            std::env::temp_dir().join(format!("alloy-fs-search-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        let dir = root.join("instances").join("a");
+        std::fs::create_dir_all(dir.join("config")).unwrap();
+        std::fs::write(
+            dir.join("server.properties"),
+            "server-ip=10.0.0.5\nmotd=hi, 10.0.0.5\n",
+        )
+        .unwrap();
+        std::fs::write(dir.join("config").join("x.yml"), "host: \"10.0.0.5\"\n").unwrap();
+        std::fs::write(dir.join("world.dat"), b"10.0.0.5\0\x01").unwrap();
+
+        let out = search(&root, &dir, "instances/a", "10.0.0.5", false, 2, None).unwrap();
+        assert_eq!(out.matches.len(), 2);
+        assert!(out.export_path.is_none());
+
+        let out = search(
+            &root,
+            &dir,
+            "instances/a",
+            "10.0.0.5",
+            false,
+            1,
+            Some(ExportFormat::Csv),
+        )
+        .unwrap();
+        assert_eq!(out.matches.len(), 1);
+        assert_eq!(out.total_matches, 3);
+        assert_eq!(out.files_skipped, 1);
+        let export = out.export_path.unwrap();
+        assert!(export.starts_with("_exports/search-"));
+        let csv = std::fs::read_to_string(root.join(&export)).unwrap();
+        assert_eq!(
+            csv,
+            "path,line,text\n\
+             instances/a/config/x.yml,1,\"host: \"\"10.0.0.5\"\"\"\n\
+             instances/a/server.properties,1,server-ip=10.0.0.5\n\
+             instances/a/server.properties,2,\"motd=hi, 10.0.0.5\"\n"
+        );
+
+        // Searching the whole root doesn't pick up the export itself.
+        let out = search(&root, &root, "", "MOTD", true, 10, Some(ExportFormat::Json)).unwrap();
+        assert_eq!(out.total_matches, 1);
+        let json: serde_json::Value =
+            serde_json::from_slice(&std::fs::read(root.join(out.export_path.unwrap())).unwrap())
+                .unwrap();
+        assert_eq!(json[0]["line"], 2);
+
+        let _ = std::fs::remove_dir_all(&root);
+    }
+}
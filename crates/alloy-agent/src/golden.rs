@@ -0,0 +1,262 @@
+use std::path::{Path, PathBuf};
+
+use crate::fs_hash;
+
+// Agent-managed state that belongs to one instance and is never cloned from (or compared with)
+// its golden image. instance.json is written fresh for the new instance.
+const SKIP: &[&str] = &[
+    "instance.json",
+    "logs",
+    "crashes",
+    "config/frpc.status.json",
+    "_exports",
+    ".checkpoint",
+];
+
+// Files the agent and the game servers only ever replace (write to a temp file, then rename),
+// never rewrite in place, so a hardlink shared with the golden image can't be modified through
+// the derived instance. World data is rewritten in place and must be reflinked or copied.
+const HARDLINK_EXTENSIONS: &[&str] = &["jar", "zip", "so", "dll"];
+
+fn skipped(rel: &Path) -> bool {
+    SKIP.iter().any(|s| rel.starts_with(s))
+}
+
+fn hardlink_safe(rel: &Path) -> bool {
+    rel.extension().and_then(|e| e.to_str()).is_some_and(|e| {
+        HARDLINK_EXTENSIONS
+            .iter()
+            .any(|x| e.eq_ignore_ascii_case(x))
+    })
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub(crate) struct CloneStats {
+    pub(crate) reflinked_files: u32,
+    pub(crate) linked_files: u32,
+    pub(crate) copied_files: u32,
+    pub(crate) size_bytes: u64,
+}
+
+#[cfg(target_os = "linux")]
+fn reflink(src: &Path, dst: &Path) -> std::io::Result<()> {
+    use std::os::fd::AsRawFd;
+
+    let from = std::fs::File::open(src)?;
+    let to = std::fs::OpenOptions::new()
+        .write(true)
+        .create_new(true)
+        .open(dst)?;
+    // SAFETY: both descriptors are open for the duration of the call.
+    let rc = unsafe { libc::ioctl(to.as_raw_fd(), libc::FICLONE, from.as_raw_fd()) };
+    if rc == 0 {
+        return Ok(());
+    }
+    let err = std::io::Error::last_os_error();
+    drop(to);
+    let _ = std::fs::remove_file(dst);
+    Err(err)
+}
+
+#[cfg(not(target_os = "linux"))]
+fn reflink(_src: &Path, _dst: &Path) -> std::io::Result<()> {
+    Err(std::io::Error::from(std::io::ErrorKind::Unsupported))
+}
+
+struct Cloner {
+    // Cleared after the first refusal: a filesystem that can't reflink one file can't reflink
+    // any, and each attempt costs a syscall per file.
+    try_reflink: bool,
+    stats: CloneStats,
+}
+
+impl Cloner {
+    fn file(&mut self, rel: &Path, src: &Path, dst: &Path, len: u64) -> std::io::Result<()> {
+        self.stats.size_bytes = self.stats.size_bytes.saturating_add(len);
+        if self.try_reflink {
+            match reflink(src, dst) {
+                Ok(()) => {
+                    self.stats.reflinked_files += 1;
+                    return Ok(());
+                }
+                Err(err) => {
+                    tracing::debug!(error = %err, "reflink unavailable; using hardlinks and copies");
+                    self.try_reflink = false;
+                }
+            }
+        }
+        if hardlink_safe(rel) && std::fs::hard_link(src, dst).is_ok() {
+            self.stats.linked_files += 1;
+            return Ok(());
+        }
+        std::fs::copy(src, dst)?;
+        self.stats.copied_files += 1;
+        Ok(())
+    }
+
+    fn dir(&mut self, src_root: &Path, dst_root: &Path, rel: &Path) -> std::io::Result<()> {
+        std::fs::create_dir_all(dst_root.join(rel))?;
+        let mut entries = std::fs::read_dir(src_root.join(rel))?.collect::<Result<Vec<_>, _>>()?;
+        entries.sort_by_key(|e| e.file_name());
+        for de in entries {
+            let child = rel.join(de.file_name());
+            if skipped(&child) {
+                continue;
+            }
+            let src = src_root.join(&child);
+            let dst = dst_root.join(&child);
+            let meta = std::fs::symlink_metadata(&src)?;
+            let ft = meta.file_type();
+            if ft.is_dir() {
+                self.dir(src_root, dst_root, &child)?;
+            } else if ft.is_file() {
+                self.file(&child, &src, &dst, meta.len())?;
+            } else if ft.is_symlink() {
+                // Instance symlinks are relative (e.g. server.properties -> config/...), so the
+                // same target resolves inside the new directory.
+                #[cfg(unix)]
+                std::os::unix::fs::symlink(std::fs::read_link(&src)?, &dst)?;
+            }
+        }
+        Ok(())
+    }
+}
+
+// Blocking. Materializes `src` into `dst`, which must not exist yet. On error the partial copy is
+// removed.
+pub(crate) fn clone_tree(src: &Path, dst: &Path) -> std::io::Result<CloneStats> {
+    if dst.exists() {
+        return Err(std::io::Error::new(
+            std::io::ErrorKind::AlreadyExists,
+            format!("{} already exists", dst.display()),
+        ));
+    }
+    let mut cloner = Cloner {
+        try_reflink: true,
+        stats: CloneStats::default(),
+    };
+    match cloner.dir(src, dst, Path::new("")) {
+        Ok(()) => Ok(cloner.stats),
+        Err(err) => {
+            let _ = std::fs::remove_dir_all(dst);
+            Err(err)
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub(crate) struct Divergence {
+    pub(crate) shared_bytes: u64,
+    pub(crate) diverged_bytes: u64,
+    pub(crate) diverged_files: u32,
+    pub(crate) removed_files: u32,
+}
+
+#[cfg(unix)]
+fn same_inode(a: &std::fs::Metadata, b: &std::fs::Metadata) -> bool {
+    use std::os::unix::fs::MetadataExt;
+    a.dev() == b.dev() && a.ino() == b.ino()
+}
+
+#[cfg(not(unix))]
+fn same_inode(_a: &std::fs::Metadata, _b: &std::fs::Metadata) -> bool {
+    false
+}
+
+fn same_contents(a: &Path, b: &Path) -> bool {
+    let hash = |p: &Path| fs_hash::hash_file(p, fs_hash::Algorithm::Xxh3, fs_hash::Mode::Full);
+    match (hash(a), hash(b)) {
+        (Ok((_, x)), Ok((_, y))) => x == y,
+        _ => false,
+    }
+}
+
+fn files(root: &Path) -> std::io::Result<Vec<PathBuf>> {
+    let mut out = fs_hash::walk_files(root)?;
+    out.retain(|rel| !skipped(rel));
+    Ok(out)
+}
+
+// Blocking. Hardlinked files are recognized by inode; anything else of equal size is compared by
+// content, since reflinked copies get their own inode. Reads every such file in both trees.
+pub(crate) fn divergence(golden: &Path, derived: &Path) -> std::io::Result<Divergence> {
+    let golden_files = files(golden)?;
+    let derived_files = files(derived)?;
+
+    let mut out = Divergence::default();
+    for rel in &derived_files {
+        let d = derived.join(rel);
+        let d_meta = std::fs::metadata(&d)?;
+        let g = golden.join(rel);
+        let shared = match std::fs::metadata(&g) {
+            Ok(g_meta) => {
+                same_inode(&g_meta, &d_meta)
+                    || (g_meta.len() == d_meta.len() && same_contents(&g, &d))
+            }
+            Err(_) => false,
+        };
+        if shared {
+            out.shared_bytes = out.shared_bytes.saturating_add(d_meta.len());
+        } else {
+            out.diverged_bytes = out.diverged_bytes.saturating_add(d_meta.len());
+            out.diverged_files += 1;
+        }
+    }
+    out.removed_files = golden_files
+        .iter()
+        .filter(|rel| derived_files.binary_search(rel).is_err())
+        .count() as u32;
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn clone_skips_agent_state_and_tracks_divergence() {
+        let root = std::env::temp_dir().join(format!("alloy-golden-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        let golden = root.join("golden");
+        let derived = root.join("derived");
+        std::fs::create_dir_all(golden.join("worlds/world")).unwrap();
+        std::fs::create_dir_all(golden.join("logs")).unwrap();
+        std::fs::write(golden.join("server.jar"), b"jar").unwrap();
+        std::fs::write(golden.join("worlds/world/level.dat"), b"level").unwrap();
+        std::fs::write(golden.join("instance.json"), b"{}").unwrap();
+        std::fs::write(golden.join("logs/console.log"), b"log").unwrap();
+
+        let stats = clone_tree(&golden, &derived).unwrap();
+        assert_eq!(
+            stats.reflinked_files + stats.linked_files + stats.copied_files,
+            2
+        );
+        assert_eq!(stats.size_bytes, 8);
+        assert!(!derived.join("instance.json").exists());
+        assert!(!derived.join("logs").exists());
+        assert!(clone_tree(&golden, &derived).is_err());
+
+        assert_eq!(
+            divergence(&golden, &derived).unwrap(),
+            Divergence {
+                shared_bytes: 8,
+                ..Default::default()
+            }
+        );
+
+        std::fs::write(derived.join("worlds/world/level.dat"), b"level2").unwrap();
+        std::fs::write(derived.join("ops.json"), b"[]").unwrap();
+        std::fs::remove_file(derived.join("server.jar")).unwrap();
+        assert_eq!(
+            divergence(&golden, &derived).unwrap(),
+            Divergence {
+                shared_bytes: 0,
+                diverged_bytes: 8,
+                diverged_files: 2,
+                removed_files: 1,
+            }
+        );
+
+        let _ = std::fs::remove_dir_all(&root);
+    }
+}
@@ -1,7 +1,13 @@
 use alloy_proto::agent_v1::agent_health_service_server::{
     AgentHealthService, AgentHealthServiceServer,
 };
-use alloy_proto::agent_v1::{HealthCheckRequest, HealthCheckResponse, PortAvailability};
+use alloy_proto::agent_v1::{
+    BackupSchedule, BackupScheduleError, ClockStatus, DirCacheStats, FrpSummary,
+    HealthCheckRequest, HealthCheckResponse, MirrorStatus, NodeInfoRequest, NodeInfoResponse,
+    PlacementRequest, PlacementResponse, PortAvailability, ReloadBackupScheduleRequest,
+    ReloadBackupScheduleResponse, SelfTestCheck, SelfTestRequest, SelfTestResponse,
+    SetLogLevelRequest, SetLogLevelResponse, SupportBundleRequest, SupportBundleResponse,
+};
 use tonic::{Request, Response, Status};
 
 #[derive(Debug, Default, Clone)]
@@ -64,17 +70,14 @@ impl AgentHealthService for HealthApi {
 
         fn check_tcp_port(port: u16) -> PortAvailability {
             use std::io::ErrorKind;
-            use std::net::TcpListener;
-
-            match TcpListener::bind(("0.0.0.0", port)) {
-                Ok(l) => {
-                    l.set_nonblocking(true).ok();
-                    PortAvailability {
-                        port: port as u32,
-                        available: true,
-                        error: String::new(),
-                    }
-                }
+
+            // Both the IPv4 and IPv6 wildcard, since a server may bind either.
+            match crate::netaddr::check_tcp(None, port) {
+                Ok(()) => PortAvailability {
+                    port: port as u32,
+                    available: true,
+                    error: String::new(),
+                },
                 Err(e) if e.kind() == ErrorKind::AddrInUse => PortAvailability {
                     port: port as u32,
                     available: false,
@@ -93,6 +96,31 @@ impl AgentHealthService for HealthApi {
             .map(check_tcp_port)
             .collect();
 
+        let frp = crate::frp::summarize(&data_root.join("instances")).await;
+        let dir_cache = crate::dir_cache::stats();
+
+        let (timezone, utc_offset_seconds) = crate::clock::timezone();
+        let ntp = crate::clock::status();
+        let clock = ClockStatus {
+            unix_ms: crate::console_audit::now_unix_ms(),
+            timezone,
+            utc_offset_seconds,
+            ntp_server: crate::clock::ntp_server().unwrap_or_default(),
+            ntp_offset_ms: ntp.last.as_ref().map(|s| s.offset_ms).unwrap_or_default(),
+            has_ntp_offset: ntp.last.is_some(),
+            ntp_round_trip_ms: ntp
+                .last
+                .as_ref()
+                .map(|s| s.round_trip_ms)
+                .unwrap_or_default(),
+            ntp_checked_at_unix_ms: ntp
+                .last
+                .as_ref()
+                .map(|s| s.checked_at_unix_ms)
+                .unwrap_or_default(),
+            ntp_error: ntp.error.unwrap_or_default(),
+        };
+
         let reply = HealthCheckResponse {
             status: "SERVING".to_string(),
             agent_version: env!("CARGO_PKG_VERSION").to_string(),
@@ -100,9 +128,290 @@ impl AgentHealthService for HealthApi {
             data_root_writable: writable,
             data_root_free_bytes: free_bytes(&data_root),
             ports,
+            frp: Some(FrpSummary {
+                tunnels: frp.tunnels,
+                tunnels_running: frp.tunnels_running,
+                proxies: frp.proxies,
+                proxies_running: frp.proxies_running,
+                cur_conns: frp.cur_conns,
+                traffic_in_bytes: frp.traffic_in_bytes,
+                traffic_out_bytes: frp.traffic_out_bytes,
+            }),
+            dir_cache: Some(DirCacheStats {
+                hits: dir_cache.hits,
+                misses: dir_cache.misses,
+                bypassed: dir_cache.bypassed,
+                dirs: dir_cache.dirs,
+            }),
+            clock: Some(clock),
+            mirrors: crate::mirrors::status()
+                .into_iter()
+                .map(|m| MirrorStatus {
+                    provider: m.provider,
+                    base: m.base,
+                    position: m.position,
+                    healthy: m.healthy,
+                    successes: m.successes,
+                    failures: m.failures,
+                    last_error: m.last_error,
+                    last_ok_unix_ms: m.last_ok_unix_ms,
+                    last_error_unix_ms: m.last_error_unix_ms,
+                })
+                .collect(),
         };
         Ok(Response::new(reply))
     }
+
+    async fn support_bundle(
+        &self,
+        request: Request<SupportBundleRequest>,
+    ) -> Result<Response<SupportBundleResponse>, Status> {
+        let req = request.into_inner();
+        let health = self
+            .check(Request::new(HealthCheckRequest {}))
+            .await?
+            .into_inner();
+        let frp = health.frp.unwrap_or_default();
+        let dir_cache = health.dir_cache.unwrap_or_default();
+        let clock = health.clock.unwrap_or_default();
+        let ports: Vec<_> = health
+            .ports
+            .iter()
+            .map(|p| {
+                serde_json::json!({
+                    "port": p.port,
+                    "available": p.available,
+                    "error": p.error,
+                })
+            })
+            .collect();
+        let mirrors: Vec<_> = health
+            .mirrors
+            .iter()
+            .map(|m| {
+                serde_json::json!({
+                    "provider": m.provider,
+                    "base": m.base,
+                    "position": m.position,
+                    "healthy": m.healthy,
+                    "successes": m.successes,
+                    "failures": m.failures,
+                    "last_error": m.last_error,
+                })
+            })
+            .collect();
+        let diagnostics = serde_json::json!({
+            "data_root": health.data_root,
+            "data_root_writable": health.data_root_writable,
+            "data_root_free_bytes": health.data_root_free_bytes,
+            "ports": ports,
+            "frp": {
+                "tunnels": frp.tunnels,
+                "tunnels_running": frp.tunnels_running,
+                "proxies": frp.proxies,
+                "proxies_running": frp.proxies_running,
+            },
+            "dir_cache": {
+                "hits": dir_cache.hits,
+                "misses": dir_cache.misses,
+                "bypassed": dir_cache.bypassed,
+                "dirs": dir_cache.dirs,
+            },
+            "clock": {
+                "unix_ms": clock.unix_ms,
+                "timezone": clock.timezone,
+                "utc_offset_seconds": clock.utc_offset_seconds,
+                "ntp_server": clock.ntp_server,
+                "ntp_offset_ms": clock.has_ntp_offset.then_some(clock.ntp_offset_ms),
+                "ntp_error": clock.ntp_error,
+            },
+            "mirrors": mirrors,
+            "disabled_commands": crate::command_policy::disabled(),
+        });
+
+        let console_lines = match req.console_lines {
+            0 => crate::support_bundle::DEFAULT_CONSOLE_LINES,
+            n => (n as usize).min(crate::support_bundle::MAX_CONSOLE_LINES),
+        };
+        let opts = crate::support_bundle::Options {
+            instance_ids: req.instance_ids,
+            console_lines,
+            keep_ips: req.keep_ip_addresses,
+            diagnostics,
+        };
+        let bundle = tokio::task::spawn_blocking(move || crate::support_bundle::build(&opts))
+            .await
+            .map_err(|e| Status::internal(format!("support bundle task failed: {e}")))?
+            .map_err(|e| Status::internal(format!("support bundle failed: {e:#}")))?;
+
+        let data = if req.inline && bundle.size_bytes <= crate::support_bundle::INLINE_MAX_BYTES {
+            tokio::fs::read(&bundle.path)
+                .await
+                .map_err(|e| Status::internal(format!("failed to read bundle: {e}")))?
+        } else {
+            Vec::new()
+        };
+        let path = bundle
+            .path
+            .strip_prefix(crate::minecraft::data_root())
+            .unwrap_or(&bundle.path)
+            .to_string_lossy()
+            .replace('\\', "/");
+        tracing::info!(%path, size_bytes = bundle.size_bytes, "support bundle written");
+
+        Ok(Response::new(SupportBundleResponse {
+            path,
+            size_bytes: bundle.size_bytes,
+            files: bundle.files,
+            redactions: bundle.redactions as u32,
+            data,
+        }))
+    }
+
+    async fn self_test(
+        &self,
+        request: Request<SelfTestRequest>,
+    ) -> Result<Response<SelfTestResponse>, Status> {
+        let req = request.into_inner();
+        let port_start = match req.port_start {
+            0 => crate::selftest::DEFAULT_PORT_START,
+            p => u16::try_from(p)
+                .ok()
+                .filter(|p| *p >= 1024)
+                .ok_or_else(|| Status::invalid_argument("port_start must be in 1024-65535"))?,
+        };
+        let started = std::time::Instant::now();
+        let checks = crate::selftest::run(&crate::selftest::Options {
+            skip_network: req.skip_network,
+            port_start,
+        })
+        .await;
+        let ok = checks
+            .iter()
+            .all(|c| c.outcome != crate::selftest::Outcome::Fail);
+
+        Ok(Response::new(SelfTestResponse {
+            ok,
+            checks: checks
+                .into_iter()
+                .map(|c| SelfTestCheck {
+                    name: c.name,
+                    outcome: c.outcome.as_str().to_string(),
+                    message: c.message,
+                    duration_ms: c.duration_ms,
+                })
+                .collect(),
+            duration_ms: started.elapsed().as_millis() as u64,
+        }))
+    }
+
+    async fn set_log_level(
+        &self,
+        request: Request<SetLogLevelRequest>,
+    ) -> Result<Response<SetLogLevelResponse>, Status> {
+        let req = request.into_inner();
+        if req.filter.trim().is_empty() {
+            return Ok(Response::new(SetLogLevelResponse {
+                filter: crate::logging::current_filter(),
+                previous: String::new(),
+            }));
+        }
+        let previous = crate::logging::set_filter(&req.filter)
+            .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+        let filter = crate::logging::current_filter();
+        tracing::info!(%filter, %previous, "log filter changed");
+        Ok(Response::new(SetLogLevelResponse { filter, previous }))
+    }
+
+    async fn reload_backup_schedule(
+        &self,
+        _request: Request<ReloadBackupScheduleRequest>,
+    ) -> Result<Response<ReloadBackupScheduleResponse>, Status> {
+        let snapshot = crate::backup_schedule::reload().await;
+        Ok(Response::new(ReloadBackupScheduleResponse {
+            poll_every_sec: snapshot.poll_every.as_secs(),
+            schedules: snapshot
+                .entries
+                .into_iter()
+                .map(|e| BackupSchedule {
+                    instance_id: e.instance_id,
+                    interval_minutes: e.interval_minutes,
+                    keep: e.keep,
+                    global: e.global,
+                    next_due_unix_ms: e.next_due_unix_ms,
+                    last_run_unix_ms: e.last_run_unix_ms,
+                    last_result: e.last_result,
+                })
+                .collect(),
+            errors: snapshot
+                .errors
+                .into_iter()
+                .map(|e| BackupScheduleError {
+                    source: e.source,
+                    message: e.message,
+                })
+                .collect(),
+            changed: snapshot.changed,
+        }))
+    }
+
+    async fn node_info(
+        &self,
+        _request: Request<NodeInfoRequest>,
+    ) -> Result<Response<NodeInfoResponse>, Status> {
+        let identity = crate::node_identity::get();
+        let checkpoint_unsupported = tokio::task::spawn_blocking(crate::criu::unsupported_reason)
+            .await
+            .unwrap_or_else(|e| Some(format!("capability check failed: {e}")));
+        Ok(Response::new(NodeInfoResponse {
+            node_id: identity.id.clone(),
+            name: identity.name.clone(),
+            labels: identity.labels.clone().into_iter().collect(),
+            public_address: identity.public_address.clone().unwrap_or_default(),
+            agent_version: env!("CARGO_PKG_VERSION").to_string(),
+            os: std::env::consts::OS.to_string(),
+            arch: std::env::consts::ARCH.to_string(),
+            cpus: std::thread::available_parallelism()
+                .map(|n| n.get() as u32)
+                .unwrap_or(0),
+            data_root: crate::minecraft::data_root().display().to_string(),
+            checkpoint_supported: checkpoint_unsupported.is_none(),
+            checkpoint_unsupported_reason: checkpoint_unsupported.unwrap_or_default(),
+        }))
+    }
+
+    async fn placement(
+        &self,
+        request: Request<PlacementRequest>,
+    ) -> Result<Response<PlacementResponse>, Status> {
+        let req = request.into_inner();
+        let (cap, rec) = tokio::task::spawn_blocking(move || {
+            let cap = crate::placement::capacity();
+            let rec = crate::placement::evaluate(
+                &cap,
+                crate::placement::Demand {
+                    memory_mb: req.memory_mb,
+                    disk_mb: req.disk_mb,
+                    cpu_millicores: req.cpu_millicores,
+                },
+            );
+            (cap, rec)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("placement task failed: {e}")))?;
+        Ok(Response::new(PlacementResponse {
+            fits: rec.fits,
+            score: rec.score,
+            reasons: rec.reasons,
+            memory_budget_mb: cap.memory_budget_mb,
+            memory_available_mb: cap.memory_available_mb,
+            memory_committed_mb: cap.memory_committed_mb,
+            cpu_millicores: cap.cpu_millicores,
+            cpu_committed_millicores: cap.cpu_committed_millicores,
+            disk_usable_mb: cap.disk_usable_mb,
+            instances: cap.instances,
+        }))
+    }
 }
 
 pub fn server() -> AgentHealthServiceServer<HealthApi> {
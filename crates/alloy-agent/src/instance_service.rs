@@ -6,19 +6,39 @@ use std::{
 
 use alloy_proto::agent_v1::instance_service_server::{InstanceService, InstanceServiceServer};
 use alloy_proto::agent_v1::{
-    CreateInstanceRequest, CreateInstanceResponse, DeleteInstancePreviewRequest,
-    DeleteInstancePreviewResponse, DeleteInstanceRequest, DeleteInstanceResponse,
-    GetInstanceRequest, GetInstanceResponse, ImportSaveFromUrlRequest, ImportSaveFromUrlResponse,
-    InstanceConfig, InstanceInfo, ListInstancesRequest, ListInstancesResponse,
-    StartInstanceRequest, StartInstanceResponse, StopInstanceRequest, StopInstanceResponse,
-    UpdateInstanceRequest, UpdateInstanceResponse,
+    ApplyPropertyProfilesRequest, ApplyPropertyProfilesResponse, BackupInstanceRequest,
+    BackupInstanceResponse, BlockCount, BlockPosition, ConfigKey, CrashRecord,
+    CreateFromGoldenRequest, CreateFromGoldenResponse, CreateInstanceRequest,
+    CreateInstanceResponse, DeleteInstancePreviewRequest, DeleteInstancePreviewResponse,
+    DeleteInstanceRequest, DeleteInstanceResponse, DeletePropertyProfileRequest,
+    DeletePropertyProfileResponse, EnvFile, EnvReport, ExposeWebMapRequest, ExposeWebMapResponse,
+    FrpAdminRequest, FrpAdminResponse, FrpFailoverEvent, FrpProxySecurity, FrpProxyStats,
+    FrpProxyStatus, FrpSecurityPosture, GetConfigSchemaRequest, GetConfigSchemaResponse,
+    GetDivergenceRequest, GetDivergenceResponse, GetEnvReportRequest, GetEnvReportResponse,
+    GetFrpStatsRequest, GetFrpStatsResponse, GetFrpStatusRequest, GetFrpStatusResponse,
+    GetInstanceRequest, GetInstanceResponse, GetLastCrashRequest, GetLastCrashResponse,
+    GetPlayerInventoryRequest, GetPlayerInventoryResponse, GetPlayerStatsRequest,
+    GetPlayerStatsResponse, GetWebMapStatusRequest, GetWebMapStatusResponse,
+    ImportSaveFromUrlRequest, ImportSaveFromUrlResponse, InstallWebMapRequest,
+    InstallWebMapResponse, InstanceConfig, InstanceInfo, ListInstancesRequest,
+    ListInstancesResponse, ListManagedFilesRequest, ListManagedFilesResponse,
+    ListPlayerPositionsRequest, ListPlayerPositionsResponse, ListPropertyProfilesRequest,
+    ListPropertyProfilesResponse, ManageFileRequest, ManageFileResponse, ManagedFileStatus,
+    PerfAuditRequest, PerfAuditResponse, PerfFinding, PlayerPosition, PlayerStats, PropertyChange,
+    PropertyProfile, PropertyValue, RenderMapPreviewRequest, RenderMapPreviewResponse,
+    RestorePlayerDataRequest, RestorePlayerDataResponse, SavePropertyProfileRequest,
+    SavePropertyProfileResponse, SetGoldenRequest, SetGoldenResponse, StartInstanceRequest,
+    StartInstanceResponse, StopInstanceRequest, StopInstanceResponse, UnmanageFileRequest,
+    UnmanageFileResponse, UpdateInstanceRequest, UpdateInstanceResponse, ValidateStartRequest,
+    ValidateStartResponse, VerifyServerJarRequest, VerifyServerJarResponse, WebMapStatus,
 };
 use futures_util::StreamExt;
 use reqwest::Url;
 use tokio::io::AsyncWriteExt;
 use tonic::{Request, Response, Status};
 
-use crate::port_alloc;
+use crate::error_payload::{Localized, MessageKey};
+use crate::port_alloc::{self, PortProto};
 use crate::process_manager::ProcessManager;
 
 const INSTANCES_DIR: &str = "instances";
@@ -76,6 +96,14 @@ struct PersistedInstance {
     params: BTreeMap<String, String>,
     #[serde(default)]
     display_name: Option<String>,
+    // Golden images are clone sources only; see `golden`.
+    #[serde(default)]
+    golden: bool,
+    #[serde(default)]
+    derived_from: Option<String>,
+    // Files whose content the agent keeps pinned; see `managed_config`.
+    #[serde(default)]
+    managed_files: Vec<crate::managed_config::ManagedFile>,
 }
 
 impl PersistedInstance {
@@ -85,6 +113,10 @@ impl PersistedInstance {
             template_id: self.template_id.clone(),
             params: self.params.clone().into_iter().collect(),
             display_name: self.display_name.clone().unwrap_or_default(),
+            golden: self.golden,
+            derived_from: self.derived_from.clone().unwrap_or_default(),
+            node_id: crate::node_identity::get().id.clone(),
+            node_name: crate::node_identity::get().name.clone(),
         }
     }
 }
@@ -146,7 +178,75 @@ async fn ensure_instance_stopped(
     Ok(())
 }
 
+// A hibernation checkpoint is only valid for the files it was taken against. Resuming after a
+// stopped-only write would bring back the old JVM, which ignores the change and overwrites it on
+// its next save, so such writes drop the checkpoint and the next start is a normal boot.
+fn discard_checkpoint(instance_id: &str) {
+    if let Ok(dir) = instance_dir(instance_id)
+        && crate::criu::read_meta(&dir).is_some()
+    {
+        crate::criu::discard(&dir);
+        tracing::info!(
+            instance_id = %instance_id,
+            "hibernation checkpoint discarded: the instance changed while hibernated"
+        );
+    }
+}
+
+// For writes that need a stopped instance; see discard_checkpoint.
+async fn ensure_instance_writable(
+    manager: &ProcessManager,
+    instance_id: &str,
+) -> Result<(), Status> {
+    ensure_instance_stopped(manager, instance_id).await?;
+    discard_checkpoint(instance_id);
+    Ok(())
+}
+
+// Ids of the instances created from `golden_id`.
+async fn derived_instances(golden_id: &str) -> Result<Vec<String>, Status> {
+    let base = data_root().join(INSTANCES_DIR);
+    let mut rd = match tokio::fs::read_dir(&base).await {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => {
+            return Err(Status::internal(format!(
+                "failed to read instances dir: {e}"
+            )));
+        }
+    };
+    let mut out = Vec::new();
+    while let Some(de) = rd
+        .next_entry()
+        .await
+        .map_err(|e| Status::internal(format!("failed to read instances entry: {e}")))?
+    {
+        let name = de.file_name().to_string_lossy().to_string();
+        if let Ok(inst) = load_instance(&name).await
+            && inst.derived_from.as_deref() == Some(golden_id)
+        {
+            out.push(inst.instance_id);
+        }
+    }
+    out.sort();
+    Ok(out)
+}
+
 async fn ensure_persisted_ports(inst: &mut PersistedInstance) -> Result<(), Status> {
+    // `auto` bind addresses are picked once too, so the instance keeps its address.
+    if inst.template_id.starts_with("minecraft:")
+        && matches!(
+            crate::bind_pool::requested(&inst.params),
+            Ok(crate::bind_pool::Request::Auto)
+        )
+    {
+        let ip = crate::bind_pool::assign(&inst.instance_id)
+            .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        inst.params
+            .insert(crate::bind_pool::PARAM.to_string(), ip.to_string());
+        save_instance(inst).await?;
+    }
+
     // Only persist auto-assigned ports on first start.
     // This keeps connection info stable across restarts.
     match inst.template_id.as_str() {
@@ -157,7 +257,7 @@ async fn ensure_persisted_ports(inst: &mut PersistedInstance) -> Result<(), Stat
         | "terraria:vanilla" => {
             let current = inst.params.get("port").map(|s| s.trim()).unwrap_or("");
             if current.is_empty() || current == "0" {
-                let port = port_alloc::allocate_tcp_port(0)
+                let port = port_alloc::allocate_unreserved_port(PortProto::Tcp, &inst.instance_id)
                     .map_err(|e| Status::internal(format!("failed to allocate port: {e}")))?;
                 inst.params.insert("port".to_string(), port.to_string());
                 save_instance(inst).await?;
@@ -190,7 +290,7 @@ async fn ensure_persisted_ports(inst: &mut PersistedInstance) -> Result<(), Stat
                 // Best-effort: pick a unique UDP port.
                 let mut picked: Option<u16> = None;
                 for _ in 0..16 {
-                    let p = port_alloc::allocate_udp_port(0)
+                    let p = port_alloc::allocate_unreserved_port(PortProto::Udp, &inst.instance_id)
                         .map_err(|e| Status::internal(format!("failed to allocate port: {e}")))?;
                     if p == 0 || used.contains(&p) {
                         continue;
@@ -275,6 +375,180 @@ fn minecraft_level_rel(instance_dir: &Path) -> PathBuf {
     PathBuf::from("worlds/world")
 }
 
+// World directory of a Minecraft instance, from level-name in server.properties.
+fn minecraft_world_dir(inst: &PersistedInstance) -> Result<PathBuf, Status> {
+    if !inst.template_id.starts_with("minecraft:") {
+        return Err(Status::failed_precondition(
+            "instance is not a Minecraft server",
+        ));
+    }
+    let dir = instance_dir(&inst.instance_id).map_err(Status::from)?;
+    let level_rel = minecraft_level_rel(&dir);
+    let level_rel = normalize_rel_path(level_rel.to_string_lossy().as_ref())?;
+    Ok(dir.join(level_rel))
+}
+
+// Loader and game version a web map build must match: request overrides first, then what the
+// instance's files say.
+fn web_map_target(
+    inst: &PersistedInstance,
+    dir: &Path,
+    loader: &str,
+    minecraft_version: &str,
+) -> Result<(String, String), Status> {
+    let loader = match loader.trim().to_ascii_lowercase() {
+        l if !l.is_empty() => l,
+        _ => crate::minecraft_webmap::detect_loader(dir).ok_or_else(|| {
+            Status::failed_precondition(
+                "no mod loader found; web maps need a Fabric, Forge or NeoForge server",
+            )
+        })?,
+    };
+    let minecraft = match minecraft_version.trim() {
+        v if !v.is_empty() => v.to_string(),
+        _ => crate::minecraft_webmap::detect_minecraft_version(dir)
+            .or_else(|| {
+                inst.params
+                    .get("version")
+                    .map(|v| v.trim().to_string())
+                    .filter(|v| v.starts_with(|c: char| c.is_ascii_digit()))
+            })
+            .ok_or_else(|| {
+                Status::failed_precondition(
+                    "couldn't determine the Minecraft version; pass minecraft_version",
+                )
+            })?,
+    };
+    Ok((loader, minecraft))
+}
+
+async fn web_map_status(dir: &Path) -> WebMapStatus {
+    let Some(marker) = crate::minecraft_webmap::read_marker(dir) else {
+        return WebMapStatus::default();
+    };
+    let config_port = marker
+        .kind()
+        .and_then(|k| crate::minecraft_webmap::config_port(dir, k))
+        .unwrap_or(0);
+    let listening = marker.port != 0 && crate::minecraft_webmap::is_listening(marker.port).await;
+    let proxy = crate::frp::read_status(dir)
+        .await
+        .ok()
+        .flatten()
+        .and_then(|s| {
+            s.proxies
+                .into_iter()
+                .find(|p| p.configured_name == crate::minecraft_webmap::PROXY_NAME)
+        })
+        .map(|p| p.stats)
+        .unwrap_or_default();
+    let public_url = crate::minecraft_webmap::public_url(&marker);
+    let exposed = public_url.is_some();
+    WebMapStatus {
+        installed: true,
+        kind: marker.kind.clone(),
+        version_number: marker.version_number.clone(),
+        mod_path: rel_to_data_root(&dir.join("mods").join(&marker.filename)),
+        port: marker.port.into(),
+        config_port: config_port.into(),
+        listening,
+        local_url: format!("http://127.0.0.1:{}/", marker.port),
+        exposed,
+        public_url: public_url.unwrap_or_default(),
+        auth_user: if exposed {
+            crate::minecraft_webmap::AUTH_USER.to_string()
+        } else {
+            String::new()
+        },
+        auth_password: marker.auth_password.unwrap_or_default(),
+        proxy_state: proxy.state.unwrap_or_default(),
+        proxy_error: proxy.error.unwrap_or_default(),
+    }
+}
+
+// An archive written by Backup for this instance, given relative to the data root.
+fn instance_backup_path(instance_id: &str, rel: &str) -> Result<PathBuf, Status> {
+    let rel = normalize_rel_path(rel.trim())?;
+    if !rel.starts_with(Path::new("backups").join(instance_id))
+        || rel.extension().is_none_or(|e| e != "zip")
+    {
+        return Err(Status::invalid_argument(format!(
+            "backup_path must be a zip under backups/{instance_id}/"
+        )));
+    }
+    let path = data_root().join(rel);
+    if !path.is_file() {
+        return Err(Status::not_found("backup not found"));
+    }
+    Ok(path)
+}
+
+fn player_uuid(server_dir: &Path, player: &str) -> Result<(String, String), Status> {
+    if player.trim().is_empty() {
+        return Err(Status::invalid_argument("player must be non-empty"));
+    }
+    let names = crate::minecraft_players::user_names(server_dir);
+    let uuid = crate::minecraft_players::resolve_player(&names, player).ok_or_else(|| {
+        Status::not_found(format!(
+            "unknown player {:?} (not in usercache.json)",
+            player.trim()
+        ))
+    })?;
+    let name = names.get(&uuid).cloned().unwrap_or_default();
+    Ok((uuid, name))
+}
+
+fn inventory_items(items: Vec<crate::minecraft_players::Item>) -> Vec<InventoryItem> {
+    items
+        .into_iter()
+        .map(|i| InventoryItem {
+            slot: i.slot,
+            id: i.id,
+            count: i.count,
+            damage: i.damage,
+            custom_name: i.custom_name,
+            enchantments: i.enchantments,
+        })
+        .collect()
+}
+
+fn player_stats(
+    uuid: String,
+    name: String,
+    s: &crate::minecraft_players::Stats,
+    top_blocks: usize,
+) -> PlayerStats {
+    PlayerStats {
+        uuid,
+        name,
+        // Stats count game ticks (20 per second) and centimetres.
+        play_time_sec: s.play_time_ticks / 20,
+        deaths: s.deaths,
+        mob_kills: s.mob_kills,
+        player_kills: s.player_kills,
+        jumps: s.jumps,
+        distance_m: s.distance_cm / 100,
+        blocks_mined: s.blocks_mined,
+        items_crafted: s.items_crafted,
+        top_mined: s
+            .top_mined(top_blocks)
+            .into_iter()
+            .map(|(id, count)| BlockCount { id, count })
+            .collect(),
+        advancements_done: s.advancements_done,
+        error: String::new(),
+    }
+}
+
+fn block_position(b: crate::minecraft_players::BlockPos) -> BlockPosition {
+    BlockPosition {
+        dimension: b.dimension,
+        x: b.x,
+        y: b.y,
+        z: b.z,
+    }
+}
+
 fn extract_zip_safely(zip_path: &Path, out_dir: &Path) -> anyhow::Result<()> {
     std::fs::create_dir_all(out_dir)?;
     let f = std::fs::File::open(zip_path)?;
@@ -313,6 +587,87 @@ fn extract_zip_safely(zip_path: &Path, out_dir: &Path) -> anyhow::Result<()> {
     Ok(())
 }
 
+// Zips `src_dir` (relative names, symlinks skipped) plus the generated `extra` entries into
+// `out_path` via a temp file. Spans per phase (compress, sync, rename) show where a slow backup spends its time.
+#[tracing::instrument(
+    name = "backup.archive",
+    skip_all,
+    fields(
+        src = %src_dir.display(),
+        files = tracing::field::Empty,
+        bytes_in = tracing::field::Empty
+    )
+)]
+fn zip_dir(src_dir: &Path, out_path: &Path, extra: &[(&str, Vec<u8>)]) -> anyhow::Result<u64> {
+    use std::io::Write;
+
+    fn add(
+        zip: &mut zip::ZipWriter<std::fs::File>,
+        root: &Path,
+        dir: &Path,
+        opts: zip::write::SimpleFileOptions,
+        totals: &mut (u64, u64),
+    ) -> anyhow::Result<()> {
+        let mut entries = std::fs::read_dir(dir)?.flatten().collect::<Vec<_>>();
+        entries.sort_by_key(|e| e.file_name());
+        for e in entries {
+            let path = e.path();
+            let ft = e.file_type()?;
+            if ft.is_symlink() {
+                continue;
+            }
+            let rel = path
+                .strip_prefix(root)?
+                .to_string_lossy()
+                .replace('\\', "/");
+            if ft.is_dir() {
+                zip.add_directory(format!("{rel}/"), opts)?;
+                add(zip, root, &path, opts, totals)?;
+            } else if ft.is_file() {
+                zip.start_file(rel, opts)?;
+                let mut f = std::fs::File::open(&path)?;
+                totals.0 += 1;
+                totals.1 += std::io::copy(&mut f, zip)?;
+            }
+        }
+        Ok(())
+    }
+
+    if let Some(parent) = out_path.parent() {
+        std::fs::create_dir_all(parent)?;
+    }
+    let tmp_path = out_path.with_extension("zip.tmp");
+    let mut zip = zip::ZipWriter::new(std::fs::File::create(&tmp_path)?);
+    let opts = zip::write::SimpleFileOptions::default()
+        .compression_method(zip::CompressionMethod::Deflated)
+        .large_file(true);
+    let mut totals = (0, 0);
+    let res = tracing::info_span!("backup.compress")
+        .in_scope(|| add(&mut zip, src_dir, src_dir, opts, &mut totals))
+        .and_then(|()| {
+            for (name, data) in extra {
+                zip.start_file(*name, opts)?;
+                zip.write_all(data)?;
+            }
+            Ok(())
+        })
+        .and_then(|()| {
+            let _sync = tracing::info_span!("backup.sync").entered();
+            let f = zip.finish()?;
+            f.sync_all().ok();
+            Ok(())
+        });
+    tracing::Span::current()
+        .record("files", totals.0)
+        .record("bytes_in", totals.1);
+    if let Err(e) = res {
+        let _ = std::fs::remove_file(&tmp_path);
+        return Err(e);
+    }
+    tracing::info_span!("backup.rename").in_scope(|| std::fs::rename(&tmp_path, out_path))?;
+    Ok(std::fs::metadata(out_path)?.len())
+}
+
 fn file_magic_is_zip(path: &Path) -> bool {
     use std::io::Read;
 
@@ -471,6 +826,207 @@ fn find_dst_cluster_root(extracted_root: &Path) -> anyhow::Result<PathBuf> {
     Ok(hits.remove(0))
 }
 
+// A server.jar that no longer matches its recorded hash is not started. Vanilla jars are hard links
+// into the download cache, so that cache entry is dropped instead and start downloads a fresh copy.
+async fn verify_jar_before_start(id: &str, inst: &PersistedInstance) -> Result<(), Status> {
+    use crate::jar_provenance::State;
+
+    if !inst.template_id.starts_with("minecraft:") {
+        return Ok(());
+    }
+    let dir = instance_dir(id).map_err(Status::from)?;
+    let check = tokio::task::spawn_blocking(move || crate::jar_provenance::verify(&dir))
+        .await
+        .map_err(|e| Status::internal(format!("jar verify task failed: {e}")))?;
+    // An unreadable jar fails the start on its own.
+    let Ok(check) = check else {
+        return Ok(());
+    };
+    let Some(provenance) = check.provenance.filter(|_| check.state == State::Mismatch) else {
+        return Ok(());
+    };
+    let actual = check.actual_sha1.unwrap_or_default();
+
+    if inst.template_id == "minecraft:vanilla"
+        && provenance.provider == "mojang"
+        && provenance.sha1.len() == 40
+        && provenance.sha1.bytes().all(|b| b.is_ascii_hexdigit())
+    {
+        tracing::warn!(
+            instance_id = %id,
+            expected = %provenance.sha1,
+            actual = %actual,
+            "server.jar does not match Mojang's hash; dropping the cached copy"
+        );
+        let cached = crate::minecraft_download::cache_dir().join(&provenance.sha1);
+        let _ = tokio::fs::remove_dir_all(cached).await;
+        return Ok(());
+    }
+
+    Err(Status::failed_precondition(
+        crate::error_payload::encode_localized(
+            "jar_mismatch",
+            Localized::new(
+                MessageKey::new("jar.mismatch")
+                    .param("provider", &provenance.provider)
+                    .param("expected", &provenance.sha1)
+                    .param("actual", &actual),
+                format!(
+                    "server.jar does not match the hash recorded from {} (expected sha1 {}, got {actual})",
+                    provenance.provider, provenance.sha1
+                ),
+            ),
+            None,
+            Some(Localized::new(
+                MessageKey::new("hint.jar.mismatch"),
+                "Reinstall the server, or accept the current jar with instance.acceptJar.",
+            )),
+        ),
+    ))
+}
+
+// Zips a stopped instance into backups/<id>/ and publishes the backup event. `reason` is
+// "manual" for API calls and "scheduled" for backup_schedule.
+pub(crate) async fn backup_instance(
+    manager: &ProcessManager,
+    instance_id: &str,
+    reason: &str,
+) -> Result<BackupInstanceResponse, Status> {
+    let id = normalize_instance_id(instance_id).map_err(Status::from)?;
+
+    // A running server keeps writing its world; an archive taken now could be torn.
+    ensure_instance_stopped(manager, &id).await?;
+
+    let dir = instance_dir(&id).map_err(Status::from)?;
+    if tokio::fs::metadata(&dir).await.is_err() {
+        return Err(Status::not_found("instance not found"));
+    }
+
+    let now_ms = crate::console_audit::now_unix_ms();
+    // Measured on the monotonic clock, so a clock step during the backup can't distort it.
+    let started = std::time::Instant::now();
+    // Scheduled archives get their own name so pruning never touches one taken by hand.
+    let file_name = if reason == "scheduled" {
+        format!("{id}-scheduled-{now_ms}.zip")
+    } else {
+        format!("{id}-{now_ms}.zip")
+    };
+    let out_path = data_root().join("backups").join(&id).join(file_name);
+
+    // Minecraft backups carry an environment report (mc_env_report) so the restored server
+    // can be launched the same way later.
+    let inst = load_instance(&id).await?;
+    let env_report = inst.template_id.starts_with("minecraft:");
+    let size_bytes = tokio::task::spawn_blocking({
+        let id = id.clone();
+        let out_path = out_path.clone();
+        let span = tracing::Span::current();
+        move || {
+            span.in_scope(|| {
+                let mut extra = Vec::new();
+                if env_report {
+                    let report =
+                        crate::mc_env_report::generate(&id, &dir, &inst.template_id, inst.params);
+                    extra.push((
+                        crate::mc_env_report::FILE,
+                        serde_json::to_vec_pretty(&report)?,
+                    ));
+                }
+                zip_dir(&dir, &out_path, &extra)
+            })
+        }
+    })
+    .await
+    .map_err(|e| Status::internal(format!("backup task failed: {e}")))?
+    .map_err(|e| Status::internal(format!("backup failed: {e:#}")))?;
+
+    let duration_ms = started.elapsed().as_millis() as u64;
+    let path = rel_to_data_root(&out_path);
+    let mut event = serde_json::json!({
+        "instance_id": id,
+        "reason": reason,
+        "backup_path": path,
+        "size_bytes": size_bytes,
+        "created_at_unix_ms": now_ms,
+        "duration_ms": duration_ms,
+        "env_report": env_report,
+        "request_id": crate::trace::current(),
+    });
+    let clock = crate::clock::metadata();
+    let timezone = clock
+        .get("timezone")
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .to_string();
+    if let Some(obj) = event.as_object_mut() {
+        obj.extend(clock);
+    }
+    crate::topics::publish("events:backup", || event.clone());
+    crate::outbox::push("backup", event);
+
+    Ok(BackupInstanceResponse {
+        path,
+        size_bytes,
+        created_at_unix_ms: now_ms,
+        duration_ms,
+        timezone,
+    })
+}
+
+pub(crate) async fn start_instance(
+    manager: &ProcessManager,
+    instance_id: &str,
+) -> Result<alloy_process::ProcessStatus, Status> {
+    let id = normalize_instance_id(instance_id).map_err(Status::from)?;
+    let mut inst = load_instance(&id).await?;
+    if inst.golden {
+        // Running it would change the image every derived instance is compared against.
+        return Err(Status::failed_precondition(
+            "instance is a golden image; create an instance from it or unmark it first",
+        ));
+    }
+
+    // If ports were omitted/blank, assign once and persist.
+    ensure_persisted_ports(&mut inst).await?;
+
+    // Dynmap only writes its config on first start, so its port is applied on the next one.
+    if let Ok(dir) = instance_dir(&id)
+        && let Some(map) = crate::minecraft_webmap::read_marker(&dir)
+        && let Some(kind) = map.kind()
+        && let Err(e) = crate::minecraft_webmap::apply_config(&dir, kind, map.port)
+    {
+        tracing::warn!(
+            instance_id = %id,
+            error = %format!("{e:#}"),
+            "failed to apply web map port"
+        );
+    }
+
+    verify_jar_before_start(&id, &inst).await?;
+
+    // A hibernated server resumes from its checkpoint; if that fails it gets a normal start.
+    if let Ok(dir) = instance_dir(&id)
+        && let Some(meta) = crate::criu::read_meta(&dir)
+    {
+        if meta.template_id == inst.template_id {
+            match manager.resume(&id, &inst.params).await {
+                Ok(status) => return Ok(status),
+                Err(e) => tracing::warn!(
+                    instance_id = %id,
+                    error = %format!("{e:#}"),
+                    "resume from checkpoint failed; starting normally"
+                ),
+            }
+        }
+        crate::criu::discard(&dir);
+    }
+
+    manager
+        .start_from_template_with_process_id(&id, &inst.template_id, inst.params)
+        .await
+        .map_err(|e| Status::invalid_argument(e.to_string()))
+}
+
 #[derive(Debug, Clone)]
 pub struct InstanceApi {
     manager: ProcessManager,
@@ -499,6 +1055,10 @@ impl InstanceService for InstanceApi {
             &params,
         )
         .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        crate::frp::SecurityOptions::from_params(&params)
+            .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        crate::backup_schedule::validate_params(&params).map_err(Status::invalid_argument)?;
+        crate::player_milestones::validate_params(&params).map_err(Status::invalid_argument)?;
 
         let display_name = if req.display_name.trim().is_empty() {
             None
@@ -511,8 +1071,12 @@ impl InstanceService for InstanceApi {
             template_id: req.template_id,
             params,
             display_name,
+            golden: false,
+            derived_from: None,
+            managed_files: Vec::new(),
         };
         save_instance(&inst).await?;
+        crate::backup_schedule::wake();
 
         Ok(Response::new(CreateInstanceResponse {
             config: Some(inst.to_proto()),
@@ -590,17 +1154,7 @@ impl InstanceService for InstanceApi {
         request: Request<StartInstanceRequest>,
     ) -> Result<Response<StartInstanceResponse>, Status> {
         let req = request.into_inner();
-        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
-        let mut inst = load_instance(&id).await?;
-
-        // If ports were omitted/blank, assign once and persist.
-        ensure_persisted_ports(&mut inst).await?;
-
-        let status = self
-            .manager
-            .start_from_template_with_process_id(&id, &inst.template_id, inst.params)
-            .await
-            .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        let status = start_instance(&self.manager, &req.instance_id).await?;
 
         Ok(Response::new(StartInstanceResponse {
             status: Some(crate::process_service::map_status(status)),
@@ -613,7 +1167,7 @@ impl InstanceService for InstanceApi {
     ) -> Result<Response<ImportSaveFromUrlResponse>, Status> {
         let req = request.into_inner();
         let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
-        ensure_instance_stopped(&self.manager, &id).await?;
+        ensure_instance_writable(&self.manager, &id).await?;
 
         let url_raw = req.url.trim();
         if url_raw.is_empty() {
@@ -862,6 +1416,20 @@ impl InstanceService for InstanceApi {
             .as_ref()
             .map(|p| rel_to_data_root(p))
             .unwrap_or_default();
+        if !backup.is_empty() {
+            let mut event = serde_json::json!({
+                "instance_id": id,
+                "reason": "save_import",
+                "backup_path": backup,
+                "created_at_unix_ms": crate::console_audit::now_unix_ms(),
+                "request_id": crate::trace::current(),
+            });
+            if let Some(obj) = event.as_object_mut() {
+                obj.extend(crate::clock::metadata());
+            }
+            crate::topics::publish("events:backup", || event.clone());
+            crate::outbox::push("backup", event);
+        }
 
         Ok(Response::new(ImportSaveFromUrlResponse {
             ok: true,
@@ -909,6 +1477,17 @@ impl InstanceService for InstanceApi {
             return Err(Status::not_found("instance not found"));
         }
 
+        if load_instance(&id).await.is_ok_and(|inst| inst.golden) {
+            let derived = derived_instances(&id).await?;
+            if !derived.is_empty() {
+                return Err(Status::failed_precondition(format!(
+                    "golden image has {} derived instance(s): {}",
+                    derived.len(),
+                    derived.join(", ")
+                )));
+            }
+        }
+
         tokio::fs::remove_dir_all(&dir)
             .await
             .map_err(|e| Status::internal(format!("failed to delete instance: {e}")))?;
@@ -916,6 +1495,196 @@ impl InstanceService for InstanceApi {
         Ok(Response::new(DeleteInstanceResponse { ok: true }))
     }
 
+    async fn get_last_crash(
+        &self,
+        request: Request<GetLastCrashRequest>,
+    ) -> Result<Response<GetLastCrashResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+
+        // Game templates run under instances/<id>; generic templates under processes/<id>.
+        let dirs = [
+            instance_dir(&id).map_err(Status::from)?.join("crashes"),
+            data_root().join("processes").join(&id).join("crashes"),
+        ];
+        let last = crate::crash_journal::read_last(&dirs)
+            .await
+            .map_err(|e| Status::internal(format!("failed to read crash journal: {e:#}")))?;
+
+        let crash = last.map(|(path, r)| CrashRecord {
+            process_id: r.process_id,
+            template_id: r.template_id,
+            exited_at_unix_ms: r.exited_at_unix_ms,
+            runtime_ms: r.runtime_ms,
+            exit_code: r.exit_code.unwrap_or_default(),
+            has_exit_code: r.exit_code.is_some(),
+            signal: r.signal.unwrap_or_default(),
+            has_signal: r.signal.is_some(),
+            exit_category: r.exit_category.unwrap_or_default(),
+            exit_reason: r.exit_reason.unwrap_or_default(),
+            hs_err_path: r.hs_err_path.unwrap_or_default(),
+            lines: r.lines,
+            path: path.display().to_string(),
+            start_request_id: r.request_id.unwrap_or_default(),
+        });
+
+        Ok(Response::new(GetLastCrashResponse { crash }))
+    }
+
+    async fn get_frp_status(
+        &self,
+        request: Request<GetFrpStatusRequest>,
+    ) -> Result<Response<GetFrpStatusResponse>, Status> {
+        let req = request.into_inner();
+        let dir = instance_dir(&req.instance_id).map_err(Status::from)?;
+
+        let status = crate::frp::read_status(&dir)
+            .await
+            .map_err(|e| Status::internal(format!("failed to read frp status: {e:#}")))?;
+        let Some(status) = status else {
+            return Ok(Response::new(GetFrpStatusResponse::default()));
+        };
+        let failover = status.failover.unwrap_or_default();
+
+        Ok(Response::new(GetFrpStatusResponse {
+            configured: true,
+            state: status.state,
+            proxies: status
+                .proxies
+                .into_iter()
+                .map(|p| FrpProxyStatus {
+                    configured_name: p.configured_name,
+                    name: p.name,
+                    collisions: p.collisions,
+                })
+                .collect(),
+            last_error: status.last_error.unwrap_or_default(),
+            updated_at_unix_ms: status.updated_at_unix_ms,
+            endpoints: failover.endpoints,
+            active_endpoint: failover.active,
+            failovers: failover
+                .history
+                .into_iter()
+                .map(|e| FrpFailoverEvent {
+                    at_unix_ms: e.at_unix_ms,
+                    from: e.from,
+                    to: e.to,
+                    reason: e.reason,
+                })
+                .collect(),
+            security: status.security.map(|sec| FrpSecurityPosture {
+                tls_enable: sec.tls_enable.unwrap_or(true),
+                tls_explicit: sec.tls_enable.is_some(),
+                level: sec.level,
+                proxies: sec
+                    .proxies
+                    .into_iter()
+                    .map(|p| FrpProxySecurity {
+                        name: p.name,
+                        use_encryption: p.use_encryption,
+                        use_compression: p.use_compression,
+                    })
+                    .collect(),
+                warnings: sec.warnings,
+            }),
+        }))
+    }
+
+    async fn get_frp_stats(
+        &self,
+        request: Request<GetFrpStatsRequest>,
+    ) -> Result<Response<GetFrpStatsResponse>, Status> {
+        let req = request.into_inner();
+        let dir = instance_dir(&req.instance_id).map_err(Status::from)?;
+
+        let status = crate::frp::read_status(&dir)
+            .await
+            .map_err(|e| Status::internal(format!("failed to read frp status: {e:#}")))?;
+        let Some(status) = status else {
+            return Ok(Response::new(GetFrpStatsResponse::default()));
+        };
+
+        Ok(Response::new(GetFrpStatsResponse {
+            configured: true,
+            proxies: status
+                .proxies
+                .into_iter()
+                .map(|p| FrpProxyStats {
+                    name: p.name,
+                    proxy_type: p.stats.proxy_type.unwrap_or_default(),
+                    state: p.stats.state.unwrap_or_default(),
+                    remote_addr: p.stats.remote_addr.unwrap_or_default(),
+                    error: p.stats.error.unwrap_or_default(),
+                    has_counters: p.stats.cur_conns.is_some(),
+                    cur_conns: p.stats.cur_conns.unwrap_or(0),
+                    traffic_in_bytes: p.stats.traffic_in_bytes.unwrap_or(0),
+                    traffic_out_bytes: p.stats.traffic_out_bytes.unwrap_or(0),
+                    last_heartbeat_unix_ms: p.stats.last_heartbeat_unix_ms.unwrap_or(0),
+                })
+                .collect(),
+            sampled_at_unix_ms: status.stats_updated_at_unix_ms.unwrap_or(0),
+        }))
+    }
+
+    async fn frp_admin(
+        &self,
+        request: Request<FrpAdminRequest>,
+    ) -> Result<Response<FrpAdminResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+
+        match req.action.trim() {
+            "status" => {
+                let api = crate::frp::read_admin_api(&dir)
+                    .await
+                    .map_err(|e| Status::internal(format!("{e:#}")))?
+                    .ok_or_else(|| {
+                        Status::failed_precondition(
+                            "frpc admin api is not enabled on a loopback address",
+                        )
+                    })?;
+                let output = crate::frp::admin_status(&api)
+                    .await
+                    .map_err(|e| Status::unavailable(format!("{e:#}")))?;
+                Ok(Response::new(FrpAdminResponse { output }))
+            }
+            "reload" => {
+                let new_config = req.frp_config.trim().to_string();
+                let config = if new_config.is_empty() {
+                    load_instance(&id)
+                        .await?
+                        .params
+                        .get("frp_config")
+                        .map(|v| v.trim().to_string())
+                        .unwrap_or_default()
+                } else {
+                    new_config.clone()
+                };
+                if config.is_empty() {
+                    return Err(Status::failed_precondition("instance has no frp_config"));
+                }
+
+                crate::frp::request_reload(&dir, config)
+                    .await
+                    .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+
+                // Persist so the next start uses the reloaded config.
+                if !new_config.is_empty() {
+                    let mut inst = load_instance(&id).await?;
+                    inst.params.insert("frp_config".to_string(), new_config);
+                    save_instance(&inst).await?;
+                }
+                Ok(Response::new(FrpAdminResponse {
+                    output: "reloaded".to_string(),
+                }))
+            }
+            other => Err(Status::invalid_argument(format!(
+                "unknown frp admin action: {other}"
+            ))),
+        }
+    }
+
     async fn delete_preview(
         &self,
         request: Request<DeleteInstancePreviewRequest>,
@@ -972,6 +1741,16 @@ impl InstanceService for InstanceApi {
         }))
     }
 
+    async fn backup(
+        &self,
+        request: Request<BackupInstanceRequest>,
+    ) -> Result<Response<BackupInstanceResponse>, Status> {
+        let req = request.into_inner();
+        backup_instance(&self.manager, &req.instance_id, "manual")
+            .await
+            .map(Response::new)
+    }
+
     async fn update(
         &self,
         request: Request<UpdateInstanceRequest>,
@@ -980,7 +1759,7 @@ impl InstanceService for InstanceApi {
         let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
 
         // Refuse updates while running to avoid inconsistent config vs process.
-        ensure_instance_stopped(&self.manager, &id).await?;
+        ensure_instance_writable(&self.manager, &id).await?;
 
         let mut inst = load_instance(&id).await?;
         inst.params = req.params.into_iter().collect();
@@ -997,16 +1776,1138 @@ impl InstanceService for InstanceApi {
             &inst.params,
         )
         .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        crate::frp::SecurityOptions::from_params(&inst.params)
+            .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        crate::backup_schedule::validate_params(&inst.params).map_err(Status::invalid_argument)?;
+        crate::player_milestones::validate_params(&inst.params)
+            .map_err(Status::invalid_argument)?;
 
         // If ports were omitted/blank, assign once and persist.
         ensure_persisted_ports(&mut inst).await?;
 
         save_instance(&inst).await?;
+        crate::backup_schedule::wake();
 
         Ok(Response::new(UpdateInstanceResponse {
             config: Some(inst.to_proto()),
         }))
     }
+
+    async fn set_golden(
+        &self,
+        request: Request<SetGoldenRequest>,
+    ) -> Result<Response<SetGoldenResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+
+        ensure_instance_stopped(&self.manager, &id).await?;
+
+        let mut inst = load_instance(&id).await?;
+        if req.golden && inst.derived_from.is_some() {
+            return Err(Status::failed_precondition(
+                "instance is derived from another golden image",
+            ));
+        }
+        inst.golden = req.golden;
+        save_instance(&inst).await?;
+
+        Ok(Response::new(SetGoldenResponse {
+            config: Some(inst.to_proto()),
+        }))
+    }
+
+    async fn create_from_golden(
+        &self,
+        request: Request<CreateFromGoldenRequest>,
+    ) -> Result<Response<CreateFromGoldenResponse>, Status> {
+        let req = request.into_inner();
+        let golden_id = normalize_instance_id(&req.golden_instance_id).map_err(Status::from)?;
+        let golden = load_instance(&golden_id).await?;
+        if !golden.golden {
+            return Err(Status::failed_precondition(
+                "instance is not marked as a golden image",
+            ));
+        }
+        ensure_instance_stopped(&self.manager, &golden_id).await?;
+
+        let instance_id = alloy_process::ProcessId::new().0;
+        let src = instance_dir(&golden_id).map_err(Status::from)?;
+        let dst = instance_dir(&instance_id).map_err(Status::from)?;
+
+        let started = std::time::Instant::now();
+        let stats = tokio::task::spawn_blocking({
+            let dst = dst.clone();
+            move || crate::golden::clone_tree(&src, &dst)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("clone task failed: {e}")))?
+        .map_err(|e| Status::internal(format!("failed to clone golden image: {e}")))?;
+        let elapsed_ms = started.elapsed().as_millis() as u64;
+
+        // The clone must not come up on the golden image's ports; fresh ones are assigned on
+        // first start.
+        let mut params = golden.params.clone();
+        for k in ["port", "master_port", "auth_port"] {
+            if let Some(v) = params.get_mut(k) {
+                v.clear();
+            }
+        }
+        // Same for a web map; its proxy stays with the golden image's domain.
+        if let Some(mut map) = crate::minecraft_webmap::read_marker(&dst)
+            && let Some(kind) = map.kind()
+        {
+            let moved = port_alloc::allocate_unreserved_port(PortProto::Tcp, &instance_id)
+                .and_then(|port| {
+                    map.port = port;
+                    map.domain = None;
+                    map.public_port = 0;
+                    map.auth_password = None;
+                    crate::minecraft_webmap::apply_config(&dst, kind, port)?;
+                    crate::minecraft_webmap::write_marker(&dst, &map)
+                });
+            if let Err(e) = moved {
+                let _ = tokio::fs::remove_dir_all(&dst).await;
+                return Err(Status::internal(format!(
+                    "failed to move web map port: {e:#}"
+                )));
+            }
+            if let Some(frp_config) = params.get_mut("frp_config") {
+                *frp_config =
+                    crate::frp::remove_proxy(frp_config, crate::minecraft_webmap::PROXY_NAME)
+                        .trim()
+                        .to_string();
+            }
+        }
+        let display_name = if req.display_name.trim().is_empty() {
+            None
+        } else {
+            Some(req.display_name)
+        };
+
+        let inst = PersistedInstance {
+            instance_id,
+            template_id: golden.template_id,
+            params,
+            display_name,
+            golden: false,
+            derived_from: Some(golden_id),
+            managed_files: golden.managed_files,
+        };
+        if let Err(status) = save_instance(&inst).await {
+            let _ = tokio::fs::remove_dir_all(&dst).await;
+            return Err(status);
+        }
+        crate::dir_cache::clear();
+
+        tracing::info!(
+            instance_id = %inst.instance_id,
+            golden = inst.derived_from.as_deref().unwrap_or_default(),
+            reflinked = stats.reflinked_files,
+            linked = stats.linked_files,
+            copied = stats.copied_files,
+            elapsed_ms,
+            "instance created from golden image"
+        );
+
+        Ok(Response::new(CreateFromGoldenResponse {
+            config: Some(inst.to_proto()),
+            reflinked_files: stats.reflinked_files,
+            linked_files: stats.linked_files,
+            copied_files: stats.copied_files,
+            size_bytes: stats.size_bytes,
+            elapsed_ms,
+        }))
+    }
+
+    async fn get_divergence(
+        &self,
+        request: Request<GetDivergenceRequest>,
+    ) -> Result<Response<GetDivergenceResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let Some(golden_id) = inst.derived_from else {
+            return Err(Status::failed_precondition(
+                "instance was not created from a golden image",
+            ));
+        };
+
+        let golden_dir = instance_dir(&golden_id).map_err(Status::from)?;
+        if tokio::fs::metadata(&golden_dir).await.is_err() {
+            return Err(Status::not_found(format!(
+                "golden image {golden_id} no longer exists"
+            )));
+        }
+        let dir = instance_dir(&id).map_err(Status::from)?;
+
+        let d = tokio::task::spawn_blocking(move || crate::golden::divergence(&golden_dir, &dir))
+            .await
+            .map_err(|e| Status::internal(format!("divergence task failed: {e}")))?
+            .map_err(|e| Status::internal(format!("failed to compare with golden image: {e}")))?;
+
+        Ok(Response::new(GetDivergenceResponse {
+            golden_instance_id: golden_id,
+            shared_bytes: d.shared_bytes,
+            diverged_bytes: d.diverged_bytes,
+            diverged_files: d.diverged_files,
+            removed_files: d.removed_files,
+        }))
+    }
+
+    async fn render_map_preview(
+        &self,
+        request: Request<RenderMapPreviewRequest>,
+    ) -> Result<Response<RenderMapPreviewResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let world = minecraft_world_dir(&inst)?;
+        let dim = crate::minecraft_map::Dimension::parse(&req.dimension).ok_or_else(|| {
+            Status::invalid_argument("dimension must be overworld, nether or end")
+        })?;
+        if req.zoom > crate::minecraft_map::MAX_ZOOM {
+            return Err(Status::invalid_argument(format!(
+                "zoom must be at most {}",
+                crate::minecraft_map::MAX_ZOOM
+            )));
+        }
+        let bounds = if req.has_bounds {
+            if req.min_x > req.max_x || req.min_z > req.max_z {
+                return Err(Status::invalid_argument("bounds: min must not exceed max"));
+            }
+            Some(crate::minecraft_map::Bounds {
+                min_x: req.min_x,
+                min_z: req.min_z,
+                max_x: req.max_x,
+                max_z: req.max_z,
+            })
+        } else {
+            None
+        };
+
+        // Reading region files while the server saves can catch a chunk mid-write; such chunks
+        // come out flat instead of failing the preview.
+        let out_path = instance_dir(&id)
+            .map_err(Status::from)?
+            .join("_exports")
+            .join(format!("map-{}.png", dim.as_str()));
+        let (preview, seed) = tokio::task::spawn_blocking({
+            let out_path = out_path.clone();
+            move || -> anyhow::Result<_> {
+                let preview = crate::minecraft_map::render(&world, dim, bounds, req.zoom)?;
+                if let Some(parent) = out_path.parent() {
+                    std::fs::create_dir_all(parent)?;
+                }
+                let tmp = out_path.with_extension("png.tmp");
+                std::fs::write(&tmp, &preview.png)?;
+                std::fs::rename(&tmp, &out_path)?;
+                Ok((preview, crate::minecraft_map::world_seed(&world)))
+            }
+        })
+        .await
+        .map_err(|e| Status::internal(format!("map preview task failed: {e}")))?
+        .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+        crate::dir_cache::clear();
+
+        Ok(Response::new(RenderMapPreviewResponse {
+            path: rel_to_data_root(&out_path),
+            png: preview.png,
+            width: preview.width,
+            height: preview.height,
+            zoom: preview.zoom,
+            min_x: preview.bounds.min_x,
+            min_z: preview.bounds.min_z,
+            max_x: preview.bounds.max_x,
+            max_z: preview.bounds.max_z,
+            chunks_rendered: preview.chunks_rendered,
+            chunks_flat: preview.chunks_flat,
+            dimension: dim.as_str().to_string(),
+            seed: seed.unwrap_or_default(),
+            has_seed: seed.is_some(),
+        }))
+    }
+
+    async fn install_web_map(
+        &self,
+        request: Request<InstallWebMapRequest>,
+    ) -> Result<Response<InstallWebMapResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        minecraft_world_dir(&inst)?;
+        let kind = crate::minecraft_webmap::Kind::parse(&req.kind)
+            .ok_or_else(|| Status::invalid_argument("kind must be dynmap, bluemap or squaremap"))?;
+        let port = u16::try_from(req.port)
+            .map_err(|_| Status::invalid_argument("port must be at most 65535"))?;
+        ensure_instance_writable(&self.manager, &id).await?;
+
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let (loader, minecraft) = web_map_target(&inst, &dir, &req.loader, &req.minecraft_version)?;
+        let port = match (port, crate::minecraft_webmap::read_marker(&dir)) {
+            (0, Some(prev)) if prev.port != 0 => prev.port,
+            (0, _) => port_alloc::allocate_unreserved_port(PortProto::Tcp, &id)
+                .map_err(|e| Status::internal(format!("failed to allocate port: {e}")))?,
+            (p, _) => p,
+        };
+
+        let marker = crate::minecraft_webmap::install(&dir, kind, &loader, &minecraft, port)
+            .await
+            .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+        crate::dir_cache::clear();
+        tracing::info!(
+            instance_id = %id,
+            kind = kind.as_str(),
+            version = %marker.version_number,
+            port,
+            "web map installed"
+        );
+
+        // An existing proxy has to follow the new port.
+        if let (Some(domain), Some(password)) = (&marker.domain, &marker.auth_password)
+            && let Some(frp_config) = inst.params.get("frp_config")
+        {
+            let body = crate::minecraft_webmap::proxy_body(port, domain, password);
+            let updated =
+                crate::frp::upsert_proxy(frp_config, crate::minecraft_webmap::PROXY_NAME, &body);
+            let mut inst = inst;
+            inst.params
+                .insert("frp_config".to_string(), updated.trim().to_string());
+            save_instance(&inst).await?;
+        }
+
+        Ok(Response::new(InstallWebMapResponse {
+            status: Some(web_map_status(&dir).await),
+        }))
+    }
+
+    async fn expose_web_map(
+        &self,
+        request: Request<ExposeWebMapRequest>,
+    ) -> Result<Response<ExposeWebMapResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let mut inst = load_instance(&id).await?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let mut marker = crate::minecraft_webmap::read_marker(&dir)
+            .ok_or_else(|| Status::failed_precondition("no web map installed"))?;
+        let frp_config = inst
+            .params
+            .get("frp_config")
+            .map(|v| v.trim().to_string())
+            .filter(|v| !v.is_empty())
+            .ok_or_else(|| Status::failed_precondition("instance has no frp_config"))?;
+
+        let new_config = if req.remove {
+            marker.domain = None;
+            marker.public_port = 0;
+            marker.auth_password = None;
+            crate::frp::remove_proxy(&frp_config, crate::minecraft_webmap::PROXY_NAME)
+        } else {
+            let domain = req.domain.trim().to_ascii_lowercase();
+            if !crate::minecraft_webmap::valid_domain(&domain) {
+                return Err(Status::invalid_argument("domain must be a DNS name"));
+            }
+            let public_port = u16::try_from(req.public_port)
+                .map_err(|_| Status::invalid_argument("public_port must be at most 65535"))?;
+            // Keep the password across re-exposes so shared links keep working.
+            let password = marker
+                .auth_password
+                .clone()
+                .unwrap_or_else(crate::frp::random_token);
+            let body = crate::minecraft_webmap::proxy_body(marker.port, &domain, &password);
+            marker.domain = Some(domain);
+            marker.public_port = public_port;
+            marker.auth_password = Some(password);
+            crate::frp::upsert_proxy(&frp_config, crate::minecraft_webmap::PROXY_NAME, &body)
+        };
+        let new_config = new_config.trim().to_string();
+
+        let running = matches!(
+            self.manager.get_status(&id).await.map(|st| st.state),
+            Some(alloy_process::ProcessState::Running)
+        );
+        if running {
+            crate::frp::request_reload(&dir, new_config.clone())
+                .await
+                .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+        }
+        crate::minecraft_webmap::write_marker(&dir, &marker)
+            .map_err(|e| Status::internal(format!("failed to write web map state: {e:#}")))?;
+        inst.params.insert("frp_config".to_string(), new_config);
+        save_instance(&inst).await?;
+
+        Ok(Response::new(ExposeWebMapResponse {
+            status: Some(web_map_status(&dir).await),
+            reloaded: running,
+        }))
+    }
+
+    async fn get_web_map_status(
+        &self,
+        request: Request<GetWebMapStatusRequest>,
+    ) -> Result<Response<GetWebMapStatusResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        load_instance(&id).await?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        Ok(Response::new(GetWebMapStatusResponse {
+            status: Some(web_map_status(&dir).await),
+        }))
+    }
+
+    async fn list_player_positions(
+        &self,
+        request: Request<ListPlayerPositionsRequest>,
+    ) -> Result<Response<ListPlayerPositionsResponse>, Status> {
+        use crate::minecraft_players as players;
+
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let world = minecraft_world_dir(&inst)?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+
+        let players = tokio::task::spawn_blocking(move || -> Result<_, Status> {
+            let names = players::user_names(&dir);
+            let wanted = match req.player.trim() {
+                "" => None,
+                q => Some(players::resolve_player(&names, q).ok_or_else(|| {
+                    Status::not_found(format!("unknown player {q:?} (not in usercache.json)"))
+                })?),
+            };
+            let files = players::playerdata_files(&world)
+                .map_err(|e| Status::internal(format!("failed to list playerdata: {e}")))?;
+
+            let mut out = Vec::new();
+            for (uuid, path) in files {
+                if wanted.as_ref().is_some_and(|w| *w != uuid) {
+                    continue;
+                }
+                let mut p = PlayerPosition {
+                    name: names.get(&uuid).cloned().unwrap_or_default(),
+                    uuid,
+                    saved_at_unix_ms: players::saved_at_unix_ms(&path),
+                    ..Default::default()
+                };
+                match players::read_position(&path) {
+                    Ok(pos) => {
+                        p.dimension = pos.dimension;
+                        p.x = pos.pos.x;
+                        p.y = pos.pos.y;
+                        p.z = pos.pos.z;
+                        p.yaw = pos.yaw;
+                        p.pitch = pos.pitch;
+                        p.health = pos.health;
+                        p.xp_level = pos.xp_level;
+                        p.game_mode = pos.game_mode;
+                        p.last_death = pos.last_death.map(block_position);
+                        p.respawn = pos.respawn.map(block_position);
+                    }
+                    Err(e) => p.error = format!("{e:#}"),
+                }
+                out.push(p);
+            }
+            if wanted.is_some() && out.is_empty() {
+                return Err(Status::not_found("player has no saved data in this world"));
+            }
+            Ok(out)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("playerdata task failed: {e}")))??;
+
+        Ok(Response::new(ListPlayerPositionsResponse { players }))
+    }
+
+    async fn get_player_inventory(
+        &self,
+        request: Request<GetPlayerInventoryRequest>,
+    ) -> Result<Response<GetPlayerInventoryResponse>, Status> {
+        use crate::minecraft_players as players;
+
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let world = minecraft_world_dir(&inst)?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let backup_rel = req.backup_path.trim().to_string();
+        let backup = match backup_rel.as_str() {
+            "" => None,
+            rel => Some(instance_backup_path(&id, rel)?),
+        };
+
+        let (uuid, name, inv) = tokio::task::spawn_blocking(move || -> Result<_, Status> {
+            let (uuid, name) = player_uuid(&dir, &req.player)?;
+            let root = match &backup {
+                Some(archive) => {
+                    let world_rel = world.strip_prefix(&dir).unwrap_or(&world);
+                    players::playerdata_from_backup(archive, world_rel, &uuid)
+                        .and_then(|raw| players::parse_playerdata(&raw))
+                }
+                None => {
+                    let path = world.join("playerdata").join(format!("{uuid}.dat"));
+                    if !path.is_file() {
+                        return Err(Status::not_found("player has no saved data in this world"));
+                    }
+                    crate::nbt::read_file(&path)
+                }
+            }
+            .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+            Ok((uuid, name, players::inventory(&root)))
+        })
+        .await
+        .map_err(|e| Status::internal(format!("playerdata task failed: {e}")))??;
+
+        Ok(Response::new(GetPlayerInventoryResponse {
+            uuid,
+            name,
+            backup_path: backup_rel,
+            items: inventory_items(inv.items),
+            ender_items: inventory_items(inv.ender_items),
+            xp_level: inv.xp_level,
+        }))
+    }
+
+    async fn restore_player_data(
+        &self,
+        request: Request<RestorePlayerDataRequest>,
+    ) -> Result<Response<RestorePlayerDataResponse>, Status> {
+        use crate::minecraft_players as players;
+
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let world = minecraft_world_dir(&inst)?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let backup_rel = req.backup_path.trim().to_string();
+        if backup_rel.is_empty() {
+            return Err(Status::invalid_argument("backup_path must be non-empty"));
+        }
+        let archive = instance_backup_path(&id, &backup_rel)?;
+
+        // A running server holds the player in memory and would overwrite the file on save.
+        ensure_instance_writable(&self.manager, &id).await?;
+
+        let resp = tokio::task::spawn_blocking(move || -> Result<_, Status> {
+            let (uuid, _) = player_uuid(&dir, &req.player)?;
+            let world_rel = world.strip_prefix(&dir).unwrap_or(&world);
+            let raw = players::playerdata_from_backup(&archive, world_rel, &uuid)
+                .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+            // Refuse to write back something the server couldn't load.
+            let inv = players::parse_playerdata(&raw)
+                .map(|root| players::inventory(&root))
+                .map_err(|e| Status::failed_precondition(format!("backup playerdata: {e:#}")))?;
+
+            let write = || -> std::io::Result<Option<PathBuf>> {
+                let pd = world.join("playerdata");
+                std::fs::create_dir_all(&pd)?;
+                let target = pd.join(format!("{uuid}.dat"));
+                let previous = if target.is_file() {
+                    let now_ms = std::time::SystemTime::now()
+                        .duration_since(std::time::UNIX_EPOCH)
+                        .map(|d| d.as_millis() as u64)
+                        .unwrap_or(0);
+                    let keep = pd.join(format!("{uuid}.dat.pre-restore-{now_ms}"));
+                    std::fs::copy(&target, &keep)?;
+                    Some(keep)
+                } else {
+                    None
+                };
+                let tmp = pd.join(format!("{uuid}.dat.alloy-tmp"));
+                std::fs::write(&tmp, &raw)?;
+                std::fs::rename(&tmp, &target)?;
+                Ok(previous)
+            };
+            let previous = write()
+                .map_err(|e| Status::internal(format!("failed to write playerdata: {e}")))?;
+
+            Ok(RestorePlayerDataResponse {
+                uuid,
+                previous_path: previous.map(|p| rel_to_data_root(&p)).unwrap_or_default(),
+                items: inv.items.len() as u32,
+                ender_items: inv.ender_items.len() as u32,
+            })
+        })
+        .await
+        .map_err(|e| Status::internal(format!("playerdata task failed: {e}")))??;
+        crate::dir_cache::clear();
+
+        tracing::info!(
+            instance_id = %id,
+            uuid = %resp.uuid,
+            backup = %backup_rel,
+            "playerdata restored from backup"
+        );
+        Ok(Response::new(resp))
+    }
+
+    async fn get_player_stats(
+        &self,
+        request: Request<GetPlayerStatsRequest>,
+    ) -> Result<Response<GetPlayerStatsResponse>, Status> {
+        use crate::minecraft_players as players;
+
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let world = minecraft_world_dir(&inst)?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let top_blocks = match req.top_blocks {
+            0 => 5,
+            n => n.min(100) as usize,
+        };
+
+        let resp = tokio::task::spawn_blocking(move || -> Result<_, Status> {
+            let names = players::user_names(&dir);
+            let uuids = match req.player.trim() {
+                "" => players::stats_players(&world),
+                _ => vec![player_uuid(&dir, &req.player)?.0],
+            };
+
+            let mut totals = players::Stats::default();
+            let mut out = Vec::with_capacity(uuids.len());
+            for uuid in uuids {
+                let name = names.get(&uuid).cloned().unwrap_or_default();
+                match players::read_stats(&world, &uuid) {
+                    Ok(stats) => {
+                        totals.add(&stats);
+                        out.push(player_stats(uuid, name, &stats, top_blocks));
+                    }
+                    Err(e) => out.push(PlayerStats {
+                        uuid,
+                        name,
+                        error: format!("{e:#}"),
+                        ..Default::default()
+                    }),
+                }
+            }
+            Ok(GetPlayerStatsResponse {
+                players: out,
+                totals: Some(player_stats(
+                    String::new(),
+                    String::new(),
+                    &totals,
+                    top_blocks,
+                )),
+            })
+        })
+        .await
+        .map_err(|e| Status::internal(format!("player stats task failed: {e}")))??;
+
+        Ok(Response::new(resp))
+    }
+
+    async fn validate_start(
+        &self,
+        request: Request<ValidateStartRequest>,
+    ) -> Result<Response<ValidateStartResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        if !inst.template_id.starts_with("minecraft:") {
+            return Err(Status::failed_precondition(
+                "validate-only start supports Minecraft servers only",
+            ));
+        }
+        let dir = instance_dir(&id).map_err(Status::from)?;
+
+        // Vanilla installs server.jar on start, so probe the cached download it would use.
+        let jar = if inst.template_id == "minecraft:vanilla" {
+            let mc = crate::minecraft::validate_vanilla_params(&inst.params)
+                .map_err(|e| Status::invalid_argument(e.to_string()))?;
+            let resolved = crate::minecraft_download::resolve_server_jar(&mc.version)
+                .await
+                .map_err(|e| {
+                    Status::unavailable(format!("failed to resolve minecraft server jar: {e}"))
+                })?;
+            crate::minecraft_download::ensure_server_jar(&resolved)
+                .await
+                .map_err(|e| {
+                    Status::unavailable(format!("failed to download minecraft server jar: {e}"))
+                })?
+        } else if dir.join("server.jar").is_file() {
+            dir.join("server.jar")
+        } else if dir.join("libraries").is_dir() {
+            return Ok(Response::new(ValidateStartResponse {
+                ok: true,
+                verdict: crate::minecraft_probe::Verdict::Inconclusive
+                    .as_str()
+                    .to_string(),
+                message: "this server pack launches through unix_args.txt and cannot be probed"
+                    .to_string(),
+                ..Default::default()
+            }));
+        } else {
+            return Err(Status::failed_precondition(
+                "server.jar is not installed yet; start the instance once to install it",
+            ));
+        };
+
+        let probe = crate::minecraft_probe::probe(jar.clone())
+            .await
+            .map_err(|e| Status::internal(format!("launch probe failed: {e:#}")))?;
+        tracing::info!(
+            instance_id = %id,
+            verdict = probe.verdict.as_str(),
+            network_isolated = probe.network_isolated,
+            duration_ms = probe.duration_ms,
+            "launch probe finished"
+        );
+        Ok(Response::new(ValidateStartResponse {
+            ok: probe.verdict.ok(),
+            verdict: probe.verdict.as_str().to_string(),
+            message: probe.message,
+            jar_path: rel_to_data_root(&jar),
+            main_class: probe.main_class.unwrap_or_default(),
+            exit_code: probe.exit_code.unwrap_or_default(),
+            has_exit_code: probe.exit_code.is_some(),
+            timed_out: probe.timed_out,
+            network_isolated: probe.network_isolated,
+            duration_ms: probe.duration_ms,
+            output: probe.output,
+        }))
+    }
+
+    async fn verify_server_jar(
+        &self,
+        request: Request<VerifyServerJarRequest>,
+    ) -> Result<Response<VerifyServerJarResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        if !inst.template_id.starts_with("minecraft:") {
+            return Err(Status::failed_precondition(
+                "instance is not a Minecraft server",
+            ));
+        }
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let accept = req.accept_current;
+
+        let check = tokio::task::spawn_blocking(move || -> Result<_, Status> {
+            if accept {
+                crate::jar_provenance::record_installed(&dir, "manual", "", "").map_err(|e| {
+                    Status::failed_precondition(format!("failed to record server.jar: {e:#}"))
+                })?;
+            }
+            crate::jar_provenance::verify(&dir)
+                .map_err(|e| Status::internal(format!("failed to hash server.jar: {e}")))
+        })
+        .await
+        .map_err(|e| Status::internal(format!("jar verify task failed: {e}")))??;
+
+        if accept {
+            tracing::info!(instance_id = %id, "server.jar accepted as trusted");
+        }
+        if check.state == crate::jar_provenance::State::Mismatch {
+            tracing::warn!(instance_id = %id, "server.jar does not match its recorded hash");
+        }
+        let p = check.provenance.unwrap_or_default();
+        Ok(Response::new(VerifyServerJarResponse {
+            state: check.state.as_str().to_string(),
+            provider: p.provider,
+            source_url: p.source_url,
+            version: p.version,
+            expected_sha1: p.sha1,
+            expected_size: p.size,
+            actual_sha1: check.actual_sha1.unwrap_or_default(),
+            actual_size: check.actual_size,
+            recorded_at_unix_ms: p.recorded_at_unix_ms,
+        }))
+    }
+
+    async fn list_property_profiles(
+        &self,
+        _request: Request<ListPropertyProfilesRequest>,
+    ) -> Result<Response<ListPropertyProfilesResponse>, Status> {
+        let profiles = tokio::task::spawn_blocking(crate::mc_properties::list)
+            .await
+            .map_err(|e| Status::internal(format!("profile list task failed: {e}")))?;
+        Ok(Response::new(ListPropertyProfilesResponse {
+            profiles: profiles
+                .into_iter()
+                .map(property_profile_to_proto)
+                .collect(),
+        }))
+    }
+
+    async fn save_property_profile(
+        &self,
+        request: Request<SavePropertyProfileRequest>,
+    ) -> Result<Response<SavePropertyProfileResponse>, Status> {
+        let p = request
+            .into_inner()
+            .profile
+            .ok_or_else(|| Status::invalid_argument("profile is required"))?;
+        let values = p.values.into_iter().map(|v| (v.key, v.value)).collect();
+        let saved = crate::mc_properties::save(&p.name, &p.description, values)
+            .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+        tracing::info!(profile = %saved.name, keys = saved.values.len(), "property profile saved");
+        Ok(Response::new(SavePropertyProfileResponse {
+            profile: Some(property_profile_to_proto(saved)),
+        }))
+    }
+
+    async fn delete_property_profile(
+        &self,
+        request: Request<DeletePropertyProfileRequest>,
+    ) -> Result<Response<DeletePropertyProfileResponse>, Status> {
+        let name = request.into_inner().name;
+        let deleted = crate::mc_properties::delete(&name)
+            .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+        Ok(Response::new(DeletePropertyProfileResponse { deleted }))
+    }
+
+    async fn apply_property_profiles(
+        &self,
+        request: Request<ApplyPropertyProfilesRequest>,
+    ) -> Result<Response<ApplyPropertyProfilesResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        if !inst.template_id.starts_with("minecraft:") {
+            return Err(Status::failed_precondition(
+                "instance is not a Minecraft server",
+            ));
+        }
+        if req.profiles.is_empty() {
+            return Err(Status::invalid_argument("no profiles given"));
+        }
+        let mut profiles = Vec::with_capacity(req.profiles.len());
+        for name in &req.profiles {
+            let p = crate::mc_properties::find(name.trim())
+                .ok_or_else(|| Status::not_found(format!("unknown property profile {name:?}")))?;
+            profiles.push(p);
+        }
+        let values = crate::mc_properties::combine(&profiles);
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let dry_run = req.dry_run;
+        let changes = tokio::task::spawn_blocking(move || {
+            crate::mc_properties::apply(&dir, &values, dry_run)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("apply task failed: {e}")))?
+        .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+
+        let applied = !dry_run && !changes.is_empty();
+        if applied {
+            crate::dir_cache::clear();
+            tracing::info!(
+                instance_id = %id,
+                profiles = %req.profiles.join(","),
+                changed = changes.len(),
+                "property profiles applied"
+            );
+        }
+        let running = self.manager.get_status(&id).await.is_some_and(|st| {
+            matches!(
+                st.state,
+                alloy_process::ProcessState::Running | alloy_process::ProcessState::Starting
+            )
+        });
+        if applied && !running {
+            discard_checkpoint(&id);
+        }
+        Ok(Response::new(ApplyPropertyProfilesResponse {
+            restart_required: applied && running,
+            applied,
+            changes: changes
+                .into_iter()
+                .map(|c| PropertyChange {
+                    key: c.key,
+                    had_value: c.old.is_some(),
+                    old_value: c.old.unwrap_or_default(),
+                    new_value: c.new,
+                })
+                .collect(),
+        }))
+    }
+
+    async fn get_config_schema(
+        &self,
+        request: Request<GetConfigSchemaRequest>,
+    ) -> Result<Response<GetConfigSchemaResponse>, Status> {
+        let req = request.into_inner();
+        let dir = minecraft_instance_dir(&req.instance_id).await?;
+        let file = req.file.trim().to_string();
+        if !file.is_empty() && !crate::mc_perf::FILES.contains(&file.as_str()) {
+            return Err(Status::invalid_argument(format!(
+                "no schema for {file:?}; known files: {}",
+                crate::mc_perf::FILES.join(", ")
+            )));
+        }
+        let keys = tokio::task::spawn_blocking(move || {
+            crate::mc_perf::describe(&dir, (!file.is_empty()).then_some(file.as_str()))
+        })
+        .await
+        .map_err(|e| Status::internal(format!("schema task failed: {e}")))?;
+        Ok(Response::new(GetConfigSchemaResponse {
+            keys: keys
+                .into_iter()
+                .map(|k| {
+                    let range = k.spec.kind.range();
+                    ConfigKey {
+                        file: k.spec.file.to_string(),
+                        key: k.spec.key.to_string(),
+                        kind: k.spec.kind.name().to_string(),
+                        has_range: range.is_some(),
+                        min: range.map(|r| r.0).unwrap_or_default(),
+                        max: range.map(|r| r.1).unwrap_or_default(),
+                        default_value: k.spec.default.to_string(),
+                        description: k.spec.description.to_string(),
+                        perf: k.spec.perf(),
+                        has_value: k.value.is_some(),
+                        value: k.value.unwrap_or_default(),
+                        problem: k.problem.unwrap_or_default(),
+                    }
+                })
+                .collect(),
+        }))
+    }
+
+    async fn perf_audit(
+        &self,
+        request: Request<PerfAuditRequest>,
+    ) -> Result<Response<PerfAuditResponse>, Status> {
+        let dir = minecraft_instance_dir(&request.into_inner().instance_id).await?;
+        let report = tokio::task::spawn_blocking(move || crate::mc_perf::audit(&dir))
+            .await
+            .map_err(|e| Status::internal(format!("audit task failed: {e}")))?;
+        Ok(Response::new(PerfAuditResponse {
+            files: report.files.iter().map(|f| f.to_string()).collect(),
+            findings: report
+                .findings
+                .into_iter()
+                .map(|f| PerfFinding {
+                    file: f.file.to_string(),
+                    key: f.key.to_string(),
+                    value: f.value,
+                    is_default: f.is_default,
+                    suggested: f.suggested,
+                    severity: f.severity.as_str().to_string(),
+                    message: f.message,
+                })
+                .collect(),
+        }))
+    }
+
+    async fn list_managed_files(
+        &self,
+        request: Request<ListManagedFilesRequest>,
+    ) -> Result<Response<ListManagedFilesResponse>, Status> {
+        let id = normalize_instance_id(&request.into_inner().instance_id).map_err(Status::from)?;
+        let inst = load_instance(&id).await?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let files = tokio::task::spawn_blocking(move || {
+            inst.managed_files
+                .iter()
+                .map(|f| managed_file_status(&dir, f))
+                .collect()
+        })
+        .await
+        .map_err(|e| Status::internal(format!("managed file check failed: {e}")))?;
+        Ok(Response::new(ListManagedFilesResponse { files }))
+    }
+
+    async fn manage_file(
+        &self,
+        request: Request<ManageFileRequest>,
+    ) -> Result<Response<ManageFileResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let mut inst = load_instance(&id).await?;
+        let policy = crate::managed_config::Policy::parse(&req.policy)
+            .ok_or_else(|| Status::invalid_argument("policy must be alert or revert"))?;
+        let path = crate::managed_config::normalize_path(&req.path)
+            .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+        let dir = instance_dir(&id).map_err(Status::from)?;
+        let content = req.has_content.then_some(req.content);
+        let sha256 = tokio::task::spawn_blocking({
+            let dir = dir.clone();
+            let path = path.clone();
+            move || crate::managed_config::pin(&dir, &path, content)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("pin task failed: {e}")))?
+        .map_err(|e| Status::failed_precondition(format!("{e:#}")))?;
+
+        let file = crate::managed_config::ManagedFile {
+            path: path.clone(),
+            sha256,
+            policy,
+        };
+        inst.managed_files.retain(|f| f.path != path);
+        inst.managed_files.push(file.clone());
+        inst.managed_files.sort_by(|a, b| a.path.cmp(&b.path));
+        save_instance(&inst).await?;
+        crate::managed_config::prune(&dir, &inst.managed_files);
+        tracing::info!(
+            instance_id = %id,
+            path = %file.path,
+            policy = file.policy.as_str(),
+            sha256 = %file.sha256,
+            "managed config pinned"
+        );
+        Ok(Response::new(ManageFileResponse {
+            file: Some(managed_file_status(&dir, &file)),
+        }))
+    }
+
+    async fn unmanage_file(
+        &self,
+        request: Request<UnmanageFileRequest>,
+    ) -> Result<Response<UnmanageFileResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let mut inst = load_instance(&id).await?;
+        let path = crate::managed_config::normalize_path(&req.path)
+            .map_err(|e| Status::invalid_argument(format!("{e:#}")))?;
+        let before = inst.managed_files.len();
+        inst.managed_files.retain(|f| f.path != path);
+        let removed = inst.managed_files.len() != before;
+        if removed {
+            save_instance(&inst).await?;
+            let dir = instance_dir(&id).map_err(Status::from)?;
+            crate::managed_config::prune(&dir, &inst.managed_files);
+        }
+        Ok(Response::new(UnmanageFileResponse { removed }))
+    }
+
+    async fn hibernate(
+        &self,
+        request: Request<HibernateInstanceRequest>,
+    ) -> Result<Response<HibernateInstanceResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let timeout = if req.timeout_ms == 0 {
+            std::time::Duration::from_secs(30)
+        } else {
+            std::time::Duration::from_millis(req.timeout_ms as u64)
+        };
+        let started = std::time::Instant::now();
+
+        let (status, checkpointed, fallback_reason, checkpoint_bytes) =
+            match self.manager.hibernate(&id).await {
+                Ok((status, meta)) => (status, true, String::new(), meta.size_bytes),
+                Err(e) => {
+                    let reason = format!("{e:#}");
+                    tracing::info!(
+                        instance_id = %id,
+                        reason = %reason,
+                        "hibernation unavailable; stopping normally"
+                    );
+                    let status = self
+                        .manager
+                        .stop(&id, timeout)
+                        .await
+                        .map_err(|e| Status::not_found(e.to_string()))?;
+                    (status, false, reason, 0)
+                }
+            };
+
+        Ok(Response::new(HibernateInstanceResponse {
+            status: Some(crate::process_service::map_status(status)),
+            checkpointed,
+            fallback_reason,
+            checkpoint_bytes,
+            elapsed_ms: started.elapsed().as_millis() as u64,
+        }))
+    }
+
+    async fn get_env_report(
+        &self,
+        request: Request<GetEnvReportRequest>,
+    ) -> Result<Response<GetEnvReportResponse>, Status> {
+        let req = request.into_inner();
+        let id = normalize_instance_id(&req.instance_id).map_err(Status::from)?;
+        let dir = minecraft_instance_dir(&id).await?;
+        let inst = load_instance(&id).await?;
+        let backup_path = match req.backup_path.trim() {
+            "" => None,
+            rel => Some(instance_backup_path(&id, rel)?),
+        };
+
+        let (current, backup) = tokio::task::spawn_blocking(move || {
+            let current = crate::mc_env_report::generate(&id, &dir, &inst.template_id, inst.params);
+            let backup = backup_path
+                .map(|p| crate::mc_env_report::read_from_backup(&p))
+                .transpose()
+                .map(Option::flatten);
+            (current, backup)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("env report task failed: {e}")))?;
+        let backup =
+            backup.map_err(|e| Status::internal(format!("failed to read backup: {e:#}")))?;
+
+        let differences = backup
+            .as_ref()
+            .map(|b| crate::mc_env_report::diff(b, &current))
+            .unwrap_or_default();
+        Ok(Response::new(GetEnvReportResponse {
+            current: Some(env_report_to_proto(current)),
+            backup: backup.map(env_report_to_proto),
+            differences,
+        }))
+    }
+}
+
+fn managed_file_status(dir: &Path, f: &crate::managed_config::ManagedFile) -> ManagedFileStatus {
+    let state = crate::managed_config::state(dir, f);
+    ManagedFileStatus {
+        path: f.path.clone(),
+        policy: f.policy.as_str().to_string(),
+        sha256: f.sha256.clone(),
+        state: state.as_str().to_string(),
+        actual_sha256: state.actual().unwrap_or_default().to_string(),
+    }
+}
+
+fn env_file_to_proto(f: crate::mc_env_report::FileEntry) -> EnvFile {
+    EnvFile {
+        path: f.path,
+        size_bytes: f.size_bytes,
+        sha256: f.sha256,
+    }
+}
+
+fn env_report_to_proto(r: crate::mc_env_report::Report) -> EnvReport {
+    EnvReport {
+        generated_at_unix_ms: r.generated_at_unix_ms,
+        template_id: r.template_id,
+        node_id: r.node_id,
+        agent_version: r.agent_version,
+        java_version: r.java_version,
+        jvm_flags: r.jvm_flags,
+        server_jar: r.server_jar.map(env_file_to_proto),
+        jar_provider: r.jar_provider,
+        jar_version: r.jar_version,
+        mods: r.mods.into_iter().map(env_file_to_proto).collect(),
+        config: r.config.into_iter().collect(),
+        params: r.params.into_iter().collect(),
+    }
+}
+
+async fn minecraft_instance_dir(instance_id: &str) -> Result<PathBuf, Status> {
+    let id = normalize_instance_id(instance_id).map_err(Status::from)?;
+    let inst = load_instance(&id).await?;
+    if !inst.template_id.starts_with("minecraft:") {
+        return Err(Status::failed_precondition(
+            "instance is not a Minecraft server",
+        ));
+    }
+    instance_dir(&id).map_err(Status::from)
+}
+
+fn property_profile_to_proto(p: crate::mc_properties::Profile) -> PropertyProfile {
+    PropertyProfile {
+        name: p.name,
+        description: p.description,
+        builtin: p.builtin,
+        values: p
+            .values
+            .into_iter()
+            .map(|(key, value)| PropertyValue { key, value })
+            .collect(),
+    }
 }
 
 pub fn server(manager: ProcessManager) -> InstanceServiceServer<InstanceApi> {
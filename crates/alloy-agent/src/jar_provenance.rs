@@ -0,0 +1,170 @@
+use std::{
+    io::Read,
+    path::{Path, PathBuf},
+};
+
+use serde::{Deserialize, Serialize};
+use sha1::Digest;
+
+// Where an instance's server.jar came from and the hash it should have, kept in server-jar.json
+// next to it. Re-hashing the jar against this record catches tampering and bit-rot.
+
+const FILE: &str = "server-jar.json";
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct Provenance {
+    // "mojang" (hash published by Mojang), "fabric" (hashed at download) or "manual" (accepted
+    // by an operator).
+    pub provider: String,
+    #[serde(default)]
+    pub source_url: String,
+    #[serde(default)]
+    pub version: String,
+    pub sha1: String,
+    pub size: u64,
+    pub recorded_at_unix_ms: u64,
+}
+
+impl Provenance {
+    pub fn new(provider: &str, source_url: &str, version: &str, sha1: &str, size: u64) -> Self {
+        Self {
+            provider: provider.to_string(),
+            source_url: source_url.to_string(),
+            version: version.to_string(),
+            sha1: sha1.to_ascii_lowercase(),
+            size,
+            recorded_at_unix_ms: crate::console_audit::now_unix_ms(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum State {
+    Ok,
+    Mismatch,
+    // A record exists but server.jar is gone.
+    Missing,
+    // No record; the jar was not installed from a known provider.
+    Unrecorded,
+}
+
+impl State {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            State::Ok => "ok",
+            State::Mismatch => "mismatch",
+            State::Missing => "missing",
+            State::Unrecorded => "unrecorded",
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct Check {
+    pub state: State,
+    pub provenance: Option<Provenance>,
+    pub actual_sha1: Option<String>,
+    pub actual_size: u64,
+}
+
+pub fn jar_path(instance_dir: &Path) -> PathBuf {
+    instance_dir.join("server.jar")
+}
+
+pub fn read(instance_dir: &Path) -> Option<Provenance> {
+    let raw = std::fs::read(instance_dir.join(FILE)).ok()?;
+    serde_json::from_slice(&raw).ok()
+}
+
+pub fn write(instance_dir: &Path, provenance: &Provenance) -> anyhow::Result<()> {
+    let path = instance_dir.join(FILE);
+    let tmp = path.with_extension("json.tmp");
+    std::fs::write(&tmp, serde_json::to_vec_pretty(provenance)?)?;
+    std::fs::rename(&tmp, &path)?;
+    Ok(())
+}
+
+pub fn hash_file(path: &Path) -> std::io::Result<(String, u64)> {
+    let mut f = std::fs::File::open(path)?;
+    let mut hasher = sha1::Sha1::new();
+    let mut buf = vec![0u8; 1 << 16];
+    let mut size = 0u64;
+    loop {
+        let n = f.read(&mut buf)?;
+        if n == 0 {
+            break;
+        }
+        hasher.update(&buf[..n]);
+        size += n as u64;
+    }
+    Ok((hex::encode(hasher.finalize()), size))
+}
+
+// Records the jar currently installed, e.g. one the provider publishes no hash for.
+pub fn record_installed(
+    instance_dir: &Path,
+    provider: &str,
+    source_url: &str,
+    version: &str,
+) -> anyhow::Result<Provenance> {
+    let (sha1, size) = hash_file(&jar_path(instance_dir))?;
+    let provenance = Provenance::new(provider, source_url, version, &sha1, size);
+    write(instance_dir, &provenance)?;
+    Ok(provenance)
+}
+
+pub fn verify(instance_dir: &Path) -> std::io::Result<Check> {
+    let provenance = read(instance_dir);
+    let jar = jar_path(instance_dir);
+    if !jar.is_file() {
+        return Ok(Check {
+            state: match provenance {
+                Some(_) => State::Missing,
+                None => State::Unrecorded,
+            },
+            provenance,
+            actual_sha1: None,
+            actual_size: 0,
+        });
+    }
+    let (sha1, size) = hash_file(&jar)?;
+    let state = match &provenance {
+        None => State::Unrecorded,
+        Some(p) if p.sha1.eq_ignore_ascii_case(&sha1) && p.size == size => State::Ok,
+        Some(_) => State::Mismatch,
+    };
+    Ok(Check {
+        state,
+        provenance,
+        actual_sha1: Some(sha1),
+        actual_size: size,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn detects_changed_jars() {
+        let dir =
+            std::env::temp_dir().join(format!("alloy-provenance-test-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        assert_eq!(verify(&dir).unwrap().state, State::Unrecorded);
+
+        std::fs::write(jar_path(&dir), b"jar").unwrap();
+        assert_eq!(verify(&dir).unwrap().state, State::Unrecorded);
+        let p = record_installed(&dir, "fabric", "https://example.invalid/jar", "1.21").unwrap();
+        assert_eq!(p.sha1, "f92e777f4341930bad9b2422283c4680d00dbc06");
+        assert_eq!(verify(&dir).unwrap().state, State::Ok);
+
+        std::fs::write(jar_path(&dir), b"jaR").unwrap();
+        let check = verify(&dir).unwrap();
+        assert_eq!(check.state, State::Mismatch);
+        assert_eq!(check.actual_size, 3);
+
+        std::fs::remove_file(jar_path(&dir)).unwrap();
+        assert_eq!(verify(&dir).unwrap().state, State::Missing);
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,175 @@
+use std::{
+    os::unix::fs::{FileTypeExt, PermissionsExt},
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+use futures_util::{Stream, StreamExt};
+use tokio::net::UnixStream;
+
+// Local management socket (used by alloyctl). Serves the same gRPC services as the TCP listener,
+// for operators on the host when control is unreachable.
+//
+// ALLOY_AGENT_SOCKET overrides the path; `off` disables it.
+fn socket_path() -> Option<PathBuf> {
+    match std::env::var("ALLOY_AGENT_SOCKET") {
+        Ok(v) if matches!(v.trim(), "off" | "0" | "false") => None,
+        Ok(v) if !v.trim().is_empty() => Some(PathBuf::from(v.trim())),
+        _ => Some(crate::minecraft::data_root().join("alloy-agent.sock")),
+    }
+}
+
+// Who may connect, checked against the peer's SO_PEERCRED credentials on accept. root and the
+// agent's own user are always allowed; ALLOY_AGENT_SOCKET_UIDS / ALLOY_AGENT_SOCKET_GIDS
+// (comma-separated) add more. Only the peer's primary gid is known, not supplementary groups.
+#[derive(Debug, Default, Clone, PartialEq, Eq)]
+struct PeerPolicy {
+    own_uid: u32,
+    uids: Vec<u32>,
+    gids: Vec<u32>,
+}
+
+fn parse_ids(name: &str, raw: &str) -> anyhow::Result<Vec<u32>> {
+    raw.split(',')
+        .map(str::trim)
+        .filter(|s| !s.is_empty())
+        .map(|s| {
+            s.parse::<u32>()
+                .map_err(|_| anyhow::anyhow!("{name}: invalid id {s:?}"))
+        })
+        .collect()
+}
+
+impl PeerPolicy {
+    fn from_env() -> anyhow::Result<Self> {
+        let ids = |name: &str| match std::env::var(name) {
+            Ok(raw) => parse_ids(name, &raw),
+            Err(_) => Ok(Vec::new()),
+        };
+        Ok(Self {
+            // SAFETY: geteuid has no preconditions and cannot fail.
+            own_uid: unsafe { libc::geteuid() },
+            uids: ids("ALLOY_AGENT_SOCKET_UIDS")?,
+            gids: ids("ALLOY_AGENT_SOCKET_GIDS")?,
+        })
+    }
+
+    // Other users can only reach the socket when the allow lists name them.
+    fn socket_mode(&self) -> u32 {
+        if self.uids.is_empty() && self.gids.is_empty() {
+            0o600
+        } else {
+            0o666
+        }
+    }
+
+    fn allows(&self, uid: u32, gid: u32) -> bool {
+        uid == 0 || uid == self.own_uid || self.uids.contains(&uid) || self.gids.contains(&gid)
+    }
+}
+
+fn bind(path: &Path, mode: u32) -> anyhow::Result<tokio::net::UnixListener> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir).with_context(|| format!("create {}", dir.display()))?;
+    }
+
+    // A socket left behind by a previous run would make bind fail; anything else is not ours.
+    if let Ok(meta) = std::fs::symlink_metadata(path) {
+        if !meta.file_type().is_socket() {
+            anyhow::bail!("{} exists and is not a socket", path.display());
+        }
+        std::fs::remove_file(path).with_context(|| format!("remove stale {}", path.display()))?;
+    }
+
+    let listener =
+        tokio::net::UnixListener::bind(path).with_context(|| format!("bind {}", path.display()))?;
+    std::fs::set_permissions(path, std::fs::Permissions::from_mode(mode))
+        .with_context(|| format!("chmod {}", path.display()))?;
+    Ok(listener)
+}
+
+fn authorize(policy: &PeerPolicy, stream: &UnixStream) -> bool {
+    let cred = match stream.peer_cred() {
+        Ok(c) => c,
+        Err(err) => {
+            tracing::warn!(error = %err, "local socket: cannot read peer credentials; rejecting");
+            return false;
+        }
+    };
+    if policy.allows(cred.uid(), cred.gid()) {
+        return true;
+    }
+    tracing::warn!(
+        uid = cred.uid(),
+        gid = cred.gid(),
+        pid = ?cred.pid(),
+        "local socket: rejected connection from unauthorized peer"
+    );
+    false
+}
+
+// Binds the configured socket and yields the connections whose peer is allowed. Failure only
+// disables alloyctl; the TCP listener still serves.
+pub(crate) fn listen() -> Option<impl Stream<Item = std::io::Result<UnixStream>>> {
+    let path = socket_path()?;
+    let policy = match PeerPolicy::from_env() {
+        Ok(p) => p,
+        Err(err) => {
+            tracing::warn!(error = %format!("{err:#}"), "local socket disabled");
+            return None;
+        }
+    };
+    let listener = match bind(&path, policy.socket_mode()) {
+        Ok(l) => l,
+        Err(err) => {
+            tracing::warn!(error = %format!("{err:#}"), "local socket disabled");
+            return None;
+        }
+    };
+    tracing::info!(
+        path = %path.display(),
+        uids = ?policy.uids,
+        gids = ?policy.gids,
+        "alloy-agent local socket listening"
+    );
+
+    let incoming = tokio_stream::wrappers::UnixListenerStream::new(listener);
+    // Dropping a rejected stream closes it before any request is read.
+    Some(incoming.filter(move |res| {
+        let ok = match res {
+            Ok(stream) => authorize(&policy, stream),
+            Err(_) => true,
+        };
+        std::future::ready(ok)
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn peer_policy_allows_root_self_and_listed_ids() {
+        let policy = PeerPolicy {
+            own_uid: 1000,
+            uids: parse_ids("uids", "1001, 1002").unwrap(),
+            gids: parse_ids("gids", "50").unwrap(),
+        };
+        assert!(policy.allows(0, 0));
+        assert!(policy.allows(1000, 1000));
+        assert!(policy.allows(1002, 1002));
+        assert!(policy.allows(2000, 50));
+        assert!(!policy.allows(2000, 2000));
+        assert_eq!(policy.socket_mode(), 0o666);
+
+        assert_eq!(
+            PeerPolicy {
+                own_uid: 1000,
+                ..Default::default()
+            }
+            .socket_mode(),
+            0o600
+        );
+        assert!(parse_ids("uids", "1001,abc").is_err());
+    }
+}
@@ -0,0 +1,218 @@
+use std::{
+    fs::File,
+    io::Write,
+    path::{Path, PathBuf},
+    sync::OnceLock,
+};
+
+use tracing_subscriber::{EnvFilter, Registry, prelude::*, reload};
+
+// The agent's own logs: stdout for docker and `logs/agent.log` under the data root, as text or
+// JSON lines (ALLOY_LOG_FORMAT=json). The file rotates by size. The level filter uses RUST_LOG
+// syntax, including per-module overrides (`info,alloy_agent::frp=debug`), and can be changed
+// while the agent runs through the SetLogLevel RPC. Spans can also be exported over OTLP (see
+// otel).
+
+const FILE_NAME: &str = "agent.log";
+const DEFAULT_FILTER: &str = "info";
+const DEFAULT_MAX_BYTES: u64 = 20 * 1024 * 1024;
+const DEFAULT_MAX_FILES: usize = 5;
+
+static FILTER: OnceLock<reload::Handle<EnvFilter, Registry>> = OnceLock::new();
+
+fn env_u64(key: &str, default: u64) -> u64 {
+    std::env::var(key)
+        .ok()
+        .and_then(|v| v.trim().parse().ok())
+        .unwrap_or(default)
+}
+
+fn startup_filter() -> String {
+    std::env::var("RUST_LOG")
+        .ok()
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+        .unwrap_or_else(|| DEFAULT_FILTER.to_string())
+}
+
+// agent.log, rotated to agent.log.1 (newest) .. agent.log.N once it reaches max_bytes.
+struct RotatingFile {
+    path: PathBuf,
+    file: File,
+    len: u64,
+    max_bytes: u64,
+    max_files: usize,
+}
+
+impl RotatingFile {
+    fn open(dir: &Path, max_bytes: u64, max_files: usize) -> std::io::Result<Self> {
+        let path = dir.join(FILE_NAME);
+        let file = File::options().create(true).append(true).open(&path)?;
+        let len = file.metadata()?.len();
+        Ok(Self {
+            path,
+            file,
+            len,
+            max_bytes,
+            max_files,
+        })
+    }
+
+    fn rotated(&self, n: usize) -> PathBuf {
+        self.path.with_file_name(format!("{FILE_NAME}.{n}"))
+    }
+
+    fn rotate(&mut self) -> std::io::Result<()> {
+        self.file.flush()?;
+        if self.max_files == 0 {
+            self.file.set_len(0)?;
+        } else {
+            let _ = std::fs::remove_file(self.rotated(self.max_files));
+            for n in (1..self.max_files).rev() {
+                let _ = std::fs::rename(self.rotated(n), self.rotated(n + 1));
+            }
+            std::fs::rename(&self.path, self.rotated(1))?;
+            self.file = File::options().create(true).append(true).open(&self.path)?;
+        }
+        self.len = 0;
+        Ok(())
+    }
+}
+
+impl Write for RotatingFile {
+    fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+        // The non-blocking writer hands over one event per call, so lines are never split.
+        if self.max_bytes > 0 && self.len > 0 && self.len + buf.len() as u64 > self.max_bytes {
+            self.rotate()?;
+        }
+        let n = self.file.write(buf)?;
+        self.len += n as u64;
+        Ok(n)
+    }
+
+    fn flush(&mut self) -> std::io::Result<()> {
+        self.file.flush()
+    }
+}
+
+fn json_format() -> bool {
+    std::env::var("ALLOY_LOG_FORMAT")
+        .map(|v| v.trim().eq_ignore_ascii_case("json"))
+        .unwrap_or(false)
+}
+
+// Flushes buffered file logs and exported spans when dropped.
+pub struct Guard {
+    _file: tracing_appender::non_blocking::WorkerGuard,
+    _otel: Option<crate::otel::Exporter>,
+}
+
+// Installs the global subscriber. Keep the returned guard alive until exit.
+pub fn init(log_dir: &Path) -> anyhow::Result<Guard> {
+    let max_files = env_u64("ALLOY_LOG_MAX_FILES", DEFAULT_MAX_FILES as u64) as usize;
+    let file = RotatingFile::open(
+        log_dir,
+        env_u64("ALLOY_LOG_MAX_BYTES", DEFAULT_MAX_BYTES),
+        max_files,
+    )?;
+    let (file_writer, guard) = tracing_appender::non_blocking(file);
+
+    let startup = startup_filter();
+    let (filter, handle) = reload::Layer::new(EnvFilter::try_new(&startup).unwrap_or_else(|e| {
+        eprintln!("invalid RUST_LOG {startup:?}: {e}; using {DEFAULT_FILTER}");
+        EnvFilter::new(DEFAULT_FILTER)
+    }));
+
+    let (stdout, file) = if json_format() {
+        (
+            tracing_subscriber::fmt::layer()
+                .json()
+                .with_writer(std::io::stdout)
+                .boxed(),
+            tracing_subscriber::fmt::layer()
+                .json()
+                .with_writer(file_writer)
+                .boxed(),
+        )
+    } else {
+        (
+            tracing_subscriber::fmt::layer()
+                .with_writer(std::io::stdout)
+                .with_ansi(true)
+                .boxed(),
+            tracing_subscriber::fmt::layer()
+                .with_writer(file_writer)
+                .with_ansi(false)
+                .boxed(),
+        )
+    };
+    let otel_endpoint = crate::otel::endpoint();
+    let (otel, exporter) = match otel_endpoint.as_deref().map(crate::otel::layer) {
+        Some(Ok((layer, exporter))) => (Some(layer), Some(exporter)),
+        Some(Err(e)) => {
+            eprintln!("OTLP export disabled: {e:#}");
+            (None, None)
+        }
+        None => (None, None),
+    };
+    tracing_subscriber::registry()
+        .with(filter)
+        .with(stdout)
+        .with(file)
+        .with(otel)
+        .init();
+    let _ = FILTER.set(handle);
+    if let (Some(endpoint), Some(_)) = (&otel_endpoint, &exporter) {
+        tracing::info!(%endpoint, "exporting traces over OTLP");
+    }
+    Ok(Guard {
+        _file: guard,
+        _otel: exporter,
+    })
+}
+
+// The active filter in RUST_LOG syntax.
+pub fn current_filter() -> String {
+    FILTER
+        .get()
+        .and_then(|h| h.with_current(|f| f.to_string()).ok())
+        .unwrap_or_default()
+}
+
+// Replaces the active filter until the next restart. Returns the previous one.
+pub fn set_filter(directives: &str) -> anyhow::Result<String> {
+    let directives = directives.trim();
+    anyhow::ensure!(!directives.is_empty(), "log filter is empty");
+    let filter = EnvFilter::try_new(directives)
+        .map_err(|e| anyhow::anyhow!("invalid log filter {directives:?}: {e}"))?;
+    let handle = FILTER
+        .get()
+        .ok_or_else(|| anyhow::anyhow!("logging is not initialized"))?;
+    let previous = current_filter();
+    handle.reload(filter)?;
+    Ok(previous)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn rotates_by_size() {
+        let dir = std::env::temp_dir().join(format!("alloy-logging-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+
+        let mut f = RotatingFile::open(&dir, 10, 2).unwrap();
+        for line in ["aaaaaaa\n", "bbbbbbb\n", "ccccccc\n", "ddddddd\n"] {
+            f.write_all(line.as_bytes()).unwrap();
+        }
+        f.flush().unwrap();
+        let read = |name: &str| std::fs::read_to_string(dir.join(name)).unwrap();
+        assert_eq!(read("agent.log"), "ddddddd\n");
+        assert_eq!(read("agent.log.1"), "ccccccc\n");
+        assert_eq!(read("agent.log.2"), "bbbbbbb\n");
+        assert!(!dir.join("agent.log.3").exists());
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -1,11 +1,13 @@
 use std::path::{Component, Path, PathBuf};
 
 use alloy_proto::agent_v1::logs_service_server::{LogsService, LogsServiceServer};
-use alloy_proto::agent_v1::{TailFileRequest, TailFileResponse};
+use alloy_proto::agent_v1::{
+    PasteFileRequest, PasteFileResponse, TailFileRequest, TailFileResponse,
+};
 use tokio::io::{AsyncReadExt, AsyncSeekExt};
 use tonic::{Request, Response, Status};
 
-use crate::minecraft;
+use crate::{log_paste, minecraft};
 
 const DEFAULT_LIMIT_BYTES: u32 = 64 * 1024;
 const MAX_LIMIT_BYTES: u32 = 1024 * 1024;
@@ -145,6 +147,74 @@ impl LogsService for LogsApi {
             next_cursor: next_cursor.to_string(),
         }))
     }
+
+    async fn paste_file(
+        &self,
+        request: Request<PasteFileRequest>,
+    ) -> Result<Response<PasteFileResponse>, Status> {
+        let req = request.into_inner();
+        let rel = normalize_rel_path(&req.path).map_err(Status::from)?;
+        let service = log_paste::service_url().ok_or_else(|| {
+            Status::failed_precondition("log paste is disabled (ALLOY_PASTE_URL)")
+        })?;
+        // The upload leaves the node, so a symlink must not lead it outside the data root, and
+        // only log files qualify, judged by where the path really points.
+        let root = minecraft::data_root();
+        let path = tokio::fs::canonicalize(root.join(&rel))
+            .await
+            .map_err(|_| Status::not_found("path not found"))?;
+        let Ok(rel) = path.strip_prefix(&root).map(Path::to_path_buf) else {
+            return Err(Status::permission_denied("path escapes the data root"));
+        };
+        if !log_paste::is_log_path(&rel) {
+            return Err(Status::invalid_argument(
+                "only log files (logs/, *.log, *.log.gz) can be uploaded",
+            ));
+        }
+        if !tokio::fs::metadata(&path)
+            .await
+            .map_err(|_| Status::not_found("path not found"))?
+            .is_file()
+        {
+            return Err(Status::invalid_argument("path is not a file"));
+        }
+
+        let max_lines = match req.max_lines {
+            0 => log_paste::MAX_LINES,
+            n => n as usize,
+        };
+        let prepared = tokio::task::spawn_blocking(move || {
+            let text = log_paste::read_log(&path)?;
+            let redactor = log_paste::Redactor::for_instance(&log_paste::instance_params(&rel));
+            anyhow::Ok(log_paste::prepare(&text, &req.filter, max_lines, &redactor))
+        })
+        .await
+        .map_err(|e| Status::internal(format!("paste task failed: {e}")))?
+        .map_err(|e| Status::internal(format!("failed to read log: {e:#}")))?;
+        if prepared.lines == 0 {
+            return Err(Status::failed_precondition("no log lines to upload"));
+        }
+
+        let (url, raw_url) = log_paste::upload(&service, &prepared.content)
+            .await
+            .map_err(|e| Status::unavailable(format!("{e:#}")))?;
+        tracing::info!(
+            path = %req.path,
+            %url,
+            lines = prepared.lines,
+            redactions = prepared.redactions,
+            "log uploaded to paste service"
+        );
+        Ok(Response::new(PasteFileResponse {
+            url,
+            raw_url: raw_url.unwrap_or_default(),
+            lines: prepared.lines as u32,
+            bytes: prepared.content.len() as u64,
+            redactions: prepared.redactions as u32,
+            truncated: prepared.truncated,
+            service,
+        }))
+    }
 }
 
 pub fn server() -> LogsServiceServer<LogsApi> {
@@ -1,7 +1,6 @@
 use std::net::SocketAddr;
 
 use tonic::transport::Server;
-use tracing_subscriber::prelude::*;
 
 #[cfg(target_os = "linux")]
 #[derive(Debug, serde::Deserialize)]
@@ -200,74 +199,136 @@ async fn cleanup_orphan_processes() {
 #[cfg(not(target_os = "linux"))]
 async fn cleanup_orphan_processes() {}
 
+mod autostart;
+mod backup_protection;
+mod backup_schedule;
+mod bind_pool;
+mod clock;
+mod command_policy;
+mod console_audit;
+mod console_guard;
 mod control_tunnel;
+mod crash_journal;
+mod criu;
+mod dir_cache;
 mod download_progress;
 mod dst;
 mod dst_download;
 mod error_payload;
 mod filesystem_service;
+mod frp;
+mod fs_hash;
+mod fs_search;
+mod golden;
 mod health_service;
 mod instance_service;
+mod jar_provenance;
+#[cfg(unix)]
+mod local_socket;
+mod log_paste;
+mod logging;
 mod logs_service;
+mod managed_config;
+mod mc_env_report;
+mod mc_perf;
+mod mc_properties;
 mod minecraft;
 mod minecraft_curseforge;
 mod minecraft_download;
 mod minecraft_import;
 mod minecraft_launch;
+mod minecraft_map;
 mod minecraft_modrinth;
+mod minecraft_players;
+mod minecraft_probe;
+mod minecraft_webmap;
+mod mirrors;
+mod nbt;
+mod netaddr;
+mod node_identity;
+mod otel;
+mod outbox;
+mod placement;
+mod player_milestones;
 mod port_alloc;
+mod process_exit;
 mod process_manager;
 mod process_manager_support;
 mod process_service;
 mod sandbox;
+mod selftest;
+mod support_bundle;
 mod templates;
 mod terraria;
 mod terraria_download;
+mod test_mode;
+mod topics;
+mod trace;
+mod upstream;
 
 #[tokio::main]
 async fn main() -> anyhow::Result<()> {
+    // Test mode launches its fake Minecraft server as `alloy-agent fake-minecraft-server ...`.
+    let args: Vec<String> = std::env::args().collect();
+    if args.get(1).map(String::as_str) == Some(test_mode::FAKE_SERVER_ARG) {
+        return test_mode::run_fake_server(&args[2..]).await;
+    }
+
     // Ensure the data root exists early so health checks and instance creation are stable.
     std::fs::create_dir_all(crate::minecraft::data_root())?;
 
     // Persist agent logs under data root and keep stdout logs for docker/dev.
     let log_dir = crate::minecraft::data_root().join("logs");
     std::fs::create_dir_all(&log_dir)?;
-    let file_appender = tracing_appender::rolling::daily(&log_dir, "agent.log");
-    let (file_writer, file_guard) = tracing_appender::non_blocking(file_appender);
-
-    let filter = tracing_subscriber::EnvFilter::from_default_env();
-    tracing_subscriber::registry()
-        .with(filter)
-        .with(
-            tracing_subscriber::fmt::layer()
-                .with_writer(std::io::stdout)
-                .with_ansi(true),
-        )
-        .with(
-            tracing_subscriber::fmt::layer()
-                .with_writer(file_writer)
-                .with_ansi(false),
-        )
-        .init();
-    let _file_guard = file_guard;
+    let _log_guard = logging::init(&log_dir)?;
+
+    for warning in process_manager_support::protect_agent_memory() {
+        tracing::warn!("{warning}");
+    }
 
     cleanup_orphan_processes().await;
 
+    if test_mode::enabled() {
+        test_mode::start_fixtures().await?;
+    }
+
     let addr: SocketAddr = ([0, 0, 0, 0], 50051).into();
     tracing::info!(%addr, "alloy-agent gRPC listening");
 
     let manager = process_manager::ProcessManager::default();
 
     control_tunnel::spawn(manager.clone());
+    autostart::spawn(manager.clone());
+    backup_schedule::spawn(manager.clone());
+    managed_config::spawn();
+    player_milestones::spawn();
+
+    let tcp = grpc_router(manager.clone()).serve(addr);
+    #[cfg(unix)]
+    if let Some(incoming) = local_socket::listen() {
+        let local = grpc_router(manager).serve_with_incoming(incoming);
+        tokio::try_join!(tcp, local)?;
+        return Ok(());
+    }
+    tcp.await?;
 
+    Ok(())
+}
+
+type GrpcLayers = tower::layer::util::Stack<
+    command_policy::PolicyLayer,
+    tower::layer::util::Stack<trace::RequestIdLayer, tower::layer::util::Identity>,
+>;
+
+fn grpc_router(
+    manager: process_manager::ProcessManager,
+) -> tonic::transport::server::Router<GrpcLayers> {
     Server::builder()
+        .layer(trace::RequestIdLayer)
+        .layer(command_policy::PolicyLayer)
         .add_service(health_service::server())
         .add_service(filesystem_service::server())
         .add_service(logs_service::server())
         .add_service(process_service::server(manager.clone()))
         .add_service(instance_service::server(manager))
-        .serve(addr)
-        .await?;
-
-    Ok(())
 }
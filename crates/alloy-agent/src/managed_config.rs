@@ -0,0 +1,379 @@
+use std::{
+    collections::BTreeMap,
+    path::{Component, Path, PathBuf},
+    time::Duration,
+};
+
+use serde::{Deserialize, Serialize};
+use sha2::Digest;
+
+use crate::process_manager_support::env_u64;
+
+// Managed config files. An instance declares files in instance.json (`managed_files`) whose
+// content is pinned; the pinned copy lives in <instance>/.managed/<sha256>. A periodic check
+// compares each file with it, and on drift (a plugin or a person rewrote the file) either reports
+// it (`alert`, once per distinct content) or writes the pinned copy back (`revert`). Every
+// enforcement is pushed to the outbox as a `config_drift` event, so it ends up in control's
+// audit log, and published on `events:config_drift`.
+//
+// ALLOY_CONFIG_DRIFT_INTERVAL_SEC sets how often to check (default 60); 0 disables the check.
+
+pub const BLOB_DIR: &str = ".managed";
+const DEFAULT_INTERVAL_SEC: u64 = 60;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Policy {
+    Alert,
+    Revert,
+}
+
+impl Policy {
+    pub fn parse(raw: &str) -> Option<Self> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" | "alert" => Some(Self::Alert),
+            "revert" => Some(Self::Revert),
+            _ => None,
+        }
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Alert => "alert",
+            Self::Revert => "revert",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ManagedFile {
+    // Relative to the instance dir.
+    pub path: String,
+    pub sha256: String,
+    pub policy: Policy,
+}
+
+// A managed path in canonical form: relative, without `.`/`..`, and not one of the agent's own
+// files.
+pub fn normalize_path(raw: &str) -> anyhow::Result<String> {
+    let p = Path::new(raw.trim());
+    let mut parts = Vec::new();
+    for c in p.components() {
+        match c {
+            Component::CurDir => {}
+            Component::Normal(seg) => parts.push(seg.to_string_lossy().to_string()),
+            Component::ParentDir => anyhow::bail!("path traversal is not allowed"),
+            Component::Prefix(_) | Component::RootDir => {
+                anyhow::bail!("path must be relative to the instance")
+            }
+        }
+    }
+    anyhow::ensure!(!parts.is_empty(), "path is required");
+    anyhow::ensure!(
+        parts[0] != BLOB_DIR && parts != ["instance.json"],
+        "{} is managed by the agent",
+        parts.join("/")
+    );
+    Ok(parts.join("/"))
+}
+
+fn sha256_hex(bytes: &[u8]) -> String {
+    hex::encode(sha2::Sha256::digest(bytes))
+}
+
+fn write_atomic(path: &Path, bytes: &[u8]) -> std::io::Result<()> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let mut tmp = path.as_os_str().to_owned();
+    tmp.push(".alloy-tmp");
+    let tmp = PathBuf::from(tmp);
+    std::fs::write(&tmp, bytes)?;
+    std::fs::rename(&tmp, path)
+}
+
+fn blob_path(instance_dir: &Path, sha256: &str) -> PathBuf {
+    instance_dir.join(BLOB_DIR).join(sha256)
+}
+
+// Pins `path` to `content`, writing it to the file as well, or to the file's current content.
+// Returns the pinned hash.
+pub fn pin(instance_dir: &Path, path: &str, content: Option<Vec<u8>>) -> anyhow::Result<String> {
+    let file = instance_dir.join(path);
+    let bytes = match content {
+        Some(bytes) => {
+            write_atomic(&file, &bytes)?;
+            bytes
+        }
+        None => std::fs::read(&file).map_err(|e| anyhow::anyhow!("cannot read {path}: {e}"))?,
+    };
+    let sha256 = sha256_hex(&bytes);
+    let blob = blob_path(instance_dir, &sha256);
+    if !blob.exists() {
+        write_atomic(&blob, &bytes)?;
+    }
+    Ok(sha256)
+}
+
+// Removes pinned copies no entry of `files` refers to any more.
+pub fn prune(instance_dir: &Path, files: &[ManagedFile]) {
+    let Ok(rd) = std::fs::read_dir(instance_dir.join(BLOB_DIR)) else {
+        return;
+    };
+    for de in rd.flatten() {
+        let name = de.file_name().to_string_lossy().to_string();
+        if !files.iter().any(|f| f.sha256 == name) {
+            let _ = std::fs::remove_file(de.path());
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum State {
+    Ok,
+    Drifted { actual: String },
+    Missing,
+}
+
+impl State {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Ok => "ok",
+            Self::Drifted { .. } => "drifted",
+            Self::Missing => "missing",
+        }
+    }
+
+    pub fn actual(&self) -> Option<&str> {
+        match self {
+            Self::Drifted { actual } => Some(actual),
+            _ => None,
+        }
+    }
+}
+
+pub fn state(instance_dir: &Path, f: &ManagedFile) -> State {
+    match std::fs::read(instance_dir.join(&f.path)) {
+        Ok(bytes) => {
+            let actual = sha256_hex(&bytes);
+            if actual == f.sha256 {
+                State::Ok
+            } else {
+                State::Drifted { actual }
+            }
+        }
+        Err(_) => State::Missing,
+    }
+}
+
+fn revert(instance_dir: &Path, f: &ManagedFile) -> anyhow::Result<()> {
+    let bytes = std::fs::read(blob_path(instance_dir, &f.sha256))
+        .map_err(|e| anyhow::anyhow!("pinned copy is missing: {e}"))?;
+    anyhow::ensure!(sha256_hex(&bytes) == f.sha256, "pinned copy is corrupt");
+    write_atomic(&instance_dir.join(&f.path), &bytes)?;
+    Ok(())
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Enforcement {
+    pub path: String,
+    pub policy: Policy,
+    pub expected: String,
+    // None when the file is missing.
+    pub actual: Option<String>,
+    // "alerted", "reverted" or "revert_failed".
+    pub action: &'static str,
+    pub error: Option<String>,
+}
+
+// Checks one instance's managed files and enforces their policies. `alerted` remembers the drifted
+// content already reported per path, so an unchanged drift is not reported on every pass.
+pub fn enforce(
+    instance_dir: &Path,
+    files: &[ManagedFile],
+    alerted: &mut BTreeMap<String, Option<String>>,
+) -> Vec<Enforcement> {
+    let mut out = Vec::new();
+    for f in files {
+        let st = state(instance_dir, f);
+        if st == State::Ok {
+            alerted.remove(&f.path);
+            continue;
+        }
+        let actual = st.actual().map(str::to_string);
+        let (action, error) = match f.policy {
+            Policy::Alert => {
+                if alerted.get(&f.path) == Some(&actual) {
+                    continue;
+                }
+                alerted.insert(f.path.clone(), actual.clone());
+                ("alerted", None)
+            }
+            Policy::Revert => match revert(instance_dir, f) {
+                Ok(()) => ("reverted", None),
+                Err(e) => {
+                    // Reported once, like an alert, until the content changes again.
+                    if alerted.get(&f.path) == Some(&actual) {
+                        continue;
+                    }
+                    alerted.insert(f.path.clone(), actual.clone());
+                    ("revert_failed", Some(format!("{e:#}")))
+                }
+            },
+        };
+        out.push(Enforcement {
+            path: f.path.clone(),
+            policy: f.policy,
+            expected: f.sha256.clone(),
+            actual,
+            action,
+            error,
+        });
+    }
+    out
+}
+
+#[derive(Debug, Deserialize)]
+struct InstanceJsonForDrift {
+    instance_id: String,
+    #[serde(default)]
+    managed_files: Vec<ManagedFile>,
+}
+
+fn interval() -> Option<Duration> {
+    match env_u64("ALLOY_CONFIG_DRIFT_INTERVAL_SEC").unwrap_or(DEFAULT_INTERVAL_SEC) {
+        0 => None,
+        secs => Some(Duration::from_secs(secs.clamp(5, 24 * 60 * 60))),
+    }
+}
+
+fn record(instance_id: &str, e: &Enforcement) {
+    match e.action {
+        "reverted" => tracing::info!(%instance_id, path = %e.path, "managed config reverted"),
+        _ => tracing::warn!(
+            %instance_id,
+            path = %e.path,
+            action = e.action,
+            error = e.error.as_deref().unwrap_or(""),
+            "managed config drifted"
+        ),
+    }
+    let mut event = serde_json::json!({
+        "instance_id": instance_id,
+        "path": e.path,
+        "policy": e.policy.as_str(),
+        "expected_sha256": e.expected,
+        "actual_sha256": e.actual,
+        "action": e.action,
+        "error": e.error,
+        "detected_at_unix_ms": crate::console_audit::now_unix_ms(),
+    });
+    if let Some(obj) = event.as_object_mut() {
+        obj.extend(crate::clock::metadata());
+    }
+    crate::topics::publish("events:config_drift", || event.clone());
+    crate::outbox::push("config_drift", event);
+}
+
+fn check_all(alerted: &mut BTreeMap<String, BTreeMap<String, Option<String>>>) {
+    let root = crate::minecraft::data_root().join("instances");
+    let Ok(rd) = std::fs::read_dir(&root) else {
+        return;
+    };
+    for de in rd.flatten() {
+        let dir = de.path();
+        let Some(inst) = std::fs::read(dir.join("instance.json"))
+            .ok()
+            .and_then(|raw| serde_json::from_slice::<InstanceJsonForDrift>(&raw).ok())
+        else {
+            continue;
+        };
+        if inst.managed_files.is_empty() {
+            alerted.remove(&inst.instance_id);
+            continue;
+        }
+        let seen = alerted.entry(inst.instance_id.clone()).or_default();
+        seen.retain(|path, _| inst.managed_files.iter().any(|f| &f.path == path));
+        for e in enforce(&dir, &inst.managed_files, seen) {
+            record(&inst.instance_id, &e);
+        }
+    }
+}
+
+pub fn spawn() {
+    let Some(every) = interval() else {
+        tracing::info!("config drift check disabled via ALLOY_CONFIG_DRIFT_INTERVAL_SEC");
+        return;
+    };
+    tokio::spawn(async move {
+        let mut alerted = BTreeMap::new();
+        let mut tick = tokio::time::interval(every);
+        tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+        loop {
+            tick.tick().await;
+            alerted = match tokio::task::spawn_blocking(move || {
+                check_all(&mut alerted);
+                alerted
+            })
+            .await
+            {
+                Ok(v) => v,
+                Err(e) => {
+                    tracing::warn!(error = %e, "config drift check failed");
+                    BTreeMap::new()
+                }
+            };
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn alerts_once_and_reverts() {
+        let dir =
+            std::env::temp_dir().join(format!("alloy-managed-config-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(dir.join("config")).unwrap();
+        std::fs::write(dir.join("config/server.properties"), "pvp=false\n").unwrap();
+
+        let path = normalize_path("./config/server.properties").unwrap();
+        assert_eq!(path, "config/server.properties");
+        assert!(normalize_path("../x").is_err());
+        assert!(normalize_path(".managed/abc").is_err());
+
+        let sha = pin(&dir, &path, None).unwrap();
+        let mut files = vec![ManagedFile {
+            path: path.clone(),
+            sha256: sha.clone(),
+            policy: Policy::Alert,
+        }];
+        let mut alerted = BTreeMap::new();
+        assert!(enforce(&dir, &files, &mut alerted).is_empty());
+
+        std::fs::write(dir.join(&path), "pvp=true\n").unwrap();
+        let first = enforce(&dir, &files, &mut alerted);
+        assert_eq!(first.len(), 1);
+        assert_eq!(first[0].action, "alerted");
+        // The same drift is not reported again.
+        assert!(enforce(&dir, &files, &mut alerted).is_empty());
+
+        files[0].policy = Policy::Revert;
+        let reverted = enforce(&dir, &files, &mut alerted);
+        assert_eq!(reverted[0].action, "reverted");
+        assert_eq!(
+            std::fs::read_to_string(dir.join(&path)).unwrap(),
+            "pvp=false\n"
+        );
+        assert_eq!(state(&dir, &files[0]), State::Ok);
+
+        prune(&dir, &[]);
+        std::fs::remove_file(dir.join(&path)).unwrap();
+        let failed = enforce(&dir, &files, &mut alerted);
+        assert_eq!(failed[0].action, "revert_failed");
+        assert_eq!(failed[0].actual, None);
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,325 @@
+use std::{collections::BTreeMap, io::Read, path::Path};
+
+use serde::{Deserialize, Serialize};
+
+use crate::fs_hash::{self, Algorithm, Mode};
+
+// What a Minecraft server needs to run the same way again: the Java build, the JVM flags of the
+// last run, the server jar and every mod/plugin jar with their hashes, and the values of the
+// known config keys (see mc_perf). Each backup archive carries one as alloy-env.json, so a world
+// restored months later can be compared with what the node would launch today.
+
+pub const FILE: &str = "alloy-env.json";
+
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct FileEntry {
+    // Relative to the instance dir.
+    pub path: String,
+    pub size_bytes: u64,
+    pub sha256: String,
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct Report {
+    pub generated_at_unix_ms: u64,
+    pub instance_id: String,
+    pub template_id: String,
+    #[serde(default)]
+    pub node_id: String,
+    pub agent_version: String,
+    // Full `java -version` output; empty for servers that run in a container.
+    pub java_version: String,
+    // JVM options of the last run, from run.json; empty before the first start.
+    pub jvm_flags: Vec<String>,
+    pub server_jar: Option<FileEntry>,
+    #[serde(default)]
+    pub jar_provider: String,
+    #[serde(default)]
+    pub jar_version: String,
+    // mods/*.jar and plugins/*.jar.
+    pub mods: Vec<FileEntry>,
+    // `<file>:<key>` of every known config key the files set.
+    pub config: BTreeMap<String, String>,
+    // Instance params, secrets redacted.
+    pub params: BTreeMap<String, String>,
+}
+
+#[derive(Deserialize)]
+struct RunJson {
+    exec: String,
+    #[serde(default)]
+    args: Vec<String>,
+    #[serde(default)]
+    container_name: Option<String>,
+}
+
+fn is_java(arg: &str) -> bool {
+    Path::new(arg).file_name().is_some_and(|n| n == "java")
+}
+
+// The options between the java binary and `-jar`. The launch may be wrapped (bwrap), so the java
+// binary is looked for in the arguments too.
+fn jvm_flags(exec: &str, args: &[String]) -> (Option<String>, Vec<String>) {
+    let (java, start) = if is_java(exec) {
+        (exec.to_string(), 0)
+    } else {
+        match args.iter().position(|a| is_java(a)) {
+            Some(i) => (args[i].clone(), i + 1),
+            None => return (None, Vec::new()),
+        }
+    };
+    let end = args[start..]
+        .iter()
+        .position(|a| a == "-jar")
+        .map_or(args.len(), |i| start + i);
+    let flags = args[start..end]
+        .iter()
+        .filter(|a| a.starts_with('-'))
+        .cloned()
+        .collect();
+    (Some(java), flags)
+}
+
+fn java_version(java: &str) -> String {
+    std::process::Command::new(java)
+        .arg("-version")
+        .output()
+        .map(|out| String::from_utf8_lossy(&out.stderr).trim().to_string())
+        .unwrap_or_else(|e| format!("unavailable: {e}"))
+}
+
+fn file_entry(instance_dir: &Path, rel: &str) -> Option<FileEntry> {
+    let (size_bytes, sha256) =
+        fs_hash::hash_file(&instance_dir.join(rel), Algorithm::Sha256, Mode::Full).ok()?;
+    Some(FileEntry {
+        path: rel.to_string(),
+        size_bytes,
+        sha256,
+    })
+}
+
+fn jars(instance_dir: &Path, sub: &str) -> Vec<FileEntry> {
+    let Ok(rd) = std::fs::read_dir(instance_dir.join(sub)) else {
+        return Vec::new();
+    };
+    let mut names: Vec<String> = rd
+        .flatten()
+        .filter(|de| de.file_type().is_ok_and(|t| t.is_file()))
+        .map(|de| de.file_name().to_string_lossy().to_string())
+        .filter(|n| n.ends_with(".jar"))
+        .collect();
+    names.sort();
+    names
+        .into_iter()
+        .filter_map(|n| file_entry(instance_dir, &format!("{sub}/{n}")))
+        .collect()
+}
+
+// Blocking: hashes every jar. Run it on the blocking pool.
+pub fn generate(
+    instance_id: &str,
+    instance_dir: &Path,
+    template_id: &str,
+    params: BTreeMap<String, String>,
+) -> Report {
+    let run = std::fs::read(instance_dir.join("run.json"))
+        .ok()
+        .and_then(|raw| serde_json::from_slice::<RunJson>(&raw).ok());
+    let (java, jvm_flags) = match &run {
+        Some(run) => jvm_flags(&run.exec, &run.args),
+        None => (None, Vec::new()),
+    };
+    let in_container = run.as_ref().is_some_and(|r| r.container_name.is_some());
+    let java_version = if in_container {
+        String::new()
+    } else {
+        java_version(java.as_deref().unwrap_or("java"))
+    };
+
+    let provenance = crate::jar_provenance::read(instance_dir).unwrap_or_default();
+    let mut mods = jars(instance_dir, "mods");
+    mods.extend(jars(instance_dir, "plugins"));
+
+    let config = crate::mc_perf::describe(instance_dir, None)
+        .into_iter()
+        .filter_map(|k| Some((format!("{}:{}", k.spec.file, k.spec.key), k.value?)))
+        .collect();
+
+    Report {
+        generated_at_unix_ms: crate::console_audit::now_unix_ms(),
+        instance_id: instance_id.to_string(),
+        template_id: template_id.to_string(),
+        node_id: crate::node_identity::get().id.clone(),
+        agent_version: env!("CARGO_PKG_VERSION").to_string(),
+        java_version,
+        jvm_flags,
+        server_jar: file_entry(instance_dir, "server.jar"),
+        jar_provider: provenance.provider,
+        jar_version: provenance.version,
+        mods,
+        config,
+        params: crate::process_manager::redact_params(params),
+    }
+}
+
+// The report stored in a backup archive; None for archives made before reports existed.
+pub fn read_from_backup(zip_path: &Path) -> anyhow::Result<Option<Report>> {
+    let mut zip = zip::ZipArchive::new(std::fs::File::open(zip_path)?)?;
+    let mut entry = match zip.by_name(FILE) {
+        Ok(e) => e,
+        Err(zip::result::ZipError::FileNotFound) => return Ok(None),
+        Err(e) => return Err(e.into()),
+    };
+    let mut raw = Vec::new();
+    entry.read_to_end(&mut raw)?;
+    Ok(Some(serde_json::from_slice(&raw)?))
+}
+
+fn first_line(s: &str) -> &str {
+    s.lines().next().unwrap_or_default()
+}
+
+// What changed from `old` (e.g. a backup's report) to `new`, one line per difference.
+pub fn diff(old: &Report, new: &Report) -> Vec<String> {
+    let mut out = Vec::new();
+    if old.template_id != new.template_id {
+        out.push(format!(
+            "template: {} -> {}",
+            old.template_id, new.template_id
+        ));
+    }
+    if first_line(&old.java_version) != first_line(&new.java_version) {
+        out.push(format!(
+            "java: {} -> {}",
+            first_line(&old.java_version),
+            first_line(&new.java_version)
+        ));
+    }
+    if old.jvm_flags != new.jvm_flags {
+        out.push(format!(
+            "jvm flags: {} -> {}",
+            old.jvm_flags.join(" "),
+            new.jvm_flags.join(" ")
+        ));
+    }
+    let jar = |r: &Report| r.server_jar.as_ref().map(|j| j.sha256.clone());
+    if jar(old) != jar(new) {
+        out.push(format!(
+            "server.jar: {} -> {}",
+            jar(old).unwrap_or_else(|| "missing".to_string()),
+            jar(new).unwrap_or_else(|| "missing".to_string())
+        ));
+    }
+
+    let old_mods: BTreeMap<&str, &str> = old
+        .mods
+        .iter()
+        .map(|m| (m.path.as_str(), m.sha256.as_str()))
+        .collect();
+    let new_mods: BTreeMap<&str, &str> = new
+        .mods
+        .iter()
+        .map(|m| (m.path.as_str(), m.sha256.as_str()))
+        .collect();
+    for (path, hash) in &old_mods {
+        match new_mods.get(path) {
+            None => out.push(format!("{path}: removed")),
+            Some(h) if h != hash => out.push(format!("{path}: changed")),
+            Some(_) => {}
+        }
+    }
+    for path in new_mods.keys().filter(|p| !old_mods.contains_key(*p)) {
+        out.push(format!("{path}: added"));
+    }
+
+    for (key, value) in &old.config {
+        match new.config.get(key) {
+            None => out.push(format!("{key}: {value} -> unset")),
+            Some(v) if v != value => out.push(format!("{key}: {value} -> {v}")),
+            Some(_) => {}
+        }
+    }
+    for (key, value) in new
+        .config
+        .iter()
+        .filter(|(k, _)| !old.config.contains_key(*k))
+    {
+        out.push(format!("{key}: unset -> {value}"));
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn extracts_jvm_flags_and_diffs_reports() {
+        let args: Vec<String> = [
+            "--die-with-parent",
+            "--bind",
+            "/data",
+            "/data",
+            "/usr/bin/java",
+            "-Xms1G",
+            "-Xmx4G",
+            "-XX:+UseG1GC",
+            "-jar",
+            "server.jar",
+            "--nogui",
+        ]
+        .iter()
+        .map(|s| s.to_string())
+        .collect();
+        assert_eq!(
+            jvm_flags("bwrap", &args),
+            (
+                Some("/usr/bin/java".to_string()),
+                vec![
+                    "-Xms1G".to_string(),
+                    "-Xmx4G".to_string(),
+                    "-XX:+UseG1GC".to_string()
+                ]
+            )
+        );
+        assert_eq!(jvm_flags("java", &args[5..]).1.len(), 3);
+        assert_eq!(jvm_flags("/opt/server/start.sh", &[]), (None, Vec::new()));
+
+        let entry = |path: &str, sha256: &str| FileEntry {
+            path: path.to_string(),
+            size_bytes: 1,
+            sha256: sha256.to_string(),
+        };
+        let old = Report {
+            template_id: "minecraft:paper".to_string(),
+            java_version: "openjdk version \"21.0.2\" 2024-01-16\nOpenJDK Runtime".to_string(),
+            jvm_flags: vec!["-Xmx4G".to_string()],
+            server_jar: Some(entry("server.jar", "aa")),
+            mods: vec![entry("plugins/a.jar", "1"), entry("plugins/b.jar", "2")],
+            config: BTreeMap::from([(
+                "config/server.properties:view-distance".to_string(),
+                "10".to_string(),
+            )]),
+            ..Report::default()
+        };
+        let mut new = old.clone();
+        assert!(diff(&old, &new).is_empty());
+
+        new.java_version = "openjdk version \"21.0.4\" 2024-07-16".to_string();
+        new.mods = vec![entry("plugins/a.jar", "9"), entry("plugins/c.jar", "3")];
+        new.config.insert(
+            "config/server.properties:view-distance".to_string(),
+            "8".to_string(),
+        );
+        assert_eq!(
+            diff(&old, &new),
+            [
+                "java: openjdk version \"21.0.2\" 2024-01-16 -> openjdk version \"21.0.4\" 2024-07-16",
+                "plugins/a.jar: changed",
+                "plugins/b.jar: removed",
+                "plugins/c.jar: added",
+                "config/server.properties:view-distance: 10 -> 8",
+            ]
+        );
+    }
+}
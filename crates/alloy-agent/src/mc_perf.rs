@@ -0,0 +1,557 @@
+use std::path::Path;
+
+// Known keys of server.properties and the Paper/Spigot/Purpur/Bukkit YAML configs: their type,
+// valid range and default, and for the ones that matter for tick time, the values known to cause
+// lag with a suggested replacement. Feeds the config editor's schema and the performance audit.
+// Paths are relative to the instance dir; Paper 1.19+ keeps its files in config/.
+
+pub const SERVER_PROPERTIES: &str = "config/server.properties";
+pub const SPIGOT: &str = "spigot.yml";
+pub const BUKKIT: &str = "bukkit.yml";
+pub const PAPER_GLOBAL: &str = "config/paper-global.yml";
+pub const PAPER_WORLD: &str = "config/paper-world-defaults.yml";
+pub const PURPUR: &str = "purpur.yml";
+
+pub const FILES: &[&str] = &[
+    SERVER_PROPERTIES,
+    SPIGOT,
+    BUKKIT,
+    PAPER_GLOBAL,
+    PAPER_WORLD,
+    PURPUR,
+];
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Kind {
+    Int { min: i64, max: i64 },
+    Float { min: f64, max: f64 },
+    Bool,
+}
+
+impl Kind {
+    pub fn name(self) -> &'static str {
+        match self {
+            Self::Int { .. } => "int",
+            Self::Float { .. } => "float",
+            Self::Bool => "bool",
+        }
+    }
+
+    pub fn range(self) -> Option<(f64, f64)> {
+        match self {
+            Self::Int { min, max } => Some((min as f64, max as f64)),
+            Self::Float { min, max } => Some((min, max)),
+            Self::Bool => None,
+        }
+    }
+
+    // Why `value` is not valid for this key, if it isn't. Paper accepts `default` for most
+    // numeric settings.
+    fn check(self, value: &str) -> Option<String> {
+        match self {
+            _ if value == "default" => None,
+            Self::Bool => {
+                (!matches!(value, "true" | "false")).then(|| "must be true or false".to_string())
+            }
+            Self::Int { min, max } => match value.parse::<i64>() {
+                Ok(v) if (min..=max).contains(&v) => None,
+                Ok(_) => Some(format!("must be between {min} and {max}")),
+                Err(_) => Some("must be a whole number".to_string()),
+            },
+            Self::Float { min, max } => match value.parse::<f64>() {
+                Ok(v) if (min..=max).contains(&v) => None,
+                Ok(_) => Some(format!("must be between {min} and {max}")),
+                Err(_) => Some("must be a number".to_string()),
+            },
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy)]
+enum Lag {
+    Above(f64),
+    Below(f64),
+    Is(&'static str),
+}
+
+impl Lag {
+    fn hit(self, value: &str) -> bool {
+        match self {
+            Self::Above(t) => value.parse::<f64>().is_ok_and(|v| v > t),
+            Self::Below(t) => value.parse::<f64>().is_ok_and(|v| v < t),
+            Self::Is(v) => value == v,
+        }
+    }
+}
+
+pub struct Spec {
+    pub file: &'static str,
+    pub key: &'static str,
+    pub kind: Kind,
+    pub default: &'static str,
+    pub description: &'static str,
+    // The lagging values and what to use instead.
+    lag: Option<(Lag, &'static str)>,
+}
+
+impl Spec {
+    pub fn perf(&self) -> bool {
+        self.lag.is_some()
+    }
+}
+
+const fn int(min: i64, max: i64) -> Kind {
+    Kind::Int { min, max }
+}
+
+const fn float(min: f64, max: f64) -> Kind {
+    Kind::Float { min, max }
+}
+
+const SPECS: &[Spec] = &[
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "view-distance",
+        kind: int(2, 32),
+        default: "10",
+        description: "Chunks sent to players in each direction.",
+        lag: Some((Lag::Above(10.0), "10")),
+    },
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "simulation-distance",
+        kind: int(2, 32),
+        default: "10",
+        description: "Chunks around players in which entities and blocks are ticked.",
+        lag: Some((Lag::Above(10.0), "8")),
+    },
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "entity-broadcast-range-percentage",
+        kind: int(10, 1000),
+        default: "100",
+        description: "How far away entities are sent to clients, in percent of the default.",
+        lag: Some((Lag::Above(100.0), "100")),
+    },
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "network-compression-threshold",
+        kind: int(-1, 65535),
+        default: "256",
+        description: "Packets at least this big are compressed; -1 disables compression.",
+        lag: None,
+    },
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "max-tick-time",
+        kind: int(-1, i64::MAX),
+        default: "60000",
+        description: "Milliseconds a single tick may take before the watchdog stops the server.",
+        lag: None,
+    },
+    Spec {
+        file: SERVER_PROPERTIES,
+        key: "sync-chunk-writes",
+        kind: Kind::Bool,
+        default: "true",
+        description: "Write region files synchronously.",
+        lag: None,
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-activation-range.animals",
+        kind: int(0, 128),
+        default: "32",
+        description: "Blocks from a player within which animals are ticked.",
+        lag: Some((Lag::Above(32.0), "32")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-activation-range.monsters",
+        kind: int(0, 128),
+        default: "32",
+        description: "Blocks from a player within which monsters are ticked.",
+        lag: Some((Lag::Above(32.0), "32")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-activation-range.raiders",
+        kind: int(0, 128),
+        default: "48",
+        description: "Blocks from a player within which raiders are ticked.",
+        lag: Some((Lag::Above(48.0), "48")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-activation-range.misc",
+        kind: int(0, 128),
+        default: "16",
+        description: "Blocks from a player within which other entities are ticked.",
+        lag: Some((Lag::Above(16.0), "16")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-activation-range.villagers",
+        kind: int(0, 128),
+        default: "32",
+        description: "Blocks from a player within which villagers are ticked.",
+        lag: Some((Lag::Above(32.0), "16")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.entity-tracking-range.players",
+        kind: int(0, 512),
+        default: "48",
+        description: "Blocks within which players see other players.",
+        lag: Some((Lag::Above(128.0), "48")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.mob-spawn-range",
+        kind: int(1, 16),
+        default: "8",
+        description: "Chunks around players in which mobs spawn.",
+        lag: Some((Lag::Above(8.0), "6")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.merge-radius.item",
+        kind: float(0.0, 16.0),
+        default: "2.5",
+        description: "Blocks within which dropped items merge.",
+        lag: Some((Lag::Below(1.0), "2.5")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.merge-radius.exp",
+        kind: float(-1.0, 16.0),
+        default: "3.0",
+        description: "Blocks within which experience orbs merge; -1 disables merging.",
+        lag: Some((Lag::Below(1.0), "3.0")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.ticks-per.hopper-transfer",
+        kind: int(1, 1200),
+        default: "8",
+        description: "Ticks between hopper item transfers.",
+        lag: Some((Lag::Below(8.0), "8")),
+    },
+    Spec {
+        file: SPIGOT,
+        key: "world-settings.default.item-despawn-rate",
+        kind: int(0, i64::MAX),
+        default: "6000",
+        description: "Ticks before a dropped item despawns.",
+        lag: Some((Lag::Above(6000.0), "6000")),
+    },
+    Spec {
+        file: BUKKIT,
+        key: "spawn-limits.monsters",
+        kind: int(-1, 1000),
+        default: "70",
+        description: "Monsters per player that may exist at once.",
+        lag: Some((Lag::Above(70.0), "70")),
+    },
+    Spec {
+        file: BUKKIT,
+        key: "spawn-limits.animals",
+        kind: int(-1, 1000),
+        default: "10",
+        description: "Animals per player that may exist at once.",
+        lag: Some((Lag::Above(10.0), "10")),
+    },
+    Spec {
+        file: BUKKIT,
+        key: "ticks-per.monster-spawns",
+        kind: int(0, 1000),
+        default: "1",
+        description: "Ticks between monster spawn attempts.",
+        lag: None,
+    },
+    Spec {
+        file: BUKKIT,
+        key: "ticks-per.autosave",
+        kind: int(0, i64::MAX),
+        default: "6000",
+        description: "Ticks between world saves; 0 leaves saving to Paper's own schedule.",
+        lag: Some((Lag::Below(1200.0), "6000")),
+    },
+    Spec {
+        file: PAPER_GLOBAL,
+        key: "chunk-system.worker-threads",
+        kind: int(-1, 256),
+        default: "-1",
+        description: "Threads for chunk generation and loading; -1 picks one from the core count.",
+        lag: None,
+    },
+    Spec {
+        file: PAPER_GLOBAL,
+        key: "misc.max-joins-per-tick",
+        kind: int(1, 1000),
+        default: "5",
+        description: "Players let in per tick while many connect at once.",
+        lag: Some((Lag::Above(10.0), "5")),
+    },
+    Spec {
+        file: PAPER_GLOBAL,
+        key: "timings.enabled",
+        kind: Kind::Bool,
+        default: "true",
+        description: "Collect timings reports. The spark profiler costs less.",
+        lag: Some((Lag::Is("true"), "false")),
+    },
+    Spec {
+        file: PAPER_WORLD,
+        key: "chunks.max-auto-save-chunks-per-tick",
+        kind: int(1, 1000),
+        default: "24",
+        description: "Chunks saved per tick during an autosave.",
+        lag: Some((Lag::Above(24.0), "24")),
+    },
+    Spec {
+        file: PAPER_WORLD,
+        key: "chunks.prevent-moving-into-unloaded-chunks",
+        kind: Kind::Bool,
+        default: "false",
+        description: "Stop players moving into chunks that are not loaded yet.",
+        lag: Some((Lag::Is("false"), "true")),
+    },
+    Spec {
+        file: PAPER_WORLD,
+        key: "collisions.max-entity-collisions",
+        kind: int(0, 1000),
+        default: "8",
+        description: "Collisions an entity processes per tick.",
+        lag: Some((Lag::Above(8.0), "8")),
+    },
+    Spec {
+        file: PAPER_WORLD,
+        key: "environment.optimize-explosions",
+        kind: Kind::Bool,
+        default: "false",
+        description: "Cache explosion calculations.",
+        lag: Some((Lag::Is("false"), "true")),
+    },
+    Spec {
+        file: PAPER_WORLD,
+        key: "hopper.disable-move-event",
+        kind: Kind::Bool,
+        default: "false",
+        description: "Skip InventoryMoveItemEvent for hoppers. Breaks plugins that protect containers with it.",
+        lag: None,
+    },
+    Spec {
+        file: PURPUR,
+        key: "world-settings.default.mobs.villager.brain-ticks",
+        kind: int(1, 100),
+        default: "1",
+        description: "Ticks between villager AI updates.",
+        lag: None,
+    },
+];
+
+pub fn specs() -> &'static [Spec] {
+    SPECS
+}
+
+// The settings of one file as flat `key -> value`; None when the file does not exist or cannot
+// be parsed.
+fn read_values(instance_dir: &Path, file: &str) -> Option<Vec<(String, String)>> {
+    let raw = std::fs::read_to_string(instance_dir.join(file)).ok()?;
+    if file.ends_with(".properties") {
+        return Some(
+            raw.lines()
+                .filter(|l| !l.trim_start().starts_with('#'))
+                .filter_map(|l| l.split_once('='))
+                .map(|(k, v)| (k.trim().to_string(), v.trim().to_string()))
+                .collect(),
+        );
+    }
+    let doc: serde_yaml::Value = serde_yaml::from_str(&raw).ok()?;
+    let mut out = Vec::new();
+    flatten("", &doc, &mut out);
+    Some(out)
+}
+
+fn flatten(prefix: &str, v: &serde_yaml::Value, out: &mut Vec<(String, String)>) {
+    use serde_yaml::Value;
+    match v {
+        Value::Mapping(m) => {
+            for (k, child) in m {
+                let k = match k {
+                    Value::String(s) => s.clone(),
+                    Value::Number(n) => n.to_string(),
+                    Value::Bool(b) => b.to_string(),
+                    _ => continue,
+                };
+                let path = if prefix.is_empty() {
+                    k
+                } else {
+                    format!("{prefix}.{k}")
+                };
+                flatten(&path, child, out);
+            }
+        }
+        Value::String(s) => out.push((prefix.to_string(), s.clone())),
+        Value::Number(n) => out.push((prefix.to_string(), n.to_string())),
+        Value::Bool(b) => out.push((prefix.to_string(), b.to_string())),
+        _ => {}
+    }
+}
+
+fn lookup<'a>(values: &'a [(String, String)], key: &str) -> Option<&'a str> {
+    values
+        .iter()
+        .find(|(k, _)| k == key)
+        .map(|(_, v)| v.as_str())
+}
+
+pub struct KeyState {
+    pub spec: &'static Spec,
+    // None when the file doesn't set it.
+    pub value: Option<String>,
+    pub problem: Option<String>,
+}
+
+// The known keys of the files present in `instance_dir` (or of `only`) with their current values.
+pub fn describe(instance_dir: &Path, only: Option<&str>) -> Vec<KeyState> {
+    let mut out = Vec::new();
+    for file in FILES.iter().filter(|f| only.is_none_or(|o| o == **f)) {
+        let Some(values) = read_values(instance_dir, file) else {
+            continue;
+        };
+        for spec in SPECS.iter().filter(|s| s.file == *file) {
+            let value = lookup(&values, spec.key).map(str::to_string);
+            let problem = value.as_deref().and_then(|v| spec.kind.check(v));
+            out.push(KeyState {
+                spec,
+                value,
+                problem,
+            });
+        }
+    }
+    out
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Severity {
+    Invalid,
+    Lag,
+}
+
+impl Severity {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Invalid => "invalid",
+            Self::Lag => "lag",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Finding {
+    pub file: &'static str,
+    pub key: &'static str,
+    pub value: String,
+    // The file doesn't set the key and the server's default applies.
+    pub is_default: bool,
+    pub suggested: String,
+    pub severity: Severity,
+    pub message: String,
+}
+
+pub struct Audit {
+    pub files: Vec<&'static str>,
+    pub findings: Vec<Finding>,
+}
+
+// Flags invalid values and settings known to cause lag in the files present in `instance_dir`.
+pub fn audit(instance_dir: &Path) -> Audit {
+    let mut files = Vec::new();
+    let mut findings = Vec::new();
+    for file in FILES {
+        let Some(values) = read_values(instance_dir, file) else {
+            continue;
+        };
+        files.push(*file);
+        for spec in SPECS.iter().filter(|s| s.file == *file) {
+            let set = lookup(&values, spec.key).filter(|v| *v != "default");
+            let value = set.unwrap_or(spec.default);
+            if let Some(problem) = spec.kind.check(value) {
+                findings.push(Finding {
+                    file,
+                    key: spec.key,
+                    value: value.to_string(),
+                    is_default: set.is_none(),
+                    suggested: spec.default.to_string(),
+                    severity: Severity::Invalid,
+                    message: problem,
+                });
+                continue;
+            }
+            let Some((lag, suggested)) = spec.lag else {
+                continue;
+            };
+            if lag.hit(value) {
+                findings.push(Finding {
+                    file,
+                    key: spec.key,
+                    value: value.to_string(),
+                    is_default: set.is_none(),
+                    suggested: suggested.to_string(),
+                    severity: Severity::Lag,
+                    message: spec.description.to_string(),
+                });
+            }
+        }
+    }
+    Audit { files, findings }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn flags_lagging_and_invalid_settings() {
+        let dir = std::env::temp_dir().join(format!("alloy-mc-perf-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(dir.join("config")).unwrap();
+        std::fs::write(
+            dir.join(SERVER_PROPERTIES),
+            "#Minecraft server properties\nview-distance=24\nsimulation-distance=abc\n",
+        )
+        .unwrap();
+        std::fs::write(
+            dir.join(SPIGOT),
+            "world-settings:\n  default:\n    entity-activation-range:\n      animals: 64\n      monsters: 32\n",
+        )
+        .unwrap();
+
+        let report = audit(&dir);
+        assert_eq!(report.files, [SERVER_PROPERTIES, SPIGOT]);
+        let find = |key: &str| report.findings.iter().find(|f| f.key == key);
+        let view = find("view-distance").unwrap();
+        assert_eq!(
+            (view.value.as_str(), view.severity.as_str(), view.is_default),
+            ("24", "lag", false)
+        );
+        assert_eq!(
+            find("simulation-distance").unwrap().severity,
+            Severity::Invalid
+        );
+        assert_eq!(
+            find("world-settings.default.entity-activation-range.animals")
+                .unwrap()
+                .suggested,
+            "32"
+        );
+        assert!(find("world-settings.default.entity-activation-range.monsters").is_none());
+
+        let keys = describe(&dir, Some(SPIGOT));
+        assert!(keys.iter().all(|k| k.spec.file == SPIGOT));
+        assert!(keys.iter().any(|k| k.value.as_deref() == Some("64")));
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
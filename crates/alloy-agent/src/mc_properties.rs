@@ -0,0 +1,357 @@
+use std::path::{Path, PathBuf};
+
+// Named sets of server.properties values applied in one write. A few profiles ship with the agent;
+// user profiles live in <data root>/property-profiles/<name>.properties, a description comment
+// followed by key=value lines. Profiles never touch the keys the agent manages itself.
+
+const DIR: &str = "property-profiles";
+const MANAGED_KEYS: &[&str] = &["server-port", "server-ip"];
+
+struct Builtin {
+    name: &'static str,
+    description: &'static str,
+    values: &'static [(&'static str, &'static str)],
+}
+
+const BUILTINS: &[Builtin] = &[
+    Builtin {
+        name: "hardcore",
+        description: "One life on hard difficulty; dead players become spectators.",
+        values: &[
+            ("hardcore", "true"),
+            ("difficulty", "hard"),
+            ("gamemode", "survival"),
+            ("force-gamemode", "true"),
+            ("spawn-monsters", "true"),
+        ],
+    },
+    Builtin {
+        name: "creative-build",
+        description: "Creative building server without monsters or PvP.",
+        values: &[
+            ("gamemode", "creative"),
+            ("force-gamemode", "true"),
+            ("difficulty", "peaceful"),
+            ("spawn-monsters", "false"),
+            ("pvp", "false"),
+            ("allow-flight", "true"),
+        ],
+    },
+    Builtin {
+        name: "anti-grief-basics",
+        description: "Whitelist only, authenticated accounts, protected spawn, no command blocks.",
+        values: &[
+            ("white-list", "true"),
+            ("enforce-whitelist", "true"),
+            ("online-mode", "true"),
+            ("spawn-protection", "16"),
+            ("enable-command-block", "false"),
+        ],
+    },
+];
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Profile {
+    pub name: String,
+    pub description: String,
+    pub builtin: bool,
+    pub values: Vec<(String, String)>,
+}
+
+fn profiles_dir() -> PathBuf {
+    crate::minecraft::data_root().join(DIR)
+}
+
+fn valid_name(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= 48
+        && name
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-' || c == '_')
+}
+
+fn valid_key(key: &str) -> bool {
+    !key.is_empty()
+        && key
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '.' | '_'))
+}
+
+fn check_values(values: &[(String, String)]) -> anyhow::Result<()> {
+    anyhow::ensure!(!values.is_empty(), "profile has no properties");
+    for (k, v) in values {
+        anyhow::ensure!(valid_key(k), "invalid property key {k:?}");
+        anyhow::ensure!(
+            !MANAGED_KEYS.contains(&k.as_str()),
+            "{k} is managed by the agent and cannot be set by a profile"
+        );
+        anyhow::ensure!(
+            !v.contains(['\n', '\r']),
+            "value of {k} must be a single line"
+        );
+    }
+    Ok(())
+}
+
+fn parse_file(name: &str, raw: &str) -> Profile {
+    let mut description = String::new();
+    let mut values: Vec<(String, String)> = Vec::new();
+    for line in raw.lines() {
+        let line = line.trim();
+        if let Some(comment) = line.strip_prefix('#') {
+            if description.is_empty() && values.is_empty() {
+                description = comment.trim().to_string();
+            }
+            continue;
+        }
+        let Some((k, v)) = line.split_once('=') else {
+            continue;
+        };
+        let k = k.trim();
+        if !valid_key(k) || MANAGED_KEYS.contains(&k) {
+            continue;
+        }
+        values.retain(|(existing, _)| existing != k);
+        values.push((k.to_string(), v.trim().to_string()));
+    }
+    Profile {
+        name: name.to_string(),
+        description,
+        builtin: false,
+        values,
+    }
+}
+
+fn builtin(b: &Builtin) -> Profile {
+    Profile {
+        name: b.name.to_string(),
+        description: b.description.to_string(),
+        builtin: true,
+        values: b
+            .values
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect(),
+    }
+}
+
+// Built-in profiles first, then the user's by name.
+pub fn list() -> Vec<Profile> {
+    let mut out: Vec<Profile> = BUILTINS.iter().map(builtin).collect();
+    let mut user = Vec::new();
+    if let Ok(rd) = std::fs::read_dir(profiles_dir()) {
+        for de in rd.flatten() {
+            let path = de.path();
+            let Some(name) = path
+                .file_name()
+                .and_then(|n| n.to_str())
+                .and_then(|n| n.strip_suffix(".properties"))
+            else {
+                continue;
+            };
+            if !valid_name(name) || BUILTINS.iter().any(|b| b.name == name) {
+                continue;
+            }
+            if let Ok(raw) = std::fs::read_to_string(&path) {
+                user.push(parse_file(name, &raw));
+            }
+        }
+    }
+    user.sort_by(|a, b| a.name.cmp(&b.name));
+    out.extend(user);
+    out
+}
+
+pub fn find(name: &str) -> Option<Profile> {
+    list().into_iter().find(|p| p.name == name)
+}
+
+// Creates or replaces a user profile.
+pub fn save(
+    name: &str,
+    description: &str,
+    values: Vec<(String, String)>,
+) -> anyhow::Result<Profile> {
+    let name = name.trim();
+    anyhow::ensure!(
+        valid_name(name),
+        "profile name must be 1-48 characters of a-z, 0-9, - and _"
+    );
+    anyhow::ensure!(
+        !BUILTINS.iter().any(|b| b.name == name),
+        "{name} is a built-in profile"
+    );
+    let values: Vec<(String, String)> = values
+        .into_iter()
+        .map(|(k, v)| (k.trim().to_string(), v.trim().to_string()))
+        .collect();
+    check_values(&values)?;
+
+    let description = description.trim().replace(['\n', '\r'], " ");
+    let mut raw = String::new();
+    if !description.is_empty() {
+        raw.push_str(&format!("# {description}\n"));
+    }
+    for (k, v) in &values {
+        raw.push_str(&format!("{k}={v}\n"));
+    }
+    let dir = profiles_dir();
+    std::fs::create_dir_all(&dir)?;
+    let path = dir.join(format!("{name}.properties"));
+    let tmp = dir.join(format!(".{name}.properties.tmp"));
+    std::fs::write(&tmp, raw.as_bytes())?;
+    std::fs::rename(&tmp, &path)?;
+    Ok(parse_file(name, &raw))
+}
+
+// Removes a user profile; false when there was none.
+pub fn delete(name: &str) -> anyhow::Result<bool> {
+    let name = name.trim();
+    anyhow::ensure!(
+        !BUILTINS.iter().any(|b| b.name == name),
+        "built-in profiles cannot be deleted"
+    );
+    anyhow::ensure!(valid_name(name), "invalid profile name");
+    match std::fs::remove_file(profiles_dir().join(format!("{name}.properties"))) {
+        Ok(()) => Ok(true),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(false),
+        Err(e) => Err(e.into()),
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Change {
+    pub key: String,
+    // None when the key was not in the file.
+    pub old: Option<String>,
+    pub new: String,
+}
+
+// server.properties with `values` applied: existing keys are replaced in place, new ones are
+// appended, comments and everything else are kept. Returns the new contents and what changed.
+fn merge(raw: &str, values: &[(String, String)]) -> (String, Vec<Change>) {
+    let mut changes = Vec::new();
+    let mut seen = Vec::new();
+    let mut out = String::with_capacity(raw.len() + 64);
+    for line in raw.lines() {
+        let key = line
+            .trim_start()
+            .split_once('=')
+            .filter(|_| !line.trim_start().starts_with('#'))
+            .map(|(k, v)| (k.trim(), v));
+        if let Some((k, old)) = key
+            && let Some((_, new)) = values.iter().find(|(vk, _)| vk == k)
+        {
+            if !seen.iter().any(|s| s == k) {
+                seen.push(k.to_string());
+                if old != new {
+                    changes.push(Change {
+                        key: k.to_string(),
+                        old: Some(old.to_string()),
+                        new: new.clone(),
+                    });
+                }
+                out.push_str(&format!("{k}={new}\n"));
+            }
+            continue;
+        }
+        out.push_str(line);
+        out.push('\n');
+    }
+    for (k, v) in values {
+        if !seen.iter().any(|s| s == k) {
+            seen.push(k.clone());
+            changes.push(Change {
+                key: k.clone(),
+                old: None,
+                new: v.clone(),
+            });
+            out.push_str(&format!("{k}={v}\n"));
+        }
+    }
+    (out, changes)
+}
+
+// The values of `profiles` combined in order, later profiles overriding earlier ones.
+pub fn combine(profiles: &[Profile]) -> Vec<(String, String)> {
+    let mut out: Vec<(String, String)> = Vec::new();
+    for p in profiles {
+        for (k, v) in &p.values {
+            match out.iter_mut().find(|(ek, _)| ek == k) {
+                Some(existing) => existing.1 = v.clone(),
+                None => out.push((k.clone(), v.clone())),
+            }
+        }
+    }
+    out
+}
+
+// Applies `values` to the instance's server.properties, or only reports the diff with `dry_run`.
+pub fn apply(
+    instance_dir: &Path,
+    values: &[(String, String)],
+    dry_run: bool,
+) -> anyhow::Result<Vec<Change>> {
+    check_values(values)?;
+    let config_dir = instance_dir.join("config");
+    let path = config_dir.join("server.properties");
+    let raw = match std::fs::read_to_string(&path) {
+        Ok(v) => v,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => String::new(),
+        Err(e) => return Err(e.into()),
+    };
+    let (next, changes) = merge(&raw, values);
+    if dry_run || changes.is_empty() {
+        return Ok(changes);
+    }
+    std::fs::create_dir_all(&config_dir)?;
+    let tmp = config_dir.join(".server.properties.tmp");
+    std::fs::write(&tmp, next.as_bytes())?;
+    std::fs::rename(&tmp, &path)?;
+    Ok(changes)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn merges_profiles_into_properties() {
+        let raw = "#Minecraft server properties\ndifficulty=easy\npvp=true\nserver-port=25565\n";
+        let profiles = [
+            builtin(&BUILTINS[0]),
+            parse_file(
+                "mine",
+                "# no pvp\npvp=false\ndifficulty=hard\nserver-port=1\n",
+            ),
+        ];
+        assert_eq!(profiles[1].description, "no pvp");
+        let values = combine(&profiles);
+        assert!(values.iter().all(|(k, _)| k != "server-port"));
+
+        let (out, changes) = merge(raw, &values);
+        assert!(out.starts_with("#Minecraft server properties\ndifficulty=hard\npvp=false\n"));
+        assert!(out.contains("server-port=25565\n"));
+        assert!(out.contains("hardcore=true\n"));
+        assert_eq!(
+            changes[0],
+            Change {
+                key: "difficulty".to_string(),
+                old: Some("easy".to_string()),
+                new: "hard".to_string(),
+            }
+        );
+        assert!(
+            changes
+                .iter()
+                .any(|c| c.key == "hardcore" && c.old.is_none())
+        );
+
+        // Applying again changes nothing.
+        assert!(merge(&out, &values).1.is_empty());
+
+        let bad = vec![("server-ip".to_string(), "10.0.0.1".to_string())];
+        assert!(check_values(&bad).is_err());
+        assert!(!valid_name("../x"));
+    }
+}
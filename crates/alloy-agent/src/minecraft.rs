@@ -119,6 +119,42 @@ pub fn instance_dir(process_id: &str) -> PathBuf {
     data_root().join("instances").join(process_id)
 }
 
+// `server-ip` from the instance's server.properties, when it is set to an IP literal. Unset means
+// the server listens on every address.
+pub fn server_ip(instance_dir: &Path) -> Option<std::net::IpAddr> {
+    let props = fs::read_to_string(instance_dir.join("config").join("server.properties"))
+        .or_else(|_| fs::read_to_string(instance_dir.join("server.properties")))
+        .ok()?;
+    props
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("server-ip="))
+        .and_then(crate::netaddr::parse_ip)
+}
+
+// Sets `server-ip` in config/server.properties, for an instance pinned to `ip` (see bind_pool).
+pub fn set_server_ip(instance_dir: &Path, ip: std::net::IpAddr) -> anyhow::Result<()> {
+    let path = instance_dir.join("config").join("server.properties");
+    let existing = fs::read_to_string(&path).unwrap_or_default();
+    let mut out = String::new();
+    let mut wrote = false;
+    for line in existing.lines() {
+        if line.trim_start().starts_with("server-ip=") {
+            if !wrote {
+                out.push_str(&format!("server-ip={ip}\n"));
+                wrote = true;
+            }
+            continue;
+        }
+        out.push_str(line);
+        out.push('\n');
+    }
+    if !wrote {
+        out.push_str(&format!("server-ip={ip}\n"));
+    }
+    fs::write(path, out.as_bytes())?;
+    Ok(())
+}
+
 pub fn ensure_vanilla_instance_layout(
     instance_dir: &Path,
     params: &VanillaParams,
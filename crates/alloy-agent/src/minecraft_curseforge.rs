@@ -15,7 +15,7 @@ use tokio::sync::Mutex;
 
 use crate::minecraft;
 
-const CF_API_BASE: &str = "https://api.curseforge.com/v1";
+pub(crate) const CF_API_BASE: &str = "https://api.curseforge.com/v1";
 const CF_GAME_ID_MINECRAFT: u32 = 432;
 const CF_CLASS_ID_MODPACKS: u32 = 4471;
 
@@ -429,17 +429,14 @@ async fn resolve_mod_id_by_slug(api_key: &str, slug: &str) -> anyhow::Result<u32
         .append_pair("gameId", &CF_GAME_ID_MINECRAFT.to_string())
         .append_pair("classId", &CF_CLASS_ID_MODPACKS.to_string())
         .append_pair("slug", slug);
-    let resp = http_client()
-        .get(url)
-        .header("x-api-key", api_key)
-        .send()
-        .await
-        .context("curseforge search")?
-        .error_for_status()
-        .context("curseforge search (status)")?
-        .json::<SearchModsResponse>()
-        .await
-        .context("parse curseforge search json")?;
+    let resp = crate::upstream::get_json::<SearchModsResponse>(
+        crate::upstream::CURSEFORGE,
+        url.as_str(),
+        |u| http_client().get(u).header("x-api-key", api_key),
+    )
+    .await
+    .context("curseforge search")?
+    .value;
 
     let hit = resp
         .data
@@ -466,17 +463,13 @@ struct ModFile {
 
 async fn get_mod_file(api_key: &str, mod_id: u32, file_id: u32) -> anyhow::Result<ModFile> {
     let url = format!("{CF_API_BASE}/mods/{mod_id}/files/{file_id}");
-    let resp = http_client()
-        .get(url)
-        .header("x-api-key", api_key)
-        .send()
+    let resp =
+        crate::upstream::get_json::<ModFileResponse>(crate::upstream::CURSEFORGE, &url, |u| {
+            http_client().get(u).header("x-api-key", api_key)
+        })
         .await
         .context("curseforge get file")?
-        .error_for_status()
-        .context("curseforge get file (status)")?
-        .json::<ModFileResponse>()
-        .await
-        .context("parse curseforge file json")?;
+        .value;
     Ok(resp.data)
 }
 
@@ -487,17 +480,13 @@ struct DownloadUrlResponse {
 
 async fn get_download_url(api_key: &str, mod_id: u32, file_id: u32) -> anyhow::Result<String> {
     let url = format!("{CF_API_BASE}/mods/{mod_id}/files/{file_id}/download-url");
-    let resp = http_client()
-        .get(url)
-        .header("x-api-key", api_key)
-        .send()
+    let resp =
+        crate::upstream::get_json::<DownloadUrlResponse>(crate::upstream::CURSEFORGE, &url, |u| {
+            http_client().get(u).header("x-api-key", api_key)
+        })
         .await
         .context("curseforge get download url")?
-        .error_for_status()
-        .context("curseforge get download url (status)")?
-        .json::<DownloadUrlResponse>()
-        .await
-        .context("parse curseforge download url json")?;
+        .value;
     let out = resp.data.trim().to_string();
     if out.is_empty() {
         anyhow::bail!("curseforge download url is empty");
@@ -505,6 +494,7 @@ async fn get_download_url(api_key: &str, mod_id: u32, file_id: u32) -> anyhow::R
     Ok(out)
 }
 
+#[tracing::instrument(name = "http.download", skip(path), fields(url = %url))]
 async fn download_to_path(url: &str, path: &Path) -> anyhow::Result<()> {
     if let Some(parent) = path.parent() {
         tokio::fs::create_dir_all(parent).await?;
@@ -33,6 +33,7 @@ fn download_chunk_threshold(total_bytes: u64) -> u64 {
     }
 }
 
+#[tracing::instrument(name = "http.download", skip_all, fields(url = %url))]
 pub async fn download_bytes_with_progress<F>(
     url: Url,
     expected_size: Option<u64>,
@@ -153,9 +154,14 @@ pub struct ResolvedServerJar {
     pub sha1: String,
     pub size: u64,
     pub java_major: u32,
+    // Resolved from cached metadata because Mojang's API was unreachable.
+    pub is_stale: bool,
 }
 
-fn manifest_url() -> String {
+pub(crate) fn manifest_url() -> String {
+    if let Some(url) = crate::test_mode::manifest_url() {
+        return url;
+    }
     std::env::var("ALLOY_MINECRAFT_MANIFEST_URL")
         .ok()
         .map(|v| v.trim().to_string())
@@ -171,37 +177,35 @@ pub async fn resolve_server_jar(version: &str) -> anyhow::Result<ResolvedServerJ
         .timeout(Duration::from_secs(60))
         .build()?;
 
-    let manifest: VersionManifestV2 = client
-        .get(manifest_url())
-        .send()
-        .await
-        .context("fetch version manifest")?
-        .error_for_status()?
-        .json()
+    let url = manifest_url();
+    let manifest =
+        crate::upstream::get_json::<VersionManifestV2>(crate::upstream::MOJANG, &url, |u| {
+            client.get(u)
+        })
         .await
-        .context("parse version manifest")?;
+        .context("fetch version manifest")?;
+    let mut is_stale = manifest.is_stale;
 
     let version_id = if version == "latest_release" {
-        manifest.latest.release
+        manifest.value.latest.release
     } else {
         version.to_string()
     };
 
     let vref = manifest
+        .value
         .versions
         .into_iter()
         .find(|v| v.id == version_id)
         .ok_or_else(|| anyhow::anyhow!("unknown minecraft version: {version}"))?;
 
-    let vjson: VersionJson = client
-        .get(&vref.url)
-        .send()
-        .await
-        .context("fetch version json")?
-        .error_for_status()?
-        .json()
-        .await
-        .context("parse version json")?;
+    let vjson = crate::upstream::get_json::<VersionJson>(crate::upstream::MOJANG, &vref.url, |u| {
+        client.get(u)
+    })
+    .await
+    .context("fetch version json")?;
+    is_stale |= vjson.is_stale;
+    let vjson = vjson.value;
 
     Ok(ResolvedServerJar {
         version_id: vref.id,
@@ -209,6 +213,7 @@ pub async fn resolve_server_jar(version: &str) -> anyhow::Result<ResolvedServerJ
         sha1: vjson.downloads.server.sha1,
         size: vjson.downloads.server.size,
         java_major: vjson.java_version.major_version,
+        is_stale,
     })
 }
 
@@ -327,7 +332,7 @@ where
 
     fs::create_dir_all(jar_path.parent().unwrap())?;
 
-    let url = Url::parse(&resolved.jar_url)?;
+    let candidates = crate::mirrors::candidates(crate::upstream::MOJANG, &resolved.jar_url);
     let mut last_err: Option<anyhow::Error> = None;
     let mut bytes: Option<Vec<u8>> = None;
     let mut last_report = DownloadReport {
@@ -335,44 +340,45 @@ where
         total_bytes: resolved.size,
         speed_bytes_per_sec: 0,
     };
-    for attempt in 1..=3_u32 {
-        let res: anyhow::Result<Vec<u8>> = (async {
-            let (bytes, report) = download_bytes_with_progress(
-                url.clone(),
-                Some(resolved.size),
-                |downloaded, total, speed| {
-                    last_report = DownloadReport {
-                        downloaded_bytes: downloaded,
-                        total_bytes: total,
-                        speed_bytes_per_sec: speed,
-                    };
-                    if let Some(cb) = on_progress.as_mut() {
-                        cb(downloaded, total, speed);
-                    }
-                },
-            )
-            .await?;
-
-            last_report = report;
-            Ok(bytes)
-        })
-        .await;
-
-        match res {
-            Ok(b) => {
-                bytes = Some(b);
-                break;
-            }
-            Err(e) => {
-                last_err = Some(e);
-                if attempt < 3 {
-                    tokio::time::sleep(Duration::from_millis(
-                        200_u64.saturating_mul(2_u64.pow(attempt - 1)),
-                    ))
-                    .await;
+    'attempts: for attempt in 1..=3_u32 {
+        for candidate in &candidates {
+            let res: anyhow::Result<Vec<u8>> = (async {
+                let (bytes, report) = download_bytes_with_progress(
+                    Url::parse(&candidate.url)?,
+                    Some(resolved.size),
+                    |downloaded, total, speed| {
+                        last_report = DownloadReport {
+                            downloaded_bytes: downloaded,
+                            total_bytes: total,
+                            speed_bytes_per_sec: speed,
+                        };
+                        if let Some(cb) = on_progress.as_mut() {
+                            cb(downloaded, total, speed);
+                        }
+                    },
+                )
+                .await?;
+
+                last_report = report;
+                Ok(bytes)
+            })
+            .await;
+            candidate.record(&res);
+
+            match res {
+                Ok(b) => {
+                    bytes = Some(b);
+                    break 'attempts;
                 }
+                Err(e) => last_err = Some(e),
             }
         }
+        if attempt < 3 {
+            tokio::time::sleep(Duration::from_millis(
+                200_u64.saturating_mul(2_u64.pow(attempt - 1)),
+            ))
+            .await;
+        }
     }
 
     let bytes =
@@ -278,6 +278,7 @@ fn is_http_url(s: &str) -> bool {
     lower.starts_with("http://") || lower.starts_with("https://")
 }
 
+#[tracing::instrument(name = "http.download", skip(path), fields(url = %url))]
 async fn download_to_path(url: &str, path: &Path) -> anyhow::Result<()> {
     if let Some(parent) = path.parent() {
         tokio::fs::create_dir_all(parent).await?;
@@ -1,4 +1,7 @@
-use std::path::{Path, PathBuf};
+use std::{
+    collections::BTreeMap,
+    path::{Path, PathBuf},
+};
 
 #[derive(Debug, Clone)]
 pub struct LaunchSpec {
@@ -7,6 +10,87 @@ pub struct LaunchSpec {
     pub kind: String,
 }
 
+// Outcome of the `jvm_large_pages` param, recorded in run.json.
+#[derive(Debug, Clone, serde::Serialize)]
+pub struct LargePagesDecision {
+    pub enabled: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub flag: Option<String>,
+    pub reason: String,
+}
+
+fn meminfo_value(meminfo: &str, key: &str) -> Option<u64> {
+    meminfo.lines().find_map(|line| {
+        let (k, rest) = line.split_once(':')?;
+        if k.trim() != key {
+            return None;
+        }
+        rest.split_whitespace().next()?.parse::<u64>().ok()
+    })
+}
+
+fn thp_mode(enabled: &str) -> Option<&str> {
+    // Format: "always [madvise] never"
+    let start = enabled.find('[')?;
+    let end = enabled[start..].find(']')? + start;
+    Some(&enabled[start + 1..end])
+}
+
+fn decide_large_pages(meminfo: Option<&str>, thp_enabled: Option<&str>) -> LargePagesDecision {
+    if let Some(meminfo) = meminfo {
+        let total = meminfo_value(meminfo, "HugePages_Total").unwrap_or(0);
+        if total > 0 {
+            let size_kb = meminfo_value(meminfo, "Hugepagesize").unwrap_or(0);
+            let free = meminfo_value(meminfo, "HugePages_Free").unwrap_or(0);
+            return LargePagesDecision {
+                enabled: true,
+                flag: Some("-XX:+UseLargePages".to_string()),
+                reason: format!(
+                    "hugetlbfs pages available ({free}/{total} free, {size_kb} kB each)"
+                ),
+            };
+        }
+    }
+
+    if let Some(mode) = thp_enabled.and_then(thp_mode)
+        && matches!(mode, "always" | "madvise")
+    {
+        return LargePagesDecision {
+            enabled: true,
+            flag: Some("-XX:+UseTransparentHugePages".to_string()),
+            reason: format!("no hugetlbfs pages reserved; using transparent hugepages ({mode})"),
+        };
+    }
+
+    LargePagesDecision {
+        enabled: false,
+        flag: None,
+        reason: if meminfo.is_none() {
+            "large pages are not supported on this host".to_string()
+        } else {
+            "no hugetlbfs pages reserved and transparent hugepages disabled".to_string()
+        },
+    }
+}
+
+// Returns None when the instance did not opt in via `jvm_large_pages`.
+pub fn large_pages_decision(params: &BTreeMap<String, String>) -> Option<LargePagesDecision> {
+    let requested = matches!(
+        params
+            .get("jvm_large_pages")
+            .map(|v| v.trim().to_ascii_lowercase())
+            .as_deref(),
+        Some("1" | "true" | "yes" | "on")
+    );
+    if !requested {
+        return None;
+    }
+
+    let meminfo = std::fs::read_to_string("/proc/meminfo").ok();
+    let thp = std::fs::read_to_string("/sys/kernel/mm/transparent_hugepage/enabled").ok();
+    Some(decide_large_pages(meminfo.as_deref(), thp.as_deref()))
+}
+
 fn write_alloy_jvm_args(instance_dir: &Path, memory_mb: u32) -> anyhow::Result<PathBuf> {
     let path = instance_dir.join("alloy_jvm_args.txt");
     let tmp = instance_dir.join("alloy_jvm_args.txt.tmp");
@@ -121,3 +205,23 @@ pub fn resolve_launch_spec(instance_dir: &Path, memory_mb: u32) -> anyhow::Resul
         "could not determine how to launch this server pack (expected server.jar or libraries/**/unix_args.txt)"
     );
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn large_pages_prefers_hugetlbfs_then_thp() {
+        let reserved = "MemTotal: 1000 kB\nHugePages_Total:     512\nHugePages_Free:      500\nHugepagesize:       2048 kB\n";
+        let d = decide_large_pages(Some(reserved), Some("always madvise [never]"));
+        assert_eq!(d.flag.as_deref(), Some("-XX:+UseLargePages"));
+
+        let none = "MemTotal: 1000 kB\nHugePages_Total:       0\n";
+        let d = decide_large_pages(Some(none), Some("always [madvise] never"));
+        assert_eq!(d.flag.as_deref(), Some("-XX:+UseTransparentHugePages"));
+
+        let d = decide_large_pages(Some(none), Some("always madvise [never]"));
+        assert!(!d.enabled);
+        assert!(d.flag.is_none());
+    }
+}
@@ -0,0 +1,529 @@
+use std::{
+    io::{Read, Seek, SeekFrom, Write},
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+
+use crate::nbt::{self, Tag};
+
+// Largest preview side in pixels; bigger areas need a coarser zoom or tighter bounds.
+pub(crate) const MAX_SIDE_PX: u32 = 2048;
+pub(crate) const MAX_ZOOM: u32 = 16;
+
+const SECTOR_BYTES: u64 = 4096;
+// Chunks stored in a separate .mcc file (compression type | 128) are rare and skipped.
+const EXTERNAL_CHUNK_FLAG: u8 = 128;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Dimension {
+    Overworld,
+    Nether,
+    End,
+}
+
+impl Dimension {
+    pub(crate) fn parse(raw: &str) -> Option<Self> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" | "overworld" | "minecraft:overworld" => Some(Self::Overworld),
+            "nether" | "the_nether" | "minecraft:the_nether" => Some(Self::Nether),
+            "end" | "the_end" | "minecraft:the_end" => Some(Self::End),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_str(self) -> &'static str {
+        match self {
+            Self::Overworld => "overworld",
+            Self::Nether => "nether",
+            Self::End => "end",
+        }
+    }
+
+    fn region_dir(self, world: &Path) -> PathBuf {
+        match self {
+            Self::Overworld => world.join("region"),
+            Self::Nether => world.join("DIM-1").join("region"),
+            Self::End => world.join("DIM1").join("region"),
+        }
+    }
+}
+
+// Inclusive block-coordinate rectangle.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) struct Bounds {
+    pub(crate) min_x: i32,
+    pub(crate) min_z: i32,
+    pub(crate) max_x: i32,
+    pub(crate) max_z: i32,
+}
+
+impl Bounds {
+    fn contains(&self, x: i32, z: i32) -> bool {
+        (self.min_x..=self.max_x).contains(&x) && (self.min_z..=self.max_z).contains(&z)
+    }
+
+    fn intersect(self, other: Bounds) -> Option<Bounds> {
+        let b = Bounds {
+            min_x: self.min_x.max(other.min_x),
+            min_z: self.min_z.max(other.min_z),
+            max_x: self.max_x.min(other.max_x),
+            max_z: self.max_z.min(other.max_z),
+        };
+        (b.min_x <= b.max_x && b.min_z <= b.max_z).then_some(b)
+    }
+}
+
+#[derive(Debug, Clone)]
+pub(crate) struct Preview {
+    pub(crate) png: Vec<u8>,
+    pub(crate) width: u32,
+    pub(crate) height: u32,
+    pub(crate) zoom: u32,
+    pub(crate) bounds: Bounds,
+    pub(crate) chunks_rendered: u32,
+    // Chunks that exist but couldn't be drawn block by block (pre-1.18 format, unfinished
+    // generation, corrupt data); they show as flat grey.
+    pub(crate) chunks_flat: u32,
+}
+
+fn parse_region_name(name: &str) -> Option<(i32, i32)> {
+    let mut it = name.strip_prefix("r.")?.strip_suffix(".mca")?.split('.');
+    let rx = it.next()?.parse().ok()?;
+    let rz = it.next()?.parse().ok()?;
+    it.next().is_none().then_some((rx, rz))
+}
+
+struct Region {
+    path: PathBuf,
+    rx: i32,
+    rz: i32,
+    // (sector offset, sector count) per chunk, indexed by local z * 32 + x.
+    locations: Vec<(u32, u8)>,
+}
+
+impl Region {
+    fn open(path: PathBuf, rx: i32, rz: i32) -> anyhow::Result<Self> {
+        let mut f = std::fs::File::open(&path)?;
+        let mut header = vec![0u8; SECTOR_BYTES as usize];
+        // Empty or truncated region files are normal right after a chunk was first touched.
+        if f.read_exact(&mut header).is_err() {
+            return Ok(Self {
+                path,
+                rx,
+                rz,
+                locations: Vec::new(),
+            });
+        }
+        let locations = header
+            .chunks_exact(4)
+            .map(|e| (u32::from_be_bytes([0, e[0], e[1], e[2]]), e[3]))
+            .collect();
+        Ok(Self {
+            path,
+            rx,
+            rz,
+            locations,
+        })
+    }
+
+    fn chunks(&self) -> impl Iterator<Item = (i32, i32, u32)> + '_ {
+        self.locations
+            .iter()
+            .enumerate()
+            .filter(|(_, (offset, count))| *offset >= 2 && *count > 0)
+            .map(|(i, (offset, _))| {
+                let cx = self.rx * 32 + (i % 32) as i32;
+                let cz = self.rz * 32 + (i / 32) as i32;
+                (cx, cz, *offset)
+            })
+    }
+
+    fn read_chunk(&self, f: &mut std::fs::File, offset: u32) -> anyhow::Result<Option<Tag>> {
+        f.seek(SeekFrom::Start(offset as u64 * SECTOR_BYTES))?;
+        let mut head = [0u8; 5];
+        f.read_exact(&mut head)?;
+        let len = u32::from_be_bytes([head[0], head[1], head[2], head[3]]) as usize;
+        let compression = head[4];
+        if compression & EXTERNAL_CHUNK_FLAG != 0 || len < 1 || len > 16 * 1024 * 1024 {
+            return Ok(None);
+        }
+        let mut data = vec![0u8; len - 1];
+        f.read_exact(&mut data)?;
+        let raw = match compression {
+            1 | 2 | 3 => nbt::decompress(&data)?,
+            // LZ4 (configurable since 1.20.5) isn't supported.
+            _ => return Ok(None),
+        };
+        Ok(Some(nbt::parse(&raw)?))
+    }
+}
+
+fn list_regions(dir: &Path) -> anyhow::Result<Vec<Region>> {
+    let mut out = Vec::new();
+    let rd = match std::fs::read_dir(dir) {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(out),
+        Err(e) => return Err(e).with_context(|| format!("read {}", dir.display())),
+    };
+    for de in rd.flatten() {
+        let name = de.file_name().to_string_lossy().to_string();
+        if let Some((rx, rz)) = parse_region_name(&name) {
+            out.push(Region::open(de.path(), rx, rz)?);
+        }
+    }
+    out.sort_by_key(|r| (r.rz, r.rx));
+    Ok(out)
+}
+
+// Reads entry `i` of a packed long array where entries don't span longs (1.16+ layout).
+fn packed(data: &[i64], bits: u32, i: usize) -> Option<u32> {
+    if bits == 0 || bits > 32 {
+        return None;
+    }
+    let per_long = (64 / bits) as usize;
+    let word = *data.get(i / per_long)? as u64;
+    let shift = (i % per_long) as u32 * bits;
+    Some(((word >> shift) & ((1u64 << bits) - 1)) as u32)
+}
+
+fn bits_for(n: usize) -> u32 {
+    usize::BITS - n.saturating_sub(1).leading_zeros()
+}
+
+struct Section<'a> {
+    palette: &'a [Tag],
+    data: &'a [i64],
+}
+
+impl Section<'_> {
+    fn block(&self, x: usize, y: usize, z: usize) -> Option<&str> {
+        let idx = if self.palette.len() <= 1 {
+            0
+        } else {
+            let bits = bits_for(self.palette.len()).max(4);
+            packed(self.data, bits, (y * 16 + z) * 16 + x)? as usize
+        };
+        self.palette.get(idx)?.get("Name")?.as_str()
+    }
+}
+
+// A chunk's top block per column: (block name, y). None when the chunk can't be read block by
+// block (pre-1.18 layout or generation not finished).
+fn surface(chunk: &Tag) -> Option<Vec<Option<(String, i32)>>> {
+    let status = chunk.get("Status").and_then(Tag::as_str).unwrap_or("");
+    if !matches!(status, "minecraft:full" | "full") {
+        return None;
+    }
+    let heights = chunk
+        .path(&["Heightmaps", "WORLD_SURFACE"])?
+        .as_long_array();
+    if heights.is_empty() {
+        return None;
+    }
+    let min_y = chunk.get("yPos").and_then(Tag::as_i64).unwrap_or(-4) as i32 * 16;
+    let height_bits = 64 / 256usize.div_ceil(heights.len()) as u32;
+
+    let mut sections = std::collections::HashMap::new();
+    for s in chunk.get("sections")?.as_list() {
+        let (Some(y), Some(states)) = (s.get("Y").and_then(Tag::as_i64), s.get("block_states"))
+        else {
+            continue;
+        };
+        sections.insert(
+            y as i32,
+            Section {
+                palette: states.get("palette").map(Tag::as_list).unwrap_or(&[]),
+                data: states.get("data").map(Tag::as_long_array).unwrap_or(&[]),
+            },
+        );
+    }
+
+    let mut out = Vec::with_capacity(256);
+    for i in 0..256usize {
+        let h = packed(heights, height_bits, i).unwrap_or(0) as i32;
+        if h == 0 {
+            out.push(None);
+            continue;
+        }
+        let y = min_y + h - 1;
+        let block = sections
+            .get(&y.div_euclid(16))
+            .and_then(|s| s.block(i % 16, y.rem_euclid(16) as usize, i / 16));
+        out.push(block.map(|b| (b.to_string(), y)));
+    }
+    Some(out)
+}
+
+// Approximate map colours for common surface blocks; anything else falls back on its name.
+fn block_color(name: &str) -> [u8; 3] {
+    let name = name.strip_prefix("minecraft:").unwrap_or(name);
+    match name {
+        "grass_block" | "moss_block" => return [0x5f, 0x9f, 0x35],
+        "water" | "bubble_column" | "kelp" | "kelp_plant" | "seagrass" | "tall_seagrass" => {
+            return [0x3f, 0x76, 0xe4];
+        }
+        "sand" | "sandstone" | "birch_log" => return [0xdb, 0xd3, 0xa0],
+        "red_sand" | "terracotta" => return [0xa9, 0x58, 0x21],
+        "snow" | "snow_block" | "powder_snow" => return [0xf8, 0xfe, 0xfe],
+        "ice" | "packed_ice" | "blue_ice" => return [0xa0, 0xa0, 0xff],
+        "lava" | "magma_block" => return [0xd8, 0x5a, 0x16],
+        "dirt" | "coarse_dirt" | "rooted_dirt" | "farmland" | "dirt_path" => {
+            return [0x86, 0x60, 0x43];
+        }
+        "podzol" | "mud" | "mangrove_roots" => return [0x5a, 0x3f, 0x26],
+        "mycelium" => return [0x6f, 0x63, 0x69],
+        "gravel" | "andesite" | "tuff" => return [0x88, 0x88, 0x88],
+        "netherrack" | "nether_wart_block" | "crimson_nylium" => return [0x70, 0x02, 0x00],
+        "warped_nylium" | "warped_wart_block" => return [0x16, 0x7e, 0x86],
+        "soul_sand" | "soul_soil" => return [0x51, 0x3e, 0x32],
+        "end_stone" => return [0xdb, 0xde, 0x9e],
+        "obsidian" | "bedrock" => return [0x19, 0x19, 0x19],
+        _ => {}
+    }
+    if name.contains("leaves")
+        || name.contains("vine")
+        || name.ends_with("grass")
+        || name.ends_with("fern")
+    {
+        [0x30, 0x74, 0x1f]
+    } else if name.contains("log") || name.contains("wood") || name.contains("planks") {
+        [0x8f, 0x77, 0x48]
+    } else if name.contains("deepslate") || name.contains("basalt") || name.contains("blackstone") {
+        [0x50, 0x50, 0x55]
+    } else if name.contains("snow") {
+        [0xf8, 0xfe, 0xfe]
+    } else if name.contains("flower") || name.contains("tulip") || name.contains("poppy") {
+        [0xd0, 0x4a, 0x4a]
+    } else {
+        // Stone and everything without a better guess.
+        [0x70, 0x70, 0x70]
+    }
+}
+
+const FLAT_COLOR: [u8; 3] = [0x9a, 0x9a, 0x9a];
+
+fn shade(c: [u8; 3], factor: f32) -> [u8; 3] {
+    c.map(|v| (v as f32 * factor).round().clamp(0.0, 255.0) as u8)
+}
+
+// Minimal RGBA PNG: one IDAT, no filtering. Unexplored pixels stay transparent.
+fn encode_png(width: u32, height: u32, rgba: &[u8]) -> anyhow::Result<Vec<u8>> {
+    fn chunk(out: &mut Vec<u8>, ty: &[u8; 4], data: &[u8]) {
+        out.extend((data.len() as u32).to_be_bytes());
+        out.extend(ty);
+        out.extend(data);
+        let mut crc = crc32fast::Hasher::new();
+        crc.update(ty);
+        crc.update(data);
+        out.extend(crc.finalize().to_be_bytes());
+    }
+
+    let mut ihdr = Vec::with_capacity(13);
+    ihdr.extend(width.to_be_bytes());
+    ihdr.extend(height.to_be_bytes());
+    ihdr.extend([8, 6, 0, 0, 0]);
+
+    let stride = width as usize * 4;
+    let mut z = flate2::write::ZlibEncoder::new(Vec::new(), flate2::Compression::default());
+    for row in rgba.chunks_exact(stride) {
+        z.write_all(&[0])?;
+        z.write_all(row)?;
+    }
+    let idat = z.finish()?;
+
+    let mut out = b"\x89PNG\r\n\x1a\n".to_vec();
+    chunk(&mut out, b"IHDR", &ihdr);
+    chunk(&mut out, b"IDAT", &idat);
+    chunk(&mut out, b"IEND", &[]);
+    Ok(out)
+}
+
+// Blocking. Renders a top-down view of the generated chunks of `world` inside `bounds` (all of
+// them when None), one pixel per `zoom` x `zoom` blocks sampled at the cell's corner. Zoom 0 picks
+// the smallest power of two that fits MAX_SIDE_PX.
+pub(crate) fn render(
+    world: &Path,
+    dim: Dimension,
+    bounds: Option<Bounds>,
+    zoom: u32,
+) -> anyhow::Result<Preview> {
+    let regions = list_regions(&dim.region_dir(world))?;
+
+    let mut extent: Option<Bounds> = None;
+    for (cx, cz, _) in regions.iter().flat_map(|r| r.chunks()) {
+        let c = Bounds {
+            min_x: cx * 16,
+            min_z: cz * 16,
+            max_x: cx * 16 + 15,
+            max_z: cz * 16 + 15,
+        };
+        extent = Some(match extent {
+            None => c,
+            Some(e) => Bounds {
+                min_x: e.min_x.min(c.min_x),
+                min_z: e.min_z.min(c.min_z),
+                max_x: e.max_x.max(c.max_x),
+                max_z: e.max_z.max(c.max_z),
+            },
+        });
+    }
+    let Some(extent) = extent else {
+        anyhow::bail!("no generated chunks in the {} dimension", dim.as_str());
+    };
+    let area = match bounds {
+        Some(b) => b
+            .intersect(extent)
+            .ok_or_else(|| anyhow::anyhow!("no generated chunks inside the requested bounds"))?,
+        None => extent,
+    };
+
+    let side = |zoom: i32| {
+        (
+            ((area.max_x - area.min_x) / zoom + 1) as u32,
+            ((area.max_z - area.min_z) / zoom + 1) as u32,
+        )
+    };
+    let zoom = if zoom == 0 {
+        [1, 2, 4, 8, 16]
+            .into_iter()
+            .find(|&z| {
+                let (w, h) = side(z);
+                w <= MAX_SIDE_PX && h <= MAX_SIDE_PX
+            })
+            .unwrap_or(MAX_ZOOM as i32)
+    } else {
+        zoom.min(MAX_ZOOM) as i32
+    };
+    let (width, height) = side(zoom);
+    if width > MAX_SIDE_PX || height > MAX_SIDE_PX {
+        anyhow::bail!(
+            "preview would be {width}x{height} px (max {MAX_SIDE_PX}); \
+             use a larger zoom or smaller bounds"
+        );
+    }
+
+    let mut rgba = vec![0u8; width as usize * height as usize * 4];
+    let mut heights = vec![i32::MIN; width as usize * height as usize];
+    let mut preview = Preview {
+        png: Vec::new(),
+        width,
+        height,
+        zoom: zoom as u32,
+        bounds: area,
+        chunks_rendered: 0,
+        chunks_flat: 0,
+    };
+
+    let pixel = |bx: i32, bz: i32| -> Option<usize> {
+        if !area.contains(bx, bz) || (bx - area.min_x) % zoom != 0 || (bz - area.min_z) % zoom != 0
+        {
+            return None;
+        }
+        let px = ((bx - area.min_x) / zoom) as usize;
+        let pz = ((bz - area.min_z) / zoom) as usize;
+        Some(pz * width as usize + px)
+    };
+
+    for region in &regions {
+        let mut f = None;
+        for (cx, cz, offset) in region.chunks() {
+            let chunk_area = Bounds {
+                min_x: cx * 16,
+                min_z: cz * 16,
+                max_x: cx * 16 + 15,
+                max_z: cz * 16 + 15,
+            };
+            if chunk_area.intersect(area).is_none() {
+                continue;
+            }
+            if f.is_none() {
+                f = Some(std::fs::File::open(&region.path)?);
+            }
+            let chunk = region
+                .read_chunk(f.as_mut().expect("opened above"), offset)
+                .unwrap_or_else(|err| {
+                    tracing::debug!(
+                        path = %region.path.display(),
+                        cx,
+                        cz,
+                        error = %format!("{err:#}"),
+                        "skipping unreadable chunk"
+                    );
+                    None
+                });
+            let columns = chunk.as_ref().and_then(surface);
+            if columns.is_none() {
+                preview.chunks_flat += 1;
+            }
+            preview.chunks_rendered += 1;
+
+            for i in 0..256usize {
+                let bx = cx * 16 + (i % 16) as i32;
+                let bz = cz * 16 + (i / 16) as i32;
+                let Some(p) = pixel(bx, bz) else {
+                    continue;
+                };
+                let (color, y) = match columns.as_ref().map(|c| &c[i]) {
+                    Some(Some((name, y))) => (block_color(name), *y),
+                    Some(None) => continue,
+                    None => (FLAT_COLOR, i32::MIN),
+                };
+                rgba[p * 4..p * 4 + 3].copy_from_slice(&color);
+                rgba[p * 4 + 3] = 0xff;
+                heights[p] = y;
+            }
+        }
+    }
+
+    // Relief: lighten slopes facing north, darken the ones facing away.
+    let w = width as usize;
+    for p in (w..heights.len()).rev() {
+        let (here, north) = (heights[p], heights[p - w]);
+        if here == i32::MIN || north == i32::MIN || here == north {
+            continue;
+        }
+        let factor = if here > north { 1.12 } else { 0.86 };
+        let c = [rgba[p * 4], rgba[p * 4 + 1], rgba[p * 4 + 2]];
+        rgba[p * 4..p * 4 + 3].copy_from_slice(&shade(c, factor));
+    }
+
+    preview.png = encode_png(width, height, &rgba)?;
+    Ok(preview)
+}
+
+// The world seed from level.dat (1.16+ keeps it under WorldGenSettings, older versions in
+// RandomSeed).
+pub(crate) fn world_seed(world: &Path) -> Option<i64> {
+    let level = nbt::read_file(&world.join("level.dat")).ok()?;
+    level
+        .path(&["Data", "WorldGenSettings", "seed"])
+        .or_else(|| level.path(&["Data", "RandomSeed"]))
+        .and_then(Tag::as_i64)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn packed_arrays_and_region_names_decode() {
+        // Three 9-bit entries (1, 2, 300) in one long, as in a 1.18 heightmap.
+        let word = 1i64 | (2i64 << 9) | (300i64 << 18);
+        assert_eq!(packed(&[word], 9, 0), Some(1));
+        assert_eq!(packed(&[word], 9, 1), Some(2));
+        assert_eq!(packed(&[word], 9, 2), Some(300));
+        assert_eq!(packed(&[word], 9, 7), None);
+
+        assert_eq!(bits_for(1), 0);
+        assert_eq!(bits_for(2), 1);
+        assert_eq!(bits_for(17), 5);
+
+        assert_eq!(parse_region_name("r.-1.2.mca"), Some((-1, 2)));
+        assert_eq!(parse_region_name("r.0.0.mcc"), None);
+
+        let png = encode_png(1, 1, &[1, 2, 3, 255]).unwrap();
+        assert!(png.starts_with(b"\x89PNG\r\n\x1a\n"));
+        assert!(png.ends_with(&[0xae, 0x42, 0x60, 0x82]));
+    }
+}
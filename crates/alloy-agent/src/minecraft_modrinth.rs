@@ -219,16 +219,14 @@ async fn resolve_mrpack_url(source: &str) -> anyhow::Result<String> {
         if let Some(i) = segs.iter().position(|s| *s == "version") {
             if let Some(version_id) = segs.get(i + 1) {
                 let api = format!("https://api.modrinth.com/v2/version/{version_id}");
-                let resp = http_client()
-                    .get(api)
-                    .send()
-                    .await
-                    .context("fetch modrinth version")?
-                    .error_for_status()
-                    .context("fetch modrinth version (status)")?
-                    .json::<ModrinthVersionResp>()
-                    .await
-                    .context("parse modrinth version json")?;
+                let resp = crate::upstream::get_json::<ModrinthVersionResp>(
+                    crate::upstream::MODRINTH,
+                    &api,
+                    |u| http_client().get(u),
+                )
+                .await
+                .context("fetch modrinth version")?
+                .value;
 
                 let mut candidates: Vec<&ModrinthVersionFile> = resp
                     .files
@@ -249,6 +247,7 @@ async fn resolve_mrpack_url(source: &str) -> anyhow::Result<String> {
     );
 }
 
+#[tracing::instrument(name = "http.download", skip(path), fields(url = %url))]
 async fn download_to_path(url: &str, path: &Path) -> anyhow::Result<()> {
     if let Some(parent) = path.parent() {
         tokio::fs::create_dir_all(parent).await?;
@@ -366,16 +365,15 @@ struct FabricInstallerVersion {
 }
 
 async fn latest_fabric_installer_version() -> anyhow::Result<String> {
-    let list = http_client()
-        .get("https://meta.fabricmc.net/v2/versions/installer")
-        .send()
-        .await
-        .context("fetch fabric installer versions")?
-        .error_for_status()
-        .context("fetch fabric installer versions (status)")?
-        .json::<Vec<FabricInstallerVersion>>()
-        .await
-        .context("parse fabric installer versions")?;
+    const URL: &str = "https://meta.fabricmc.net/v2/versions/installer";
+    let list = crate::upstream::get_json::<Vec<FabricInstallerVersion>>(
+        crate::upstream::FABRIC,
+        URL,
+        |u| http_client().get(u),
+    )
+    .await
+    .context("fetch fabric installer versions")?
+    .value;
 
     for v in &list {
         if v.stable {
@@ -402,7 +400,27 @@ async fn ensure_fabric_server_jar(
     if jar.exists() {
         return Ok(());
     }
-    download_to_path(&url, &jar).await?;
+    let mut last_err = None;
+    for candidate in crate::mirrors::candidates(crate::upstream::FABRIC, &url) {
+        let res = download_to_path(&candidate.url, &jar).await;
+        candidate.record(&res);
+        match res {
+            Ok(()) => {
+                last_err = None;
+                break;
+            }
+            Err(e) => last_err = Some(e),
+        }
+    }
+    if let Some(e) = last_err {
+        return Err(e);
+    }
+    // Fabric publishes no hash for the launcher jar, so record the one just downloaded.
+    let version = format!("{minecraft_version}+fabric-{loader_version}");
+    if let Err(e) = crate::jar_provenance::record_installed(instance_dir, "fabric", &url, &version)
+    {
+        tracing::warn!(error = %e, "failed to record server.jar provenance");
+    }
     Ok(())
 }
 
@@ -412,6 +430,15 @@ fn read_marker(instance_dir: &Path) -> Option<InstalledMarker> {
     serde_json::from_slice::<InstalledMarker>(&raw).ok()
 }
 
+// Loader and game version of the modpack installed in `instance_dir`, if any.
+pub(crate) fn installed_pack(instance_dir: &Path) -> Option<InstalledPack> {
+    read_marker(instance_dir).map(|m| InstalledPack {
+        minecraft: m.minecraft,
+        loader: m.loader,
+        loader_version: m.loader_version,
+    })
+}
+
 fn write_marker(instance_dir: &Path, marker: &InstalledMarker) -> anyhow::Result<()> {
     let p = instance_dir.join("modrinth.json");
     let tmp = p.with_extension("tmp");
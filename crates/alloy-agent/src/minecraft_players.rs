@@ -0,0 +1,609 @@
+use std::{
+    collections::BTreeMap,
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+
+use crate::nbt::{self, Tag};
+
+// Players known to a world, read from files the server writes for offline players. For someone
+// who is online the data is as of the last autosave.
+
+// uuid -> name from the server's usercache.json (in the server directory, not the world).
+pub(crate) fn user_names(server_dir: &Path) -> BTreeMap<String, String> {
+    #[derive(serde::Deserialize)]
+    struct Entry {
+        name: String,
+        uuid: String,
+    }
+    std::fs::read(server_dir.join("usercache.json"))
+        .ok()
+        .and_then(|raw| serde_json::from_slice::<Vec<Entry>>(&raw).ok())
+        .unwrap_or_default()
+        .into_iter()
+        .map(|e| (e.uuid.to_ascii_lowercase(), e.name))
+        .collect()
+}
+
+fn is_uuid(s: &str) -> bool {
+    s.len() == 36
+        && s.char_indices().all(|(i, c)| match i {
+            8 | 13 | 18 | 23 => c == '-',
+            _ => c.is_ascii_hexdigit(),
+        })
+}
+
+// `query` is a uuid or a (case-insensitive) name from usercache.json.
+pub(crate) fn resolve_player(names: &BTreeMap<String, String>, query: &str) -> Option<String> {
+    let q = query.trim().to_ascii_lowercase();
+    if is_uuid(&q) {
+        return Some(q);
+    }
+    names
+        .iter()
+        .find(|(_, name)| name.eq_ignore_ascii_case(&q))
+        .map(|(uuid, _)| uuid.clone())
+}
+
+// `<world>/playerdata/<uuid>.dat` for every player, sorted by uuid.
+pub(crate) fn playerdata_files(world: &Path) -> std::io::Result<Vec<(String, PathBuf)>> {
+    let dir = world.join("playerdata");
+    let rd = match std::fs::read_dir(&dir) {
+        Ok(rd) => rd,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(e),
+    };
+    let mut out = Vec::new();
+    for de in rd {
+        let de = de?;
+        let name = de.file_name().to_string_lossy().to_string();
+        // Skips the server's .dat_old backups and anything else in the directory.
+        if let Some(uuid) = name.strip_suffix(".dat")
+            && is_uuid(uuid)
+        {
+            out.push((uuid.to_ascii_lowercase(), de.path()));
+        }
+    }
+    out.sort();
+    Ok(out)
+}
+
+// Before 1.16 the dimension was stored as a number.
+fn dimension(tag: Option<&Tag>) -> String {
+    match tag {
+        Some(Tag::String(s)) => s.clone(),
+        Some(t) => match t.as_i64() {
+            Some(-1) => "minecraft:the_nether".to_string(),
+            Some(1) => "minecraft:the_end".to_string(),
+            _ => "minecraft:overworld".to_string(),
+        },
+        None => "minecraft:overworld".to_string(),
+    }
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub(crate) struct Vec3 {
+    pub(crate) x: f64,
+    pub(crate) y: f64,
+    pub(crate) z: f64,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct BlockPos {
+    pub(crate) dimension: String,
+    pub(crate) x: i32,
+    pub(crate) y: i32,
+    pub(crate) z: i32,
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct Position {
+    pub(crate) dimension: String,
+    pub(crate) pos: Vec3,
+    pub(crate) yaw: f32,
+    pub(crate) pitch: f32,
+    pub(crate) health: f32,
+    pub(crate) xp_level: i32,
+    pub(crate) game_mode: String,
+    // 1.19+.
+    pub(crate) last_death: Option<BlockPos>,
+    // Bed or respawn anchor, when set.
+    pub(crate) respawn: Option<BlockPos>,
+}
+
+fn game_mode(id: Option<i64>) -> String {
+    match id {
+        Some(0) => "survival",
+        Some(1) => "creative",
+        Some(2) => "adventure",
+        Some(3) => "spectator",
+        _ => "",
+    }
+    .to_string()
+}
+
+fn int_pos(tag: Option<&Tag>) -> Option<(i32, i32, i32)> {
+    match tag? {
+        Tag::IntArray(v) if v.len() == 3 => Some((v[0], v[1], v[2])),
+        _ => None,
+    }
+}
+
+fn respawn(root: &Tag) -> Option<BlockPos> {
+    // 1.21.5+ nests it; older versions use flat SpawnX/SpawnY/SpawnZ keys.
+    if let Some(r) = root.get("respawn") {
+        let (x, y, z) = int_pos(r.get("pos"))?;
+        return Some(BlockPos {
+            dimension: dimension(r.get("dimension")),
+            x,
+            y,
+            z,
+        });
+    }
+    let coord = |k: &str| root.get(k).and_then(Tag::as_i64).map(|v| v as i32);
+    Some(BlockPos {
+        dimension: dimension(root.get("SpawnDimension")),
+        x: coord("SpawnX")?,
+        y: coord("SpawnY")?,
+        z: coord("SpawnZ")?,
+    })
+}
+
+pub(crate) fn position(root: &Tag) -> anyhow::Result<Position> {
+    let pos: Vec<f64> = root
+        .get("Pos")
+        .map(Tag::as_list)
+        .unwrap_or_default()
+        .iter()
+        .filter_map(Tag::as_f64)
+        .collect();
+    let [x, y, z] = pos[..] else {
+        anyhow::bail!("playerdata has no Pos");
+    };
+    let rot: Vec<f64> = root
+        .get("Rotation")
+        .map(Tag::as_list)
+        .unwrap_or_default()
+        .iter()
+        .filter_map(Tag::as_f64)
+        .collect();
+    let last_death = root.get("LastDeathLocation").and_then(|d| {
+        let (x, y, z) = int_pos(d.get("pos"))?;
+        Some(BlockPos {
+            dimension: dimension(d.get("dimension")),
+            x,
+            y,
+            z,
+        })
+    });
+    Ok(Position {
+        dimension: dimension(root.get("Dimension")),
+        pos: Vec3 { x, y, z },
+        yaw: rot.first().copied().unwrap_or_default() as f32,
+        pitch: rot.get(1).copied().unwrap_or_default() as f32,
+        health: root.get("Health").and_then(Tag::as_f64).unwrap_or_default() as f32,
+        xp_level: root
+            .get("XpLevel")
+            .and_then(Tag::as_i64)
+            .unwrap_or_default() as i32,
+        game_mode: game_mode(root.get("playerGameType").and_then(Tag::as_i64)),
+        last_death,
+        respawn: respawn(root),
+    })
+}
+
+pub(crate) fn parse_playerdata(raw: &[u8]) -> anyhow::Result<Tag> {
+    nbt::parse(&nbt::decompress(raw)?)
+}
+
+pub(crate) fn read_position(path: &Path) -> anyhow::Result<Position> {
+    position(&nbt::read_file(path)?)
+}
+
+// The server rewrites a player's file on autosave and logout, so this is when it last saw them.
+pub(crate) fn saved_at_unix_ms(path: &Path) -> u64 {
+    std::fs::metadata(path)
+        .and_then(|m| m.modified())
+        .ok()
+        .and_then(|t| t.duration_since(std::time::UNIX_EPOCH).ok())
+        .map(|d| d.as_millis().min(u64::MAX as u128) as u64)
+        .unwrap_or(0)
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct Item {
+    // "hotbar.0", "inventory.12", "armor.head", "offhand", "ender.3", ...
+    pub(crate) slot: String,
+    pub(crate) id: String,
+    pub(crate) count: i32,
+    pub(crate) damage: i32,
+    // Raw text component (JSON or SNBT), as stored.
+    pub(crate) custom_name: String,
+    pub(crate) enchantments: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct Inventory {
+    pub(crate) items: Vec<Item>,
+    pub(crate) ender_items: Vec<Item>,
+    pub(crate) xp_level: i32,
+}
+
+fn inventory_slot(slot: i64) -> String {
+    match slot {
+        0..=8 => format!("hotbar.{slot}"),
+        9..=35 => format!("inventory.{}", slot - 9),
+        100 => "armor.feet".to_string(),
+        101 => "armor.legs".to_string(),
+        102 => "armor.chest".to_string(),
+        103 => "armor.head".to_string(),
+        -106 => "offhand".to_string(),
+        other => format!("slot.{other}"),
+    }
+}
+
+fn enchantment_names(tag: Option<&Tag>) -> Vec<String> {
+    match tag {
+        // 1.20.5+: {levels: {"minecraft:sharpness": 5}} (or the map itself in 1.21.5+).
+        Some(Tag::Compound(m)) => {
+            let levels = match m.get("levels") {
+                Some(Tag::Compound(levels)) => levels,
+                _ => m,
+            };
+            levels
+                .iter()
+                .map(|(id, lvl)| format!("{id} {}", lvl.as_i64().unwrap_or(1)))
+                .collect()
+        }
+        // Before: [{id: "minecraft:sharpness", lvl: 5s}].
+        Some(Tag::List(v)) => v
+            .iter()
+            .filter_map(|e| {
+                let id = e.get("id")?.as_str()?;
+                Some(format!(
+                    "{id} {}",
+                    e.get("lvl").and_then(Tag::as_i64).unwrap_or(1)
+                ))
+            })
+            .collect(),
+        _ => Vec::new(),
+    }
+}
+
+fn item(tag: &Tag, slot: String) -> Option<Item> {
+    let id = tag.get("id")?.as_str()?.to_string();
+    // "count" since 1.20.5 (components), "Count" before (with a "tag" compound).
+    let count = tag
+        .get("count")
+        .or_else(|| tag.get("Count"))
+        .and_then(Tag::as_i64)
+        .unwrap_or(1) as i32;
+    let (damage, custom_name, enchantments) = match tag.get("components") {
+        Some(c) => (
+            c.get("minecraft:damage").and_then(Tag::as_i64),
+            c.get("minecraft:custom_name"),
+            enchantment_names(c.get("minecraft:enchantments")),
+        ),
+        None => (
+            tag.path(&["tag", "Damage"]).and_then(Tag::as_i64),
+            tag.path(&["tag", "display", "Name"]),
+            enchantment_names(tag.path(&["tag", "Enchantments"])),
+        ),
+    };
+    let custom_name = match custom_name {
+        Some(Tag::String(s)) => s.clone(),
+        // 1.21.5+ stores text components as NBT.
+        Some(other) => other
+            .get("text")
+            .and_then(Tag::as_str)
+            .unwrap_or_default()
+            .to_string(),
+        None => String::new(),
+    };
+    Some(Item {
+        slot,
+        id,
+        count,
+        damage: damage.unwrap_or(0) as i32,
+        custom_name,
+        enchantments,
+    })
+}
+
+pub(crate) fn inventory(root: &Tag) -> Inventory {
+    let slotted = |key: &str, name: &dyn Fn(i64) -> String| -> Vec<Item> {
+        root.get(key)
+            .map(Tag::as_list)
+            .unwrap_or_default()
+            .iter()
+            .filter_map(|t| {
+                let slot = t.get("Slot").and_then(Tag::as_i64).unwrap_or(-1);
+                item(t, name(slot))
+            })
+            .collect()
+    };
+    let mut items = slotted("Inventory", &inventory_slot);
+    // 1.21.5+ keeps armor and the offhand in a separate compound.
+    if let Some(Tag::Compound(equipment)) = root.get("equipment") {
+        for (key, t) in equipment {
+            let slot = match key.as_str() {
+                "offhand" => "offhand".to_string(),
+                other => format!("armor.{other}"),
+            };
+            items.extend(item(t, slot));
+        }
+    }
+    Inventory {
+        items,
+        ender_items: slotted("EnderItems", &|slot| format!("ender.{slot}")),
+        xp_level: root
+            .get("XpLevel")
+            .and_then(Tag::as_i64)
+            .unwrap_or_default() as i32,
+    }
+}
+
+// `playerdata/<uuid>.dat` of the world at `world_rel` inside an instance backup. Falls back to any
+// world in the archive, for backups taken before level-name was changed.
+pub(crate) fn playerdata_from_backup(
+    archive: &Path,
+    world_rel: &Path,
+    uuid: &str,
+) -> anyhow::Result<Vec<u8>> {
+    use std::io::Read;
+
+    let f = std::fs::File::open(archive).with_context(|| format!("open {}", archive.display()))?;
+    let mut zip = zip::ZipArchive::new(f)?;
+    let file_name = format!("{uuid}.dat");
+    let exact = world_rel
+        .join("playerdata")
+        .join(&file_name)
+        .to_string_lossy()
+        .replace('\\', "/");
+    let suffix = format!("/playerdata/{file_name}");
+    let name = if zip.index_for_name(&exact).is_some() {
+        exact
+    } else {
+        zip.file_names()
+            .filter(|n| n.ends_with(&suffix))
+            .min()
+            .map(str::to_string)
+            .ok_or_else(|| anyhow::anyhow!("backup has no playerdata for {uuid}"))?
+    };
+    let mut out = Vec::new();
+    zip.by_name(&name)?.read_to_end(&mut out)?;
+    Ok(out)
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub(crate) struct Stats {
+    pub(crate) play_time_ticks: u64,
+    pub(crate) deaths: u64,
+    pub(crate) mob_kills: u64,
+    pub(crate) player_kills: u64,
+    pub(crate) jumps: u64,
+    // Every *_one_cm movement stat (walking, sprinting, flying, boats, ...).
+    pub(crate) distance_cm: u64,
+    pub(crate) blocks_mined: u64,
+    pub(crate) items_crafted: u64,
+    // Block id -> times mined.
+    pub(crate) mined: BTreeMap<String, u64>,
+    // Recipe unlocks are advancements too, but say nothing about progress and are left out.
+    pub(crate) advancements_done: u32,
+}
+
+impl Stats {
+    pub(crate) fn add(&mut self, other: &Stats) {
+        self.play_time_ticks += other.play_time_ticks;
+        self.deaths += other.deaths;
+        self.mob_kills += other.mob_kills;
+        self.player_kills += other.player_kills;
+        self.jumps += other.jumps;
+        self.distance_cm += other.distance_cm;
+        self.blocks_mined += other.blocks_mined;
+        self.items_crafted += other.items_crafted;
+        for (id, n) in &other.mined {
+            *self.mined.entry(id.clone()).or_default() += n;
+        }
+        self.advancements_done += other.advancements_done;
+    }
+
+    // The `n` most mined blocks, most first.
+    pub(crate) fn top_mined(&self, n: usize) -> Vec<(String, u64)> {
+        let mut v: Vec<_> = self.mined.iter().map(|(k, v)| (k.clone(), *v)).collect();
+        v.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+        v.truncate(n);
+        v
+    }
+}
+
+fn counters(v: Option<&serde_json::Value>) -> impl Iterator<Item = (&str, u64)> {
+    v.and_then(|v| v.as_object())
+        .into_iter()
+        .flatten()
+        .filter_map(|(k, v)| Some((k.as_str(), v.as_u64()?)))
+}
+
+// `<world>/stats/<uuid>.json`, 1.13+ layout ({"stats": {"minecraft:custom": {...}, ...}}).
+pub(crate) fn parse_stats(raw: &[u8]) -> anyhow::Result<Stats> {
+    let v: serde_json::Value = serde_json::from_slice(raw)?;
+    let stats = v
+        .get("stats")
+        .ok_or_else(|| anyhow::anyhow!("unsupported stats format (pre-1.13?)"))?;
+    let mut out = Stats::default();
+    for (k, n) in counters(stats.get("minecraft:custom")) {
+        match k {
+            // Renamed in 1.17.
+            "minecraft:play_time" | "minecraft:play_one_minute" => out.play_time_ticks += n,
+            "minecraft:deaths" => out.deaths = n,
+            "minecraft:mob_kills" => out.mob_kills = n,
+            "minecraft:player_kills" => out.player_kills = n,
+            "minecraft:jump" => out.jumps = n,
+            k if k.ends_with("_one_cm") => out.distance_cm += n,
+            _ => {}
+        }
+    }
+    for (k, n) in counters(stats.get("minecraft:mined")) {
+        out.blocks_mined += n;
+        out.mined.insert(k.to_string(), n);
+    }
+    out.items_crafted = counters(stats.get("minecraft:crafted"))
+        .map(|(_, n)| n)
+        .sum();
+    Ok(out)
+}
+
+// `<world>/advancements/<uuid>.json`: advancement id -> {"criteria": {...}, "done": bool}.
+pub(crate) fn count_advancements(raw: &[u8]) -> anyhow::Result<u32> {
+    let v: serde_json::Value = serde_json::from_slice(raw)?;
+    Ok(v.as_object()
+        .into_iter()
+        .flatten()
+        .filter(|(k, v)| {
+            !k.contains(":recipes/") && v.get("done").and_then(|d| d.as_bool()) == Some(true)
+        })
+        .count() as u32)
+}
+
+// Players with a stats or advancements file, sorted.
+pub(crate) fn stats_players(world: &Path) -> Vec<String> {
+    let mut out = std::collections::BTreeSet::new();
+    for sub in ["stats", "advancements"] {
+        let Ok(rd) = std::fs::read_dir(world.join(sub)) else {
+            continue;
+        };
+        for de in rd.flatten() {
+            let name = de.file_name().to_string_lossy().to_string();
+            if let Some(uuid) = name.strip_suffix(".json")
+                && is_uuid(uuid)
+            {
+                out.insert(uuid.to_ascii_lowercase());
+            }
+        }
+    }
+    out.into_iter().collect()
+}
+
+// Missing files count as zero (a player who never earned an advancement has no file).
+pub(crate) fn read_stats(world: &Path, uuid: &str) -> anyhow::Result<Stats> {
+    let read = |sub: &str| -> anyhow::Result<Option<Vec<u8>>> {
+        let path = world.join(sub).join(format!("{uuid}.json"));
+        match std::fs::read(&path) {
+            Ok(raw) => Ok(Some(raw)),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(None),
+            Err(e) => Err(e).with_context(|| format!("read {}", path.display())),
+        }
+    };
+    let mut stats = match read("stats")? {
+        Some(raw) => parse_stats(&raw).context("parse stats")?,
+        None => Stats::default(),
+    };
+    if let Some(raw) = read("advancements")? {
+        stats.advancements_done = count_advancements(&raw).context("parse advancements")?;
+    }
+    Ok(stats)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn reads_position_inventory_and_stats() {
+        let list = |v: &[f64]| Tag::List(v.iter().map(|&f| Tag::Double(f)).collect());
+        let compound = |kv: Vec<(&str, Tag)>| {
+            Tag::Compound(kv.into_iter().map(|(k, v)| (k.to_string(), v)).collect())
+        };
+        let root = compound(vec![
+            ("Pos", list(&[10.5, 64.0, -3.25])),
+            (
+                "Rotation",
+                Tag::List(vec![Tag::Float(90.0), Tag::Float(-10.0)]),
+            ),
+            ("Dimension", Tag::Int(-1)),
+            ("playerGameType", Tag::Int(0)),
+            (
+                "LastDeathLocation",
+                compound(vec![
+                    ("dimension", Tag::String("minecraft:the_end".to_string())),
+                    ("pos", Tag::IntArray(vec![1, 2, 3])),
+                ]),
+            ),
+        ]);
+
+        let p = position(&root).unwrap();
+        assert_eq!(p.dimension, "minecraft:the_nether");
+        assert_eq!(
+            p.pos,
+            Vec3 {
+                x: 10.5,
+                y: 64.0,
+                z: -3.25
+            }
+        );
+        assert_eq!(p.yaw, 90.0);
+        assert_eq!(p.game_mode, "survival");
+        assert_eq!(p.last_death.unwrap().z, 3);
+        assert!(p.respawn.is_none());
+
+        assert!(position(&compound(vec![])).is_err());
+
+        let stack = |slot: i8, id: &str, count: Tag| {
+            compound(vec![
+                ("Slot", Tag::Byte(slot)),
+                ("id", Tag::String(id.to_string())),
+                ("count", count),
+            ])
+        };
+        let inv = inventory(&compound(vec![
+            (
+                "Inventory",
+                Tag::List(vec![
+                    stack(0, "minecraft:diamond_sword", Tag::Int(1)),
+                    stack(103, "minecraft:iron_helmet", Tag::Int(1)),
+                ]),
+            ),
+            (
+                "EnderItems",
+                Tag::List(vec![stack(4, "minecraft:elytra", Tag::Int(1))]),
+            ),
+        ]));
+        assert_eq!(inv.items[0].slot, "hotbar.0");
+        assert_eq!(inv.items[1].slot, "armor.head");
+        assert_eq!(inv.ender_items[0].id, "minecraft:elytra");
+
+        let stats = parse_stats(
+            br#"{"stats":{"minecraft:custom":{"minecraft:play_time":72000,"minecraft:deaths":2,
+            "minecraft:walk_one_cm":500,"minecraft:fly_one_cm":250},
+            "minecraft:mined":{"minecraft:stone":40,"minecraft:dirt":2}},"DataVersion":3465}"#,
+        )
+        .unwrap();
+        assert_eq!(stats.play_time_ticks, 72000);
+        assert_eq!(stats.deaths, 2);
+        assert_eq!(stats.distance_cm, 750);
+        assert_eq!(stats.blocks_mined, 42);
+        assert_eq!(
+            stats.top_mined(1),
+            vec![("minecraft:stone".to_string(), 40)]
+        );
+        assert_eq!(
+            count_advancements(
+                br#"{"minecraft:story/root":{"done":true},"minecraft:recipes/misc/x":{"done":true},
+                "minecraft:story/mine_stone":{"done":false},"DataVersion":3465}"#
+            )
+            .unwrap(),
+            1
+        );
+
+        let names = BTreeMap::from([(
+            "069a79f4-44e9-4726-a5be-fca90e38aaf5".to_string(),
+            "Notch".to_string(),
+        )]);
+        assert_eq!(
+            resolve_player(&names, "notch").as_deref(),
+            Some("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+        );
+        assert_eq!(resolve_player(&names, "nobody"), None);
+    }
+}
@@ -0,0 +1,318 @@
+use std::{
+    collections::VecDeque,
+    io::Read,
+    path::{Path, PathBuf},
+    process::Stdio,
+    sync::{Arc, Mutex, OnceLock},
+    time::{Duration, Instant},
+};
+
+use tokio::io::{AsyncBufReadExt, AsyncRead, BufReader};
+
+// Validate-only start: checks that a Minecraft server jar is launchable without starting the
+// server. The jar is read end to end (so truncated or corrupted downloads fail their CRC), then
+// `java -jar server.jar --help` runs in a scratch directory with a small heap, a timeout and, where
+// `unshare` allows it, no network.
+
+const OUTPUT_LINES: usize = 40;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Verdict {
+    Launchable,
+    // The jar looked fine but the JVM run could not confirm it, e.g. it wanted the network.
+    Inconclusive,
+    CorruptJar,
+    JavaIncompatible,
+    Failed,
+}
+
+impl Verdict {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Verdict::Launchable => "launchable",
+            Verdict::Inconclusive => "inconclusive",
+            Verdict::CorruptJar => "corrupt_jar",
+            Verdict::JavaIncompatible => "java_incompatible",
+            Verdict::Failed => "failed",
+        }
+    }
+
+    pub fn ok(self) -> bool {
+        matches!(self, Verdict::Launchable | Verdict::Inconclusive)
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct Probe {
+    pub verdict: Verdict,
+    pub message: String,
+    pub main_class: Option<String>,
+    pub exit_code: Option<i32>,
+    pub timed_out: bool,
+    pub network_isolated: bool,
+    pub duration_ms: u64,
+    pub output: Vec<String>,
+}
+
+impl Probe {
+    fn new(verdict: Verdict, message: impl Into<String>) -> Self {
+        Self {
+            verdict,
+            message: message.into(),
+            main_class: None,
+            exit_code: None,
+            timed_out: false,
+            network_isolated: false,
+            duration_ms: 0,
+            output: Vec::new(),
+        }
+    }
+}
+
+fn timeout() -> Duration {
+    let ms = std::env::var("ALLOY_LAUNCH_PROBE_TIMEOUT_MS")
+        .ok()
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .filter(|v| *v > 0)
+        .unwrap_or(30_000);
+    Duration::from_millis(ms)
+}
+
+fn manifest_main_class(manifest: &str) -> Option<String> {
+    manifest
+        .lines()
+        .find_map(|l| l.strip_prefix("Main-Class:"))
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+}
+
+// Reads every entry so the zip CRCs are checked, and returns the manifest's Main-Class.
+pub fn check_jar(path: &Path) -> Result<String, String> {
+    let file = std::fs::File::open(path).map_err(|e| format!("open {}: {e}", path.display()))?;
+    let mut zip = zip::ZipArchive::new(file).map_err(|e| format!("not a valid jar: {e}"))?;
+    let mut manifest = None;
+    for i in 0..zip.len() {
+        let mut entry = zip
+            .by_index(i)
+            .map_err(|e| format!("unreadable entry #{i}: {e}"))?;
+        let name = entry.name().to_string();
+        if name == "META-INF/MANIFEST.MF" {
+            let mut text = String::new();
+            entry
+                .read_to_string(&mut text)
+                .map_err(|e| format!("corrupt entry {name}: {e}"))?;
+            manifest = Some(text);
+        } else {
+            std::io::copy(&mut entry, &mut std::io::sink())
+                .map_err(|e| format!("corrupt entry {name}: {e}"))?;
+        }
+    }
+    let manifest = manifest.ok_or_else(|| "jar has no META-INF/MANIFEST.MF".to_string())?;
+    manifest_main_class(&manifest).ok_or_else(|| "jar manifest has no Main-Class".to_string())
+}
+
+// `unshare -rn` needs user namespaces (or CAP_SYS_ADMIN). Containers often have neither.
+fn unshare_available() -> bool {
+    static AVAILABLE: OnceLock<bool> = OnceLock::new();
+    *AVAILABLE.get_or_init(|| {
+        std::process::Command::new("unshare")
+            .args(["-rn", "true"])
+            .stdin(Stdio::null())
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .status()
+            .is_ok_and(|s| s.success())
+    })
+}
+
+fn classify(exit_code: Option<i32>, timed_out: bool, isolated: bool, output: &[String]) -> Verdict {
+    let has = |needle: &str| output.iter().any(|l| l.contains(needle));
+    if has("Invalid or corrupt jarfile")
+        || has("no main manifest attribute")
+        || has("ZipException")
+        || has("ClassNotFoundException")
+        || has("NoClassDefFoundError")
+    {
+        return Verdict::CorruptJar;
+    }
+    if has("UnsupportedClassVersionError") {
+        return Verdict::JavaIncompatible;
+    }
+    if exit_code == Some(0) {
+        return Verdict::Launchable;
+    }
+    // Paper's launcher downloads the vanilla jar on first run, which an isolated probe cannot do.
+    if timed_out
+        || (isolated && (has("UnknownHostException") || has("ConnectException") || has("Download")))
+    {
+        return Verdict::Inconclusive;
+    }
+    Verdict::Failed
+}
+
+fn spawn_reader<R: AsyncRead + Unpin + Send + 'static>(
+    reader: Option<R>,
+    lines: Arc<Mutex<VecDeque<String>>>,
+) -> Option<tokio::task::JoinHandle<()>> {
+    let reader = reader?;
+    Some(tokio::spawn(async move {
+        let mut reader = BufReader::new(reader).lines();
+        while let Ok(Some(line)) = reader.next_line().await {
+            let mut lines = lines.lock().unwrap_or_else(|e| e.into_inner());
+            if lines.len() == OUTPUT_LINES {
+                lines.pop_front();
+            }
+            lines.push_back(line);
+        }
+    }))
+}
+
+async fn run_help(jar: &Path, scratch: &Path) -> anyhow::Result<Probe> {
+    let test_mode = crate::test_mode::enabled();
+    let isolated = !test_mode && unshare_available();
+    let java_args = [
+        "-Xmx256M".to_string(),
+        "-Djava.awt.headless=true".to_string(),
+        "-jar".to_string(),
+        jar.display().to_string(),
+        "--help".to_string(),
+    ];
+    let mut cmd = if test_mode {
+        let (program, args) = crate::test_mode::fake_java_command(&java_args)?;
+        let mut c = tokio::process::Command::new(program);
+        c.args(args);
+        c
+    } else if isolated {
+        let mut c = tokio::process::Command::new("unshare");
+        c.arg("-rn").arg("java").args(&java_args);
+        c
+    } else {
+        let mut c = tokio::process::Command::new("java");
+        c.args(&java_args);
+        c
+    };
+    cmd.current_dir(scratch)
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true);
+
+    let started = Instant::now();
+    let mut child = cmd
+        .spawn()
+        .map_err(|e| anyhow::anyhow!("failed to run java: {e}"))?;
+    let lines = Arc::new(Mutex::new(VecDeque::new()));
+    let readers = [
+        spawn_reader(child.stdout.take(), lines.clone()),
+        spawn_reader(child.stderr.take(), lines.clone()),
+    ];
+
+    let (exit_code, timed_out) = match tokio::time::timeout(timeout(), child.wait()).await {
+        Ok(status) => (status?.code(), false),
+        Err(_) => {
+            let _ = child.kill().await;
+            (None, true)
+        }
+    };
+    for reader in readers.into_iter().flatten() {
+        let _ = tokio::time::timeout(Duration::from_secs(2), reader).await;
+    }
+
+    let output: Vec<String> = lines
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .iter()
+        .cloned()
+        .collect();
+    let verdict = classify(exit_code, timed_out, isolated, &output);
+    let message = match verdict {
+        Verdict::Launchable => "the server jar starts".to_string(),
+        Verdict::Inconclusive if timed_out => {
+            "the JVM loaded the jar but did not exit before the timeout".to_string()
+        }
+        Verdict::Inconclusive => "the jar needs network access on first start".to_string(),
+        Verdict::CorruptJar => "the JVM could not load the server jar".to_string(),
+        Verdict::JavaIncompatible => "the jar needs a newer Java runtime".to_string(),
+        Verdict::Failed => match exit_code {
+            Some(code) => format!("java exited with code {code}"),
+            None => "java was killed by a signal".to_string(),
+        },
+    };
+    Ok(Probe {
+        verdict,
+        message,
+        main_class: None,
+        exit_code,
+        timed_out,
+        network_isolated: isolated,
+        duration_ms: started.elapsed().as_millis() as u64,
+        output,
+    })
+}
+
+pub async fn probe(jar: PathBuf) -> anyhow::Result<Probe> {
+    let checked = {
+        let jar = jar.clone();
+        tokio::task::spawn_blocking(move || check_jar(&jar)).await?
+    };
+    let main_class = match checked {
+        Ok(main_class) => main_class,
+        Err(e) => return Ok(Probe::new(Verdict::CorruptJar, e)),
+    };
+
+    // Launchers like the 1.18+ bundler unpack libraries into the working directory.
+    let millis = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_millis();
+    let scratch = crate::minecraft::data_root()
+        .join("tmp")
+        .join(format!("launch-probe-{}-{millis}", std::process::id()));
+    tokio::fs::create_dir_all(&scratch).await?;
+    let res = run_help(&jar, &scratch).await;
+    let _ = tokio::fs::remove_dir_all(&scratch).await;
+
+    let mut probe = res?;
+    probe.main_class = Some(main_class);
+    Ok(probe)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn checks_jars_and_classifies_output() {
+        let dir = std::env::temp_dir().join(format!("alloy-probe-test-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let jar = dir.join("server.jar");
+        let mut zip = zip::ZipWriter::new(std::fs::File::create(&jar).unwrap());
+        let opts = zip::write::SimpleFileOptions::default();
+        zip.start_file("META-INF/MANIFEST.MF", opts).unwrap();
+        std::io::Write::write_all(&mut zip, b"Manifest-Version: 1.0\r\nMain-Class: a.Main\r\n")
+            .unwrap();
+        zip.finish().unwrap();
+        assert_eq!(check_jar(&jar).unwrap(), "a.Main");
+
+        let raw = std::fs::read(&jar).unwrap();
+        std::fs::write(&jar, &raw[..raw.len() / 2]).unwrap();
+        assert!(check_jar(&jar).is_err());
+        let _ = std::fs::remove_dir_all(&dir);
+
+        let out = |s: &str| vec![s.to_string()];
+        assert_eq!(
+            classify(Some(1), false, false, &out("Invalid or corrupt jarfile")),
+            Verdict::CorruptJar
+        );
+        assert_eq!(
+            classify(Some(1), false, false, &out("UnsupportedClassVersionError")),
+            Verdict::JavaIncompatible
+        );
+        assert_eq!(
+            classify(Some(1), false, true, &out("Downloading vanilla jar...")),
+            Verdict::Inconclusive
+        );
+        assert_eq!(classify(Some(0), false, true, &[]), Verdict::Launchable);
+        assert_eq!(classify(Some(1), false, false, &[]), Verdict::Failed);
+    }
+}
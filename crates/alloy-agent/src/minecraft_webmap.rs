@@ -0,0 +1,446 @@
+use std::{
+    path::{Path, PathBuf},
+    sync::OnceLock,
+    time::Duration,
+};
+
+use anyhow::Context;
+use serde::{Deserialize, Serialize};
+use sha1::Digest;
+
+// Web map mods (Dynmap, BlueMap, squaremap) installed from Modrinth into an instance's mods/,
+// with their web server port managed by the agent. State lives in webmap.json next to
+// instance.json rather than in the instance params, so editing the instance can't drop it.
+const MARKER: &str = "webmap.json";
+
+// Name of the frp proxy section that exposes the map.
+pub(crate) const PROXY_NAME: &str = "web-map";
+pub(crate) const AUTH_USER: &str = "map";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Kind {
+    Dynmap,
+    BlueMap,
+    Squaremap,
+}
+
+impl Kind {
+    pub(crate) fn parse(raw: &str) -> Option<Self> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "dynmap" => Some(Self::Dynmap),
+            "bluemap" => Some(Self::BlueMap),
+            "squaremap" => Some(Self::Squaremap),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_str(self) -> &'static str {
+        match self {
+            Self::Dynmap => "dynmap",
+            Self::BlueMap => "bluemap",
+            Self::Squaremap => "squaremap",
+        }
+    }
+
+    // Modrinth project slugs happen to match.
+    fn slug(self) -> &'static str {
+        self.as_str()
+    }
+
+    // Relative to the instance directory (the server's working directory).
+    fn config_path(self) -> PathBuf {
+        match self {
+            Self::Dynmap => PathBuf::from("dynmap/configuration.txt"),
+            Self::BlueMap => PathBuf::from("config/bluemap/webserver.conf"),
+            Self::Squaremap => PathBuf::from("config/squaremap/config.yml"),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub(crate) struct Marker {
+    pub(crate) kind: String,
+    pub(crate) port: u16,
+    pub(crate) loader: String,
+    pub(crate) minecraft: String,
+    pub(crate) version_id: String,
+    pub(crate) version_number: String,
+    // Under mods/.
+    pub(crate) filename: String,
+    #[serde(default)]
+    pub(crate) domain: Option<String>,
+    // frps vhost_http_port, only used to build the public URL.
+    #[serde(default)]
+    pub(crate) public_port: u16,
+    #[serde(default)]
+    pub(crate) auth_password: Option<String>,
+}
+
+impl Marker {
+    pub(crate) fn kind(&self) -> Option<Kind> {
+        Kind::parse(&self.kind)
+    }
+}
+
+pub(crate) fn read_marker(instance_dir: &Path) -> Option<Marker> {
+    let raw = std::fs::read(instance_dir.join(MARKER)).ok()?;
+    serde_json::from_slice(&raw).ok()
+}
+
+pub(crate) fn write_marker(instance_dir: &Path, marker: &Marker) -> anyhow::Result<()> {
+    let p = instance_dir.join(MARKER);
+    let tmp = p.with_extension("json.tmp");
+    std::fs::write(&tmp, serde_json::to_vec_pretty(marker)?)?;
+    std::fs::rename(tmp, p)?;
+    Ok(())
+}
+
+// Mod loader of the instance: recorded for Modrinth packs, otherwise guessed from the files the
+// loader installers leave behind. Vanilla servers have none and can't load a web map.
+pub(crate) fn detect_loader(instance_dir: &Path) -> Option<String> {
+    if let Some(pack) = crate::minecraft_modrinth::installed_pack(instance_dir) {
+        return Some(pack.loader);
+    }
+    let libs = instance_dir.join("libraries").join("net");
+    if instance_dir.join(".fabric").is_dir()
+        || instance_dir.join("fabric-server-launch.jar").is_file()
+    {
+        Some("fabric".to_string())
+    } else if libs.join("neoforged").is_dir() {
+        Some("neoforge".to_string())
+    } else if libs.join("minecraftforge").is_dir() {
+        Some("forge".to_string())
+    } else {
+        None
+    }
+}
+
+pub(crate) fn detect_minecraft_version(instance_dir: &Path) -> Option<String> {
+    crate::minecraft_modrinth::installed_pack(instance_dir).map(|p| p.minecraft)
+}
+
+fn http_client() -> &'static reqwest::Client {
+    static CLIENT: OnceLock<reqwest::Client> = OnceLock::new();
+    CLIENT.get_or_init(|| {
+        reqwest::Client::builder()
+            .user_agent("alloy-agent")
+            .timeout(Duration::from_secs(10 * 60))
+            .build()
+            .expect("failed to build reqwest client")
+    })
+}
+
+#[derive(Debug, Deserialize)]
+struct ModrinthVersion {
+    id: String,
+    version_number: String,
+    version_type: String,
+    files: Vec<ModrinthFile>,
+}
+
+#[derive(Debug, Deserialize)]
+struct ModrinthFile {
+    url: String,
+    filename: String,
+    #[serde(default)]
+    primary: bool,
+    hashes: ModrinthHashes,
+}
+
+#[derive(Debug, Deserialize)]
+struct ModrinthHashes {
+    sha1: String,
+}
+
+// Newest release build for the loader and game version (newest beta when there is no release).
+async fn resolve_version(
+    kind: Kind,
+    loader: &str,
+    minecraft: &str,
+) -> anyhow::Result<(ModrinthVersion, ModrinthFile)> {
+    let url = reqwest::Url::parse_with_params(
+        &format!(
+            "https://api.modrinth.com/v2/project/{}/version",
+            kind.slug()
+        ),
+        &[
+            ("loaders", serde_json::json!([loader]).to_string()),
+            ("game_versions", serde_json::json!([minecraft]).to_string()),
+        ],
+    )?;
+    let versions = crate::upstream::get_json::<Vec<ModrinthVersion>>(
+        crate::upstream::MODRINTH,
+        url.as_str(),
+        |u| http_client().get(u),
+    )
+    .await
+    .context("fetch modrinth versions")?
+    .value;
+
+    let mut version = versions
+        .into_iter()
+        .enumerate()
+        .min_by_key(|(i, v)| (v.version_type != "release", *i))
+        .map(|(_, v)| v)
+        .ok_or_else(|| {
+            anyhow::anyhow!(
+                "{} has no {loader} build for Minecraft {minecraft}",
+                kind.as_str()
+            )
+        })?;
+    version.files.sort_by_key(|f| !f.primary);
+    let file = version
+        .files
+        .drain(..)
+        .find(|f| f.filename.ends_with(".jar"))
+        .ok_or_else(|| anyhow::anyhow!("modrinth version {} has no jar", version.id))?;
+    Ok((version, file))
+}
+
+async fn download_verified(url: &str, path: &Path, sha1_hex: &str) -> anyhow::Result<()> {
+    let bytes = http_client()
+        .get(url)
+        .send()
+        .await
+        .with_context(|| format!("download {url}"))?
+        .error_for_status()
+        .with_context(|| format!("download {url} (status)"))?
+        .bytes()
+        .await
+        .with_context(|| format!("download {url}"))?;
+    let got = hex::encode(sha1::Sha1::digest(&bytes));
+    if !got.eq_ignore_ascii_case(sha1_hex) {
+        anyhow::bail!("sha1 mismatch for {url}: expected {sha1_hex}, got {got}");
+    }
+    if let Some(parent) = path.parent() {
+        tokio::fs::create_dir_all(parent).await?;
+    }
+    let tmp = path.with_extension("jar.tmp");
+    tokio::fs::write(&tmp, &bytes).await?;
+    tokio::fs::rename(&tmp, path).await?;
+    Ok(())
+}
+
+// Replaces the value of the first `key:` line (any indentation), or appends one.
+fn set_line_value(raw: &str, key: &str, value: &str) -> String {
+    let mut out = String::with_capacity(raw.len() + 32);
+    let mut replaced = false;
+    for line in raw.lines() {
+        let indent = &line[..line.len() - line.trim_start().len()];
+        let is_key = line
+            .trim_start()
+            .split_once(':')
+            .is_some_and(|(k, _)| k.trim() == key);
+        if !replaced && is_key {
+            out.push_str(&format!("{indent}{key}: {value}\n"));
+            replaced = true;
+        } else {
+            out.push_str(line);
+            out.push('\n');
+        }
+    }
+    if !replaced {
+        out.push_str(&format!("{key}: {value}\n"));
+    }
+    out
+}
+
+fn line_value(raw: &str, key: &str) -> Option<String> {
+    raw.lines().find_map(|line| {
+        let (k, v) = line.trim_start().split_once(':')?;
+        (k.trim() == key).then(|| v.trim().trim_matches('"').to_string())
+    })
+}
+
+// Writes the agent-assigned port into the map's config. BlueMap and squaremap fill in defaults
+// for everything else, so a missing file is created. Dynmap needs its full generated
+// configuration.txt; until its first start has written it, nothing is changed and Ok(false) is
+// returned.
+pub(crate) fn apply_config(instance_dir: &Path, kind: Kind, port: u16) -> anyhow::Result<bool> {
+    let path = instance_dir.join(kind.config_path());
+    let existing = match std::fs::read_to_string(&path) {
+        Ok(v) => Some(v),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => None,
+        Err(e) => return Err(e).with_context(|| format!("read {}", path.display())),
+    };
+    let updated = match (kind, existing) {
+        (Kind::Dynmap, None) => return Ok(false),
+        (Kind::Dynmap, Some(raw)) => set_line_value(&raw, "webserver-port", &port.to_string()),
+        (Kind::BlueMap, raw) => set_line_value(&raw.unwrap_or_default(), "port", &port.to_string()),
+        (Kind::Squaremap, raw) => {
+            let mut doc: serde_yaml::Value = match raw {
+                Some(raw) if !raw.trim().is_empty() => serde_yaml::from_str(&raw)
+                    .with_context(|| format!("parse {}", path.display()))?,
+                _ => serde_yaml::Value::Mapping(Default::default()),
+            };
+            let web = doc
+                .as_mapping_mut()
+                .map(|m| {
+                    m.entry("settings".into())
+                        .or_insert_with(|| serde_yaml::Value::Mapping(Default::default()))
+                })
+                .and_then(|s| s.as_mapping_mut())
+                .map(|s| {
+                    s.entry("internal-webserver".into())
+                        .or_insert_with(|| serde_yaml::Value::Mapping(Default::default()))
+                })
+                .and_then(|w| w.as_mapping_mut())
+                .ok_or_else(|| anyhow::anyhow!("unexpected layout in {}", path.display()))?;
+            web.insert("enabled".into(), true.into());
+            web.insert("port".into(), u64::from(port).into());
+            serde_yaml::to_string(&doc)?
+        }
+    };
+    if let Some(parent) = path.parent() {
+        std::fs::create_dir_all(parent)?;
+    }
+    let tmp = path.with_extension("alloy.tmp");
+    std::fs::write(&tmp, updated)?;
+    std::fs::rename(&tmp, &path)?;
+    Ok(true)
+}
+
+// The port the map's own config currently names, to spot a config edited by hand.
+pub(crate) fn config_port(instance_dir: &Path, kind: Kind) -> Option<u16> {
+    let raw = std::fs::read_to_string(instance_dir.join(kind.config_path())).ok()?;
+    let v = match kind {
+        Kind::Dynmap => line_value(&raw, "webserver-port")?,
+        Kind::BlueMap => line_value(&raw, "port")?,
+        Kind::Squaremap => {
+            let doc: serde_yaml::Value = serde_yaml::from_str(&raw).ok()?;
+            return doc
+                .get("settings")?
+                .get("internal-webserver")?
+                .get("port")?
+                .as_u64()
+                .and_then(|p| u16::try_from(p).ok());
+        }
+    };
+    v.parse().ok()
+}
+
+// Downloads the map mod and points its web server at `port`. A previously installed map jar is
+// removed, including one of a different kind.
+pub(crate) async fn install(
+    instance_dir: &Path,
+    kind: Kind,
+    loader: &str,
+    minecraft: &str,
+    port: u16,
+) -> anyhow::Result<Marker> {
+    let (version, file) = resolve_version(kind, loader, minecraft).await?;
+    let mods = instance_dir.join("mods");
+    download_verified(&file.url, &mods.join(&file.filename), &file.hashes.sha1).await?;
+
+    let previous = read_marker(instance_dir);
+    if let Some(prev) = &previous
+        && prev.filename != file.filename
+        && !prev.filename.is_empty()
+        && !prev.filename.contains(['/', '\\'])
+    {
+        let _ = tokio::fs::remove_file(mods.join(&prev.filename)).await;
+    }
+
+    let marker = Marker {
+        kind: kind.as_str().to_string(),
+        port,
+        loader: loader.to_string(),
+        minecraft: minecraft.to_string(),
+        version_id: version.id,
+        version_number: version.version_number,
+        filename: file.filename,
+        // Exposure survives a reinstall; the proxy only needs the new port.
+        domain: previous.as_ref().and_then(|p| p.domain.clone()),
+        public_port: previous.as_ref().map_or(0, |p| p.public_port),
+        auth_password: previous.and_then(|p| p.auth_password),
+    };
+    let dir = instance_dir.to_path_buf();
+    let m = marker.clone();
+    tokio::task::spawn_blocking(move || -> anyhow::Result<()> {
+        apply_config(&dir, kind, port)?;
+        write_marker(&dir, &m)
+    })
+    .await??;
+    Ok(marker)
+}
+
+// frp http proxy with basic auth in front of the map. frps needs vhost_http_port and the domain
+// must resolve to it.
+pub(crate) fn proxy_body(port: u16, domain: &str, password: &str) -> String {
+    format!(
+        "type = http\nlocal_ip = 127.0.0.1\nlocal_port = {port}\ncustom_domains = {domain}\n\
+         http_user = {AUTH_USER}\nhttp_pwd = {password}\n"
+    )
+}
+
+pub(crate) fn public_url(marker: &Marker) -> Option<String> {
+    let domain = marker.domain.as_deref()?;
+    Some(match marker.public_port {
+        0 | 80 => format!("http://{domain}/"),
+        port => format!("http://{domain}:{port}/"),
+    })
+}
+
+pub(crate) fn valid_domain(domain: &str) -> bool {
+    !domain.is_empty()
+        && domain.len() <= 253
+        && domain.contains('.')
+        && domain
+            .split('.')
+            .all(|l| !l.is_empty() && l.chars().all(|c| c.is_ascii_alphanumeric() || c == '-'))
+}
+
+pub(crate) async fn is_listening(port: u16) -> bool {
+    matches!(
+        tokio::time::timeout(
+            Duration::from_millis(500),
+            tokio::net::TcpStream::connect(("127.0.0.1", port)),
+        )
+        .await,
+        Ok(Ok(_))
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn port_is_written_into_each_config_format() {
+        let dir = std::env::temp_dir().join(format!("alloy-webmap-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+
+        // Dynmap waits for its generated config, then only the port line changes.
+        assert!(!apply_config(&dir, Kind::Dynmap, 8200).unwrap());
+        let dynmap = dir.join("dynmap/configuration.txt");
+        std::fs::create_dir_all(dynmap.parent().unwrap()).unwrap();
+        std::fs::write(&dynmap, "deftemplatesuffix: hires\nwebserver-port: 8123\n").unwrap();
+        assert!(apply_config(&dir, Kind::Dynmap, 8200).unwrap());
+        assert_eq!(
+            std::fs::read_to_string(&dynmap).unwrap(),
+            "deftemplatesuffix: hires\nwebserver-port: 8200\n"
+        );
+        assert_eq!(config_port(&dir, Kind::Dynmap), Some(8200));
+
+        assert!(apply_config(&dir, Kind::BlueMap, 8201).unwrap());
+        assert_eq!(config_port(&dir, Kind::BlueMap), Some(8201));
+
+        let squaremap = dir.join("config/squaremap/config.yml");
+        std::fs::create_dir_all(squaremap.parent().unwrap()).unwrap();
+        std::fs::write(&squaremap, "settings:\n  language-file: lang-en.yml\n").unwrap();
+        assert!(apply_config(&dir, Kind::Squaremap, 8202).unwrap());
+        assert_eq!(config_port(&dir, Kind::Squaremap), Some(8202));
+        assert!(
+            std::fs::read_to_string(&squaremap)
+                .unwrap()
+                .contains("language-file")
+        );
+
+        assert!(valid_domain("map.example.com"));
+        assert!(!valid_domain("map.example.com\nhttp_pwd = x"));
+        assert!(!valid_domain("localhost"));
+
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,258 @@
+use std::{
+    collections::BTreeMap,
+    sync::Mutex,
+    time::{Duration, Instant},
+};
+
+// Alternative base URLs per provider, e.g. BMCLAPI for Mojang on networks in mainland China.
+// ALLOY_MIRRORS_<PROVIDER> lists bases in the order to try them, `official` standing for the
+// provider's own hosts. A URL on one of the provider's official bases is rewritten by replacing
+// that base with each mirror's.
+// A mirror that fails is tried after the healthy ones for COOLDOWN, so a dead mirror costs one
+// failed request per cooldown instead of one per call.
+
+const OFFICIAL: &str = "official";
+const COOLDOWN: Duration = Duration::from_secs(5 * 60);
+
+struct Provider {
+    name: &'static str,
+    env: &'static str,
+    official: &'static [&'static str],
+}
+
+const PROVIDERS: &[Provider] = &[
+    Provider {
+        name: crate::upstream::MOJANG,
+        env: "ALLOY_MIRRORS_MOJANG",
+        official: &[
+            "https://piston-meta.mojang.com",
+            "https://piston-data.mojang.com",
+            "https://launchermeta.mojang.com",
+            "https://launcher.mojang.com",
+        ],
+    },
+    Provider {
+        name: crate::upstream::MODRINTH,
+        env: "ALLOY_MIRRORS_MODRINTH",
+        official: &["https://api.modrinth.com"],
+    },
+    Provider {
+        name: crate::upstream::FABRIC,
+        env: "ALLOY_MIRRORS_FABRIC",
+        official: &["https://meta.fabricmc.net"],
+    },
+    Provider {
+        name: crate::upstream::CURSEFORGE,
+        env: "ALLOY_MIRRORS_CURSEFORGE",
+        official: &["https://api.curseforge.com"],
+    },
+];
+
+// The configured order, with OFFICIAL for the provider's own hosts. They are appended when not
+// listed, unless the list says `-official`.
+fn parse_list(raw: &str) -> Vec<String> {
+    let mut out: Vec<String> = Vec::new();
+    let mut skip_official = false;
+    for entry in raw.split(',') {
+        let entry = entry.trim().trim_end_matches('/');
+        if entry == "-official" {
+            skip_official = true;
+        } else if entry.is_empty() || out.iter().any(|v| v == entry) {
+            continue;
+        } else if entry == OFFICIAL || entry.starts_with("https://") || entry.starts_with("http://")
+        {
+            out.push(entry.to_string());
+        } else {
+            tracing::warn!(%entry, "ignoring mirror that is not an http(s) URL");
+        }
+    }
+    if out.is_empty() || (!skip_official && !out.iter().any(|v| v == OFFICIAL)) {
+        out.push(OFFICIAL.to_string());
+    }
+    out
+}
+
+fn configured(provider: &Provider) -> Vec<String> {
+    std::env::var(provider.env)
+        .map(|raw| parse_list(&raw))
+        .unwrap_or_else(|_| vec![OFFICIAL.to_string()])
+}
+
+#[derive(Debug, Default)]
+struct Health {
+    successes: u64,
+    failures: u64,
+    down_until: Option<Instant>,
+    last_error: String,
+    last_ok_unix_ms: u64,
+    last_error_unix_ms: u64,
+}
+
+// Keyed by (provider, base).
+static HEALTH: Mutex<BTreeMap<(&'static str, String), Health>> = Mutex::new(BTreeMap::new());
+
+fn is_down(provider: &'static str, base: &str) -> bool {
+    let map = HEALTH.lock().unwrap_or_else(|e| e.into_inner());
+    map.get(&(provider, base.to_string()))
+        .and_then(|h| h.down_until)
+        .is_some_and(|t| t > Instant::now())
+}
+
+// One URL to try: `base` is the configured entry it came from, for health tracking.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Candidate {
+    pub provider: &'static str,
+    pub base: String,
+    pub url: String,
+}
+
+impl Candidate {
+    pub fn record<T>(&self, res: &anyhow::Result<T>) {
+        let error = res.as_ref().err().map(|e| format!("{e:#}"));
+        record(self.provider, &self.base, error.as_deref());
+    }
+}
+
+fn rewrite(provider: &Provider, list: &[String], url: &str) -> Vec<Candidate> {
+    let Some(official) = provider.official.iter().find(|base| {
+        url.strip_prefix(**base)
+            .is_some_and(|rest| rest.starts_with('/'))
+    }) else {
+        return vec![Candidate {
+            provider: provider.name,
+            base: OFFICIAL.to_string(),
+            url: url.to_string(),
+        }];
+    };
+    let rest = &url[official.len()..];
+    list.iter()
+        .map(|base| Candidate {
+            provider: provider.name,
+            base: base.clone(),
+            url: if base == OFFICIAL {
+                url.to_string()
+            } else {
+                format!("{base}{rest}")
+            },
+        })
+        .collect()
+}
+
+// URLs to try for `url`, in order: healthy mirrors as configured, then ones in their cooldown.
+// URLs that are not on the provider's official hosts are returned unchanged.
+pub fn candidates(provider: &'static str, url: &str) -> Vec<Candidate> {
+    let Some(p) = PROVIDERS.iter().find(|p| p.name == provider) else {
+        return vec![Candidate {
+            provider,
+            base: OFFICIAL.to_string(),
+            url: url.to_string(),
+        }];
+    };
+    let (healthy, down): (Vec<_>, Vec<_>) = rewrite(p, &configured(p), url)
+        .into_iter()
+        .partition(|c| !is_down(provider, &c.base));
+    healthy.into_iter().chain(down).collect()
+}
+
+pub fn record(provider: &'static str, base: &str, error: Option<&str>) {
+    let now_ms = crate::console_audit::now_unix_ms();
+    let mut map = HEALTH.lock().unwrap_or_else(|e| e.into_inner());
+    let h = map.entry((provider, base.to_string())).or_default();
+    match error {
+        None => {
+            h.successes = h.successes.saturating_add(1);
+            h.down_until = None;
+            h.last_ok_unix_ms = now_ms;
+        }
+        Some(e) => {
+            h.failures = h.failures.saturating_add(1);
+            h.down_until = Some(Instant::now() + COOLDOWN);
+            h.last_error = e.to_string();
+            h.last_error_unix_ms = now_ms;
+            tracing::warn!(provider, %base, error = %h.last_error, "download mirror failed");
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct Status {
+    pub provider: String,
+    pub base: String,
+    pub position: u32,
+    pub healthy: bool,
+    pub successes: u64,
+    pub failures: u64,
+    pub last_error: String,
+    pub last_ok_unix_ms: u64,
+    pub last_error_unix_ms: u64,
+}
+
+// Configured mirrors and their health, for the health check. Providers left at their defaults
+// are omitted.
+pub fn status() -> Vec<Status> {
+    let map = HEALTH.lock().unwrap_or_else(|e| e.into_inner());
+    let now = Instant::now();
+    let mut out = Vec::new();
+    for p in PROVIDERS {
+        let list = configured(p);
+        if list.len() == 1 && list[0] == OFFICIAL {
+            continue;
+        }
+        for (i, base) in list.into_iter().enumerate() {
+            let h = map.get(&(p.name, base.clone()));
+            out.push(Status {
+                provider: p.name.to_string(),
+                position: i as u32,
+                healthy: h.and_then(|h| h.down_until).is_none_or(|t| t <= now),
+                successes: h.map(|h| h.successes).unwrap_or_default(),
+                failures: h.map(|h| h.failures).unwrap_or_default(),
+                last_error: h.map(|h| h.last_error.clone()).unwrap_or_default(),
+                last_ok_unix_ms: h.map(|h| h.last_ok_unix_ms).unwrap_or_default(),
+                last_error_unix_ms: h.map(|h| h.last_error_unix_ms).unwrap_or_default(),
+                base,
+            });
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn rewrites_official_urls_onto_mirrors() {
+        let list = parse_list(" https://bmclapi2.bangbang93.com/ ,bogus");
+        assert_eq!(list, ["https://bmclapi2.bangbang93.com", OFFICIAL]);
+        assert_eq!(parse_list("official,https://m"), [OFFICIAL, "https://m"]);
+        assert_eq!(parse_list("https://m,-official"), ["https://m"]);
+        assert_eq!(parse_list(""), [OFFICIAL]);
+
+        let mojang = &PROVIDERS[0];
+        let urls: Vec<_> = rewrite(
+            mojang,
+            &list,
+            "https://piston-data.mojang.com/v1/objects/abc/server.jar",
+        )
+        .into_iter()
+        .map(|c| c.url)
+        .collect();
+        assert_eq!(
+            urls,
+            [
+                "https://bmclapi2.bangbang93.com/v1/objects/abc/server.jar",
+                "https://piston-data.mojang.com/v1/objects/abc/server.jar",
+            ]
+        );
+
+        // Other hosts, and look-alike hosts, are left alone.
+        for url in [
+            "https://example.com/server.jar",
+            "https://piston-data.mojang.com.evil.net/x",
+        ] {
+            let c = rewrite(mojang, &list, url);
+            assert_eq!(c.len(), 1);
+            assert_eq!(c[0].url, url);
+        }
+    }
+}
@@ -0,0 +1,263 @@
+use std::{collections::BTreeMap, io::Read, path::Path};
+
+use anyhow::Context;
+
+// Minimal reader for Minecraft's NBT format (big-endian, Java edition), enough to pull values out
+// of level.dat, region chunks and playerdata without a JVM.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) enum Tag {
+    Byte(i8),
+    Short(i16),
+    Int(i32),
+    Long(i64),
+    Float(f32),
+    Double(f64),
+    ByteArray(Vec<i8>),
+    String(String),
+    List(Vec<Tag>),
+    Compound(BTreeMap<String, Tag>),
+    IntArray(Vec<i32>),
+    LongArray(Vec<i64>),
+}
+
+// Nesting deeper than this is rejected instead of recursing without bound on a corrupt file.
+const MAX_DEPTH: usize = 512;
+
+impl Tag {
+    pub(crate) fn get(&self, key: &str) -> Option<&Tag> {
+        match self {
+            Tag::Compound(m) => m.get(key),
+            _ => None,
+        }
+    }
+
+    // Follows compound keys, e.g. `["Data", "WorldGenSettings", "seed"]`.
+    pub(crate) fn path(&self, keys: &[&str]) -> Option<&Tag> {
+        keys.iter().try_fold(self, |t, k| t.get(k))
+    }
+
+    pub(crate) fn as_i64(&self) -> Option<i64> {
+        match *self {
+            Tag::Byte(v) => Some(v.into()),
+            Tag::Short(v) => Some(v.into()),
+            Tag::Int(v) => Some(v.into()),
+            Tag::Long(v) => Some(v),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_f64(&self) -> Option<f64> {
+        match *self {
+            Tag::Float(v) => Some(v.into()),
+            Tag::Double(v) => Some(v),
+            _ => self.as_i64().map(|v| v as f64),
+        }
+    }
+
+    pub(crate) fn as_str(&self) -> Option<&str> {
+        match self {
+            Tag::String(s) => Some(s),
+            _ => None,
+        }
+    }
+
+    pub(crate) fn as_list(&self) -> &[Tag] {
+        match self {
+            Tag::List(v) => v,
+            _ => &[],
+        }
+    }
+
+    pub(crate) fn as_long_array(&self) -> &[i64] {
+        match self {
+            Tag::LongArray(v) => v,
+            _ => &[],
+        }
+    }
+}
+
+struct Reader<'a> {
+    buf: &'a [u8],
+}
+
+impl<'a> Reader<'a> {
+    fn take(&mut self, n: usize) -> anyhow::Result<&'a [u8]> {
+        if self.buf.len() < n {
+            anyhow::bail!("unexpected end of NBT data");
+        }
+        let (head, rest) = self.buf.split_at(n);
+        self.buf = rest;
+        Ok(head)
+    }
+
+    fn array<const N: usize>(&mut self) -> anyhow::Result<[u8; N]> {
+        Ok(self.take(N)?.try_into().expect("length checked"))
+    }
+
+    fn u8(&mut self) -> anyhow::Result<u8> {
+        Ok(self.take(1)?[0])
+    }
+
+    fn i16(&mut self) -> anyhow::Result<i16> {
+        Ok(i16::from_be_bytes(self.array()?))
+    }
+
+    fn i32(&mut self) -> anyhow::Result<i32> {
+        Ok(i32::from_be_bytes(self.array()?))
+    }
+
+    fn i64(&mut self) -> anyhow::Result<i64> {
+        Ok(i64::from_be_bytes(self.array()?))
+    }
+
+    fn array_len(&mut self) -> anyhow::Result<usize> {
+        let n = self.i32()?;
+        // Every element takes at least one byte, so a length beyond the input is corrupt.
+        if n < 0 || n as usize > self.buf.len() {
+            anyhow::bail!("invalid NBT length {n}");
+        }
+        Ok(n as usize)
+    }
+
+    fn string(&mut self) -> anyhow::Result<String> {
+        let n = u16::from_be_bytes(self.array()?) as usize;
+        // Modified UTF-8; plain UTF-8 for everything but NUL and astral characters.
+        Ok(String::from_utf8_lossy(self.take(n)?).into_owned())
+    }
+
+    fn payload(&mut self, ty: u8, depth: usize) -> anyhow::Result<Tag> {
+        if depth > MAX_DEPTH {
+            anyhow::bail!("NBT nested too deeply");
+        }
+        Ok(match ty {
+            1 => Tag::Byte(self.u8()? as i8),
+            2 => Tag::Short(self.i16()?),
+            3 => Tag::Int(self.i32()?),
+            4 => Tag::Long(self.i64()?),
+            5 => Tag::Float(f32::from_be_bytes(self.array()?)),
+            6 => Tag::Double(f64::from_be_bytes(self.array()?)),
+            7 => {
+                let n = self.array_len()?;
+                Tag::ByteArray(self.take(n)?.iter().map(|&b| b as i8).collect())
+            }
+            8 => Tag::String(self.string()?),
+            9 => {
+                let elem = self.u8()?;
+                let n = self.array_len()?;
+                if elem == 0 && n > 0 {
+                    anyhow::bail!("NBT list of end tags");
+                }
+                let mut out = Vec::with_capacity(n.min(4096));
+                for _ in 0..n {
+                    out.push(self.payload(elem, depth + 1)?);
+                }
+                Tag::List(out)
+            }
+            10 => {
+                let mut out = BTreeMap::new();
+                loop {
+                    let ty = self.u8()?;
+                    if ty == 0 {
+                        break;
+                    }
+                    let name = self.string()?;
+                    out.insert(name, self.payload(ty, depth + 1)?);
+                }
+                Tag::Compound(out)
+            }
+            11 => {
+                let n = self.array_len()?;
+                let mut out = Vec::with_capacity(n.min(self.buf.len() / 4));
+                for _ in 0..n {
+                    out.push(self.i32()?);
+                }
+                Tag::IntArray(out)
+            }
+            12 => {
+                let n = self.array_len()?;
+                let mut out = Vec::with_capacity(n.min(self.buf.len() / 8));
+                for _ in 0..n {
+                    out.push(self.i64()?);
+                }
+                Tag::LongArray(out)
+            }
+            other => anyhow::bail!("unknown NBT tag type {other}"),
+        })
+    }
+}
+
+// Parses uncompressed NBT and returns the root tag (its name is dropped).
+pub(crate) fn parse(data: &[u8]) -> anyhow::Result<Tag> {
+    let mut r = Reader { buf: data };
+    let ty = r.u8()?;
+    if ty != 10 {
+        anyhow::bail!("NBT root is not a compound (type {ty})");
+    }
+    let _name = r.string()?;
+    r.payload(ty, 0)
+}
+
+// gzip (level.dat, playerdata) and zlib (region chunks) are detected by their magic bytes;
+// anything else is taken as uncompressed.
+pub(crate) fn decompress(data: &[u8]) -> anyhow::Result<Vec<u8>> {
+    let mut out = Vec::new();
+    match data {
+        [0x1f, 0x8b, ..] => {
+            flate2::read::GzDecoder::new(data).read_to_end(&mut out)?;
+        }
+        [0x78, ..] => {
+            flate2::read::ZlibDecoder::new(data).read_to_end(&mut out)?;
+        }
+        _ => out.extend_from_slice(data),
+    }
+    Ok(out)
+}
+
+pub(crate) fn read_file(path: &Path) -> anyhow::Result<Tag> {
+    let raw = std::fs::read(path).with_context(|| format!("read {}", path.display()))?;
+    let data = decompress(&raw).with_context(|| format!("decompress {}", path.display()))?;
+    parse(&data).with_context(|| format!("parse {}", path.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_nested_compounds_lists_and_arrays() {
+        // {"": {seed: 42L, name: "w", pos: [1.5d, 2.5d], h: [7L]}}
+        let mut b = vec![10, 0, 0];
+        b.extend([4, 0, 4]);
+        b.extend(b"seed");
+        b.extend(42i64.to_be_bytes());
+        b.extend([8, 0, 4]);
+        b.extend(b"name");
+        b.extend([0, 1, b'w']);
+        b.extend([9, 0, 3]);
+        b.extend(b"pos");
+        b.push(6);
+        b.extend(2i32.to_be_bytes());
+        b.extend(1.5f64.to_be_bytes());
+        b.extend(2.5f64.to_be_bytes());
+        b.extend([12, 0, 1, b'h']);
+        b.extend(1i32.to_be_bytes());
+        b.extend(7i64.to_be_bytes());
+        b.push(0);
+
+        let root = parse(&b).unwrap();
+        assert_eq!(root.path(&["seed"]).and_then(Tag::as_i64), Some(42));
+        assert_eq!(root.get("name").and_then(Tag::as_str), Some("w"));
+        let pos: Vec<f64> = root
+            .get("pos")
+            .unwrap()
+            .as_list()
+            .iter()
+            .filter_map(Tag::as_f64)
+            .collect();
+        assert_eq!(pos, vec![1.5, 2.5]);
+        assert_eq!(root.get("h").unwrap().as_long_array(), &[7]);
+
+        // Truncated input fails instead of panicking.
+        assert!(parse(&b[..b.len() - 3]).is_err());
+    }
+}
@@ -0,0 +1,196 @@
+use std::{
+    io::ErrorKind,
+    net::{IpAddr, Ipv4Addr, Ipv6Addr, TcpListener, UdpSocket},
+};
+
+// Addresses for port checks, readiness probes and frpc configs. A server may listen on the IPv4
+// wildcard, the IPv6 one (dual-stack on Linux by default) or a single literal such as `::1` from
+// `server-ip`, so checks bind every wildcard the host supports and probes connect to a loopback
+// address of the right family. Hosts without IPv6 skip the IPv6 side instead of failing.
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Family {
+    Any,
+    V4,
+    V6,
+}
+
+impl Family {
+    pub fn parse(raw: &str) -> Option<Self> {
+        match raw.trim().to_ascii_lowercase().as_str() {
+            "" | "any" | "dual" => Some(Self::Any),
+            "ipv4" | "v4" | "4" => Some(Self::V4),
+            "ipv6" | "v6" | "6" => Some(Self::V6),
+            _ => None,
+        }
+    }
+
+    fn allows(self, ip: IpAddr) -> bool {
+        match self {
+            Self::Any => true,
+            Self::V4 => ip.is_ipv4(),
+            Self::V6 => ip.is_ipv6(),
+        }
+    }
+}
+
+// Address family readiness probes connect over when the server binds a wildcard
+// (ALLOY_PROBE_FAMILY=any|ipv4|ipv6, default any).
+pub fn probe_family() -> Family {
+    std::env::var("ALLOY_PROBE_FAMILY")
+        .ok()
+        .and_then(|v| Family::parse(&v))
+        .unwrap_or(Family::Any)
+}
+
+// An IP literal, with or without the brackets of a URL host.
+pub fn parse_ip(raw: &str) -> Option<IpAddr> {
+    let raw = raw.trim();
+    let raw = raw
+        .strip_prefix('[')
+        .and_then(|v| v.strip_suffix(']'))
+        .unwrap_or(raw);
+    raw.parse().ok()
+}
+
+// `host:port`, bracketing IPv6 literals.
+pub fn join_host_port(host: &str, port: u16) -> String {
+    match parse_ip(host) {
+        Some(IpAddr::V6(ip)) => format!("[{ip}]:{port}"),
+        _ => format!("{host}:{port}"),
+    }
+}
+
+// Splits `host:port`, `[v6]:port`, a bare host or a bare IPv6 literal. The host comes back without
+// brackets; the port is None when absent or not a port number.
+pub fn split_host_port(raw: &str) -> (&str, Option<u16>) {
+    let raw = raw.trim();
+    if let Some(rest) = raw.strip_prefix('[')
+        && let Some((host, tail)) = rest.split_once(']')
+    {
+        return (host, tail.strip_prefix(':').and_then(|p| p.parse().ok()));
+    }
+    if matches!(parse_ip(raw), Some(IpAddr::V6(_))) {
+        return (raw, None);
+    }
+    match raw.rsplit_once(':') {
+        Some((host, port)) => match port.parse() {
+            Ok(port) => (host, Some(port)),
+            Err(_) => (raw, None),
+        },
+        None => (raw, None),
+    }
+}
+
+// Where a local client (a probe, frpc) reaches a server bound to `bind`: a specific address as is,
+// a wildcard through loopback of the families it accepts, filtered by `family`.
+pub fn local_targets(bind: Option<IpAddr>, family: Family) -> Vec<IpAddr> {
+    let v4 = IpAddr::V4(Ipv4Addr::LOCALHOST);
+    let v6 = IpAddr::V6(Ipv6Addr::LOCALHOST);
+    let all = match bind {
+        Some(ip) if !ip.is_unspecified() => vec![ip],
+        Some(IpAddr::V4(_)) => vec![v4],
+        // `::` also accepts IPv4 unless the host sets bindv6only.
+        Some(IpAddr::V6(_)) => vec![v6, v4],
+        None => vec![v4, v6],
+    };
+    let filtered: Vec<IpAddr> = all
+        .iter()
+        .copied()
+        .filter(|ip| family.allows(*ip))
+        .collect();
+    if filtered.is_empty() { all } else { filtered }
+}
+
+// The single address frpc should forward to for a server bound to `bind`.
+pub fn local_target(bind: Option<IpAddr>) -> IpAddr {
+    local_targets(bind, Family::Any)[0]
+}
+
+fn wildcards(bind: Option<IpAddr>) -> Vec<IpAddr> {
+    match bind {
+        Some(ip) => vec![ip],
+        None => vec![
+            IpAddr::V4(Ipv4Addr::UNSPECIFIED),
+            IpAddr::V6(Ipv6Addr::UNSPECIFIED),
+        ],
+    }
+}
+
+fn ipv6_unsupported(ip: IpAddr, e: &std::io::Error) -> bool {
+    ip.is_ipv6()
+        && (e.kind() == ErrorKind::AddrNotAvailable || e.raw_os_error() == Some(libc::EAFNOSUPPORT))
+}
+
+// Ok when a TCP listener could bind `port` on `bind`, or on both wildcards when unset. The
+// sockets are closed again before returning.
+pub fn check_tcp(bind: Option<IpAddr>, port: u16) -> std::io::Result<()> {
+    for ip in wildcards(bind) {
+        match TcpListener::bind((ip, port)) {
+            Ok(_) => {}
+            Err(e) if bind.is_none() && ipv6_unsupported(ip, &e) => {}
+            Err(e) => return Err(e),
+        }
+    }
+    Ok(())
+}
+
+// As check_tcp, for UDP.
+pub fn check_udp(bind: Option<IpAddr>, port: u16) -> std::io::Result<()> {
+    for ip in wildcards(bind) {
+        match UdpSocket::bind((ip, port)) {
+            Ok(_) => {}
+            Err(e) if bind.is_none() && ipv6_unsupported(ip, &e) => {}
+            Err(e) => return Err(e),
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn handles_ipv6_literals() {
+        assert_eq!(parse_ip("[::1]"), Some(IpAddr::V6(Ipv6Addr::LOCALHOST)));
+        assert_eq!(parse_ip(" 10.0.0.2 "), "10.0.0.2".parse().ok());
+        assert_eq!(parse_ip("example.com"), None);
+        assert_eq!(join_host_port("2001:db8::1", 7000), "[2001:db8::1]:7000");
+        assert_eq!(join_host_port("[::1]", 7400), "[::1]:7400");
+        assert_eq!(
+            join_host_port("frps.example.com", 7000),
+            "frps.example.com:7000"
+        );
+
+        assert_eq!(
+            split_host_port("[2001:db8::1]:7001"),
+            ("2001:db8::1", Some(7001))
+        );
+        assert_eq!(split_host_port("2001:db8::1"), ("2001:db8::1", None));
+        assert_eq!(
+            split_host_port("frps.example.com:7000"),
+            ("frps.example.com", Some(7000))
+        );
+        assert_eq!(
+            split_host_port("frps.example.com"),
+            ("frps.example.com", None)
+        );
+
+        let v6 = IpAddr::V6(Ipv6Addr::LOCALHOST);
+        let v4 = IpAddr::V4(Ipv4Addr::LOCALHOST);
+        assert_eq!(local_target(parse_ip("::")), v6);
+        assert_eq!(local_target(parse_ip("0.0.0.0")), v4);
+        assert_eq!(
+            local_target(parse_ip("2001:db8::5")),
+            parse_ip("2001:db8::5").unwrap()
+        );
+        assert_eq!(local_targets(None, Family::V6), [v6]);
+        // A family the bind address can't serve falls back to what it can.
+        assert_eq!(local_targets(parse_ip("0.0.0.0"), Family::V6), [v4]);
+
+        let held = TcpListener::bind(("0.0.0.0", 0)).unwrap();
+        let port = held.local_addr().unwrap().port();
+        assert!(check_tcp(None, port).is_err());
+    }
+}
@@ -0,0 +1,108 @@
+use std::{collections::BTreeMap, path::Path, sync::OnceLock};
+
+// Who this agent is, for a panel that coordinates several agents: a stable id generated on the
+// first start and kept in <data root>/node-id, a display name, labels set by the operator and the
+// address players reach this host on. Sent in the control tunnel's hello frame and with instance
+// metadata and outbox events, so instances and backups can be attributed to a node even after a
+// rename.
+//
+// ALLOY_NODE_NAME (else $HOSTNAME), ALLOY_NODE_LABELS (e.g. `region=eu,disk=ssd`) and
+// ALLOY_NODE_PUBLIC_ADDRESS configure it.
+
+const ID_FILE: &str = "node-id";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Identity {
+    pub id: String,
+    pub name: String,
+    pub labels: BTreeMap<String, String>,
+    pub public_address: Option<String>,
+}
+
+fn env_trimmed(name: &str) -> Option<String> {
+    std::env::var(name)
+        .ok()
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+}
+
+fn node_name() -> String {
+    env_trimmed("ALLOY_NODE_NAME")
+        .or_else(|| env_trimmed("HOSTNAME"))
+        .unwrap_or_else(|| "default".to_string())
+}
+
+fn valid_label(s: &str) -> bool {
+    !s.is_empty()
+        && s.len() <= 63
+        && s.chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.' | '/'))
+}
+
+// `k=v` pairs separated by commas; malformed entries are skipped with a warning.
+pub fn parse_labels(raw: &str) -> BTreeMap<String, String> {
+    let mut out = BTreeMap::new();
+    for entry in raw.split(',').map(str::trim).filter(|e| !e.is_empty()) {
+        match entry.split_once('=').map(|(k, v)| (k.trim(), v.trim())) {
+            Some((k, v)) if valid_label(k) && (v.is_empty() || valid_label(v)) => {
+                out.insert(k.to_string(), v.to_string());
+            }
+            _ => tracing::warn!(%entry, "ignoring invalid entry in ALLOY_NODE_LABELS"),
+        }
+    }
+    out
+}
+
+// The id kept in `path`, or a new one written there. A node whose data root can't be written
+// still gets an id, just not a stable one.
+fn load_or_create_id(path: &Path) -> String {
+    if let Ok(raw) = std::fs::read_to_string(path) {
+        let id = raw.trim();
+        if !id.is_empty() {
+            return id.to_string();
+        }
+    }
+    let id = alloy_process::ProcessId::new().0;
+    let tmp = path.with_extension("tmp");
+    if let Err(e) =
+        std::fs::write(&tmp, format!("{id}\n")).and_then(|()| std::fs::rename(&tmp, path))
+    {
+        tracing::warn!(path = %path.display(), error = %e, "cannot persist node id");
+    }
+    id
+}
+
+pub fn get() -> &'static Identity {
+    static IDENTITY: OnceLock<Identity> = OnceLock::new();
+    IDENTITY.get_or_init(|| Identity {
+        id: load_or_create_id(&crate::minecraft::data_root().join(ID_FILE)),
+        name: node_name(),
+        labels: parse_labels(&std::env::var("ALLOY_NODE_LABELS").unwrap_or_default()),
+        public_address: env_trimmed("ALLOY_NODE_PUBLIC_ADDRESS"),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_labels_and_keeps_the_id() {
+        let labels = parse_labels(" region=eu-west , disk=ssd,bad label=x,=v,gpu=");
+        assert_eq!(
+            labels.into_iter().collect::<Vec<_>>(),
+            [
+                ("disk".to_string(), "ssd".to_string()),
+                ("gpu".to_string(), String::new()),
+                ("region".to_string(), "eu-west".to_string()),
+            ]
+        );
+
+        let path = std::env::temp_dir().join(format!("alloy-node-id-test-{}", std::process::id()));
+        let _ = std::fs::remove_file(&path);
+        let id = load_or_create_id(&path);
+        assert!(!id.is_empty());
+        assert_eq!(load_or_create_id(&path), id);
+        let _ = std::fs::remove_file(&path);
+    }
+}
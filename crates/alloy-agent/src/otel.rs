@@ -0,0 +1,85 @@
+use std::time::Duration;
+
+use opentelemetry::{KeyValue, trace::TracerProvider as _};
+use opentelemetry_otlp::WithExportConfig;
+use opentelemetry_sdk::{
+    Resource, runtime,
+    trace::{Sampler, Tracer, TracerProvider},
+};
+use tracing_opentelemetry::OpenTelemetryLayer;
+use tracing_subscriber::registry::LookupSpan;
+
+// Optional OTLP trace export. Off unless ALLOY_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_ENDPOINT) is
+// set. Every span the log filter lets through is exported: RPCs (with their request id), sandbox
+// preparation, provider downloads and backup phases, so a slow call can be broken down into disk,
+// network and compression time.
+
+const EXPORT_TIMEOUT: Duration = Duration::from_secs(10);
+
+pub fn endpoint() -> Option<String> {
+    let raw = std::env::var("ALLOY_OTLP_ENDPOINT")
+        .or_else(|_| std::env::var("OTEL_EXPORTER_OTLP_ENDPOINT"))
+        .unwrap_or_default();
+    match raw.trim() {
+        "" | "off" | "0" | "false" => None,
+        endpoint => Some(endpoint.to_string()),
+    }
+}
+
+// Fraction of traces to keep; child spans follow their root's decision.
+fn sample_ratio() -> f64 {
+    std::env::var("ALLOY_OTLP_SAMPLE_RATIO")
+        .ok()
+        .and_then(|v| v.trim().parse::<f64>().ok())
+        .filter(|v| v.is_finite())
+        .map(|v| v.clamp(0.0, 1.0))
+        .unwrap_or(1.0)
+}
+
+fn node_name() -> String {
+    std::env::var("ALLOY_NODE_NAME")
+        .or_else(|_| std::env::var("HOSTNAME"))
+        .unwrap_or_default()
+}
+
+// Flushes buffered spans when dropped.
+pub struct Exporter {
+    provider: TracerProvider,
+}
+
+impl Drop for Exporter {
+    fn drop(&mut self) {
+        if let Err(e) = self.provider.shutdown() {
+            eprintln!("failed to flush OTLP spans: {e}");
+        }
+    }
+}
+
+// A tracing layer exporting spans over OTLP/gRPC to `endpoint`. Must be called inside the tokio
+// runtime, which runs the batch exporter.
+pub fn layer<S>(endpoint: &str) -> anyhow::Result<(OpenTelemetryLayer<S, Tracer>, Exporter)>
+where
+    S: tracing::Subscriber + for<'span> LookupSpan<'span>,
+{
+    let exporter = opentelemetry_otlp::SpanExporter::builder()
+        .with_tonic()
+        .with_endpoint(endpoint)
+        .with_timeout(EXPORT_TIMEOUT)
+        .build()?;
+    let provider = TracerProvider::builder()
+        .with_batch_exporter(exporter, runtime::Tokio)
+        .with_sampler(Sampler::ParentBased(Box::new(Sampler::TraceIdRatioBased(
+            sample_ratio(),
+        ))))
+        .with_resource(Resource::new([
+            KeyValue::new("service.name", "alloy-agent"),
+            KeyValue::new("service.version", env!("CARGO_PKG_VERSION")),
+            KeyValue::new("host.name", node_name()),
+        ]))
+        .build();
+    let tracer = provider.tracer("alloy-agent");
+    Ok((
+        tracing_opentelemetry::layer().with_tracer(tracer),
+        Exporter { provider },
+    ))
+}
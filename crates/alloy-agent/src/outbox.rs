@@ -0,0 +1,208 @@
+use std::{
+    collections::VecDeque,
+    path::{Path, PathBuf},
+    sync::{Mutex, OnceLock},
+};
+
+use anyhow::Context;
+use serde::{Deserialize, Serialize};
+use tokio::sync::watch;
+
+use crate::process_manager_support::env_usize;
+
+const DEFAULT_MAX_EVENTS: usize = 1000;
+
+// Events that control must not miss (crashes, backup results). They are persisted until control
+// acknowledges them, and replayed in sequence order after every reconnect.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub(crate) struct OutboxEvent {
+    pub(crate) seq: u64,
+    pub(crate) kind: String,
+    pub(crate) at_unix_ms: u64,
+    pub(crate) data: serde_json::Value,
+}
+
+// On-disk layout. `epoch` changes whenever the file is recreated, so control can tell a reset
+// sequence apart from a replay.
+#[derive(Debug, Default, Serialize, Deserialize)]
+struct OutboxFile {
+    epoch: String,
+    next_seq: u64,
+    events: VecDeque<OutboxEvent>,
+}
+
+struct Outbox {
+    path: PathBuf,
+    file: OutboxFile,
+    // Latest sequence number pushed, so senders can wait for new events.
+    latest: watch::Sender<u64>,
+}
+
+fn outbox() -> &'static Mutex<Option<Outbox>> {
+    static OUTBOX: OnceLock<Mutex<Option<Outbox>>> = OnceLock::new();
+    OUTBOX.get_or_init(|| Mutex::new(None))
+}
+
+fn max_events() -> usize {
+    env_usize("ALLOY_OUTBOX_MAX_EVENTS")
+        .map(|v| v.clamp(10, 100_000))
+        .unwrap_or(DEFAULT_MAX_EVENTS)
+}
+
+fn now_unix_ms() -> u64 {
+    if let Some(ms) = crate::test_mode::now_unix_ms() {
+        return ms;
+    }
+    std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_millis() as u64)
+        .unwrap_or(0)
+}
+
+fn load(path: &Path) -> OutboxFile {
+    let parsed = std::fs::read(path)
+        .ok()
+        .and_then(|raw| serde_json::from_slice::<OutboxFile>(&raw).ok())
+        .filter(|f| !f.epoch.is_empty());
+    parsed.unwrap_or_else(|| OutboxFile {
+        epoch: format!("{:x}", now_unix_ms()),
+        next_seq: 1,
+        events: VecDeque::new(),
+    })
+}
+
+fn persist(path: &Path, file: &OutboxFile) -> anyhow::Result<()> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir).with_context(|| format!("create {}", dir.display()))?;
+    }
+    let tmp = path.with_extension("json.tmp");
+    let data = serde_json::to_vec(file).context("serialize outbox")?;
+    std::fs::write(&tmp, data).with_context(|| format!("write {}", tmp.display()))?;
+    std::fs::rename(&tmp, path).with_context(|| format!("persist {}", path.display()))?;
+    Ok(())
+}
+
+// Only the reverse tunnel enables the outbox; without it, push() is a no-op.
+pub(crate) fn enable(path: PathBuf) {
+    let file = load(&path);
+    let (latest, _) = watch::channel(file.next_seq.saturating_sub(1));
+    if !file.events.is_empty() {
+        tracing::info!(
+            pending = file.events.len(),
+            path = %path.display(),
+            "outbox has events waiting for control"
+        );
+    }
+    let mut guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    *guard = Some(Outbox { path, file, latest });
+}
+
+pub(crate) fn epoch() -> Option<String> {
+    let guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    guard.as_ref().map(|o| o.file.epoch.clone())
+}
+
+pub(crate) fn push(kind: &str, mut data: serde_json::Value) {
+    // Lets a panel with several nodes attribute the event even if the node is renamed later.
+    if let Some(obj) = data.as_object_mut() {
+        obj.entry("node_id")
+            .or_insert_with(|| crate::node_identity::get().id.clone().into());
+    }
+    let mut guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    let Some(o) = guard.as_mut() else {
+        return;
+    };
+    let seq = o.file.next_seq;
+    o.file.next_seq += 1;
+    o.file.events.push_back(OutboxEvent {
+        seq,
+        kind: kind.to_string(),
+        at_unix_ms: now_unix_ms(),
+        data,
+    });
+    // Bounded so a control that never comes back can't fill the disk; the oldest go first.
+    let excess = o.file.events.len().saturating_sub(max_events());
+    if excess > 0 {
+        o.file.events.drain(..excess);
+        tracing::warn!(dropped = excess, "outbox full; dropped oldest events");
+    }
+    if let Err(err) = persist(&o.path, &o.file) {
+        tracing::warn!(error = %err, "failed to persist outbox");
+    }
+    o.latest.send_replace(seq);
+}
+
+// Receiver that changes whenever an event is pushed. None while the outbox is disabled.
+pub(crate) fn watch() -> Option<watch::Receiver<u64>> {
+    let guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    guard.as_ref().map(|o| o.latest.subscribe())
+}
+
+// Unacknowledged events with a sequence number above `seq`, oldest first.
+pub(crate) fn pending_after(seq: u64) -> Vec<OutboxEvent> {
+    let guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    let Some(o) = guard.as_ref() else {
+        return Vec::new();
+    };
+    o.file
+        .events
+        .iter()
+        .filter(|e| e.seq > seq)
+        .cloned()
+        .collect()
+}
+
+// Control has stored everything up to and including `seq`. Acks for another epoch are stale.
+pub(crate) fn ack(epoch: &str, seq: u64) {
+    let mut guard = outbox().lock().unwrap_or_else(|e| e.into_inner());
+    let Some(o) = guard.as_mut() else {
+        return;
+    };
+    if o.file.epoch != epoch {
+        return;
+    }
+    let before = o.file.events.len();
+    o.file.events.retain(|e| e.seq > seq);
+    if o.file.events.len() == before {
+        return;
+    }
+    if let Err(err) = persist(&o.path, &o.file) {
+        tracing::warn!(error = %err, "failed to persist outbox");
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn load_starts_a_new_epoch_for_missing_or_corrupt_files() {
+        let dir = std::env::temp_dir().join(format!("alloy-outbox-test-{}", std::process::id()));
+        let path = dir.join("control-outbox.json");
+        let _ = std::fs::remove_dir_all(&dir);
+
+        let fresh = load(&path);
+        assert_eq!(fresh.next_seq, 1);
+        assert!(!fresh.epoch.is_empty());
+
+        let mut file = fresh;
+        file.events.push_back(OutboxEvent {
+            seq: 1,
+            kind: "crash".to_string(),
+            at_unix_ms: 1,
+            data: serde_json::json!({"process_id": "p1"}),
+        });
+        file.next_seq = 2;
+        persist(&path, &file).unwrap();
+
+        let reloaded = load(&path);
+        assert_eq!(reloaded.epoch, file.epoch);
+        assert_eq!(reloaded.next_seq, 2);
+        assert_eq!(reloaded.events.len(), 1);
+
+        std::fs::write(&path, b"{not json").unwrap();
+        assert_eq!(load(&path).next_seq, 1);
+
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
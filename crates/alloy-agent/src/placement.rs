@@ -0,0 +1,256 @@
+use std::collections::BTreeMap;
+
+// Would a new instance fit on this node? Compares the requested RAM, disk and CPU with what the
+// host has and what existing instances already claim: their heap (`memory_mb`, the -Xmx of
+// Minecraft servers) or sandbox memory limit, whichever is larger, and their sandbox CPU quota.
+// A panel with several nodes asks each one and places the instance on the best score.
+//
+// Memory and disk are hard limits. CPU quotas are routinely overcommitted, so running past the
+// node's cores only lowers the score.
+
+const MEMORY_RESERVE_MB_DEFAULT: u64 = 1024;
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Demand {
+    pub memory_mb: u64,
+    pub disk_mb: u64,
+    pub cpu_millicores: u64,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Capacity {
+    // Host memory minus the reserve kept for the OS and the agent.
+    pub memory_budget_mb: u64,
+    // What the kernel reports as available right now.
+    pub memory_available_mb: u64,
+    pub memory_committed_mb: u64,
+    pub cpu_millicores: u64,
+    pub cpu_committed_millicores: u64,
+    // Free space under the data root minus ALLOY_MIN_FREE_SPACE_BYTES.
+    pub disk_usable_mb: u64,
+    pub instances: u32,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Recommendation {
+    pub fits: bool,
+    // 0-100, higher means more headroom left after placing the instance; 0 when it doesn't fit.
+    pub score: u32,
+    pub reasons: Vec<String>,
+}
+
+fn param_u64(params: &BTreeMap<String, String>, key: &str) -> u64 {
+    params
+        .get(key)
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .unwrap_or(0)
+}
+
+// What an existing instance claims: (memory MiB, CPU millicores).
+fn claimed(params: &BTreeMap<String, String>) -> (u64, u64) {
+    let memory = param_u64(params, "memory_mb").max(param_u64(params, "sandbox_memory_mb"));
+    (memory, param_u64(params, "sandbox_cpu_millicores"))
+}
+
+#[derive(serde::Deserialize)]
+struct InstanceJsonForPlacement {
+    #[serde(default)]
+    params: BTreeMap<String, String>,
+    // Golden images are never started, so they claim nothing.
+    #[serde(default)]
+    golden: bool,
+}
+
+fn meminfo_mb(meminfo: &str, key: &str) -> u64 {
+    meminfo
+        .lines()
+        .find_map(|line| {
+            let rest = line.strip_prefix(key)?.strip_prefix(':')?;
+            rest.split_whitespace().next()?.parse::<u64>().ok()
+        })
+        .map(|kb| kb / 1024)
+        .unwrap_or(0)
+}
+
+pub fn capacity() -> Capacity {
+    let mut cap = Capacity::default();
+
+    let meminfo = std::fs::read_to_string("/proc/meminfo").unwrap_or_default();
+    let reserve = std::env::var("ALLOY_PLACEMENT_MEMORY_RESERVE_MB")
+        .ok()
+        .and_then(|v| v.trim().parse::<u64>().ok())
+        .unwrap_or(MEMORY_RESERVE_MB_DEFAULT);
+    cap.memory_budget_mb = meminfo_mb(&meminfo, "MemTotal").saturating_sub(reserve);
+    cap.memory_available_mb = meminfo_mb(&meminfo, "MemAvailable");
+
+    cap.cpu_millicores = std::thread::available_parallelism()
+        .map(|n| n.get() as u64 * 1000)
+        .unwrap_or(0);
+
+    let root = crate::minecraft::data_root();
+    cap.disk_usable_mb = crate::process_manager::free_bytes(&root)
+        .unwrap_or(0)
+        .saturating_sub(crate::process_manager::min_free_space_bytes())
+        / (1024 * 1024);
+
+    if let Ok(rd) = std::fs::read_dir(root.join("instances")) {
+        for de in rd.flatten() {
+            let Some(inst) = std::fs::read(de.path().join("instance.json"))
+                .ok()
+                .and_then(|raw| serde_json::from_slice::<InstanceJsonForPlacement>(&raw).ok())
+            else {
+                continue;
+            };
+            if inst.golden {
+                continue;
+            }
+            let (memory, cpu) = claimed(&inst.params);
+            cap.memory_committed_mb += memory;
+            cap.cpu_committed_millicores += cpu;
+            cap.instances += 1;
+        }
+    }
+    cap
+}
+
+// Share of `total` still free after `used`, in 0..=1.
+fn headroom(total: u64, used: u64) -> f64 {
+    if total == 0 {
+        return 0.0;
+    }
+    (total.saturating_sub(used) as f64 / total as f64).clamp(0.0, 1.0)
+}
+
+pub fn evaluate(cap: &Capacity, want: Demand) -> Recommendation {
+    let mut fits = true;
+    let mut reasons = Vec::new();
+
+    let memory_after = cap.memory_committed_mb + want.memory_mb;
+    if cap.memory_budget_mb == 0 {
+        fits = false;
+        reasons.push("memory: cannot read host memory".to_string());
+    } else if memory_after > cap.memory_budget_mb {
+        fits = false;
+        reasons.push(format!(
+            "memory: {} MiB requested, but {} of {} MiB are already claimed by {} instance(s)",
+            want.memory_mb, cap.memory_committed_mb, cap.memory_budget_mb, cap.instances
+        ));
+    } else {
+        reasons.push(format!(
+            "memory: {} MiB of {} MiB claimed after placement",
+            memory_after, cap.memory_budget_mb
+        ));
+        if want.memory_mb > cap.memory_available_mb {
+            reasons.push(format!(
+                "memory: only {} MiB free right now; running instances may need to stop first",
+                cap.memory_available_mb
+            ));
+        }
+    }
+
+    if want.disk_mb > cap.disk_usable_mb {
+        fits = false;
+        reasons.push(format!(
+            "disk: {} MiB requested, {} MiB usable",
+            want.disk_mb, cap.disk_usable_mb
+        ));
+    } else {
+        reasons.push(format!(
+            "disk: {} MiB usable, {} MiB left after placement",
+            cap.disk_usable_mb,
+            cap.disk_usable_mb - want.disk_mb
+        ));
+    }
+
+    let cpu_after = cap.cpu_committed_millicores + want.cpu_millicores;
+    if cpu_after > cap.cpu_millicores {
+        reasons.push(format!(
+            "cpu: {}m of quotas on {}m of cores after placement (overcommitted)",
+            cpu_after, cap.cpu_millicores
+        ));
+    } else {
+        reasons.push(format!(
+            "cpu: {}m of {}m claimed after placement",
+            cpu_after, cap.cpu_millicores
+        ));
+    }
+
+    let score = if fits {
+        let memory = headroom(cap.memory_budget_mb, memory_after);
+        let disk = headroom(cap.disk_usable_mb, want.disk_mb);
+        let cpu = headroom(cap.cpu_millicores, cpu_after);
+        ((memory * 0.5 + disk * 0.25 + cpu * 0.25) * 100.0).round() as u32
+    } else {
+        0
+    };
+    Recommendation {
+        fits,
+        score,
+        reasons,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn scores_by_headroom_and_rejects_overcommitted_memory() {
+        let meminfo = "MemTotal:       16777216 kB\nMemAvailable:    4194304 kB\n";
+        assert_eq!(meminfo_mb(meminfo, "MemTotal"), 16384);
+        assert_eq!(meminfo_mb(meminfo, "MemAvailable"), 4096);
+
+        let params = BTreeMap::from([
+            ("memory_mb".to_string(), "4096".to_string()),
+            ("sandbox_memory_mb".to_string(), "6144".to_string()),
+            ("sandbox_cpu_millicores".to_string(), "2000".to_string()),
+        ]);
+        assert_eq!(claimed(&params), (6144, 2000));
+
+        let cap = Capacity {
+            memory_budget_mb: 16384,
+            memory_available_mb: 4096,
+            memory_committed_mb: 8192,
+            cpu_millicores: 4000,
+            cpu_committed_millicores: 2000,
+            disk_usable_mb: 10240,
+            instances: 2,
+        };
+        let small = evaluate(
+            &cap,
+            Demand {
+                memory_mb: 2048,
+                disk_mb: 1024,
+                cpu_millicores: 1000,
+            },
+        );
+        assert!(small.fits);
+        let large = evaluate(
+            &cap,
+            Demand {
+                memory_mb: 6144,
+                disk_mb: 1024,
+                cpu_millicores: 4000,
+            },
+        );
+        assert!(large.fits);
+        assert!(large.score < small.score);
+        assert!(large.reasons.iter().any(|r| r.contains("overcommitted")));
+        assert!(
+            large
+                .reasons
+                .iter()
+                .any(|r| r.contains("only 4096 MiB free"))
+        );
+
+        let too_big = evaluate(
+            &cap,
+            Demand {
+                memory_mb: 9000,
+                ..Demand::default()
+            },
+        );
+        assert!(!too_big.fits);
+        assert_eq!(too_big.score, 0);
+    }
+}
@@ -0,0 +1,424 @@
+use std::{
+    collections::{BTreeMap, BTreeSet, HashMap},
+    sync::OnceLock,
+    time::Duration,
+};
+
+// Player milestones. Join/leave lines on the console topics of Minecraft instances are tracked per
+// instance, and the triggers set in the instance's params fire a notification:
+//
+// - `milestone_first_join_of_day` (true/false): the first player to join on a new day (host local
+//   time, see clock::timezone).
+// - `milestone_player_count` (N): the online count goes above N. Fires once, then again after the
+//   count has dropped back to N or below.
+// - `milestone_players` (comma-separated names): one of these players joins.
+//
+// Every milestone is published on `events:milestone` and queued in the outbox (so the panel's
+// audit log has it as `agent.milestone`); with `milestone_webhook_url` set it is also POSTed there
+// as JSON with a Discord/Slack-compatible `content`/`text` line. Params are read when an event
+// fires, so changes apply without a restart.
+
+pub(crate) const PARAM_WEBHOOK: &str = "milestone_webhook_url";
+pub(crate) const PARAM_FIRST_JOIN_OF_DAY: &str = "milestone_first_join_of_day";
+pub(crate) const PARAM_PLAYER_COUNT: &str = "milestone_player_count";
+pub(crate) const PARAM_PLAYERS: &str = "milestone_players";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Presence {
+    Joined(String),
+    Left(String),
+    // The server (re)started or is stopping: nobody is online.
+    Reset,
+}
+
+fn is_player_name(s: &str) -> bool {
+    (3..=16).contains(&s.len()) && s.chars().all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+// The message is what follows the first `]: ` (`[12:00:00 INFO]: ` on Paper, `[12:00:00]
+// [Server thread/INFO]: ` on vanilla), so chat text can't pose as a join: chat lines start with
+// `<name>`, which is not a valid player name.
+fn parse_line(line: &str) -> Option<Presence> {
+    let msg = line.split_once("]: ")?.1.trim();
+    if let Some(name) = msg.strip_suffix(" joined the game") {
+        return is_player_name(name).then(|| Presence::Joined(name.to_string()));
+    }
+    if let Some(name) = msg.strip_suffix(" left the game") {
+        return is_player_name(name).then(|| Presence::Left(name.to_string()));
+    }
+    if (msg.starts_with("Done (") && msg.contains("For help")) || msg == "Stopping server" {
+        return Some(Presence::Reset);
+    }
+    None
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+struct Triggers {
+    webhook: Option<String>,
+    first_join_of_day: bool,
+    player_count: Option<usize>,
+    // Lowercase.
+    players: BTreeSet<String>,
+}
+
+fn non_empty<'a>(params: &'a BTreeMap<String, String>, key: &str) -> Option<&'a str> {
+    params.get(key).map(|v| v.trim()).filter(|v| !v.is_empty())
+}
+
+fn triggers(params: &BTreeMap<String, String>) -> Result<Triggers, String> {
+    let webhook = match non_empty(params, PARAM_WEBHOOK) {
+        Some(url) if url.starts_with("https://") || url.starts_with("http://") => {
+            Some(url.to_string())
+        }
+        Some(_) => return Err(format!("{PARAM_WEBHOOK} must be an http(s) URL")),
+        None => None,
+    };
+    let first_join_of_day = match non_empty(params, PARAM_FIRST_JOIN_OF_DAY) {
+        None => false,
+        Some(v) => match v.to_ascii_lowercase().as_str() {
+            "1" | "true" | "yes" | "on" => true,
+            "0" | "false" | "no" | "off" => false,
+            _ => {
+                return Err(format!(
+                    "{PARAM_FIRST_JOIN_OF_DAY} must be true or false, got {v:?}"
+                ));
+            }
+        },
+    };
+    let player_count = non_empty(params, PARAM_PLAYER_COUNT)
+        .map(|v| {
+            v.parse::<usize>()
+                .map_err(|_| format!("{PARAM_PLAYER_COUNT} must be a number of players, got {v:?}"))
+        })
+        .transpose()?;
+    let mut players = BTreeSet::new();
+    for name in non_empty(params, PARAM_PLAYERS)
+        .unwrap_or_default()
+        .split(',')
+        .map(str::trim)
+        .filter(|n| !n.is_empty())
+    {
+        if !is_player_name(name) {
+            return Err(format!("{PARAM_PLAYERS}: invalid player name {name:?}"));
+        }
+        players.insert(name.to_ascii_lowercase());
+    }
+    Ok(Triggers {
+        webhook,
+        first_join_of_day,
+        player_count,
+        players,
+    })
+}
+
+// Validates the milestone params of an instance; used when instances are created or updated.
+pub(crate) fn validate_params(params: &BTreeMap<String, String>) -> Result<(), String> {
+    triggers(params).map(|_| ())
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Milestone {
+    FirstJoinOfDay { player: String },
+    PlayerCount { player: String, threshold: usize },
+    PlayerJoined { player: String },
+}
+
+impl Milestone {
+    fn kind(&self) -> &'static str {
+        match self {
+            Milestone::FirstJoinOfDay { .. } => "first_join_of_day",
+            Milestone::PlayerCount { .. } => "player_count",
+            Milestone::PlayerJoined { .. } => "player_joined",
+        }
+    }
+
+    fn player(&self) -> &str {
+        match self {
+            Milestone::FirstJoinOfDay { player }
+            | Milestone::PlayerCount { player, .. }
+            | Milestone::PlayerJoined { player } => player,
+        }
+    }
+
+    fn message(&self, instance: &str, online: usize) -> String {
+        match self {
+            Milestone::FirstJoinOfDay { player } => {
+                format!("{player} is the first player on {instance} today")
+            }
+            Milestone::PlayerCount { player, threshold } => {
+                format!("{online} players online on {instance} (over {threshold}); {player} joined")
+            }
+            Milestone::PlayerJoined { player } => format!("{player} joined {instance}"),
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+struct InstanceState {
+    // Lowercase names.
+    online: BTreeSet<String>,
+    // Local day (days since the epoch) of the last join.
+    last_join_day: Option<i64>,
+    // The player count trigger fired and hasn't re-armed yet.
+    count_fired: bool,
+}
+
+// Updates `state` and returns the milestones `presence` reaches. State is tracked whether or not
+// any trigger is set, so enabling one mid-session starts from the right count.
+fn observe(
+    state: &mut InstanceState,
+    triggers: &Triggers,
+    presence: &Presence,
+    day: i64,
+) -> Vec<Milestone> {
+    let mut out = Vec::new();
+    match presence {
+        Presence::Joined(name) => {
+            state.online.insert(name.to_ascii_lowercase());
+            let first_today = state.last_join_day != Some(day);
+            state.last_join_day = Some(day);
+            if triggers.first_join_of_day && first_today {
+                out.push(Milestone::FirstJoinOfDay {
+                    player: name.clone(),
+                });
+            }
+            if let Some(threshold) = triggers.player_count
+                && state.online.len() > threshold
+                && !state.count_fired
+            {
+                state.count_fired = true;
+                out.push(Milestone::PlayerCount {
+                    player: name.clone(),
+                    threshold,
+                });
+            }
+            if triggers.players.contains(&name.to_ascii_lowercase()) {
+                out.push(Milestone::PlayerJoined {
+                    player: name.clone(),
+                });
+            }
+        }
+        Presence::Left(name) => {
+            state.online.remove(&name.to_ascii_lowercase());
+        }
+        Presence::Reset => state.online.clear(),
+    }
+    if triggers
+        .player_count
+        .is_none_or(|threshold| state.online.len() <= threshold)
+    {
+        state.count_fired = false;
+    }
+    out
+}
+
+fn local_day(now_ms: u64) -> i64 {
+    let (_, offset_secs) = crate::clock::timezone();
+    ((now_ms / 1000) as i64 + i64::from(offset_secs)).div_euclid(86_400)
+}
+
+#[derive(serde::Deserialize)]
+struct InstanceJsonForMilestones {
+    template_id: String,
+    #[serde(default)]
+    params: BTreeMap<String, String>,
+}
+
+// Params of a Minecraft instance; None for other templates and unknown ids.
+fn minecraft_params(instance_id: &str) -> Option<BTreeMap<String, String>> {
+    let path = crate::minecraft::data_root()
+        .join("instances")
+        .join(instance_id)
+        .join("instance.json");
+    let raw = std::fs::read(path).ok()?;
+    let inst = serde_json::from_slice::<InstanceJsonForMilestones>(&raw).ok()?;
+    inst.template_id
+        .starts_with("minecraft:")
+        .then_some(inst.params)
+}
+
+fn http_client() -> &'static reqwest::Client {
+    static CLIENT: OnceLock<reqwest::Client> = OnceLock::new();
+    CLIENT.get_or_init(|| {
+        reqwest::Client::builder()
+            .user_agent("alloy-agent")
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to build reqwest client")
+    })
+}
+
+// One retry; a webhook that is down loses the notification (the outbox still has it).
+async fn post_webhook(url: String, body: serde_json::Value) {
+    let mut last_err = String::new();
+    for attempt in 0..2 {
+        if attempt > 0 {
+            tokio::time::sleep(Duration::from_secs(5)).await;
+        }
+        match http_client().post(&url).json(&body).send().await {
+            Ok(resp) if resp.status().is_success() => return,
+            Ok(resp) => last_err = format!("HTTP {}", resp.status()),
+            Err(e) => last_err = e.without_url().to_string(),
+        }
+    }
+    // The URL usually carries a token; only the host is logged.
+    let host = reqwest::Url::parse(&url)
+        .ok()
+        .and_then(|u| u.host_str().map(str::to_string))
+        .unwrap_or_default();
+    tracing::warn!(
+        host = %host,
+        error = %last_err,
+        "milestone webhook failed"
+    );
+}
+
+fn notify(instance_id: &str, triggers: &Triggers, milestone: &Milestone, online: usize) {
+    let message = milestone.message(instance_id, online);
+    let mut event = serde_json::json!({
+        "instance_id": instance_id,
+        "milestone": milestone.kind(),
+        "player": milestone.player(),
+        "online": online,
+        "message": message,
+        "at_unix_ms": crate::console_audit::now_unix_ms(),
+    });
+    if let Milestone::PlayerCount { threshold, .. } = milestone {
+        event["threshold"] = (*threshold).into();
+    }
+    tracing::info!(
+        instance_id = %instance_id,
+        milestone = milestone.kind(),
+        player = %milestone.player(),
+        "player milestone"
+    );
+    crate::topics::publish("events:milestone", || event.clone());
+    crate::outbox::push("milestone", event.clone());
+    if let Some(url) = triggers.webhook.clone() {
+        event["content"] = message.clone().into();
+        event["text"] = message.into();
+        tokio::spawn(post_webhook(url, event));
+    }
+}
+
+pub fn spawn() {
+    let mut sub = match crate::topics::subscribe("console:*", None) {
+        Ok(sub) => sub,
+        Err(e) => {
+            tracing::warn!(error = %e, "player milestones disabled");
+            return;
+        }
+    };
+    tokio::spawn(async move {
+        let mut states: HashMap<String, InstanceState> = HashMap::new();
+        while let Some(ev) = sub.recv().await {
+            let Some(instance_id) = ev.topic.strip_prefix("console:") else {
+                continue;
+            };
+            let Some(presence) = ev.data.as_str().and_then(parse_line) else {
+                continue;
+            };
+            let Some(params) = minecraft_params(instance_id) else {
+                continue;
+            };
+            let triggers = match triggers(&params) {
+                Ok(t) => t,
+                Err(e) => {
+                    tracing::warn!(
+                        instance_id = %instance_id,
+                        error = %e,
+                        "invalid milestone params"
+                    );
+                    Triggers::default()
+                }
+            };
+            let state = states.entry(instance_id.to_string()).or_default();
+            let day = local_day(crate::console_audit::now_unix_ms());
+            for milestone in observe(state, &triggers, &presence, day) {
+                notify(instance_id, &triggers, &milestone, state.online.len());
+            }
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn params(pairs: &[(&str, &str)]) -> BTreeMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    fn joined(name: &str) -> Presence {
+        Presence::Joined(name.to_string())
+    }
+
+    #[test]
+    fn parses_presence_and_fires_triggers() {
+        assert_eq!(
+            parse_line("[12:00:01 INFO]: Steve joined the game"),
+            Some(joined("Steve"))
+        );
+        assert_eq!(
+            parse_line("[12:00:01] [Server thread/INFO]: Alex_2 left the game"),
+            Some(Presence::Left("Alex_2".to_string()))
+        );
+        assert_eq!(
+            parse_line("[12:00:01 INFO]: <Bob> x]: Steve joined the game"),
+            None
+        );
+        assert_eq!(parse_line("[12:00:01 INFO]: <Bob> hi"), None);
+        assert_eq!(
+            parse_line("[12:00:00 INFO]: Done (3.2s)! For help, type \"help\""),
+            Some(Presence::Reset)
+        );
+
+        assert!(validate_params(&params(&[(PARAM_WEBHOOK, "ftp://x")])).is_err());
+        assert!(validate_params(&params(&[(PARAM_PLAYER_COUNT, "many")])).is_err());
+        assert!(validate_params(&params(&[(PARAM_PLAYERS, "Steve, a b")])).is_err());
+        let t = triggers(&params(&[
+            (PARAM_FIRST_JOIN_OF_DAY, "true"),
+            (PARAM_PLAYER_COUNT, "1"),
+            (PARAM_PLAYERS, "Notch, steve"),
+        ]))
+        .unwrap();
+
+        let mut state = InstanceState::default();
+        assert_eq!(
+            observe(&mut state, &t, &joined("Steve"), 10),
+            [
+                Milestone::FirstJoinOfDay {
+                    player: "Steve".to_string()
+                },
+                Milestone::PlayerJoined {
+                    player: "Steve".to_string()
+                },
+            ]
+        );
+        assert_eq!(
+            observe(&mut state, &t, &joined("Alex"), 10),
+            [Milestone::PlayerCount {
+                player: "Alex".to_string(),
+                threshold: 1
+            }]
+        );
+        // Still above the threshold: no repeat.
+        assert!(observe(&mut state, &t, &joined("Herobrine"), 10).is_empty());
+        observe(&mut state, &t, &Presence::Left("Alex".to_string()), 10);
+        observe(&mut state, &t, &Presence::Left("Herobrine".to_string()), 10);
+        // Re-armed once the count dropped back to the threshold.
+        assert_eq!(observe(&mut state, &t, &joined("Alex"), 10).len(), 1);
+
+        observe(&mut state, &t, &Presence::Reset, 10);
+        assert!(state.online.is_empty());
+        assert_eq!(
+            observe(&mut state, &t, &joined("Alex"), 11),
+            [Milestone::FirstJoinOfDay {
+                player: "Alex".to_string()
+            }]
+        );
+    }
+}
@@ -1,19 +1,42 @@
 use std::{
+    collections::{BTreeMap, HashMap},
     io::ErrorKind,
-    net::{TcpListener, UdpSocket},
+    net::{IpAddr, TcpListener, UdpSocket},
+    path::{Path, PathBuf},
+    sync::{
+        Mutex, OnceLock,
+        atomic::{AtomicU64, Ordering},
+    },
 };
 
 use anyhow::Context;
 
+use crate::error_payload::{Localized, MessageKey};
+
+fn in_use_error(proto: PortProto, port: u16) -> anyhow::Error {
+    match holder(proto, port) {
+        Some(owner) => anyhow::anyhow!(
+            "port already in use: {port} (held by instance {}, {})",
+            owner.instance_id,
+            owner.purpose
+        ),
+        None => anyhow::anyhow!("port already in use: {port}"),
+    }
+}
+
 pub fn allocate_tcp_port(preferred: u16) -> anyhow::Result<u16> {
+    allocate_tcp_port_on(None, preferred)
+}
+
+// As allocate_tcp_port for a server bound to `bind` (e.g. its `server-ip`). Without one, the port
+// has to be free on both the IPv4 and the IPv6 wildcard.
+pub fn allocate_tcp_port_on(bind: Option<IpAddr>, preferred: u16) -> anyhow::Result<u16> {
     if preferred != 0 {
         // Validate availability.
-        match TcpListener::bind(("0.0.0.0", preferred)) {
-            Ok(l) => {
-                l.set_nonblocking(true).ok();
-            }
+        match crate::netaddr::check_tcp(bind, preferred) {
+            Ok(()) => {}
             Err(e) if e.kind() == ErrorKind::AddrInUse => {
-                anyhow::bail!("port already in use: {preferred}");
+                return Err(in_use_error(PortProto::Tcp, preferred));
             }
             Err(e) => {
                 return Err(e).context(format!("bind port {preferred}"));
@@ -22,20 +45,27 @@ pub fn allocate_tcp_port(preferred: u16) -> anyhow::Result<u16> {
         return Ok(preferred);
     }
 
-    // Ask OS for an ephemeral port.
-    let listener = TcpListener::bind(("0.0.0.0", 0))?;
-    let port = listener.local_addr()?.port();
-    Ok(port)
+    // Ask OS for an ephemeral port, then make sure the other family has it free too.
+    for _ in 0..16 {
+        let listener = TcpListener::bind((
+            bind.unwrap_or(IpAddr::V4(std::net::Ipv4Addr::UNSPECIFIED)),
+            0,
+        ))?;
+        let port = listener.local_addr()?.port();
+        drop(listener);
+        if crate::netaddr::check_tcp(bind, port).is_ok() {
+            return Ok(port);
+        }
+    }
+    anyhow::bail!("no free tcp port available")
 }
 
 pub fn allocate_udp_port(preferred: u16) -> anyhow::Result<u16> {
     if preferred != 0 {
-        match UdpSocket::bind(("0.0.0.0", preferred)) {
-            Ok(s) => {
-                s.set_nonblocking(true).ok();
-            }
+        match crate::netaddr::check_udp(None, preferred) {
+            Ok(()) => {}
             Err(e) if e.kind() == ErrorKind::AddrInUse => {
-                anyhow::bail!("port already in use: {preferred}");
+                return Err(in_use_error(PortProto::Udp, preferred));
             }
             Err(e) => {
                 return Err(e).context(format!("bind port {preferred}"));
@@ -44,7 +74,300 @@ pub fn allocate_udp_port(preferred: u16) -> anyhow::Result<u16> {
         return Ok(preferred);
     }
 
-    let sock = UdpSocket::bind(("0.0.0.0", 0))?;
-    let port = sock.local_addr()?.port();
-    Ok(port)
+    for _ in 0..16 {
+        let sock = UdpSocket::bind(("0.0.0.0", 0))?;
+        let port = sock.local_addr()?.port();
+        drop(sock);
+        if crate::netaddr::check_udp(None, port).is_ok() {
+            return Ok(port);
+        }
+    }
+    anyhow::bail!("no free udp port available")
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum PortProto {
+    Tcp,
+    Udp,
+}
+
+impl PortProto {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            PortProto::Tcp => "tcp",
+            PortProto::Udp => "udp",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PortOwner {
+    pub instance_id: String,
+    pub purpose: String,
+}
+
+struct Reservation {
+    owner: PortOwner,
+    lease_id: u64,
+}
+
+// (proto, port, bind address); None is every address.
+type Key = (PortProto, u16, Option<IpAddr>);
+
+// Ports held by running instances and their frpc sidecars. Game servers and tunnels reserve their
+// listeners here so neither side hands out a port the other is using. Servers pinned to different
+// addresses (see bind_pool) can share a port; a reservation without an address covers them all.
+fn registry() -> &'static Mutex<HashMap<Key, Reservation>> {
+    static REGISTRY: OnceLock<Mutex<HashMap<Key, Reservation>>> = OnceLock::new();
+    REGISTRY.get_or_init(|| Mutex::new(HashMap::new()))
+}
+
+fn overlaps(key: &Key, proto: PortProto, port: u16, bind: Option<IpAddr>) -> bool {
+    key.0 == proto && key.1 == port && (key.2.is_none() || bind.is_none() || key.2 == bind)
+}
+
+static NEXT_LEASE_ID: AtomicU64 = AtomicU64::new(1);
+
+// Releases the reservation when dropped. A restart of the same instance may take over the port
+// before the previous run lets go, so only the newest lease removes the entry.
+#[derive(Debug)]
+pub struct PortLease {
+    key: Key,
+    lease_id: u64,
+}
+
+impl Drop for PortLease {
+    fn drop(&mut self) {
+        let mut reg = registry().lock().unwrap_or_else(|e| e.into_inner());
+        if reg
+            .get(&self.key)
+            .is_some_and(|r| r.lease_id == self.lease_id)
+        {
+            reg.remove(&self.key);
+        }
+    }
+}
+
+pub fn conflict_error(proto: PortProto, port: u16, owner: &PortOwner) -> anyhow::Error {
+    let msg = format!(
+        "port {port}/{} is reserved by instance {} ({})",
+        proto.as_str(),
+        owner.instance_id,
+        owner.purpose
+    );
+    let mut fields = BTreeMap::new();
+    fields.insert("port".to_string(), msg.clone());
+    let key = MessageKey::new("port.conflict")
+        .param("port", port)
+        .param("proto", proto.as_str())
+        .param("instance", &owner.instance_id)
+        .param("purpose", &owner.purpose);
+    crate::error_payload::anyhow_localized(
+        "port_conflict",
+        Localized::new(key, msg),
+        Some(fields),
+        Some(Localized::new(
+            MessageKey::new("hint.port.change_owner").param("instance", &owner.instance_id),
+            format!(
+                "Pick another port, or change the port of instance {}.",
+                owner.instance_id
+            ),
+        )),
+    )
+}
+
+// A requested port (`field`) that could not be bound, e.g. because something else listens on it.
+pub fn unavailable_error(
+    field: &str,
+    proto: PortProto,
+    port: u16,
+    err: &anyhow::Error,
+) -> anyhow::Error {
+    let mut fields = BTreeMap::new();
+    fields.insert(field.to_string(), err.to_string());
+    let mut key = MessageKey::new("port.unavailable")
+        .param("field", field)
+        .param("port", port)
+        .param("proto", proto.as_str());
+    if let Some(owner) = holder(proto, port) {
+        key = key.param("instance", owner.instance_id);
+    }
+    crate::error_payload::anyhow_localized(
+        "invalid_param",
+        Localized::new(key, format!("invalid {field}")),
+        Some(fields),
+        Some(Localized::new(
+            MessageKey::new("hint.port.pick_another"),
+            "Pick another port, or leave it blank (0) to auto-assign a free port.",
+        )),
+    )
+}
+
+// Current runtime holder of a port on any address, if any.
+pub fn holder(proto: PortProto, port: u16) -> Option<PortOwner> {
+    let reg = registry().lock().unwrap_or_else(|e| e.into_inner());
+    reg.iter()
+        .find(|(k, _)| overlaps(k, proto, port, None))
+        .map(|(_, r)| r.owner.clone())
+}
+
+// Reserves `port` on every address for `instance_id`. Fails when a different instance holds it.
+pub fn lease(
+    proto: PortProto,
+    port: u16,
+    instance_id: &str,
+    purpose: &str,
+) -> anyhow::Result<PortLease> {
+    lease_on(proto, None, port, instance_id, purpose)
+}
+
+// As lease, for a listener bound to `bind` only.
+pub fn lease_on(
+    proto: PortProto,
+    bind: Option<IpAddr>,
+    port: u16,
+    instance_id: &str,
+    purpose: &str,
+) -> anyhow::Result<PortLease> {
+    let mut reg = registry().lock().unwrap_or_else(|e| e.into_inner());
+    if let Some((_, r)) = reg
+        .iter()
+        .find(|(k, r)| overlaps(k, proto, port, bind) && r.owner.instance_id != instance_id)
+    {
+        return Err(conflict_error(proto, port, &r.owner));
+    }
+    // A restart of the same instance replaces its own reservations.
+    reg.retain(|k, r| !(overlaps(k, proto, port, bind) && r.owner.instance_id == instance_id));
+    let lease_id = NEXT_LEASE_ID.fetch_add(1, Ordering::Relaxed);
+    reg.insert(
+        (proto, port, bind),
+        Reservation {
+            owner: PortOwner {
+                instance_id: instance_id.to_string(),
+                purpose: purpose.to_string(),
+            },
+            lease_id,
+        },
+    );
+    Ok(PortLease {
+        key: (proto, port, bind),
+        lease_id,
+    })
+}
+
+// Ports saved in instance.json. Stopped instances keep these, so new allocations avoid them too.
+fn persisted_ports(instances_root: &Path) -> Vec<(PortProto, u16, PortOwner)> {
+    let mut out = Vec::new();
+    let Ok(rd) = std::fs::read_dir(instances_root) else {
+        return out;
+    };
+    for de in rd.flatten() {
+        let Ok(raw) = std::fs::read(de.path().join("instance.json")) else {
+            continue;
+        };
+        let Ok(v) = serde_json::from_slice::<serde_json::Value>(&raw) else {
+            continue;
+        };
+        let instance_id = de.file_name().to_string_lossy().to_string();
+        let template_id = v.get("template_id").and_then(|t| t.as_str()).unwrap_or("");
+        let proto = if template_id.starts_with("dst:") {
+            PortProto::Udp
+        } else {
+            PortProto::Tcp
+        };
+        for key in ["port", "master_port", "auth_port"] {
+            let Some(port) = v
+                .get("params")
+                .and_then(|p| p.get(key))
+                .and_then(|p| p.as_str())
+                .and_then(|p| p.trim().parse::<u16>().ok())
+                .filter(|p| *p != 0)
+            else {
+                continue;
+            };
+            out.push((
+                proto,
+                port,
+                PortOwner {
+                    instance_id: instance_id.clone(),
+                    purpose: format!("{template_id} {key}"),
+                },
+            ));
+        }
+        if let Some(marker) = crate::minecraft_webmap::read_marker(&de.path())
+            && marker.port != 0
+        {
+            out.push((
+                PortProto::Tcp,
+                marker.port,
+                PortOwner {
+                    instance_id: instance_id.clone(),
+                    purpose: format!("{} web map", marker.kind),
+                },
+            ));
+        }
+    }
+    out
+}
+
+fn instances_root() -> PathBuf {
+    crate::minecraft::data_root().join("instances")
+}
+
+// Finds another instance that holds `port` right now or has it saved in its config.
+pub fn reserved_by_other(proto: PortProto, port: u16, instance_id: &str) -> Option<PortOwner> {
+    if let Some(owner) = holder(proto, port)
+        && owner.instance_id != instance_id
+    {
+        return Some(owner);
+    }
+    persisted_ports(&instances_root())
+        .into_iter()
+        .find(|(p, n, o)| *p == proto && *n == port && o.instance_id != instance_id)
+        .map(|(_, _, o)| o)
+}
+
+// Picks a free port that no other instance has reserved.
+pub fn allocate_unreserved_port(proto: PortProto, instance_id: &str) -> anyhow::Result<u16> {
+    for _ in 0..16 {
+        let port = match proto {
+            PortProto::Tcp => allocate_tcp_port(0)?,
+            PortProto::Udp => allocate_udp_port(0)?,
+        };
+        if port != 0 && reserved_by_other(proto, port, instance_id).is_none() {
+            return Ok(port);
+        }
+    }
+    anyhow::bail!("no unreserved {} port available", proto.as_str())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn lease_rejects_other_instances_until_released() {
+        let a = lease(PortProto::Tcp, 1, "a", "minecraft:vanilla port").unwrap();
+        let err = lease(PortProto::Tcp, 1, "b", "frpc admin api").unwrap_err();
+        assert!(err.to_string().contains("reserved by instance a"));
+        assert!(lease(PortProto::Udp, 1, "b", "dst:vanilla port").is_ok());
+
+        // A restart of the same instance takes over; the old lease must not release it.
+        let a2 = lease(PortProto::Tcp, 1, "a", "minecraft:vanilla port").unwrap();
+        drop(a);
+        assert_eq!(holder(PortProto::Tcp, 1).unwrap().instance_id, "a");
+        drop(a2);
+        assert!(holder(PortProto::Tcp, 1).is_none());
+        assert!(lease(PortProto::Tcp, 1, "b", "frpc admin api").is_ok());
+
+        // Instances pinned to different addresses share a port; an unpinned one conflicts.
+        let (x, y) = (
+            Some("192.0.2.10".parse().unwrap()),
+            Some("192.0.2.11".parse().unwrap()),
+        );
+        let _c = lease_on(PortProto::Tcp, x, 2, "c", "minecraft:vanilla port").unwrap();
+        let _d = lease_on(PortProto::Tcp, y, 2, "d", "minecraft:vanilla port").unwrap();
+        assert!(lease_on(PortProto::Tcp, x, 2, "e", "minecraft:vanilla port").is_err());
+        assert!(lease(PortProto::Tcp, 2, "e", "minecraft:vanilla port").is_err());
+    }
 }
@@ -0,0 +1,322 @@
+use std::{
+    path::{Path, PathBuf},
+    time::{Duration, SystemTime},
+};
+
+// How many console lines (from the current run) are scanned when classifying an exit.
+pub(crate) const EXIT_LOG_SCAN_LINES: usize = 200;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum ExitCategory {
+    Stopped,
+    Exited,
+    EarlyExit,
+    Oom,
+    JvmCrash,
+    PortInUse,
+    MissingClass,
+    Watchdog,
+    Crashed,
+}
+
+impl ExitCategory {
+    pub(crate) fn as_str(self) -> &'static str {
+        match self {
+            ExitCategory::Stopped => "stopped",
+            ExitCategory::Exited => "exited",
+            ExitCategory::EarlyExit => "early_exit",
+            ExitCategory::Oom => "oom",
+            ExitCategory::JvmCrash => "jvm_crash",
+            ExitCategory::PortInUse => "port_in_use",
+            ExitCategory::MissingClass => "missing_class",
+            ExitCategory::Watchdog => "watchdog",
+            ExitCategory::Crashed => "crashed",
+        }
+    }
+
+    pub(crate) fn is_failure(self) -> bool {
+        !matches!(self, ExitCategory::Stopped | ExitCategory::Exited)
+    }
+}
+
+#[derive(Debug, Clone)]
+pub(crate) struct ExitClassification {
+    pub(crate) category: ExitCategory,
+    pub(crate) reason: String,
+}
+
+#[derive(Debug, Default)]
+pub(crate) struct ExitFacts<'a> {
+    pub(crate) code: Option<i32>,
+    pub(crate) signal: Option<i32>,
+    pub(crate) stopping: bool,
+    // Set when the agent itself gave up on the process (e.g. the port probe timed out).
+    pub(crate) watchdog: Option<&'a str>,
+    pub(crate) oom_reason: Option<&'a str>,
+    pub(crate) runtime: Duration,
+    pub(crate) early_exit_threshold: Duration,
+    pub(crate) log_tail: &'a [String],
+    pub(crate) hs_err: Option<&'a Path>,
+}
+
+#[cfg(unix)]
+pub(crate) fn exit_signal(status: &std::process::ExitStatus) -> Option<i32> {
+    use std::os::unix::process::ExitStatusExt;
+    status.signal()
+}
+
+#[cfg(not(unix))]
+pub(crate) fn exit_signal(_status: &std::process::ExitStatus) -> Option<i32> {
+    None
+}
+
+fn signal_name(sig: i32) -> Option<&'static str> {
+    Some(match sig {
+        1 => "SIGHUP",
+        2 => "SIGINT",
+        3 => "SIGQUIT",
+        4 => "SIGILL",
+        6 => "SIGABRT",
+        7 => "SIGBUS",
+        8 => "SIGFPE",
+        9 => "SIGKILL",
+        11 => "SIGSEGV",
+        13 => "SIGPIPE",
+        15 => "SIGTERM",
+        _ => return None,
+    })
+}
+
+fn describe_signal(sig: i32) -> String {
+    match signal_name(sig) {
+        Some(name) => format!("killed by signal {sig} ({name})"),
+        None => format!("killed by signal {sig}"),
+    }
+}
+
+// Scans the newest lines first so the most recent failure wins.
+fn classify_log_tail(lines: &[String]) -> Option<ExitClassification> {
+    for line in lines.iter().rev() {
+        let lower = line.to_ascii_lowercase();
+
+        if lower.contains("failed to bind to port")
+            || lower.contains("address already in use")
+            || lower.contains("java.net.bindexception")
+        {
+            return Some(ExitClassification {
+                category: ExitCategory::PortInUse,
+                reason: "port is already in use (another server may be running)".to_string(),
+            });
+        }
+
+        if lower.contains("java.lang.outofmemoryerror") {
+            return Some(ExitClassification {
+                category: ExitCategory::Oom,
+                reason: "Java heap exhausted (OutOfMemoryError); raise memory_mb".to_string(),
+            });
+        }
+
+        if lower.contains("could not find or load main class")
+            || lower.contains("no main manifest attribute")
+            || lower.contains("unable to access jarfile")
+        {
+            return Some(ExitClassification {
+                category: ExitCategory::MissingClass,
+                reason: format!("missing main class or jar: {}", line.trim()),
+            });
+        }
+        if lower.contains("java.lang.classnotfoundexception")
+            || lower.contains("java.lang.noclassdeffounderror")
+        {
+            return Some(ExitClassification {
+                category: ExitCategory::MissingClass,
+                reason: format!("missing class: {}", line.trim()),
+            });
+        }
+
+        if lower.contains("a fatal error has been detected by the java runtime environment") {
+            return Some(ExitClassification {
+                category: ExitCategory::JvmCrash,
+                reason: "JVM crashed (fatal error in the Java runtime)".to_string(),
+            });
+        }
+    }
+    None
+}
+
+pub(crate) fn classify(f: &ExitFacts<'_>) -> ExitClassification {
+    if f.stopping {
+        return ExitClassification {
+            category: ExitCategory::Stopped,
+            reason: "stopped".to_string(),
+        };
+    }
+
+    if let Some(msg) = f.watchdog {
+        return ExitClassification {
+            category: ExitCategory::Watchdog,
+            reason: format!("killed by watchdog: {msg}"),
+        };
+    }
+
+    if let Some(reason) = f.oom_reason {
+        return ExitClassification {
+            category: ExitCategory::Oom,
+            reason: format!("killed by the kernel OOM killer ({reason})"),
+        };
+    }
+
+    let success = f.signal.is_none() && f.code == Some(0);
+
+    if let Some(path) = f.hs_err
+        && !success
+    {
+        return ExitClassification {
+            category: ExitCategory::JvmCrash,
+            reason: format!("JVM crashed; see {}", path.display()),
+        };
+    }
+
+    // A clean exit (e.g. an in-game /stop) is not a failure, whatever harmless errors plugins
+    // logged before it.
+    if !success && let Some(c) = classify_log_tail(f.log_tail) {
+        return c;
+    }
+
+    if f.runtime < f.early_exit_threshold {
+        return ExitClassification {
+            category: ExitCategory::EarlyExit,
+            reason: format!("exited too quickly ({}ms)", f.runtime.as_millis()),
+        };
+    }
+
+    if success {
+        return ExitClassification {
+            category: ExitCategory::Exited,
+            reason: "exited".to_string(),
+        };
+    }
+
+    ExitClassification {
+        category: ExitCategory::Crashed,
+        reason: match (f.signal, f.code) {
+            (Some(sig), _) => describe_signal(sig),
+            (None, Some(code)) => format!("exited with code {code}"),
+            (None, None) => "exited abnormally".to_string(),
+        },
+    }
+}
+
+// The JVM writes hs_err_pid<pid>.log into its working directory when it crashes. The pid seen by
+// the agent may differ (docker, wrapper scripts), so pick the newest report written during this run.
+pub(crate) async fn find_hs_err_file(dir: &Path, since: SystemTime) -> Option<PathBuf> {
+    let mut rd = tokio::fs::read_dir(dir).await.ok()?;
+    let mut best: Option<(SystemTime, PathBuf)> = None;
+    while let Ok(Some(de)) = rd.next_entry().await {
+        let name = de.file_name();
+        let name = name.to_string_lossy();
+        if !(name.starts_with("hs_err_pid") && name.ends_with(".log")) {
+            continue;
+        }
+        let Ok(modified) = de.metadata().await.and_then(|m| m.modified()) else {
+            continue;
+        };
+        if modified < since {
+            continue;
+        }
+        if best.as_ref().is_none_or(|(t, _)| modified > *t) {
+            best = Some((modified, de.path()));
+        }
+    }
+    best.map(|(_, p)| p)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn facts<'a>(code: Option<i32>, log_tail: &'a [String]) -> ExitFacts<'a> {
+        ExitFacts {
+            code,
+            runtime: Duration::from_secs(60),
+            early_exit_threshold: Duration::from_secs(3),
+            log_tail,
+            ..Default::default()
+        }
+    }
+
+    fn lines(v: &[&str]) -> Vec<String> {
+        v.iter().map(|s| s.to_string()).collect()
+    }
+
+    #[test]
+    fn classify_prefers_explicit_signals_over_logs() {
+        let tail = lines(&["java.lang.OutOfMemoryError: Java heap space"]);
+
+        let mut f = facts(Some(143), &tail);
+        f.stopping = true;
+        assert_eq!(classify(&f).category, ExitCategory::Stopped);
+
+        let mut f = facts(Some(143), &tail);
+        f.watchdog = Some("port 25565 did not open within 1000ms");
+        assert_eq!(classify(&f).category, ExitCategory::Watchdog);
+
+        let mut f = facts(None, &tail);
+        f.signal = Some(9);
+        f.oom_reason = Some("host memory exhausted");
+        let c = classify(&f);
+        assert_eq!(c.category, ExitCategory::Oom);
+        assert!(c.reason.contains("host memory exhausted"));
+
+        let f = facts(Some(1), &tail);
+        assert_eq!(classify(&f).category, ExitCategory::Oom);
+    }
+
+    #[test]
+    fn classify_recognizes_common_console_failures() {
+        let tail = lines(&[
+            "[Server thread/WARN]: **** FAILED TO BIND TO PORT!",
+            "[Server thread/WARN]: Perhaps a server is already running on that port?",
+        ]);
+        assert_eq!(
+            classify(&facts(Some(1), &tail)).category,
+            ExitCategory::PortInUse
+        );
+        assert_eq!(
+            classify(&facts(Some(0), &tail)).category,
+            ExitCategory::Exited
+        );
+
+        let tail = lines(&["Error: Could not find or load main class net.minecraft.Main"]);
+        assert_eq!(
+            classify(&facts(Some(1), &tail)).category,
+            ExitCategory::MissingClass
+        );
+
+        let tail = lines(&["# A fatal error has been detected by the Java Runtime Environment:"]);
+        assert_eq!(
+            classify(&facts(Some(134), &tail)).category,
+            ExitCategory::JvmCrash
+        );
+    }
+
+    #[test]
+    fn classify_falls_back_to_exit_status() {
+        assert_eq!(
+            classify(&facts(Some(0), &[])).category,
+            ExitCategory::Exited
+        );
+
+        let c = classify(&facts(Some(2), &[]));
+        assert_eq!(c.category, ExitCategory::Crashed);
+        assert_eq!(c.reason, "exited with code 2");
+
+        let mut f = facts(None, &[]);
+        f.signal = Some(11);
+        assert_eq!(classify(&f).reason, "killed by signal 11 (SIGSEGV)");
+
+        let mut f = facts(Some(0), &[]);
+        f.runtime = Duration::from_millis(200);
+        assert_eq!(classify(&f).category, ExitCategory::EarlyExit);
+    }
+}
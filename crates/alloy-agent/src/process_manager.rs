@@ -1,5 +1,6 @@
 use std::{
     collections::{BTreeMap, BTreeSet, HashMap, VecDeque},
+    net::IpAddr,
     path::{Path, PathBuf},
     sync::Arc,
     time::Duration,
@@ -15,24 +16,34 @@ use tokio::{
     sync::mpsc,
 };
 
+use crate::bind_pool;
+use crate::crash_journal;
+use crate::criu;
 use crate::dst;
 use crate::dst_download;
+use crate::frp;
 use crate::minecraft;
 use crate::minecraft_curseforge;
 use crate::minecraft_download;
 use crate::minecraft_import;
 use crate::minecraft_launch;
 use crate::minecraft_modrinth;
-use crate::port_alloc;
+use crate::netaddr;
+use crate::outbox;
+use crate::port_alloc::{self, PortProto};
+use crate::process_exit;
 use crate::sandbox;
 use crate::templates;
 use crate::terraria;
 use crate::terraria_download;
+use crate::topics;
 use crate::process_manager_support::{
+    OomBaseline,
     RestartConfig,
     RestartPolicy,
     compute_backoff_ms,
     early_exit_threshold,
+    detect_oom_kill,
     env_u64,
     format_error_chain,
     log_file_limits,
@@ -90,14 +101,16 @@ fn cpu_percent_x100(
 
 const DEFAULT_MIN_FREE_SPACE_BYTES: u64 = 1024 * 1024 * 1024; // 1 GiB
 
-fn min_free_space_bytes() -> u64 {
+const MAX_CONSOLE_INPUT_BYTES: usize = 4096;
+
+pub(crate) fn min_free_space_bytes() -> u64 {
     env_u64("ALLOY_MIN_FREE_SPACE_BYTES")
         .map(|v| v.clamp(0, 1024_u64 * 1024 * 1024 * 1024))
         .unwrap_or(DEFAULT_MIN_FREE_SPACE_BYTES)
 }
 
 #[cfg(unix)]
-fn free_bytes(p: &Path) -> Option<u64> {
+pub(crate) fn free_bytes(p: &Path) -> Option<u64> {
     use std::ffi::CString;
     use std::os::unix::ffi::OsStrExt;
 
@@ -111,7 +124,7 @@ fn free_bytes(p: &Path) -> Option<u64> {
 }
 
 #[cfg(not(unix))]
-fn free_bytes(_p: &Path) -> Option<u64> {
+pub(crate) fn free_bytes(_p: &Path) -> Option<u64> {
     None
 }
 
@@ -160,7 +173,7 @@ fn graceful_term_grace() -> Duration {
     )
 }
 
-fn parse_java_major_from_version_line(first_line: &str) -> anyhow::Result<u32> {
+pub(crate) fn parse_java_major_from_version_line(first_line: &str) -> anyhow::Result<u32> {
     // Typical formats:
     // - openjdk version "21.0.2" 2024-01-16
     // - java version "1.8.0_402"
@@ -202,6 +215,9 @@ fn parse_java_major_from_version_line(first_line: &str) -> anyhow::Result<u32> {
 }
 
 fn detect_java_major() -> anyhow::Result<u32> {
+    if crate::test_mode::enabled() {
+        return Ok(crate::test_mode::JAVA_MAJOR);
+    }
     // Use the runtime `java` in PATH. We vendor Java 21 in the Docker image,
     // but this also supports local dev installs.
     let out = std::process::Command::new("java")
@@ -331,7 +347,7 @@ local_ip = 0.0.0.0
 local_port = 25565
 remote_port = 0
 "#;
-        let patched = patch_frp_config(raw, 25577);
+        let patched = patch_frp_config(raw, "127.0.0.1", 25577);
         assert!(patched.contains("local_ip = 127.0.0.1"));
         assert!(patched.contains("local_port = 25577"));
         assert!(patched.contains("remote_port = 25577"));
@@ -349,7 +365,7 @@ type = tcp
 local_port = 25565
 remote_port = 0
 "#;
-        let patched = patch_frp_config(raw, 25577);
+        let patched = patch_frp_config(raw, "127.0.0.1", 25577);
         assert!(patched.contains("remote_port = 30012"));
     }
 
@@ -366,8 +382,9 @@ remote_port = 0
     "remote_port": 0
   }
 }"#;
-        let patched = patch_frp_config(raw, 26666);
+        let patched = patch_frp_config(raw, "::1", 26666);
         assert!(patched.contains("[common]"));
+        assert!(patched.contains("local_ip = ::1"));
         assert!(patched.contains("server_addr = frp.example.com"));
         assert!(patched.contains("[game]"));
         assert!(patched.contains("local_port = 26666"));
@@ -386,7 +403,7 @@ proxies:
     local_port: 25565
     remote_port: 0
 "#;
-        let patched = patch_frp_config(raw, 27777);
+        let patched = patch_frp_config(raw, "127.0.0.1", 27777);
         assert!(patched.contains("[game]"));
         assert!(patched.contains("local_port = 27777"));
         assert!(patched.contains("remote_port = 27777"));
@@ -489,22 +506,51 @@ impl LogBuffer {
         }
         (out, last)
     }
+
+    fn last_seq(&self) -> u64 {
+        self.next_seq.saturating_sub(1)
+    }
+
+    // Most recent lines (up to `limit`) emitted after `cursor`, oldest first.
+    fn recent_after(&self, cursor: u64, limit: usize) -> Vec<String> {
+        let mut out: Vec<String> = self
+            .lines
+            .iter()
+            .rev()
+            .take_while(|(seq, _)| *seq > cursor)
+            .take(limit)
+            .map(|(_, line)| line.clone())
+            .collect();
+        out.reverse();
+        out
+    }
 }
 
 #[derive(Clone)]
 struct LogSink {
     buffer: Arc<Mutex<LogBuffer>>,
     file_tx: Option<mpsc::UnboundedSender<String>>,
+    // `console:<process_id>`; live subscribers get every line as it is emitted.
+    topic: String,
 }
 
 impl LogSink {
     async fn emit(&self, line: impl Into<String>) {
         let line = line.into();
+        topics::publish(&self.topic, || line.clone().into());
         self.buffer.lock().await.push_line(line.clone());
         if let Some(tx) = &self.file_tx {
             let _ = tx.send(line);
         }
     }
+
+    async fn cursor(&self) -> u64 {
+        self.buffer.lock().await.last_seq()
+    }
+
+    async fn recent_after(&self, cursor: u64, limit: usize) -> Vec<String> {
+        self.buffer.lock().await.recent_after(cursor, limit)
+    }
 }
 
 struct FileLogWriter {
@@ -604,6 +650,8 @@ struct RunInfo {
     // Params are redacted for known secret keys.
     params: BTreeMap<String, String>,
     env: BTreeMap<String, String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    large_pages: Option<minecraft_launch::LargePagesDecision>,
 }
 
 #[derive(Debug, Clone, serde::Deserialize)]
@@ -612,7 +660,16 @@ struct RunContainerMeta {
     container_id: Option<String>,
 }
 
-fn redact_params(mut params: BTreeMap<String, String>) -> BTreeMap<String, String> {
+// What hibernate() needs to know about the current run.
+#[derive(Debug, Clone, serde::Deserialize)]
+struct RunLaunchMeta {
+    exec: String,
+    container_name: Option<String>,
+    #[serde(default)]
+    params: BTreeMap<String, String>,
+}
+
+pub(crate) fn redact_params(mut params: BTreeMap<String, String>) -> BTreeMap<String, String> {
     for (k, v) in params.iter_mut() {
         let key = k.to_ascii_lowercase();
         let is_secret = key.contains("password")
@@ -620,6 +677,7 @@ fn redact_params(mut params: BTreeMap<String, String>) -> BTreeMap<String, Strin
             || key.contains("secret")
             || key.contains("api_key")
             || key.contains("apikey")
+            || key.contains("webhook")
             || (key.contains("frp") && key.contains("config"));
         if is_secret && !v.is_empty() {
             *v = "<redacted>".to_string();
@@ -779,7 +837,7 @@ fn normalize_ini_scalar_value(raw: &str) -> String {
         .to_string()
 }
 
-fn patch_frpc_ini(raw: &str, local_port: u16, alloc_ports_hint: &[u16]) -> String {
+fn patch_frpc_ini(raw: &str, local_ip: &str, local_port: u16, alloc_ports_hint: &[u16]) -> String {
     let mut explicit_remote_port: Option<u16> = None;
     for line in raw.lines() {
         let trimmed = line.trim_start();
@@ -836,7 +894,9 @@ fn patch_frpc_ini(raw: &str, local_port: u16, alloc_ports_hint: &[u16]) -> Strin
             let rest = trimmed.get("local_ip".len()..).unwrap_or("").trim_start();
             if rest.is_empty() || rest.starts_with('=') || rest.starts_with(':') {
                 out.push_str(indent);
-                out.push_str("local_ip = 127.0.0.1\n");
+                out.push_str("local_ip = ");
+                out.push_str(local_ip);
+                out.push('\n');
                 continue;
             }
         }
@@ -873,6 +933,7 @@ fn json_scalar_to_string(v: &serde_json::Value) -> Option<String> {
 
 fn patch_structured_frp_to_ini(
     root: serde_json::Value,
+    local_ip: &str,
     local_port: u16,
     alloc_ports_hint: &[u16],
 ) -> Option<String> {
@@ -954,7 +1015,7 @@ fn patch_structured_frp_to_ini(
         vals.remove("localIP");
         vals.remove("localPort");
         vals.remove("remotePort");
-        vals.insert("local_ip".to_string(), "127.0.0.1".to_string());
+        vals.insert("local_ip".to_string(), local_ip.to_string());
         vals.insert("local_port".to_string(), local_port.to_string());
         vals.insert("remote_port".to_string(), remote.to_string());
         vals.entry("type".to_string())
@@ -989,65 +1050,77 @@ fn patch_structured_frp_to_ini(
     Some(out)
 }
 
-fn patch_frp_config(raw: &str, local_port: u16) -> String {
+// Points every proxy at local_ip:local_port, the instance's server as seen from frpc.
+fn patch_frp_config(raw: &str, local_ip: &str, local_port: u16) -> String {
     let format = detect_frp_config_format(raw);
     let alloc_ports_hint = parse_allocatable_ports_hint(raw);
 
     match format {
-        FrpConfigFormat::Ini => patch_frpc_ini(raw, local_port, &alloc_ports_hint),
+        FrpConfigFormat::Ini => patch_frpc_ini(raw, local_ip, local_port, &alloc_ports_hint),
         FrpConfigFormat::Json => serde_json::from_str::<serde_json::Value>(raw)
             .ok()
-            .and_then(|root| patch_structured_frp_to_ini(root, local_port, &alloc_ports_hint))
-            .unwrap_or_else(|| patch_frpc_ini(raw, local_port, &alloc_ports_hint)),
+            .and_then(|root| {
+                patch_structured_frp_to_ini(root, local_ip, local_port, &alloc_ports_hint)
+            })
+            .unwrap_or_else(|| patch_frpc_ini(raw, local_ip, local_port, &alloc_ports_hint)),
         FrpConfigFormat::Toml => raw
             .parse::<toml::Value>()
             .ok()
             .and_then(|v| serde_json::to_value(v).ok())
-            .and_then(|root| patch_structured_frp_to_ini(root, local_port, &alloc_ports_hint))
-            .unwrap_or_else(|| patch_frpc_ini(raw, local_port, &alloc_ports_hint)),
+            .and_then(|root| {
+                patch_structured_frp_to_ini(root, local_ip, local_port, &alloc_ports_hint)
+            })
+            .unwrap_or_else(|| patch_frpc_ini(raw, local_ip, local_port, &alloc_ports_hint)),
         FrpConfigFormat::Yaml => serde_yaml::from_str::<serde_yaml::Value>(raw)
             .ok()
             .and_then(|v| serde_json::to_value(v).ok())
-            .and_then(|root| patch_structured_frp_to_ini(root, local_port, &alloc_ports_hint))
-            .unwrap_or_else(|| patch_frpc_ini(raw, local_port, &alloc_ports_hint)),
+            .and_then(|root| {
+                patch_structured_frp_to_ini(root, local_ip, local_port, &alloc_ports_hint)
+            })
+            .unwrap_or_else(|| patch_frpc_ini(raw, local_ip, local_port, &alloc_ports_hint)),
     }
 }
 
-async fn start_frpc_sidecar(
-    sink: LogSink,
-    instance_dir: PathBuf,
-    owner_pgid: i32,
-    local_port: u16,
-    config_raw: String,
-) -> anyhow::Result<()> {
-    let cfg_dir = instance_dir.join("config");
-    let cfg_path = cfg_dir.join("frpc.ini");
-    let detected = detect_frp_config_format(&config_raw);
-    let patched = patch_frp_config(&config_raw, local_port);
-
-    tokio::fs::create_dir_all(&cfg_dir)
-        .await
-        .context("create frpc config dir")?;
+async fn write_frpc_config(cfg_path: &Path, contents: &str) -> anyhow::Result<()> {
+    if let Some(dir) = cfg_path.parent() {
+        tokio::fs::create_dir_all(dir)
+            .await
+            .context("create frpc config dir")?;
+    }
 
     let tmp = cfg_path.with_extension("ini.tmp");
-    tokio::fs::write(&tmp, patched.as_bytes())
+    tokio::fs::write(&tmp, contents.as_bytes())
         .await
         .context("write frpc config tmp")?;
-    tokio::fs::rename(&tmp, &cfg_path)
+    tokio::fs::rename(&tmp, cfg_path)
         .await
         .context("persist frpc config")?;
+    Ok(())
+}
 
-    let exec = std::env::var("ALLOY_FRPC_PATH").unwrap_or_else(|_| "frpc".to_string());
-
-    sink.emit(format!(
-        "[alloy-agent] starting frpc tunnel (local_port={local_port}, source={detected:?})"
-    ))
-    .await;
+enum FrpcEvent {
+    Exited(std::io::Result<std::process::ExitStatus>),
+    Signal(frp::FrpcSignal),
+    PollStats,
+    Reload(frp::ReloadRequest),
+}
 
-    let mut cmd = Command::new(&exec);
-    cmd.current_dir(&instance_dir)
+// Spawns frpc and forwards its output to the instance console. Name collisions and connection
+// results reported by frpc are sent on the returned channel.
+fn spawn_frpc(
+    sink: &LogSink,
+    exec: &str,
+    instance_dir: &Path,
+    cfg_path: &Path,
+    owner_pgid: i32,
+) -> anyhow::Result<(
+    tokio::process::Child,
+    mpsc::UnboundedReceiver<frp::FrpcSignal>,
+)> {
+    let mut cmd = Command::new(exec);
+    cmd.current_dir(instance_dir)
         .arg("-c")
-        .arg(&cfg_path)
+        .arg(cfg_path)
         .stdin(std::process::Stdio::null())
         .stdout(std::process::Stdio::piped())
         .stderr(std::process::Stdio::piped());
@@ -1069,14 +1142,19 @@ async fn start_frpc_sidecar(
         .spawn()
         .with_context(|| format!("spawn frpc: exec={exec} (cfg {})", cfg_path.display()))?;
 
+    let (signal_tx, signal_rx) = mpsc::unbounded_channel::<frp::FrpcSignal>();
     let stdout = child.stdout.take();
     let stderr = child.stderr.take();
 
     if let Some(out) = stdout {
         let sink = sink.clone();
+        let signal_tx = signal_tx.clone();
         tokio::spawn(async move {
             let mut lines = BufReader::new(out).lines();
             while let Ok(Some(line)) = lines.next_line().await {
+                if let Some(signal) = frp::parse_frpc_line(&line) {
+                    let _ = signal_tx.send(signal);
+                }
                 sink.emit(format!("[frpc stdout] {line}")).await;
             }
         });
@@ -1086,31 +1164,304 @@ async fn start_frpc_sidecar(
         tokio::spawn(async move {
             let mut lines = BufReader::new(err).lines();
             while let Ok(Some(line)) = lines.next_line().await {
+                if let Some(signal) = frp::parse_frpc_line(&line) {
+                    let _ = signal_tx.send(signal);
+                }
                 sink.emit(format!("[frpc stderr] {line}")).await;
             }
         });
     }
 
+    Ok((child, signal_rx))
+}
+
+async fn start_frpc_sidecar(
+    sink: LogSink,
+    instance_dir: PathBuf,
+    owner_pgid: i32,
+    local_port: u16,
+    config_raw: String,
+    security: frp::SecurityOptions,
+) -> anyhow::Result<()> {
+    let cfg_path = instance_dir.join("config").join("frpc.ini");
+    let detected = detect_frp_config_format(&config_raw);
+    let instance_id = instance_dir
+        .file_name()
+        .map(|s| s.to_string_lossy().to_string())
+        .unwrap_or_default();
+
+    // The tunnel forwards to local_port, so it has to be this instance's port. The admin listener
+    // is leased for as long as the sidecar runs.
+    if let Some(owner) = port_alloc::holder(PortProto::Tcp, local_port)
+        && owner.instance_id != instance_id
+    {
+        anyhow::bail!(
+            "tunnel local port {local_port} is reserved by instance {} ({})",
+            owner.instance_id,
+            owner.purpose
+        );
+    }
+    // A server bound to a single address (server-ip) is only reachable there.
+    let local_ip = netaddr::local_target(minecraft::server_ip(&instance_dir)).to_string();
+    let patched = patch_frp_config(&config_raw, &local_ip, local_port);
+    let admin_port = match frp::admin_port(&patched) {
+        Some(port) => {
+            if let Some(owner) = port_alloc::reserved_by_other(PortProto::Tcp, port, &instance_id) {
+                anyhow::bail!(
+                    "frpc admin_port {port} is reserved by instance {} ({})",
+                    owner.instance_id,
+                    owner.purpose
+                );
+            }
+            port
+        }
+        None => port_alloc::allocate_unreserved_port(PortProto::Tcp, &instance_id)?,
+    };
+    let admin_lease =
+        port_alloc::lease(PortProto::Tcp, admin_port, &instance_id, "frpc admin api")?;
+    let patched = frp::enable_admin_api(&patched, admin_port);
+
+    let mut tunnel = frp::TunnelConfig::new(&instance_id, &patched, security);
+    let admin = frp::admin_api(&tunnel.ini);
+    let mut dashboard = frp::dashboard_url(&tunnel.ini);
+    write_frpc_config(&cfg_path, &tunnel.render()).await?;
+    for warning in frp::security_posture(&tunnel.render()).warnings {
+        sink.emit(format!("[alloy-agent] frp: {warning}")).await;
+    }
+    let _ = frp::write_status(&instance_dir, &tunnel.status("starting", None)).await;
+
+    let exec = std::env::var("ALLOY_FRPC_PATH").unwrap_or_else(|_| "frpc".to_string());
+
+    sink.emit(format!(
+        "[alloy-agent] starting frpc tunnel (local_port={local_port}, source={detected:?})"
+    ))
+    .await;
+
+    let (mut child, mut signals) =
+        match spawn_frpc(&sink, &exec, &instance_dir, &cfg_path, owner_pgid) {
+            Ok(v) => v,
+            Err(e) => {
+                let status = tunnel.status("failed", Some(format_error_chain(&e)));
+                let _ = frp::write_status(&instance_dir, &status).await;
+                return Err(e);
+            }
+        };
+    let _ = frp::write_status(&instance_dir, &tunnel.status("running", None)).await;
+
+    let stats_interval = Duration::from_millis(
+        env_u64("ALLOY_FRP_STATS_INTERVAL_MS")
+            .unwrap_or(15_000)
+            .max(1_000),
+    );
+    let failover_after = Duration::from_millis(
+        env_u64("ALLOY_FRP_FAILOVER_AFTER_MS")
+            .unwrap_or(60_000)
+            .max(1_000),
+    );
+
+    let mut reloads = frp::register_sidecar(&instance_dir);
     let wait_sink = sink.clone();
     tokio::spawn(async move {
-        let res = child.wait().await;
-        match res {
-            Ok(st) => {
-                wait_sink
-                    .emit(format!("[alloy-agent] frpc exited: {st}"))
-                    .await
+        let _admin_lease = admin_lease;
+        let mut stats_tick = tokio::time::interval(stats_interval);
+        stats_tick.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+        loop {
+            let next = tokio::select! {
+                res = child.wait() => FrpcEvent::Exited(res),
+                Some(signal) = signals.recv() => FrpcEvent::Signal(signal),
+                _ = stats_tick.tick() => FrpcEvent::PollStats,
+                Some(req) = reloads.recv() => FrpcEvent::Reload(req),
+            };
+
+            // Every arm that falls through to the end of the loop restarts frpc with the current
+            // config.
+            match next {
+                FrpcEvent::Signal(frp::FrpcSignal::Collision(name)) => {
+                    let Some(new_name) = tunnel.naming.rename_on_collision(&name) else {
+                        let msg = format!("proxy name {name} is already in use on frps");
+                        wait_sink
+                            .emit(format!("[alloy-agent] frp: {msg}; giving up on renaming"))
+                            .await;
+                        let status = tunnel.status("running", Some(msg));
+                        let _ = frp::write_status(&instance_dir, &status).await;
+                        continue;
+                    };
+                    wait_sink
+                        .emit(format!(
+                            "[alloy-agent] frp: proxy name {name} is already in use on frps; retrying as {new_name}"
+                        ))
+                        .await;
+                }
+                FrpcEvent::Signal(frp::FrpcSignal::Connected) => {
+                    if let Some(f) = tunnel.failover.as_mut() {
+                        f.on_connected();
+                    }
+                    continue;
+                }
+                FrpcEvent::Signal(frp::FrpcSignal::ConnectFailed(reason)) => {
+                    let Some(f) = tunnel.failover.as_mut() else {
+                        continue;
+                    };
+                    if !f.on_failure(failover_after) {
+                        continue;
+                    }
+                    let (from, to) = f.advance(&reason);
+                    wait_sink
+                        .emit(format!(
+                            "[alloy-agent] frp: {from} unreachable for {}s; failing over to {to}",
+                            failover_after.as_secs()
+                        ))
+                        .await;
+                }
+                FrpcEvent::PollStats => {
+                    let Some(api) = admin.as_ref() else {
+                        continue;
+                    };
+                    match frp::fetch_frpc_status(api).await {
+                        Ok(mut sample) => {
+                            if let Some(url) = dashboard.as_ref()
+                                && let Err(e) = frp::fetch_frps_counters(url, &mut sample).await
+                            {
+                                let error = format_error_chain(&e);
+                                tracing::debug!(%error, "frps dashboard query failed");
+                            }
+                            if let Some(f) = tunnel.failover.as_mut()
+                                && sample.iter().any(|s| s.state.as_deref() == Some("running"))
+                            {
+                                f.on_connected();
+                            }
+                            tunnel.naming.record_stats(&sample);
+                            let status = tunnel.status("running", None);
+                            let _ = frp::write_status(&instance_dir, &status).await;
+                        }
+                        // frpc may still be starting; the next tick retries.
+                        Err(e) => {
+                            let error = format_error_chain(&e);
+                            tracing::debug!(%error, "frpc admin api query failed");
+                        }
+                    }
+                    continue;
+                }
+                FrpcEvent::Reload(req) => {
+                    let result = match admin.as_ref() {
+                        Some(api) => {
+                            reload_frpc_config(
+                                &cfg_path,
+                                &instance_id,
+                                api,
+                                &local_ip,
+                                local_port,
+                                &tunnel,
+                                &req.config_raw,
+                            )
+                            .await
+                        }
+                        None => Err(anyhow::anyhow!("frpc admin api is not enabled")),
+                    };
+                    let reply = match result {
+                        Ok(next_tunnel) => {
+                            tunnel = next_tunnel;
+                            dashboard = frp::dashboard_url(&tunnel.ini);
+                            wait_sink
+                                .emit("[alloy-agent] frpc config reloaded".to_string())
+                                .await;
+                            let status = tunnel.status("running", None);
+                            let _ = frp::write_status(&instance_dir, &status).await;
+                            Ok(())
+                        }
+                        Err(e) => {
+                            let err = format_error_chain(&e);
+                            wait_sink
+                                .emit(format!("[alloy-agent] frpc reload failed: {err}"))
+                                .await;
+                            Err(e)
+                        }
+                    };
+                    let _ = req.reply.send(reply);
+                    continue;
+                }
+                FrpcEvent::Exited(res) => {
+                    // frpc can still exit on a failed connection (e.g. a bad token); move on to
+                    // the next frps unless every endpoint already failed since the last login.
+                    let failover = tunnel
+                        .failover
+                        .as_mut()
+                        .filter(|f| f.is_failing() && !f.exhausted());
+                    if let Some(f) = failover {
+                        let (from, to) = f.advance("frpc exited while failing to connect");
+                        wait_sink
+                            .emit(format!(
+                                "[alloy-agent] frp: frpc exited while connecting to {from}; failing over to {to}"
+                            ))
+                            .await;
+                    } else {
+                        let (msg, err) = match res {
+                            Ok(st) => (format!("[alloy-agent] frpc exited: {st}"), None),
+                            Err(e) => (
+                                format!("[alloy-agent] frpc wait failed: {e}"),
+                                Some(e.to_string()),
+                            ),
+                        };
+                        wait_sink.emit(msg).await;
+                        let status = tunnel.status("exited", err);
+                        let _ = frp::write_status(&instance_dir, &status).await;
+                        break;
+                    }
+                }
             }
-            Err(e) => {
-                wait_sink
-                    .emit(format!("[alloy-agent] frpc wait failed: {e}"))
-                    .await
+
+            let _ = child.kill().await;
+            let respawned = match write_frpc_config(&cfg_path, &tunnel.render()).await {
+                Ok(()) => spawn_frpc(&wait_sink, &exec, &instance_dir, &cfg_path, owner_pgid),
+                Err(e) => Err(e),
+            };
+            match respawned {
+                Ok((c, rx)) => {
+                    child = c;
+                    signals = rx;
+                    let _ = frp::write_status(&instance_dir, &tunnel.status("running", None)).await;
+                }
+                Err(e) => {
+                    let err = format_error_chain(&e);
+                    wait_sink
+                        .emit(format!("[alloy-agent] frpc restart failed: {err}"))
+                        .await;
+                    let status = tunnel.status("failed", Some(err));
+                    let _ = frp::write_status(&instance_dir, &status).await;
+                    break;
+                }
             }
         }
+        drop(reloads);
+        frp::unregister_sidecar(&instance_dir);
     });
 
     Ok(())
 }
 
+// Applies a new frp config to the running frpc through its admin API instead of restarting it, so
+// players on unchanged proxies stay connected. The previous config is restored if frpc rejects it.
+async fn reload_frpc_config(
+    cfg_path: &Path,
+    instance_id: &str,
+    api: &frp::AdminApi,
+    local_ip: &str,
+    local_port: u16,
+    current: &frp::TunnelConfig,
+    config_raw: &str,
+) -> anyhow::Result<frp::TunnelConfig> {
+    let next = frp::keep_admin_api(&patch_frp_config(config_raw, local_ip, local_port), api);
+    let mut next_tunnel = frp::TunnelConfig::new(instance_id, &next, current.security);
+    next_tunnel.carry_over(current);
+
+    write_frpc_config(cfg_path, &next_tunnel.render()).await?;
+    if let Err(e) = frp::admin_reload(api).await {
+        let _ = write_frpc_config(cfg_path, &current.render()).await;
+        return Err(e);
+    }
+    Ok(next_tunnel)
+}
+
 #[cfg(target_os = "linux")]
 unsafe fn set_parent_death_signal() -> std::io::Result<()> {
     // If the agent process dies (crash/kill), ensure the child is terminated.
@@ -1137,6 +1488,13 @@ fn prepare_instance_command(
     args: &[String],
     extra_rw_paths: &[PathBuf],
 ) -> anyhow::Result<(Command, sandbox::SandboxLaunch)> {
+    let fake;
+    let (exec, args) = if crate::test_mode::enabled() && exec == "java" {
+        fake = crate::test_mode::fake_java_command(args)?;
+        (fake.0.as_str(), fake.1.as_slice())
+    } else {
+        (exec, args)
+    };
     let launch = sandbox::prepare_launch(
         process_id,
         template_id,
@@ -1323,12 +1681,17 @@ async fn docker_kill_container(container_id: &str) -> anyhow::Result<()> {
     );
 }
 
-async fn wait_for_local_tcp_port(port: u16, timeout: Duration) -> bool {
+// Waits until something accepts connections on `port` at the address a server bound to `bind`
+// is reachable on (loopback of either family for a wildcard, see netaddr::local_targets).
+async fn wait_for_local_tcp_port(bind: Option<IpAddr>, port: u16, timeout: Duration) -> bool {
+    let targets = netaddr::local_targets(bind, netaddr::probe_family());
     let deadline = tokio::time::Instant::now() + timeout;
     loop {
-        if let Ok(s) = tokio::net::TcpStream::connect(("127.0.0.1", port)).await {
-            drop(s);
-            return true;
+        for ip in &targets {
+            if let Ok(s) = tokio::net::TcpStream::connect((*ip, port)).await {
+                drop(s);
+                return true;
+            }
         }
 
         if tokio::time::Instant::now() >= deadline {
@@ -1350,6 +1713,163 @@ async fn set_entry_message(
     e.message = message;
 }
 
+// Records how an instance process ended. Shared by every template's wait task.
+fn apply_exit_result(
+    e: &mut ProcessEntry,
+    res: &std::io::Result<std::process::ExitStatus>,
+    stopping: bool,
+    runtime: Duration,
+    oom_reason: Option<&str>,
+    log_tail: &[String],
+    hs_err: Option<&Path>,
+) {
+    let scan_from = log_tail
+        .len()
+        .saturating_sub(process_exit::EXIT_LOG_SCAN_LINES);
+    let log_tail = &log_tail[scan_from..];
+    match res {
+        Ok(status) => {
+            e.exit_code = status.code();
+
+            // The port probe marks the entry as failed before it terminates the process.
+            let watchdog = if matches!(e.state, ProcessState::Failed) {
+                e.message.clone()
+            } else {
+                None
+            };
+            let c = process_exit::classify(&process_exit::ExitFacts {
+                code: status.code(),
+                signal: process_exit::exit_signal(status),
+                stopping,
+                watchdog: watchdog.as_deref(),
+                oom_reason,
+                runtime,
+                early_exit_threshold: early_exit_threshold(),
+                log_tail,
+                hs_err,
+            });
+
+            e.state = if c.category.is_failure() {
+                ProcessState::Failed
+            } else {
+                ProcessState::Exited
+            };
+            e.message = Some(c.reason.clone());
+            e.exit_category = Some(c.category.as_str().to_string());
+            e.exit_reason = Some(c.reason);
+        }
+        Err(err) => {
+            e.state = ProcessState::Failed;
+            e.message = Some(format!("wait failed: {err}"));
+            e.exit_category = Some(process_exit::ExitCategory::Crashed.as_str().to_string());
+            e.exit_reason = e.message.clone();
+        }
+    }
+}
+
+// Builds the crash journal record for a failed exit; requested stops and clean exits are not
+// journaled, so they can't push real crashes out of the journal.
+fn crash_record_for(
+    e: &ProcessEntry,
+    process_id: &str,
+    res: &std::io::Result<std::process::ExitStatus>,
+    runtime: Duration,
+    hs_err: Option<&Path>,
+    lines: &[String],
+) -> Option<crash_journal::CrashRecord> {
+    let clean = [
+        process_exit::ExitCategory::Stopped,
+        process_exit::ExitCategory::Exited,
+    ];
+    if clean
+        .iter()
+        .any(|c| e.exit_category.as_deref() == Some(c.as_str()))
+    {
+        return None;
+    }
+    let exited_at_unix_ms = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_millis() as u64)
+        .unwrap_or(0);
+    Some(crash_journal::CrashRecord {
+        process_id: process_id.to_string(),
+        template_id: e.template_id.0.clone(),
+        exited_at_unix_ms,
+        runtime_ms: runtime.as_millis() as u64,
+        exit_code: e.exit_code,
+        signal: res.as_ref().ok().and_then(process_exit::exit_signal),
+        exit_category: e.exit_category.clone(),
+        exit_reason: e.exit_reason.clone(),
+        hs_err_path: hs_err.map(|p| p.display().to_string()),
+        request_id: e.request_id.clone(),
+        lines: lines.to_vec(),
+    })
+}
+
+async fn save_crash_journal(sink: &LogSink, dir: &Path, record: &crash_journal::CrashRecord) {
+    let journal_path = match crash_journal::write(dir, record).await {
+        Ok(path) => {
+            sink.emit(format!(
+                "[alloy-agent] crash journal saved: {}",
+                path.display()
+            ))
+            .await;
+            Some(path.display().to_string())
+        }
+        Err(err) => {
+            tracing::warn!(
+                process_id = %record.process_id,
+                error = %format_error_chain(&err),
+                "failed to write crash journal"
+            );
+            None
+        }
+    };
+
+    outbox::push(
+        "crash",
+        serde_json::json!({
+            "process_id": record.process_id,
+            "template_id": record.template_id,
+            "exited_at_unix_ms": record.exited_at_unix_ms,
+            "runtime_ms": record.runtime_ms,
+            "exit_code": record.exit_code,
+            "signal": record.signal,
+            "exit_category": record.exit_category,
+            "exit_reason": record.exit_reason,
+            "journal_path": journal_path,
+            "request_id": record.request_id,
+        }),
+    );
+}
+
+// The console of a process: its ring buffer, logs/console.log under `root_dir` and the live topic.
+fn console_sink(
+    process_id: &str,
+    root_dir: &Path,
+    logs: Arc<Mutex<LogBuffer>>,
+) -> (LogSink, mpsc::UnboundedSender<String>) {
+    let console_log_path = root_dir.join("logs").join("console.log");
+    let (max_bytes, max_files) = log_file_limits();
+    let (log_tx, mut log_rx) = mpsc::unbounded_channel::<String>();
+    tokio::spawn(async move {
+        let Ok(mut writer) = FileLogWriter::open(console_log_path, max_bytes, max_files).await
+        else {
+            return;
+        };
+        while let Some(line) = log_rx.recv().await {
+            let _ = writer.write_line(&line).await;
+        }
+    });
+
+    let sink = LogSink {
+        buffer: logs,
+        file_tx: Some(log_tx.clone()),
+        topic: format!("console:{process_id}"),
+    };
+    (sink, log_tx)
+}
+
 #[derive(Debug)]
 struct ProcessEntry {
     template_id: ProcessTemplateId,
@@ -1358,6 +1878,8 @@ struct ProcessEntry {
     resources: Option<alloy_process::ProcessResources>,
     exit_code: Option<i32>,
     message: Option<String>,
+    exit_category: Option<String>,
+    exit_reason: Option<String>,
     restart: RestartConfig,
     restart_attempts: u32,
     stdin: Option<ChildStdin>,
@@ -1365,6 +1887,8 @@ struct ProcessEntry {
     pgid: Option<i32>,
     logs: Arc<Mutex<LogBuffer>>,
     log_file_tx: Option<mpsc::UnboundedSender<String>>,
+    // Request that started this run; None for autostart and automatic restarts.
+    request_id: Option<String>,
 }
 
 #[derive(Clone, Debug, Default)]
@@ -1407,6 +1931,14 @@ impl ProcessManager {
                         write_bytes,
                     });
                 }
+                topics::publish(&format!("metrics:{process_id}"), || {
+                    serde_json::json!({
+                        "cpu_percent_x100": cpu_percent_x100,
+                        "rss_bytes": rss_bytes,
+                        "read_bytes": read_bytes,
+                        "write_bytes": write_bytes,
+                    })
+                });
 
                 tokio::time::sleep(interval).await;
             }
@@ -1465,25 +1997,7 @@ impl ProcessManager {
             minecraft::data_root().join("processes").join(&id.0)
         };
 
-        let console_log_path = root_dir.join("logs").join("console.log");
-        let (max_bytes, max_files) = log_file_limits();
-        let (log_tx, mut log_rx) = mpsc::unbounded_channel::<String>();
-        tokio::spawn({
-            let path = console_log_path.clone();
-            async move {
-                let Ok(mut writer) = FileLogWriter::open(path, max_bytes, max_files).await else {
-                    return;
-                };
-                while let Some(line) = log_rx.recv().await {
-                    let _ = writer.write_line(&line).await;
-                }
-            }
-        });
-
-        let sink = LogSink {
-            buffer: logs.clone(),
-            file_tx: Some(log_tx.clone()),
-        };
+        let (sink, log_tx) = console_sink(&id.0, &root_dir, logs.clone());
 
         sink.emit(format!(
             "[alloy-agent] start requested: template_id={} process_id={}",
@@ -1504,6 +2018,8 @@ impl ProcessManager {
                     resources: None,
                     exit_code: None,
                     message: Some("starting...".to_string()),
+                    exit_category: None,
+                    exit_reason: None,
                     restart: initial_restart,
                     restart_attempts: reused_restart_attempts,
                     stdin: None,
@@ -1511,6 +2027,7 @@ impl ProcessManager {
                     pgid: None,
                     logs: logs.clone(),
                     log_file_tx: Some(log_tx.clone()),
+                    request_id: crate::trace::current(),
                 },
             );
         }
@@ -1529,28 +2046,31 @@ impl ProcessManager {
                 let mc = minecraft::validate_vanilla_params(&params)?;
 
                 // Allow auto port assignment (port=0 means "auto").
-                let mc_port = port_alloc::allocate_tcp_port(mc.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some(
-                            "Pick another port, or leave it blank (0) to auto-assign a free port."
-                                .to_string(),
-                        ),
-                    )
+                // A pinned bind address wins over a server-ip set by hand.
+                let pinned = bind_pool::resolve(&id.0, &params)?;
+                let bind = pinned.or_else(|| minecraft::server_ip(&minecraft::instance_dir(&id.0)));
+                let mc_port = port_alloc::allocate_tcp_port_on(bind, mc.port).map_err(|e| {
+                    port_alloc::unavailable_error("port", PortProto::Tcp, mc.port, &e)
                 })?;
                 let mc = minecraft::VanillaParams {
                     port: mc_port,
                     ..mc
                 };
                 params.insert("port".to_string(), mc_port.to_string());
+                let port_lease = port_alloc::lease_on(
+                    PortProto::Tcp,
+                    bind,
+                    mc_port,
+                    &id.0,
+                    &format!("{} port", t.template_id),
+                )?;
                 let restart = parse_restart_config(&params);
 
                 let dir = minecraft::instance_dir(&id.0);
                 minecraft::ensure_vanilla_instance_layout(&dir, &mc)?;
+                if let Some(ip) = pinned {
+                    minecraft::set_server_ip(&dir, ip)?;
+                }
 
                 set_entry_message(
                     &self.inner,
@@ -1564,7 +2084,7 @@ impl ProcessManager {
                     .await
                     .map_err(|e| {
                         crate::error_payload::anyhow(
-                            "download_failed",
+                            crate::upstream::error_code(&e),
                             format!("failed to resolve minecraft server jar: {e}"),
                             None,
                             Some(
@@ -1573,6 +2093,13 @@ impl ProcessManager {
                             ),
                         )
                     })?;
+                if resolved.is_stale {
+                    sink.emit(
+                        "[alloy-agent] Mojang API unavailable; using cached version metadata"
+                            .to_string(),
+                    )
+                    .await;
+                }
                 let have_java = detect_java_major()?;
                 if have_java != resolved.java_major {
                     return Err(crate::error_payload::anyhow(
@@ -1617,14 +2144,32 @@ impl ProcessManager {
                         Some("Ensure the instance directory is writable, then retry.".to_string()),
                     )
                 })?;
+                let provenance = crate::jar_provenance::Provenance::new(
+                    "mojang",
+                    &resolved.jar_url,
+                    &resolved.version_id,
+                    &resolved.sha1,
+                    resolved.size,
+                );
+                if let Err(e) = crate::jar_provenance::write(&dir, &provenance) {
+                    tracing::warn!(
+                        process_id = %id.0,
+                        error = %e,
+                        "failed to record server.jar provenance"
+                    );
+                }
 
                 let exec = "java".to_string();
-                let raw_args = vec![
+                let mut raw_args = vec![
                     format!("-Xmx{}M", mc.memory_mb),
                     "-jar".to_string(),
                     "server.jar".to_string(),
                     "nogui".to_string(),
                 ];
+                let large_pages = minecraft_launch::large_pages_decision(&params);
+                if let Some(flag) = large_pages.as_ref().and_then(|d| d.flag.clone()) {
+                    raw_args.insert(0, flag);
+                }
 
                 let (mut cmd, sandbox_launch) = prepare_instance_command(
                     &id.0,
@@ -1655,11 +2200,20 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env: collect_safe_env(),
+                    large_pages: large_pages.clone(),
                 };
                 let _ = write_run_json(&dir, &run).await;
 
                 sink.emit(format!("[alloy-agent] sandbox: {}", sandbox_launch.summary()))
                     .await;
+                if let Some(d) = &large_pages {
+                    sink.emit(format!(
+                        "[alloy-agent] large pages: {} ({})",
+                        d.flag.as_deref().unwrap_or("disabled"),
+                        d.reason
+                    ))
+                    .await;
+                }
                 for warning in sandbox_launch.warnings() {
                     sink.emit(format!("[alloy-agent] sandbox warning: {warning}"))
                         .await;
@@ -1746,6 +2300,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(format!("waiting for port {}...", mc.port)),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -1753,6 +2309,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -1773,6 +2330,8 @@ impl ProcessManager {
                     .map(|v| v.trim())
                     .filter(|v| !v.is_empty())
                     .map(|v| v.to_string());
+                // Validated when the instance is saved.
+                let frp_security = frp::SecurityOptions::from_params(&params).unwrap_or_default();
                 let frp_instance_dir = dir.clone();
                 tokio::spawn({
                     let inner = inner.clone();
@@ -1781,7 +2340,8 @@ impl ProcessManager {
                     let frp_instance_dir = frp_instance_dir.clone();
                     async move {
                         let timeout = port_probe_timeout();
-                        let ok = wait_for_local_tcp_port(port, timeout).await;
+                        let bind = minecraft::server_ip(&frp_instance_dir);
+                        let ok = wait_for_local_tcp_port(bind, port, timeout).await;
 
                         let (pgid, should_kill) = {
                             let mut map = inner.lock().await;
@@ -1815,6 +2375,7 @@ impl ProcessManager {
                                     pgid,
                                     port,
                                     cfg,
+                                    frp_security,
                                 )
                                 .await
                                 {
@@ -1850,7 +2411,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_lease = port_lease;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -1866,11 +2432,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -1879,35 +2463,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -1930,7 +2502,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -1942,6 +2514,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -1992,6 +2577,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(format!("waiting for port {}...", mc.port)),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -2007,21 +2594,21 @@ impl ProcessManager {
 
                 let mc = minecraft_modrinth::validate_params(&params)?;
 
-                let mc_port = port_alloc::allocate_tcp_port(mc.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some(
-                            "Pick another port, or leave it blank (0) to auto-assign a free port."
-                                .to_string(),
-                        ),
-                    )
+                // A pinned bind address wins over a server-ip set by hand.
+                let pinned = bind_pool::resolve(&id.0, &params)?;
+                let bind = pinned.or_else(|| minecraft::server_ip(&minecraft::instance_dir(&id.0)));
+                let mc_port = port_alloc::allocate_tcp_port_on(bind, mc.port).map_err(|e| {
+                    port_alloc::unavailable_error("port", PortProto::Tcp, mc.port, &e)
                 })?;
                 let mc = minecraft_modrinth::ModrinthParams { port: mc_port, ..mc };
                 params.insert("port".to_string(), mc_port.to_string());
+                let port_lease = port_alloc::lease_on(
+                    PortProto::Tcp,
+                    bind,
+                    mc_port,
+                    &id.0,
+                    &format!("{} port", t.template_id),
+                )?;
                 let restart = parse_restart_config(&params);
 
                 let dir = minecraft::instance_dir(&id.0);
@@ -2033,6 +2620,9 @@ impl ProcessManager {
                         port: mc.port,
                     },
                 )?;
+                if let Some(ip) = pinned {
+                    minecraft::set_server_ip(&dir, ip)?;
+                }
 
                 set_entry_message(
                     &self.inner,
@@ -2066,7 +2656,7 @@ impl ProcessManager {
                     .await
                     .map_err(|e| {
                         crate::error_payload::anyhow(
-                            "download_failed",
+                            crate::upstream::error_code(&e),
                             format!("failed to resolve minecraft server metadata: {e}"),
                             None,
                             Some(
@@ -2076,6 +2666,13 @@ impl ProcessManager {
                         )
                     })?;
 
+                if resolved.is_stale {
+                    sink.emit(
+                        "[alloy-agent] Mojang API unavailable; using cached version metadata"
+                            .to_string(),
+                    )
+                    .await;
+                }
                 let have_java = detect_java_major()?;
                 if have_java != resolved.java_major {
                     return Err(crate::error_payload::anyhow(
@@ -2103,12 +2700,16 @@ impl ProcessManager {
                 }
 
                 let exec = "java".to_string();
-                let raw_args = vec![
+                let mut raw_args = vec![
                     format!("-Xmx{}M", mc.memory_mb),
                     "-jar".to_string(),
                     "server.jar".to_string(),
                     "nogui".to_string(),
                 ];
+                let large_pages = minecraft_launch::large_pages_decision(&params);
+                if let Some(flag) = large_pages.as_ref().and_then(|d| d.flag.clone()) {
+                    raw_args.insert(0, flag);
+                }
 
                 let (mut cmd, sandbox_launch) = prepare_instance_command(
                     &id.0,
@@ -2139,11 +2740,20 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env: collect_safe_env(),
+                    large_pages: large_pages.clone(),
                 };
                 let _ = write_run_json(&dir, &run).await;
 
                 sink.emit(format!("[alloy-agent] sandbox: {}", sandbox_launch.summary()))
                     .await;
+                if let Some(d) = &large_pages {
+                    sink.emit(format!(
+                        "[alloy-agent] large pages: {} ({})",
+                        d.flag.as_deref().unwrap_or("disabled"),
+                        d.reason
+                    ))
+                    .await;
+                }
                 for warning in sandbox_launch.warnings() {
                     sink.emit(format!("[alloy-agent] sandbox warning: {warning}"))
                         .await;
@@ -2232,6 +2842,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(format!("waiting for port {}...", mc.port)),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -2239,6 +2851,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -2258,6 +2871,8 @@ impl ProcessManager {
                     .map(|v| v.trim())
                     .filter(|v| !v.is_empty())
                     .map(|v| v.to_string());
+                // Validated when the instance is saved.
+                let frp_security = frp::SecurityOptions::from_params(&params).unwrap_or_default();
                 let frp_instance_dir = dir.clone();
                 tokio::spawn({
                     let inner = inner.clone();
@@ -2266,7 +2881,8 @@ impl ProcessManager {
                     let frp_instance_dir = frp_instance_dir.clone();
                     async move {
                         let timeout = port_probe_timeout();
-                        let ok = wait_for_local_tcp_port(port, timeout).await;
+                        let bind = minecraft::server_ip(&frp_instance_dir);
+                        let ok = wait_for_local_tcp_port(bind, port, timeout).await;
 
                         let (pgid, should_kill) = {
                             let mut map = inner.lock().await;
@@ -2300,6 +2916,7 @@ impl ProcessManager {
                                     pgid,
                                     port,
                                     cfg,
+                                    frp_security,
                                 )
                                 .await
                                 {
@@ -2335,7 +2952,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_lease = port_lease;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -2351,11 +2973,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -2364,35 +3004,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -2415,7 +3043,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -2427,6 +3055,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -2477,6 +3118,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(format!("waiting for port {}...", mc.port)),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -2492,21 +3135,21 @@ impl ProcessManager {
 
                 let mc = minecraft_import::validate_params(&params)?;
 
-                let mc_port = port_alloc::allocate_tcp_port(mc.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some(
-                            "Pick another port, or leave it blank (0) to auto-assign a free port."
-                                .to_string(),
-                        ),
-                    )
+                // A pinned bind address wins over a server-ip set by hand.
+                let pinned = bind_pool::resolve(&id.0, &params)?;
+                let bind = pinned.or_else(|| minecraft::server_ip(&minecraft::instance_dir(&id.0)));
+                let mc_port = port_alloc::allocate_tcp_port_on(bind, mc.port).map_err(|e| {
+                    port_alloc::unavailable_error("port", PortProto::Tcp, mc.port, &e)
                 })?;
                 let mc = minecraft_import::ImportParams { port: mc_port, ..mc };
                 params.insert("port".to_string(), mc_port.to_string());
+                let port_lease = port_alloc::lease_on(
+                    PortProto::Tcp,
+                    bind,
+                    mc_port,
+                    &id.0,
+                    &format!("{} port", t.template_id),
+                )?;
                 let restart = parse_restart_config(&params);
 
                 let dir = minecraft::instance_dir(&id.0);
@@ -2539,6 +3182,9 @@ impl ProcessManager {
                         port: mc.port,
                     },
                 )?;
+                if let Some(ip) = pinned {
+                    minecraft::set_server_ip(&dir, ip)?;
+                }
 
                 let launch = minecraft_launch::resolve_launch_spec(&dir, mc.memory_mb).map_err(|e| {
                     crate::error_payload::anyhow(
@@ -2553,7 +3199,11 @@ impl ProcessManager {
                 })?;
 
                 let exec = launch.exec.clone();
-                let raw_args = launch.args.clone();
+                let mut raw_args = launch.args.clone();
+                let large_pages = minecraft_launch::large_pages_decision(&params);
+                if let Some(flag) = large_pages.as_ref().and_then(|d| d.flag.clone()) {
+                    raw_args.insert(0, flag);
+                }
 
                 let (mut cmd, sandbox_launch) = prepare_instance_command(
                     &id.0,
@@ -2584,11 +3234,20 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env: collect_safe_env(),
+                    large_pages: large_pages.clone(),
                 };
                 let _ = write_run_json(&dir, &run).await;
 
                 sink.emit(format!("[alloy-agent] sandbox: {}", sandbox_launch.summary()))
                     .await;
+                if let Some(d) = &large_pages {
+                    sink.emit(format!(
+                        "[alloy-agent] large pages: {} ({})",
+                        d.flag.as_deref().unwrap_or("disabled"),
+                        d.reason
+                    ))
+                    .await;
+                }
                 for warning in sandbox_launch.warnings() {
                     sink.emit(format!("[alloy-agent] sandbox warning: {warning}"))
                         .await;
@@ -2675,6 +3334,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(format!("waiting for port {}...", mc.port)),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -2682,6 +3343,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -2701,6 +3363,8 @@ impl ProcessManager {
                     .map(|v| v.trim())
                     .filter(|v| !v.is_empty())
                     .map(|v| v.to_string());
+                // Validated when the instance is saved.
+                let frp_security = frp::SecurityOptions::from_params(&params).unwrap_or_default();
                 let frp_instance_dir = dir.clone();
                 tokio::spawn({
                     let inner = inner.clone();
@@ -2709,7 +3373,8 @@ impl ProcessManager {
                     let frp_instance_dir = frp_instance_dir.clone();
                     async move {
                         let timeout = port_probe_timeout();
-                        let ok = wait_for_local_tcp_port(port, timeout).await;
+                        let bind = minecraft::server_ip(&frp_instance_dir);
+                        let ok = wait_for_local_tcp_port(bind, port, timeout).await;
 
                         let (pgid, should_kill) = {
                             let mut map = inner.lock().await;
@@ -2743,6 +3408,7 @@ impl ProcessManager {
                                     pgid,
                                     port,
                                     cfg,
+                                    frp_security,
                                 )
                                 .await
                                 {
@@ -2778,7 +3444,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_lease = port_lease;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -2794,11 +3465,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -2807,35 +3496,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -2858,7 +3535,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -2870,6 +3547,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -2920,6 +3610,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(format!("waiting for port {}...", mc.port)),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -2935,21 +3627,21 @@ impl ProcessManager {
 
                 let mc = minecraft_curseforge::validate_params(&params)?;
 
-                let mc_port = port_alloc::allocate_tcp_port(mc.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some(
-                            "Pick another port, or leave it blank (0) to auto-assign a free port."
-                                .to_string(),
-                        ),
-                    )
+                // A pinned bind address wins over a server-ip set by hand.
+                let pinned = bind_pool::resolve(&id.0, &params)?;
+                let bind = pinned.or_else(|| minecraft::server_ip(&minecraft::instance_dir(&id.0)));
+                let mc_port = port_alloc::allocate_tcp_port_on(bind, mc.port).map_err(|e| {
+                    port_alloc::unavailable_error("port", PortProto::Tcp, mc.port, &e)
                 })?;
                 let mc = minecraft_curseforge::CurseforgeParams { port: mc_port, ..mc };
                 params.insert("port".to_string(), mc_port.to_string());
+                let port_lease = port_alloc::lease_on(
+                    PortProto::Tcp,
+                    bind,
+                    mc_port,
+                    &id.0,
+                    &format!("{} port", t.template_id),
+                )?;
                 let restart = parse_restart_config(&params);
 
                 let dir = minecraft::instance_dir(&id.0);
@@ -2986,6 +3678,9 @@ impl ProcessManager {
                         port: mc.port,
                     },
                 )?;
+                if let Some(ip) = pinned {
+                    minecraft::set_server_ip(&dir, ip)?;
+                }
 
                 let launch = minecraft_launch::resolve_launch_spec(&dir, mc.memory_mb).map_err(|e| {
                     crate::error_payload::anyhow(
@@ -3000,7 +3695,11 @@ impl ProcessManager {
                 })?;
 
                 let exec = launch.exec.clone();
-                let raw_args = launch.args.clone();
+                let mut raw_args = launch.args.clone();
+                let large_pages = minecraft_launch::large_pages_decision(&params);
+                if let Some(flag) = large_pages.as_ref().and_then(|d| d.flag.clone()) {
+                    raw_args.insert(0, flag);
+                }
 
                 let (mut cmd, sandbox_launch) = prepare_instance_command(
                     &id.0,
@@ -3031,11 +3730,20 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env: collect_safe_env(),
+                    large_pages: large_pages.clone(),
                 };
                 let _ = write_run_json(&dir, &run).await;
 
                 sink.emit(format!("[alloy-agent] sandbox: {}", sandbox_launch.summary()))
                     .await;
+                if let Some(d) = &large_pages {
+                    sink.emit(format!(
+                        "[alloy-agent] large pages: {} ({})",
+                        d.flag.as_deref().unwrap_or("disabled"),
+                        d.reason
+                    ))
+                    .await;
+                }
                 for warning in sandbox_launch.warnings() {
                     sink.emit(format!("[alloy-agent] sandbox warning: {warning}"))
                         .await;
@@ -3125,6 +3833,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(format!("waiting for port {}...", mc.port)),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -3132,6 +3842,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -3151,6 +3862,8 @@ impl ProcessManager {
                     .map(|v| v.trim())
                     .filter(|v| !v.is_empty())
                     .map(|v| v.to_string());
+                // Validated when the instance is saved.
+                let frp_security = frp::SecurityOptions::from_params(&params).unwrap_or_default();
                 let frp_instance_dir = dir.clone();
                 tokio::spawn({
                     let inner = inner.clone();
@@ -3159,7 +3872,8 @@ impl ProcessManager {
                     let frp_instance_dir = frp_instance_dir.clone();
                     async move {
                         let timeout = port_probe_timeout();
-                        let ok = wait_for_local_tcp_port(port, timeout).await;
+                        let bind = minecraft::server_ip(&frp_instance_dir);
+                        let ok = wait_for_local_tcp_port(bind, port, timeout).await;
 
                         let (pgid, should_kill) = {
                             let mut map = inner.lock().await;
@@ -3193,6 +3907,7 @@ impl ProcessManager {
                                     pgid,
                                     port,
                                     cfg,
+                                    frp_security,
                                 )
                                 .await
                                 {
@@ -3228,7 +3943,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_lease = port_lease;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -3244,11 +3964,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -3257,35 +3995,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -3308,7 +4034,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -3320,6 +4046,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -3370,6 +4109,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(format!("waiting for port {}...", mc.port)),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -3386,34 +4127,13 @@ impl ProcessManager {
                 let tr = dst::validate_vanilla_params(&params)?;
 
                 let game_port = port_alloc::allocate_udp_port(tr.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some("Pick another port (or use 0 to auto-assign).".to_string()),
-                    )
+                    port_alloc::unavailable_error("port", PortProto::Udp, tr.port, &e)
                 })?;
                 let master_port = port_alloc::allocate_udp_port(tr.master_port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("master_port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid master_port",
-                        Some(fields),
-                        Some("Pick another port (or use 0 to auto-assign).".to_string()),
-                    )
+                    port_alloc::unavailable_error("master_port", PortProto::Udp, tr.master_port, &e)
                 })?;
                 let auth_port = port_alloc::allocate_udp_port(tr.auth_port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("auth_port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid auth_port",
-                        Some(fields),
-                        Some("Pick another port (or use 0 to auto-assign).".to_string()),
-                    )
+                    port_alloc::unavailable_error("auth_port", PortProto::Udp, tr.auth_port, &e)
                 })?;
 
                 // Best-effort: avoid obvious duplicates.
@@ -3433,6 +4153,11 @@ impl ProcessManager {
                     ..tr
                 };
                 params.insert("port".to_string(), game_port.to_string());
+                let port_leases = [
+                    port_alloc::lease(PortProto::Udp, game_port, &id.0, "dst:vanilla port")?,
+                    port_alloc::lease(PortProto::Udp, master_port, &id.0, "dst:vanilla master_port")?,
+                    port_alloc::lease(PortProto::Udp, auth_port, &id.0, "dst:vanilla auth_port")?,
+                ];
                 params.insert("master_port".to_string(), master_port.to_string());
                 params.insert("auth_port".to_string(), auth_port.to_string());
                 let restart = parse_restart_config(&params);
@@ -3510,6 +4235,7 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env: collect_safe_env(),
+                    large_pages: None,
                 };
                 let _ = write_run_json(&dir, &run).await;
 
@@ -3599,6 +4325,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some("starting...".to_string()),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -3606,6 +4334,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -3637,7 +4366,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_leases = port_leases;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -3653,11 +4387,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -3666,35 +4418,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -3717,7 +4457,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -3729,6 +4469,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -3779,6 +4532,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some("starting...".to_string()),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -3795,23 +4550,19 @@ impl ProcessManager {
                 let tr = terraria::validate_vanilla_params(&params)?;
 
                 let tr_port = port_alloc::allocate_tcp_port(tr.port).map_err(|e| {
-                    let mut fields = BTreeMap::new();
-                    fields.insert("port".to_string(), e.to_string());
-                    crate::error_payload::anyhow(
-                        "invalid_param",
-                        "invalid port",
-                        Some(fields),
-                        Some(
-                            "Pick another port, or leave it blank (0) to auto-assign a free port."
-                                .to_string(),
-                        ),
-                    )
+                    port_alloc::unavailable_error("port", PortProto::Tcp, tr.port, &e)
                 })?;
                 let tr = terraria::VanillaParams {
                     port: tr_port,
                     ..tr
                 };
                 params.insert("port".to_string(), tr_port.to_string());
+                let port_lease = port_alloc::lease(
+                    PortProto::Tcp,
+                    tr_port,
+                    &id.0,
+                    &format!("{} port", t.template_id),
+                )?;
                 let restart = parse_restart_config(&params);
 
                 let dir = terraria::instance_dir(&id.0);
@@ -3939,6 +4690,7 @@ impl ProcessManager {
                     cwd: sandbox_launch.cwd.display().to_string(),
                     params: redact_params(params.clone()),
                     env,
+                    large_pages: None,
                 };
                 let _ = write_run_json(&dir, &run).await;
 
@@ -4036,6 +4788,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(format!("waiting for port {}...", tr.port)),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin,
@@ -4043,6 +4797,7 @@ impl ProcessManager {
                             pgid,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -4063,6 +4818,8 @@ impl ProcessManager {
                     .map(|v| v.trim())
                     .filter(|v| !v.is_empty())
                     .map(|v| v.to_string());
+                // Validated when the instance is saved.
+                let frp_security = frp::SecurityOptions::from_params(&params).unwrap_or_default();
                 let frp_instance_dir = dir.clone();
                 tokio::spawn({
                     let inner = inner.clone();
@@ -4079,7 +4836,7 @@ impl ProcessManager {
                         } else {
                             port_probe_timeout()
                         };
-                        let ok = wait_for_local_tcp_port(port, timeout).await;
+                        let ok = wait_for_local_tcp_port(None, port, timeout).await;
 
                         let (pgid, should_kill) = {
                             let mut map = inner.lock().await;
@@ -4113,6 +4870,7 @@ impl ProcessManager {
                                     pgid,
                                     port,
                                     cfg,
+                                    frp_security,
                                 )
                                 .await
                                 {
@@ -4148,7 +4906,12 @@ impl ProcessManager {
                 let wait_sink = sink.clone();
                 let template_id = t.template_id.clone();
                 let params_for_restart = params.clone();
+                let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+                let exit_log_cursor = sink.cursor().await;
+                let exit_cwd = sandbox_launch.cwd.clone();
+                let crash_dir = root_dir.join("crashes");
                 tokio::spawn(async move {
+                    let _port_lease = port_lease;
                     let res = child.wait().await;
                     #[cfg(unix)]
                     if let Some(pgid) = process_pgid {
@@ -4164,11 +4927,29 @@ impl ProcessManager {
                         }
                     }
                     let runtime = tokio::time::Instant::now().duration_since(started);
+                    let oom_reason = match &res {
+                        Ok(status) => {
+                            detect_oom_kill(status, pid_u32, &oom_baseline).await
+                        }
+                        Err(_) => None,
+                    };
+                    let exit_log_tail = wait_sink
+                        .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                        .await;
+                    let hs_err = match &res {
+                        Ok(status) if !status.success() => {
+                            let since = std::time::SystemTime::now()
+                                .checked_sub(runtime)
+                                .unwrap_or(std::time::UNIX_EPOCH);
+                            process_exit::find_hs_err_file(&exit_cwd, since).await
+                        }
+                        _ => None,
+                    };
 
                     let mut restart_after: Option<Duration> = None;
                     let mut restart_attempt: u32 = 0;
 
-                    let (final_state, exit_code) = {
+                    let (final_state, exit_code, exit_reason, crash_record) = {
                         let mut map = inner.lock().await;
                         let Some(e) = map.get_mut(&id_str) else {
                             return;
@@ -4177,35 +4958,23 @@ impl ProcessManager {
                         e.stdin = None;
                         let stopping = matches!(e.state, ProcessState::Stopping);
 
-                        match res {
-                            Ok(status) => {
-                                e.exit_code = status.code();
-
-                                if stopping {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("stopped".to_string());
-                                } else if runtime < early_exit_threshold() {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited too quickly ({}ms)",
-                                        runtime.as_millis()
-                                    ));
-                                } else if status.success() {
-                                    e.state = ProcessState::Exited;
-                                    e.message = Some("exited".to_string());
-                                } else {
-                                    e.state = ProcessState::Failed;
-                                    e.message = Some(format!(
-                                        "exited with code {}",
-                                        status.code().unwrap_or_default()
-                                    ));
-                                }
-                            }
-                            Err(err) => {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!("wait failed: {err}"));
-                            }
-                        }
+                        apply_exit_result(
+                            e,
+                            &res,
+                            stopping,
+                            runtime,
+                            oom_reason.as_deref(),
+                            &exit_log_tail,
+                            hs_err.as_deref(),
+                        );
+                        let crash_record = crash_record_for(
+                            e,
+                            &id_str,
+                            &res,
+                            runtime,
+                            hs_err.as_deref(),
+                            &exit_log_tail,
+                        );
 
                         if !stopping {
                             let is_failure = matches!(e.state, ProcessState::Failed)
@@ -4228,7 +4997,7 @@ impl ProcessManager {
                             }
                         }
 
-                        (e.state, e.exit_code)
+                        (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                     };
 
                     wait_sink
@@ -4240,6 +5009,19 @@ impl ProcessManager {
                         ))
                         .await;
 
+                    if let Some(reason) = exit_reason.as_deref()
+                        && matches!(final_state, ProcessState::Failed)
+                    {
+                        tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                        wait_sink
+                            .emit(format!("[alloy-agent] exit reason: {reason}"))
+                            .await;
+                    }
+
+                    if let Some(record) = crash_record {
+                        save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                    }
+
                     if let Some(delay) = restart_after {
                         wait_sink
                             .emit(format!(
@@ -4290,6 +5072,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(format!("waiting for port {}...", tr.port)),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 });
             }
 
@@ -4327,6 +5111,7 @@ impl ProcessManager {
                 cwd: sandbox_launch.cwd.display().to_string(),
                 params: redact_params(params.clone()),
                 env: collect_safe_env(),
+                large_pages: None,
             };
             let _ = write_run_json(&root_dir, &run).await;
 
@@ -4412,6 +5197,8 @@ impl ProcessManager {
                         resources: None,
                         exit_code: None,
                         message: None,
+                        exit_category: None,
+                        exit_reason: None,
                         restart,
                         restart_attempts: reused_restart_attempts,
                         stdin,
@@ -4419,6 +5206,7 @@ impl ProcessManager {
                         pgid,
                         logs: logs.clone(),
                         log_file_tx: Some(log_tx.clone()),
+                        request_id: crate::trace::current(),
                     },
                 );
             }
@@ -4433,14 +5221,36 @@ impl ProcessManager {
             let wait_sink = sink.clone();
             let template_id = t.template_id.clone();
             let params_for_restart = params.clone();
+            let oom_baseline = OomBaseline::capture(sandbox_launch.cgroup_path()).await;
+            let exit_log_cursor = sink.cursor().await;
+            let exit_cwd = sandbox_launch.cwd.clone();
+            let crash_dir = root_dir.join("crashes");
             tokio::spawn(async move {
                 let res = child.wait().await;
                 let runtime = tokio::time::Instant::now().duration_since(started);
+                let oom_reason = match &res {
+                    Ok(status) => {
+                        detect_oom_kill(status, pid_u32, &oom_baseline).await
+                    }
+                    Err(_) => None,
+                };
+                let exit_log_tail = wait_sink
+                    .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                    .await;
+                let hs_err = match &res {
+                    Ok(status) if !status.success() => {
+                        let since = std::time::SystemTime::now()
+                            .checked_sub(runtime)
+                            .unwrap_or(std::time::UNIX_EPOCH);
+                        process_exit::find_hs_err_file(&exit_cwd, since).await
+                    }
+                    _ => None,
+                };
 
                 let mut restart_after: Option<Duration> = None;
                 let mut restart_attempt: u32 = 0;
 
-                let (final_state, exit_code) = {
+                let (final_state, exit_code, exit_reason, crash_record) = {
                     let mut map = inner.lock().await;
                     let Some(e) = map.get_mut(&id_str) else {
                         return;
@@ -4449,33 +5259,23 @@ impl ProcessManager {
                     e.stdin = None;
                     let stopping = matches!(e.state, ProcessState::Stopping);
 
-                    match res {
-                        Ok(status) => {
-                            e.exit_code = status.code();
-
-                            if stopping {
-                                e.state = ProcessState::Exited;
-                                e.message = Some("stopped".to_string());
-                            } else if runtime < early_exit_threshold() {
-                                e.state = ProcessState::Failed;
-                                e.message =
-                                    Some(format!("exited too quickly ({}ms)", runtime.as_millis()));
-                            } else if status.success() {
-                                e.state = ProcessState::Exited;
-                                e.message = Some("exited".to_string());
-                            } else {
-                                e.state = ProcessState::Failed;
-                                e.message = Some(format!(
-                                    "exited with code {}",
-                                    status.code().unwrap_or_default()
-                                ));
-                            }
-                        }
-                        Err(err) => {
-                            e.state = ProcessState::Failed;
-                            e.message = Some(format!("wait failed: {err}"));
-                        }
-                    }
+                    apply_exit_result(
+                        e,
+                        &res,
+                        stopping,
+                        runtime,
+                        oom_reason.as_deref(),
+                        &exit_log_tail,
+                        hs_err.as_deref(),
+                    );
+                    let crash_record = crash_record_for(
+                        e,
+                        &id_str,
+                        &res,
+                        runtime,
+                        hs_err.as_deref(),
+                        &exit_log_tail,
+                    );
 
                     if !stopping {
                         let is_failure = matches!(e.state, ProcessState::Failed)
@@ -4498,7 +5298,7 @@ impl ProcessManager {
                         }
                     }
 
-                    (e.state, e.exit_code)
+                    (e.state, e.exit_code, e.exit_reason.clone(), crash_record)
                 };
 
                 wait_sink
@@ -4510,6 +5310,19 @@ impl ProcessManager {
                     ))
                     .await;
 
+                if let Some(reason) = exit_reason.as_deref()
+                    && matches!(final_state, ProcessState::Failed)
+                {
+                    tracing::warn!(process_id = %id_str, reason, "instance exited abnormally");
+                    wait_sink
+                        .emit(format!("[alloy-agent] exit reason: {reason}"))
+                        .await;
+                }
+
+                if let Some(record) = crash_record {
+                    save_crash_journal(&wait_sink, &crash_dir, &record).await;
+                }
+
                 if let Some(delay) = restart_after {
                     wait_sink
                         .emit(format!(
@@ -4563,6 +5376,8 @@ impl ProcessManager {
                 exit_code: None,
                 message: None,
                 resources: None,
+                exit_category: None,
+                exit_reason: None,
             })
         }
         .await;
@@ -4587,6 +5402,8 @@ impl ProcessManager {
                             resources: None,
                             exit_code: None,
                             message: Some(msg.clone()),
+                            exit_category: None,
+                            exit_reason: None,
                             restart,
                             restart_attempts: reused_restart_attempts,
                             stdin: None,
@@ -4594,6 +5411,7 @@ impl ProcessManager {
                             pgid: None,
                             logs: logs.clone(),
                             log_file_tx: Some(log_tx.clone()),
+                            request_id: crate::trace::current(),
                         },
                     );
                 }
@@ -4606,6 +5424,8 @@ impl ProcessManager {
                     exit_code: None,
                     message: Some(msg),
                     resources: None,
+                    exit_category: None,
+                    exit_reason: None,
                 })
             }
         }
@@ -4627,6 +5447,8 @@ impl ProcessManager {
                 exit_code: e.exit_code,
                 message: e.message.clone(),
                 resources: e.resources.clone(),
+                exit_category: e.exit_category.clone(),
+                exit_reason: e.exit_reason.clone(),
             })
             .collect()
     }
@@ -4641,6 +5463,8 @@ impl ProcessManager {
             exit_code: e.exit_code,
             message: e.message.clone(),
             resources: e.resources.clone(),
+            exit_category: e.exit_category.clone(),
+            exit_reason: e.exit_reason.clone(),
         })
     }
 
@@ -4684,6 +5508,8 @@ impl ProcessManager {
                     exit_code: e.exit_code,
                     message: e.message.clone(),
                     resources: e.resources.clone(),
+                    exit_category: e.exit_category.clone(),
+                    exit_reason: e.exit_reason.clone(),
                 });
             }
 
@@ -4701,35 +5527,24 @@ impl ProcessManager {
             }
         }
 
-        let emit = |line: String,
-                    logs: Arc<Mutex<LogBuffer>>,
-                    log_tx: Option<mpsc::UnboundedSender<String>>| async move {
-            logs.lock().await.push_line(line.clone());
-            if let Some(tx) = log_tx {
-                let _ = tx.send(line);
-            }
+        let sink = LogSink {
+            buffer: logs.clone(),
+            file_tx: log_tx,
+            topic: format!("console:{process_id}"),
         };
 
-        emit(
-            format!(
-                "[alloy-agent] stop requested (timeout_ms={})",
-                timeout.as_millis()
-            ),
-            logs.clone(),
-            log_tx.clone(),
-        )
+        sink.emit(format!(
+            "[alloy-agent] stop requested (timeout_ms={})",
+            timeout.as_millis()
+        ))
         .await;
 
         docker_container = find_container_for_process(process_id).await;
         if let Some(container_id) = docker_container.as_deref() {
-            emit(
-                format!(
-                    "[alloy-agent] stop: docker container detected ({})",
-                    container_id.chars().take(12).collect::<String>()
-                ),
-                logs.clone(),
-                log_tx.clone(),
-            )
+            sink.emit(format!(
+                "[alloy-agent] stop: docker container detected ({})",
+                container_id.chars().take(12).collect::<String>()
+            ))
             .await;
         }
 
@@ -4738,12 +5553,8 @@ impl ProcessManager {
             let _ = stdin.flush().await;
             // Intentionally drop stdin so the child sees EOF.
             graceful_sent = true;
-            emit(
-                "[alloy-agent] stop: sent graceful stdin".to_string(),
-                logs.clone(),
-                log_tx.clone(),
-            )
-            .await;
+            sink.emit("[alloy-agent] stop: sent graceful stdin".to_string())
+                .await;
         }
 
         // If we didn't have a graceful command, send SIGTERM right away.
@@ -4752,20 +5563,12 @@ impl ProcessManager {
                 match docker_stop_container(container_id, timeout.as_secs().max(1)).await {
                     Ok(()) => {
                         term_sent = true;
-                        emit(
-                            "[alloy-agent] stop: requested docker stop".to_string(),
-                            logs.clone(),
-                            log_tx.clone(),
-                        )
-                        .await;
+                        sink.emit("[alloy-agent] stop: requested docker stop".to_string())
+                            .await;
                     }
                     Err(err) => {
-                        emit(
-                            format!("[alloy-agent] stop: docker stop failed: {err}"),
-                            logs.clone(),
-                            log_tx.clone(),
-                        )
-                        .await;
+                        sink.emit(format!("[alloy-agent] stop: docker stop failed: {err}"))
+                            .await;
                     }
                 }
             } else if let Some(pgid) = pgid {
@@ -4774,12 +5577,8 @@ impl ProcessManager {
                     libc::kill(-pgid, libc::SIGTERM);
                 }
                 term_sent = true;
-                emit(
-                    "[alloy-agent] stop: sent SIGTERM".to_string(),
-                    logs.clone(),
-                    log_tx.clone(),
-                )
-                .await;
+                sink.emit("[alloy-agent] stop: sent SIGTERM".to_string())
+                    .await;
             }
         }
 
@@ -4830,17 +5629,13 @@ impl ProcessManager {
                         let lower = line.to_ascii_lowercase();
                         if save_keywords.iter().any(|k| lower.contains(k)) {
                             save_confirmed = true;
-                            emit(
-                                format!(
-                                    "[alloy-agent] stop: world save confirmed ({})",
-                                    save_keywords
-                                        .iter()
-                                        .find(|k| lower.contains(*k))
-                                        .unwrap_or(&"matched")
-                                ),
-                                logs.clone(),
-                                log_tx.clone(),
-                            )
+                            sink.emit(format!(
+                                "[alloy-agent] stop: world save confirmed ({})",
+                                save_keywords
+                                    .iter()
+                                    .find(|k| lower.contains(*k))
+                                    .unwrap_or(&"matched")
+                            ))
                             .await;
                             let mut inner = self.inner.lock().await;
                             if let Some(e) = inner.get_mut(process_id) {
@@ -4851,11 +5646,9 @@ impl ProcessManager {
                     }
                 } else if !save_timeout_warned {
                     save_timeout_warned = true;
-                    emit(
+                    sink.emit(
                         "[alloy-agent] stop: world save not confirmed before timeout window; shutdown may risk data loss"
-                            .to_string(),
-                        logs.clone(),
-                        log_tx.clone(),
+                            .to_string()
                     )
                     .await;
                     let mut inner = self.inner.lock().await;
@@ -4874,19 +5667,15 @@ impl ProcessManager {
                     match docker_stop_container(container_id, remaining_secs).await {
                         Ok(()) => {
                             term_sent = true;
-                            emit(
+                            sink.emit(
                                 "[alloy-agent] stop: requested docker stop (late)".to_string(),
-                                logs.clone(),
-                                log_tx.clone(),
                             )
                             .await;
                         }
                         Err(err) => {
-                            emit(
-                                format!("[alloy-agent] stop: docker stop failed (late): {err}"),
-                                logs.clone(),
-                                log_tx.clone(),
-                            )
+                            sink.emit(format!(
+                                "[alloy-agent] stop: docker stop failed (late): {err}"
+                            ))
                             .await;
                         }
                     }
@@ -4896,12 +5685,8 @@ impl ProcessManager {
                         libc::kill(-pgid, libc::SIGTERM);
                     }
                     term_sent = true;
-                    emit(
-                        "[alloy-agent] stop: sent SIGTERM (late)".to_string(),
-                        logs.clone(),
-                        log_tx.clone(),
-                    )
-                    .await;
+                    sink.emit("[alloy-agent] stop: sent SIGTERM (late)".to_string())
+                        .await;
                 }
             }
 
@@ -4925,12 +5710,8 @@ impl ProcessManager {
                             killed = true;
                         }
                         Err(err) => {
-                            emit(
-                                format!("[alloy-agent] stop: docker kill failed: {err}"),
-                                logs.clone(),
-                                log_tx.clone(),
-                            )
-                            .await;
+                            sink.emit(format!("[alloy-agent] stop: docker kill failed: {err}"))
+                                .await;
                         }
                     }
                 }
@@ -4944,12 +5725,8 @@ impl ProcessManager {
                 }
 
                 if killed {
-                    emit(
-                        "[alloy-agent] stop: sent SIGKILL (timeout)".to_string(),
-                        logs.clone(),
-                        log_tx.clone(),
-                    )
-                    .await;
+                    sink.emit("[alloy-agent] stop: sent SIGKILL (timeout)".to_string())
+                        .await;
                 }
                 break;
             }
@@ -4980,4 +5757,354 @@ impl ProcessManager {
         let guard = logs.lock().await;
         Ok(guard.tail_after(cursor, limit))
     }
+
+    // Appends an agent line to the process's console output (buffer, log file and live topic).
+    pub async fn note(&self, process_id: &str, line: String) {
+        let sink = {
+            let inner = self.inner.lock().await;
+            let Some(e) = inner.get(process_id) else {
+                return;
+            };
+            LogSink {
+                buffer: e.logs.clone(),
+                file_tx: e.log_file_tx.clone(),
+                topic: format!("console:{process_id}"),
+            }
+        };
+        sink.emit(line).await;
+    }
+
+    // Writes one console line to the process's stdin. The pipe is taken out of the entry for the
+    // write so a child that stops reading can't wedge the manager lock; stop() racing with it
+    // just falls back to SIGTERM.
+    pub async fn send_input(&self, process_id: &str, line: &str) -> anyhow::Result<()> {
+        let line = line.trim_end_matches(['\r', '\n']);
+        if line.contains(['\r', '\n']) {
+            anyhow::bail!("console input must be a single line");
+        }
+        if line.len() > MAX_CONSOLE_INPUT_BYTES {
+            anyhow::bail!("console input is longer than {MAX_CONSOLE_INPUT_BYTES} bytes");
+        }
+
+        let mut stdin = {
+            let mut inner = self.inner.lock().await;
+            let e = inner
+                .get_mut(process_id)
+                .ok_or_else(|| anyhow::anyhow!("unknown process_id: {process_id}"))?;
+            if !matches!(e.state, ProcessState::Running | ProcessState::Starting) {
+                anyhow::bail!("process is not running");
+            }
+            e.stdin
+                .take()
+                .ok_or_else(|| anyhow::anyhow!("console input is not available for this process"))?
+        };
+
+        let write = async {
+            stdin.write_all(line.as_bytes()).await?;
+            stdin.write_all(b"\n").await?;
+            stdin.flush().await
+        };
+        let res = tokio::time::timeout(Duration::from_secs(5), write).await;
+
+        let mut inner = self.inner.lock().await;
+        if let Some(e) = inner.get_mut(process_id)
+            && e.stdin.is_none()
+            && matches!(e.state, ProcessState::Running | ProcessState::Starting)
+        {
+            e.stdin = Some(stdin);
+        }
+        match res {
+            Ok(Ok(())) => Ok(()),
+            Ok(Err(err)) => Err(anyhow::anyhow!("write console input: {err}")),
+            Err(_) => Err(anyhow::anyhow!(
+                "console input timed out (process not reading stdin)"
+            )),
+        }
+    }
+
+    // Checkpoints a running Minecraft server with CRIU (see `criu`); it is left exited with the
+    // message "hibernated" and resumes on its next start. Errors leave the server running, so the
+    // caller can fall back to stop().
+    pub async fn hibernate(&self, process_id: &str) -> anyhow::Result<(ProcessStatus, criu::Meta)> {
+        if let Some(reason) = tokio::task::spawn_blocking(criu::unsupported_reason).await? {
+            anyhow::bail!(reason);
+        }
+        let dir = minecraft::instance_dir(process_id);
+        let raw = tokio::fs::read(dir.join("run.json"))
+            .await
+            .context("read run.json")?;
+        let run: RunLaunchMeta = serde_json::from_slice(&raw).context("parse run.json")?;
+        if run.container_name.is_some()
+            || Path::new(&run.exec)
+                .file_name()
+                .is_some_and(|n| n == "bwrap")
+        {
+            anyhow::bail!(
+                "checkpoints need a natively launched server (ALLOY_SANDBOX_MODE=native)"
+            );
+        }
+        let port = run
+            .params
+            .get("port")
+            .and_then(|p| p.parse::<u16>().ok())
+            .unwrap_or(0);
+
+        let (pid, template_id, sink) = {
+            let mut inner = self.inner.lock().await;
+            let e = inner
+                .get_mut(process_id)
+                .ok_or_else(|| anyhow::anyhow!("unknown process_id: {process_id}"))?;
+            if !matches!(e.state, ProcessState::Running) {
+                anyhow::bail!("process is not running");
+            }
+            if !e.template_id.0.starts_with("minecraft:") {
+                anyhow::bail!("checkpoints are only supported for Minecraft servers");
+            }
+            let pid = e.pid.ok_or_else(|| anyhow::anyhow!("process has no pid"))?;
+            e.state = ProcessState::Stopping;
+            e.message = Some("hibernating".to_string());
+            let sink = LogSink {
+                buffer: e.logs.clone(),
+                file_tx: e.log_file_tx.clone(),
+                topic: format!("console:{process_id}"),
+            };
+            (pid, e.template_id.0.clone(), sink)
+        };
+
+        sink.emit(format!(
+            "[alloy-agent] hibernate: checkpointing pid {pid} with criu"
+        ))
+        .await;
+        let started = tokio::time::Instant::now();
+        let dump = tokio::task::spawn_blocking({
+            let dir = dir.clone();
+            let template_id = template_id.clone();
+            move || criu::dump(&dir, pid, &template_id, port)
+        })
+        .await?;
+        let meta = match dump {
+            Ok(meta) => meta,
+            Err(err) => {
+                // CRIU lets the process continue when a dump fails.
+                {
+                    let mut inner = self.inner.lock().await;
+                    if let Some(e) = inner.get_mut(process_id)
+                        && e.pid == Some(pid)
+                        && matches!(e.state, ProcessState::Stopping)
+                    {
+                        e.state = ProcessState::Running;
+                        e.message = None;
+                    }
+                }
+                sink.emit(format!("[alloy-agent] hibernate failed: {err:#}"))
+                    .await;
+                return Err(err);
+            }
+        };
+
+        // The exit handler records the exit as a requested stop; wait for it, then say why.
+        let deadline = tokio::time::Instant::now() + Duration::from_secs(10);
+        while tokio::time::Instant::now() < deadline {
+            if self
+                .get_status(process_id)
+                .await
+                .is_some_and(|st| matches!(st.state, ProcessState::Exited | ProcessState::Failed))
+            {
+                break;
+            }
+            tokio::time::sleep(Duration::from_millis(100)).await;
+        }
+        {
+            let mut inner = self.inner.lock().await;
+            if let Some(e) = inner.get_mut(process_id)
+                && e.pid == Some(pid)
+            {
+                e.state = ProcessState::Exited;
+                e.message = Some("hibernated".to_string());
+                e.exit_category = Some(process_exit::ExitCategory::Stopped.as_str().to_string());
+                e.exit_reason = Some("hibernated (checkpointed with CRIU)".to_string());
+            }
+        }
+        sink.emit(format!(
+            "[alloy-agent] hibernated in {}ms ({} bytes of checkpoint images)",
+            started.elapsed().as_millis(),
+            meta.size_bytes
+        ))
+        .await;
+
+        let status = self
+            .get_status(process_id)
+            .await
+            .ok_or_else(|| anyhow::anyhow!("unknown process_id: {process_id}"))?;
+        Ok((status, meta))
+    }
+
+    // Restores a server hibernated by hibernate(). Restored runs are not restarted automatically
+    // and their exit code is criu's. On error the checkpoint is left for the caller to discard.
+    pub async fn resume(
+        &self,
+        process_id: &str,
+        params: &BTreeMap<String, String>,
+    ) -> anyhow::Result<ProcessStatus> {
+        if let Some(reason) = tokio::task::spawn_blocking(criu::unsupported_reason).await? {
+            anyhow::bail!(reason);
+        }
+        let dir = minecraft::instance_dir(process_id);
+        let meta = criu::read_meta(&dir).context("no checkpoint")?;
+
+        let (logs, restart_attempts) = {
+            let inner = self.inner.lock().await;
+            match inner.get(process_id) {
+                Some(e)
+                    if matches!(
+                        e.state,
+                        ProcessState::Running | ProcessState::Starting | ProcessState::Stopping
+                    ) =>
+                {
+                    anyhow::bail!("process_id already running: {process_id}");
+                }
+                Some(e) => (e.logs.clone(), e.restart_attempts),
+                None => (Arc::new(Mutex::new(LogBuffer::default())), 0),
+            }
+        };
+        let (sink, log_tx) = console_sink(process_id, &dir, logs.clone());
+        sink.emit(format!(
+            "[alloy-agent] resuming from checkpoint (pid {} at {} unix ms)",
+            meta.pid, meta.created_at_unix_ms
+        ))
+        .await;
+
+        let bind = bind_pool::resolve(process_id, params)?.or_else(|| minecraft::server_ip(&dir));
+        let port_lease = port_alloc::lease_on(
+            PortProto::Tcp,
+            bind,
+            meta.port,
+            process_id,
+            &format!("{} port", meta.template_id),
+        )?;
+
+        let started = tokio::time::Instant::now();
+        let restored = match criu::restore(&dir).await {
+            Ok(v) => v,
+            Err(err) => {
+                sink.emit(format!("[alloy-agent] resume failed: {err:#}"))
+                    .await;
+                return Err(err);
+            }
+        };
+        let pid = restored.pid;
+        let pgid = restored.pgid;
+
+        let out = restored.stdout;
+        tokio::spawn({
+            let sink = sink.clone();
+            async move {
+                let mut lines = BufReader::new(out).lines();
+                while let Ok(Some(line)) = lines.next_line().await {
+                    sink.emit(format!("[stdout] {line}")).await;
+                }
+            }
+        });
+        let err = restored.stderr;
+        tokio::spawn({
+            let sink = sink.clone();
+            async move {
+                let mut lines = BufReader::new(err).lines();
+                while let Ok(Some(line)) = lines.next_line().await {
+                    sink.emit(format!("[stderr] {line}")).await;
+                }
+            }
+        });
+
+        {
+            let mut inner = self.inner.lock().await;
+            inner.insert(
+                process_id.to_string(),
+                ProcessEntry {
+                    template_id: ProcessTemplateId(meta.template_id.clone()),
+                    state: ProcessState::Running,
+                    pid: Some(pid),
+                    resources: None,
+                    exit_code: None,
+                    message: None,
+                    exit_category: None,
+                    exit_reason: None,
+                    restart: parse_restart_config(params),
+                    restart_attempts,
+                    stdin: Some(restored.stdin),
+                    graceful_stdin: templates::find_template(&meta.template_id)
+                        .and_then(|t| t.graceful_stdin),
+                    pgid: Some(pgid),
+                    logs: logs.clone(),
+                    log_file_tx: Some(log_tx),
+                    request_id: crate::trace::current(),
+                },
+            );
+        }
+        self.spawn_resource_sampler(process_id.to_string(), pid);
+        sink.emit(format!(
+            "[alloy-agent] resumed pid {pid} in {}ms",
+            started.elapsed().as_millis()
+        ))
+        .await;
+
+        if let Some(cfg) = params
+            .get("frp_config")
+            .map(|v| v.trim())
+            .filter(|v| !v.is_empty())
+            && let Err(e) = start_frpc_sidecar(
+                sink.clone(),
+                dir.clone(),
+                pgid,
+                meta.port,
+                cfg.to_string(),
+                frp::SecurityOptions::from_params(params).unwrap_or_default(),
+            )
+            .await
+        {
+            sink.emit(format!("[alloy-agent] frpc start failed: {e}"))
+                .await;
+        }
+
+        let inner = self.inner.clone();
+        let id = process_id.to_string();
+        let mut child = restored.child;
+        let exit_log_cursor = sink.cursor().await;
+        tokio::spawn(async move {
+            let _port_lease = port_lease;
+            let res = child.wait().await;
+            #[cfg(unix)]
+            unsafe {
+                libc::kill(-pgid, libc::SIGTERM);
+            }
+            let runtime = started.elapsed();
+            let exit_log_tail = sink
+                .recent_after(exit_log_cursor, crash_journal::JOURNAL_LINES)
+                .await;
+            let (state, exit_code) = {
+                let mut map = inner.lock().await;
+                let Some(e) = map.get_mut(&id) else {
+                    return;
+                };
+                if e.pid != Some(pid) {
+                    return;
+                }
+                e.stdin = None;
+                let stopping = matches!(e.state, ProcessState::Stopping);
+                apply_exit_result(e, &res, stopping, runtime, None, &exit_log_tail, None);
+                (e.state, e.exit_code)
+            };
+            sink.emit(format!(
+                "[alloy-agent] process exited: state={:?} exit_code={:?} runtime_ms={}",
+                state,
+                exit_code,
+                runtime.as_millis()
+            ))
+            .await;
+        });
+
+        self.get_status(process_id)
+            .await
+            .ok_or_else(|| anyhow::anyhow!("unknown process_id: {process_id}"))
+    }
 }
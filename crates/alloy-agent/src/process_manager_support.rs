@@ -193,3 +193,237 @@ pub(crate) async fn read_proc_rss_bytes(pid: u32) -> Option<u64> {
 pub(crate) async fn read_proc_rss_bytes(_pid: u32) -> Option<u64> {
     None
 }
+
+#[cfg_attr(not(target_os = "linux"), allow(dead_code))]
+fn parse_memory_events_oom_kill(raw: &str) -> u64 {
+    raw.lines()
+        .find_map(|line| {
+            let (k, v) = line.split_once(' ')?;
+            if k == "oom_kill" {
+                v.trim().parse::<u64>().ok()
+            } else {
+                None
+            }
+        })
+        .unwrap_or(0)
+}
+
+// /dev/kmsg records look like `<prio>,<seq>,<usec since boot>,<flags>;<message>`. Records
+// older than `since_us` are skipped so a recycled PID can't match an earlier run's OOM kill.
+#[cfg_attr(not(target_os = "linux"), allow(dead_code))]
+fn kmsg_mentions_oom_kill(raw: &str, pid: u32, since_us: u64) -> bool {
+    let killed = format!("Killed process {pid} ");
+    let task = format!(",pid={pid},");
+    raw.lines().any(|line| {
+        let Some((header, msg)) = line.split_once(';') else {
+            return false;
+        };
+        let Some(ts) = header.split(',').nth(2).and_then(|v| v.parse::<u64>().ok()) else {
+            return false;
+        };
+        ts >= since_us
+            && (msg.contains(&killed) || (msg.contains("oom-kill:") && msg.contains(&task)))
+    })
+}
+
+#[cfg(target_os = "linux")]
+fn monotonic_now_us() -> u64 {
+    let mut ts = libc::timespec {
+        tv_sec: 0,
+        tv_nsec: 0,
+    };
+    // SAFETY: clock_gettime only writes to the timespec we pass in.
+    if unsafe { libc::clock_gettime(libc::CLOCK_MONOTONIC, &mut ts) } != 0 {
+        return 0;
+    }
+    (ts.tv_sec as u64)
+        .saturating_mul(1_000_000)
+        .saturating_add(ts.tv_nsec as u64 / 1_000)
+}
+
+// OOM evidence as it stood when a run started. The cgroup's `oom_kill` counter is cumulative and
+// the cgroup is reused across runs, so only kills counted after the start belong to this run.
+#[cfg_attr(not(target_os = "linux"), allow(dead_code))]
+#[derive(Debug, Clone, Default)]
+pub(crate) struct OomBaseline {
+    cgroup_path: Option<std::path::PathBuf>,
+    oom_kills: u64,
+    started_us: u64,
+}
+
+impl OomBaseline {
+    #[cfg(target_os = "linux")]
+    pub(crate) async fn capture(cgroup_path: Option<&std::path::Path>) -> Self {
+        let mut oom_kills = 0;
+        if let Some(path) = cgroup_path
+            && let Ok(raw) = tokio::fs::read_to_string(path.join("memory.events")).await
+        {
+            oom_kills = parse_memory_events_oom_kill(&raw);
+        }
+        Self {
+            cgroup_path: cgroup_path.map(std::path::Path::to_path_buf),
+            oom_kills,
+            started_us: monotonic_now_us(),
+        }
+    }
+
+    #[cfg(not(target_os = "linux"))]
+    pub(crate) async fn capture(_cgroup_path: Option<&std::path::Path>) -> Self {
+        Self::default()
+    }
+
+    #[cfg_attr(not(target_os = "linux"), allow(dead_code))]
+    fn new_cgroup_kills(&self, raw: &str) -> bool {
+        parse_memory_events_oom_kill(raw) > self.oom_kills
+    }
+}
+
+#[cfg(target_os = "linux")]
+fn read_kmsg_best_effort() -> Option<String> {
+    use std::io::Read;
+    use std::os::unix::fs::OpenOptionsExt;
+
+    // /dev/kmsg returns one record per read(); stop at EAGAIN (end of buffer).
+    let mut f = std::fs::OpenOptions::new()
+        .read(true)
+        .custom_flags(libc::O_NONBLOCK)
+        .open("/dev/kmsg")
+        .ok()?;
+    let mut out = String::new();
+    let mut buf = vec![0u8; 8192];
+    for _ in 0..20_000 {
+        match f.read(&mut buf) {
+            Ok(0) => break,
+            Ok(n) => {
+                out.push_str(&String::from_utf8_lossy(&buf[..n]));
+                if !out.ends_with('\n') {
+                    out.push('\n');
+                }
+            }
+            Err(e) if e.raw_os_error() == Some(libc::EPIPE) => continue,
+            Err(_) => break,
+        }
+    }
+    Some(out)
+}
+
+// Best-effort check whether a SIGKILL'd instance was taken out by the kernel OOM killer.
+// Returns a short reason when OOM is confirmed via cgroup memory.events or the kernel log.
+#[cfg(target_os = "linux")]
+pub(crate) async fn detect_oom_kill(
+    status: &std::process::ExitStatus,
+    pid: Option<u32>,
+    baseline: &OomBaseline,
+) -> Option<String> {
+    use std::os::unix::process::ExitStatusExt;
+
+    // `docker run` reports a SIGKILL'd container as exit code 137.
+    let killed = status.signal() == Some(libc::SIGKILL) || status.code() == Some(137);
+    if !killed {
+        return None;
+    }
+
+    if let Some(path) = baseline.cgroup_path.as_deref()
+        && let Ok(raw) = tokio::fs::read_to_string(path.join("memory.events")).await
+        && baseline.new_cgroup_kills(&raw)
+    {
+        return Some("cgroup memory limit reached".to_string());
+    }
+
+    let pid = pid?;
+    let kmsg = tokio::task::spawn_blocking(read_kmsg_best_effort)
+        .await
+        .ok()
+        .flatten()?;
+    if kmsg_mentions_oom_kill(&kmsg, pid, baseline.started_us) {
+        return Some("host memory exhausted".to_string());
+    }
+    None
+}
+
+#[cfg(not(target_os = "linux"))]
+pub(crate) async fn detect_oom_kill(
+    _status: &std::process::ExitStatus,
+    _pid: Option<u32>,
+    _baseline: &OomBaseline,
+) -> Option<String> {
+    None
+}
+
+fn env_flag(name: &str) -> bool {
+    matches!(
+        std::env::var(name)
+            .unwrap_or_default()
+            .trim()
+            .to_ascii_lowercase()
+            .as_str(),
+        "1" | "true" | "yes" | "on"
+    )
+}
+
+// Agent self-protection against memory pressure:
+// - ALLOY_AGENT_MLOCK=true locks the agent's pages in RAM so it stays responsive under swap.
+// - ALLOY_AGENT_OOM_SCORE_ADJ makes the kernel OOM killer prefer instances over the agent.
+// Instances inherit the agent's oom_score_adj unless they set `oom_score_adj` themselves.
+#[cfg(target_os = "linux")]
+pub(crate) fn protect_agent_memory() -> Vec<String> {
+    let mut warnings = Vec::new();
+
+    if env_flag("ALLOY_AGENT_MLOCK") {
+        let rc = unsafe { libc::mlockall(libc::MCL_CURRENT | libc::MCL_FUTURE) };
+        if rc == -1 {
+            warnings.push(format!(
+                "mlockall failed: {} (needs CAP_IPC_LOCK or a higher RLIMIT_MEMLOCK)",
+                std::io::Error::last_os_error()
+            ));
+        }
+    }
+
+    if let Ok(raw) = std::env::var("ALLOY_AGENT_OOM_SCORE_ADJ") {
+        match raw.trim().parse::<i32>() {
+            Ok(v) if (-1000..=1000).contains(&v) => {
+                if let Err(e) = std::fs::write("/proc/self/oom_score_adj", format!("{v}\n")) {
+                    warnings.push(format!("failed to set agent oom_score_adj={v}: {e}"));
+                }
+            }
+            _ => warnings.push(format!(
+                "invalid ALLOY_AGENT_OOM_SCORE_ADJ={raw:?} (expected -1000..1000)"
+            )),
+        }
+    }
+
+    warnings
+}
+
+#[cfg(not(target_os = "linux"))]
+pub(crate) fn protect_agent_memory() -> Vec<String> {
+    Vec::new()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn oom_detection_ignores_kills_from_earlier_runs() {
+        // The cgroup was already OOM-killed once before this run started.
+        let baseline = OomBaseline {
+            cgroup_path: None,
+            oom_kills: 1,
+            started_us: 5_000_000,
+        };
+        assert!(!baseline.new_cgroup_kills("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"));
+        assert!(baseline.new_cgroup_kills("low 0\nhigh 0\nmax 4\noom 2\noom_kill 2\n"));
+
+        let old = "3,100,4000000,-;Out of memory: Killed process 42 (java) total-vm:1kB\n";
+        let new = "3,200,6000000,-;Out of memory: Killed process 42 (java) total-vm:1kB\n";
+        assert!(!kmsg_mentions_oom_kill(old, 42, baseline.started_us));
+        assert!(kmsg_mentions_oom_kill(new, 42, baseline.started_us));
+        assert!(!kmsg_mentions_oom_kill(new, 43, baseline.started_us));
+        assert!(kmsg_mentions_oom_kill(
+            "6,201,6000001,-;oom-kill:constraint=CONSTRAINT_NONE,task=java,pid=42,uid=0\n",
+            42,
+            baseline.started_us,
+        ));
+    }
+}
@@ -1,20 +1,88 @@
-use std::{collections::BTreeMap, time::Duration};
+use std::{
+    collections::BTreeMap,
+    pin::Pin,
+    sync::atomic::{AtomicU64, Ordering},
+    time::Duration,
+};
 
 use alloy_proto::agent_v1::process_service_server::{ProcessService, ProcessServiceServer};
 use alloy_proto::agent_v1::{
-    CacheEntry, ClearCacheRequest, ClearCacheResponse, GetCacheStatsRequest, GetCacheStatsResponse,
-    GetStatusRequest, GetStatusResponse, GetWarmTemplateProgressRequest,
-    GetWarmTemplateProgressResponse, ListProcessesRequest, ListProcessesResponse,
-    ListTemplatesRequest, ListTemplatesResponse, ProcessResources, ProcessState, ProcessStatus,
-    ProcessTemplate, StartFromTemplateRequest, StartFromTemplateResponse, StopProcessRequest,
-    StopProcessResponse, TailLogsRequest, TailLogsResponse, WarmTemplateCacheRequest,
-    WarmTemplateCacheResponse,
+    CacheEntry, ClearCacheRequest, ClearCacheResponse, ConsoleClientMessage, ConsoleServerMessage,
+    GetCacheStatsRequest, GetCacheStatsResponse, GetStatusRequest, GetStatusResponse,
+    GetWarmTemplateProgressRequest, GetWarmTemplateProgressResponse, ListProcessesRequest,
+    ListProcessesResponse, ListTemplatesRequest, ListTemplatesResponse, ProcessResources,
+    ProcessState, ProcessStatus, ProcessTemplate, SendInputRequest, SendInputResponse,
+    StartFromTemplateRequest, StartFromTemplateResponse, StopProcessRequest, StopProcessResponse,
+    TailLogsRequest, TailLogsResponse, WarmTemplateCacheRequest, WarmTemplateCacheResponse,
+    console_client_message,
 };
-use tonic::{Request, Response, Status};
+use futures_util::Stream;
+use tokio::sync::mpsc;
+use tonic::{Request, Response, Status, Streaming};
 
+use crate::console_audit::{self, ConsoleInputRecord};
+use crate::console_guard::{self, Attribution};
 use crate::process_manager::ProcessManager;
+use crate::topics;
 use crate::{minecraft_download, terraria_download};
 
+const MAX_CONSOLE_HISTORY_LINES: u32 = 1000;
+
+static NEXT_CONSOLE_SESSION: AtomicU64 = AtomicU64::new(1);
+
+fn new_console_session_id() -> String {
+    let n = NEXT_CONSOLE_SESSION.fetch_add(1, Ordering::Relaxed);
+    format!("console-{:x}-{n}", console_audit::now_unix_ms())
+}
+
+// What the agent itself knows about the caller: the socket peer's uid for alloyctl, else the
+// TCP address (normally control).
+fn peer_actor<T>(request: &Request<T>) -> String {
+    #[cfg(unix)]
+    if let Some(cred) = request
+        .extensions()
+        .get::<tonic::transport::server::UdsConnectInfo>()
+        .and_then(|info| info.peer_cred)
+    {
+        return format!("uid:{}", cred.uid());
+    }
+    match request.remote_addr() {
+        Some(addr) => format!("tcp:{addr}"),
+        None => "unknown".to_string(),
+    }
+}
+
+// Writes one console line for `actor`, naming them in the game first when
+// ALLOY_CONSOLE_ATTRIBUTION asks for it.
+async fn send_attributed(
+    manager: &ProcessManager,
+    process_id: &str,
+    actor: &str,
+    line: &str,
+) -> anyhow::Result<()> {
+    let attribution = console_guard::attribution();
+    if attribution == Attribution::Say
+        && let Some(status) = manager.get_status(process_id).await
+        && let Some(say) = console_guard::say_line(&status.template_id.0, actor, line)
+    {
+        manager.send_input(process_id, &say).await?;
+    }
+    manager.send_input(process_id, line).await?;
+    if attribution != Attribution::Off {
+        let note = format!("[alloy-agent] {actor}> {}", line.trim());
+        manager.note(process_id, note).await;
+    }
+    Ok(())
+}
+
+fn console_message(line: String, dropped: u64) -> ConsoleServerMessage {
+    ConsoleServerMessage {
+        line,
+        dropped,
+        ..Default::default()
+    }
+}
+
 #[derive(Debug, Clone)]
 pub struct ProcessApi {
     manager: ProcessManager,
@@ -52,6 +120,8 @@ pub fn map_status(s: alloy_process::ProcessStatus) -> ProcessStatus {
             read_bytes: r.read_bytes,
             write_bytes: r.write_bytes,
         }),
+        exit_category: s.exit_category.unwrap_or_default(),
+        exit_reason: s.exit_reason.unwrap_or_default(),
     }
 }
 
@@ -149,16 +219,29 @@ impl ProcessService for ProcessApi {
                                 format!("failed to resolve minecraft server jar: {e}"),
                             );
                         }
-                        Status::invalid_argument(crate::error_payload::encode(
-                            "download_failed",
+                        let code = crate::upstream::error_code(&e);
+                        let message = crate::error_payload::encode(
+                            code,
                             format!("failed to resolve minecraft server jar: {e}"),
                             None,
                             Some(
                                 "Check network connectivity to Mojang piston-meta endpoints."
                                     .to_string(),
                             ),
-                        ))
+                        );
+                        // Outages surface as UNAVAILABLE so control requeues the download.
+                        if code == "upstream_unavailable" {
+                            Status::unavailable(message)
+                        } else {
+                            Status::invalid_argument(message)
+                        }
                     })?;
+                if resolved.is_stale {
+                    tracing::warn!(
+                        version = %resolved.version_id,
+                        "Mojang API unavailable; resolved from cached metadata"
+                    );
+                }
 
                 report_progress(
                     "download",
@@ -837,6 +920,185 @@ impl ProcessService for ProcessApi {
             next_cursor: next.to_string(),
         }))
     }
+
+    async fn send_input(
+        &self,
+        request: Request<SendInputRequest>,
+    ) -> Result<Response<SendInputResponse>, Status> {
+        let peer = peer_actor(&request);
+        let req = request.into_inner();
+        // Control passes the panel user along; keep the transport peer next to it.
+        let actor = if req.actor.trim().is_empty() {
+            peer
+        } else {
+            format!("{} via {peer}", req.actor.trim())
+        };
+
+        if !req.confirmed
+            && let Some(command) = console_guard::guarded(&req.line)
+        {
+            console_audit::record(&ConsoleInputRecord {
+                at_unix_ms: console_audit::now_unix_ms(),
+                process_id: &req.process_id,
+                actor: &actor,
+                session_id: &req.session_id,
+                line: &req.line,
+                ok: false,
+                unconfirmed: true,
+                request_id: crate::trace::current(),
+            })
+            .await;
+            return Err(console_guard::confirmation_required(command));
+        }
+
+        // In the game the panel user is enough; the transport peer stays in the audit log.
+        let sender = if req.actor.trim().is_empty() {
+            actor.as_str()
+        } else {
+            req.actor.trim()
+        };
+        let res = send_attributed(&self.manager, &req.process_id, sender, &req.line).await;
+        console_audit::record(&ConsoleInputRecord {
+            at_unix_ms: console_audit::now_unix_ms(),
+            process_id: &req.process_id,
+            actor: &actor,
+            session_id: &req.session_id,
+            line: &req.line,
+            ok: res.is_ok(),
+            unconfirmed: false,
+            request_id: crate::trace::current(),
+        })
+        .await;
+        res.map_err(|e| Status::failed_precondition(e.to_string()))?;
+
+        Ok(Response::new(SendInputResponse {}))
+    }
+
+    type AttachConsoleStream =
+        Pin<Box<dyn Stream<Item = Result<ConsoleServerMessage, Status>> + Send + 'static>>;
+
+    async fn attach_console(
+        &self,
+        request: Request<Streaming<ConsoleClientMessage>>,
+    ) -> Result<Response<Self::AttachConsoleStream>, Status> {
+        let peer = peer_actor(&request);
+        let mut inbound = request.into_inner();
+
+        let open = match inbound.message().await? {
+            Some(ConsoleClientMessage {
+                msg: Some(console_client_message::Msg::Open(open)),
+            }) => open,
+            _ => return Err(Status::invalid_argument("first message must be open")),
+        };
+        let process_id = open.process_id.trim().to_string();
+        if self.manager.get_status(&process_id).await.is_none() {
+            return Err(Status::not_found(format!(
+                "unknown process_id: {process_id}"
+            )));
+        }
+        let actor = if open.actor.trim().is_empty() {
+            peer
+        } else {
+            format!("{peer} ({})", open.actor.trim())
+        };
+
+        // Subscribe before reading history so no line falls between the two.
+        let mut output = topics::subscribe(&format!("console:{process_id}"), None)
+            .map_err(Status::invalid_argument)?;
+        let history = if open.history_lines > 0 {
+            let limit = open.history_lines.min(MAX_CONSOLE_HISTORY_LINES) as usize;
+            self.manager
+                .tail_logs(&process_id, 0, limit)
+                .await
+                .map(|(lines, _)| lines)
+                .unwrap_or_default()
+        } else {
+            Vec::new()
+        };
+
+        let session_id = new_console_session_id();
+        tracing::info!(%process_id, %actor, %session_id, "console attached");
+
+        let (tx, rx) = mpsc::channel::<Result<ConsoleServerMessage, Status>>(256);
+        let _ = tx
+            .send(Ok(ConsoleServerMessage {
+                session_id: session_id.clone(),
+                ..Default::default()
+            }))
+            .await;
+        for line in history {
+            let _ = tx.send(Ok(console_message(line, 0))).await;
+        }
+
+        // Output: the console topic. The broker drops (and counts) lines for a client that
+        // stops reading, so a stalled terminal never holds up the server.
+        let output_tx = tx.clone();
+        let output_task = tokio::spawn(async move {
+            while let Some(ev) = output.recv().await {
+                let line = match ev.data {
+                    serde_json::Value::String(s) => s,
+                    other => other.to_string(),
+                };
+                if output_tx
+                    .send(Ok(console_message(line, ev.dropped)))
+                    .await
+                    .is_err()
+                {
+                    break;
+                }
+            }
+        });
+
+        // Input: every line is written to stdin and recorded against this session. Guarded
+        // commands go through once the same line is sent twice.
+        let manager = self.manager.clone();
+        crate::trace::spawn(async move {
+            let mut confirm = console_guard::SessionConfirm::default();
+            while let Ok(Some(msg)) = inbound.message().await {
+                let Some(console_client_message::Msg::Input(line)) = msg.msg else {
+                    continue;
+                };
+                let guarded = console_guard::guarded(&line);
+                let unconfirmed =
+                    guarded.is_some() && !confirm.confirm(&line, std::time::Instant::now());
+                let res = if unconfirmed {
+                    Err(anyhow::anyhow!(
+                        "`{}` needs confirmation: send the same line again within {}s to run it",
+                        guarded.unwrap_or_default(),
+                        console_guard::CONFIRM_WINDOW.as_secs()
+                    ))
+                } else {
+                    send_attributed(&manager, &process_id, &actor, &line).await
+                };
+                console_audit::record(&ConsoleInputRecord {
+                    at_unix_ms: console_audit::now_unix_ms(),
+                    process_id: &process_id,
+                    actor: &actor,
+                    session_id: &session_id,
+                    line: &line,
+                    ok: res.is_ok(),
+                    unconfirmed,
+                    request_id: crate::trace::current(),
+                })
+                .await;
+                if let Err(err) = res {
+                    let msg = ConsoleServerMessage {
+                        error: err.to_string(),
+                        ..Default::default()
+                    };
+                    if tx.send(Ok(msg)).await.is_err() {
+                        break;
+                    }
+                }
+            }
+            tracing::info!(%process_id, %session_id, "console detached");
+            output_task.abort();
+        });
+
+        Ok(Response::new(Box::pin(
+            tokio_stream::wrappers::ReceiverStream::new(rx),
+        )))
+    }
 }
 
 pub fn server(manager: ProcessManager) -> ProcessServiceServer<ProcessApi> {
@@ -19,6 +19,10 @@ pub struct SandboxLimits {
     pub pids_limit: u64,
     pub nofile_limit: u64,
     pub cpu_millicores: u64,
+    // Host CPUs the instance is pinned to. Empty means no pinning.
+    pub cpu_set: Vec<usize>,
+    // Kernel OOM killer preference (-1000..1000). None inherits the agent's value.
+    pub oom_score_adj: Option<i32>,
 }
 
 impl SandboxLimits {
@@ -44,7 +48,13 @@ impl SandboxLimits {
             format!("{}m", self.cpu_millicores)
         };
 
-        format!("mem={mem_mb} pids={pids} nofile={nofile} cpu={cpu}")
+        let cpuset = if self.cpu_set.is_empty() {
+            "all".to_string()
+        } else {
+            format_cpu_set(&self.cpu_set)
+        };
+
+        format!("mem={mem_mb} pids={pids} nofile={nofile} cpu={cpu} cpuset={cpuset}")
     }
 
     pub fn apply_pre_exec(&self) -> io::Result<()> {
@@ -81,6 +91,19 @@ impl SandboxLimits {
             if self.nofile_limit > 0 {
                 set_rlimit(libc::RLIMIT_NOFILE, self.nofile_limit)?;
             }
+
+            if !self.cpu_set.is_empty() {
+                let mut set: libc::cpu_set_t = unsafe { std::mem::zeroed() };
+                for cpu in &self.cpu_set {
+                    unsafe { libc::CPU_SET(*cpu, &mut set) };
+                }
+                let rc = unsafe {
+                    libc::sched_setaffinity(0, std::mem::size_of::<libc::cpu_set_t>(), &set)
+                };
+                if rc == -1 {
+                    return Err(io::Error::last_os_error());
+                }
+            }
         }
 
         Ok(())
@@ -136,22 +159,41 @@ impl SandboxLaunch {
         !self.is_docker_mode()
     }
 
+    pub fn cgroup_path(&self) -> Option<&Path> {
+        self.cgroup_path.as_deref()
+    }
+
     pub fn attach_pid(&self, pid: u32) -> Option<String> {
         #[cfg(target_os = "linux")]
         {
-            let Some(path) = &self.cgroup_path else {
-                return None;
-            };
-
-            let procs = path.join("cgroup.procs");
-            if let Err(e) = std::fs::write(&procs, format!("{pid}\n")) {
-                return Some(format!(
-                    "failed to attach pid {} to cgroup {}: {}",
-                    pid,
-                    path.display(),
-                    e
+            let mut problems = Vec::<String>::new();
+
+            // Docker applies --oom-score-adj itself; the local pid is only the docker client.
+            if let Some(adj) = self.limits.oom_score_adj
+                && !self.is_docker_mode()
+                && let Err(e) =
+                    std::fs::write(format!("/proc/{pid}/oom_score_adj"), format!("{adj}\n"))
+            {
+                problems.push(format!(
+                    "failed to set oom_score_adj={adj} for pid {pid}: {e} (lowering needs CAP_SYS_RESOURCE)"
                 ));
             }
+
+            if let Some(path) = &self.cgroup_path {
+                let procs = path.join("cgroup.procs");
+                if let Err(e) = std::fs::write(&procs, format!("{pid}\n")) {
+                    problems.push(format!(
+                        "failed to attach pid {} to cgroup {}: {}",
+                        pid,
+                        path.display(),
+                        e
+                    ));
+                }
+            }
+
+            if !problems.is_empty() {
+                return Some(problems.join("; "));
+            }
         }
 
         None
@@ -204,6 +246,11 @@ fn choose_mode(
 ) -> anyhow::Result<(Mode, Vec<String>)> {
     let mut warnings = Vec::<String>::new();
 
+    // The fake server of test mode is this binary, which containers don't have.
+    if crate::test_mode::enabled() {
+        return Ok((Mode::Native, warnings));
+    }
+
     let forced = std::env::var("ALLOY_SANDBOX_FORCE_MODE")
         .ok()
         .map(|v| v.trim().to_ascii_lowercase())
@@ -359,7 +406,102 @@ fn resolve_limits(params: &BTreeMap<String, String>) -> SandboxLimits {
         pids_limit,
         nofile_limit,
         cpu_millicores,
+        cpu_set: Vec::new(),
+        oom_score_adj: parse_string_param(params, "oom_score_adj")
+            .and_then(|v| v.parse::<i32>().ok())
+            .map(|v| v.clamp(-1000, 1000)),
+    }
+}
+
+// Accepts the taskset/cpuset list format, e.g. "0-3,6,8-9".
+fn parse_cpu_set(raw: &str) -> Result<Vec<usize>, String> {
+    // CPU_SETSIZE on Linux.
+    const MAX_CPUS: usize = 1024;
+
+    let mut out = BTreeSet::<usize>::new();
+    for part in raw.split(',') {
+        let part = part.trim();
+        if part.is_empty() {
+            continue;
+        }
+        let (lo, hi) = match part.split_once('-') {
+            Some((a, b)) => (a.trim(), b.trim()),
+            None => (part, part),
+        };
+        let lo = lo
+            .parse::<usize>()
+            .map_err(|_| format!("invalid cpu {lo:?} (expected e.g. 0-3,6)"))?;
+        let hi = hi
+            .parse::<usize>()
+            .map_err(|_| format!("invalid cpu {hi:?} (expected e.g. 0-3,6)"))?;
+        if lo > hi {
+            return Err(format!("invalid cpu range {part:?}"));
+        }
+        if hi >= MAX_CPUS {
+            return Err(format!("cpu {hi} is out of range (max {})", MAX_CPUS - 1));
+        }
+        out.extend(lo..=hi);
+    }
+    Ok(out.into_iter().collect())
+}
+
+fn format_cpu_set(cpus: &[usize]) -> String {
+    let mut parts = Vec::<String>::new();
+    let mut i = 0;
+    while i < cpus.len() {
+        let start = cpus[i];
+        let mut end = start;
+        while i + 1 < cpus.len() && cpus[i + 1] == end + 1 {
+            i += 1;
+            end = cpus[i];
+        }
+        if start == end {
+            parts.push(start.to_string());
+        } else {
+            parts.push(format!("{start}-{end}"));
+        }
+        i += 1;
+    }
+    parts.join(",")
+}
+
+fn host_cpu_count() -> Option<usize> {
+    #[cfg(unix)]
+    {
+        let n = unsafe { libc::sysconf(libc::_SC_NPROCESSORS_CONF) };
+        if n > 0 {
+            return Some(n as usize);
+        }
+    }
+    std::thread::available_parallelism().ok().map(|n| n.get())
+}
+
+fn resolve_cpu_set(params: &BTreeMap<String, String>) -> anyhow::Result<Vec<usize>> {
+    let Some(raw) = parse_string_param(params, "cpu_affinity") else {
+        return Ok(Vec::new());
+    };
+
+    let invalid = |msg: String| {
+        let mut fields = BTreeMap::new();
+        fields.insert("cpu_affinity".to_string(), msg);
+        crate::error_payload::anyhow(
+            "invalid_param",
+            "invalid cpu affinity",
+            Some(fields),
+            Some("Use a CPU list like 0-3,6 (see `nproc` on the host).".to_string()),
+        )
+    };
+
+    let cpus = parse_cpu_set(raw).map_err(invalid)?;
+    if let (Some(max), Some(count)) = (cpus.last(), host_cpu_count())
+        && *max >= count
+    {
+        return Err(invalid(format!(
+            "cpu {max} does not exist on this host (cpus: 0-{})",
+            count.saturating_sub(1)
+        )));
     }
+    Ok(cpus)
 }
 
 fn normalize_path(path: &Path) -> PathBuf {
@@ -648,6 +790,7 @@ fn maybe_add_docker_env(args: &mut Vec<String>, key: &str) {
     }
 }
 
+#[tracing::instrument(name = "sandbox.docker_ready", skip_all, fields(image = %image))]
 fn ensure_docker_ready(image: &str, docker_data_volume: Option<&str>) -> anyhow::Result<()> {
     let socket_path = std::env::var("ALLOY_SANDBOX_DOCKER_SOCKET")
         .ok()
@@ -760,6 +903,14 @@ fn build_docker_args(
         out.push("--cpus".to_string());
         out.push(format!("{:.3}", limits.cpu_millicores as f64 / 1000.0));
     }
+    if !limits.cpu_set.is_empty() {
+        out.push("--cpuset-cpus".to_string());
+        out.push(format_cpu_set(&limits.cpu_set));
+    }
+    if let Some(adj) = limits.oom_score_adj {
+        out.push("--oom-score-adj".to_string());
+        out.push(adj.to_string());
+    }
     if limits.nofile_limit > 0 {
         out.push("--ulimit".to_string());
         out.push(format!("nofile={0}:{0}", limits.nofile_limit));
@@ -863,11 +1014,23 @@ fn sanitize_cgroup_name(raw: &str) -> String {
 mod tests {
     use super::{
         detect_docker_data_volume_from_mountinfo, extract_docker_volume_from_mount_root,
-        mount_path_from_mountinfo, mountpoint_prefix_matches,
+        format_cpu_set, mount_path_from_mountinfo, mountpoint_prefix_matches, parse_cpu_set,
         resolve_host_mount_path_from_mountinfo,
     };
     use std::path::Path;
 
+    #[test]
+    fn cpu_set_parse_and_format_roundtrip() {
+        let cpus = parse_cpu_set("6, 0-3,2,8-9").unwrap();
+        assert_eq!(cpus, vec![0, 1, 2, 3, 6, 8, 9]);
+        assert_eq!(format_cpu_set(&cpus), "0-3,6,8-9");
+
+        assert!(parse_cpu_set("3-1").is_err());
+        assert!(parse_cpu_set("a").is_err());
+        assert!(parse_cpu_set("4096").is_err());
+        assert!(parse_cpu_set("").unwrap().is_empty());
+    }
+
     #[test]
     fn mountpoint_prefix_matching_works() {
         assert!(mountpoint_prefix_matches("/data", "/data"));
@@ -942,6 +1105,7 @@ mod tests {
 }
 
 #[cfg(target_os = "linux")]
+#[tracing::instrument(name = "sandbox.cgroup", skip(limits))]
 fn try_prepare_cgroup(process_id: &str, limits: &SandboxLimits) -> Result<Option<PathBuf>, String> {
     if !env_bool("ALLOY_SANDBOX_ENABLE_CGROUPS", true) {
         return Ok(None);
@@ -997,6 +1161,23 @@ fn try_prepare_cgroup(
     Ok(None)
 }
 
+// The mode a launch with default params would use, with any fallback warnings. Used by the
+// self-test, so it does not touch cgroups or containers.
+pub fn default_mode() -> anyhow::Result<(&'static str, Vec<String>)> {
+    let (mode, warnings) = choose_mode(env_bool("ALLOY_SANDBOX_DEFAULT_ENABLED", true), None)?;
+    let name = match mode {
+        Mode::Native => "native",
+        Mode::Bwrap => "bwrap",
+        Mode::Docker => "docker",
+    };
+    Ok((name, warnings))
+}
+
+#[tracing::instrument(
+    name = "sandbox.prepare",
+    skip_all,
+    fields(process_id = %process_id, template_id = %template_id)
+)]
 pub fn prepare_launch(
     process_id: &str,
     template_id: &str,
@@ -1014,7 +1195,11 @@ pub fn prepare_launch(
 
     let mode_override = parse_string_param(params, "sandbox_mode");
     let (mode, mut warnings) = choose_mode(sandbox_enabled, mode_override)?;
-    let limits = resolve_limits(params);
+    let mut limits = resolve_limits(params);
+    limits.cpu_set = resolve_cpu_set(params)?;
+    if !limits.cpu_set.is_empty() && !cfg!(target_os = "linux") {
+        warnings.push("cpu_affinity is only supported on Linux; ignoring".to_string());
+    }
 
     let mut cgroup_path = None;
     if sandbox_enabled && !matches!(mode, Mode::Docker) {
@@ -0,0 +1,363 @@
+use std::{
+    io::ErrorKind,
+    time::{Duration, Instant},
+};
+
+// Self-test for fresh installs: runs the things a server start depends on (sandbox, disk, Java,
+// frpc, outbound HTTPS, clock, port binding) in isolation, so a failure points at the broken
+// piece instead of surfacing as a failed start.
+
+const DISK_PROBE_BYTES: usize = 1024 * 1024;
+const COMMAND_TIMEOUT: Duration = Duration::from_secs(10);
+const HTTP_TIMEOUT: Duration = Duration::from_secs(10);
+pub const DEFAULT_PORT_START: u16 = 40_000;
+const PORT_COUNT: u16 = 5;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Outcome {
+    Pass,
+    // Works, but with a fallback or an optional piece missing.
+    Warn,
+    Fail,
+    Skipped,
+}
+
+impl Outcome {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Outcome::Pass => "pass",
+            Outcome::Warn => "warn",
+            Outcome::Fail => "fail",
+            Outcome::Skipped => "skipped",
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct Check {
+    pub name: String,
+    pub outcome: Outcome,
+    pub message: String,
+    pub duration_ms: u64,
+}
+
+#[derive(Debug, Clone)]
+pub struct Options {
+    pub skip_network: bool,
+    pub port_start: u16,
+}
+
+fn timed(name: &str, started: Instant, (outcome, message): (Outcome, String)) -> Check {
+    Check {
+        name: name.to_string(),
+        outcome,
+        message,
+        duration_ms: started.elapsed().as_millis() as u64,
+    }
+}
+
+fn sandbox() -> (Outcome, String) {
+    match crate::sandbox::default_mode() {
+        Ok((mode, warnings)) if warnings.is_empty() => (Outcome::Pass, format!("mode={mode}")),
+        Ok((mode, warnings)) => (
+            Outcome::Warn,
+            format!("mode={mode}: {}", warnings.join("; ")),
+        ),
+        Err(e) => (Outcome::Fail, format!("{e:#}")),
+    }
+}
+
+fn disk() -> (Outcome, String) {
+    let dir = crate::minecraft::data_root().join("tmp");
+    let path = dir.join(format!(
+        "selftest-{}-{}",
+        std::process::id(),
+        crate::console_audit::now_unix_ms()
+    ));
+    let data: Vec<u8> = (0..DISK_PROBE_BYTES).map(|i| (i % 251) as u8).collect();
+    let started = Instant::now();
+    let res = (|| -> std::io::Result<()> {
+        std::fs::create_dir_all(&dir)?;
+        {
+            let mut f = std::fs::File::create(&path)?;
+            std::io::Write::write_all(&mut f, &data)?;
+            f.sync_all()?;
+        }
+        if std::fs::read(&path)? != data {
+            return Err(std::io::Error::other("read back different bytes"));
+        }
+        std::fs::remove_file(&path)
+    })();
+    match res {
+        Ok(()) => (
+            Outcome::Pass,
+            format!(
+                "wrote, read and deleted 1 MiB under {} in {} ms",
+                dir.display(),
+                started.elapsed().as_millis()
+            ),
+        ),
+        Err(e) => {
+            let _ = std::fs::remove_file(&path);
+            (Outcome::Fail, format!("{}: {e}", dir.display()))
+        }
+    }
+}
+
+// First line of the command's combined output, or why it could not run.
+async fn first_output_line(exec: &str, args: &[&str]) -> Result<String, String> {
+    let run = tokio::process::Command::new(exec)
+        .args(args)
+        .stdin(std::process::Stdio::null())
+        .kill_on_drop(true)
+        .output();
+    let out = match tokio::time::timeout(COMMAND_TIMEOUT, run).await {
+        Ok(Ok(out)) => out,
+        Ok(Err(e)) if e.kind() == ErrorKind::NotFound => {
+            return Err(format!("`{exec}` not found"));
+        }
+        Ok(Err(e)) => return Err(format!("failed to run `{exec}`: {e}")),
+        Err(_) => return Err(format!("`{exec}` did not exit within 10s")),
+    };
+    let text = format!(
+        "{}{}",
+        String::from_utf8_lossy(&out.stdout),
+        String::from_utf8_lossy(&out.stderr)
+    );
+    Ok(text
+        .lines()
+        .map(str::trim)
+        .find(|l| !l.is_empty())
+        .unwrap_or_default()
+        .to_string())
+}
+
+async fn java() -> (Outcome, String) {
+    let line = match first_output_line("java", &["-version"]).await {
+        Ok(line) => line,
+        Err(e) => return (Outcome::Fail, e),
+    };
+    match crate::process_manager::parse_java_major_from_version_line(&line) {
+        Ok(major) => (Outcome::Pass, format!("Java {major} ({line})")),
+        Err(e) => (Outcome::Fail, format!("{e:#}")),
+    }
+}
+
+// frpc is only needed for FRP tunnels, so a missing binary is a warning.
+async fn frpc() -> (Outcome, String) {
+    let exec = std::env::var("ALLOY_FRPC_PATH").unwrap_or_else(|_| "frpc".to_string());
+    match first_output_line(&exec, &["-v"]).await {
+        Ok(version) if !version.is_empty() => (Outcome::Pass, format!("{exec} {version}")),
+        Ok(_) => (Outcome::Warn, format!("{exec} printed no version")),
+        Err(e) => (Outcome::Warn, format!("{e}; FRP tunnels will not start")),
+    }
+}
+
+fn http_targets() -> Vec<(&'static str, String)> {
+    let mut targets = vec![
+        ("mojang", crate::minecraft_download::manifest_url()),
+        ("modrinth", "https://api.modrinth.com/v2".to_string()),
+        (
+            "fabric",
+            "https://meta.fabricmc.net/v2/versions/installer".to_string(),
+        ),
+        (
+            "curseforge",
+            crate::minecraft_curseforge::CF_API_BASE.to_string(),
+        ),
+    ];
+    if let Some(url) = crate::log_paste::service_url() {
+        targets.push(("paste", url));
+    }
+    targets
+}
+
+// Any HTTP response counts: the point is DNS, routing, TLS and proxies, not the API's answer.
+async fn reach(client: reqwest::Client, url: String) -> (Outcome, String) {
+    match client.get(&url).send().await {
+        Ok(resp) => (
+            Outcome::Pass,
+            format!("{url}: HTTP {}", resp.status().as_u16()),
+        ),
+        Err(e) if e.is_timeout() => (Outcome::Fail, format!("{url}: timed out")),
+        Err(e) => {
+            let mut msg = e.to_string();
+            let mut source = std::error::Error::source(&e);
+            while let Some(s) = source {
+                msg = format!("{msg}: {s}");
+                source = s.source();
+            }
+            (Outcome::Fail, format!("{url}: {msg}"))
+        }
+    }
+}
+
+async fn clock() -> (Outcome, String) {
+    let Some(res) = crate::clock::refresh().await else {
+        return (Outcome::Skipped, "disabled by ALLOY_NTP_SERVER".to_string());
+    };
+    let (timezone, utc_offset) = crate::clock::timezone();
+    match res {
+        Ok(skew) => {
+            let msg = format!(
+                "{} ms off {} (round trip {} ms), timezone {timezone} (UTC{:+})",
+                skew.offset_ms,
+                skew.server,
+                skew.round_trip_ms,
+                utc_offset / 3600
+            );
+            let outcome = match skew.offset_ms.abs() {
+                n if n < crate::clock::WARN_OFFSET_MS => Outcome::Pass,
+                n if n < crate::clock::FAIL_OFFSET_MS => Outcome::Warn,
+                _ => Outcome::Fail,
+            };
+            (outcome, msg)
+        }
+        // Blocked NTP is common behind firewalls and doesn't stop servers from running.
+        Err(e) => (Outcome::Warn, e),
+    }
+}
+
+fn ports(start: u16) -> (Outcome, String) {
+    let end = start.saturating_add(PORT_COUNT - 1);
+    let mut bound = Vec::new();
+    let mut in_use = Vec::new();
+    for port in start..=end {
+        let tcp = crate::netaddr::check_tcp(None, port);
+        let udp = crate::netaddr::check_udp(None, port);
+        match (tcp, udp) {
+            (Ok(_), Ok(_)) => bound.push(port),
+            (Err(e), _) | (_, Err(e)) if e.kind() == ErrorKind::AddrInUse => in_use.push(port),
+            (Err(e), _) | (_, Err(e)) => {
+                return (Outcome::Fail, format!("bind port {port}: {e}"));
+            }
+        }
+    }
+    let in_use = if in_use.is_empty() {
+        String::new()
+    } else {
+        format!(
+            "; in use: {}",
+            in_use
+                .iter()
+                .map(u16::to_string)
+                .collect::<Vec<_>>()
+                .join(",")
+        )
+    };
+    if bound.is_empty() {
+        (
+            Outcome::Fail,
+            format!("no port in {start}-{end} could be bound{in_use}"),
+        )
+    } else {
+        (
+            Outcome::Pass,
+            format!(
+                "bound TCP and UDP on {}/{} ports in {start}-{end}{in_use}",
+                bound.len(),
+                PORT_COUNT
+            ),
+        )
+    }
+}
+
+pub async fn run(opts: &Options) -> Vec<Check> {
+    let mut checks = Vec::new();
+
+    let started = Instant::now();
+    let result = tokio::task::spawn_blocking(sandbox)
+        .await
+        .unwrap_or_else(|e| (Outcome::Fail, e.to_string()));
+    checks.push(timed("sandbox", started, result));
+
+    let started = Instant::now();
+    let result = tokio::task::spawn_blocking(disk)
+        .await
+        .unwrap_or_else(|e| (Outcome::Fail, e.to_string()));
+    checks.push(timed("disk", started, result));
+
+    let started = Instant::now();
+    checks.push(timed("java", started, java().await));
+
+    let started = Instant::now();
+    checks.push(timed("frpc", started, frpc().await));
+
+    let targets = http_targets();
+    if opts.skip_network {
+        for (name, url) in targets {
+            checks.push(Check {
+                name: format!("network:{name}"),
+                outcome: Outcome::Skipped,
+                message: url,
+                duration_ms: 0,
+            });
+        }
+    } else {
+        let client = reqwest::Client::builder()
+            .user_agent(concat!("alloy-agent/", env!("CARGO_PKG_VERSION")))
+            .timeout(HTTP_TIMEOUT)
+            .build();
+        match client {
+            Ok(client) => {
+                // Probed concurrently, so unreachable hosts cost one timeout in total.
+                let mut set = tokio::task::JoinSet::new();
+                for (i, (name, url)) in targets.into_iter().enumerate() {
+                    let client = client.clone();
+                    set.spawn(async move {
+                        let started = Instant::now();
+                        let check = timed(
+                            &format!("network:{name}"),
+                            started,
+                            reach(client, url).await,
+                        );
+                        (i, check)
+                    });
+                }
+                let mut results = set.join_all().await;
+                results.sort_by_key(|(i, _)| *i);
+                checks.extend(results.into_iter().map(|(_, c)| c));
+            }
+            Err(e) => checks.push(Check {
+                name: "network".to_string(),
+                outcome: Outcome::Fail,
+                message: format!("failed to build HTTP client: {e}"),
+                duration_ms: 0,
+            }),
+        }
+    }
+
+    let started = Instant::now();
+    if opts.skip_network {
+        checks.push(timed(
+            "clock",
+            started,
+            (Outcome::Skipped, "offline".to_string()),
+        ));
+    } else {
+        checks.push(timed("clock", started, clock().await));
+    }
+
+    let started = Instant::now();
+    let start = opts.port_start;
+    let result = tokio::task::spawn_blocking(move || ports(start))
+        .await
+        .unwrap_or_else(|e| (Outcome::Fail, e.to_string()));
+    checks.push(timed("ports", started, result));
+
+    checks
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn reports_ports_held_elsewhere() {
+        let held = std::net::TcpListener::bind(("0.0.0.0", 0)).unwrap();
+        let port = held.local_addr().unwrap().port();
+        let (outcome, message) = ports(port);
+        assert!(message.contains(&format!("in use: {port}")), "{message}");
+        assert_ne!(outcome, Outcome::Warn);
+    }
+}
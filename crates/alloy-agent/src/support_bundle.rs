@@ -0,0 +1,343 @@
+use std::{
+    collections::BTreeMap,
+    io::{Read, Seek, Write},
+    path::{Path, PathBuf},
+};
+
+use anyhow::Context;
+
+use crate::log_paste::Redactor;
+
+// Support bundle: one zip with the agent's own logs, instance metadata, resolved start commands,
+// console tails, version info and diagnostics, for attaching to bug reports. Every file goes
+// through the same redactor as log pastes before it is written.
+
+const DIR: &str = "support-bundles";
+// Older bundles are pruned so repeated requests can't fill the disk.
+const KEEP: usize = 5;
+const AGENT_LOG_FILES: usize = 3;
+const AGENT_LOG_BYTES: u64 = 2 * 1024 * 1024;
+const CONSOLE_TAIL_BYTES: u64 = 4 * 1024 * 1024;
+pub const DEFAULT_CONSOLE_LINES: usize = 300;
+pub const MAX_CONSOLE_LINES: usize = 5_000;
+// Largest archive returned in the RPC response; gRPC's default message limit is 4 MiB.
+pub const INLINE_MAX_BYTES: u64 = 3 * 1024 * 1024;
+// Copied as they are besides redaction; run.json holds the resolved start command.
+const INSTANCE_FILES: &[&str] = &["run.json", "server-jar.json"];
+
+#[derive(Debug, Clone)]
+pub struct Options {
+    // Empty means every instance.
+    pub instance_ids: Vec<String>,
+    pub console_lines: usize,
+    pub keep_ips: bool,
+    pub diagnostics: serde_json::Value,
+}
+
+#[derive(Debug, Clone)]
+pub struct Bundle {
+    pub path: PathBuf,
+    pub size_bytes: u64,
+    pub files: Vec<String>,
+    pub redactions: usize,
+}
+
+struct Writer {
+    zip: zip::ZipWriter<std::fs::File>,
+    opts: zip::write::SimpleFileOptions,
+    redactor: Redactor,
+    files: Vec<String>,
+    redactions: usize,
+}
+
+impl Writer {
+    fn text(&mut self, name: &str, text: &str) -> anyhow::Result<()> {
+        self.zip.start_file(name, self.opts)?;
+        for line in text.lines() {
+            let (line, n) = self.redactor.line(line);
+            self.redactions += n;
+            self.zip.write_all(line.as_bytes())?;
+            self.zip.write_all(b"\n")?;
+        }
+        self.files.push(name.to_string());
+        Ok(())
+    }
+
+    fn json(&mut self, name: &str, value: &serde_json::Value) -> anyhow::Result<()> {
+        self.text(name, &serde_json::to_string_pretty(value)?)
+    }
+}
+
+// The last `max_bytes` of a file, starting at a line boundary.
+fn tail_text(path: &Path, max_bytes: u64) -> std::io::Result<String> {
+    let mut f = std::fs::File::open(path)?;
+    let len = f.metadata()?.len();
+    let start = len.saturating_sub(max_bytes);
+    f.seek(std::io::SeekFrom::Start(start))?;
+    let mut raw = Vec::new();
+    f.read_to_end(&mut raw)?;
+    let text = String::from_utf8_lossy(&raw);
+    Ok(match (start, text.find('\n')) {
+        (0, _) | (_, None) => text.into_owned(),
+        (_, Some(i)) => text[i + 1..].to_string(),
+    })
+}
+
+fn tail_lines(text: &str, n: usize) -> String {
+    let lines: Vec<&str> = text.lines().collect();
+    lines[lines.len().saturating_sub(n)..].join("\n")
+}
+
+fn is_safe_id(id: &str) -> bool {
+    !id.is_empty()
+        && id
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_' || c == '.')
+        && id != "."
+        && id != ".."
+}
+
+fn instance_ids(instances: &Path, requested: &[String]) -> anyhow::Result<Vec<String>> {
+    if !requested.is_empty() {
+        for id in requested {
+            anyhow::ensure!(is_safe_id(id), "invalid instance id: {id}");
+        }
+        return Ok(requested.to_vec());
+    }
+    let mut ids: Vec<String> = std::fs::read_dir(instances)
+        .map(|rd| {
+            rd.flatten()
+                .filter(|de| de.path().is_dir())
+                .map(|de| de.file_name().to_string_lossy().into_owned())
+                .collect()
+        })
+        .unwrap_or_default();
+    ids.sort();
+    Ok(ids)
+}
+
+struct InstanceMeta {
+    // instance.json with secret params replaced.
+    config: serde_json::Value,
+    // The original params, so their secret values can be redacted wherever else they appear.
+    params: BTreeMap<String, String>,
+}
+
+fn read_instance(dir: &Path) -> Option<InstanceMeta> {
+    let raw = std::fs::read(dir.join("instance.json")).ok()?;
+    let mut config: serde_json::Value = serde_json::from_slice(&raw).ok()?;
+    let params: BTreeMap<String, String> = config
+        .get("params")
+        .cloned()
+        .and_then(|p| serde_json::from_value(p).ok())
+        .unwrap_or_default();
+    if let Some(obj) = config.as_object_mut() {
+        obj.insert(
+            "params".to_string(),
+            serde_json::to_value(crate::process_manager::redact_params(params.clone())).ok()?,
+        );
+    }
+    Some(InstanceMeta { config, params })
+}
+
+fn newest_crash(dir: &Path) -> Option<PathBuf> {
+    let mut records: Vec<PathBuf> = std::fs::read_dir(dir.join("crashes"))
+        .ok()?
+        .flatten()
+        .map(|de| de.path())
+        .filter(|p| {
+            p.file_name()
+                .map(|n| n.to_string_lossy())
+                .is_some_and(|n| n.starts_with("crash-") && n.ends_with(".json"))
+        })
+        .collect();
+    records.sort();
+    records.pop()
+}
+
+fn java_version() -> String {
+    std::process::Command::new("java")
+        .arg("-version")
+        .output()
+        .map(|out| {
+            String::from_utf8_lossy(&out.stderr)
+                .lines()
+                .next()
+                .unwrap_or_default()
+                .to_string()
+        })
+        .unwrap_or_else(|e| format!("unavailable: {e}"))
+}
+
+fn versions() -> serde_json::Value {
+    serde_json::json!({
+        "agent_version": env!("CARGO_PKG_VERSION"),
+        "os": std::env::consts::OS,
+        "arch": std::env::consts::ARCH,
+        "kernel": std::fs::read_to_string("/proc/sys/kernel/osrelease")
+            .map(|s| s.trim().to_string())
+            .unwrap_or_default(),
+        "java": java_version(),
+    })
+}
+
+fn agent_logs(logs: &Path) -> Vec<PathBuf> {
+    let mut files: Vec<PathBuf> = std::fs::read_dir(logs)
+        .map(|rd| {
+            rd.flatten()
+                .map(|de| de.path())
+                .filter(|p| {
+                    p.file_name()
+                        .is_some_and(|n| n.to_string_lossy().starts_with("agent.log"))
+                })
+                .collect()
+        })
+        .unwrap_or_default();
+    // Newest first: agent.log, then its rotations.
+    files.sort_by_key(|p| {
+        std::cmp::Reverse(
+            std::fs::metadata(p)
+                .and_then(|m| m.modified())
+                .unwrap_or(std::time::UNIX_EPOCH),
+        )
+    });
+    files.truncate(AGENT_LOG_FILES);
+    files
+}
+
+fn prune(dir: &Path) {
+    let Ok(rd) = std::fs::read_dir(dir) else {
+        return;
+    };
+    let mut bundles: Vec<PathBuf> = rd
+        .flatten()
+        .map(|de| de.path())
+        .filter(|p| p.extension().is_some_and(|e| e == "zip"))
+        .collect();
+    bundles.sort();
+    let excess = bundles.len().saturating_sub(KEEP);
+    for old in bundles.into_iter().take(excess) {
+        let _ = std::fs::remove_file(old);
+    }
+}
+
+pub fn build(opts: &Options) -> anyhow::Result<Bundle> {
+    let root = crate::minecraft::data_root();
+    let instances = root.join("instances");
+    let ids = instance_ids(&instances, &opts.instance_ids)?;
+    let metadata: Vec<(String, Option<InstanceMeta>)> = ids
+        .into_iter()
+        .map(|id| {
+            let meta = read_instance(&instances.join(&id));
+            (id, meta)
+        })
+        .collect();
+    let redactor = Redactor::for_instances(
+        metadata
+            .iter()
+            .filter_map(|(_, m)| m.as_ref().map(|m| &m.params)),
+    )
+    .keep_ips(opts.keep_ips);
+
+    let created_at_unix_ms = crate::console_audit::now_unix_ms();
+    let dir = root.join(DIR);
+    std::fs::create_dir_all(&dir).with_context(|| format!("create {}", dir.display()))?;
+    let path = dir.join(format!("alloy-support-{created_at_unix_ms}.zip"));
+    let tmp = path.with_extension("zip.tmp");
+    let mut w = Writer {
+        zip: zip::ZipWriter::new(std::fs::File::create(&tmp)?),
+        opts: zip::write::SimpleFileOptions::default()
+            .compression_method(zip::CompressionMethod::Deflated),
+        redactor,
+        files: Vec::new(),
+        redactions: 0,
+    };
+
+    let res = (|| -> anyhow::Result<()> {
+        w.json("versions.json", &versions())?;
+        w.json("diagnostics.json", &opts.diagnostics)?;
+        for log in agent_logs(&root.join("logs")) {
+            let Ok(text) = tail_text(&log, AGENT_LOG_BYTES) else {
+                continue;
+            };
+            let name = log.file_name().unwrap_or_default().to_string_lossy();
+            w.text(&format!("agent/{name}"), &text)?;
+        }
+
+        for (id, meta) in &metadata {
+            let dir = instances.join(id);
+            if let Some(meta) = meta {
+                w.json(&format!("instances/{id}/instance.json"), &meta.config)?;
+            }
+            for name in INSTANCE_FILES {
+                if let Ok(text) = std::fs::read_to_string(dir.join(name)) {
+                    w.text(&format!("instances/{id}/{name}"), &text)?;
+                }
+            }
+            if let Ok(text) = tail_text(&dir.join("logs").join("console.log"), CONSOLE_TAIL_BYTES) {
+                w.text(
+                    &format!("instances/{id}/console.log"),
+                    &tail_lines(&text, opts.console_lines),
+                )?;
+            }
+            if let Some(crash) = newest_crash(&dir)
+                && let Ok(text) = std::fs::read_to_string(&crash)
+            {
+                w.text(&format!("instances/{id}/last-crash.json"), &text)?;
+            }
+        }
+
+        let manifest = serde_json::json!({
+            "created_at_unix_ms": created_at_unix_ms,
+            "agent_version": env!("CARGO_PKG_VERSION"),
+            "instances": metadata.iter().map(|(id, _)| id).collect::<Vec<_>>(),
+            "files": w.files,
+            "redactions": w.redactions,
+            "ip_addresses_masked": !opts.keep_ips,
+        });
+        w.json("manifest.json", &manifest)?;
+        Ok(())
+    })();
+    let res = res.and_then(|()| {
+        let f = w.zip.finish()?;
+        f.sync_all()?;
+        Ok(())
+    });
+    if let Err(e) = res {
+        let _ = std::fs::remove_file(&tmp);
+        return Err(e);
+    }
+    std::fs::rename(&tmp, &path).with_context(|| format!("persist {}", path.display()))?;
+    prune(&dir);
+
+    Ok(Bundle {
+        size_bytes: std::fs::metadata(&path)?.len(),
+        path,
+        files: w.files,
+        redactions: w.redactions,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn tails_from_a_line_boundary() {
+        let dir = std::env::temp_dir().join(format!("alloy-bundle-test-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let log = dir.join("console.log");
+        std::fs::write(&log, "first line\nsecond line\nthird\n").unwrap();
+        assert_eq!(
+            tail_text(&log, 1024).unwrap(),
+            "first line\nsecond line\nthird\n"
+        );
+        assert_eq!(tail_text(&log, 10).unwrap(), "third\n");
+        assert_eq!(tail_lines("a\nb\nc", 2), "b\nc");
+        let _ = std::fs::remove_dir_all(&dir);
+
+        assert!(is_safe_id("mc-1"));
+        assert!(!is_safe_id(".."));
+        assert!(!is_safe_id("a/b"));
+    }
+}
@@ -182,6 +182,25 @@ fn sandbox_params() -> Vec<TemplateParam> {
             "2000",
             "CPU quota hint for cgroup (1000 = 1 core).",
         ),
+        param_string_advanced(
+            "cpu_affinity",
+            "CPU affinity",
+            false,
+            "",
+            vec![],
+            "0-3,6",
+            "Pin the instance to these host CPUs (taskset list format). Empty means no pinning.",
+        ),
+        param_int_advanced(
+            "oom_score_adj",
+            "OOM score adjust",
+            false,
+            "",
+            -1000,
+            1000,
+            "0",
+            "Kernel OOM killer preference (-1000..1000). Higher values are killed first; lowering requires privileges.",
+        ),
         param_string_advanced(
             "restart_policy",
             "Restart policy",
@@ -224,6 +243,69 @@ fn sandbox_params() -> Vec<TemplateParam> {
     ]
 }
 
+fn minecraft_jvm_params() -> Vec<TemplateParam> {
+    vec![param_bool_advanced(
+        "jvm_large_pages",
+        "JVM large pages",
+        false,
+        false,
+        "Start Java with large pages when the host supports them (hugetlbfs or transparent hugepages).",
+    )]
+}
+
+fn minecraft_network_params() -> Vec<TemplateParam> {
+    vec![param_string_advanced(
+        "bind_address",
+        "Bind address",
+        false,
+        "",
+        vec![],
+        "auto or 192.0.2.10",
+        "Listen on one address of this host (written to server-ip). `auto` picks a free address from the agent's ALLOY_BIND_POOL. Leave blank to listen on all addresses.",
+    )]
+}
+
+fn autostart_params() -> Vec<TemplateParam> {
+    vec![
+        param_bool_advanced(
+            "autostart",
+            "Start on agent boot",
+            false,
+            false,
+            "Start this instance automatically when the agent starts.",
+        ),
+        param_int_advanced(
+            "autostart_priority",
+            "Autostart priority",
+            false,
+            "100",
+            0,
+            1000,
+            "100",
+            "Lower values start first when the agent boots.",
+        ),
+        param_int_advanced(
+            "autostart_delay_ms",
+            "Autostart delay (ms)",
+            false,
+            "0",
+            0,
+            600000,
+            "0",
+            "Extra delay before this instance is started on boot.",
+        ),
+        param_string_advanced(
+            "autostart_after",
+            "Autostart after",
+            false,
+            "",
+            vec![],
+            "instance-id-1,instance-id-2",
+            "Comma-separated instance ids that must be running before this one starts on boot.",
+        ),
+    ]
+}
+
 fn param_bool(
     key: &str,
     label: &str,
@@ -616,8 +698,13 @@ pub fn list_templates() -> Vec<ProcessTemplate> {
     ];
 
     for t in &mut templates {
+        if t.template_id.starts_with("minecraft:") {
+            t.params.extend(minecraft_jvm_params());
+            t.params.extend(minecraft_network_params());
+        }
         if t.template_id != "demo:sleep" {
             t.params.extend(sandbox_params());
+            t.params.extend(autostart_params());
         }
     }
 
@@ -671,6 +758,11 @@ pub fn apply_params(
         t.args = vec![secs.to_string()];
     }
 
+    if t.template_id.starts_with("minecraft:") {
+        // Whether the address exists on this host is checked on start.
+        let _ = crate::bind_pool::requested(params)?;
+    }
+
     if t.template_id == "minecraft:vanilla" {
         // Contract-only commit: validate params early; runtime wiring is in later commits.
         let _ = crate::minecraft::validate_vanilla_params(params)?;
@@ -26,6 +26,7 @@ fn download_chunk_threshold(total_bytes: u64) -> u64 {
     }
 }
 
+#[tracing::instrument(name = "http.download", skip_all, fields(url = %url))]
 async fn download_zip_with_progress<F>(
     url: Url,
     expected_size: Option<u64>,
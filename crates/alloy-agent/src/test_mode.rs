@@ -0,0 +1,554 @@
+use std::{
+    collections::BTreeSet,
+    io::{Read, Write},
+    path::Path,
+    sync::OnceLock,
+    time::{Duration, Instant},
+};
+
+use anyhow::Context;
+use sha1::Digest;
+use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
+
+use crate::process_manager_support::env_u64;
+
+// Test mode for full-stack integration tests (ALLOY_TEST_MODE=1). The agent runs as usual (process
+// manager, console, topics, backups, control tunnel), but a Minecraft server needs neither Java nor
+// the network:
+// - Mojang's version manifest, version json and server.jar come from fixtures served on 127.0.0.1.
+// - The Java runtime check passes, and `java` launches, including the launch probe, run this
+//   binary as a fake server (FAKE_SERVER_ARG) that prints vanilla log lines, listens on the server
+//   port and exits on `stop` or SIGTERM.
+// - Instances launch natively; docker and bwrap are not used.
+// - The clock starts at ALLOY_TEST_CLOCK_START_MS (default 2024-01-01T00:00:00Z) when the agent
+//   starts and runs at normal speed, in UTC. NTP checks are off.
+//
+// Templates with other upstreams (Terraria, CurseForge/Modrinth packs) still download from them.
+
+pub const FAKE_SERVER_ARG: &str = "fake-minecraft-server";
+// What the fake runtime reports for `java -version`; every fixture version asks for it.
+pub const JAVA_MAJOR: u32 = 21;
+const DEFAULT_CLOCK_START_MS: u64 = 1_704_067_200_000;
+// Newest first; the first is `latest_release`.
+const FIXTURE_VERSIONS: &[&str] = &["1.21.4", "1.21.1"];
+const MAIN_CLASS: &str = "net.minecraft.bundler.Main";
+// What vanilla prints for `--help`, which is all the launch probe asks of the fake server.
+const FAKE_HELP: &[&str] = &[
+    "Option                  Description",
+    "------                  -----------",
+    "--bonusChest",
+    "--demo",
+    "--eraseCache",
+    "--forceUpgrade",
+    "--help",
+    "--initSettings          Initializes 'server.properties' and 'eula.txt', then quits",
+    "--nogui",
+    "--port <Integer>        (default: -1)",
+    "--universe <String>     (default: .)",
+    "--world <String>",
+];
+
+pub fn enabled() -> bool {
+    static ENABLED: OnceLock<bool> = OnceLock::new();
+    *ENABLED.get_or_init(|| {
+        matches!(
+            std::env::var("ALLOY_TEST_MODE")
+                .unwrap_or_default()
+                .trim()
+                .to_ascii_lowercase()
+                .as_str(),
+            "1" | "true" | "yes" | "on"
+        )
+    })
+}
+
+// The deterministic clock; None outside test mode.
+pub fn now_unix_ms() -> Option<u64> {
+    if !enabled() {
+        return None;
+    }
+    static CLOCK: OnceLock<(u64, Instant)> = OnceLock::new();
+    let (start_ms, started) = CLOCK.get_or_init(|| {
+        (
+            env_u64("ALLOY_TEST_CLOCK_START_MS").unwrap_or(DEFAULT_CLOCK_START_MS),
+            Instant::now(),
+        )
+    });
+    Some(start_ms + started.elapsed().as_millis() as u64)
+}
+
+// --- Upstream fixtures ---
+
+static FIXTURE_BASE: OnceLock<String> = OnceLock::new();
+
+// `http://127.0.0.1:<port>` once the fixture server is up.
+pub fn fixture_base() -> Option<&'static str> {
+    FIXTURE_BASE.get().map(String::as_str)
+}
+
+pub fn manifest_url() -> Option<String> {
+    fixture_base().map(|base| format!("{base}/mc/game/version_manifest_v2.json"))
+}
+
+// A real jar, so jar checks like the launch probe's accept it. Entries are stored with a fixed
+// timestamp: the bytes must not change between calls, since the version json carries their sha1.
+fn write_fixture_jar(version: &str) -> zip::result::ZipResult<Vec<u8>> {
+    let opts = zip::write::SimpleFileOptions::default()
+        .compression_method(zip::CompressionMethod::Stored)
+        .last_modified_time(zip::DateTime::default());
+    let mut zip = zip::ZipWriter::new(std::io::Cursor::new(Vec::new()));
+    zip.start_file("META-INF/MANIFEST.MF", opts)?;
+    zip.write_all(format!("Manifest-Version: 1.0\r\nMain-Class: {MAIN_CLASS}\r\n").as_bytes())?;
+    zip.start_file("version.json", opts)?;
+    let version_json = serde_json::json!({
+        "id": version,
+        "name": version,
+        "java_version": JAVA_MAJOR,
+    });
+    zip.write_all(version_json.to_string().as_bytes())?;
+    Ok(zip.finish()?.into_inner())
+}
+
+fn fixture_jar(version: &str) -> Vec<u8> {
+    write_fixture_jar(version).expect("writing a zip to memory cannot fail")
+}
+
+fn sha1_hex(bytes: &[u8]) -> String {
+    hex::encode(sha1::Sha1::digest(bytes))
+}
+
+// The response body for `path`, shaped like piston-meta/piston-data.
+fn fixture(base: &str, path: &str) -> Option<Vec<u8>> {
+    if path == "/mc/game/version_manifest_v2.json" {
+        let versions: Vec<_> = FIXTURE_VERSIONS
+            .iter()
+            .map(|id| {
+                serde_json::json!({
+                    "id": id,
+                    "type": "release",
+                    "url": format!("{base}/v1/packages/{id}.json"),
+                })
+            })
+            .collect();
+        let manifest = serde_json::json!({
+            "latest": { "release": FIXTURE_VERSIONS[0], "snapshot": FIXTURE_VERSIONS[0] },
+            "versions": versions,
+        });
+        return Some(manifest.to_string().into_bytes());
+    }
+    if let Some(id) = path
+        .strip_prefix("/v1/packages/")
+        .and_then(|rest| rest.strip_suffix(".json"))
+    {
+        let id = FIXTURE_VERSIONS.iter().find(|v| **v == id)?;
+        let jar = fixture_jar(id);
+        let sha1 = sha1_hex(&jar);
+        let version = serde_json::json!({
+            "id": id,
+            "downloads": {
+                "server": {
+                    "sha1": sha1,
+                    "size": jar.len(),
+                    "url": format!("{base}/v1/objects/{sha1}/server.jar"),
+                },
+            },
+            "javaVersion": { "component": "java-runtime-delta", "majorVersion": JAVA_MAJOR },
+        });
+        return Some(version.to_string().into_bytes());
+    }
+    let sha1 = path
+        .strip_prefix("/v1/objects/")
+        .and_then(|rest| rest.strip_suffix("/server.jar"))?;
+    FIXTURE_VERSIONS
+        .iter()
+        .map(|id| fixture_jar(id))
+        .find(|jar| sha1_hex(jar) == sha1)
+}
+
+async fn serve_fixture(stream: tokio::net::TcpStream, base: &str) -> std::io::Result<()> {
+    let mut stream = BufReader::new(stream);
+    let mut request_line = String::new();
+    stream.read_line(&mut request_line).await?;
+    // Skip the headers.
+    let mut line = String::new();
+    while stream.read_line(&mut line).await? > 2 {
+        line.clear();
+    }
+    let path = request_line.split_whitespace().nth(1).unwrap_or("/");
+    let (status, body) = match fixture(base, path) {
+        Some(body) => ("200 OK", body),
+        None => ("404 Not Found", b"not found".to_vec()),
+    };
+    let head = format!(
+        "HTTP/1.1 {status}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+        body.len()
+    );
+    let stream = stream.get_mut();
+    stream.write_all(head.as_bytes()).await?;
+    stream.write_all(&body).await?;
+    stream.shutdown().await
+}
+
+// Serves the fixtures on an ephemeral loopback port.
+pub async fn start_fixtures() -> anyhow::Result<()> {
+    let listener = tokio::net::TcpListener::bind(("127.0.0.1", 0))
+        .await
+        .context("bind test-mode fixture server")?;
+    let base = format!("http://{}", listener.local_addr()?);
+    tracing::warn!(%base, "test mode: serving Minecraft fixtures; downloads and Java are fake");
+    let _ = FIXTURE_BASE.set(base.clone());
+    tokio::spawn(async move {
+        loop {
+            let Ok((stream, _)) = listener.accept().await else {
+                continue;
+            };
+            let base = base.clone();
+            tokio::spawn(async move {
+                if let Err(e) = serve_fixture(stream, &base).await {
+                    tracing::debug!(error = %e, "test mode: fixture request failed");
+                }
+            });
+        }
+    });
+    Ok(())
+}
+
+// --- Fake server ---
+
+// The command that stands in for `java <args>`: this binary in fake server mode, told the current
+// test clock so its log timestamps line up with the agent's.
+pub fn fake_java_command(args: &[String]) -> anyhow::Result<(String, Vec<String>)> {
+    let exe = std::env::current_exe().context("locate agent binary for the fake server")?;
+    let mut out = vec![
+        FAKE_SERVER_ARG.to_string(),
+        "--clock-ms".to_string(),
+        now_unix_ms().unwrap_or(DEFAULT_CLOCK_START_MS).to_string(),
+    ];
+    out.extend(args.iter().cloned());
+    Ok((exe.display().to_string(), out))
+}
+
+struct FakeLog {
+    start_ms: u64,
+    started: Instant,
+}
+
+impl FakeLog {
+    fn line(&self, thread: &str, level: &str, msg: &str) -> String {
+        let secs = (self.start_ms + self.started.elapsed().as_millis() as u64) / 1000 % 86_400;
+        format!(
+            "[{:02}:{:02}:{:02}] [{thread}/{level}]: {msg}",
+            secs / 3600,
+            secs / 60 % 60,
+            secs % 60
+        )
+    }
+
+    fn info(&self, msg: &str) {
+        println!("{}", self.line("Server thread", "INFO", msg));
+    }
+
+    fn warn(&self, msg: &str) {
+        println!("{}", self.line("Server thread", "WARN", msg));
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct FakeProps {
+    port: u16,
+    ip: Option<String>,
+    level: String,
+    max_players: u32,
+}
+
+fn fake_props(raw: &str) -> FakeProps {
+    let get = |key: &str| {
+        raw.lines()
+            .filter_map(|l| l.trim().split_once('='))
+            .find(|(k, _)| k.trim() == key)
+            .map(|(_, v)| v.trim().to_string())
+            .filter(|v| !v.is_empty())
+    };
+    FakeProps {
+        port: get("server-port")
+            .and_then(|v| v.parse().ok())
+            .unwrap_or(25565),
+        ip: get("server-ip"),
+        level: get("level-name").unwrap_or_else(|| "world".to_string()),
+        max_players: get("max-players")
+            .and_then(|v| v.parse().ok())
+            .unwrap_or(20),
+    }
+}
+
+#[derive(Debug, PartialEq, Eq)]
+enum Reply {
+    Lines(Vec<String>),
+    Stop,
+    Crash,
+}
+
+// Console commands the fake server understands. Besides a few vanilla ones, `alloy-test join
+// <name>`, `alloy-test leave <name>` and `alloy-test crash` script player and crash scenarios.
+fn fake_command(line: &str, online: &mut BTreeSet<String>, max_players: u32) -> Reply {
+    let line = line.trim().trim_start_matches('/');
+    let (cmd, rest) = line.split_once(' ').unwrap_or((line, ""));
+    let rest = rest.trim();
+    match (cmd, rest.split_once(' ').unwrap_or((rest, ""))) {
+        ("", _) => Reply::Lines(Vec::new()),
+        ("stop", _) => Reply::Stop,
+        ("list", _) => Reply::Lines(vec![format!(
+            "There are {} of a max of {max_players} players online: {}",
+            online.len(),
+            online.iter().cloned().collect::<Vec<_>>().join(", ")
+        )]),
+        ("say", _) => Reply::Lines(vec![format!("[Server] {rest}")]),
+        ("alloy-test", ("join", name)) if !name.is_empty() => {
+            online.insert(name.to_string());
+            Reply::Lines(vec![format!("{name} joined the game")])
+        }
+        ("alloy-test", ("leave", name)) if online.remove(name) => Reply::Lines(vec![
+            format!("{name} lost connection: Disconnected"),
+            format!("{name} left the game"),
+        ]),
+        ("alloy-test", ("crash", _)) => Reply::Crash,
+        _ => Reply::Lines(vec![
+            "Unknown or incomplete command, see below for error".to_string(),
+            format!("{line}<--[HERE]"),
+        ]),
+    }
+}
+
+fn stop_lines(level: &str) -> Vec<String> {
+    let mut out = vec![
+        "Stopping the server".to_string(),
+        "Stopping server".to_string(),
+        "Saving players".to_string(),
+        "Saving worlds".to_string(),
+    ];
+    for dim in ["overworld", "the_nether", "the_end"] {
+        out.push(format!(
+            "Saving chunks for level 'ServerLevel[{level}]'/minecraft:{dim}"
+        ));
+    }
+    out.push("ThreadedAnvilChunkStorage: All dimensions are saved".to_string());
+    out
+}
+
+fn read_version(cwd: &Path) -> String {
+    let read = || -> anyhow::Result<String> {
+        let mut zip = zip::ZipArchive::new(std::fs::File::open(cwd.join("server.jar"))?)?;
+        let mut raw = String::new();
+        zip.by_name("version.json")?.read_to_string(&mut raw)?;
+        let v: serde_json::Value = serde_json::from_str(&raw)?;
+        Ok(v["id"]
+            .as_str()
+            .context("version.json has no id")?
+            .to_string())
+    };
+    read().unwrap_or_else(|_| "unknown".to_string())
+}
+
+#[cfg(unix)]
+async fn terminated() {
+    use tokio::signal::unix::{SignalKind, signal};
+    match signal(SignalKind::terminate()) {
+        Ok(mut term) => {
+            term.recv().await;
+        }
+        Err(_) => std::future::pending().await,
+    }
+}
+
+#[cfg(not(unix))]
+async fn terminated() {
+    let _ = tokio::signal::ctrl_c().await;
+}
+
+// Entry point for `alloy-agent fake-minecraft-server [--clock-ms N] <java args>`, run from the
+// instance dir.
+pub async fn run_fake_server(args: &[String]) -> anyhow::Result<()> {
+    // The launch probe runs `java -jar server.jar --help`.
+    if args.iter().any(|a| a == "--help") {
+        FAKE_HELP.iter().for_each(|l| println!("{l}"));
+        return Ok(());
+    }
+    let start_ms = args
+        .windows(2)
+        .find(|w| w[0] == "--clock-ms")
+        .and_then(|w| w[1].parse().ok())
+        .unwrap_or(DEFAULT_CLOCK_START_MS);
+    let log = FakeLog {
+        start_ms,
+        started: Instant::now(),
+    };
+    let cwd = std::env::current_dir()?;
+    let props = fake_props(
+        &std::fs::read_to_string(cwd.join("server.properties"))
+            .or_else(|_| std::fs::read_to_string(cwd.join("config").join("server.properties")))
+            .unwrap_or_default(),
+    );
+
+    log.info(&format!(
+        "Starting minecraft server version {}",
+        read_version(&cwd)
+    ));
+    log.info("Loading properties");
+    log.info("Default game type: SURVIVAL");
+    log.info("Generating keypair");
+    let host = props.ip.clone().unwrap_or_else(|| "*".to_string());
+    log.info(&format!(
+        "Starting Minecraft server on {host}:{}",
+        props.port
+    ));
+    let bind_ip = props.ip.clone().unwrap_or_else(|| "0.0.0.0".to_string());
+    let listener = match tokio::net::TcpListener::bind((bind_ip.as_str(), props.port)).await {
+        Ok(l) => l,
+        Err(e) => {
+            log.warn("**** FAILED TO BIND TO PORT!");
+            log.warn(&format!("The exception was: {e}"));
+            log.warn("Perhaps a server is already running on that port?");
+            std::process::exit(1);
+        }
+    };
+    log.info("Using epoll channel type");
+    log.info(&format!("Preparing level \"{}\"", props.level));
+    std::fs::create_dir_all(cwd.join(&props.level))?;
+    log.info("Preparing start region for dimension minecraft:overworld");
+    tokio::time::sleep(Duration::from_millis(200)).await;
+    let elapsed = log.started.elapsed();
+    log.info(&format!("Time elapsed: {} ms", elapsed.as_millis()));
+    log.info(&format!(
+        "Done ({:.3}s)! For help, type \"help\"",
+        elapsed.as_secs_f64()
+    ));
+
+    // Accept and drop connections; the port only has to be open.
+    tokio::spawn(async move {
+        while let Ok((stream, _)) = listener.accept().await {
+            drop(stream);
+        }
+    });
+
+    let term = terminated();
+    tokio::pin!(term);
+    let mut stdin = Some(BufReader::new(tokio::io::stdin()).lines());
+    let mut online = BTreeSet::new();
+    loop {
+        let next = async {
+            match stdin.as_mut() {
+                Some(lines) => lines.next_line().await.ok().flatten(),
+                None => std::future::pending().await,
+            }
+        };
+        let line = tokio::select! {
+            line = next => line,
+            _ = &mut term => Some("stop".to_string()),
+        };
+        let Some(line) = line else {
+            // Like the real server, keep running with a closed stdin.
+            stdin = None;
+            continue;
+        };
+        match fake_command(&line, &mut online, props.max_players) {
+            Reply::Lines(lines) => lines.iter().for_each(|l| log.info(l)),
+            Reply::Stop => {
+                stop_lines(&props.level).iter().for_each(|l| log.info(l));
+                return Ok(());
+            }
+            Reply::Crash => {
+                println!(
+                    "{}",
+                    log.line(
+                        "Server thread",
+                        "ERROR",
+                        "Encountered an unexpected exception"
+                    )
+                );
+                println!("java.lang.IllegalStateException: alloy-test crash");
+                std::process::exit(1);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn serves_consistent_fixtures_and_scripts_the_fake_server() {
+        let base = "http://127.0.0.1:1";
+        let manifest: serde_json::Value =
+            serde_json::from_slice(&fixture(base, "/mc/game/version_manifest_v2.json").unwrap())
+                .unwrap();
+        assert_eq!(manifest["latest"]["release"], FIXTURE_VERSIONS[0]);
+        let url = manifest["versions"][1]["url"].as_str().unwrap();
+        let version: serde_json::Value =
+            serde_json::from_slice(&fixture(base, url.strip_prefix(base).unwrap()).unwrap())
+                .unwrap();
+        assert_eq!(version["javaVersion"]["majorVersion"], JAVA_MAJOR);
+        let jar_url = version["downloads"]["server"]["url"].as_str().unwrap();
+        let jar = fixture(base, jar_url.strip_prefix(base).unwrap()).unwrap();
+        assert_eq!(version["downloads"]["server"]["sha1"], sha1_hex(&jar));
+        assert_eq!(version["downloads"]["server"]["size"], jar.len());
+        assert!(fixture(base, "/v1/objects/00/server.jar").is_none());
+        assert!(fixture(base, "/v1/packages/1.8.9.json").is_none());
+
+        let props = fake_props("server-port=25570\nserver-ip=\nlevel-name=worlds/world\n");
+        assert_eq!(
+            props,
+            FakeProps {
+                port: 25570,
+                ip: None,
+                level: "worlds/world".to_string(),
+                max_players: 20,
+            }
+        );
+
+        let mut online = BTreeSet::new();
+        assert_eq!(
+            fake_command("alloy-test join Steve", &mut online, 20),
+            Reply::Lines(vec!["Steve joined the game".to_string()])
+        );
+        assert_eq!(
+            fake_command("/list", &mut online, 20),
+            Reply::Lines(vec![
+                "There are 1 of a max of 20 players online: Steve".to_string()
+            ])
+        );
+        assert_eq!(
+            fake_command("alloy-test leave Steve", &mut online, 20),
+            Reply::Lines(vec![
+                "Steve lost connection: Disconnected".to_string(),
+                "Steve left the game".to_string(),
+            ])
+        );
+        assert_eq!(fake_command("stop", &mut online, 20), Reply::Stop);
+        assert!(matches!(
+            fake_command("alloy-test leave Alex", &mut online, 20),
+            Reply::Lines(l) if l[0].starts_with("Unknown")
+        ));
+
+        // ValidateStart's launch probe accepts the fixture jar.
+        assert_eq!(fixture_jar("1.21.1"), fixture_jar("1.21.1"));
+        let dir = std::env::temp_dir().join(format!("alloy-test-mode-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(dir.join("server.jar"), fixture_jar("1.21.1")).unwrap();
+        assert_eq!(
+            crate::minecraft_probe::check_jar(&dir.join("server.jar")).unwrap(),
+            MAIN_CLASS
+        );
+        assert_eq!(read_version(&dir), "1.21.1");
+        let _ = std::fs::remove_dir_all(&dir);
+
+        let log = FakeLog {
+            start_ms: DEFAULT_CLOCK_START_MS + 3_723_000,
+            started: Instant::now(),
+        };
+        assert_eq!(
+            log.line("Server thread", "INFO", "x"),
+            "[01:02:03] [Server thread/INFO]: x"
+        );
+    }
+}
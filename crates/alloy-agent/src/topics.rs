@@ -0,0 +1,202 @@
+use std::sync::{
+    Arc, Mutex, OnceLock,
+    atomic::{AtomicU64, Ordering},
+};
+
+use tokio::sync::mpsc;
+
+use crate::process_manager_support::env_usize;
+
+const DEFAULT_QUEUE: usize = 256;
+
+// Upper bound on live subscriptions across all control connections.
+const MAX_SUBSCRIPTIONS: usize = 256;
+
+#[derive(Debug, Clone, serde::Serialize)]
+pub(crate) struct TopicEvent {
+    pub(crate) topic: String,
+    pub(crate) data: serde_json::Value,
+    // Events dropped for this subscriber since the previous delivery.
+    pub(crate) dropped: u64,
+}
+
+struct Subscriber {
+    id: u64,
+    pattern: String,
+    filter: Option<String>,
+    tx: mpsc::Sender<TopicEvent>,
+    dropped: Arc<AtomicU64>,
+}
+
+impl Subscriber {
+    fn matches(&self, topic: &str) -> bool {
+        match self.pattern.strip_suffix('*') {
+            Some(prefix) => topic.starts_with(prefix),
+            None => self.pattern == topic,
+        }
+    }
+
+    fn accepts(&self, data: &serde_json::Value) -> bool {
+        let Some(needle) = self.filter.as_deref() else {
+            return true;
+        };
+        let text = match data {
+            serde_json::Value::String(s) => s.to_ascii_lowercase(),
+            other => other.to_string().to_ascii_lowercase(),
+        };
+        text.contains(needle)
+    }
+}
+
+fn subscribers() -> &'static Mutex<Vec<Subscriber>> {
+    static SUBSCRIBERS: OnceLock<Mutex<Vec<Subscriber>>> = OnceLock::new();
+    SUBSCRIBERS.get_or_init(|| Mutex::new(Vec::new()))
+}
+
+static NEXT_ID: AtomicU64 = AtomicU64::new(1);
+
+fn queue_len() -> usize {
+    env_usize("ALLOY_TOPIC_QUEUE")
+        .map(|v| v.clamp(16, 65_536))
+        .unwrap_or(DEFAULT_QUEUE)
+}
+
+// Topics are `console:<instance>`, `metrics:<instance>` and `events:<kind>`. A trailing `*`
+// subscribes to every topic with that prefix (e.g. `console:*`).
+pub(crate) fn validate_topic(pattern: &str) -> Result<(), String> {
+    let Some((kind, rest)) = pattern.split_once(':') else {
+        return Err(format!("invalid topic: {pattern}"));
+    };
+    if !matches!(kind, "console" | "metrics" | "events") {
+        return Err(format!("unknown topic kind: {kind}"));
+    }
+    let name = rest.strip_suffix('*').unwrap_or(rest);
+    if !name
+        .chars()
+        .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+    {
+        return Err(format!("invalid topic: {pattern}"));
+    }
+    if name.is_empty() && !rest.ends_with('*') {
+        return Err(format!("invalid topic: {pattern}"));
+    }
+    Ok(())
+}
+
+// Unsubscribes when dropped.
+pub(crate) struct Subscription {
+    id: u64,
+    rx: mpsc::Receiver<TopicEvent>,
+}
+
+impl Subscription {
+    pub(crate) async fn recv(&mut self) -> Option<TopicEvent> {
+        self.rx.recv().await
+    }
+}
+
+impl Drop for Subscription {
+    fn drop(&mut self) {
+        let mut subs = subscribers().lock().unwrap_or_else(|e| e.into_inner());
+        subs.retain(|s| s.id != self.id);
+    }
+}
+
+pub(crate) fn subscribe(pattern: &str, filter: Option<&str>) -> Result<Subscription, String> {
+    validate_topic(pattern)?;
+    let filter = filter
+        .map(|f| f.trim().to_ascii_lowercase())
+        .filter(|f| !f.is_empty());
+
+    let mut subs = subscribers().lock().unwrap_or_else(|e| e.into_inner());
+    if subs.len() >= MAX_SUBSCRIPTIONS {
+        return Err(format!("too many subscriptions (max {MAX_SUBSCRIPTIONS})"));
+    }
+    let (tx, rx) = mpsc::channel(queue_len());
+    let id = NEXT_ID.fetch_add(1, Ordering::Relaxed);
+    subs.push(Subscriber {
+        id,
+        pattern: pattern.to_string(),
+        filter,
+        tx,
+        dropped: Arc::new(AtomicU64::new(0)),
+    });
+    Ok(Subscription { id, rx })
+}
+
+// Never blocks the publisher. A subscriber whose queue is full loses the event, and the count is
+// reported with its next delivery so the client knows it missed something.
+pub(crate) fn publish(topic: &str, data: impl FnOnce() -> serde_json::Value) {
+    let subs = subscribers().lock().unwrap_or_else(|e| e.into_inner());
+    let mut matching = subs.iter().filter(|s| s.matches(topic)).peekable();
+    if matching.peek().is_none() {
+        return;
+    }
+    let value = data();
+    for sub in matching {
+        if !sub.accepts(&value) {
+            continue;
+        }
+        let dropped = sub.dropped.swap(0, Ordering::Relaxed);
+        let ev = TopicEvent {
+            topic: topic.to_string(),
+            data: value.clone(),
+            dropped,
+        };
+        if sub.tx.try_send(ev).is_err() {
+            sub.dropped.fetch_add(dropped + 1, Ordering::Relaxed);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn validate_topic_accepts_known_kinds() {
+        assert!(validate_topic("console:mc-1").is_ok());
+        assert!(validate_topic("metrics:*").is_ok());
+        assert!(validate_topic("events:backup").is_ok());
+        assert!(validate_topic("console:").is_err());
+        assert!(validate_topic("logs:mc-1").is_err());
+        assert!(validate_topic("console:../x").is_err());
+    }
+
+    #[test]
+    fn publish_filters_and_reports_drops() {
+        let mut all = subscribe("console:topics-test-*", None).unwrap();
+        let mut warn = subscribe("console:topics-test-a", Some("WARN")).unwrap();
+
+        publish("console:topics-test-a", || "[INFO] hello".into());
+        publish("console:topics-test-a", || "[WARN] low memory".into());
+        publish(
+            "metrics:topics-test-a",
+            || serde_json::json!({"rss_bytes": 1}),
+        );
+
+        assert_eq!(all.rx.try_recv().unwrap().data, "[INFO] hello");
+        assert_eq!(all.rx.try_recv().unwrap().data, "[WARN] low memory");
+        assert!(all.rx.try_recv().is_err());
+        assert_eq!(warn.rx.try_recv().unwrap().data, "[WARN] low memory");
+        assert!(warn.rx.try_recv().is_err());
+
+        for i in 0..queue_len() + 3 {
+            publish("console:topics-test-b", || format!("line {i}").into());
+        }
+        for _ in 0..queue_len() {
+            all.rx.try_recv().unwrap();
+        }
+        publish("console:topics-test-b", || "after".into());
+        let ev = all.rx.try_recv().unwrap();
+        assert_eq!(ev.data, "after");
+        assert_eq!(ev.dropped, 3);
+
+        // Other tests may hold their own console subscriptions, so only look for ours.
+        let ids = [all.id, warn.id];
+        drop(warn);
+        drop(all);
+        let subs = subscribers().lock().unwrap();
+        assert!(!subs.iter().any(|s| ids.contains(&s.id)));
+    }
+}
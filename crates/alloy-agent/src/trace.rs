@@ -0,0 +1,137 @@
+use std::{
+    future::Future,
+    hash::{BuildHasher, Hasher},
+    pin::Pin,
+    sync::atomic::{AtomicU64, Ordering},
+    task::{Context, Poll},
+};
+
+use tonic::{body::BoxBody, codegen::http};
+use tracing::Instrument;
+
+// Request ids: control sends its `x-request-id` with every call (gRPC metadata, or the tunnel's
+// `req` frame), and the agent runs the handler inside a span carrying it, so agent log lines,
+// control's audit entries and the panel's error reports share one id. Calls without one (alloyctl,
+// older control builds) get a fresh id. It is echoed back on every response.
+
+pub const HEADER: &str = "x-request-id";
+const MAX_LEN: usize = 128;
+
+tokio::task_local! {
+    static REQUEST_ID: String;
+}
+
+static NEXT: AtomicU64 = AtomicU64::new(0);
+
+pub fn new_id() -> String {
+    // RandomState is seeded randomly per process; the counter keeps ids in one process unique.
+    let mut h = std::collections::hash_map::RandomState::new().build_hasher();
+    h.write_u64(NEXT.fetch_add(1, Ordering::Relaxed));
+    h.write_u128(
+        std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .map(|d| d.as_nanos())
+            .unwrap_or_default(),
+    );
+    format!("agent-{:016x}", h.finish())
+}
+
+// A caller-supplied id, if it is safe to log and echo.
+pub fn sanitize(raw: &str) -> Option<String> {
+    let raw = raw.trim();
+    (!raw.is_empty()
+        && raw.len() <= MAX_LEN
+        && raw
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.' | ':')))
+    .then(|| raw.to_string())
+}
+
+// The id of the request the current task is serving, if any.
+pub fn current() -> Option<String> {
+    REQUEST_ID.try_with(Clone::clone).ok()
+}
+
+// Runs `fut` with `id` as the current request id. Callers also put it on their span so it
+// appears on log lines.
+pub fn scope<F: Future>(id: String, fut: F) -> impl Future<Output = F::Output> {
+    REQUEST_ID.scope(id, fut)
+}
+
+// tokio::spawn for work started by a request: the task keeps the request id and span.
+pub fn spawn<F>(fut: F) -> tokio::task::JoinHandle<F::Output>
+where
+    F: Future + Send + 'static,
+    F::Output: Send + 'static,
+{
+    let fut = fut.in_current_span();
+    match current() {
+        Some(id) => tokio::spawn(REQUEST_ID.scope(id, fut)),
+        None => tokio::spawn(fut),
+    }
+}
+
+// Adopts or assigns the request id on the direct gRPC listener and echoes it in the response.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct RequestIdLayer;
+
+impl<S> tower::Layer<S> for RequestIdLayer {
+    type Service = RequestIdService<S>;
+
+    fn layer(&self, inner: S) -> Self::Service {
+        RequestIdService { inner }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct RequestIdService<S> {
+    inner: S,
+}
+
+impl<S, B> tower::Service<http::Request<B>> for RequestIdService<S>
+where
+    S: tower::Service<http::Request<B>, Response = http::Response<BoxBody>>,
+    S::Future: Send + 'static,
+{
+    type Response = S::Response;
+    type Error = S::Error;
+    type Future = Pin<Box<dyn Future<Output = Result<Self::Response, Self::Error>> + Send>>;
+
+    fn poll_ready(&mut self, cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        self.inner.poll_ready(cx)
+    }
+
+    fn call(&mut self, req: http::Request<B>) -> Self::Future {
+        let id = req
+            .headers()
+            .get(HEADER)
+            .and_then(|v| v.to_str().ok())
+            .and_then(sanitize)
+            .unwrap_or_else(new_id);
+        let span = tracing::info_span!("grpc", request_id = %id, method = %req.uri().path());
+        let fut = scope(id.clone(), self.inner.call(req).instrument(span));
+        Box::pin(async move {
+            let mut resp = fut.await?;
+            if let Ok(v) = http::HeaderValue::from_str(&id) {
+                resp.headers_mut().insert(HEADER, v);
+            }
+            Ok(resp)
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn accepts_only_plain_ids() {
+        assert_eq!(sanitize(" 3f2a-b1 ").as_deref(), Some("3f2a-b1"));
+        assert_eq!(sanitize("outbox:node:1").as_deref(), Some("outbox:node:1"));
+        assert_eq!(sanitize(""), None);
+        assert_eq!(sanitize("a b"), None);
+        assert_eq!(sanitize("id\r\nx-evil: 1"), None);
+        assert_eq!(sanitize(&"a".repeat(MAX_LEN + 1)), None);
+        assert_ne!(new_id(), new_id());
+    }
+}
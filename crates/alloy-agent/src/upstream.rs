@@ -0,0 +1,252 @@
+use std::{
+    collections::BTreeMap,
+    path::PathBuf,
+    sync::Mutex,
+    time::{Duration, Instant},
+};
+
+use sha2::Digest;
+
+// Metadata APIs of the providers we install from (Mojang piston-meta, Modrinth, Fabric meta,
+// CurseForge). Each provider has a circuit breaker: after FAILURE_THRESHOLD consecutive failures
+// (network errors, 5xx, 429, unparsable bodies) calls skip the network for OPEN_FOR, then a single
+// probe is let through. Good responses are kept on disk, so during an outage the last copy is
+// served and marked stale instead of failing the install. Without a cached copy the call fails
+// with `Unavailable`, which callers report as `upstream_unavailable` so control can retry later.
+
+const FAILURE_THRESHOLD: u32 = 3;
+const OPEN_FOR: Duration = Duration::from_secs(60);
+
+pub const MOJANG: &str = "mojang";
+pub const MODRINTH: &str = "modrinth";
+pub const FABRIC: &str = "fabric";
+pub const CURSEFORGE: &str = "curseforge";
+
+#[derive(Debug)]
+pub struct Unavailable {
+    pub provider: &'static str,
+    pub reason: String,
+}
+
+impl std::fmt::Display for Unavailable {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{} API is unavailable: {}", self.provider, self.reason)
+    }
+}
+
+impl std::error::Error for Unavailable {}
+
+// Whether `e` (or anything it wraps) is an upstream outage rather than a bad request.
+pub fn is_unavailable(e: &anyhow::Error) -> bool {
+    e.chain().any(|c| c.is::<Unavailable>())
+}
+
+// The error code to report for a failed lookup: outages are retried later, other failures are not.
+pub fn error_code(e: &anyhow::Error) -> &'static str {
+    if is_unavailable(e) {
+        "upstream_unavailable"
+    } else {
+        "download_failed"
+    }
+}
+
+pub struct Fetched<T> {
+    pub value: T,
+    // Served from the on-disk copy because the provider could not be reached.
+    pub is_stale: bool,
+}
+
+#[derive(Debug, Default)]
+struct Breaker {
+    failures: u32,
+    open_until: Option<Instant>,
+    last_error: String,
+}
+
+static BREAKERS: Mutex<BTreeMap<&'static str, Breaker>> = Mutex::new(BTreeMap::new());
+
+// Err(last error) while the breaker is open. Once OPEN_FOR has passed, one caller gets through
+// and the breaker stays open for everyone else until that probe is recorded.
+fn admit(provider: &'static str) -> Result<(), String> {
+    let mut map = BREAKERS.lock().unwrap_or_else(|e| e.into_inner());
+    let b = map.entry(provider).or_default();
+    let now = Instant::now();
+    match b.open_until {
+        Some(until) if until > now => Err(b.last_error.clone()),
+        Some(_) => {
+            b.open_until = Some(now + OPEN_FOR);
+            Ok(())
+        }
+        None => Ok(()),
+    }
+}
+
+fn record(provider: &'static str, outcome: Result<(), &str>) {
+    let mut map = BREAKERS.lock().unwrap_or_else(|e| e.into_inner());
+    let b = map.entry(provider).or_default();
+    match outcome {
+        Ok(()) => {
+            if b.open_until.is_some() {
+                tracing::info!(provider, "upstream API recovered");
+            }
+            *b = Breaker::default();
+        }
+        Err(e) => {
+            b.failures = b.failures.saturating_add(1);
+            b.last_error = e.to_string();
+            if b.failures >= FAILURE_THRESHOLD {
+                if b.open_until.is_none() {
+                    tracing::warn!(provider, error = %e, "upstream API failing; opening circuit");
+                }
+                b.open_until = Some(Instant::now() + OPEN_FOR);
+            }
+        }
+    }
+}
+
+fn cache_path(provider: &str, key: &str) -> PathBuf {
+    let digest = hex::encode(sha2::Sha256::digest(key.as_bytes()));
+    crate::minecraft::data_root()
+        .join("cache")
+        .join("upstream")
+        .join(provider)
+        .join(format!("{}.json", &digest[..32]))
+}
+
+fn store(path: &std::path::Path, body: &[u8]) {
+    // Best-effort: a missing copy only matters during the next outage.
+    let Some(dir) = path.parent() else {
+        return;
+    };
+    let tmp = path.with_extension("json.tmp");
+    let ok = std::fs::create_dir_all(dir).is_ok()
+        && std::fs::write(&tmp, body).is_ok()
+        && std::fs::rename(&tmp, path).is_ok();
+    if !ok {
+        let _ = std::fs::remove_file(&tmp);
+    }
+}
+
+enum Attempt<T> {
+    Ok(Vec<u8>, T),
+    // The provider answered and refused the request (4xx); not an outage.
+    Rejected(anyhow::Error),
+    Failed(String),
+}
+
+async fn attempt<T: serde::de::DeserializeOwned>(
+    provider: &'static str,
+    req: reqwest::RequestBuilder,
+) -> Attempt<T> {
+    let resp = match req.send().await {
+        Ok(v) => v,
+        Err(e) => return Attempt::Failed(e.to_string()),
+    };
+    let status = resp.status();
+    if status.is_client_error() && status != reqwest::StatusCode::TOO_MANY_REQUESTS {
+        return Attempt::Rejected(anyhow::anyhow!("{provider} API returned {status}"));
+    }
+    if !status.is_success() {
+        return Attempt::Failed(format!("HTTP {status}"));
+    }
+    let body = match resp.bytes().await {
+        Ok(v) => v.to_vec(),
+        Err(e) => return Attempt::Failed(e.to_string()),
+    };
+    match serde_json::from_slice(&body) {
+        Ok(v) => Attempt::Ok(body, v),
+        Err(e) => Attempt::Failed(format!("invalid JSON: {e}")),
+    }
+}
+
+// GETs JSON from `provider`, trying its mirrors in order and falling back to the last good
+// response for `url` when none of them answers. `request` builds the request for one mirror's URL
+// (add credentials there; they are not part of the cache key).
+pub async fn get_json<T: serde::de::DeserializeOwned>(
+    provider: &'static str,
+    url: &str,
+    request: impl Fn(&str) -> reqwest::RequestBuilder,
+) -> anyhow::Result<Fetched<T>> {
+    let path = cache_path(provider, url);
+    let reason = match admit(provider) {
+        Err(last) => format!("circuit open after repeated failures ({last})"),
+        Ok(()) => {
+            let candidates = crate::mirrors::candidates(provider, url);
+            let mut rejected = None;
+            let mut failures = Vec::new();
+            for c in &candidates {
+                match attempt::<T>(provider, request(&c.url)).await {
+                    Attempt::Ok(body, value) => {
+                        crate::mirrors::record(provider, &c.base, None);
+                        record(provider, Ok(()));
+                        store(&path, &body);
+                        return Ok(Fetched {
+                            value,
+                            is_stale: false,
+                        });
+                    }
+                    // A mirror may lack something the provider has, so keep going; the refusal
+                    // stands only if no one has it.
+                    Attempt::Rejected(e) => {
+                        rejected.get_or_insert(e);
+                    }
+                    Attempt::Failed(reason) => {
+                        crate::mirrors::record(provider, &c.base, Some(&reason));
+                        failures.push(if candidates.len() > 1 {
+                            format!("{}: {reason}", c.base)
+                        } else {
+                            reason
+                        });
+                    }
+                }
+            }
+            if let Some(e) = rejected {
+                record(provider, Ok(()));
+                return Err(e);
+            }
+            let reason = failures.join("; ");
+            record(provider, Err(reason.as_str()));
+            reason
+        }
+    };
+
+    let cached = std::fs::read(&path)
+        .ok()
+        .and_then(|body| serde_json::from_slice::<T>(&body).ok());
+    match cached {
+        Some(value) => {
+            tracing::warn!(provider, %url, %reason, "upstream unavailable; serving cached copy");
+            Ok(Fetched {
+                value,
+                is_stale: true,
+            })
+        }
+        None => Err(Unavailable { provider, reason }.into()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn opens_after_repeated_failures() {
+        const P: &str = "test-provider";
+        assert!(admit(P).is_ok());
+        for _ in 0..FAILURE_THRESHOLD {
+            record(P, Err("HTTP 503"));
+        }
+        assert_eq!(admit(P), Err("HTTP 503".to_string()));
+        record(P, Ok(()));
+        assert!(admit(P).is_ok());
+
+        let e = anyhow::Error::from(Unavailable {
+            provider: P,
+            reason: "timeout".to_string(),
+        })
+        .context("fetch version manifest");
+        assert!(is_unavailable(&e));
+        let e = anyhow::anyhow!("unknown minecraft version");
+        assert!(!is_unavailable(&e));
+    }
+}
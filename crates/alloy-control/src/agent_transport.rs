@@ -7,9 +7,17 @@ use std::{
 };
 
 use base64::Engine;
-use tokio::sync::oneshot;
+use tokio::sync::{mpsc, oneshot};
 
-use crate::agent_tunnel::{AgentConnection, AgentHub, ControlToAgentFrame, TunnelResponse};
+use crate::agent_tunnel::{
+    AgentConnection, AgentHub, ControlToAgentFrame, TopicEvent, TopicSender, TunnelResponse,
+};
+
+// Events buffered per subscription on the control side before older ones are dropped.
+const TOPIC_QUEUE: usize = 256;
+
+// Subscription ids share the agent's request id space, so they get their own prefix.
+static NEXT_SUB_ID: AtomicU64 = AtomicU64::new(1);
 
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 enum TransportMode {
@@ -89,6 +97,8 @@ fn safe_to_retry_over_direct(method: &str) -> bool {
         "/alloy.agent.v1.AgentHealthService/Check"
             | "/alloy.agent.v1.FilesystemService/GetCapabilities"
             | "/alloy.agent.v1.FilesystemService/ListDir"
+            | "/alloy.agent.v1.FilesystemService/StatBatch"
+            | "/alloy.agent.v1.FilesystemService/Hash"
             | "/alloy.agent.v1.FilesystemService/ReadFile"
             | "/alloy.agent.v1.LogsService/TailFile"
             | "/alloy.agent.v1.ProcessService/ListTemplates"
@@ -98,6 +108,15 @@ fn safe_to_retry_over_direct(method: &str) -> bool {
             | "/alloy.agent.v1.ProcessService/TailLogs"
             | "/alloy.agent.v1.InstanceService/List"
             | "/alloy.agent.v1.InstanceService/Get"
+            | "/alloy.agent.v1.InstanceService/GetLastCrash"
+            | "/alloy.agent.v1.InstanceService/GetFrpStatus"
+            | "/alloy.agent.v1.InstanceService/GetFrpStats"
+            | "/alloy.agent.v1.InstanceService/GetDivergence"
+            | "/alloy.agent.v1.InstanceService/RenderMapPreview"
+            | "/alloy.agent.v1.InstanceService/GetWebMapStatus"
+            | "/alloy.agent.v1.InstanceService/ListPlayerPositions"
+            | "/alloy.agent.v1.InstanceService/GetPlayerInventory"
+            | "/alloy.agent.v1.InstanceService/GetPlayerStats"
     )
 }
 
@@ -108,9 +127,49 @@ fn is_long_running_method(method: &str) -> bool {
             | "/alloy.agent.v1.ProcessService/StartFromTemplate"
             | "/alloy.agent.v1.InstanceService/Start"
             | "/alloy.agent.v1.InstanceService/ImportSaveFromUrl"
+            | "/alloy.agent.v1.FilesystemService/Hash"
+            | "/alloy.agent.v1.InstanceService/CreateFromGolden"
+            | "/alloy.agent.v1.InstanceService/GetDivergence"
+            | "/alloy.agent.v1.InstanceService/RenderMapPreview"
+            | "/alloy.agent.v1.InstanceService/InstallWebMap"
+            | "/alloy.agent.v1.InstanceService/ValidateStart"
+            | "/alloy.agent.v1.LogsService/PasteFile"
+            | "/alloy.agent.v1.AgentHealthService/SupportBundle"
+            | "/alloy.agent.v1.AgentHealthService/SelfTest"
     )
 }
 
+// A live topic stream from the agent. Dropping it unsubscribes.
+pub struct TopicSubscription {
+    conn: Arc<AgentConnection>,
+    id: String,
+    rx: mpsc::Receiver<TopicEvent>,
+}
+
+impl TopicSubscription {
+    // None once the agent disconnects.
+    pub async fn recv(&mut self) -> Option<TopicEvent> {
+        self.rx.recv().await
+    }
+}
+
+impl Drop for TopicSubscription {
+    fn drop(&mut self) {
+        self.conn
+            .subscriptions
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .remove(&self.id);
+        let frame = ControlToAgentFrame::Unsub { id: &self.id };
+        if let Ok(text) = serde_json::to_string(&frame) {
+            let _ = self
+                .conn
+                .tx
+                .try_send(axum::extract::ws::Message::Text(text));
+        }
+    }
+}
+
 #[derive(Clone)]
 pub struct AgentTransport {
     hub: AgentHub,
@@ -119,6 +178,7 @@ pub struct AgentTransport {
     timeout: Duration,
     next_id: Arc<AtomicU64>,
     b64: base64::engine::general_purpose::GeneralPurpose,
+    request_id: Option<String>,
 }
 
 impl AgentTransport {
@@ -130,9 +190,16 @@ impl AgentTransport {
             timeout: parse_timeout_ms(std::env::var("ALLOY_AGENT_TIMEOUT_MS").ok()),
             next_id: Arc::new(AtomicU64::new(1)),
             b64: base64::engine::general_purpose::STANDARD,
+            request_id: None,
         }
     }
 
+    // Sent with every call so agent logs can be matched to this request.
+    pub fn with_request_id(mut self, request_id: String) -> Self {
+        self.request_id = Some(request_id);
+        self
+    }
+
     pub async fn connected_nodes(&self) -> Vec<String> {
         self.hub.nodes().await
     }
@@ -199,6 +266,72 @@ impl AgentTransport {
         }
     }
 
+    // Topics are only served over the agent tunnel; direct gRPC has no equivalent.
+    pub async fn subscribe(
+        &self,
+        topic: &str,
+        filter: Option<&str>,
+    ) -> Result<TopicSubscription, tonic::Status> {
+        let Some(conn) = self.pick_tunnel_conn().await else {
+            return Err(tonic::Status::unavailable(
+                "agent is not connected (no active tunnel)",
+            ));
+        };
+
+        let id = format!("sub-{}", NEXT_SUB_ID.fetch_add(1, Ordering::Relaxed));
+        let (tx, rx) = mpsc::channel(TOPIC_QUEUE);
+        conn.subscriptions
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .insert(id.clone(), TopicSender { tx, dropped: 0 });
+        let sub = TopicSubscription {
+            conn: conn.clone(),
+            id: id.clone(),
+            rx,
+        };
+
+        let (ack_tx, ack_rx) = oneshot::channel::<TunnelResponse>();
+        conn.pending.lock().await.insert(id.clone(), ack_tx);
+
+        let frame = ControlToAgentFrame::Sub {
+            id: &id,
+            topic,
+            filter,
+        };
+        let text = serde_json::to_string(&frame)
+            .map_err(|e| tonic::Status::internal(format!("failed to encode request: {e}")))?;
+        if conn
+            .tx
+            .send(axum::extract::ws::Message::Text(text))
+            .await
+            .is_err()
+        {
+            let _ = conn.pending.lock().await.remove(&id);
+            return Err(tonic::Status::unavailable("agent tunnel send failed"));
+        }
+
+        let resp = match tokio::time::timeout(self.timeout, ack_rx).await {
+            Ok(Ok(v)) => v,
+            Ok(Err(_)) => {
+                return Err(tonic::Status::unavailable("agent tunnel disconnected"));
+            }
+            Err(_) => {
+                let _ = conn.pending.lock().await.remove(&id);
+                return Err(tonic::Status::deadline_exceeded("agent subscribe timeout"));
+            }
+        };
+        if !resp.ok {
+            let code = code_from_i32(resp.status_code.unwrap_or(2));
+            return Err(tonic::Status::new(
+                code,
+                resp.status_message
+                    .unwrap_or_else(|| "agent error".to_string()),
+            ));
+        }
+
+        Ok(sub)
+    }
+
     async fn call_tunnel_bytes<Res>(
         &self,
         method: &'static str,
@@ -223,6 +356,7 @@ impl AgentTransport {
             id: &id,
             method,
             payload_b64: &payload,
+            request_id: self.request_id.as_deref(),
         };
 
         let text = serde_json::to_string(&frame)
@@ -299,6 +433,11 @@ impl AgentTransport {
         })?;
         let mut request = tonic::Request::new(req);
         request.set_timeout(timeout);
+        if let Some(id) = self.request_id.as_deref()
+            && let Ok(v) = id.parse()
+        {
+            request.metadata_mut().insert("x-request-id", v);
+        }
 
         let path = tonic::codegen::http::uri::PathAndQuery::from_static(method);
         let codec = tonic::codec::ProstCodec::default();
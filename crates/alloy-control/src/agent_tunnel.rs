@@ -1,4 +1,7 @@
-use std::{collections::HashMap, sync::Arc};
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::Arc,
+};
 
 use axum::{
     extract::{
@@ -19,6 +22,10 @@ use crate::state::AppState;
 pub struct AgentHello {
     pub node: String,
     pub agent_version: String,
+    pub disabled_commands: Vec<String>,
+    pub node_id: String,
+    pub labels: BTreeMap<String, String>,
+    pub public_address: Option<String>,
 }
 
 #[derive(Debug, Clone, serde::Serialize)]
@@ -29,14 +36,40 @@ pub enum ControlToAgentFrame<'a> {
         id: &'a str,
         method: &'a str,
         payload_b64: &'a str,
+        // The HTTP request's id; the agent logs under it and echoes it in the `resp` frame.
+        #[serde(skip_serializing_if = "Option::is_none")]
+        request_id: Option<&'a str>,
+    },
+    #[serde(rename = "sub")]
+    Sub {
+        id: &'a str,
+        topic: &'a str,
+        filter: Option<&'a str>,
     },
+    #[serde(rename = "unsub")]
+    Unsub { id: &'a str },
+    #[serde(rename = "ack")]
+    Ack { epoch: &'a str, seq: u64 },
 }
 
 #[derive(Debug, Clone, serde::Deserialize)]
 #[serde(tag = "type")]
 pub enum AgentToControlFrame {
     #[serde(rename = "hello")]
-    Hello { node: String, agent_version: String },
+    Hello {
+        node: String,
+        agent_version: String,
+        // Absent from agents that predate command policies.
+        #[serde(default)]
+        disabled_commands: Vec<String>,
+        // The agent's stable id, labels and public address; absent from older agents.
+        #[serde(default)]
+        node_id: String,
+        #[serde(default)]
+        labels: BTreeMap<String, String>,
+        #[serde(default)]
+        public_address: Option<String>,
+    },
     #[serde(rename = "resp")]
     Resp {
         id: String,
@@ -45,6 +78,22 @@ pub enum AgentToControlFrame {
         status_code: Option<i32>,
         status_message: Option<String>,
     },
+    #[serde(rename = "event")]
+    Event {
+        sub_id: String,
+        topic: String,
+        data: serde_json::Value,
+        dropped: u64,
+    },
+    // Durable agent events (crashes, backup results), replayed after reconnects until acked.
+    #[serde(rename = "outbox")]
+    Outbox {
+        epoch: String,
+        seq: u64,
+        kind: String,
+        at_unix_ms: u64,
+        data: serde_json::Value,
+    },
     #[serde(other)]
     Unknown,
 }
@@ -57,17 +106,63 @@ pub struct TunnelResponse {
     pub status_message: Option<String>,
 }
 
+#[derive(Debug, Clone, serde::Serialize)]
+pub struct TopicEvent {
+    pub topic: String,
+    pub data: serde_json::Value,
+    // Events dropped (by the agent or here) since the previous delivery.
+    pub dropped: u64,
+}
+
+#[derive(Debug)]
+pub struct TopicSender {
+    pub tx: mpsc::Sender<TopicEvent>,
+    pub dropped: u64,
+}
+
 #[derive(Debug)]
 pub struct AgentConnection {
     pub node: String,
     pub agent_version: String,
+    // Command families and methods the agent refuses by policy, from its hello frame.
+    pub disabled_commands: Vec<String>,
+    // From the hello frame; node_id is empty for agents that predate node identities.
+    pub node_id: String,
+    pub labels: BTreeMap<String, String>,
+    pub public_address: Option<String>,
     pub tx: mpsc::Sender<Message>,
     pub pending: Mutex<HashMap<String, oneshot::Sender<TunnelResponse>>>,
+    // Live topic subscriptions by id. A std mutex so subscriptions can unregister on drop.
+    pub subscriptions: std::sync::Mutex<HashMap<String, TopicSender>>,
+}
+
+impl AgentConnection {
+    // Never blocks the socket reader: a subscriber that is not keeping up loses events, and the
+    // count is passed on with its next delivery.
+    fn deliver(&self, sub_id: &str, event: TopicEvent) {
+        let mut subs = self.subscriptions.lock().unwrap_or_else(|e| e.into_inner());
+        let Some(sub) = subs.get_mut(sub_id) else {
+            return;
+        };
+        let event = TopicEvent {
+            dropped: event.dropped + sub.dropped,
+            ..event
+        };
+        match sub.tx.try_send(event) {
+            Ok(()) => sub.dropped = 0,
+            Err(mpsc::error::TrySendError::Full(event)) => sub.dropped = event.dropped + 1,
+            Err(mpsc::error::TrySendError::Closed(_)) => {
+                subs.remove(sub_id);
+            }
+        }
+    }
 }
 
 #[derive(Clone, Default)]
 pub struct AgentHub {
     inner: Arc<RwLock<HashMap<String, Arc<AgentConnection>>>>,
+    // Last stored outbox (epoch, seq) per node; replays at or below it are duplicates.
+    outbox_seen: Arc<std::sync::Mutex<HashMap<String, (String, u64)>>>,
 }
 
 impl AgentHub {
@@ -90,6 +185,52 @@ impl AgentHub {
     pub async fn remove(&self, node: &str) {
         self.inner.write().await.remove(node);
     }
+
+    fn outbox_is_new(&self, node: &str, epoch: &str, seq: u64) -> bool {
+        let seen = self.outbox_seen.lock().unwrap_or_else(|e| e.into_inner());
+        match seen.get(node) {
+            Some((e, last)) if e == epoch => seq > *last,
+            _ => true,
+        }
+    }
+
+    fn outbox_stored(&self, node: &str, epoch: &str, seq: u64) {
+        let mut seen = self.outbox_seen.lock().unwrap_or_else(|e| e.into_inner());
+        seen.insert(node.to_string(), (epoch.to_string(), seq));
+    }
+}
+
+// Stores one replayed agent event in the audit log. Returns false if it could not be stored, in
+// which case the connection is closed without acking it, and the agent sends it again when it
+// reconnects.
+async fn store_outbox_event(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    node: &str,
+    seq: u64,
+    kind: &str,
+    at_unix_ms: u64,
+    data: serde_json::Value,
+) -> bool {
+    let model = alloy_db::entities::audit_events::ActiveModel {
+        id: Set(sea_orm::prelude::Uuid::new_v4()),
+        request_id: Set(format!("outbox:{node}:{seq}")),
+        user_id: Set(None),
+        action: Set(format!("agent.{kind}")),
+        target: Set(node.to_string()),
+        meta: Set(Some(serde_json::json!({
+            "seq": seq,
+            "at_unix_ms": at_unix_ms.to_string(),
+            "data": data,
+        }))),
+        created_at: Set(chrono::Utc::now().into()),
+    };
+    match model.insert(db).await {
+        Ok(_) => true,
+        Err(err) => {
+            tracing::warn!(%err, node, seq, kind, "failed to store agent outbox event");
+            false
+        }
+    }
 }
 
 fn configured_agent_token() -> Option<String> {
@@ -182,9 +323,17 @@ async fn handle_agent_socket(state: AppState, socket: WebSocket, auth: WsAuth) {
                     Ok(AgentToControlFrame::Hello {
                         node,
                         agent_version,
+                        disabled_commands,
+                        node_id,
+                        labels,
+                        public_address,
                     }) => AgentHello {
                         node,
                         agent_version,
+                        disabled_commands,
+                        node_id,
+                        labels,
+                        public_address,
                     },
                     _ => {
                         let _ = sender.send(Message::Close(None)).await;
@@ -272,8 +421,13 @@ async fn handle_agent_socket(state: AppState, socket: WebSocket, auth: WsAuth) {
         let conn = Arc::new(AgentConnection {
             node: node.clone(),
             agent_version: hello.agent_version,
+            disabled_commands: hello.disabled_commands,
+            node_id: hello.node_id,
+            labels: hello.labels,
+            public_address: hello.public_address,
             tx,
             pending: Mutex::new(HashMap::new()),
+            subscriptions: std::sync::Mutex::new(HashMap::new()),
         });
 
         state.agent_hub.insert(conn.clone()).await;
@@ -311,6 +465,47 @@ async fn handle_agent_socket(state: AppState, socket: WebSocket, auth: WsAuth) {
                                 });
                             }
                         }
+                        AgentToControlFrame::Event {
+                            sub_id,
+                            topic,
+                            data,
+                            dropped,
+                        } => {
+                            conn.deliver(
+                                &sub_id,
+                                TopicEvent {
+                                    topic,
+                                    data,
+                                    dropped,
+                                },
+                            );
+                        }
+                        AgentToControlFrame::Outbox {
+                            epoch,
+                            seq,
+                            kind,
+                            at_unix_ms,
+                            data,
+                        } => {
+                            if state.agent_hub.outbox_is_new(&node, &epoch, seq) {
+                                tracing::info!(seq, kind = %kind, "agent event");
+                                if !store_outbox_event(
+                                    &state.db, &node, seq, &kind, at_unix_ms, data,
+                                )
+                                .await
+                                {
+                                    // Acking a later event would drop this one from the agent's
+                                    // outbox too, so end the connection; the agent replays from
+                                    // this seq when it reconnects.
+                                    break;
+                                }
+                                state.agent_hub.outbox_stored(&node, &epoch, seq);
+                            }
+                            let ack = ControlToAgentFrame::Ack { epoch: &epoch, seq };
+                            if let Ok(text) = serde_json::to_string(&ack) {
+                                let _ = conn.tx.send(Message::Text(text)).await;
+                            }
+                        }
                         AgentToControlFrame::Hello { .. } | AgentToControlFrame::Unknown => {}
                     }
                 }
@@ -321,6 +516,11 @@ async fn handle_agent_socket(state: AppState, socket: WebSocket, auth: WsAuth) {
 
         state.agent_hub.remove(&node).await;
         let _ = conn.pending.lock().await.drain();
+        // Dropping the senders ends every panel stream fed by this agent.
+        conn.subscriptions
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clear();
 
         writer.abort();
     }
@@ -1,17 +1,27 @@
 use alloy_db::entities::audit_events;
-use sea_orm::{ActiveModelTrait, Set};
+use sea_orm::{ActiveModelTrait, DatabaseConnection, Set};
 
 use crate::rpc::Ctx;
 
 pub async fn record(ctx: &Ctx, action: &str, target: &str, meta: Option<serde_json::Value>) {
-    let user_id = ctx
-        .user
-        .as_ref()
-        .and_then(|u| sea_orm::prelude::Uuid::parse_str(&u.user_id).ok());
+    let user_id = ctx.user.as_ref().map(|u| u.user_id.as_str());
+    record_as(&ctx.db, &ctx.request_id, user_id, action, target, meta).await;
+}
+
+// For callers outside an rspc request (e.g. the panel WebSocket).
+pub async fn record_as(
+    db: &DatabaseConnection,
+    request_id: &str,
+    user_id: Option<&str>,
+    action: &str,
+    target: &str,
+    meta: Option<serde_json::Value>,
+) {
+    let user_id = user_id.and_then(|id| sea_orm::prelude::Uuid::parse_str(id).ok());
 
     let model = audit_events::ActiveModel {
         id: Set(sea_orm::prelude::Uuid::new_v4()),
-        request_id: Set(ctx.request_id.clone()),
+        request_id: Set(request_id.to_string()),
         user_id: Set(user_id),
         action: Set(action.to_string()),
         target: Set(target.to_string()),
@@ -19,7 +29,7 @@ pub async fn record(ctx: &Ctx, action: &str, target: &str, meta: Option<serde_js
         created_at: Set(chrono::Utc::now().into()),
     };
 
-    if let Err(err) = model.insert(&*ctx.db).await {
+    if let Err(err) = model.insert(db).await {
         tracing::warn!(%err, action, target, "failed to write audit event");
     }
 }
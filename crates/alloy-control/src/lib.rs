@@ -8,4 +8,5 @@ pub mod request_meta;
 pub mod rpc;
 pub mod security;
 pub mod state;
+pub mod topics;
 pub mod update;
@@ -7,6 +7,7 @@ use alloy_control::request_meta::RequestMeta;
 use alloy_control::rpc;
 use alloy_control::security;
 use alloy_control::state::AppState;
+use alloy_control::topics;
 use axum::extract::State;
 use axum::middleware;
 use axum::{
@@ -146,6 +147,7 @@ async fn init_db_and_migrate() -> anyhow::Result<AppState> {
     Ok(AppState {
         db: std::sync::Arc::new(db),
         agent_hub: agent_tunnel::AgentHub::new(),
+        panel_sessions: topics::PanelSessions::new(),
     })
 }
 
@@ -183,6 +185,7 @@ async fn main() -> anyhow::Result<()> {
             rpc::Ctx {
                 db: state.db.clone(),
                 agent_hub: state.agent_hub.clone(),
+                panel_sessions: state.panel_sessions.clone(),
                 user: user.map(|axum::Extension(u)| u),
                 request_id: meta.request_id,
             }
@@ -194,6 +197,7 @@ async fn main() -> anyhow::Result<()> {
         .route("/healthz", get(healthz))
         .route("/auth/whoami", get(auth::whoami))
         .route("/agent/ws", get(agent_tunnel::agent_ws))
+        .route("/topics/ws", get(topics::panel_ws))
         .nest("/auth", auth_router)
         .nest("/rspc", rspc_router)
         .layer(middleware::from_fn(security::request_id))
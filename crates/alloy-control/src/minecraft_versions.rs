@@ -15,6 +15,9 @@ pub struct MinecraftVersionsResponse {
     pub latest_release: String,
     pub latest_snapshot: String,
     pub versions: Vec<MinecraftVersionRef>,
+    // True when Mojang could not be reached and this is the last list we fetched.
+    pub is_stale: bool,
+    pub fetched_at: String,
 }
 
 #[derive(Debug, Clone, Deserialize)]
@@ -47,6 +50,19 @@ fn cache() -> &'static tokio::sync::RwLock<Option<(std::time::Instant, Minecraft
     MANIFEST_CACHE.get_or_init(|| tokio::sync::RwLock::new(None))
 }
 
+// Set when a fetch fails. Until FAILURE_BACKOFF has passed, listings are served from the cache
+// without contacting Mojang, so an outage doesn't add three slow retries to every request.
+static LAST_FAILURE: std::sync::Mutex<Option<std::time::Instant>> = std::sync::Mutex::new(None);
+const FAILURE_BACKOFF: Duration = Duration::from_secs(60);
+
+async fn stale_copy() -> Option<MinecraftVersionsResponse> {
+    let g = cache().read().await;
+    g.as_ref().map(|(_, v)| MinecraftVersionsResponse {
+        is_stale: true,
+        ..v.clone()
+    })
+}
+
 fn http_client() -> &'static reqwest::Client {
     static CLIENT: OnceLock<reqwest::Client> = OnceLock::new();
     CLIENT.get_or_init(|| {
@@ -82,6 +98,14 @@ pub async fn get_versions() -> anyhow::Result<MinecraftVersionsResponse> {
         }
     }
 
+    let backing_off = LAST_FAILURE
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .is_some_and(|t| t.elapsed() < FAILURE_BACKOFF);
+    if backing_off && let Some(v) = stale_copy().await {
+        return Ok(v);
+    }
+
     let url = manifest_url();
     let mut last_err: Option<anyhow::Error> = None;
     let mut manifest: Option<ManifestV2> = None;
@@ -120,9 +144,14 @@ pub async fn get_versions() -> anyhow::Result<MinecraftVersionsResponse> {
     let manifest = match manifest {
         Some(v) => v,
         None => {
+            *LAST_FAILURE.lock().unwrap_or_else(|e| e.into_inner()) =
+                Some(std::time::Instant::now());
             // Best-effort fallback: if we have any cached response (even stale), serve it.
-            if let Some((_, v)) = &*cache().read().await {
-                return Ok(v.clone());
+            if let Some(v) = stale_copy().await {
+                if let Some(e) = &last_err {
+                    tracing::warn!(error = %e, "version manifest unavailable; serving cached list");
+                }
+                return Ok(v);
             }
             return Err(
                 last_err.unwrap_or_else(|| anyhow::anyhow!("fetch version manifest failed"))
@@ -130,6 +159,8 @@ pub async fn get_versions() -> anyhow::Result<MinecraftVersionsResponse> {
         }
     };
 
+    *LAST_FAILURE.lock().unwrap_or_else(|e| e.into_inner()) = None;
+
     let mut versions = manifest
         .versions
         .into_iter()
@@ -147,6 +178,8 @@ pub async fn get_versions() -> anyhow::Result<MinecraftVersionsResponse> {
         latest_release: manifest.latest.release,
         latest_snapshot: manifest.latest.snapshot,
         versions,
+        is_stale: false,
+        fetched_at: chrono::Utc::now().to_rfc3339(),
     };
 
     {
@@ -1,10 +1,12 @@
 use alloy_proto::agent_v1::{
     ClearCacheRequest, CreateInstanceRequest, DeleteInstancePreviewRequest, DeleteInstanceRequest,
-    GetCacheStatsRequest, GetCapabilitiesRequest, GetInstanceRequest, GetStatusRequest,
+    FrpAdminRequest, GetCacheStatsRequest, GetCapabilitiesRequest, GetFrpStatsRequest,
+    GetFrpStatusRequest, GetInstanceRequest, GetLastCrashRequest, GetStatusRequest,
     GetWarmTemplateProgressRequest, HealthCheckRequest, ListDirRequest, ListInstancesRequest,
-    ListProcessesRequest, ListTemplatesRequest, ReadFileRequest, StartFromTemplateRequest,
-    StartInstanceRequest, StopInstanceRequest, StopProcessRequest, TailFileRequest,
-    TailLogsRequest, UpdateInstanceRequest, WarmTemplateCacheRequest,
+    ListProcessesRequest, ListTemplatesRequest, PasteFileRequest, ReadFileRequest, SelfTestRequest,
+    SetLogLevelRequest, StartFromTemplateRequest, StartInstanceRequest, StopInstanceRequest,
+    StopProcessRequest, SupportBundleRequest, TailFileRequest, TailLogsRequest,
+    UpdateInstanceRequest, WarmTemplateCacheRequest,
 };
 use rspc::{Procedure, ProcedureError, ResolverError, Router};
 
@@ -32,6 +34,10 @@ const DOWNLOAD_STATE_PAUSED: &str = "paused";
 const DOWNLOAD_STATE_SUCCESS: &str = "success";
 const DOWNLOAD_STATE_ERROR: &str = "error";
 const DOWNLOAD_STATE_CANCELED: &str = "canceled";
+// Jobs that fail because a provider API is down go back to the queue this many times, waiting
+// DOWNLOAD_RETRY_BASE_SECS * attempt between tries.
+const DOWNLOAD_UPSTREAM_MAX_ATTEMPTS: i32 = 6;
+const DOWNLOAD_RETRY_BASE_SECS: u64 = 30;
 
 fn random_token(n: usize) -> String {
     use base64::Engine;
@@ -602,6 +608,8 @@ fn api_error_with_field(
         request_id: ctx.request_id.clone(),
         field_errors,
         hint: None,
+        message_key: None,
+        hint_key: None,
     }
 }
 
@@ -617,6 +625,23 @@ struct DownloadQueueRuntime {
     db: Arc<alloy_db::sea_orm::DatabaseConnection>,
     agent_hub: crate::agent_tunnel::AgentHub,
     notify: Arc<tokio::sync::Notify>,
+    // Requeued jobs and when they may run again. In memory only: after a restart they run at once.
+    retry_after: Arc<std::sync::Mutex<HashMap<sea_orm::prelude::Uuid, Instant>>>,
+}
+
+impl DownloadQueueRuntime {
+    fn waiting_for_retry(&self, id: &sea_orm::prelude::Uuid) -> bool {
+        let map = self.retry_after.lock().unwrap_or_else(|e| e.into_inner());
+        map.get(id).is_some_and(|t| *t > Instant::now())
+    }
+
+    // Time until the earliest requeued job is due, dropping entries that already are.
+    fn next_retry_in(&self) -> Option<Duration> {
+        let now = Instant::now();
+        let mut map = self.retry_after.lock().unwrap_or_else(|e| e.into_inner());
+        map.retain(|_, t| *t > now);
+        map.values().min().map(|t| t.duration_since(now))
+    }
 }
 
 static DOWNLOAD_QUEUE_RUNTIME: OnceLock<DownloadQueueRuntime> = OnceLock::new();
@@ -629,6 +654,7 @@ pub fn init_download_queue_runtime(
         db,
         agent_hub,
         notify: Arc::new(tokio::sync::Notify::new()),
+        retry_after: Arc::new(std::sync::Mutex::new(HashMap::new())),
     };
 
     if DOWNLOAD_QUEUE_RUNTIME.set(runtime.clone()).is_ok() {
@@ -651,6 +677,7 @@ fn wake_download_queue_worker() {
 pub struct Ctx {
     pub db: Arc<alloy_db::sea_orm::DatabaseConnection>,
     pub agent_hub: crate::agent_tunnel::AgentHub,
+    pub panel_sessions: crate::topics::PanelSessions,
     pub user: Option<AuthUser>,
     pub request_id: String,
 }
@@ -662,6 +689,17 @@ pub struct ApiError {
     pub request_id: String,
     pub field_errors: std::collections::BTreeMap<String, String>,
     pub hint: Option<String>,
+    // Set for agent errors that carry them, so the panel can translate `message` and `hint`.
+    pub message_key: Option<MessageKey>,
+    pub hint_key: Option<MessageKey>,
+}
+
+// Stable message identifier plus parameters; the English text stays the fallback.
+#[derive(Debug, Clone, serde::Serialize, serde::Deserialize, Type)]
+pub struct MessageKey {
+    pub key: String,
+    #[serde(default)]
+    pub params: std::collections::BTreeMap<String, String>,
 }
 
 impl rspc::Error for ApiError {
@@ -685,10 +723,12 @@ fn api_error(ctx: &Ctx, code: &str, message: impl Into<String>) -> ApiError {
         request_id: ctx.request_id.clone(),
         field_errors: std::collections::BTreeMap::new(),
         hint: None,
+        message_key: None,
+        hint_key: None,
     }
 }
 
-fn is_read_only() -> bool {
+pub(crate) fn is_read_only() -> bool {
     matches!(
         std::env::var("ALLOY_READ_ONLY")
             .unwrap_or_default()
@@ -767,17 +807,27 @@ fn enforce_rate_limit(ctx: &Ctx) -> Result<(), ApiError> {
     Ok(())
 }
 
+// The same limit for writes that don't go through rspc, such as console input over the panel
+// WebSocket. It shares the user's budget, so opening more sessions doesn't raise it.
+pub(crate) fn allow_user_write(user_id: &str) -> bool {
+    RateLimiter::global().allow(&format!("user:{user_id}"))
+}
+
 const AGENT_ERROR_PREFIX: &str = "ALLOY_ERROR_JSON:";
 
 #[derive(Debug, Clone, serde::Deserialize)]
-struct AgentErrorPayload {
-    code: String,
-    message: String,
+pub(crate) struct AgentErrorPayload {
+    pub(crate) code: String,
+    pub(crate) message: String,
     field_errors: Option<std::collections::BTreeMap<String, String>>,
     hint: Option<String>,
+    #[serde(default)]
+    message_key: Option<MessageKey>,
+    #[serde(default)]
+    hint_key: Option<MessageKey>,
 }
 
-fn parse_agent_error_payload(raw: &str) -> Option<AgentErrorPayload> {
+pub(crate) fn parse_agent_error_payload(raw: &str) -> Option<AgentErrorPayload> {
     let payload = raw.trim().strip_prefix(AGENT_ERROR_PREFIX)?;
     serde_json::from_str::<AgentErrorPayload>(payload).ok()
 }
@@ -790,6 +840,8 @@ fn api_error_from_agent_status(ctx: &Ctx, action: &str, status: tonic::Status) -
             request_id: ctx.request_id.clone(),
             field_errors: payload.field_errors.unwrap_or_default(),
             hint: payload.hint,
+            message_key: payload.message_key,
+            hint_key: payload.hint_key,
         };
     }
 
@@ -821,6 +873,175 @@ pub struct AgentHealthResponse {
     pub agent_version: String,
 }
 
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct SupportBundleInput {
+    pub instance_ids: Option<Vec<String>>,
+    pub console_lines: Option<u32>,
+    pub keep_ip_addresses: Option<bool>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct SupportBundleOutput {
+    // Relative to the agent data root.
+    pub path: String,
+    // u64 as string.
+    pub size_bytes: String,
+    pub files: Vec<String>,
+    pub redactions: u32,
+    // The zip, when it was small enough to return; otherwise fetch it from the node.
+    pub data_base64: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct SelfTestInput {
+    pub skip_network: Option<bool>,
+    pub port_start: Option<u32>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct SelfTestCheckDto {
+    pub name: String,
+    // "pass", "warn", "fail" or "skipped".
+    pub outcome: String,
+    pub message: String,
+    // u64 as string.
+    pub duration_ms: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct SelfTestOutput {
+    pub ok: bool,
+    pub checks: Vec<SelfTestCheckDto>,
+    // u64 as string.
+    pub duration_ms: String,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct SetLogLevelInput {
+    // RUST_LOG syntax; empty or missing only reads the current filter.
+    pub filter: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PlacementInput {
+    pub memory_mb: Option<u32>,
+    pub disk_mb: Option<u32>,
+    pub cpu_millicores: Option<u32>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PlacementOutput {
+    pub fits: bool,
+    pub score: u32,
+    pub reasons: Vec<String>,
+    pub memory_budget_mb: u32,
+    pub memory_available_mb: u32,
+    pub memory_committed_mb: u32,
+    pub cpu_millicores: u32,
+    pub cpu_committed_millicores: u32,
+    pub disk_usable_mb: u32,
+    pub instances: u32,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct NodeInfoOutput {
+    pub node_id: String,
+    pub name: String,
+    pub labels: std::collections::BTreeMap<String, String>,
+    pub public_address: Option<String>,
+    pub agent_version: String,
+    pub os: String,
+    pub arch: String,
+    pub cpus: u32,
+    pub data_root: String,
+    pub checkpoint_supported: bool,
+    pub checkpoint_unsupported_reason: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct SetLogLevelOutput {
+    pub filter: String,
+    pub previous: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct BackupScheduleDto {
+    pub instance_id: String,
+    pub interval_minutes: String,
+    pub keep: u32,
+    // From the node's backup-schedule.json rather than the instance's params.
+    pub global: bool,
+    pub next_due_unix_ms: String,
+    pub last_run_unix_ms: Option<String>,
+    pub last_result: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct BackupScheduleErrorDto {
+    pub source: String,
+    pub message: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ScheduleReloadOutput {
+    pub poll_every_sec: String,
+    pub schedules: Vec<BackupScheduleDto>,
+    pub errors: Vec<BackupScheduleErrorDto>,
+    pub changed: Vec<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpSummaryDto {
+    pub tunnels: u32,
+    pub tunnels_running: u32,
+    pub proxies: u32,
+    pub proxies_running: u32,
+    pub cur_conns: String,
+    pub traffic_in_bytes: String,
+    pub traffic_out_bytes: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct DirCacheStatsDto {
+    pub hits: String,
+    pub misses: String,
+    pub bypassed: String,
+    pub dirs: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ClockStatusDto {
+    // u64 as string.
+    pub unix_ms: String,
+    pub timezone: String,
+    pub utc_offset_seconds: i32,
+    pub ntp_server: Option<String>,
+    // i64 as string; positive when the agent clock is behind NTP.
+    pub ntp_offset_ms: Option<String>,
+    // u64 as string.
+    pub ntp_checked_at_unix_ms: Option<String>,
+    pub ntp_error: Option<String>,
+    // i64 as string; agent clock minus control clock, including the RPC latency.
+    pub skew_vs_control_ms: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct MirrorStatusDto {
+    pub provider: String,
+    pub base: String,
+    pub position: u32,
+    pub healthy: bool,
+    // u64 as string.
+    pub successes: String,
+    // u64 as string.
+    pub failures: String,
+    pub last_error: Option<String>,
+    // u64 as string.
+    pub last_ok_unix_ms: Option<String>,
+    // u64 as string.
+    pub last_error_unix_ms: Option<String>,
+}
+
 #[derive(Debug, Clone, serde::Serialize, Type)]
 pub struct PortAvailabilityDto {
     pub port: u32,
@@ -838,6 +1059,10 @@ pub struct AgentHealthFullDto {
     pub data_root_writable: Option<bool>,
     pub data_root_free_bytes: Option<String>,
     pub ports: Option<Vec<PortAvailabilityDto>>,
+    pub frp: Option<FrpSummaryDto>,
+    pub dir_cache: Option<DirCacheStatsDto>,
+    pub clock: Option<ClockStatusDto>,
+    pub mirrors: Option<Vec<MirrorStatusDto>>,
     pub error: Option<String>,
 }
 
@@ -888,6 +1113,8 @@ pub struct ProcessStatusDto {
     pub exit_code: Option<i32>,
     pub message: Option<String>,
     pub resources: Option<ProcessResourcesDto>,
+    pub exit_category: Option<String>,
+    pub exit_reason: Option<String>,
 }
 
 #[derive(Debug, Clone, serde::Deserialize, Type)]
@@ -1019,6 +1246,8 @@ pub struct DownloadQueueMutationOutput {
 #[derive(Debug, Clone, serde::Deserialize, Type)]
 pub struct ListDirInput {
     pub path: Option<String>,
+    // Skip the agent's listing cache, e.g. for an explicit refresh.
+    pub no_cache: Option<bool>,
 }
 
 #[derive(Debug, Clone, serde::Serialize, Type)]
@@ -1034,9 +1263,95 @@ pub struct ListDirOutput {
     pub entries: Vec<DirEntryDto>,
 }
 
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct StatBatchInput {
+    pub paths: Vec<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PathStatDto {
+    pub path: String,
+    pub exists: bool,
+    pub is_dir: bool,
+    pub size_bytes: u32,
+    pub modified_unix_ms: String,
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct StatBatchOutput {
+    pub results: Vec<PathStatDto>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct FsHashInput {
+    pub path: String,
+    // "sha256" (default) or "xxh3".
+    pub algorithm: Option<String>,
+    pub quick: Option<bool>,
+    pub quick_bytes: Option<u32>,
+    pub workers: Option<u32>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FileHashDto {
+    pub path: String,
+    pub size_bytes: String,
+    pub hash: String,
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FsHashOutput {
+    pub algorithm: String,
+    pub quick: bool,
+    pub files: Vec<FileHashDto>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct FsSearchInput {
+    pub path: String,
+    pub query: String,
+    pub case_insensitive: Option<bool>,
+    pub max_matches: Option<u32>,
+    // Rejected here; exports write a file and go through fs.searchExport.
+    pub export: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct FsSearchExportInput {
+    pub path: String,
+    pub query: String,
+    pub case_insensitive: Option<bool>,
+    pub max_matches: Option<u32>,
+    // "json" or "csv"; every match is written to _exports/ under the data root.
+    pub format: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct SearchMatchDto {
+    pub path: String,
+    pub line: u32,
+    pub text: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FsSearchOutput {
+    pub matches: Vec<SearchMatchDto>,
+    pub total_matches: u32,
+    pub truncated: bool,
+    pub files_scanned: u32,
+    pub files_skipped: u32,
+    pub export_path: Option<String>,
+    pub export_truncated: bool,
+}
+
 #[derive(Debug, Clone, serde::Serialize, Type)]
 pub struct FsCapabilitiesOutput {
     pub write_enabled: bool,
+    pub disabled_commands: Vec<String>,
+    // Backups younger than this can't be deleted; 0 when protection is off.
+    pub backup_protect_hours: u32,
 }
 
 #[derive(Debug, Clone, serde::Serialize, Type)]
@@ -1087,6 +1402,25 @@ pub struct TailFileOutput {
     pub next_cursor: String,
 }
 
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PasteLogInput {
+    pub path: String,
+    pub filter: Option<String>,
+    pub max_lines: Option<u32>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PasteLogOutput {
+    pub url: String,
+    pub raw_url: Option<String>,
+    pub service: String,
+    pub lines: u32,
+    // u64 as string.
+    pub bytes: String,
+    pub redactions: u32,
+    pub truncated: bool,
+}
+
 #[derive(Debug, Clone, serde::Serialize, Type)]
 pub struct NodeDto {
     pub id: String,
@@ -1097,6 +1431,13 @@ pub struct NodeDto {
     pub last_seen_at: Option<String>,
     pub agent_version: Option<String>,
     pub last_error: Option<String>,
+    // Policy advertised by the connected agent; empty while it is offline.
+    pub disabled_commands: Vec<String>,
+    // Identity advertised by the connected agent (see the agent's node_identity); unset while it
+    // is offline.
+    pub node_id: Option<String>,
+    pub labels: std::collections::BTreeMap<String, String>,
+    pub public_address: Option<String>,
 }
 
 #[derive(Debug, Clone, serde::Serialize, Type)]
@@ -1144,6 +1485,33 @@ pub struct FrpNodeDeleteOutput {
     pub ok: bool,
 }
 
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PanelSessionDto {
+    pub session_id: String,
+    pub user_id: String,
+    pub username: String,
+    pub remote_addr: Option<String>,
+    pub user_agent: Option<String>,
+    pub connected_at_unix_ms: String,
+    pub topics: Vec<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PanelSessionsOutput {
+    pub sessions: Vec<PanelSessionDto>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PanelSessionDisconnectInput {
+    pub session_id: String,
+    pub reason: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PanelSessionDisconnectOutput {
+    pub disconnected: bool,
+}
+
 #[derive(Debug, Clone, serde::Deserialize, Type)]
 pub struct NodeCreateInput {
     pub name: String,
@@ -1168,6 +1536,11 @@ pub struct InstanceConfigDto {
     pub template_id: String,
     pub params: std::collections::BTreeMap<String, String>,
     pub display_name: Option<String>,
+    pub golden: bool,
+    pub derived_from: Option<String>,
+    // The node the instance lives on; unset for agents that predate node identities.
+    pub node_id: Option<String>,
+    pub node_name: Option<String>,
 }
 
 #[derive(Debug, Clone, serde::Serialize, Type)]
@@ -1187,6 +1560,16 @@ pub struct StopInstanceInput {
     pub timeout_ms: Option<u32>,
 }
 
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct HibernateInstanceOutput {
+    pub status: ProcessStatusDto,
+    // False when the agent stopped the server normally; see fallback_reason.
+    pub checkpointed: bool,
+    pub fallback_reason: Option<String>,
+    pub checkpoint_bytes: String,
+    pub elapsed_ms: String,
+}
+
 #[derive(Debug, Clone, serde::Deserialize, Type)]
 pub struct RestartInstanceInput {
     pub instance_id: String,
@@ -1210,6 +1593,102 @@ pub struct InstanceDiagnosticsOutput {
     pub console_log_lines: Vec<String>,
 }
 
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct CrashRecordDto {
+    pub process_id: String,
+    pub template_id: String,
+    pub exited_at_unix_ms: String,
+    pub runtime_ms: String,
+    pub exit_code: Option<i32>,
+    pub signal: Option<i32>,
+    pub exit_category: Option<String>,
+    pub exit_reason: Option<String>,
+    pub hs_err_path: Option<String>,
+    pub lines: Vec<String>,
+    pub path: String,
+    // Request id of the start that launched the run, for matching it to audit entries.
+    pub start_request_id: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpProxyStatusDto {
+    pub configured_name: String,
+    pub name: String,
+    pub collisions: u32,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpFailoverEventDto {
+    pub at_unix_ms: String,
+    pub from: String,
+    pub to: String,
+    pub reason: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpProxySecurityDto {
+    pub name: String,
+    pub use_encryption: bool,
+    pub use_compression: bool,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpSecurityPostureDto {
+    // None when frpc's default applies (on since frp 0.50).
+    pub tls_enable: Option<bool>,
+    pub level: String,
+    pub proxies: Vec<FrpProxySecurityDto>,
+    pub warnings: Vec<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct InstanceFrpStatusOutput {
+    pub configured: bool,
+    pub state: String,
+    pub proxies: Vec<FrpProxyStatusDto>,
+    pub last_error: Option<String>,
+    pub updated_at_unix_ms: String,
+    pub endpoints: Vec<String>,
+    pub active_endpoint: Option<String>,
+    pub failovers: Vec<FrpFailoverEventDto>,
+    pub security: Option<FrpSecurityPostureDto>,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct InstanceFrpAdminInput {
+    pub instance_id: String,
+    // "status" or "reload".
+    pub action: String,
+    // reload only: config to apply and save; omitted reloads the saved config.
+    pub frp_config: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct InstanceFrpAdminOutput {
+    pub output: String,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct FrpProxyStatsDto {
+    pub name: String,
+    pub proxy_type: Option<String>,
+    pub state: Option<String>,
+    pub remote_addr: Option<String>,
+    pub error: Option<String>,
+    // Only set when the frps dashboard is configured for the tunnel.
+    pub cur_conns: Option<String>,
+    pub traffic_in_bytes: Option<String>,
+    pub traffic_out_bytes: Option<String>,
+    pub last_heartbeat_unix_ms: Option<String>,
+}
+
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct InstanceFrpStatsOutput {
+    pub configured: bool,
+    pub proxies: Vec<FrpProxyStatsDto>,
+    pub sampled_at_unix_ms: Option<String>,
+}
+
 #[derive(Debug, Clone, serde::Deserialize, Type)]
 pub struct UpdateInstanceInput {
     pub instance_id: String,
@@ -1289,717 +1768,1344 @@ pub struct DeleteInstanceOutput {
 }
 
 #[derive(Debug, Clone, serde::Deserialize, Type)]
-pub struct NodeSetEnabledInput {
-    pub node_id: String,
-    pub enabled: bool,
+pub struct SetGoldenInput {
+    pub instance_id: String,
+    pub golden: bool,
 }
 
-fn map_instance_config(cfg: alloy_proto::agent_v1::InstanceConfig) -> InstanceConfigDto {
-    InstanceConfigDto {
-        instance_id: cfg.instance_id,
-        template_id: cfg.template_id,
-        params: cfg.params.into_iter().collect(),
-        display_name: if cfg.display_name.trim().is_empty() {
-            None
-        } else {
-            Some(cfg.display_name)
-        },
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct CreateFromGoldenInput {
+    pub golden_instance_id: String,
+    pub display_name: Option<String>,
 }
 
-fn map_param_type(t: i32) -> ParamTypeDto {
-    match t {
-        x if x == alloy_proto::agent_v1::ParamType::String as i32 => ParamTypeDto::String,
-        x if x == alloy_proto::agent_v1::ParamType::Int as i32 => ParamTypeDto::Int,
-        x if x == alloy_proto::agent_v1::ParamType::Bool as i32 => ParamTypeDto::Bool,
-        _ => ParamTypeDto::String,
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct CreateFromGoldenOutput {
+    pub config: InstanceConfigDto,
+    pub reflinked_files: u32,
+    pub linked_files: u32,
+    pub copied_files: u32,
+    pub size_bytes: String,
+    pub elapsed_ms: String,
 }
 
-fn map_template_param(p: alloy_proto::agent_v1::TemplateParam) -> TemplateParamDto {
-    let kind = map_param_type(p.r#type);
-    TemplateParamDto {
-        key: p.key,
-        label: p.label,
-        kind,
-        required: p.required,
-        default_value: p.default_value,
-        min_int: if matches!(kind, ParamTypeDto::Int) && (p.min_int != 0 || p.max_int != 0) {
-            Some(p.min_int.clamp(i32::MIN as i64, i32::MAX as i64) as i32)
-        } else {
-            None
-        },
-        max_int: if matches!(kind, ParamTypeDto::Int) && (p.min_int != 0 || p.max_int != 0) {
-            Some(p.max_int.clamp(i32::MIN as i64, i32::MAX as i64) as i32)
-        } else {
-            None
-        },
-        enum_values: p.enum_values,
-        secret: p.secret,
-        placeholder: if p.placeholder.trim().is_empty() {
-            None
-        } else {
-            Some(p.placeholder)
-        },
-        help: if p.help.trim().is_empty() {
-            None
-        } else {
-            Some(p.help)
-        },
-        advanced: p.advanced,
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct MapPreviewInput {
+    pub instance_id: String,
+    pub dimension: Option<String>,
+    pub zoom: Option<u32>,
+    pub min_x: Option<i32>,
+    pub min_z: Option<i32>,
+    pub max_x: Option<i32>,
+    pub max_z: Option<i32>,
 }
 
-fn map_process_status(p: alloy_proto::agent_v1::ProcessStatus) -> ProcessStatusDto {
-    ProcessStatusDto {
-        process_id: p.process_id.clone(),
-        template_id: p.template_id.clone(),
-        state: p.state().as_str_name().to_string(),
-        pid: if p.has_pid { Some(p.pid) } else { None },
-        exit_code: if p.has_exit_code {
-            Some(p.exit_code)
-        } else {
-            None
-        },
-        message: if p.message.is_empty() {
-            None
-        } else {
-            Some(p.message)
-        },
-        resources: p.resources.map(|r| ProcessResourcesDto {
-            cpu_percent_x100: r.cpu_percent_x100,
-            rss_bytes: r.rss_bytes.to_string(),
-            read_bytes: r.read_bytes.to_string(),
-            write_bytes: r.write_bytes.to_string(),
-        }),
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct MapPreviewOutput {
+    pub path: String,
+    pub png_base64: String,
+    pub width: u32,
+    pub height: u32,
+    pub zoom: u32,
+    pub min_x: i32,
+    pub min_z: i32,
+    pub max_x: i32,
+    pub max_z: i32,
+    pub chunks_rendered: u32,
+    pub chunks_flat: u32,
+    pub dimension: String,
+    pub seed: Option<String>,
 }
 
-fn map_instance_info(
-    ctx: &Ctx,
-    info: alloy_proto::agent_v1::InstanceInfo,
-) -> Result<InstanceInfoDto, ApiError> {
-    let cfg = info
-        .config
-        .ok_or_else(|| api_error(ctx, "internal", "missing instance config"))?;
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct InstanceDivergenceOutput {
+    pub golden_instance_id: String,
+    pub shared_bytes: String,
+    pub diverged_bytes: String,
+    pub diverged_files: u32,
+    pub removed_files: u32,
+}
 
-    Ok(InstanceInfoDto {
-        config: map_instance_config(cfg),
-        status: info.status.map(map_process_status),
-    })
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PlayerPositionsInput {
+    pub instance_id: String,
+    pub player: Option<String>,
 }
 
-fn clamp_u64_to_u32(v: u64) -> u32 {
-    if v > u32::MAX as u64 {
-        u32::MAX
-    } else {
-        v as u32
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct BlockPositionDto {
+    pub dimension: String,
+    pub x: i32,
+    pub y: i32,
+    pub z: i32,
 }
 
-fn agent_transport(ctx: &Ctx) -> AgentTransport {
-    AgentTransport::new(ctx.agent_hub.clone())
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PlayerPositionDto {
+    pub uuid: String,
+    pub name: Option<String>,
+    pub dimension: String,
+    pub x: f64,
+    pub y: f64,
+    pub z: f64,
+    pub yaw: f32,
+    pub pitch: f32,
+    pub health: f32,
+    pub xp_level: i32,
+    pub game_mode: Option<String>,
+    pub last_death: Option<BlockPositionDto>,
+    pub respawn: Option<BlockPositionDto>,
+    pub saved_at_unix_ms: String,
+    pub error: Option<String>,
 }
 
-async fn verify_steamcmd_login_via_agent(
-    ctx: &Ctx,
-    username: &str,
-    password: &str,
-    steam_guard_code: Option<&str>,
-) -> Result<(), ApiError> {
-    let transport = agent_transport(ctx);
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PlayerInventoryInput {
+    pub instance_id: String,
+    pub player: String,
+    pub backup_path: Option<String>,
+}
 
-    let mut verify_params = std::collections::BTreeMap::<String, String>::new();
-    verify_params.insert("steam_username".to_string(), username.to_string());
-    verify_params.insert("steam_password".to_string(), password.to_string());
-    if let Some(code) = steam_guard_code
-        && !code.trim().is_empty()
-    {
-        verify_params.insert("steam_guard_code".to_string(), code.trim().to_string());
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct InventoryItemDto {
+    pub slot: String,
+    pub id: String,
+    pub count: i32,
+    pub damage: i32,
+    pub custom_name: Option<String>,
+    pub enchantments: Vec<String>,
+}
 
-    let _verified: alloy_proto::agent_v1::WarmTemplateCacheResponse = transport
-        .call(
-            "/alloy.agent.v1.ProcessService/WarmTemplateCache",
-            WarmTemplateCacheRequest {
-                template_id: "steamcmd:auth".to_string(),
-                params: verify_params.into_iter().collect(),
-                progress_id: String::new(),
-            },
-        )
-        .await
-        .map_err(|status| {
-            api_error_from_agent_status(ctx, "settings.setSteamcmdCredentials.verify", status)
-        })?;
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PlayerInventoryOutput {
+    pub uuid: String,
+    pub name: Option<String>,
+    pub backup_path: Option<String>,
+    pub items: Vec<InventoryItemDto>,
+    pub ender_items: Vec<InventoryItemDto>,
+    pub xp_level: i32,
+}
 
-    Ok(())
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct RestorePlayerDataInput {
+    pub instance_id: String,
+    pub player: String,
+    pub backup_path: String,
 }
 
-async fn setting_get(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    key: &str,
-) -> Result<Option<String>, sea_orm::DbErr> {
-    use alloy_db::entities::settings;
-    use sea_orm::EntityTrait;
-    Ok(settings::Entity::find_by_id(key.to_string())
-        .one(db)
-        .await?
-        .map(|m| m.value))
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct RestorePlayerDataOutput {
+    pub uuid: String,
+    pub previous_path: Option<String>,
+    pub items: u32,
+    pub ender_items: u32,
 }
 
-async fn setting_is_set(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    key: &str,
-) -> Result<bool, sea_orm::DbErr> {
-    Ok(setting_get(db, key)
-        .await?
-        .is_some_and(|v| !v.trim().is_empty()))
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PlayerStatsInput {
+    pub instance_id: String,
+    pub player: Option<String>,
+    pub top_blocks: Option<u32>,
 }
 
-async fn setting_set(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    key: &str,
-    value: &str,
-    is_secret: bool,
-) -> Result<(), sea_orm::DbErr> {
-    use alloy_db::entities::settings;
-    use sea_orm::{EntityTrait, Set};
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct BlockCountDto {
+    pub id: String,
+    pub count: String,
+}
 
-    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-    let model = settings::ActiveModel {
-        key: Set(key.to_string()),
-        value: Set(value.to_string()),
-        is_secret: Set(is_secret),
-        created_at: Set(now),
-        updated_at: Set(now),
-    };
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PlayerStatsDto {
+    pub uuid: Option<String>,
+    pub name: Option<String>,
+    pub play_time_sec: String,
+    pub deaths: String,
+    pub mob_kills: String,
+    pub player_kills: String,
+    pub jumps: String,
+    pub distance_m: String,
+    pub blocks_mined: String,
+    pub items_crafted: String,
+    pub top_mined: Vec<BlockCountDto>,
+    pub advancements_done: u32,
+    pub error: Option<String>,
+}
 
-    settings::Entity::insert(model)
-        .on_conflict(
-            sea_orm::sea_query::OnConflict::column(settings::Column::Key)
-                .update_columns([
-                    settings::Column::Value,
-                    settings::Column::IsSecret,
-                    settings::Column::UpdatedAt,
-                ])
-                .to_owned(),
-        )
-        .exec(db)
-        .await?;
-    Ok(())
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PlayerStatsOutput {
+    pub players: Vec<PlayerStatsDto>,
+    pub totals: PlayerStatsDto,
 }
 
-async fn setting_set_secret(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    key: &str,
-    value: &str,
-) -> Result<(), sea_orm::DbErr> {
-    setting_set(db, key, value, true).await
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ValidateStartOutput {
+    pub ok: bool,
+    pub verdict: String,
+    pub message: String,
+    pub jar_path: Option<String>,
+    pub main_class: Option<String>,
+    pub exit_code: Option<i32>,
+    pub timed_out: bool,
+    pub network_isolated: bool,
+    pub duration_ms: String,
+    pub output: Vec<String>,
 }
 
-async fn setting_clear(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    key: &str,
-) -> Result<(), sea_orm::DbErr> {
-    use alloy_db::entities::settings;
-    use sea_orm::EntityTrait;
-    let _ = settings::Entity::delete_by_id(key.to_string())
-        .exec(db)
-        .await?;
-    Ok(())
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct JarVerificationOutput {
+    pub state: String,
+    pub provider: Option<String>,
+    pub source_url: Option<String>,
+    pub version: Option<String>,
+    pub expected_sha1: Option<String>,
+    pub expected_size: Option<String>,
+    pub actual_sha1: Option<String>,
+    pub actual_size: String,
+    pub recorded_at_unix_ms: Option<String>,
 }
 
-fn normalize_download_target(raw: &str) -> Option<&'static str> {
-    match raw.trim() {
-        "minecraft_vanilla" => Some("minecraft_vanilla"),
-        "terraria_vanilla" => Some("terraria_vanilla"),
-        _ => None,
-    }
+#[derive(Debug, Clone, serde::Serialize, serde::Deserialize, Type)]
+pub struct PropertyValueDto {
+    pub key: String,
+    pub value: String,
 }
 
-fn expected_template_id_for_target(target: &str) -> Option<&'static str> {
-    match target {
-        "minecraft_vanilla" => Some("minecraft:vanilla"),
-        "terraria_vanilla" => Some("terraria:vanilla"),
-        _ => None,
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PropertyProfileDto {
+    pub name: String,
+    pub description: Option<String>,
+    pub builtin: bool,
+    pub values: Vec<PropertyValueDto>,
 }
 
-fn normalize_download_template_id(raw: &str) -> Option<&'static str> {
-    match raw.trim() {
-        "minecraft:vanilla" => Some("minecraft:vanilla"),
-        "terraria:vanilla" => Some("terraria:vanilla"),
-        _ => None,
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct SavePropertyProfileInput {
+    pub name: String,
+    pub description: Option<String>,
+    pub values: Vec<PropertyValueDto>,
 }
 
-fn download_state_rank(state: &str) -> i32 {
-    match state {
-        DOWNLOAD_STATE_RUNNING => 0,
-        DOWNLOAD_STATE_QUEUED => 1,
-        DOWNLOAD_STATE_PAUSED => 2,
-        DOWNLOAD_STATE_ERROR => 3,
-        DOWNLOAD_STATE_SUCCESS => 4,
-        DOWNLOAD_STATE_CANCELED => 5,
-        _ => 9,
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct PropertyProfileNameInput {
+    pub name: String,
 }
 
-fn normalize_download_version(raw: &str) -> String {
-    let trimmed = raw.trim();
-    if trimmed.is_empty() {
-        "latest".to_string()
-    } else {
-        trimmed.chars().take(128).collect()
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct DeletePropertyProfileOutput {
+    pub deleted: bool,
 }
 
-fn parse_download_job_params(raw: &str) -> std::collections::BTreeMap<String, String> {
-    let Ok(value) = serde_json::from_str::<serde_json::Value>(raw) else {
-        return std::collections::BTreeMap::new();
-    };
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct ApplyPropertyProfilesInput {
+    pub instance_id: String,
+    // Applied in order; later profiles override earlier ones.
+    pub profiles: Vec<String>,
+    pub dry_run: Option<bool>,
+}
 
-    let mut out = std::collections::BTreeMap::<String, String>::new();
-    let serde_json::Value::Object(map) = value else {
-        return out;
-    };
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PropertyChangeDto {
+    pub key: String,
+    // None when the key was not set before.
+    pub old_value: Option<String>,
+    pub new_value: String,
+}
 
-    for (k, v) in map {
-        let key = k.trim();
-        if key.is_empty() || key.len() > 120 {
-            continue;
-        }
-        let value = match v {
-            serde_json::Value::Null => continue,
-            serde_json::Value::String(s) => s,
-            other => other.to_string(),
-        };
-        if value.len() > 8192 {
-            continue;
-        }
-        out.insert(key.to_string(), value);
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ApplyPropertyProfilesOutput {
+    pub changes: Vec<PropertyChangeDto>,
+    pub applied: bool,
+    pub restart_required: bool,
+}
 
-    out
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct ConfigSchemaInput {
+    pub instance_id: String,
+    pub file: Option<String>,
 }
 
-fn serialize_download_job_params(
-    params: &std::collections::BTreeMap<String, String>,
-) -> Result<String, String> {
-    let mut filtered = std::collections::BTreeMap::<String, String>::new();
-    for (k, v) in params {
-        let key = k.trim();
-        if key.is_empty() || key.len() > 120 {
-            continue;
-        }
-        filtered.insert(key.to_string(), v.chars().take(8192).collect());
-    }
-    serde_json::to_string(&filtered).map_err(|e| format!("invalid params json: {e}"))
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ConfigKeyDto {
+    pub file: String,
+    pub key: String,
+    pub kind: String,
+    pub min: Option<f64>,
+    pub max: Option<f64>,
+    pub default_value: String,
+    pub description: String,
+    pub perf: bool,
+    pub value: Option<String>,
+    pub problem: Option<String>,
 }
 
-fn dt_to_unix_ms(dt: chrono::DateTime<chrono::FixedOffset>) -> String {
-    dt.timestamp_millis().to_string()
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ConfigSchemaOutput {
+    pub keys: Vec<ConfigKeyDto>,
 }
 
-#[derive(Debug, Clone)]
-struct DownloadProgressSnapshot {
-    stage: String,
-    downloaded_bytes: u64,
-    total_bytes: u64,
-    speed_bytes_per_sec: u64,
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PerfFindingDto {
+    pub file: String,
+    pub key: String,
+    pub value: String,
+    pub is_default: bool,
+    pub suggested: String,
+    pub severity: String,
+    pub message: String,
 }
 
-fn download_progress_id(id: &sea_orm::prelude::Uuid, attempt_count: i32) -> String {
-    format!("{}:{}", id, attempt_count.max(0))
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct PerfAuditOutput {
+    pub files: Vec<String>,
+    pub findings: Vec<PerfFindingDto>,
 }
 
-fn progress_percent_x100(downloaded_bytes: u64, total_bytes: u64) -> Option<u32> {
-    if total_bytes == 0 {
-        return None;
-    }
-    let pct = downloaded_bytes
-        .saturating_mul(10_000)
-        .checked_div(total_bytes)
-        .unwrap_or(0)
-        .min(10_000);
-    Some(pct as u32)
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct EnvReportInput {
+    pub instance_id: String,
+    // Backup zip (relative to the data root) to compare with.
+    pub backup_path: Option<String>,
 }
 
-fn progress_eta_sec(downloaded_bytes: u64, total_bytes: u64, speed_bytes_per_sec: u64) -> Option<u32> {
-    if speed_bytes_per_sec == 0 || total_bytes <= downloaded_bytes {
-        return None;
-    }
-    let left = total_bytes.saturating_sub(downloaded_bytes);
-    let eta = left.saturating_add(speed_bytes_per_sec.saturating_sub(1)) / speed_bytes_per_sec;
-    Some(eta.min(u32::MAX as u64) as u32)
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct EnvFileDto {
+    pub path: String,
+    pub size_bytes: String,
+    pub sha256: String,
 }
 
-fn map_download_job_model(
-    model: alloy_db::entities::download_jobs::Model,
-    progress: Option<&DownloadProgressSnapshot>,
-) -> DownloadQueueJobDto {
-    let progress_stage = progress
-        .and_then(|p| {
-            let s = p.stage.trim();
-            if s.is_empty() {
-                None
-            } else {
-                Some(s.to_string())
-            }
-        });
-    let progress_downloaded_bytes = progress.map(|p| p.downloaded_bytes.to_string());
-    let progress_total_bytes = progress.map(|p| p.total_bytes.to_string());
-    let progress_speed_bytes_per_sec = progress.map(|p| p.speed_bytes_per_sec.to_string());
-    let progress_percent_x100 = progress.and_then(|p| {
-        progress_percent_x100(p.downloaded_bytes, p.total_bytes)
-    });
-    let progress_eta_sec = progress.and_then(|p| {
-        progress_eta_sec(p.downloaded_bytes, p.total_bytes, p.speed_bytes_per_sec)
-    });
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct EnvReportDto {
+    pub generated_at_unix_ms: String,
+    pub template_id: String,
+    pub node_id: String,
+    pub agent_version: String,
+    pub java_version: Option<String>,
+    pub jvm_flags: Vec<String>,
+    pub server_jar: Option<EnvFileDto>,
+    pub jar_provider: Option<String>,
+    pub jar_version: Option<String>,
+    pub mods: Vec<EnvFileDto>,
+    pub config: std::collections::BTreeMap<String, String>,
+    pub params: std::collections::BTreeMap<String, String>,
+}
 
-    DownloadQueueJobDto {
-        id: model.id.to_string(),
-        target: model.target,
-        template_id: model.template_id,
-        version: model.version,
-        params: parse_download_job_params(&model.params_json),
-        state: model.state,
-        message: model.message,
-        request_id: model.request_id,
-        queue_position: model.queue_position.to_string(),
-        attempt_count: model.attempt_count,
-        created_at_unix_ms: dt_to_unix_ms(model.created_at),
-        started_at_unix_ms: model.started_at.map(dt_to_unix_ms),
-        updated_at_unix_ms: dt_to_unix_ms(model.updated_at),
-        finished_at_unix_ms: model.finished_at.map(dt_to_unix_ms),
-        progress_stage,
-        progress_downloaded_bytes,
-        progress_total_bytes,
-        progress_speed_bytes_per_sec,
-        progress_percent_x100,
-        progress_eta_sec,
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct EnvReportOutput {
+    pub current: Option<EnvReportDto>,
+    // Absent without backup_path or for backups taken before reports existed.
+    pub backup: Option<EnvReportDto>,
+    pub differences: Vec<String>,
+}
+
+fn env_file_dto(f: alloy_proto::agent_v1::EnvFile) -> EnvFileDto {
+    EnvFileDto {
+        path: f.path,
+        size_bytes: f.size_bytes.to_string(),
+        sha256: f.sha256,
     }
 }
 
-async fn download_queue_is_paused(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-) -> Result<bool, sea_orm::DbErr> {
-    Ok(setting_get(db, SETTING_DOWNLOAD_QUEUE_PAUSED)
-        .await?
-        .is_some_and(|v| matches!(v.trim(), "1" | "true" | "yes" | "on")))
+fn env_report_dto(r: alloy_proto::agent_v1::EnvReport) -> EnvReportDto {
+    EnvReportDto {
+        generated_at_unix_ms: r.generated_at_unix_ms.to_string(),
+        template_id: r.template_id,
+        node_id: r.node_id,
+        agent_version: r.agent_version,
+        java_version: (!r.java_version.is_empty()).then_some(r.java_version),
+        jvm_flags: r.jvm_flags,
+        server_jar: r.server_jar.map(env_file_dto),
+        jar_provider: (!r.jar_provider.is_empty()).then_some(r.jar_provider),
+        jar_version: (!r.jar_version.is_empty()).then_some(r.jar_version),
+        mods: r.mods.into_iter().map(env_file_dto).collect(),
+        config: r.config.into_iter().collect(),
+        params: r.params.into_iter().collect(),
+    }
 }
 
-async fn download_queue_set_paused(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    paused: bool,
-) -> Result<(), sea_orm::DbErr> {
-    if paused {
-        setting_set(db, SETTING_DOWNLOAD_QUEUE_PAUSED, "1", false).await
-    } else {
-        setting_clear(db, SETTING_DOWNLOAD_QUEUE_PAUSED).await
-    }
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ManagedFileDto {
+    pub path: String,
+    pub policy: String,
+    pub sha256: String,
+    pub state: String,
+    pub actual_sha256: Option<String>,
 }
 
-async fn download_queue_snapshot(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    agent_hub: &crate::agent_tunnel::AgentHub,
-) -> Result<DownloadQueueOutput, sea_orm::DbErr> {
-    use alloy_db::entities::download_jobs;
-    use sea_orm::{EntityTrait, QueryOrder};
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct ManagedFilesOutput {
+    pub files: Vec<ManagedFileDto>,
+}
 
-    let queue_paused = download_queue_is_paused(db).await?;
-    let mut rows = download_jobs::Entity::find()
-        .order_by_desc(download_jobs::Column::UpdatedAt)
-        .all(db)
-        .await?;
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct ManageFileInput {
+    pub instance_id: String,
+    pub path: String,
+    pub policy: Option<String>,
+    // Desired content; the file's current content is pinned when omitted.
+    pub content: Option<String>,
+}
 
-    rows.sort_by(|a, b| {
-        let ar = download_state_rank(&a.state);
-        let br = download_state_rank(&b.state);
-        if ar != br {
-            return ar.cmp(&br);
-        }
-        if ar <= 2 {
-            if a.queue_position != b.queue_position {
-                return a.queue_position.cmp(&b.queue_position);
-            }
-            return a.created_at.cmp(&b.created_at);
-        }
-        b.updated_at.cmp(&a.updated_at)
-    });
-    if rows.len() > 80 {
-        rows.truncate(80);
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct UnmanageFileInput {
+    pub instance_id: String,
+    pub path: String,
+}
 
-    let mut progress_by_id = HashMap::<String, DownloadProgressSnapshot>::new();
-    let running_jobs: Vec<_> = rows
-        .iter()
-        .filter(|r| r.state == DOWNLOAD_STATE_RUNNING)
-        .collect();
-    if !running_jobs.is_empty() {
-        let transport = AgentTransport::new(agent_hub.clone());
-        for row in running_jobs {
-            let pid = download_progress_id(&row.id, row.attempt_count);
-            let resp = transport
-                .call::<_, alloy_proto::agent_v1::GetWarmTemplateProgressResponse>(
-                    "/alloy.agent.v1.ProcessService/GetWarmTemplateProgress",
-                    GetWarmTemplateProgressRequest {
-                        progress_id: pid.clone(),
-                    },
-                )
-                .await;
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct UnmanageFileOutput {
+    pub removed: bool,
+}
 
-            let Ok(progress) = resp else {
-                continue;
-            };
-            if !progress.found {
-                continue;
-            }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct InstallWebMapInput {
+    pub instance_id: String,
+    pub kind: String,
+    pub port: Option<u16>,
+    pub minecraft_version: Option<String>,
+    pub loader: Option<String>,
+}
 
-            progress_by_id.insert(
-                pid,
-                DownloadProgressSnapshot {
-                    stage: progress.stage,
-                    downloaded_bytes: progress.downloaded_bytes,
-                    total_bytes: progress.total_bytes,
-                    speed_bytes_per_sec: progress.speed_bytes_per_sec,
-                },
-            );
-        }
-    }
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct ExposeWebMapInput {
+    pub instance_id: String,
+    pub domain: Option<String>,
+    pub public_port: Option<u16>,
+    pub remove: Option<bool>,
+}
 
-    Ok(DownloadQueueOutput {
-        queue_paused,
-        jobs: rows
+#[derive(Debug, Clone, serde::Serialize, Type)]
+pub struct WebMapStatusOutput {
+    pub installed: bool,
+    pub kind: Option<String>,
+    pub version_number: Option<String>,
+    pub mod_path: Option<String>,
+    pub port: u32,
+    pub config_port: Option<u32>,
+    pub listening: bool,
+    pub local_url: Option<String>,
+    pub exposed: bool,
+    pub public_url: Option<String>,
+    pub auth_user: Option<String>,
+    pub auth_password: Option<String>,
+    pub proxy_state: Option<String>,
+    pub proxy_error: Option<String>,
+    pub reloaded: bool,
+}
+
+#[derive(Debug, Clone, serde::Deserialize, Type)]
+pub struct NodeSetEnabledInput {
+    pub node_id: String,
+    pub enabled: bool,
+}
+
+fn map_search_response(resp: alloy_proto::agent_v1::SearchResponse) -> FsSearchOutput {
+    FsSearchOutput {
+        matches: resp
+            .matches
             .into_iter()
-            .map(|row| {
-                let key = download_progress_id(&row.id, row.attempt_count);
-                let progress = progress_by_id.get(&key);
-                map_download_job_model(row, progress)
+            .map(|m| SearchMatchDto {
+                path: m.path,
+                line: clamp_u64_to_u32(m.line),
+                text: m.text,
             })
             .collect(),
-    })
+        total_matches: clamp_u64_to_u32(resp.total_matches),
+        truncated: resp.truncated,
+        files_scanned: clamp_u64_to_u32(resp.files_scanned),
+        files_skipped: clamp_u64_to_u32(resp.files_skipped),
+        export_path: (!resp.export_path.is_empty()).then_some(resp.export_path),
+        export_truncated: resp.export_truncated,
+    }
 }
 
-async fn download_queue_next_position(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-) -> Result<i64, sea_orm::DbErr> {
-    use alloy_db::entities::download_jobs;
-    use sea_orm::{EntityTrait, QueryOrder};
-
-    let top = download_jobs::Entity::find()
-        .order_by_desc(download_jobs::Column::QueuePosition)
-        .one(db)
-        .await?;
-    Ok(top.map(|m| m.queue_position.saturating_add(1)).unwrap_or(1))
+fn map_block_position(b: alloy_proto::agent_v1::BlockPosition) -> BlockPositionDto {
+    BlockPositionDto {
+        dimension: b.dimension,
+        x: b.x,
+        y: b.y,
+        z: b.z,
+    }
 }
 
-async fn trim_download_history(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-    keep_terminal: usize,
-) -> Result<(), sea_orm::DbErr> {
-    use alloy_db::entities::download_jobs;
-    use sea_orm::{ColumnTrait, Condition, EntityTrait, QueryFilter, QueryOrder};
-
-    let terminal_condition = Condition::any()
-        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_SUCCESS))
-        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_ERROR))
-        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_CANCELED));
+fn map_inventory_items(items: Vec<alloy_proto::agent_v1::InventoryItem>) -> Vec<InventoryItemDto> {
+    items
+        .into_iter()
+        .map(|i| InventoryItemDto {
+            slot: i.slot,
+            id: i.id,
+            count: i.count,
+            damage: i.damage,
+            custom_name: (!i.custom_name.is_empty()).then_some(i.custom_name),
+            enchantments: i.enchantments,
+        })
+        .collect()
+}
+
+fn map_player_stats(s: alloy_proto::agent_v1::PlayerStats) -> PlayerStatsDto {
+    PlayerStatsDto {
+        uuid: (!s.uuid.is_empty()).then_some(s.uuid),
+        name: (!s.name.is_empty()).then_some(s.name),
+        play_time_sec: s.play_time_sec.to_string(),
+        deaths: s.deaths.to_string(),
+        mob_kills: s.mob_kills.to_string(),
+        player_kills: s.player_kills.to_string(),
+        jumps: s.jumps.to_string(),
+        distance_m: s.distance_m.to_string(),
+        blocks_mined: s.blocks_mined.to_string(),
+        items_crafted: s.items_crafted.to_string(),
+        top_mined: s
+            .top_mined
+            .into_iter()
+            .map(|b| BlockCountDto {
+                id: b.id,
+                count: b.count.to_string(),
+            })
+            .collect(),
+        advancements_done: s.advancements_done,
+        error: (!s.error.is_empty()).then_some(s.error),
+    }
+}
 
-    let terminal_rows = download_jobs::Entity::find()
-        .filter(terminal_condition)
-        .order_by_desc(download_jobs::Column::UpdatedAt)
-        .all(db)
-        .await?;
+fn map_jar_verification(
+    resp: alloy_proto::agent_v1::VerifyServerJarResponse,
+) -> JarVerificationOutput {
+    let recorded = !resp.provider.is_empty();
+    JarVerificationOutput {
+        state: resp.state,
+        provider: recorded.then_some(resp.provider),
+        source_url: (!resp.source_url.is_empty()).then_some(resp.source_url),
+        version: (!resp.version.is_empty()).then_some(resp.version),
+        expected_sha1: (!resp.expected_sha1.is_empty()).then_some(resp.expected_sha1),
+        expected_size: recorded.then(|| resp.expected_size.to_string()),
+        actual_sha1: (!resp.actual_sha1.is_empty()).then_some(resp.actual_sha1),
+        actual_size: resp.actual_size.to_string(),
+        recorded_at_unix_ms: recorded.then(|| resp.recorded_at_unix_ms.to_string()),
+    }
+}
 
-    if terminal_rows.len() <= keep_terminal {
-        return Ok(());
+fn map_managed_file(f: alloy_proto::agent_v1::ManagedFileStatus) -> ManagedFileDto {
+    ManagedFileDto {
+        path: f.path,
+        policy: f.policy,
+        sha256: f.sha256,
+        state: f.state,
+        actual_sha256: (!f.actual_sha256.is_empty()).then_some(f.actual_sha256),
     }
+}
 
-    let stale_ids: Vec<sea_orm::prelude::Uuid> = terminal_rows
-        .into_iter()
-        .skip(keep_terminal)
-        .map(|m| m.id)
-        .collect();
+fn map_property_profile(p: alloy_proto::agent_v1::PropertyProfile) -> PropertyProfileDto {
+    PropertyProfileDto {
+        name: p.name,
+        description: (!p.description.is_empty()).then_some(p.description),
+        builtin: p.builtin,
+        values: p
+            .values
+            .into_iter()
+            .map(|v| PropertyValueDto {
+                key: v.key,
+                value: v.value,
+            })
+            .collect(),
+    }
+}
 
-    if stale_ids.is_empty() {
-        return Ok(());
+fn map_web_map_status(
+    st: Option<alloy_proto::agent_v1::WebMapStatus>,
+    reloaded: bool,
+) -> WebMapStatusOutput {
+    let st = st.unwrap_or_default();
+    let opt = |s: String| (!s.is_empty()).then_some(s);
+    WebMapStatusOutput {
+        installed: st.installed,
+        kind: opt(st.kind),
+        version_number: opt(st.version_number),
+        mod_path: opt(st.mod_path),
+        port: st.port,
+        config_port: (st.config_port != 0).then_some(st.config_port),
+        listening: st.listening,
+        local_url: st.installed.then_some(st.local_url),
+        exposed: st.exposed,
+        public_url: opt(st.public_url),
+        auth_user: opt(st.auth_user),
+        auth_password: opt(st.auth_password),
+        proxy_state: opt(st.proxy_state),
+        proxy_error: opt(st.proxy_error),
+        reloaded,
     }
+}
 
-    let _ = download_jobs::Entity::delete_many()
-        .filter(download_jobs::Column::Id.is_in(stale_ids))
-        .exec(db)
-        .await?;
-    Ok(())
+fn map_instance_config(cfg: alloy_proto::agent_v1::InstanceConfig) -> InstanceConfigDto {
+    InstanceConfigDto {
+        instance_id: cfg.instance_id,
+        template_id: cfg.template_id,
+        params: cfg.params.into_iter().collect(),
+        display_name: if cfg.display_name.trim().is_empty() {
+            None
+        } else {
+            Some(cfg.display_name)
+        },
+        golden: cfg.golden,
+        derived_from: (!cfg.derived_from.is_empty()).then_some(cfg.derived_from),
+        node_id: (!cfg.node_id.is_empty()).then_some(cfg.node_id),
+        node_name: (!cfg.node_name.is_empty()).then_some(cfg.node_name),
+    }
 }
 
-async fn recover_download_queue_after_restart(
-    db: &alloy_db::sea_orm::DatabaseConnection,
-) -> Result<(), sea_orm::DbErr> {
-    use alloy_db::entities::download_jobs;
-    use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, Set};
-
-    let rows = download_jobs::Entity::find()
-        .filter(download_jobs::Column::State.eq(DOWNLOAD_STATE_RUNNING))
-        .all(db)
-        .await?;
-
-    if rows.is_empty() {
-        return Ok(());
+fn map_param_type(t: i32) -> ParamTypeDto {
+    match t {
+        x if x == alloy_proto::agent_v1::ParamType::String as i32 => ParamTypeDto::String,
+        x if x == alloy_proto::agent_v1::ParamType::Int as i32 => ParamTypeDto::Int,
+        x if x == alloy_proto::agent_v1::ParamType::Bool as i32 => ParamTypeDto::Bool,
+        _ => ParamTypeDto::String,
     }
+}
 
-    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-    let next_base = download_queue_next_position(db).await?;
-
-    for (idx, row) in rows.into_iter().enumerate() {
-        let mut active: download_jobs::ActiveModel = row.into();
-        active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
-        active.message = Set("queued after control restart".to_string());
-        active.request_id = Set(None);
-        active.finished_at = Set(None);
-        active.updated_at = Set(now);
-        active.queue_position = Set(next_base.saturating_add(idx as i64));
-        let _ = active.update(db).await?;
+fn map_template_param(p: alloy_proto::agent_v1::TemplateParam) -> TemplateParamDto {
+    let kind = map_param_type(p.r#type);
+    TemplateParamDto {
+        key: p.key,
+        label: p.label,
+        kind,
+        required: p.required,
+        default_value: p.default_value,
+        min_int: if matches!(kind, ParamTypeDto::Int) && (p.min_int != 0 || p.max_int != 0) {
+            Some(p.min_int.clamp(i32::MIN as i64, i32::MAX as i64) as i32)
+        } else {
+            None
+        },
+        max_int: if matches!(kind, ParamTypeDto::Int) && (p.min_int != 0 || p.max_int != 0) {
+            Some(p.max_int.clamp(i32::MIN as i64, i32::MAX as i64) as i32)
+        } else {
+            None
+        },
+        enum_values: p.enum_values,
+        secret: p.secret,
+        placeholder: if p.placeholder.trim().is_empty() {
+            None
+        } else {
+            Some(p.placeholder)
+        },
+        help: if p.help.trim().is_empty() {
+            None
+        } else {
+            Some(p.help)
+        },
+        advanced: p.advanced,
     }
-
-    Ok(())
 }
 
-fn compact_download_error_message(raw: &str) -> String {
-    let normalized = raw.trim().replace("\r", "");
-    let mut lines = normalized.lines().map(str::trim).filter(|l| !l.is_empty());
-    let mut out = Vec::<String>::new();
-    for _ in 0..10 {
-        let Some(line) = lines.next() else {
-            break;
-        };
-        out.push(line.chars().take(240).collect());
-    }
-    if out.is_empty() {
-        return "download failed".to_string();
-    }
-    let mut text = out.join(" · ");
-    if text.len() > 1200 {
-        text.truncate(1200);
-        text.push('…');
+fn map_process_status(p: alloy_proto::agent_v1::ProcessStatus) -> ProcessStatusDto {
+    ProcessStatusDto {
+        process_id: p.process_id.clone(),
+        template_id: p.template_id.clone(),
+        state: p.state().as_str_name().to_string(),
+        pid: if p.has_pid { Some(p.pid) } else { None },
+        exit_code: if p.has_exit_code {
+            Some(p.exit_code)
+        } else {
+            None
+        },
+        message: if p.message.is_empty() {
+            None
+        } else {
+            Some(p.message)
+        },
+        resources: p.resources.map(|r| ProcessResourcesDto {
+            cpu_percent_x100: r.cpu_percent_x100,
+            rss_bytes: r.rss_bytes.to_string(),
+            read_bytes: r.read_bytes.to_string(),
+            write_bytes: r.write_bytes.to_string(),
+        }),
+        exit_category: if p.exit_category.is_empty() {
+            None
+        } else {
+            Some(p.exit_category)
+        },
+        exit_reason: if p.exit_reason.is_empty() {
+            None
+        } else {
+            Some(p.exit_reason)
+        },
     }
-    text
 }
 
-async fn prepare_warm_params(
-    _db: &alloy_db::sea_orm::DatabaseConnection,
-    _template_id: &str,
-    params: std::collections::BTreeMap<String, String>,
-) -> Result<std::collections::BTreeMap<String, String>, String> {
-    Ok(params)
+fn map_instance_info(
+    ctx: &Ctx,
+    info: alloy_proto::agent_v1::InstanceInfo,
+) -> Result<InstanceInfoDto, ApiError> {
+    let cfg = info
+        .config
+        .ok_or_else(|| api_error(ctx, "internal", "missing instance config"))?;
+
+    Ok(InstanceInfoDto {
+        config: map_instance_config(cfg),
+        status: info.status.map(map_process_status),
+    })
 }
 
-async fn download_queue_worker_loop(runtime: DownloadQueueRuntime) {
-    if let Err(e) = recover_download_queue_after_restart(&runtime.db).await {
-        tracing::error!(error = %e, "download queue recovery failed");
+fn clamp_u64_to_u32(v: u64) -> u32 {
+    if v > u32::MAX as u64 {
+        u32::MAX
+    } else {
+        v as u32
     }
+}
 
-    loop {
-        let did_work = match run_next_download_queue_job(&runtime).await {
-            Ok(v) => v,
-            Err(e) => {
-                tracing::error!(error = %e, "download queue worker tick failed");
-                false
-            }
-        };
-
-        if did_work {
-            continue;
-        }
-
-        runtime.notify.notified().await;
-    }
+fn agent_transport(ctx: &Ctx) -> AgentTransport {
+    AgentTransport::new(ctx.agent_hub.clone()).with_request_id(ctx.request_id.clone())
 }
 
-async fn run_next_download_queue_job(runtime: &DownloadQueueRuntime) -> Result<bool, String> {
-    use alloy_db::entities::download_jobs;
-    use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, Set};
+async fn verify_steamcmd_login_via_agent(
+    ctx: &Ctx,
+    username: &str,
+    password: &str,
+    steam_guard_code: Option<&str>,
+) -> Result<(), ApiError> {
+    let transport = agent_transport(ctx);
 
-    if download_queue_is_paused(&runtime.db)
-        .await
-        .map_err(|e| format!("db error: {e}"))?
+    let mut verify_params = std::collections::BTreeMap::<String, String>::new();
+    verify_params.insert("steam_username".to_string(), username.to_string());
+    verify_params.insert("steam_password".to_string(), password.to_string());
+    if let Some(code) = steam_guard_code
+        && !code.trim().is_empty()
     {
-        return Ok(false);
+        verify_params.insert("steam_guard_code".to_string(), code.trim().to_string());
     }
 
-    let Some(row) = download_jobs::Entity::find()
-        .filter(download_jobs::Column::State.eq(DOWNLOAD_STATE_QUEUED))
-        .order_by_asc(download_jobs::Column::QueuePosition)
-        .order_by_asc(download_jobs::Column::CreatedAt)
-        .one(&*runtime.db)
-        .await
-        .map_err(|e| format!("db error: {e}"))?
-    else {
-        return Ok(false);
-    };
-
-    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-                let mut running: download_jobs::ActiveModel = row.clone().into();
-                running.state = Set(DOWNLOAD_STATE_RUNNING.to_string());
-                running.message = Set("resolving download target…".to_string());
-                running.request_id = Set(None);
-                running.started_at = Set(Some(now));
-                running.finished_at = Set(None);
-                running.updated_at = Set(now);
-                running.attempt_count = Set(row.attempt_count.saturating_add(1));
-    let running = running
-        .update(&*runtime.db)
-        .await
-        .map_err(|e| format!("db error: {e}"))?;
-
-    let mut params = parse_download_job_params(&running.params_json);
-    params = prepare_warm_params(&runtime.db, &running.template_id, params).await?;
-
-    let transport = AgentTransport::new(runtime.agent_hub.clone());
-    match transport
-        .call::<_, alloy_proto::agent_v1::WarmTemplateCacheResponse>(
+    let _verified: alloy_proto::agent_v1::WarmTemplateCacheResponse = transport
+        .call(
             "/alloy.agent.v1.ProcessService/WarmTemplateCache",
             WarmTemplateCacheRequest {
-                progress_id: download_progress_id(&running.id, running.attempt_count),
-                template_id: running.template_id.clone(),
-                params: params.into_iter().collect(),
+                template_id: "steamcmd:auth".to_string(),
+                params: verify_params.into_iter().collect(),
+                progress_id: String::new(),
             },
         )
         .await
-    {
-        Ok(_resp) => {
-            let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-            let mut done: download_jobs::ActiveModel = running.into();
-            done.state = Set(DOWNLOAD_STATE_SUCCESS.to_string());
-            done.message = Set("download completed".to_string());
-            done.request_id = Set(None);
-            done.updated_at = Set(now);
-            done.finished_at = Set(Some(now));
-            let _ = done
-                .update(&*runtime.db)
-                .await
-                .map_err(|e| format!("db error: {e}"))?;
-            let _ = trim_download_history(&runtime.db, 50).await;
-            Ok(true)
-        }
-        Err(status) => {
-            let msg = if let Some(payload) = parse_agent_error_payload(status.message()) {
-                payload.message
-            } else {
-                format!("process.warm_template_cache: {}", status.message())
-            };
+        .map_err(|status| {
+            api_error_from_agent_status(ctx, "settings.setSteamcmdCredentials.verify", status)
+        })?;
 
-            if let Ok(progress) = transport
-                .call::<_, alloy_proto::agent_v1::GetWarmTemplateProgressResponse>(
-                    "/alloy.agent.v1.ProcessService/GetWarmTemplateProgress",
-                    GetWarmTemplateProgressRequest {
-                        progress_id: download_progress_id(&running.id, running.attempt_count),
-                    },
-                )
-                .await
-                && progress.found
-                && !progress.message.trim().is_empty()
-            {
+    Ok(())
+}
+
+async fn setting_get(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    key: &str,
+) -> Result<Option<String>, sea_orm::DbErr> {
+    use alloy_db::entities::settings;
+    use sea_orm::EntityTrait;
+    Ok(settings::Entity::find_by_id(key.to_string())
+        .one(db)
+        .await?
+        .map(|m| m.value))
+}
+
+async fn setting_is_set(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    key: &str,
+) -> Result<bool, sea_orm::DbErr> {
+    Ok(setting_get(db, key)
+        .await?
+        .is_some_and(|v| !v.trim().is_empty()))
+}
+
+async fn setting_set(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    key: &str,
+    value: &str,
+    is_secret: bool,
+) -> Result<(), sea_orm::DbErr> {
+    use alloy_db::entities::settings;
+    use sea_orm::{EntityTrait, Set};
+
+    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+    let model = settings::ActiveModel {
+        key: Set(key.to_string()),
+        value: Set(value.to_string()),
+        is_secret: Set(is_secret),
+        created_at: Set(now),
+        updated_at: Set(now),
+    };
+
+    settings::Entity::insert(model)
+        .on_conflict(
+            sea_orm::sea_query::OnConflict::column(settings::Column::Key)
+                .update_columns([
+                    settings::Column::Value,
+                    settings::Column::IsSecret,
+                    settings::Column::UpdatedAt,
+                ])
+                .to_owned(),
+        )
+        .exec(db)
+        .await?;
+    Ok(())
+}
+
+async fn setting_set_secret(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    key: &str,
+    value: &str,
+) -> Result<(), sea_orm::DbErr> {
+    setting_set(db, key, value, true).await
+}
+
+async fn setting_clear(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    key: &str,
+) -> Result<(), sea_orm::DbErr> {
+    use alloy_db::entities::settings;
+    use sea_orm::EntityTrait;
+    let _ = settings::Entity::delete_by_id(key.to_string())
+        .exec(db)
+        .await?;
+    Ok(())
+}
+
+fn normalize_download_target(raw: &str) -> Option<&'static str> {
+    match raw.trim() {
+        "minecraft_vanilla" => Some("minecraft_vanilla"),
+        "terraria_vanilla" => Some("terraria_vanilla"),
+        _ => None,
+    }
+}
+
+fn expected_template_id_for_target(target: &str) -> Option<&'static str> {
+    match target {
+        "minecraft_vanilla" => Some("minecraft:vanilla"),
+        "terraria_vanilla" => Some("terraria:vanilla"),
+        _ => None,
+    }
+}
+
+fn normalize_download_template_id(raw: &str) -> Option<&'static str> {
+    match raw.trim() {
+        "minecraft:vanilla" => Some("minecraft:vanilla"),
+        "terraria:vanilla" => Some("terraria:vanilla"),
+        _ => None,
+    }
+}
+
+fn download_state_rank(state: &str) -> i32 {
+    match state {
+        DOWNLOAD_STATE_RUNNING => 0,
+        DOWNLOAD_STATE_QUEUED => 1,
+        DOWNLOAD_STATE_PAUSED => 2,
+        DOWNLOAD_STATE_ERROR => 3,
+        DOWNLOAD_STATE_SUCCESS => 4,
+        DOWNLOAD_STATE_CANCELED => 5,
+        _ => 9,
+    }
+}
+
+fn normalize_download_version(raw: &str) -> String {
+    let trimmed = raw.trim();
+    if trimmed.is_empty() {
+        "latest".to_string()
+    } else {
+        trimmed.chars().take(128).collect()
+    }
+}
+
+fn parse_download_job_params(raw: &str) -> std::collections::BTreeMap<String, String> {
+    let Ok(value) = serde_json::from_str::<serde_json::Value>(raw) else {
+        return std::collections::BTreeMap::new();
+    };
+
+    let mut out = std::collections::BTreeMap::<String, String>::new();
+    let serde_json::Value::Object(map) = value else {
+        return out;
+    };
+
+    for (k, v) in map {
+        let key = k.trim();
+        if key.is_empty() || key.len() > 120 {
+            continue;
+        }
+        let value = match v {
+            serde_json::Value::Null => continue,
+            serde_json::Value::String(s) => s,
+            other => other.to_string(),
+        };
+        if value.len() > 8192 {
+            continue;
+        }
+        out.insert(key.to_string(), value);
+    }
+
+    out
+}
+
+fn serialize_download_job_params(
+    params: &std::collections::BTreeMap<String, String>,
+) -> Result<String, String> {
+    let mut filtered = std::collections::BTreeMap::<String, String>::new();
+    for (k, v) in params {
+        let key = k.trim();
+        if key.is_empty() || key.len() > 120 {
+            continue;
+        }
+        filtered.insert(key.to_string(), v.chars().take(8192).collect());
+    }
+    serde_json::to_string(&filtered).map_err(|e| format!("invalid params json: {e}"))
+}
+
+fn dt_to_unix_ms(dt: chrono::DateTime<chrono::FixedOffset>) -> String {
+    dt.timestamp_millis().to_string()
+}
+
+#[derive(Debug, Clone)]
+struct DownloadProgressSnapshot {
+    stage: String,
+    downloaded_bytes: u64,
+    total_bytes: u64,
+    speed_bytes_per_sec: u64,
+}
+
+fn download_progress_id(id: &sea_orm::prelude::Uuid, attempt_count: i32) -> String {
+    format!("{}:{}", id, attempt_count.max(0))
+}
+
+fn progress_percent_x100(downloaded_bytes: u64, total_bytes: u64) -> Option<u32> {
+    if total_bytes == 0 {
+        return None;
+    }
+    let pct = downloaded_bytes
+        .saturating_mul(10_000)
+        .checked_div(total_bytes)
+        .unwrap_or(0)
+        .min(10_000);
+    Some(pct as u32)
+}
+
+fn progress_eta_sec(downloaded_bytes: u64, total_bytes: u64, speed_bytes_per_sec: u64) -> Option<u32> {
+    if speed_bytes_per_sec == 0 || total_bytes <= downloaded_bytes {
+        return None;
+    }
+    let left = total_bytes.saturating_sub(downloaded_bytes);
+    let eta = left.saturating_add(speed_bytes_per_sec.saturating_sub(1)) / speed_bytes_per_sec;
+    Some(eta.min(u32::MAX as u64) as u32)
+}
+
+fn map_download_job_model(
+    model: alloy_db::entities::download_jobs::Model,
+    progress: Option<&DownloadProgressSnapshot>,
+) -> DownloadQueueJobDto {
+    let progress_stage = progress
+        .and_then(|p| {
+            let s = p.stage.trim();
+            if s.is_empty() {
+                None
+            } else {
+                Some(s.to_string())
+            }
+        });
+    let progress_downloaded_bytes = progress.map(|p| p.downloaded_bytes.to_string());
+    let progress_total_bytes = progress.map(|p| p.total_bytes.to_string());
+    let progress_speed_bytes_per_sec = progress.map(|p| p.speed_bytes_per_sec.to_string());
+    let progress_percent_x100 = progress.and_then(|p| {
+        progress_percent_x100(p.downloaded_bytes, p.total_bytes)
+    });
+    let progress_eta_sec = progress.and_then(|p| {
+        progress_eta_sec(p.downloaded_bytes, p.total_bytes, p.speed_bytes_per_sec)
+    });
+
+    DownloadQueueJobDto {
+        id: model.id.to_string(),
+        target: model.target,
+        template_id: model.template_id,
+        version: model.version,
+        params: parse_download_job_params(&model.params_json),
+        state: model.state,
+        message: model.message,
+        request_id: model.request_id,
+        queue_position: model.queue_position.to_string(),
+        attempt_count: model.attempt_count,
+        created_at_unix_ms: dt_to_unix_ms(model.created_at),
+        started_at_unix_ms: model.started_at.map(dt_to_unix_ms),
+        updated_at_unix_ms: dt_to_unix_ms(model.updated_at),
+        finished_at_unix_ms: model.finished_at.map(dt_to_unix_ms),
+        progress_stage,
+        progress_downloaded_bytes,
+        progress_total_bytes,
+        progress_speed_bytes_per_sec,
+        progress_percent_x100,
+        progress_eta_sec,
+    }
+}
+
+async fn download_queue_is_paused(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+) -> Result<bool, sea_orm::DbErr> {
+    Ok(setting_get(db, SETTING_DOWNLOAD_QUEUE_PAUSED)
+        .await?
+        .is_some_and(|v| matches!(v.trim(), "1" | "true" | "yes" | "on")))
+}
+
+async fn download_queue_set_paused(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    paused: bool,
+) -> Result<(), sea_orm::DbErr> {
+    if paused {
+        setting_set(db, SETTING_DOWNLOAD_QUEUE_PAUSED, "1", false).await
+    } else {
+        setting_clear(db, SETTING_DOWNLOAD_QUEUE_PAUSED).await
+    }
+}
+
+async fn download_queue_snapshot(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    agent_hub: &crate::agent_tunnel::AgentHub,
+) -> Result<DownloadQueueOutput, sea_orm::DbErr> {
+    use alloy_db::entities::download_jobs;
+    use sea_orm::{EntityTrait, QueryOrder};
+
+    let queue_paused = download_queue_is_paused(db).await?;
+    let mut rows = download_jobs::Entity::find()
+        .order_by_desc(download_jobs::Column::UpdatedAt)
+        .all(db)
+        .await?;
+
+    rows.sort_by(|a, b| {
+        let ar = download_state_rank(&a.state);
+        let br = download_state_rank(&b.state);
+        if ar != br {
+            return ar.cmp(&br);
+        }
+        if ar <= 2 {
+            if a.queue_position != b.queue_position {
+                return a.queue_position.cmp(&b.queue_position);
+            }
+            return a.created_at.cmp(&b.created_at);
+        }
+        b.updated_at.cmp(&a.updated_at)
+    });
+    if rows.len() > 80 {
+        rows.truncate(80);
+    }
+
+    let mut progress_by_id = HashMap::<String, DownloadProgressSnapshot>::new();
+    let running_jobs: Vec<_> = rows
+        .iter()
+        .filter(|r| r.state == DOWNLOAD_STATE_RUNNING)
+        .collect();
+    if !running_jobs.is_empty() {
+        let transport = AgentTransport::new(agent_hub.clone());
+        for row in running_jobs {
+            let pid = download_progress_id(&row.id, row.attempt_count);
+            let resp = transport
+                .call::<_, alloy_proto::agent_v1::GetWarmTemplateProgressResponse>(
+                    "/alloy.agent.v1.ProcessService/GetWarmTemplateProgress",
+                    GetWarmTemplateProgressRequest {
+                        progress_id: pid.clone(),
+                    },
+                )
+                .await;
+
+            let Ok(progress) = resp else {
+                continue;
+            };
+            if !progress.found {
+                continue;
+            }
+
+            progress_by_id.insert(
+                pid,
+                DownloadProgressSnapshot {
+                    stage: progress.stage,
+                    downloaded_bytes: progress.downloaded_bytes,
+                    total_bytes: progress.total_bytes,
+                    speed_bytes_per_sec: progress.speed_bytes_per_sec,
+                },
+            );
+        }
+    }
+
+    Ok(DownloadQueueOutput {
+        queue_paused,
+        jobs: rows
+            .into_iter()
+            .map(|row| {
+                let key = download_progress_id(&row.id, row.attempt_count);
+                let progress = progress_by_id.get(&key);
+                map_download_job_model(row, progress)
+            })
+            .collect(),
+    })
+}
+
+async fn download_queue_next_position(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+) -> Result<i64, sea_orm::DbErr> {
+    use alloy_db::entities::download_jobs;
+    use sea_orm::{EntityTrait, QueryOrder};
+
+    let top = download_jobs::Entity::find()
+        .order_by_desc(download_jobs::Column::QueuePosition)
+        .one(db)
+        .await?;
+    Ok(top.map(|m| m.queue_position.saturating_add(1)).unwrap_or(1))
+}
+
+async fn trim_download_history(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+    keep_terminal: usize,
+) -> Result<(), sea_orm::DbErr> {
+    use alloy_db::entities::download_jobs;
+    use sea_orm::{ColumnTrait, Condition, EntityTrait, QueryFilter, QueryOrder};
+
+    let terminal_condition = Condition::any()
+        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_SUCCESS))
+        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_ERROR))
+        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_CANCELED));
+
+    let terminal_rows = download_jobs::Entity::find()
+        .filter(terminal_condition)
+        .order_by_desc(download_jobs::Column::UpdatedAt)
+        .all(db)
+        .await?;
+
+    if terminal_rows.len() <= keep_terminal {
+        return Ok(());
+    }
+
+    let stale_ids: Vec<sea_orm::prelude::Uuid> = terminal_rows
+        .into_iter()
+        .skip(keep_terminal)
+        .map(|m| m.id)
+        .collect();
+
+    if stale_ids.is_empty() {
+        return Ok(());
+    }
+
+    let _ = download_jobs::Entity::delete_many()
+        .filter(download_jobs::Column::Id.is_in(stale_ids))
+        .exec(db)
+        .await?;
+    Ok(())
+}
+
+async fn recover_download_queue_after_restart(
+    db: &alloy_db::sea_orm::DatabaseConnection,
+) -> Result<(), sea_orm::DbErr> {
+    use alloy_db::entities::download_jobs;
+    use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, Set};
+
+    let rows = download_jobs::Entity::find()
+        .filter(download_jobs::Column::State.eq(DOWNLOAD_STATE_RUNNING))
+        .all(db)
+        .await?;
+
+    if rows.is_empty() {
+        return Ok(());
+    }
+
+    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+    let next_base = download_queue_next_position(db).await?;
+
+    for (idx, row) in rows.into_iter().enumerate() {
+        let mut active: download_jobs::ActiveModel = row.into();
+        active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
+        active.message = Set("queued after control restart".to_string());
+        active.finished_at = Set(None);
+        active.updated_at = Set(now);
+        active.queue_position = Set(next_base.saturating_add(idx as i64));
+        let _ = active.update(db).await?;
+    }
+
+    Ok(())
+}
+
+fn compact_download_error_message(raw: &str) -> String {
+    let normalized = raw.trim().replace("\r", "");
+    let mut lines = normalized.lines().map(str::trim).filter(|l| !l.is_empty());
+    let mut out = Vec::<String>::new();
+    for _ in 0..10 {
+        let Some(line) = lines.next() else {
+            break;
+        };
+        out.push(line.chars().take(240).collect());
+    }
+    if out.is_empty() {
+        return "download failed".to_string();
+    }
+    let mut text = out.join(" · ");
+    if text.len() > 1200 {
+        text.truncate(1200);
+        text.push('…');
+    }
+    text
+}
+
+async fn prepare_warm_params(
+    _db: &alloy_db::sea_orm::DatabaseConnection,
+    _template_id: &str,
+    params: std::collections::BTreeMap<String, String>,
+) -> Result<std::collections::BTreeMap<String, String>, String> {
+    Ok(params)
+}
+
+async fn download_queue_worker_loop(runtime: DownloadQueueRuntime) {
+    if let Err(e) = recover_download_queue_after_restart(&runtime.db).await {
+        tracing::error!(error = %e, "download queue recovery failed");
+    }
+
+    loop {
+        let did_work = match run_next_download_queue_job(&runtime).await {
+            Ok(v) => v,
+            Err(e) => {
+                tracing::error!(error = %e, "download queue worker tick failed");
+                false
+            }
+        };
+
+        if did_work {
+            continue;
+        }
+
+        match runtime.next_retry_in() {
+            Some(wait) => {
+                let _ = tokio::time::timeout(wait, runtime.notify.notified()).await;
+            }
+            None => runtime.notify.notified().await,
+        }
+    }
+}
+
+async fn run_next_download_queue_job(runtime: &DownloadQueueRuntime) -> Result<bool, String> {
+    use alloy_db::entities::download_jobs;
+    use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, Set};
+
+    if download_queue_is_paused(&runtime.db)
+        .await
+        .map_err(|e| format!("db error: {e}"))?
+    {
+        return Ok(false);
+    }
+
+    let Some(row) = download_jobs::Entity::find()
+        .filter(download_jobs::Column::State.eq(DOWNLOAD_STATE_QUEUED))
+        .order_by_asc(download_jobs::Column::QueuePosition)
+        .order_by_asc(download_jobs::Column::CreatedAt)
+        .all(&*runtime.db)
+        .await
+        .map_err(|e| format!("db error: {e}"))?
+        .into_iter()
+        .find(|row| !runtime.waiting_for_retry(&row.id))
+    else {
+        return Ok(false);
+    };
+
+    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+                let mut running: download_jobs::ActiveModel = row.clone().into();
+                running.state = Set(DOWNLOAD_STATE_RUNNING.to_string());
+                running.message = Set("resolving download target…".to_string());
+                running.started_at = Set(Some(now));
+                running.finished_at = Set(None);
+                running.updated_at = Set(now);
+                running.attempt_count = Set(row.attempt_count.saturating_add(1));
+    let running = running
+        .update(&*runtime.db)
+        .await
+        .map_err(|e| format!("db error: {e}"))?;
+
+    let mut params = parse_download_job_params(&running.params_json);
+    params = prepare_warm_params(&runtime.db, &running.template_id, params).await?;
+
+    // Agent logs for the download carry the id of the request that queued it.
+    let transport = AgentTransport::new(runtime.agent_hub.clone()).with_request_id(
+        running
+            .request_id
+            .clone()
+            .unwrap_or_else(|| format!("download:{}", running.id)),
+    );
+    match transport
+        .call::<_, alloy_proto::agent_v1::WarmTemplateCacheResponse>(
+            "/alloy.agent.v1.ProcessService/WarmTemplateCache",
+            WarmTemplateCacheRequest {
+                progress_id: download_progress_id(&running.id, running.attempt_count),
+                template_id: running.template_id.clone(),
+                params: params.into_iter().collect(),
+            },
+        )
+        .await
+    {
+        Ok(_resp) => {
+            let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+            let mut done: download_jobs::ActiveModel = running.into();
+            done.state = Set(DOWNLOAD_STATE_SUCCESS.to_string());
+            done.message = Set("download completed".to_string());
+            done.updated_at = Set(now);
+            done.finished_at = Set(Some(now));
+            let _ = done
+                .update(&*runtime.db)
+                .await
+                .map_err(|e| format!("db error: {e}"))?;
+            let _ = trim_download_history(&runtime.db, 50).await;
+            Ok(true)
+        }
+        Err(status) => {
+            let payload = parse_agent_error_payload(status.message());
+            let upstream_down = status.code() == tonic::Code::Unavailable
+                && payload
+                    .as_ref()
+                    .is_some_and(|p| p.code == "upstream_unavailable");
+            let msg = if let Some(payload) = payload {
+                payload.message
+            } else {
+                format!("process.warm_template_cache: {}", status.message())
+            };
+
+            // The provider's API is down and the agent has no cached metadata: requeue instead of
+            // failing, so installs go through once it is back.
+            if upstream_down && running.attempt_count < DOWNLOAD_UPSTREAM_MAX_ATTEMPTS {
+                let wait = Duration::from_secs(
+                    DOWNLOAD_RETRY_BASE_SECS.saturating_mul(running.attempt_count.max(1) as u64),
+                );
+                runtime
+                    .retry_after
+                    .lock()
+                    .unwrap_or_else(|e| e.into_inner())
+                    .insert(running.id, Instant::now() + wait);
+                tracing::warn!(
+                    job_id = %running.id,
+                    attempt = running.attempt_count,
+                    retry_in_secs = wait.as_secs(),
+                    error = %msg,
+                    "download queue job requeued: upstream unavailable"
+                );
+                let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+                let attempt = running.attempt_count;
+                let mut requeued: download_jobs::ActiveModel = running.into();
+                requeued.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
+                requeued.message = Set(format!(
+                    "upstream unavailable; retrying in {}s (attempt {attempt}/{})",
+                    wait.as_secs(),
+                    DOWNLOAD_UPSTREAM_MAX_ATTEMPTS
+                ));
+                requeued.updated_at = Set(now);
+                requeued.started_at = Set(None);
+                let _ = requeued
+                    .update(&*runtime.db)
+                    .await
+                    .map_err(|e| format!("db error: {e}"))?;
+                return Ok(true);
+            }
+
+            if let Ok(progress) = transport
+                .call::<_, alloy_proto::agent_v1::GetWarmTemplateProgressResponse>(
+                    "/alloy.agent.v1.ProcessService/GetWarmTemplateProgress",
+                    GetWarmTemplateProgressRequest {
+                        progress_id: download_progress_id(&running.id, running.attempt_count),
+                    },
+                )
+                .await
+                && progress.found
+                && !progress.message.trim().is_empty()
+            {
                 tracing::warn!(
                     job_id = %running.id,
                     progress_stage = %progress.stage,
@@ -2014,7 +3120,6 @@ async fn run_next_download_queue_job(runtime: &DownloadQueueRuntime) -> Result<b
             let mut failed: download_jobs::ActiveModel = running.into();
             failed.state = Set(DOWNLOAD_STATE_ERROR.to_string());
             failed.message = Set(compact_download_error_message(&msg));
-            failed.request_id = Set(None);
             failed.updated_at = Set(now);
             failed.finished_at = Set(Some(now));
             let _ = failed
@@ -2121,6 +3226,52 @@ pub fn router() -> Router<Ctx> {
                                 })
                                 .collect(),
                         ),
+                        frp: r.frp.map(|f| FrpSummaryDto {
+                            tunnels: f.tunnels,
+                            tunnels_running: f.tunnels_running,
+                            proxies: f.proxies,
+                            proxies_running: f.proxies_running,
+                            cur_conns: f.cur_conns.to_string(),
+                            traffic_in_bytes: f.traffic_in_bytes.to_string(),
+                            traffic_out_bytes: f.traffic_out_bytes.to_string(),
+                        }),
+                        dir_cache: r.dir_cache.map(|c| DirCacheStatsDto {
+                            hits: c.hits.to_string(),
+                            misses: c.misses.to_string(),
+                            bypassed: c.bypassed.to_string(),
+                            dirs: c.dirs.to_string(),
+                        }),
+                        clock: r.clock.map(|c| ClockStatusDto {
+                            skew_vs_control_ms: (c.unix_ms as i64
+                                - chrono::Utc::now().timestamp_millis())
+                            .to_string(),
+                            unix_ms: c.unix_ms.to_string(),
+                            timezone: c.timezone,
+                            utc_offset_seconds: c.utc_offset_seconds,
+                            ntp_server: (!c.ntp_server.is_empty()).then_some(c.ntp_server),
+                            ntp_offset_ms: c.has_ntp_offset.then(|| c.ntp_offset_ms.to_string()),
+                            ntp_checked_at_unix_ms: (c.ntp_checked_at_unix_ms > 0)
+                                .then(|| c.ntp_checked_at_unix_ms.to_string()),
+                            ntp_error: (!c.ntp_error.is_empty()).then_some(c.ntp_error),
+                        }),
+                        mirrors: Some(
+                            r.mirrors
+                                .into_iter()
+                                .map(|m| MirrorStatusDto {
+                                    provider: m.provider,
+                                    base: m.base,
+                                    position: m.position,
+                                    healthy: m.healthy,
+                                    successes: m.successes.to_string(),
+                                    failures: m.failures.to_string(),
+                                    last_error: (!m.last_error.is_empty()).then_some(m.last_error),
+                                    last_ok_unix_ms: (m.last_ok_unix_ms > 0)
+                                        .then(|| m.last_ok_unix_ms.to_string()),
+                                    last_error_unix_ms: (m.last_error_unix_ms > 0)
+                                        .then(|| m.last_error_unix_ms.to_string()),
+                                })
+                                .collect(),
+                        ),
                         error: None,
                     },
                     Err(status) => AgentHealthFullDto {
@@ -2132,6 +3283,10 @@ pub fn router() -> Router<Ctx> {
                         data_root_writable: None,
                         data_root_free_bytes: None,
                         ports: None,
+                        frp: None,
+                        dir_cache: None,
+                        clock: None,
+                        mirrors: None,
                         error: Some(status.message().to_string()),
                     },
                 };
@@ -2145,105 +3300,386 @@ pub fn router() -> Router<Ctx> {
                 {
                     Ok(resp) => FsCapabilitiesOutput {
                         write_enabled: resp.write_enabled,
+                        disabled_commands: resp.disabled_commands,
+                        backup_protect_hours: resp.backup_protect_hours,
                     },
                     Err(_) => FsCapabilitiesOutput {
                         write_enabled: false,
+                        disabled_commands: Vec::new(),
+                        backup_protect_hours: 0,
                     },
                 };
 
-                let cache_resp: alloy_proto::agent_v1::GetCacheStatsResponse = transport
+                let cache_resp: alloy_proto::agent_v1::GetCacheStatsResponse = transport
+                    .call(
+                        "/alloy.agent.v1.ProcessService/GetCacheStats",
+                        GetCacheStatsRequest {},
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "process.get_cache_stats", status)
+                    })?;
+
+                let cache = CacheStatsOutput {
+                    entries: cache_resp
+                        .entries
+                        .into_iter()
+                        .map(|e| CacheEntryDto {
+                            key: e.key,
+                            path: e.path,
+                            size_bytes: e.size_bytes.to_string(),
+                            last_used_unix_ms: e.last_used_unix_ms.to_string(),
+                        })
+                        .collect(),
+                };
+
+                let mut agent_log_path: Option<String> = None;
+                let mut agent_log_lines: Vec<String> = Vec::new();
+                if let Ok(resp) = transport
+                    .call::<_, alloy_proto::agent_v1::ListDirResponse>(
+                        "/alloy.agent.v1.FilesystemService/ListDir",
+                        ListDirRequest {
+                            path: "logs".to_string(),
+                            no_cache: true,
+                        },
+                    )
+                    .await
+                {
+                    let mut candidates: Vec<String> = resp
+                        .entries
+                        .into_iter()
+                        .filter(|e| !e.is_dir && e.name.starts_with("agent.log"))
+                        .map(|e| e.name)
+                        .collect();
+                    candidates.sort();
+                    if let Some(name) = candidates.pop() {
+                        let p = format!("logs/{name}");
+                        agent_log_path = Some(p.clone());
+                        if let Ok(resp) = transport
+                            .call::<_, alloy_proto::agent_v1::TailFileResponse>(
+                                "/alloy.agent.v1.LogsService/TailFile",
+                                TailFileRequest {
+                                    path: p,
+                                    cursor: String::new(),
+                                    limit_bytes: 512 * 1024,
+                                    max_lines: 800,
+                                },
+                            )
+                            .await
+                        {
+                            agent_log_lines = resp.lines;
+                        }
+                    }
+                }
+
+                Ok(ControlDiagnosticsOutput {
+                    fetched_at_unix_ms,
+                    request_id: ctx.request_id.clone(),
+                    control_version: env!("CARGO_PKG_VERSION").to_string(),
+                    read_only: is_read_only(),
+                    agent: health,
+                    fs: fs_caps,
+                    cache,
+                    agent_log_path,
+                    agent_log_lines,
+                })
+            }),
+        );
+
+    let agent = Router::new()
+        .procedure(
+            "health",
+            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::HealthCheckResponse = transport
+                    .call(
+                        "/alloy.agent.v1.AgentHealthService/Check",
+                        HealthCheckRequest {},
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "agent.health", status))?;
+
+                Ok(AgentHealthResponse {
+                    status: resp.status,
+                    agent_version: resp.agent_version,
+                })
+            }),
+        )
+        .procedure(
+            "nodeInfo",
+            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::NodeInfoResponse = transport
+                    .call(
+                        "/alloy.agent.v1.AgentHealthService/NodeInfo",
+                        alloy_proto::agent_v1::NodeInfoRequest {},
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "agent.node_info", status)
+                    })?;
+
+                Ok(NodeInfoOutput {
+                    node_id: resp.node_id,
+                    name: resp.name,
+                    labels: resp.labels.into_iter().collect(),
+                    public_address: (!resp.public_address.is_empty())
+                        .then_some(resp.public_address),
+                    agent_version: resp.agent_version,
+                    os: resp.os,
+                    arch: resp.arch,
+                    cpus: resp.cpus,
+                    data_root: resp.data_root,
+                    checkpoint_supported: resp.checkpoint_supported,
+                    checkpoint_unsupported_reason: (!resp.checkpoint_unsupported_reason.is_empty())
+                        .then_some(resp.checkpoint_unsupported_reason),
+                })
+            }),
+        )
+        .procedure(
+            "placement",
+            Procedure::builder::<ApiError>().query(|ctx, input: PlacementInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::PlacementResponse = transport
+                    .call(
+                        "/alloy.agent.v1.AgentHealthService/Placement",
+                        alloy_proto::agent_v1::PlacementRequest {
+                            memory_mb: input.memory_mb.unwrap_or(0) as u64,
+                            disk_mb: input.disk_mb.unwrap_or(0) as u64,
+                            cpu_millicores: input.cpu_millicores.unwrap_or(0) as u64,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "agent.placement", status)
+                    })?;
+
+                let clamp = |v: u64| v.min(u32::MAX as u64) as u32;
+                Ok(PlacementOutput {
+                    fits: resp.fits,
+                    score: resp.score,
+                    reasons: resp.reasons,
+                    memory_budget_mb: clamp(resp.memory_budget_mb),
+                    memory_available_mb: clamp(resp.memory_available_mb),
+                    memory_committed_mb: clamp(resp.memory_committed_mb),
+                    cpu_millicores: clamp(resp.cpu_millicores),
+                    cpu_committed_millicores: clamp(resp.cpu_committed_millicores),
+                    disk_usable_mb: clamp(resp.disk_usable_mb),
+                    instances: resp.instances,
+                })
+            }),
+        )
+        .procedure(
+            "selftest",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: SelfTestInput| async move {
+                // Writes probe files and binds ports on the node.
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::SelfTestResponse = transport
+                    .call(
+                        "/alloy.agent.v1.AgentHealthService/SelfTest",
+                        SelfTestRequest {
+                            skip_network: input.skip_network.unwrap_or(false),
+                            port_start: input.port_start.unwrap_or(0),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "agent.selftest", status)
+                    })?;
+
+                audit::record(
+                    &ctx,
+                    "agent.selftest",
+                    "node",
+                    Some(serde_json::json!({
+                        "ok": resp.ok,
+                        "skip_network": input.skip_network.unwrap_or(false),
+                        "failed": resp
+                            .checks
+                            .iter()
+                            .filter(|c| c.outcome == "fail")
+                            .map(|c| c.name.as_str())
+                            .collect::<Vec<_>>(),
+                    })),
+                )
+                .await;
+
+                Ok(SelfTestOutput {
+                    ok: resp.ok,
+                    checks: resp
+                        .checks
+                        .into_iter()
+                        .map(|c| SelfTestCheckDto {
+                            name: c.name,
+                            outcome: c.outcome,
+                            message: c.message,
+                            duration_ms: c.duration_ms.to_string(),
+                        })
+                        .collect(),
+                    duration_ms: resp.duration_ms.to_string(),
+                })
+            }),
+        )
+        .procedure(
+            "setLogLevel",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: SetLogLevelInput| async move {
+                enforce_rate_limit(&ctx)?;
+                let user = ctx
+                    .user
+                    .clone()
+                    .ok_or_else(|| api_error(&ctx, "unauthorized", "unauthorized"))?;
+                if !user.is_admin {
+                    return Err(api_error(&ctx, "forbidden", "forbidden"));
+                }
+
+                let filter = input.filter.unwrap_or_default();
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::SetLogLevelResponse = transport
+                    .call(
+                        "/alloy.agent.v1.AgentHealthService/SetLogLevel",
+                        SetLogLevelRequest {
+                            filter: filter.clone(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "agent.set_log_level", status)
+                    })?;
+
+                if !filter.trim().is_empty() {
+                    audit::record(
+                        &ctx,
+                        "agent.setLogLevel",
+                        &resp.filter,
+                        Some(serde_json::json!({ "previous": resp.previous })),
+                    )
+                    .await;
+                }
+
+                Ok(SetLogLevelOutput {
+                    filter: resp.filter,
+                    previous: (!resp.previous.is_empty()).then_some(resp.previous),
+                })
+            }),
+        )
+        .procedure(
+            "scheduleReload",
+            Procedure::builder::<ApiError>().mutation(|ctx, _: ()| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+                let user = ctx
+                    .user
+                    .clone()
+                    .ok_or_else(|| api_error(&ctx, "unauthorized", "unauthorized"))?;
+                if !user.is_admin {
+                    return Err(api_error(&ctx, "forbidden", "forbidden"));
+                }
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ReloadBackupScheduleResponse = transport
                     .call(
-                        "/alloy.agent.v1.ProcessService/GetCacheStats",
-                        GetCacheStatsRequest {},
+                        "/alloy.agent.v1.AgentHealthService/ReloadBackupSchedule",
+                        alloy_proto::agent_v1::ReloadBackupScheduleRequest {},
                     )
                     .await
                     .map_err(|status| {
-                        api_error_from_agent_status(&ctx, "process.get_cache_stats", status)
+                        api_error_from_agent_status(&ctx, "agent.schedule_reload", status)
                     })?;
 
-                let cache = CacheStatsOutput {
-                    entries: cache_resp
-                        .entries
+                audit::record(
+                    &ctx,
+                    "agent.scheduleReload",
+                    "backup-schedule",
+                    Some(serde_json::json!({
+                        "changed": resp.changed,
+                        "errors": resp.errors.len(),
+                    })),
+                )
+                .await;
+
+                Ok(ScheduleReloadOutput {
+                    poll_every_sec: resp.poll_every_sec.to_string(),
+                    schedules: resp
+                        .schedules
                         .into_iter()
-                        .map(|e| CacheEntryDto {
-                            key: e.key,
-                            path: e.path,
-                            size_bytes: e.size_bytes.to_string(),
-                            last_used_unix_ms: e.last_used_unix_ms.to_string(),
+                        .map(|s| BackupScheduleDto {
+                            instance_id: s.instance_id,
+                            interval_minutes: s.interval_minutes.to_string(),
+                            keep: s.keep,
+                            global: s.global,
+                            next_due_unix_ms: s.next_due_unix_ms.to_string(),
+                            last_run_unix_ms: (s.last_run_unix_ms != 0)
+                                .then(|| s.last_run_unix_ms.to_string()),
+                            last_result: (!s.last_result.is_empty()).then_some(s.last_result),
                         })
                         .collect(),
-                };
-
-                let mut agent_log_path: Option<String> = None;
-                let mut agent_log_lines: Vec<String> = Vec::new();
-                if let Ok(resp) = transport
-                    .call::<_, alloy_proto::agent_v1::ListDirResponse>(
-                        "/alloy.agent.v1.FilesystemService/ListDir",
-                        ListDirRequest {
-                            path: "logs".to_string(),
-                        },
-                    )
-                    .await
-                {
-                    let mut candidates: Vec<String> = resp
-                        .entries
+                    errors: resp
+                        .errors
                         .into_iter()
-                        .filter(|e| !e.is_dir && e.name.starts_with("agent.log"))
-                        .map(|e| e.name)
-                        .collect();
-                    candidates.sort();
-                    if let Some(name) = candidates.pop() {
-                        let p = format!("logs/{name}");
-                        agent_log_path = Some(p.clone());
-                        if let Ok(resp) = transport
-                            .call::<_, alloy_proto::agent_v1::TailFileResponse>(
-                                "/alloy.agent.v1.LogsService/TailFile",
-                                TailFileRequest {
-                                    path: p,
-                                    cursor: String::new(),
-                                    limit_bytes: 512 * 1024,
-                                    max_lines: 800,
-                                },
-                            )
-                            .await
-                        {
-                            agent_log_lines = resp.lines;
-                        }
-                    }
-                }
-
-                Ok(ControlDiagnosticsOutput {
-                    fetched_at_unix_ms,
-                    request_id: ctx.request_id.clone(),
-                    control_version: env!("CARGO_PKG_VERSION").to_string(),
-                    read_only: is_read_only(),
-                    agent: health,
-                    fs: fs_caps,
-                    cache,
-                    agent_log_path,
-                    agent_log_lines,
+                        .map(|e| BackupScheduleErrorDto {
+                            source: e.source,
+                            message: e.message,
+                        })
+                        .collect(),
+                    changed: resp.changed,
                 })
             }),
-        );
+        )
+        .procedure(
+            "supportBundle",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: SupportBundleInput| async move {
+                    enforce_rate_limit(&ctx)?;
+                    // Bundles hold logs and configs from every instance.
+                    let user = ctx
+                        .user
+                        .clone()
+                        .ok_or_else(|| api_error(&ctx, "unauthorized", "unauthorized"))?;
+                    if !user.is_admin {
+                        return Err(api_error(&ctx, "forbidden", "forbidden"));
+                    }
 
-    let agent = Router::new().procedure(
-        "health",
-        Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
-            let transport = agent_transport(&ctx);
-            let resp: alloy_proto::agent_v1::HealthCheckResponse = transport
-                .call(
-                    "/alloy.agent.v1.AgentHealthService/Check",
-                    HealthCheckRequest {},
-                )
-                .await
-                .map_err(|status| api_error_from_agent_status(&ctx, "agent.health", status))?;
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::SupportBundleResponse = transport
+                        .call(
+                            "/alloy.agent.v1.AgentHealthService/SupportBundle",
+                            SupportBundleRequest {
+                                instance_ids: input.instance_ids.unwrap_or_default(),
+                                console_lines: input.console_lines.unwrap_or(0),
+                                keep_ip_addresses: input.keep_ip_addresses.unwrap_or(false),
+                                inline: true,
+                            },
+                        )
+                        .await
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "agent.support_bundle", status)
+                        })?;
 
-            Ok(AgentHealthResponse {
-                status: resp.status,
-                agent_version: resp.agent_version,
-            })
-        }),
-    );
+                    audit::record(
+                        &ctx,
+                        "agent.supportBundle",
+                        &resp.path,
+                        Some(serde_json::json!({
+                            "size_bytes": resp.size_bytes,
+                            "redactions": resp.redactions,
+                        })),
+                    )
+                    .await;
+
+                    use base64::Engine;
+                    Ok(SupportBundleOutput {
+                        path: resp.path,
+                        size_bytes: resp.size_bytes.to_string(),
+                        files: resp.files,
+                        redactions: resp.redactions,
+                        data_base64: (!resp.data.is_empty())
+                            .then(|| base64::engine::general_purpose::STANDARD.encode(&resp.data)),
+                    })
+                },
+            ),
+        );
 
     let process = Router::new()
         .procedure(
@@ -2640,7 +4076,7 @@ pub fn router() -> Router<Ctx> {
                         params_json: Set(params_json),
                         state: Set(DOWNLOAD_STATE_QUEUED.to_string()),
                         message: Set("queued for download".to_string()),
-                        request_id: Set(None),
+                        request_id: Set(Some(ctx.request_id.clone())),
                         queue_position: Set(queue_position),
                         attempt_count: Set(0),
                         created_at: Set(now),
@@ -2674,38 +4110,205 @@ pub fn router() -> Router<Ctx> {
             ),
         )
         .procedure(
-            "downloadQueueSetPaused",
+            "downloadQueueSetPaused",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: DownloadQueueSetPausedInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    download_queue_set_paused(&*ctx.db, input.paused)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                    wake_download_queue_worker();
+
+                    audit::record(
+                        &ctx,
+                        "process.downloadQueueSetPaused",
+                        if input.paused { "paused" } else { "running" },
+                        None,
+                    )
+                    .await;
+
+                    Ok(DownloadQueueMutationOutput { ok: true })
+                },
+            ),
+        )
+        .procedure(
+            "downloadQueueMove",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: DownloadQueueMoveInput| async move {
+                    use alloy_db::entities::download_jobs;
+                    use sea_orm::{
+                        ActiveModelTrait, ColumnTrait, Condition, EntityTrait, QueryFilter,
+                        QueryOrder, Set,
+                    };
+
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    let job_id =
+                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
+                            api_error_with_field(
+                                &ctx,
+                                "invalid_param",
+                                "invalid job id",
+                                "job_id",
+                                "invalid uuid",
+                            )
+                        })?;
+                    let direction = if input.direction < 0 {
+                        -1_i32
+                    } else if input.direction > 0 {
+                        1_i32
+                    } else {
+                        0_i32
+                    };
+                    if direction == 0 {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    }
+
+                    let queue_condition = Condition::any()
+                        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_QUEUED))
+                        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_PAUSED));
+
+                    let rows = download_jobs::Entity::find()
+                        .filter(queue_condition)
+                        .order_by_asc(download_jobs::Column::QueuePosition)
+                        .order_by_asc(download_jobs::Column::CreatedAt)
+                        .all(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    let Some(idx) = rows.iter().position(|r| r.id == job_id) else {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    };
+
+                    let next_idx = idx as i32 + direction;
+                    if next_idx < 0 || next_idx >= rows.len() as i32 {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    }
+                    let next_idx = next_idx as usize;
+
+                    let left_pos = rows[idx].queue_position;
+                    let right_pos = rows[next_idx].queue_position;
+
+                    let mut left: download_jobs::ActiveModel = rows[idx].clone().into();
+                    left.queue_position = Set(right_pos);
+                    left.updated_at = Set(chrono::Utc::now().into());
+                    left.update(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    let mut right: download_jobs::ActiveModel = rows[next_idx].clone().into();
+                    right.queue_position = Set(left_pos);
+                    right.updated_at = Set(chrono::Utc::now().into());
+                    right
+                        .update(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    wake_download_queue_worker();
+                    Ok(DownloadQueueMutationOutput { ok: true })
+                },
+            ),
+        )
+        .procedure(
+            "downloadQueuePauseJob",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: DownloadQueueJobActionInput| async move {
+                    use alloy_db::entities::download_jobs;
+                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    let job_id =
+                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
+                            api_error_with_field(
+                                &ctx,
+                                "invalid_param",
+                                "invalid job id",
+                                "job_id",
+                                "invalid uuid",
+                            )
+                        })?;
+
+                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
+                        .one(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
+                    else {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    };
+                    if model.state != DOWNLOAD_STATE_QUEUED {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    }
+
+                    let mut active: download_jobs::ActiveModel = model.into();
+                    active.state = Set(DOWNLOAD_STATE_PAUSED.to_string());
+                    active.message = Set("paused in queue".to_string());
+                    active.updated_at = Set(chrono::Utc::now().into());
+                    active
+                        .update(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    Ok(DownloadQueueMutationOutput { ok: true })
+                },
+            ),
+        )
+        .procedure(
+            "downloadQueueResumeJob",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: DownloadQueueSetPausedInput| async move {
+                |ctx, input: DownloadQueueJobActionInput| async move {
+                    use alloy_db::entities::download_jobs;
+                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
-                    download_queue_set_paused(&*ctx.db, input.paused)
+                    let job_id =
+                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
+                            api_error_with_field(
+                                &ctx,
+                                "invalid_param",
+                                "invalid job id",
+                                "job_id",
+                                "invalid uuid",
+                            )
+                        })?;
+
+                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
+                        .one(&*ctx.db)
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
-                    wake_download_queue_worker();
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
+                    else {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    };
+                    if model.state != DOWNLOAD_STATE_PAUSED {
+                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    }
 
-                    audit::record(
-                        &ctx,
-                        "process.downloadQueueSetPaused",
-                        if input.paused { "paused" } else { "running" },
-                        None,
-                    )
-                    .await;
+                    let mut active: download_jobs::ActiveModel = model.into();
+                    active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
+                    active.message = Set("queued for download".to_string());
+                    active.updated_at = Set(chrono::Utc::now().into());
+                    active
+                        .update(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
 
+                    wake_download_queue_worker();
                     Ok(DownloadQueueMutationOutput { ok: true })
                 },
             ),
         )
         .procedure(
-            "downloadQueueMove",
+            "downloadQueueCancelJob",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: DownloadQueueMoveInput| async move {
+                |ctx, input: DownloadQueueJobActionInput| async move {
                     use alloy_db::entities::download_jobs;
-                    use sea_orm::{
-                        ActiveModelTrait, ColumnTrait, Condition, EntityTrait, QueryFilter,
-                        QueryOrder, Set,
-                    };
+                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
 
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
@@ -2720,64 +4323,38 @@ pub fn router() -> Router<Ctx> {
                                 "invalid uuid",
                             )
                         })?;
-                    let direction = if input.direction < 0 {
-                        -1_i32
-                    } else if input.direction > 0 {
-                        1_i32
-                    } else {
-                        0_i32
-                    };
-                    if direction == 0 {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
-                    }
-
-                    let queue_condition = Condition::any()
-                        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_QUEUED))
-                        .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_PAUSED));
 
-                    let rows = download_jobs::Entity::find()
-                        .filter(queue_condition)
-                        .order_by_asc(download_jobs::Column::QueuePosition)
-                        .order_by_asc(download_jobs::Column::CreatedAt)
-                        .all(&*ctx.db)
+                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
+                        .one(&*ctx.db)
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
-
-                    let Some(idx) = rows.iter().position(|r| r.id == job_id) else {
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
+                    else {
                         return Ok(DownloadQueueMutationOutput { ok: true });
                     };
-
-                    let next_idx = idx as i32 + direction;
-                    if next_idx < 0 || next_idx >= rows.len() as i32 {
+                    if model.state != DOWNLOAD_STATE_QUEUED && model.state != DOWNLOAD_STATE_PAUSED
+                    {
                         return Ok(DownloadQueueMutationOutput { ok: true });
                     }
-                    let next_idx = next_idx as usize;
-
-                    let left_pos = rows[idx].queue_position;
-                    let right_pos = rows[next_idx].queue_position;
-
-                    let mut left: download_jobs::ActiveModel = rows[idx].clone().into();
-                    left.queue_position = Set(right_pos);
-                    left.updated_at = Set(chrono::Utc::now().into());
-                    left.update(&*ctx.db)
-                        .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
 
-                    let mut right: download_jobs::ActiveModel = rows[next_idx].clone().into();
-                    right.queue_position = Set(left_pos);
-                    right.updated_at = Set(chrono::Utc::now().into());
-                    right
+                    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+                    let mut active: download_jobs::ActiveModel = model.into();
+                    active.state = Set(DOWNLOAD_STATE_CANCELED.to_string());
+                    active.message = Set("canceled by user".to_string());
+                    active.updated_at = Set(now);
+                    active.finished_at = Set(Some(now));
+                    active
                         .update(&*ctx.db)
                         .await
                         .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
 
+                    let _ = trim_download_history(&*ctx.db, 50).await;
                     wake_download_queue_worker();
                     Ok(DownloadQueueMutationOutput { ok: true })
                 },
             ),
         )
         .procedure(
-            "downloadQueuePauseJob",
+            "downloadQueueRetryJob",
             Procedure::builder::<ApiError>().mutation(
                 |ctx, input: DownloadQueueJobActionInput| async move {
                     use alloy_db::entities::download_jobs;
@@ -2804,371 +4381,951 @@ pub fn router() -> Router<Ctx> {
                     else {
                         return Ok(DownloadQueueMutationOutput { ok: true });
                     };
-                    if model.state != DOWNLOAD_STATE_QUEUED {
+
+                    if model.state != DOWNLOAD_STATE_SUCCESS
+                        && model.state != DOWNLOAD_STATE_ERROR
+                        && model.state != DOWNLOAD_STATE_CANCELED
+                        && model.state != DOWNLOAD_STATE_PAUSED
+                    {
                         return Ok(DownloadQueueMutationOutput { ok: true });
                     }
 
-                    let mut active: download_jobs::ActiveModel = model.into();
-                    active.state = Set(DOWNLOAD_STATE_PAUSED.to_string());
-                    active.message = Set("paused in queue".to_string());
-                    active.updated_at = Set(chrono::Utc::now().into());
-                    active
-                        .update(&*ctx.db)
+                    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
+                    let next_pos = download_queue_next_position(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    let mut active: download_jobs::ActiveModel = model.into();
+                    active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
+                    active.message = Set("queued for retry".to_string());
+                    active.request_id = Set(Some(ctx.request_id.clone()));
+                    active.queue_position = Set(next_pos);
+                    active.started_at = Set(None);
+                    active.finished_at = Set(None);
+                    active.updated_at = Set(now);
+                    active
+                        .update(&*ctx.db)
+                        .await
+                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                    wake_download_queue_worker();
+                    Ok(DownloadQueueMutationOutput { ok: true })
+                },
+            ),
+        )
+        .procedure(
+            "downloadQueueClearHistory",
+            Procedure::builder::<ApiError>().mutation(|ctx, _: ()| async move {
+                use alloy_db::entities::download_jobs;
+                use sea_orm::{ColumnTrait, Condition, EntityTrait, QueryFilter};
+
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let terminal = Condition::any()
+                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_SUCCESS))
+                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_ERROR))
+                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_CANCELED));
+                let _ = download_jobs::Entity::delete_many()
+                    .filter(terminal)
+                    .exec(&*ctx.db)
+                    .await
+                    .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+
+                Ok(DownloadQueueMutationOutput { ok: true })
+            }),
+        );
+
+    let fs = Router::new()
+        .procedure(
+            "capabilities",
+            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetCapabilitiesResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/GetCapabilities",
+                        GetCapabilitiesRequest {},
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "fs.get_capabilities", status)
+                    })?;
+
+                Ok(FsCapabilitiesOutput {
+                    write_enabled: resp.write_enabled,
+                    disabled_commands: resp.disabled_commands,
+                    backup_protect_hours: resp.backup_protect_hours,
+                })
+            }),
+        )
+        .procedure(
+            "listDir",
+            Procedure::builder::<ApiError>().query(|ctx, input: ListDirInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ListDirResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/ListDir",
+                        ListDirRequest {
+                            path: input.path.unwrap_or_default(),
+                            no_cache: input.no_cache.unwrap_or(false),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.list_dir", status))?;
+
+                Ok(ListDirOutput {
+                    entries: resp
+                        .entries
+                        .into_iter()
+                        .map(|e| DirEntryDto {
+                            name: e.name,
+                            is_dir: e.is_dir,
+                            size_bytes: clamp_u64_to_u32(e.size_bytes),
+                            modified_unix_ms: e.modified_unix_ms.to_string(),
+                        })
+                        .collect(),
+                })
+            }),
+        )
+        .procedure(
+            "statBatch",
+            Procedure::builder::<ApiError>().query(|ctx, input: StatBatchInput| async move {
+                // Mirrors the agent's limit so an oversized batch fails with a field error.
+                if input.paths.len() > 256 {
+                    return Err(api_error_with_field(
+                        &ctx,
+                        "invalid_param",
+                        "too many paths",
+                        "paths",
+                        "at most 256 paths per call",
+                    ));
+                }
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::StatBatchResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/StatBatch",
+                        alloy_proto::agent_v1::StatBatchRequest { paths: input.paths },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.stat_batch", status))?;
+
+                Ok(StatBatchOutput {
+                    results: resp
+                        .results
+                        .into_iter()
+                        .map(|r| PathStatDto {
+                            path: r.path,
+                            exists: r.exists,
+                            is_dir: r.is_dir,
+                            size_bytes: clamp_u64_to_u32(r.size_bytes),
+                            modified_unix_ms: r.modified_unix_ms.to_string(),
+                            error: (!r.error.is_empty()).then_some(r.error),
+                        })
+                        .collect(),
+                })
+            }),
+        )
+        .procedure(
+            "hash",
+            Procedure::builder::<ApiError>().query(|ctx, input: FsHashInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::HashResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/Hash",
+                        alloy_proto::agent_v1::HashRequest {
+                            path: input.path,
+                            algorithm: input.algorithm.unwrap_or_default(),
+                            quick: input.quick.unwrap_or(false),
+                            quick_bytes: input.quick_bytes.unwrap_or(0) as u64,
+                            workers: input.workers.unwrap_or(0),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.hash", status))?;
+
+                Ok(FsHashOutput {
+                    algorithm: resp.algorithm,
+                    quick: resp.quick,
+                    files: resp
+                        .files
+                        .into_iter()
+                        .map(|f| FileHashDto {
+                            path: f.path,
+                            size_bytes: f.size_bytes.to_string(),
+                            hash: f.hash,
+                            error: (!f.error.is_empty()).then_some(f.error),
+                        })
+                        .collect(),
+                })
+            }),
+        )
+        .procedure(
+            "search",
+            Procedure::builder::<ApiError>().query(|ctx, input: FsSearchInput| async move {
+                // Queries are plain GETs without the CSRF check, so they must not write.
+                if input.export.as_deref().is_some_and(|e| !e.is_empty()) {
+                    return Err(api_error_with_field(
+                        &ctx,
+                        "invalid_param",
+                        "export is not supported here",
+                        "export",
+                        "use fs.searchExport to write matches to a file",
+                    ));
+                }
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::SearchResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/Search",
+                        alloy_proto::agent_v1::SearchRequest {
+                            path: input.path,
+                            query: input.query,
+                            case_insensitive: input.case_insensitive.unwrap_or(false),
+                            max_matches: input.max_matches.unwrap_or(0),
+                            export: String::new(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.search", status))?;
+
+                Ok(map_search_response(resp))
+            }),
+        )
+        .procedure(
+            "searchExport",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: FsSearchExportInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+                    if input.format.trim().is_empty() {
+                        return Err(api_error_with_field(
+                            &ctx,
+                            "invalid_param",
+                            "format is required",
+                            "format",
+                            "use json or csv",
+                        ));
+                    }
+
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::SearchResponse = transport
+                        .call(
+                            "/alloy.agent.v1.FilesystemService/Search",
+                            alloy_proto::agent_v1::SearchRequest {
+                                path: input.path.clone(),
+                                query: input.query,
+                                case_insensitive: input.case_insensitive.unwrap_or(false),
+                                max_matches: input.max_matches.unwrap_or(0),
+                                export: input.format.clone(),
+                            },
+                        )
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "fs.search_export", status)
+                        })?;
 
-                    Ok(DownloadQueueMutationOutput { ok: true })
+                    audit::record(
+                        &ctx,
+                        "fs.search_export",
+                        &input.path,
+                        Some(serde_json::json!({
+                            "format": input.format,
+                            "export_path": resp.export_path,
+                            "total_matches": resp.total_matches,
+                            "truncated": resp.export_truncated,
+                        })),
+                    )
+                    .await;
+
+                    Ok(map_search_response(resp))
                 },
             ),
         )
         .procedure(
-            "downloadQueueResumeJob",
-            Procedure::builder::<ApiError>().mutation(
-                |ctx, input: DownloadQueueJobActionInput| async move {
-                    use alloy_db::entities::download_jobs;
-                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+            "readFile",
+            Procedure::builder::<ApiError>().query(|ctx, input: ReadFileInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ReadFileResponse = transport
+                    .call(
+                        "/alloy.agent.v1.FilesystemService/ReadFile",
+                        ReadFileRequest {
+                            path: input.path,
+                            offset: input.offset.unwrap_or(0) as u64,
+                            limit: input.limit.unwrap_or(0) as u64,
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.read_file", status))?;
+
+                let text = String::from_utf8(resp.data)
+                    .map_err(|_| api_error(&ctx, "invalid_utf8", "file is not valid utf-8"))?;
+
+                Ok(ReadFileOutput {
+                    text,
+                    size_bytes: clamp_u64_to_u32(resp.size_bytes),
+                })
+            }),
+        );
+
+    let log = Router::new()
+        .procedure(
+            "tailFile",
+            Procedure::builder::<ApiError>().query(|ctx, input: TailFileInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::TailFileResponse = transport
+                    .call(
+                        "/alloy.agent.v1.LogsService/TailFile",
+                        TailFileRequest {
+                            path: input.path,
+                            cursor: input.cursor.unwrap_or_default(),
+                            limit_bytes: input.limit_bytes.unwrap_or(0),
+                            max_lines: input.max_lines.unwrap_or(0),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "log.tail_file", status))?;
+
+                Ok(TailFileOutput {
+                    lines: resp.lines,
+                    next_cursor: resp.next_cursor,
+                })
+            }),
+        )
+        .procedure(
+            "paste",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: PasteLogInput| async move {
+                // Uploads to a third-party service, so it is gated and rate limited like a write.
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::PasteFileResponse = transport
+                    .call(
+                        "/alloy.agent.v1.LogsService/PasteFile",
+                        PasteFileRequest {
+                            path: input.path.clone(),
+                            filter: input.filter.unwrap_or_default(),
+                            max_lines: input.max_lines.unwrap_or(0),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "log.paste", status))?;
+
+                audit::record(
+                    &ctx,
+                    "log.paste",
+                    &input.path,
+                    Some(serde_json::json!({
+                        "url": resp.url,
+                        "lines": resp.lines,
+                        "redactions": resp.redactions,
+                    })),
+                )
+                .await;
+
+                Ok(PasteLogOutput {
+                    url: resp.url,
+                    raw_url: (!resp.raw_url.is_empty()).then_some(resp.raw_url),
+                    service: resp.service,
+                    lines: resp.lines,
+                    bytes: resp.bytes.to_string(),
+                    redactions: resp.redactions,
+                    truncated: resp.truncated,
+                })
+            }),
+        );
 
+    let instance = Router::new()
+        .procedure(
+            "create",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: CreateInstanceInput| async move {
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
-                    let job_id =
-                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
-                            api_error_with_field(
-                                &ctx,
-                                "invalid_param",
-                                "invalid job id",
-                                "job_id",
-                                "invalid uuid",
-                            )
-                        })?;
+                    let mut params = input.params;
 
-                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
-                        .one(&*ctx.db)
-                        .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
-                    else {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
-                    };
-                    if model.state != DOWNLOAD_STATE_PAUSED {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    // Defaults and control-plane settings injection.
+                    if input.template_id == "dst:vanilla" {
+                        let current = params.get("cluster_token").map(|s| s.trim()).unwrap_or("");
+                        if current.is_empty() {
+                            if let Some(v) = setting_get(&*ctx.db, SETTING_DST_DEFAULT_KLEI_KEY)
+                                .await
+                                .map_err(|e| {
+                                    api_error(&ctx, "db_error", format!("db error: {e}"))
+                                })?
+                            {
+                                let v = v.trim().to_string();
+                                if !v.is_empty() {
+                                    params.insert("cluster_token".to_string(), v);
+                                }
+                            }
+                        }
                     }
 
-                    let mut active: download_jobs::ActiveModel = model.into();
-                    active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
-                    active.message = Set("queued for download".to_string());
-                    active.updated_at = Set(chrono::Utc::now().into());
-                    active
-                        .update(&*ctx.db)
+                    if input.template_id == "minecraft:curseforge" {
+                        let current = params
+                            .get("curseforge_api_key")
+                            .map(|s| s.trim())
+                            .unwrap_or("");
+                        if current.is_empty() {
+                            let v = setting_get(&*ctx.db, SETTING_CURSEFORGE_API_KEY)
+                                .await
+                                .map_err(|e| {
+                                    api_error(&ctx, "db_error", format!("db error: {e}"))
+                                })?;
+                            let Some(v) = v else {
+                                return Err(api_error(
+                                    &ctx,
+                                    "missing_setting",
+                                    "CurseForge API key is not configured",
+                                ));
+                            };
+                            let v = v.trim().to_string();
+                            if v.is_empty() {
+                                return Err(api_error(
+                                    &ctx,
+                                    "missing_setting",
+                                    "CurseForge API key is not configured",
+                                ));
+                            }
+                            params.insert("curseforge_api_key".to_string(), v);
+                        }
+                    }
+
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::CreateInstanceResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/Create",
+                            CreateInstanceRequest {
+                                template_id: input.template_id,
+                                params: params.into_iter().collect(),
+                                display_name: input.display_name.unwrap_or_default(),
+                            },
+                        )
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.create", status)
+                        })?;
 
-                    wake_download_queue_worker();
-                    Ok(DownloadQueueMutationOutput { ok: true })
+                    let cfg = resp
+                        .config
+                        .ok_or_else(|| api_error(&ctx, "internal", "missing instance config"))?;
+
+                    audit::record(
+                        &ctx,
+                        "instance.create",
+                        &cfg.instance_id,
+                        Some(serde_json::json!({ "template_id": cfg.template_id })),
+                    )
+                    .await;
+
+                    Ok(map_instance_config(cfg))
                 },
             ),
         )
         .procedure(
-            "downloadQueueCancelJob",
-            Procedure::builder::<ApiError>().mutation(
-                |ctx, input: DownloadQueueJobActionInput| async move {
-                    use alloy_db::entities::download_jobs;
-                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
-
-                    ensure_writable(&ctx)?;
-                    enforce_rate_limit(&ctx)?;
-
-                    let job_id =
-                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
-                            api_error_with_field(
-                                &ctx,
-                                "invalid_param",
-                                "invalid job id",
-                                "job_id",
-                                "invalid uuid",
-                            )
-                        })?;
+            "get",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetInstanceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/Get",
+                        GetInstanceRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.get", status))?;
 
-                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
-                        .one(&*ctx.db)
-                        .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
-                    else {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
-                    };
-                    if model.state != DOWNLOAD_STATE_QUEUED && model.state != DOWNLOAD_STATE_PAUSED
-                    {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
-                    }
+                let info = resp
+                    .info
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing instance info"))?;
 
-                    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-                    let mut active: download_jobs::ActiveModel = model.into();
-                    active.state = Set(DOWNLOAD_STATE_CANCELED.to_string());
-                    active.message = Set("canceled by user".to_string());
-                    active.updated_at = Set(now);
-                    active.finished_at = Set(Some(now));
-                    active
-                        .update(&*ctx.db)
-                        .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                map_instance_info(&ctx, info)
+            }),
+        )
+        .procedure(
+            "list",
+            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ListInstancesResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/List",
+                        ListInstancesRequest {},
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.list", status))?;
 
-                    let _ = trim_download_history(&*ctx.db, 50).await;
-                    wake_download_queue_worker();
-                    Ok(DownloadQueueMutationOutput { ok: true })
-                },
-            ),
+                let mut out = Vec::new();
+                for info in resp.instances {
+                    out.push(map_instance_info(&ctx, info)?);
+                }
+                Ok(out)
+            }),
         )
         .procedure(
-            "downloadQueueRetryJob",
+            "diagnostics",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: DownloadQueueJobActionInput| async move {
-                    use alloy_db::entities::download_jobs;
-                    use sea_orm::{ActiveModelTrait, EntityTrait, Set};
-
-                    ensure_writable(&ctx)?;
+                |ctx, input: InstanceDiagnosticsInput| async move {
                     enforce_rate_limit(&ctx)?;
 
-                    let job_id =
-                        sea_orm::prelude::Uuid::parse_str(input.job_id.trim()).map_err(|_| {
-                            api_error_with_field(
-                                &ctx,
-                                "invalid_param",
-                                "invalid job id",
-                                "job_id",
-                                "invalid uuid",
-                            )
-                        })?;
+                    let transport = agent_transport(&ctx);
 
-                    let Some(model) = download_jobs::Entity::find_by_id(job_id)
-                        .one(&*ctx.db)
-                        .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?
-                    else {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
+                    let instance_id = input.instance_id;
+                    let max_lines = input.max_lines.unwrap_or(400).clamp(1, 2000);
+                    let limit_bytes = input
+                        .limit_bytes
+                        .unwrap_or(256 * 1024)
+                        .clamp(1024, 1024 * 1024);
+
+                    let to_utf8 = |bytes: Vec<u8>| -> Result<String, ApiError> {
+                        String::from_utf8(bytes)
+                            .map_err(|_| api_error(&ctx, "invalid_utf8", "file is not valid utf-8"))
                     };
 
-                    if model.state != DOWNLOAD_STATE_SUCCESS
-                        && model.state != DOWNLOAD_STATE_ERROR
-                        && model.state != DOWNLOAD_STATE_CANCELED
-                        && model.state != DOWNLOAD_STATE_PAUSED
+                    let instance_json = match transport
+                        .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
+                            "/alloy.agent.v1.FilesystemService/ReadFile",
+                            ReadFileRequest {
+                                path: format!("instances/{}/instance.json", instance_id),
+                                offset: 0,
+                                limit: 1024 * 1024,
+                            },
+                        )
+                        .await
                     {
-                        return Ok(DownloadQueueMutationOutput { ok: true });
-                    }
+                        Ok(resp) => Some(to_utf8(resp.data)?),
+                        Err(status) => {
+                            if status.code() == tonic::Code::NotFound {
+                                None
+                            } else {
+                                return Err(api_error_from_agent_status(
+                                    &ctx,
+                                    "instance.diagnostics.read_file(instance.json)",
+                                    status,
+                                ));
+                            }
+                        }
+                    };
+                    let instance_json = instance_json.map(|raw| {
+                        let Ok(mut v) = serde_json::from_str::<serde_json::Value>(&raw) else {
+                            return raw;
+                        };
+                        if let Some(params) = v.get_mut("params").and_then(|p| p.as_object_mut())
+                            && params.contains_key("password")
+                        {
+                            params.insert(
+                                "password".to_string(),
+                                serde_json::Value::String("<redacted>".to_string()),
+                            );
+                        }
+                        serde_json::to_string_pretty(&v).unwrap_or(raw)
+                    });
 
-                    let now: sea_orm::prelude::DateTimeWithTimeZone = chrono::Utc::now().into();
-                    let next_pos = download_queue_next_position(&*ctx.db)
+                    let run_json = match transport
+                        .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
+                            "/alloy.agent.v1.FilesystemService/ReadFile",
+                            ReadFileRequest {
+                                path: format!("instances/{}/run.json", instance_id),
+                                offset: 0,
+                                limit: 1024 * 1024,
+                            },
+                        )
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                    {
+                        Ok(resp) => Some(to_utf8(resp.data)?),
+                        Err(status) => {
+                            if status.code() != tonic::Code::NotFound {
+                                return Err(api_error_from_agent_status(
+                                    &ctx,
+                                    "instance.diagnostics.read_file(run.json)",
+                                    status,
+                                ));
+                            }
 
-                    let mut active: download_jobs::ActiveModel = model.into();
-                    active.state = Set(DOWNLOAD_STATE_QUEUED.to_string());
-                    active.message = Set("queued for retry".to_string());
-                    active.request_id = Set(None);
-                    active.queue_position = Set(next_pos);
-                    active.started_at = Set(None);
-                    active.finished_at = Set(None);
-                    active.updated_at = Set(now);
-                    active
-                        .update(&*ctx.db)
+                            match transport
+                                .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
+                                    "/alloy.agent.v1.FilesystemService/ReadFile",
+                                    ReadFileRequest {
+                                        path: format!("processes/{}/run.json", instance_id),
+                                        offset: 0,
+                                        limit: 1024 * 1024,
+                                    },
+                                )
+                                .await
+                            {
+                                Ok(resp) => Some(to_utf8(resp.data)?),
+                                Err(status) => {
+                                    if status.code() == tonic::Code::NotFound {
+                                        None
+                                    } else {
+                                        return Err(api_error_from_agent_status(
+                                            &ctx,
+                                            "instance.diagnostics.read_file(run.json)",
+                                            status,
+                                        ));
+                                    }
+                                }
+                            }
+                        }
+                    };
+
+                    let console_log_lines = match transport
+                        .call::<_, alloy_proto::agent_v1::TailFileResponse>(
+                            "/alloy.agent.v1.LogsService/TailFile",
+                            TailFileRequest {
+                                path: format!("instances/{}/logs/console.log", instance_id),
+                                cursor: "0".to_string(),
+                                limit_bytes,
+                                max_lines,
+                            },
+                        )
                         .await
-                        .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                    {
+                        Ok(resp) => resp.lines,
+                        Err(status) => {
+                            if status.code() != tonic::Code::NotFound {
+                                return Err(api_error_from_agent_status(
+                                    &ctx,
+                                    "instance.diagnostics.tail_file(console.log)",
+                                    status,
+                                ));
+                            }
 
-                    wake_download_queue_worker();
-                    Ok(DownloadQueueMutationOutput { ok: true })
+                            match transport
+                                .call::<_, alloy_proto::agent_v1::TailFileResponse>(
+                                    "/alloy.agent.v1.LogsService/TailFile",
+                                    TailFileRequest {
+                                        path: format!("processes/{}/logs/console.log", instance_id),
+                                        cursor: "0".to_string(),
+                                        limit_bytes,
+                                        max_lines,
+                                    },
+                                )
+                                .await
+                            {
+                                Ok(resp) => resp.lines,
+                                Err(status) => {
+                                    if status.code() == tonic::Code::NotFound {
+                                        Vec::new()
+                                    } else {
+                                        return Err(api_error_from_agent_status(
+                                            &ctx,
+                                            "instance.diagnostics.tail_file(console.log)",
+                                            status,
+                                        ));
+                                    }
+                                }
+                            }
+                        }
+                    };
+
+                    let fetched_at_unix_ms = chrono::Utc::now().timestamp_millis().to_string();
+
+                    Ok(InstanceDiagnosticsOutput {
+                        instance_id,
+                        fetched_at_unix_ms,
+                        request_id: ctx.request_id.clone(),
+                        instance_json,
+                        run_json,
+                        console_log_lines,
+                    })
                 },
             ),
         )
         .procedure(
-            "downloadQueueClearHistory",
-            Procedure::builder::<ApiError>().mutation(|ctx, _: ()| async move {
-                use alloy_db::entities::download_jobs;
-                use sea_orm::{ColumnTrait, Condition, EntityTrait, QueryFilter};
-
+            "start",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
                 ensure_writable(&ctx)?;
                 enforce_rate_limit(&ctx)?;
 
-                let terminal = Condition::any()
-                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_SUCCESS))
-                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_ERROR))
-                    .add(download_jobs::Column::State.eq(DOWNLOAD_STATE_CANCELED));
-                let _ = download_jobs::Entity::delete_many()
-                    .filter(terminal)
-                    .exec(&*ctx.db)
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::StartInstanceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/Start",
+                        StartInstanceRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
                     .await
-                    .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.start", status)
+                    })?;
+
+                let status = resp
+                    .status
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
+
+                audit::record(
+                    &ctx,
+                    "instance.start",
+                    &status.process_id,
+                    Some(serde_json::json!({ "template_id": status.template_id })),
+                )
+                .await;
 
-                Ok(DownloadQueueMutationOutput { ok: true })
+                Ok(map_process_status(status))
             }),
-        );
-
-    let fs = Router::new()
+        )
         .procedure(
-            "capabilities",
-            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+            "verifyJar",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::GetCapabilitiesResponse = transport
+                let resp: alloy_proto::agent_v1::VerifyServerJarResponse = transport
                     .call(
-                        "/alloy.agent.v1.FilesystemService/GetCapabilities",
-                        GetCapabilitiesRequest {},
+                        "/alloy.agent.v1.InstanceService/VerifyServerJar",
+                        alloy_proto::agent_v1::VerifyServerJarRequest {
+                            instance_id: input.instance_id,
+                            accept_current: false,
+                        },
                     )
                     .await
                     .map_err(|status| {
-                        api_error_from_agent_status(&ctx, "fs.get_capabilities", status)
+                        api_error_from_agent_status(&ctx, "instance.verify_jar", status)
                     })?;
-
-                Ok(FsCapabilitiesOutput {
-                    write_enabled: resp.write_enabled,
-                })
+                Ok(map_jar_verification(resp))
             }),
         )
         .procedure(
-            "listDir",
-            Procedure::builder::<ApiError>().query(|ctx, input: ListDirInput| async move {
+            "acceptJar",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::ListDirResponse = transport
+                let instance_id = input.instance_id;
+                let resp: alloy_proto::agent_v1::VerifyServerJarResponse = transport
                     .call(
-                        "/alloy.agent.v1.FilesystemService/ListDir",
-                        ListDirRequest {
-                            path: input.path.unwrap_or_default(),
+                        "/alloy.agent.v1.InstanceService/VerifyServerJar",
+                        alloy_proto::agent_v1::VerifyServerJarRequest {
+                            instance_id: instance_id.clone(),
+                            accept_current: true,
                         },
                     )
                     .await
-                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.list_dir", status))?;
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.accept_jar", status)
+                    })?;
 
-                Ok(ListDirOutput {
-                    entries: resp
-                        .entries
-                        .into_iter()
-                        .map(|e| DirEntryDto {
-                            name: e.name,
-                            is_dir: e.is_dir,
-                            size_bytes: clamp_u64_to_u32(e.size_bytes),
-                            modified_unix_ms: e.modified_unix_ms.to_string(),
-                        })
-                        .collect(),
-                })
+                audit::record(
+                    &ctx,
+                    "instance.acceptJar",
+                    &instance_id,
+                    Some(serde_json::json!({ "sha1": resp.actual_sha1 })),
+                )
+                .await;
+
+                Ok(map_jar_verification(resp))
             }),
         )
         .procedure(
-            "readFile",
-            Procedure::builder::<ApiError>().query(|ctx, input: ReadFileInput| async move {
+            "validateStart",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
+                // Runs the server's jar on the node, so it is treated like a start.
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::ReadFileResponse = transport
+                let resp: alloy_proto::agent_v1::ValidateStartResponse = transport
                     .call(
-                        "/alloy.agent.v1.FilesystemService/ReadFile",
-                        ReadFileRequest {
-                            path: input.path,
-                            offset: input.offset.unwrap_or(0) as u64,
-                            limit: input.limit.unwrap_or(0) as u64,
+                        "/alloy.agent.v1.InstanceService/ValidateStart",
+                        alloy_proto::agent_v1::ValidateStartRequest {
+                            instance_id: input.instance_id.clone(),
                         },
                     )
                     .await
-                    .map_err(|status| api_error_from_agent_status(&ctx, "fs.read_file", status))?;
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.validate_start", status)
+                    })?;
 
-                let text = String::from_utf8(resp.data)
-                    .map_err(|_| api_error(&ctx, "invalid_utf8", "file is not valid utf-8"))?;
+                audit::record(
+                    &ctx,
+                    "instance.validate_start",
+                    &input.instance_id,
+                    Some(serde_json::json!({
+                        "ok": resp.ok,
+                        "verdict": resp.verdict,
+                        "exit_code": resp.has_exit_code.then_some(resp.exit_code),
+                        "timed_out": resp.timed_out,
+                    })),
+                )
+                .await;
 
-                Ok(ReadFileOutput {
-                    text,
-                    size_bytes: clamp_u64_to_u32(resp.size_bytes),
+                Ok(ValidateStartOutput {
+                    ok: resp.ok,
+                    verdict: resp.verdict,
+                    message: resp.message,
+                    jar_path: (!resp.jar_path.is_empty()).then_some(resp.jar_path),
+                    main_class: (!resp.main_class.is_empty()).then_some(resp.main_class),
+                    exit_code: resp.has_exit_code.then_some(resp.exit_code),
+                    timed_out: resp.timed_out,
+                    network_isolated: resp.network_isolated,
+                    duration_ms: resp.duration_ms.to_string(),
+                    output: resp.output,
                 })
             }),
-        );
-
-    let log = Router::new().procedure(
-        "tailFile",
-        Procedure::builder::<ApiError>().query(|ctx, input: TailFileInput| async move {
-            let transport = agent_transport(&ctx);
-            let resp: alloy_proto::agent_v1::TailFileResponse = transport
-                .call(
-                    "/alloy.agent.v1.LogsService/TailFile",
-                    TailFileRequest {
-                        path: input.path,
-                        cursor: input.cursor.unwrap_or_default(),
-                        limit_bytes: input.limit_bytes.unwrap_or(0),
-                        max_lines: input.max_lines.unwrap_or(0),
-                    },
-                )
-                .await
-                .map_err(|status| api_error_from_agent_status(&ctx, "log.tail_file", status))?;
-
-            Ok(TailFileOutput {
-                lines: resp.lines,
-                next_cursor: resp.next_cursor,
-            })
-        }),
-    );
-
-    let instance = Router::new()
+        )
         .procedure(
-            "create",
+            "restart",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: CreateInstanceInput| async move {
+                |ctx, input: RestartInstanceInput| async move {
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
-                    let mut params = input.params;
-
-                    // Defaults and control-plane settings injection.
-                    if input.template_id == "dst:vanilla" {
-                        let current = params.get("cluster_token").map(|s| s.trim()).unwrap_or("");
-                        if current.is_empty() {
-                            if let Some(v) = setting_get(&*ctx.db, SETTING_DST_DEFAULT_KLEI_KEY)
-                                .await
-                                .map_err(|e| {
-                                    api_error(&ctx, "db_error", format!("db error: {e}"))
-                                })?
-                            {
-                                let v = v.trim().to_string();
-                                if !v.is_empty() {
-                                    params.insert("cluster_token".to_string(), v);
-                                }
-                            }
-                        }
-                    }
+                    let transport = agent_transport(&ctx);
 
-                    if input.template_id == "minecraft:curseforge" {
-                        let current = params
-                            .get("curseforge_api_key")
-                            .map(|s| s.trim())
-                            .unwrap_or("");
-                        if current.is_empty() {
-                            let v = setting_get(&*ctx.db, SETTING_CURSEFORGE_API_KEY)
-                                .await
-                                .map_err(|e| {
-                                    api_error(&ctx, "db_error", format!("db error: {e}"))
-                                })?;
-                            let Some(v) = v else {
-                                return Err(api_error(
-                                    &ctx,
-                                    "missing_setting",
-                                    "CurseForge API key is not configured",
-                                ));
-                            };
-                            let v = v.trim().to_string();
-                            if v.is_empty() {
-                                return Err(api_error(
+                    // Best-effort: if the instance isn't running, the stop call may return NOT_FOUND.
+                    // Treat that as "already stopped" and continue to start.
+                    match transport
+                        .call::<_, alloy_proto::agent_v1::StopInstanceResponse>(
+                            "/alloy.agent.v1.InstanceService/Stop",
+                            StopInstanceRequest {
+                                instance_id: input.instance_id.clone(),
+                                timeout_ms: input.timeout_ms.unwrap_or(30_000),
+                            },
+                        )
+                        .await
+                    {
+                        Ok(_) => {}
+                        Err(status) => {
+                            if status.code() != tonic::Code::NotFound {
+                                return Err(api_error_from_agent_status(
                                     &ctx,
-                                    "missing_setting",
-                                    "CurseForge API key is not configured",
+                                    "instance.stop",
+                                    status,
                                 ));
                             }
-                            params.insert("curseforge_api_key".to_string(), v);
                         }
                     }
 
+                    let resp: alloy_proto::agent_v1::StartInstanceResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/Start",
+                            StartInstanceRequest {
+                                instance_id: input.instance_id,
+                            },
+                        )
+                        .await
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.start", status)
+                        })?;
+
+                    let status = resp
+                        .status
+                        .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
+
+                    audit::record(
+                        &ctx,
+                        "instance.restart",
+                        &status.process_id,
+                        Some(serde_json::json!({ "template_id": status.template_id })),
+                    )
+                    .await;
+
+                    Ok(map_process_status(status))
+                },
+            ),
+        )
+        .procedure(
+            "stop",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: StopInstanceInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::StopInstanceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/Stop",
+                        StopInstanceRequest {
+                            instance_id: input.instance_id,
+                            timeout_ms: input.timeout_ms.unwrap_or(30_000),
+                        },
+                    )
+                    .await
+                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.stop", status))?;
+
+                let status = resp
+                    .status
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
+
+                audit::record(
+                    &ctx,
+                    "instance.stop",
+                    &status.process_id,
+                    Some(serde_json::json!({ "template_id": status.template_id })),
+                )
+                .await;
+
+                Ok(map_process_status(status))
+            }),
+        )
+        .procedure(
+            "hibernate",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: StopInstanceInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::HibernateInstanceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/Hibernate",
+                        alloy_proto::agent_v1::HibernateInstanceRequest {
+                            instance_id: input.instance_id,
+                            timeout_ms: input.timeout_ms.unwrap_or(30_000),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.hibernate", status)
+                    })?;
+
+                let status = resp
+                    .status
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
+
+                audit::record(
+                    &ctx,
+                    "instance.hibernate",
+                    &status.process_id,
+                    Some(serde_json::json!({
+                        "template_id": status.template_id,
+                        "checkpointed": resp.checkpointed,
+                        "fallback_reason": resp.fallback_reason,
+                    })),
+                )
+                .await;
+
+                Ok(HibernateInstanceOutput {
+                    status: map_process_status(status),
+                    checkpointed: resp.checkpointed,
+                    fallback_reason: (!resp.fallback_reason.is_empty())
+                        .then_some(resp.fallback_reason),
+                    checkpoint_bytes: resp.checkpoint_bytes.to_string(),
+                    elapsed_ms: resp.elapsed_ms.to_string(),
+                })
+            }),
+        )
+        .procedure(
+            "update",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: UpdateInstanceInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
                     let transport = agent_transport(&ctx);
-                    let resp: alloy_proto::agent_v1::CreateInstanceResponse = transport
+                    let resp: alloy_proto::agent_v1::UpdateInstanceResponse = transport
                         .call(
-                            "/alloy.agent.v1.InstanceService/Create",
-                            CreateInstanceRequest {
-                                template_id: input.template_id,
-                                params: params.into_iter().collect(),
+                            "/alloy.agent.v1.InstanceService/Update",
+                            UpdateInstanceRequest {
+                                instance_id: input.instance_id.clone(),
+                                params: input.params.into_iter().collect(),
                                 display_name: input.display_name.unwrap_or_default(),
                             },
                         )
                         .await
                         .map_err(|status| {
-                            api_error_from_agent_status(&ctx, "instance.create", status)
+                            api_error_from_agent_status(&ctx, "instance.update", status)
                         })?;
 
                     let cfg = resp
@@ -3177,7 +5334,7 @@ pub fn router() -> Router<Ctx> {
 
                     audit::record(
                         &ctx,
-                        "instance.create",
+                        "instance.update",
                         &cfg.instance_id,
                         Some(serde_json::json!({ "template_id": cfg.template_id })),
                     )
@@ -3188,469 +5345,1070 @@ pub fn router() -> Router<Ctx> {
             ),
         )
         .procedure(
-            "get",
+            "importSaveFromUrl",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: ImportSaveFromUrlInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::ImportSaveFromUrlResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/ImportSaveFromUrl",
+                            alloy_proto::agent_v1::ImportSaveFromUrlRequest {
+                                instance_id: input.instance_id.clone(),
+                                url: input.url,
+                            },
+                        )
+                        .await
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.import_save", status)
+                        })?;
+
+                    if resp.ok {
+                        audit::record(
+                            &ctx,
+                            "instance.import_save",
+                            &input.instance_id,
+                            Some(serde_json::json!({ "installed_path": resp.installed_path })),
+                        )
+                        .await;
+                    }
+
+                    Ok(ImportSaveFromUrlOutput {
+                        ok: resp.ok,
+                        message: resp.message,
+                        installed_path: resp.installed_path,
+                        backup_path: resp.backup_path,
+                    })
+                },
+            ),
+        )
+        .procedure(
+            "deletePreview",
             Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::GetInstanceResponse = transport
+                let resp: alloy_proto::agent_v1::DeleteInstancePreviewResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/Get",
-                        GetInstanceRequest {
+                        "/alloy.agent.v1.InstanceService/DeletePreview",
+                        DeleteInstancePreviewRequest {
                             instance_id: input.instance_id,
                         },
                     )
                     .await
-                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.get", status))?;
-
-                let info = resp
-                    .info
-                    .ok_or_else(|| api_error(&ctx, "internal", "missing instance info"))?;
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.delete_preview", status)
+                    })?;
 
-                map_instance_info(&ctx, info)
+                Ok(DeleteInstancePreviewOutput {
+                    instance_id: resp.instance_id,
+                    path: resp.path,
+                    size_bytes: resp.size_bytes.to_string(),
+                })
             }),
         )
         .procedure(
-            "list",
-            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
+            "frpStatus",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::ListInstancesResponse = transport
+                let resp: alloy_proto::agent_v1::GetFrpStatusResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/List",
-                        ListInstancesRequest {},
+                        "/alloy.agent.v1.InstanceService/GetFrpStatus",
+                        GetFrpStatusRequest {
+                            instance_id: input.instance_id,
+                        },
                     )
                     .await
-                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.list", status))?;
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.frp_status", status)
+                    })?;
 
-                let mut out = Vec::new();
-                for info in resp.instances {
-                    out.push(map_instance_info(&ctx, info)?);
-                }
-                Ok(out)
+                Ok(InstanceFrpStatusOutput {
+                    configured: resp.configured,
+                    state: resp.state,
+                    proxies: resp
+                        .proxies
+                        .into_iter()
+                        .map(|p| FrpProxyStatusDto {
+                            configured_name: p.configured_name,
+                            name: p.name,
+                            collisions: p.collisions,
+                        })
+                        .collect(),
+                    last_error: if resp.last_error.is_empty() {
+                        None
+                    } else {
+                        Some(resp.last_error)
+                    },
+                    updated_at_unix_ms: resp.updated_at_unix_ms.to_string(),
+                    endpoints: resp.endpoints,
+                    active_endpoint: if resp.active_endpoint.is_empty() {
+                        None
+                    } else {
+                        Some(resp.active_endpoint)
+                    },
+                    failovers: resp
+                        .failovers
+                        .into_iter()
+                        .map(|e| FrpFailoverEventDto {
+                            at_unix_ms: e.at_unix_ms.to_string(),
+                            from: e.from,
+                            to: e.to,
+                            reason: e.reason,
+                        })
+                        .collect(),
+                    security: resp.security.map(|sec| FrpSecurityPostureDto {
+                        tls_enable: sec.tls_explicit.then_some(sec.tls_enable),
+                        level: sec.level,
+                        proxies: sec
+                            .proxies
+                            .into_iter()
+                            .map(|p| FrpProxySecurityDto {
+                                name: p.name,
+                                use_encryption: p.use_encryption,
+                                use_compression: p.use_compression,
+                            })
+                            .collect(),
+                        warnings: sec.warnings,
+                    }),
+                })
             }),
         )
         .procedure(
-            "diagnostics",
+            "frpAdmin",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: InstanceDiagnosticsInput| async move {
-                    enforce_rate_limit(&ctx)?;
+                |ctx, input: InstanceFrpAdminInput| async move {
+                    if input.action.trim() != "status" {
+                        ensure_writable(&ctx)?;
+                        enforce_rate_limit(&ctx)?;
+                    }
 
+                    let action = input.action.trim().to_string();
+                    let new_config = input
+                        .frp_config
+                        .as_deref()
+                        .is_some_and(|c| !c.trim().is_empty());
                     let transport = agent_transport(&ctx);
-
-                    let instance_id = input.instance_id;
-                    let max_lines = input.max_lines.unwrap_or(400).clamp(1, 2000);
-                    let limit_bytes = input
-                        .limit_bytes
-                        .unwrap_or(256 * 1024)
-                        .clamp(1024, 1024 * 1024);
-
-                    let to_utf8 = |bytes: Vec<u8>| -> Result<String, ApiError> {
-                        String::from_utf8(bytes)
-                            .map_err(|_| api_error(&ctx, "invalid_utf8", "file is not valid utf-8"))
-                    };
-
-                    let instance_json = match transport
-                        .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
-                            "/alloy.agent.v1.FilesystemService/ReadFile",
-                            ReadFileRequest {
-                                path: format!("instances/{}/instance.json", instance_id),
-                                offset: 0,
-                                limit: 1024 * 1024,
+                    let resp: alloy_proto::agent_v1::FrpAdminResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/FrpAdmin",
+                            FrpAdminRequest {
+                                instance_id: input.instance_id.clone(),
+                                action: action.clone(),
+                                frp_config: input.frp_config.unwrap_or_default(),
                             },
                         )
                         .await
-                    {
-                        Ok(resp) => Some(to_utf8(resp.data)?),
-                        Err(status) => {
-                            if status.code() == tonic::Code::NotFound {
-                                None
-                            } else {
-                                return Err(api_error_from_agent_status(
-                                    &ctx,
-                                    "instance.diagnostics.read_file(instance.json)",
-                                    status,
-                                ));
-                            }
-                        }
-                    };
-                    let instance_json = instance_json.map(|raw| {
-                        let Ok(mut v) = serde_json::from_str::<serde_json::Value>(&raw) else {
-                            return raw;
-                        };
-                        if let Some(params) = v.get_mut("params").and_then(|p| p.as_object_mut())
-                            && params.contains_key("password")
-                        {
-                            params.insert(
-                                "password".to_string(),
-                                serde_json::Value::String("<redacted>".to_string()),
-                            );
-                        }
-                        serde_json::to_string_pretty(&v).unwrap_or(raw)
-                    });
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.frp_admin", status)
+                        })?;
 
-                    let run_json = match transport
-                        .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
-                            "/alloy.agent.v1.FilesystemService/ReadFile",
-                            ReadFileRequest {
-                                path: format!("instances/{}/run.json", instance_id),
-                                offset: 0,
-                                limit: 1024 * 1024,
-                            },
+                    if action != "status" {
+                        audit::record(
+                            &ctx,
+                            "instance.frp_admin",
+                            &input.instance_id,
+                            Some(serde_json::json!({
+                                "action": action,
+                                "new_config": new_config,
+                            })),
                         )
-                        .await
-                    {
-                        Ok(resp) => Some(to_utf8(resp.data)?),
-                        Err(status) => {
-                            if status.code() != tonic::Code::NotFound {
-                                return Err(api_error_from_agent_status(
-                                    &ctx,
-                                    "instance.diagnostics.read_file(run.json)",
-                                    status,
-                                ));
-                            }
+                        .await;
+                    }
+
+                    Ok(InstanceFrpAdminOutput {
+                        output: resp.output,
+                    })
+                },
+            ),
+        )
+        .procedure(
+            "frpStats",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetFrpStatsResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetFrpStats",
+                        GetFrpStatsRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.frp_stats", status)
+                    })?;
+
+                fn non_empty(s: String) -> Option<String> {
+                    if s.is_empty() { None } else { Some(s) }
+                }
+
+                Ok(InstanceFrpStatsOutput {
+                    configured: resp.configured,
+                    proxies: resp
+                        .proxies
+                        .into_iter()
+                        .map(|p| FrpProxyStatsDto {
+                            name: p.name,
+                            proxy_type: non_empty(p.proxy_type),
+                            state: non_empty(p.state),
+                            remote_addr: non_empty(p.remote_addr),
+                            error: non_empty(p.error),
+                            cur_conns: p.has_counters.then(|| p.cur_conns.to_string()),
+                            traffic_in_bytes: p
+                                .has_counters
+                                .then(|| p.traffic_in_bytes.to_string()),
+                            traffic_out_bytes: p
+                                .has_counters
+                                .then(|| p.traffic_out_bytes.to_string()),
+                            last_heartbeat_unix_ms: (p.last_heartbeat_unix_ms > 0)
+                                .then(|| p.last_heartbeat_unix_ms.to_string()),
+                        })
+                        .collect(),
+                    sampled_at_unix_ms: (resp.sampled_at_unix_ms > 0)
+                        .then(|| resp.sampled_at_unix_ms.to_string()),
+                })
+            }),
+        )
+        .procedure(
+            "lastCrash",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetLastCrashResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetLastCrash",
+                        GetLastCrashRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.last_crash", status)
+                    })?;
+
+                let non_empty = |s: String| if s.is_empty() { None } else { Some(s) };
+                Ok(resp.crash.map(|c| CrashRecordDto {
+                    process_id: c.process_id,
+                    template_id: c.template_id,
+                    exited_at_unix_ms: c.exited_at_unix_ms.to_string(),
+                    runtime_ms: c.runtime_ms.to_string(),
+                    exit_code: c.has_exit_code.then_some(c.exit_code),
+                    signal: c.has_signal.then_some(c.signal),
+                    exit_category: non_empty(c.exit_category),
+                    exit_reason: non_empty(c.exit_reason),
+                    hs_err_path: non_empty(c.hs_err_path),
+                    lines: c.lines,
+                    path: c.path,
+                    start_request_id: non_empty(c.start_request_id),
+                }))
+            }),
+        )
+        .procedure(
+            "delete",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let instance_id = input.instance_id;
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::DeleteInstanceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/Delete",
+                        DeleteInstanceRequest {
+                            instance_id: instance_id.clone(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.delete", status)
+                    })?;
+
+                if resp.ok {
+                    audit::record(&ctx, "instance.delete", &instance_id, None).await;
+                }
+
+                Ok(DeleteInstanceOutput { ok: resp.ok })
+            }),
+        )
+        .procedure(
+            "setGolden",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: SetGoldenInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::SetGoldenResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/SetGolden",
+                        alloy_proto::agent_v1::SetGoldenRequest {
+                            instance_id: input.instance_id,
+                            golden: input.golden,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.set_golden", status)
+                    })?;
+
+                let cfg = resp
+                    .config
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing instance config"))?;
 
-                            match transport
-                                .call::<_, alloy_proto::agent_v1::ReadFileResponse>(
-                                    "/alloy.agent.v1.FilesystemService/ReadFile",
-                                    ReadFileRequest {
-                                        path: format!("processes/{}/run.json", instance_id),
-                                        offset: 0,
-                                        limit: 1024 * 1024,
-                                    },
-                                )
-                                .await
-                            {
-                                Ok(resp) => Some(to_utf8(resp.data)?),
-                                Err(status) => {
-                                    if status.code() == tonic::Code::NotFound {
-                                        None
-                                    } else {
-                                        return Err(api_error_from_agent_status(
-                                            &ctx,
-                                            "instance.diagnostics.read_file(run.json)",
-                                            status,
-                                        ));
-                                    }
-                                }
-                            }
-                        }
-                    };
+                audit::record(
+                    &ctx,
+                    "instance.set_golden",
+                    &cfg.instance_id,
+                    Some(serde_json::json!({ "golden": cfg.golden })),
+                )
+                .await;
 
-                    let console_log_lines = match transport
-                        .call::<_, alloy_proto::agent_v1::TailFileResponse>(
-                            "/alloy.agent.v1.LogsService/TailFile",
-                            TailFileRequest {
-                                path: format!("instances/{}/logs/console.log", instance_id),
-                                cursor: "0".to_string(),
-                                limit_bytes,
-                                max_lines,
+                Ok(map_instance_config(cfg))
+            }),
+        )
+        .procedure(
+            "createFromGolden",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: CreateFromGoldenInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::CreateFromGoldenResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/CreateFromGolden",
+                            alloy_proto::agent_v1::CreateFromGoldenRequest {
+                                golden_instance_id: input.golden_instance_id,
+                                display_name: input.display_name.unwrap_or_default(),
                             },
                         )
                         .await
-                    {
-                        Ok(resp) => resp.lines,
-                        Err(status) => {
-                            if status.code() != tonic::Code::NotFound {
-                                return Err(api_error_from_agent_status(
-                                    &ctx,
-                                    "instance.diagnostics.tail_file(console.log)",
-                                    status,
-                                ));
-                            }
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.create_from_golden", status)
+                        })?;
 
-                            match transport
-                                .call::<_, alloy_proto::agent_v1::TailFileResponse>(
-                                    "/alloy.agent.v1.LogsService/TailFile",
-                                    TailFileRequest {
-                                        path: format!("processes/{}/logs/console.log", instance_id),
-                                        cursor: "0".to_string(),
-                                        limit_bytes,
-                                        max_lines,
-                                    },
-                                )
-                                .await
-                            {
-                                Ok(resp) => resp.lines,
-                                Err(status) => {
-                                    if status.code() == tonic::Code::NotFound {
-                                        Vec::new()
-                                    } else {
-                                        return Err(api_error_from_agent_status(
-                                            &ctx,
-                                            "instance.diagnostics.tail_file(console.log)",
-                                            status,
-                                        ));
-                                    }
-                                }
-                            }
-                        }
-                    };
+                    let cfg = resp
+                        .config
+                        .ok_or_else(|| api_error(&ctx, "internal", "missing instance config"))?;
 
-                    let fetched_at_unix_ms = chrono::Utc::now().timestamp_millis().to_string();
+                    audit::record(
+                        &ctx,
+                        "instance.create",
+                        &cfg.instance_id,
+                        Some(serde_json::json!({
+                            "template_id": cfg.template_id,
+                            "derived_from": cfg.derived_from,
+                        })),
+                    )
+                    .await;
 
-                    Ok(InstanceDiagnosticsOutput {
-                        instance_id,
-                        fetched_at_unix_ms,
-                        request_id: ctx.request_id.clone(),
-                        instance_json,
-                        run_json,
-                        console_log_lines,
+                    Ok(CreateFromGoldenOutput {
+                        config: map_instance_config(cfg),
+                        reflinked_files: resp.reflinked_files,
+                        linked_files: resp.linked_files,
+                        copied_files: resp.copied_files,
+                        size_bytes: resp.size_bytes.to_string(),
+                        elapsed_ms: resp.elapsed_ms.to_string(),
                     })
                 },
             ),
         )
         .procedure(
-            "start",
-            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
+            "divergence",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetDivergenceResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetDivergence",
+                        alloy_proto::agent_v1::GetDivergenceRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.divergence", status)
+                    })?;
+
+                Ok(InstanceDivergenceOutput {
+                    golden_instance_id: resp.golden_instance_id,
+                    shared_bytes: resp.shared_bytes.to_string(),
+                    diverged_bytes: resp.diverged_bytes.to_string(),
+                    diverged_files: resp.diverged_files,
+                    removed_files: resp.removed_files,
+                })
+            }),
+        )
+        .procedure(
+            "mapPreview",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: MapPreviewInput| async move {
+                use base64::Engine;
+
+                // Renders write a PNG into the instance directory.
                 ensure_writable(&ctx)?;
                 enforce_rate_limit(&ctx)?;
 
+                // Bounds are all or nothing.
+                let bounds = [input.min_x, input.min_z, input.max_x, input.max_z];
+                let has_bounds = bounds.iter().all(Option::is_some);
+                if !has_bounds && bounds.iter().any(Option::is_some) {
+                    return Err(api_error_with_field(
+                        &ctx,
+                        "invalid_param",
+                        "incomplete bounds",
+                        "min_x",
+                        "set all of min_x, min_z, max_x and max_z, or none",
+                    ));
+                }
+
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::StartInstanceResponse = transport
+                let resp: alloy_proto::agent_v1::RenderMapPreviewResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/Start",
-                        StartInstanceRequest {
-                            instance_id: input.instance_id,
+                        "/alloy.agent.v1.InstanceService/RenderMapPreview",
+                        alloy_proto::agent_v1::RenderMapPreviewRequest {
+                            instance_id: input.instance_id.clone(),
+                            dimension: input.dimension.unwrap_or_default(),
+                            zoom: input.zoom.unwrap_or(0),
+                            has_bounds,
+                            min_x: input.min_x.unwrap_or(0),
+                            min_z: input.min_z.unwrap_or(0),
+                            max_x: input.max_x.unwrap_or(0),
+                            max_z: input.max_z.unwrap_or(0),
                         },
                     )
                     .await
                     .map_err(|status| {
-                        api_error_from_agent_status(&ctx, "instance.start", status)
+                        api_error_from_agent_status(&ctx, "instance.map_preview", status)
                     })?;
 
-                let status = resp
-                    .status
-                    .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
-
                 audit::record(
                     &ctx,
-                    "instance.start",
-                    &status.process_id,
-                    Some(serde_json::json!({ "template_id": status.template_id })),
+                    "instance.map_preview",
+                    &input.instance_id,
+                    Some(serde_json::json!({
+                        "dimension": resp.dimension,
+                        "zoom": resp.zoom,
+                        "path": resp.path,
+                        "chunks_rendered": resp.chunks_rendered,
+                    })),
                 )
                 .await;
 
-                Ok(map_process_status(status))
+                Ok(MapPreviewOutput {
+                    path: resp.path,
+                    png_base64: base64::engine::general_purpose::STANDARD.encode(&resp.png),
+                    width: resp.width,
+                    height: resp.height,
+                    zoom: resp.zoom,
+                    min_x: resp.min_x,
+                    min_z: resp.min_z,
+                    max_x: resp.max_x,
+                    max_z: resp.max_z,
+                    chunks_rendered: resp.chunks_rendered,
+                    chunks_flat: resp.chunks_flat,
+                    dimension: resp.dimension,
+                    seed: resp.has_seed.then(|| resp.seed.to_string()),
+                })
             }),
         )
         .procedure(
-            "restart",
+            "installWebMap",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: RestartInstanceInput| async move {
+                |ctx, input: InstallWebMapInput| async move {
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
                     let transport = agent_transport(&ctx);
-
-                    // Best-effort: if the instance isn't running, the stop call may return NOT_FOUND.
-                    // Treat that as "already stopped" and continue to start.
-                    match transport
-                        .call::<_, alloy_proto::agent_v1::StopInstanceResponse>(
-                            "/alloy.agent.v1.InstanceService/Stop",
-                            StopInstanceRequest {
+                    let resp: alloy_proto::agent_v1::InstallWebMapResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/InstallWebMap",
+                            alloy_proto::agent_v1::InstallWebMapRequest {
                                 instance_id: input.instance_id.clone(),
-                                timeout_ms: input.timeout_ms.unwrap_or(30_000),
+                                kind: input.kind.clone(),
+                                port: input.port.unwrap_or(0).into(),
+                                minecraft_version: input.minecraft_version.unwrap_or_default(),
+                                loader: input.loader.unwrap_or_default(),
                             },
                         )
                         .await
-                    {
-                        Ok(_) => {}
-                        Err(status) => {
-                            if status.code() != tonic::Code::NotFound {
-                                return Err(api_error_from_agent_status(
-                                    &ctx,
-                                    "instance.stop",
-                                    status,
-                                ));
-                            }
-                        }
-                    }
+                        .map_err(|status| {
+                            api_error_from_agent_status(&ctx, "instance.install_web_map", status)
+                        })?;
 
-                    let resp: alloy_proto::agent_v1::StartInstanceResponse = transport
+                    let out = map_web_map_status(resp.status, false);
+                    audit::record(
+                        &ctx,
+                        "instance.install_web_map",
+                        &input.instance_id,
+                        Some(serde_json::json!({
+                            "kind": input.kind,
+                            "version": out.version_number,
+                            "port": out.port,
+                        })),
+                    )
+                    .await;
+                    Ok(out)
+                },
+            ),
+        )
+        .procedure(
+            "exposeWebMap",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: ExposeWebMapInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let remove = input.remove.unwrap_or(false);
+                let domain = input.domain.unwrap_or_default().trim().to_string();
+                if !remove && domain.is_empty() {
+                    return Err(api_error_with_field(
+                        &ctx,
+                        "invalid_param",
+                        "domain is required",
+                        "domain",
+                        "set the domain that points at frps, or remove the exposure",
+                    ));
+                }
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ExposeWebMapResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/ExposeWebMap",
+                        alloy_proto::agent_v1::ExposeWebMapRequest {
+                            instance_id: input.instance_id.clone(),
+                            domain: domain.clone(),
+                            remove,
+                            public_port: input.public_port.unwrap_or(0).into(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.expose_web_map", status)
+                    })?;
+
+                audit::record(
+                    &ctx,
+                    "instance.expose_web_map",
+                    &input.instance_id,
+                    Some(serde_json::json!({ "domain": domain, "remove": remove })),
+                )
+                .await;
+                Ok(map_web_map_status(resp.status, resp.reloaded))
+            }),
+        )
+        .procedure(
+            "playerPositions",
+            Procedure::builder::<ApiError>().query(|ctx, input: PlayerPositionsInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ListPlayerPositionsResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/ListPlayerPositions",
+                        alloy_proto::agent_v1::ListPlayerPositionsRequest {
+                            instance_id: input.instance_id,
+                            player: input.player.unwrap_or_default(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.player_positions", status)
+                    })?;
+
+                Ok(resp
+                    .players
+                    .into_iter()
+                    .map(|p| PlayerPositionDto {
+                        uuid: p.uuid,
+                        name: (!p.name.is_empty()).then_some(p.name),
+                        dimension: p.dimension,
+                        x: p.x,
+                        y: p.y,
+                        z: p.z,
+                        yaw: p.yaw,
+                        pitch: p.pitch,
+                        health: p.health,
+                        xp_level: p.xp_level,
+                        game_mode: (!p.game_mode.is_empty()).then_some(p.game_mode),
+                        last_death: p.last_death.map(map_block_position),
+                        respawn: p.respawn.map(map_block_position),
+                        saved_at_unix_ms: p.saved_at_unix_ms.to_string(),
+                        error: (!p.error.is_empty()).then_some(p.error),
+                    })
+                    .collect::<Vec<_>>())
+            }),
+        )
+        .procedure(
+            "playerInventory",
+            Procedure::builder::<ApiError>().query(|ctx, input: PlayerInventoryInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetPlayerInventoryResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetPlayerInventory",
+                        alloy_proto::agent_v1::GetPlayerInventoryRequest {
+                            instance_id: input.instance_id,
+                            player: input.player,
+                            backup_path: input.backup_path.unwrap_or_default(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.player_inventory", status)
+                    })?;
+
+                Ok(PlayerInventoryOutput {
+                    uuid: resp.uuid,
+                    name: (!resp.name.is_empty()).then_some(resp.name),
+                    backup_path: (!resp.backup_path.is_empty()).then_some(resp.backup_path),
+                    items: map_inventory_items(resp.items),
+                    ender_items: map_inventory_items(resp.ender_items),
+                    xp_level: resp.xp_level,
+                })
+            }),
+        )
+        .procedure(
+            "playerStats",
+            Procedure::builder::<ApiError>().query(|ctx, input: PlayerStatsInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetPlayerStatsResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetPlayerStats",
+                        alloy_proto::agent_v1::GetPlayerStatsRequest {
+                            instance_id: input.instance_id,
+                            player: input.player.unwrap_or_default(),
+                            top_blocks: input.top_blocks.unwrap_or(0),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.player_stats", status)
+                    })?;
+
+                Ok(PlayerStatsOutput {
+                    players: resp.players.into_iter().map(map_player_stats).collect(),
+                    totals: map_player_stats(resp.totals.unwrap_or_default()),
+                })
+            }),
+        )
+        .procedure(
+            "restorePlayerData",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: RestorePlayerDataInput| async move {
+                    ensure_writable(&ctx)?;
+                    enforce_rate_limit(&ctx)?;
+
+                    let transport = agent_transport(&ctx);
+                    let resp: alloy_proto::agent_v1::RestorePlayerDataResponse = transport
                         .call(
-                            "/alloy.agent.v1.InstanceService/Start",
-                            StartInstanceRequest {
-                                instance_id: input.instance_id,
+                            "/alloy.agent.v1.InstanceService/RestorePlayerData",
+                            alloy_proto::agent_v1::RestorePlayerDataRequest {
+                                instance_id: input.instance_id.clone(),
+                                player: input.player.clone(),
+                                backup_path: input.backup_path.clone(),
                             },
                         )
                         .await
                         .map_err(|status| {
-                            api_error_from_agent_status(&ctx, "instance.start", status)
+                            api_error_from_agent_status(
+                                &ctx,
+                                "instance.restore_player_data",
+                                status,
+                            )
                         })?;
 
-                    let status = resp
-                        .status
-                        .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
-
                     audit::record(
                         &ctx,
-                        "instance.restart",
-                        &status.process_id,
-                        Some(serde_json::json!({ "template_id": status.template_id })),
+                        "instance.restore_player_data",
+                        &input.instance_id,
+                        Some(serde_json::json!({
+                            "player": input.player,
+                            "uuid": resp.uuid,
+                            "backup_path": input.backup_path,
+                            "previous_path": resp.previous_path,
+                        })),
                     )
                     .await;
 
-                    Ok(map_process_status(status))
+                    Ok(RestorePlayerDataOutput {
+                        uuid: resp.uuid,
+                        previous_path: (!resp.previous_path.is_empty())
+                            .then_some(resp.previous_path),
+                        items: resp.items,
+                        ender_items: resp.ender_items,
+                    })
                 },
             ),
         )
         .procedure(
-            "stop",
-            Procedure::builder::<ApiError>().mutation(|ctx, input: StopInstanceInput| async move {
-                ensure_writable(&ctx)?;
-                enforce_rate_limit(&ctx)?;
-
+            "propertyProfiles",
+            Procedure::builder::<ApiError>().query(|ctx, _: ()| async move {
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::StopInstanceResponse = transport
+                let resp: alloy_proto::agent_v1::ListPropertyProfilesResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/Stop",
-                        StopInstanceRequest {
-                            instance_id: input.instance_id,
-                            timeout_ms: input.timeout_ms.unwrap_or(30_000),
-                        },
+                        "/alloy.agent.v1.InstanceService/ListPropertyProfiles",
+                        alloy_proto::agent_v1::ListPropertyProfilesRequest {},
                     )
                     .await
-                    .map_err(|status| api_error_from_agent_status(&ctx, "instance.stop", status))?;
-
-                let status = resp
-                    .status
-                    .ok_or_else(|| api_error(&ctx, "internal", "missing status"))?;
-
-                audit::record(
-                    &ctx,
-                    "instance.stop",
-                    &status.process_id,
-                    Some(serde_json::json!({ "template_id": status.template_id })),
-                )
-                .await;
-
-                Ok(map_process_status(status))
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.property_profiles", status)
+                    })?;
+                Ok(resp
+                    .profiles
+                    .into_iter()
+                    .map(map_property_profile)
+                    .collect::<Vec<_>>())
             }),
         )
         .procedure(
-            "update",
+            "savePropertyProfile",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: UpdateInstanceInput| async move {
+                |ctx, input: SavePropertyProfileInput| async move {
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
                     let transport = agent_transport(&ctx);
-                    let resp: alloy_proto::agent_v1::UpdateInstanceResponse = transport
+                    let resp: alloy_proto::agent_v1::SavePropertyProfileResponse = transport
                         .call(
-                            "/alloy.agent.v1.InstanceService/Update",
-                            UpdateInstanceRequest {
-                                instance_id: input.instance_id.clone(),
-                                params: input.params.into_iter().collect(),
-                                display_name: input.display_name.unwrap_or_default(),
+                            "/alloy.agent.v1.InstanceService/SavePropertyProfile",
+                            alloy_proto::agent_v1::SavePropertyProfileRequest {
+                                profile: Some(alloy_proto::agent_v1::PropertyProfile {
+                                    name: input.name,
+                                    description: input.description.unwrap_or_default(),
+                                    builtin: false,
+                                    values: input
+                                        .values
+                                        .into_iter()
+                                        .map(|v| alloy_proto::agent_v1::PropertyValue {
+                                            key: v.key,
+                                            value: v.value,
+                                        })
+                                        .collect(),
+                                }),
                             },
                         )
                         .await
                         .map_err(|status| {
-                            api_error_from_agent_status(&ctx, "instance.update", status)
+                            api_error_from_agent_status(
+                                &ctx,
+                                "instance.save_property_profile",
+                                status,
+                            )
                         })?;
-
-                    let cfg = resp
-                        .config
-                        .ok_or_else(|| api_error(&ctx, "internal", "missing instance config"))?;
+                    let profile = resp
+                        .profile
+                        .ok_or_else(|| api_error(&ctx, "internal", "missing profile"))?;
 
                     audit::record(
                         &ctx,
-                        "instance.update",
-                        &cfg.instance_id,
-                        Some(serde_json::json!({ "template_id": cfg.template_id })),
+                        "instance.savePropertyProfile",
+                        &profile.name,
+                        Some(serde_json::json!({ "keys": profile.values.len() })),
                     )
                     .await;
 
-                    Ok(map_instance_config(cfg))
+                    Ok(map_property_profile(profile))
                 },
             ),
         )
         .procedure(
-            "importSaveFromUrl",
+            "deletePropertyProfile",
             Procedure::builder::<ApiError>().mutation(
-                |ctx, input: ImportSaveFromUrlInput| async move {
+                |ctx, input: PropertyProfileNameInput| async move {
                     ensure_writable(&ctx)?;
                     enforce_rate_limit(&ctx)?;
 
                     let transport = agent_transport(&ctx);
-                    let resp: alloy_proto::agent_v1::ImportSaveFromUrlResponse = transport
+                    let resp: alloy_proto::agent_v1::DeletePropertyProfileResponse = transport
                         .call(
-                            "/alloy.agent.v1.InstanceService/ImportSaveFromUrl",
-                            alloy_proto::agent_v1::ImportSaveFromUrlRequest {
-                                instance_id: input.instance_id.clone(),
-                                url: input.url,
+                            "/alloy.agent.v1.InstanceService/DeletePropertyProfile",
+                            alloy_proto::agent_v1::DeletePropertyProfileRequest {
+                                name: input.name.clone(),
                             },
                         )
                         .await
                         .map_err(|status| {
-                            api_error_from_agent_status(&ctx, "instance.import_save", status)
+                            api_error_from_agent_status(
+                                &ctx,
+                                "instance.delete_property_profile",
+                                status,
+                            )
                         })?;
 
-                    if resp.ok {
+                    if resp.deleted {
+                        audit::record(&ctx, "instance.deletePropertyProfile", &input.name, None)
+                            .await;
+                    }
+
+                    Ok(DeletePropertyProfileOutput {
+                        deleted: resp.deleted,
+                    })
+                },
+            ),
+        )
+        .procedure(
+            "applyPropertyProfiles",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx, input: ApplyPropertyProfilesInput| async move {
+                    let dry_run = input.dry_run.unwrap_or(false);
+                    if !dry_run {
+                        ensure_writable(&ctx)?;
+                    }
+                    enforce_rate_limit(&ctx)?;
+
+                    let transport = agent_transport(&ctx);
+                    let instance_id = input.instance_id;
+                    let profiles = input.profiles;
+                    let resp: alloy_proto::agent_v1::ApplyPropertyProfilesResponse = transport
+                        .call(
+                            "/alloy.agent.v1.InstanceService/ApplyPropertyProfiles",
+                            alloy_proto::agent_v1::ApplyPropertyProfilesRequest {
+                                instance_id: instance_id.clone(),
+                                profiles: profiles.clone(),
+                                dry_run,
+                            },
+                        )
+                        .await
+                        .map_err(|status| {
+                            api_error_from_agent_status(
+                                &ctx,
+                                "instance.apply_property_profiles",
+                                status,
+                            )
+                        })?;
+
+                    if resp.applied {
                         audit::record(
                             &ctx,
-                            "instance.import_save",
-                            &input.instance_id,
-                            Some(serde_json::json!({ "installed_path": resp.installed_path })),
+                            "instance.applyPropertyProfiles",
+                            &instance_id,
+                            Some(serde_json::json!({
+                                "profiles": profiles,
+                                "changed": resp.changes.iter().map(|c| &c.key).collect::<Vec<_>>(),
+                            })),
                         )
                         .await;
                     }
 
-                    Ok(ImportSaveFromUrlOutput {
-                        ok: resp.ok,
-                        message: resp.message,
-                        installed_path: resp.installed_path,
-                        backup_path: resp.backup_path,
+                    Ok(ApplyPropertyProfilesOutput {
+                        applied: resp.applied,
+                        restart_required: resp.restart_required,
+                        changes: resp
+                            .changes
+                            .into_iter()
+                            .map(|c| PropertyChangeDto {
+                                key: c.key,
+                                old_value: c.had_value.then_some(c.old_value),
+                                new_value: c.new_value,
+                            })
+                            .collect(),
                     })
                 },
             ),
         )
         .procedure(
-            "deletePreview",
+            "configSchema",
+            Procedure::builder::<ApiError>().query(|ctx, input: ConfigSchemaInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetConfigSchemaResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetConfigSchema",
+                        alloy_proto::agent_v1::GetConfigSchemaRequest {
+                            instance_id: input.instance_id,
+                            file: input.file.unwrap_or_default(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.config_schema", status)
+                    })?;
+
+                Ok(ConfigSchemaOutput {
+                    keys: resp
+                        .keys
+                        .into_iter()
+                        .map(|k| ConfigKeyDto {
+                            file: k.file,
+                            key: k.key,
+                            kind: k.kind,
+                            min: k.has_range.then_some(k.min),
+                            max: k.has_range.then_some(k.max),
+                            default_value: k.default_value,
+                            description: k.description,
+                            perf: k.perf,
+                            value: k.has_value.then_some(k.value),
+                            problem: (!k.problem.is_empty()).then_some(k.problem),
+                        })
+                        .collect(),
+                })
+            }),
+        )
+        .procedure(
+            "perfAudit",
             Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::DeleteInstancePreviewResponse = transport
+                let resp: alloy_proto::agent_v1::PerfAuditResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/DeletePreview",
-                        DeleteInstancePreviewRequest {
+                        "/alloy.agent.v1.InstanceService/PerfAudit",
+                        alloy_proto::agent_v1::PerfAuditRequest {
                             instance_id: input.instance_id,
                         },
                     )
                     .await
                     .map_err(|status| {
-                        api_error_from_agent_status(&ctx, "instance.delete_preview", status)
+                        api_error_from_agent_status(&ctx, "instance.perf_audit", status)
                     })?;
 
-                Ok(DeleteInstancePreviewOutput {
-                    instance_id: resp.instance_id,
-                    path: resp.path,
-                    size_bytes: resp.size_bytes.to_string(),
+                Ok(PerfAuditOutput {
+                    files: resp.files,
+                    findings: resp
+                        .findings
+                        .into_iter()
+                        .map(|f| PerfFindingDto {
+                            file: f.file,
+                            key: f.key,
+                            value: f.value,
+                            is_default: f.is_default,
+                            suggested: f.suggested,
+                            severity: f.severity,
+                            message: f.message,
+                        })
+                        .collect(),
                 })
             }),
         )
         .procedure(
-            "delete",
-            Procedure::builder::<ApiError>().mutation(|ctx, input: InstanceIdInput| async move {
+            "envReport",
+            Procedure::builder::<ApiError>().query(|ctx, input: EnvReportInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetEnvReportResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetEnvReport",
+                        alloy_proto::agent_v1::GetEnvReportRequest {
+                            instance_id: input.instance_id,
+                            backup_path: input.backup_path.unwrap_or_default(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.env_report", status)
+                    })?;
+
+                Ok(EnvReportOutput {
+                    current: resp.current.map(env_report_dto),
+                    backup: resp.backup.map(env_report_dto),
+                    differences: resp.differences,
+                })
+            }),
+        )
+        .procedure(
+            "managedFiles",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::ListManagedFilesResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/ListManagedFiles",
+                        alloy_proto::agent_v1::ListManagedFilesRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.managed_files", status)
+                    })?;
+
+                Ok(ManagedFilesOutput {
+                    files: resp.files.into_iter().map(map_managed_file).collect(),
+                })
+            }),
+        )
+        .procedure(
+            "manageFile",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: ManageFileInput| async move {
                 ensure_writable(&ctx)?;
                 enforce_rate_limit(&ctx)?;
 
-                let instance_id = input.instance_id;
                 let transport = agent_transport(&ctx);
-                let resp: alloy_proto::agent_v1::DeleteInstanceResponse = transport
+                let instance_id = input.instance_id;
+                let resp: alloy_proto::agent_v1::ManageFileResponse = transport
                     .call(
-                        "/alloy.agent.v1.InstanceService/Delete",
-                        DeleteInstanceRequest {
+                        "/alloy.agent.v1.InstanceService/ManageFile",
+                        alloy_proto::agent_v1::ManageFileRequest {
                             instance_id: instance_id.clone(),
+                            path: input.path,
+                            policy: input.policy.unwrap_or_default(),
+                            has_content: input.content.is_some(),
+                            content: input.content.unwrap_or_default().into_bytes(),
                         },
                     )
                     .await
                     .map_err(|status| {
-                        api_error_from_agent_status(&ctx, "instance.delete", status)
+                        api_error_from_agent_status(&ctx, "instance.manage_file", status)
                     })?;
+                let file = resp
+                    .file
+                    .map(map_managed_file)
+                    .ok_or_else(|| api_error(&ctx, "internal", "missing file"))?;
 
-                if resp.ok {
-                    audit::record(&ctx, "instance.delete", &instance_id, None).await;
+                audit::record(
+                    &ctx,
+                    "instance.manageFile",
+                    &instance_id,
+                    Some(serde_json::json!({
+                        "path": file.path,
+                        "policy": file.policy,
+                        "sha256": file.sha256,
+                    })),
+                )
+                .await;
+
+                Ok(file)
+            }),
+        )
+        .procedure(
+            "unmanageFile",
+            Procedure::builder::<ApiError>().mutation(|ctx, input: UnmanageFileInput| async move {
+                ensure_writable(&ctx)?;
+                enforce_rate_limit(&ctx)?;
+
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::UnmanageFileResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/UnmanageFile",
+                        alloy_proto::agent_v1::UnmanageFileRequest {
+                            instance_id: input.instance_id.clone(),
+                            path: input.path.clone(),
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.unmanage_file", status)
+                    })?;
+
+                if resp.removed {
+                    audit::record(
+                        &ctx,
+                        "instance.unmanageFile",
+                        &input.instance_id,
+                        Some(serde_json::json!({ "path": input.path })),
+                    )
+                    .await;
                 }
 
-                Ok(DeleteInstanceOutput { ok: resp.ok })
+                Ok(UnmanageFileOutput {
+                    removed: resp.removed,
+                })
+            }),
+        )
+        .procedure(
+            "webMapStatus",
+            Procedure::builder::<ApiError>().query(|ctx, input: InstanceIdInput| async move {
+                let transport = agent_transport(&ctx);
+                let resp: alloy_proto::agent_v1::GetWebMapStatusResponse = transport
+                    .call(
+                        "/alloy.agent.v1.InstanceService/GetWebMapStatus",
+                        alloy_proto::agent_v1::GetWebMapStatusRequest {
+                            instance_id: input.instance_id,
+                        },
+                    )
+                    .await
+                    .map_err(|status| {
+                        api_error_from_agent_status(&ctx, "instance.web_map_status", status)
+                    })?;
+                Ok(map_web_map_status(resp.status, false))
             }),
         );
 
@@ -3666,9 +6424,14 @@ pub fn router() -> Router<Ctx> {
                     .await
                     .map_err(|e| api_error(&ctx, "db_error", format!("db error: {e}")))?;
 
-                Ok(rows
-                    .into_iter()
-                    .map(|n| NodeDto {
+                let mut out = Vec::with_capacity(rows.len());
+                for n in rows {
+                    let conn = ctx.agent_hub.get(&n.name).await;
+                    let disabled_commands = conn
+                        .as_ref()
+                        .map(|c| c.disabled_commands.clone())
+                        .unwrap_or_default();
+                    out.push(NodeDto {
                         id: n.id.to_string(),
                         name: n.name,
                         endpoint: n.endpoint,
@@ -3677,8 +6440,16 @@ pub fn router() -> Router<Ctx> {
                         last_seen_at: n.last_seen_at.map(|t| t.to_rfc3339()),
                         agent_version: n.agent_version,
                         last_error: n.last_error,
-                    })
-                    .collect::<Vec<_>>())
+                        disabled_commands,
+                        node_id: conn
+                            .as_ref()
+                            .map(|c| c.node_id.clone())
+                            .filter(|id| !id.is_empty()),
+                        labels: conn.as_ref().map(|c| c.labels.clone()).unwrap_or_default(),
+                        public_address: conn.as_ref().and_then(|c| c.public_address.clone()),
+                    });
+                }
+                Ok(out)
             }),
         )
         .procedure(
@@ -3758,6 +6529,10 @@ pub fn router() -> Router<Ctx> {
                             last_seen_at: inserted.last_seen_at.map(|t| t.to_rfc3339()),
                             agent_version: inserted.agent_version,
                             last_error: inserted.last_error,
+                            disabled_commands: Vec::new(),
+                            node_id: None,
+                            labels: Default::default(),
+                            public_address: None,
                         },
                         connect_token: token,
                     })
@@ -3806,6 +6581,11 @@ pub fn router() -> Router<Ctx> {
                     )
                     .await;
 
+                    let conn = ctx.agent_hub.get(&updated.name).await;
+                    let disabled_commands = conn
+                        .as_ref()
+                        .map(|c| c.disabled_commands.clone())
+                        .unwrap_or_default();
                     Ok(NodeDto {
                         id: updated.id.to_string(),
                         name: updated.name,
@@ -3815,6 +6595,13 @@ pub fn router() -> Router<Ctx> {
                         last_seen_at: updated.last_seen_at.map(|t| t.to_rfc3339()),
                         agent_version: updated.agent_version,
                         last_error: updated.last_error,
+                        disabled_commands,
+                        node_id: conn
+                            .as_ref()
+                            .map(|c| c.node_id.clone())
+                            .filter(|id| !id.is_empty()),
+                        labels: conn.as_ref().map(|c| c.labels.clone()).unwrap_or_default(),
+                        public_address: conn.as_ref().and_then(|c| c.public_address.clone()),
                     })
                 },
             ),
@@ -4554,6 +7341,85 @@ pub fn router() -> Router<Ctx> {
             ),
         );
 
+    let session = Router::new()
+        .procedure(
+            "list",
+            Procedure::builder::<ApiError>().query(|ctx: Ctx, _: ()| async move {
+                let user = ctx
+                    .user
+                    .clone()
+                    .ok_or_else(|| api_error(&ctx, "unauthorized", "unauthorized"))?;
+                if !user.is_admin {
+                    return Err(api_error(&ctx, "forbidden", "forbidden"));
+                }
+
+                let sessions = ctx
+                    .panel_sessions
+                    .list()
+                    .into_iter()
+                    .map(|s| PanelSessionDto {
+                        session_id: s.session_id,
+                        user_id: s.user_id,
+                        username: s.username,
+                        remote_addr: s.remote_addr,
+                        user_agent: s.user_agent,
+                        connected_at_unix_ms: s.connected_at_unix_ms.to_string(),
+                        topics: s.topics,
+                    })
+                    .collect();
+                Ok(PanelSessionsOutput { sessions })
+            }),
+        )
+        .procedure(
+            "disconnect",
+            Procedure::builder::<ApiError>().mutation(
+                |ctx: Ctx, input: PanelSessionDisconnectInput| async move {
+                    // Closing a socket changes no persisted state, so this also works in read-only
+                    // mode.
+                    enforce_rate_limit(&ctx)?;
+
+                    let user = ctx
+                        .user
+                        .clone()
+                        .ok_or_else(|| api_error(&ctx, "unauthorized", "unauthorized"))?;
+                    if !user.is_admin {
+                        return Err(api_error(&ctx, "forbidden", "forbidden"));
+                    }
+
+                    let reason = input
+                        .reason
+                        .as_deref()
+                        .map(str::trim)
+                        .filter(|r| !r.is_empty())
+                        .unwrap_or("disconnected by an administrator");
+                    if reason.len() > 200 {
+                        return Err(api_error_with_field(
+                            &ctx,
+                            "invalid_param",
+                            "invalid reason",
+                            "reason",
+                            "reason must be at most 200 bytes",
+                        ));
+                    }
+
+                    let session_id = input.session_id.trim();
+                    if !ctx.panel_sessions.disconnect(session_id, reason) {
+                        return Err(api_error(&ctx, "not_found", "session not found"));
+                    }
+
+                    audit::record(
+                        &ctx,
+                        "session.disconnect",
+                        session_id,
+                        Some(serde_json::json!({ "reason": reason })),
+                    )
+                    .await;
+
+                    Ok(PanelSessionDisconnectOutput { disconnected: true })
+                },
+            ),
+        );
+
     Router::new()
         .nest("control", control)
         .nest("agent", agent)
@@ -4566,4 +7432,5 @@ pub fn router() -> Router<Ctx> {
         .nest("log", log)
         .nest("instance", instance)
         .nest("node", node)
+        .nest("session", session)
 }
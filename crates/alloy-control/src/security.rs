@@ -52,7 +52,7 @@ fn parse_allowed_origins() -> Vec<String> {
         .collect()
 }
 
-fn origin_is_allowed(headers: &HeaderMap) -> bool {
+pub fn origin_is_allowed(headers: &HeaderMap) -> bool {
     // Treat missing Origin as a non-browser client (curl, service-to-service).
     // For browsers, Origin should be present for unsafe methods.
     let origin = match headers.get(axum::http::header::ORIGIN) {
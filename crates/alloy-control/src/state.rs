@@ -6,4 +6,5 @@ use alloy_db::sea_orm::DatabaseConnection;
 pub struct AppState {
     pub db: Arc<DatabaseConnection>,
     pub agent_hub: crate::agent_tunnel::AgentHub,
+    pub panel_sessions: crate::topics::PanelSessions,
 }
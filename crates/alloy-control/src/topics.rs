@@ -0,0 +1,486 @@
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+
+use axum::{
+    extract::{
+        State,
+        ws::{Message, WebSocket, WebSocketUpgrade},
+    },
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use axum_extra::extract::cookie::CookieJar;
+use futures_util::{SinkExt, StreamExt};
+use tokio::sync::{mpsc, oneshot};
+use tracing::Instrument;
+
+use alloy_proto::agent_v1::{SendInputRequest, SendInputResponse};
+
+use crate::agent_transport::{AgentTransport, TopicSubscription};
+use crate::auth::{ACCESS_COOKIE_NAME, validate_access_jwt};
+use crate::state::AppState;
+
+// Per panel socket; each one maps to a subscription on the agent.
+const MAX_SUBSCRIPTIONS_PER_SOCKET: usize = 32;
+
+#[derive(Debug, Clone, serde::Deserialize)]
+#[serde(tag = "type")]
+enum PanelToControlFrame {
+    #[serde(rename = "sub")]
+    Sub {
+        id: String,
+        topic: String,
+        #[serde(default)]
+        filter: Option<String>,
+    },
+    #[serde(rename = "unsub")]
+    Unsub { id: String },
+    // One console line for an instance's stdin, attributed to this session's user.
+    #[serde(rename = "input")]
+    Input {
+        id: String,
+        process_id: String,
+        line: String,
+        // Resend of a line the agent refused with `confirm` (e.g. `stop`).
+        #[serde(default)]
+        confirmed: bool,
+    },
+    #[serde(other)]
+    Unknown,
+}
+
+#[derive(Debug, Clone, serde::Serialize)]
+#[serde(tag = "type")]
+enum ControlToPanelFrame {
+    // First frame on every socket.
+    #[serde(rename = "session")]
+    Session { session_id: String },
+    // Sent right before an admin closes the socket.
+    #[serde(rename = "disconnected")]
+    Disconnected { reason: String },
+    #[serde(rename = "subscribed")]
+    Subscribed { id: String },
+    #[serde(rename = "input_ack")]
+    InputAck { id: String },
+    #[serde(rename = "error")]
+    Error { id: String, message: String },
+    // The line starts with a guarded command; send it again with `confirmed: true` to run it.
+    #[serde(rename = "confirm")]
+    Confirm { id: String, message: String },
+    #[serde(rename = "event")]
+    Event {
+        id: String,
+        topic: String,
+        data: serde_json::Value,
+        dropped: u64,
+    },
+    // The agent went away; the panel should resubscribe once it is back.
+    #[serde(rename = "closed")]
+    Closed { id: String },
+}
+
+#[derive(Debug, Clone)]
+pub struct PanelSessionInfo {
+    pub session_id: String,
+    pub user_id: String,
+    pub username: String,
+    pub remote_addr: Option<String>,
+    pub user_agent: Option<String>,
+    pub connected_at_unix_ms: u64,
+    // Topics of the live subscriptions, one entry per subscription.
+    pub topics: Vec<String>,
+}
+
+struct PanelSession {
+    info: PanelSessionInfo,
+    subscriptions: HashMap<String, String>,
+    kick: Option<oneshot::Sender<String>>,
+}
+
+// Connected panel sockets. Each one is a session with its own subscriptions; admins can list them
+// and force one to disconnect.
+#[derive(Clone, Default)]
+pub struct PanelSessions {
+    inner: Arc<Mutex<HashMap<String, PanelSession>>>,
+}
+
+impl PanelSessions {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn register(&self, info: PanelSessionInfo) -> oneshot::Receiver<String> {
+        let (kick, kicked) = oneshot::channel();
+        let mut inner = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        inner.insert(
+            info.session_id.clone(),
+            PanelSession {
+                info,
+                subscriptions: HashMap::new(),
+                kick: Some(kick),
+            },
+        );
+        kicked
+    }
+
+    fn set_subscription(&self, session_id: &str, sub_id: &str, topic: Option<&str>) {
+        let mut inner = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        let Some(session) = inner.get_mut(session_id) else {
+            return;
+        };
+        match topic {
+            Some(topic) => {
+                session
+                    .subscriptions
+                    .insert(sub_id.to_string(), topic.to_string());
+            }
+            None => {
+                session.subscriptions.remove(sub_id);
+            }
+        }
+    }
+
+    fn remove(&self, session_id: &str) {
+        let mut inner = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        inner.remove(session_id);
+    }
+
+    // Oldest first.
+    pub fn list(&self) -> Vec<PanelSessionInfo> {
+        let inner = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        let mut out: Vec<PanelSessionInfo> = inner
+            .values()
+            .map(|s| {
+                let mut topics: Vec<String> = s.subscriptions.values().cloned().collect();
+                topics.sort();
+                PanelSessionInfo {
+                    topics,
+                    ..s.info.clone()
+                }
+            })
+            .collect();
+        out.sort_by(|a, b| {
+            a.connected_at_unix_ms
+                .cmp(&b.connected_at_unix_ms)
+                .then_with(|| a.session_id.cmp(&b.session_id))
+        });
+        out
+    }
+
+    // Returns false when no such session is connected.
+    pub fn disconnect(&self, session_id: &str, reason: &str) -> bool {
+        let mut inner = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        let Some(kick) = inner.get_mut(session_id).and_then(|s| s.kick.take()) else {
+            return false;
+        };
+        let _ = kick.send(reason.to_string());
+        true
+    }
+}
+
+fn header_value(headers: &HeaderMap, name: &str) -> Option<String> {
+    headers
+        .get(name)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+}
+
+fn new_session_id() -> String {
+    use rand::RngCore;
+
+    let mut bytes = [0u8; 8];
+    rand::rngs::OsRng.fill_bytes(&mut bytes);
+    hex::encode(bytes)
+}
+
+fn frame_message(frame: &ControlToPanelFrame) -> Option<Message> {
+    serde_json::to_string(frame).ok().map(Message::Text)
+}
+
+// Live topic streams for the web panel (console:<instance>, metrics:<instance>, events:<kind>).
+//
+// Every browser tab opens its own socket and the agent fans topics out to each subscriber, so
+// several panel sessions can watch the same instance without stealing each other's lines.
+pub async fn panel_ws(
+    State(state): State<AppState>,
+    ws: WebSocketUpgrade,
+    headers: HeaderMap,
+    jar: CookieJar,
+) -> Response {
+    // Browsers don't apply CORS to WebSockets, so check the Origin like state-changing routes do.
+    if !crate::security::origin_is_allowed(&headers) {
+        return (StatusCode::FORBIDDEN, "origin not allowed").into_response();
+    }
+    let Some(token) = jar.get(ACCESS_COOKIE_NAME) else {
+        return (StatusCode::UNAUTHORIZED, "missing access token").into_response();
+    };
+    let user = match validate_access_jwt(token.value()) {
+        Ok(u) => u,
+        Err(_) => return (StatusCode::UNAUTHORIZED, "invalid access token").into_response(),
+    };
+
+    let info = PanelSessionInfo {
+        session_id: new_session_id(),
+        user_id: user.user_id,
+        username: user.username,
+        // Behind the bundled nginx, the peer address is the proxy; use what it forwarded.
+        remote_addr: header_value(&headers, "x-forwarded-for")
+            .and_then(|v| v.split(',').next().map(|s| s.trim().to_string()))
+            .or_else(|| header_value(&headers, "x-real-ip")),
+        user_agent: header_value(&headers, "user-agent"),
+        connected_at_unix_ms: std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .map(|d| d.as_millis() as u64)
+            .unwrap_or(0),
+        topics: Vec::new(),
+    };
+
+    ws.on_upgrade(move |socket| handle_panel_socket(state, socket, info))
+        .into_response()
+}
+
+fn spawn_forwarder(
+    id: String,
+    mut sub: TopicSubscription,
+    out_tx: mpsc::Sender<Message>,
+) -> tokio::task::JoinHandle<()> {
+    tokio::spawn(async move {
+        while let Some(ev) = sub.recv().await {
+            let frame = ControlToPanelFrame::Event {
+                id: id.clone(),
+                topic: ev.topic,
+                data: ev.data,
+                dropped: ev.dropped,
+            };
+            let Some(msg) = frame_message(&frame) else {
+                continue;
+            };
+            // A slow browser stalls only this stream; its queue then drops and counts events.
+            if out_tx.send(msg).await.is_err() {
+                return;
+            }
+        }
+        if let Some(msg) = frame_message(&ControlToPanelFrame::Closed { id }) {
+            let _ = out_tx.send(msg).await;
+        }
+    })
+}
+
+enum InputError {
+    Confirm(String),
+    Failed(String),
+}
+
+// The agent writes the line and keeps its own record; the audit entry here ties it to the panel
+// user and session.
+async fn send_input(
+    state: &AppState,
+    transport: &AgentTransport,
+    session_id: &str,
+    user_id: &str,
+    username: &str,
+    process_id: String,
+    line: String,
+    confirmed: bool,
+) -> Result<(), InputError> {
+    if crate::rpc::is_read_only() {
+        return Err(InputError::Failed(
+            "control is in read-only mode".to_string(),
+        ));
+    }
+    if !crate::rpc::allow_user_write(user_id) {
+        return Err(InputError::Failed("too many requests".to_string()));
+    }
+    let process_id = process_id.trim().to_string();
+    if process_id.is_empty() {
+        return Err(InputError::Failed("process_id is required".to_string()));
+    }
+
+    let res = transport
+        .call::<SendInputRequest, SendInputResponse>(
+            "/alloy.agent.v1.ProcessService/SendInput",
+            SendInputRequest {
+                process_id: process_id.clone(),
+                line: line.clone(),
+                actor: format!("panel:{username}"),
+                session_id: session_id.to_string(),
+                confirmed,
+            },
+        )
+        .await;
+
+    crate::audit::record_as(
+        &state.db,
+        &format!("ws:{session_id}"),
+        Some(user_id),
+        "console.input",
+        &process_id,
+        Some(serde_json::json!({
+            "session_id": session_id,
+            "line": line,
+            "ok": res.is_ok(),
+            "confirmed": confirmed,
+        })),
+    )
+    .await;
+
+    res.map(|_| ()).map_err(|status| {
+        match crate::rpc::parse_agent_error_payload(status.message()) {
+            Some(p) if p.code == "confirmation_required" => InputError::Confirm(p.message),
+            Some(p) => InputError::Failed(p.message),
+            None => InputError::Failed(status.message().to_string()),
+        }
+    })
+}
+
+async fn handle_panel_socket(state: AppState, socket: WebSocket, info: PanelSessionInfo) {
+    let span = tracing::info_span!(
+        "panel_ws",
+        session = %info.session_id,
+        user = %info.username
+    );
+    async move {
+        let session_id = info.session_id.clone();
+        let user_id = info.user_id.clone();
+        let username = info.username.clone();
+        let sessions = state.panel_sessions.clone();
+        let mut kicked = sessions.register(info);
+
+        let (mut sender, mut receiver) = socket.split();
+        let (out_tx, mut out_rx) = mpsc::channel::<Message>(64);
+        let mut writer = tokio::spawn(async move {
+            while let Some(msg) = out_rx.recv().await {
+                if sender.send(msg).await.is_err() {
+                    break;
+                }
+            }
+        });
+
+        let hello = ControlToPanelFrame::Session {
+            session_id: session_id.clone(),
+        };
+        if let Some(msg) = frame_message(&hello) {
+            let _ = out_tx.send(msg).await;
+        }
+
+        let transport = AgentTransport::new(state.agent_hub.clone());
+        let mut subscriptions: HashMap<String, tokio::task::JoinHandle<()>> = HashMap::new();
+
+        loop {
+            let msg = tokio::select! {
+                reason = &mut kicked => {
+                    let reason = reason.unwrap_or_default();
+                    tracing::info!(%reason, "panel session disconnected by admin");
+                    if let Some(msg) = frame_message(&ControlToPanelFrame::Disconnected { reason }) {
+                        let _ = out_tx.send(msg).await;
+                    }
+                    let _ = out_tx.send(Message::Close(None)).await;
+                    break;
+                }
+                msg = receiver.next() => match msg {
+                    Some(Ok(msg)) => msg,
+                    _ => break,
+                },
+            };
+            let text = match msg {
+                Message::Text(text) => text,
+                Message::Close(_) => break,
+                _ => continue,
+            };
+            let frame = serde_json::from_str::<PanelToControlFrame>(&text)
+                .unwrap_or(PanelToControlFrame::Unknown);
+            match frame {
+                PanelToControlFrame::Sub { id, topic, filter } => {
+                    if !subscriptions.contains_key(&id)
+                        && subscriptions.len() >= MAX_SUBSCRIPTIONS_PER_SOCKET
+                    {
+                        let frame = ControlToPanelFrame::Error {
+                            id,
+                            message: format!(
+                                "too many subscriptions (max {MAX_SUBSCRIPTIONS_PER_SOCKET})"
+                            ),
+                        };
+                        if let Some(msg) = frame_message(&frame) {
+                            let _ = out_tx.send(msg).await;
+                        }
+                        continue;
+                    }
+
+                    let reply = match transport.subscribe(&topic, filter.as_deref()).await {
+                        Ok(sub) => {
+                            let task = spawn_forwarder(id.clone(), sub, out_tx.clone());
+                            if let Some(old) = subscriptions.insert(id.clone(), task) {
+                                old.abort();
+                            }
+                            sessions.set_subscription(&session_id, &id, Some(&topic));
+                            ControlToPanelFrame::Subscribed { id }
+                        }
+                        Err(status) => ControlToPanelFrame::Error {
+                            id,
+                            message: status.message().to_string(),
+                        },
+                    };
+                    if let Some(msg) = frame_message(&reply) {
+                        let _ = out_tx.send(msg).await;
+                    }
+                }
+                PanelToControlFrame::Unsub { id } => {
+                    if let Some(task) = subscriptions.remove(&id) {
+                        task.abort();
+                    }
+                    sessions.set_subscription(&session_id, &id, None);
+                }
+                PanelToControlFrame::Input {
+                    id,
+                    process_id,
+                    line,
+                    confirmed,
+                } => {
+                    let reply = match send_input(
+                        &state,
+                        &transport,
+                        &session_id,
+                        &user_id,
+                        &username,
+                        process_id,
+                        line,
+                        confirmed,
+                    )
+                    .await
+                    {
+                        Ok(()) => ControlToPanelFrame::InputAck { id },
+                        Err(InputError::Confirm(message)) => {
+                            ControlToPanelFrame::Confirm { id, message }
+                        }
+                        Err(InputError::Failed(message)) => {
+                            ControlToPanelFrame::Error { id, message }
+                        }
+                    };
+                    if let Some(msg) = frame_message(&reply) {
+                        let _ = out_tx.send(msg).await;
+                    }
+                }
+                PanelToControlFrame::Unknown => {}
+            }
+        }
+
+        sessions.remove(&session_id);
+        for task in subscriptions.into_values() {
+            task.abort();
+        }
+        // Let the writer flush the final frames (e.g. the disconnect notice) before closing.
+        drop(out_tx);
+        if tokio::time::timeout(Duration::from_secs(2), &mut writer)
+            .await
+            .is_err()
+        {
+            writer.abort();
+        }
+    }
+    .instrument(span)
+    .await
+}
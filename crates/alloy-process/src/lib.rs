@@ -50,6 +50,9 @@ pub struct ProcessStatus {
     pub exit_code: Option<i32>,
     pub message: Option<String>,
     pub resources: Option<ProcessResources>,
+    // Classification of the last exit (e.g. "oom", "port_in_use") and a human-readable reason.
+    pub exit_category: Option<String>,
+    pub exit_reason: Option<String>,
 }
 
 #[cfg(test)]
@@ -1425,8 +1425,10 @@ function App() {
       ]
     }
 
+    // Mojang unreachable: control served its last list, which may miss new versions.
+    const stale = data.is_stale ? ' · cached' : ''
     const out: { value: string; label: string; meta?: string }[] = [
-      { value: 'latest_release', label: `Latest release (${data.latest_release})`, meta: 'recommended' },
+      { value: 'latest_release', label: `Latest release (${data.latest_release})${stale}`, meta: 'recommended' },
       { value: 'latest_snapshot', label: `Latest snapshot (${data.latest_snapshot})`, meta: 'unstable' },
     ]
 
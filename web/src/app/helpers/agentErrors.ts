@@ -2,11 +2,33 @@ import type { ProcessStatusDto } from '../../bindings'
 
 const AGENT_ERROR_PREFIX = 'ALLOY_ERROR_JSON:'
 
+// Stable message id plus parameters, for translating daemon messages. The English text next to it
+// is the fallback.
+export type MessageKey = {
+  key: string
+  params: Record<string, string>
+}
+
+export function parseMessageKey(value: unknown): MessageKey | null {
+  if (!value || typeof value !== 'object') return null
+  const v = value as Record<string, unknown>
+  if (typeof v.key !== 'string' || !v.key) return null
+  const params: Record<string, string> = {}
+  if (v.params && typeof v.params === 'object') {
+    for (const [k, p] of Object.entries(v.params as Record<string, unknown>)) {
+      if (typeof p === 'string') params[k] = p
+    }
+  }
+  return { key: v.key, params }
+}
+
 export type AgentErrorPayload = {
   code: string
   message: string
+  message_key?: MessageKey | null
   field_errors?: Record<string, string> | null
   hint?: string | null
+  hint_key?: MessageKey | null
 }
 
 export function parseAgentErrorPayload(raw: string | null | undefined): AgentErrorPayload | null {
@@ -21,8 +43,10 @@ export function parseAgentErrorPayload(raw: string | null | undefined): AgentErr
     return {
       code: p.code,
       message: p.message,
+      message_key: parseMessageKey(p.message_key),
       field_errors: typeof p.field_errors === 'object' ? (p.field_errors as Record<string, string>) : null,
       hint: typeof p.hint === 'string' ? p.hint : null,
+      hint_key: parseMessageKey(p.hint_key),
     }
   } catch {
     return null
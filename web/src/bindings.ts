@@ -2,33 +2,87 @@
 
 // This file was generated by [rspc](https://github.com/specta-rs/rspc). Do not edit this file manually.
 
-export type AgentHealthFullDto = { endpoint: string; ok: boolean; status: string | null; agent_version: string | null; data_root: string | null; data_root_writable: boolean | null; data_root_free_bytes: string | null; ports: PortAvailabilityDto[] | null; error: string | null }
+export type AgentHealthFullDto = { endpoint: string; ok: boolean; status: string | null; agent_version: string | null; data_root: string | null; data_root_writable: boolean | null; data_root_free_bytes: string | null; ports: PortAvailabilityDto[] | null; frp: FrpSummaryDto | null; dir_cache: DirCacheStatsDto | null; clock: ClockStatusDto | null; mirrors: MirrorStatusDto[] | null; error: string | null }
+
+export type BackupScheduleDto = { instance_id: string; interval_minutes: string; keep: number; global: boolean; next_due_unix_ms: string; last_run_unix_ms: string | null; last_result: string | null }
+
+export type BackupScheduleErrorDto = { source: string; message: string }
+
+export type BlockCountDto = { id: string; count: string }
+
+export type BlockPositionDto = { dimension: string; x: number; y: number; z: number }
 
 export type CacheEntryDto = { key: string; path: string; size_bytes: string; last_used_unix_ms: string }
 
 export type CacheStatsOutput = { entries: CacheEntryDto[] }
 
+export type ClockStatusDto = { unix_ms: string; timezone: string; utc_offset_seconds: number; ntp_server: string | null; ntp_offset_ms: string | null; ntp_checked_at_unix_ms: string | null; ntp_error: string | null; skew_vs_control_ms: string }
+
+export type ConfigKeyDto = { file: string; key: string; kind: string; min: number | null; max: number | null; default_value: string; description: string; perf: boolean; value: string | null; problem: string | null }
+
+export type DirCacheStatsDto = { hits: string; misses: string; bypassed: string; dirs: string }
+
 export type DirEntryDto = { name: string; is_dir: boolean; size_bytes: number; modified_unix_ms: string }
 
 export type DownloadQueueJobDto = { id: string; target: string; template_id: string; version: string; params: Partial<{ [key in string]: string }>; state: string; message: string; request_id: string | null; queue_position: string; attempt_count: number; created_at_unix_ms: string; started_at_unix_ms: string | null; updated_at_unix_ms: string; finished_at_unix_ms: string | null; progress_stage: string | null; progress_downloaded_bytes: string | null; progress_total_bytes: string | null; progress_speed_bytes_per_sec: string | null; progress_percent_x100: number | null; progress_eta_sec: number | null }
 
-export type FsCapabilitiesOutput = { write_enabled: boolean }
+export type EnvFileDto = { path: string; size_bytes: string; sha256: string }
+
+export type EnvReportDto = { generated_at_unix_ms: string; template_id: string; node_id: string; agent_version: string; java_version: string | null; jvm_flags: string[]; server_jar: EnvFileDto | null; jar_provider: string | null; jar_version: string | null; mods: EnvFileDto[]; config: Partial<{ [key in string]: string }>; params: Partial<{ [key in string]: string }> }
+
+export type FileHashDto = { path: string; size_bytes: string; hash: string; error: string | null }
+
+export type FrpFailoverEventDto = { at_unix_ms: string; from: string; to: string; reason: string }
+
+export type FrpProxySecurityDto = { name: string; use_encryption: boolean; use_compression: boolean }
+
+export type FrpProxyStatsDto = { name: string; proxy_type: string | null; state: string | null; remote_addr: string | null; error: string | null; cur_conns: string | null; traffic_in_bytes: string | null; traffic_out_bytes: string | null; last_heartbeat_unix_ms: string | null }
+
+export type FrpProxyStatusDto = { configured_name: string; name: string; collisions: number }
+
+export type FrpSecurityPostureDto = { tls_enable: boolean | null; level: string; proxies: FrpProxySecurityDto[]; warnings: string[] }
 
-export type InstanceConfigDto = { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }
+export type FrpSummaryDto = { tunnels: number; tunnels_running: number; proxies: number; proxies_running: number; cur_conns: string; traffic_in_bytes: string; traffic_out_bytes: string }
+
+export type FsCapabilitiesOutput = { write_enabled: boolean; disabled_commands: string[]; backup_protect_hours: number }
+
+export type InstanceConfigDto = { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null }
+
+export type InventoryItemDto = { slot: string; id: string; count: number; damage: number; custom_name: string | null; enchantments: string[] }
+
+export type ManagedFileDto = { path: string; policy: string; sha256: string; state: string; actual_sha256: string | null }
 
 export type MinecraftVersionRef = { id: string; kind: string; release_time: string }
 
-export type NodeDto = { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null }
+export type MirrorStatusDto = { provider: string; base: string; position: number; healthy: boolean; successes: string; failures: string; last_error: string | null; last_ok_unix_ms: string | null; last_error_unix_ms: string | null }
+
+export type NodeDto = { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null; disabled_commands: string[]; node_id: string | null; labels: Partial<{ [key in string]: string }>; public_address: string | null }
+
+export type PanelSessionDto = { session_id: string; user_id: string; username: string; remote_addr: string | null; user_agent: string | null; connected_at_unix_ms: string; topics: string[] }
 
 export type ParamTypeDto = "String" | "Int" | "Bool"
 
+export type PathStatDto = { path: string; exists: boolean; is_dir: boolean; size_bytes: number; modified_unix_ms: string; error: string | null }
+
+export type PerfFindingDto = { file: string; key: string; value: string; is_default: boolean; suggested: string; severity: string; message: string }
+
+export type PlayerStatsDto = { uuid: string | null; name: string | null; play_time_sec: string; deaths: string; mob_kills: string; player_kills: string; jumps: string; distance_m: string; blocks_mined: string; items_crafted: string; top_mined: BlockCountDto[]; advancements_done: number; error: string | null }
+
 export type PortAvailabilityDto = { port: number; available: boolean; error: string | null }
 
-export type ProceduresLegacy = { queries: { key: "agent.health"; input: null; result: { status: string; agent_version: string } } | { key: "control.diagnostics"; input: null; result: { fetched_at_unix_ms: string; request_id: string; control_version: string; read_only: boolean; agent: AgentHealthFullDto; fs: FsCapabilitiesOutput; cache: CacheStatsOutput; agent_log_path: string | null; agent_log_lines: string[] } } | { key: "control.ping"; input: null; result: { status: string; version: string } } | { key: "frp.list"; input: null; result: ({ id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string })[] } | { key: "fs.capabilities"; input: null; result: { write_enabled: boolean } } | { key: "fs.listDir"; input: { path: string | null }; result: { entries: DirEntryDto[] } } | { key: "fs.readFile"; input: { path: string; offset: number | null; limit: number | null }; result: { text: string; size_bytes: number } } | { key: "instance.deletePreview"; input: { instance_id: string }; result: { instance_id: string; path: string; size_bytes: string } } | { key: "instance.get"; input: { instance_id: string }; result: { config: InstanceConfigDto; status: ProcessStatusDto | null } } | { key: "instance.list"; input: null; result: ({ config: InstanceConfigDto; status: ProcessStatusDto | null })[] } | { key: "log.tailFile"; input: { path: string; cursor: string | null; limit_bytes: number | null; max_lines: number | null }; result: { lines: string[]; next_cursor: string } } | { key: "minecraft.versions"; input: null; result: { latest_release: string; latest_snapshot: string; versions: MinecraftVersionRef[] } } | { key: "node.list"; input: null; result: ({ id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null })[] } | { key: "process.cacheStats"; input: null; result: { entries: CacheEntryDto[] } } | { key: "process.downloadQueue"; input: null; result: { queue_paused: boolean; jobs: DownloadQueueJobDto[] } } | { key: "process.list"; input: null; result: ({ process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null })[] } | { key: "process.logsTail"; input: { process_id: string; cursor: string | null; limit: number | null }; result: { lines: string[]; next_cursor: string } } | { key: "process.status"; input: { process_id: string }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "process.templates"; input: null; result: { template_id: string; display_name: string; params: TemplateParamDto[] }[] } | { key: "settings.status"; input: null; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "update.check"; input: null; result: { current_version: string; latest: UpdateLatestReleaseDto | null; update_available: boolean; can_trigger_update: boolean } }; mutations: { key: "frp.create"; input: { name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string }; result: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string } } | { key: "frp.delete"; input: { id: string }; result: { ok: boolean } } | { key: "frp.update"; input: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string }; result: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string } } | { key: "instance.create"; input: { template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }; result: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null } } | { key: "instance.delete"; input: { instance_id: string }; result: { ok: boolean } } | { key: "instance.diagnostics"; input: { instance_id: string; max_lines: number | null; limit_bytes: number | null }; result: { instance_id: string; fetched_at_unix_ms: string; request_id: string; instance_json: string | null; run_json: string | null; console_log_lines: string[] } } | { key: "instance.importSaveFromUrl"; input: { instance_id: string; url: string }; result: { ok: boolean; message: string; installed_path: string; backup_path: string } } | { key: "instance.restart"; input: { instance_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "instance.start"; input: { instance_id: string }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "instance.stop"; input: { instance_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "instance.update"; input: { instance_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }; result: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null } } | { key: "node.create"; input: { name: string }; result: { node: NodeDto; connect_token: string } } | { key: "node.setEnabled"; input: { node_id: string; enabled: boolean }; result: { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null } } | { key: "process.clearCache"; input: { keys: string[] }; result: { ok: boolean; freed_bytes: string; cleared: CacheEntryDto[] } } | { key: "process.downloadQueueCancelJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueClearHistory"; input: null; result: { ok: boolean } } | { key: "process.downloadQueueEnqueue"; input: { target: string; template_id: string; version: string; params: Partial<{ [key in string]: string }> }; result: { ok: boolean } } | { key: "process.downloadQueueMove"; input: { job_id: string; direction: number }; result: { ok: boolean } } | { key: "process.downloadQueuePauseJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueResumeJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueRetryJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueSetPaused"; input: { paused: boolean }; result: { ok: boolean } } | { key: "process.start"; input: { template_id: string; params: Partial<{ [key in string]: string }> }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "process.stop"; input: { process_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null } } | { key: "process.warmCache"; input: { template_id: string; params: Partial<{ [key in string]: string }> }; result: { ok: boolean; message: string } } | { key: "settings.setCurseforgeApiKey"; input: { key: string }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "settings.setDstDefaultKleiKey"; input: { key: string }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "settings.setSteamcmdCredentials"; input: { username: string; password: string; steam_guard_code: string | null; shared_secret: string | null; mafile_json: string | null }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "update.trigger"; input: null; result: { ok: boolean; message: string } }; subscriptions: never }
+export type ProceduresLegacy = { queries: { key: "agent.health"; input: null; result: { status: string; agent_version: string } } | { key: "agent.nodeInfo"; input: null; result: { node_id: string; name: string; labels: Partial<{ [key in string]: string }>; public_address: string | null; agent_version: string; os: string; arch: string; cpus: number; data_root: string; checkpoint_supported: boolean; checkpoint_unsupported_reason: string | null } } | { key: "agent.placement"; input: { memory_mb: number | null; disk_mb: number | null; cpu_millicores: number | null }; result: { fits: boolean; score: number; reasons: string[]; memory_budget_mb: number; memory_available_mb: number; memory_committed_mb: number; cpu_millicores: number; cpu_committed_millicores: number; disk_usable_mb: number; instances: number } } | { key: "control.diagnostics"; input: null; result: { fetched_at_unix_ms: string; request_id: string; control_version: string; read_only: boolean; agent: AgentHealthFullDto; fs: FsCapabilitiesOutput; cache: CacheStatsOutput; agent_log_path: string | null; agent_log_lines: string[] } } | { key: "control.ping"; input: null; result: { status: string; version: string } } | { key: "frp.list"; input: null; result: ({ id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string })[] } | { key: "fs.capabilities"; input: null; result: { write_enabled: boolean; disabled_commands: string[]; backup_protect_hours: number } } | { key: "fs.hash"; input: { path: string; algorithm: string | null; quick: boolean | null; quick_bytes: number | null; workers: number | null }; result: { algorithm: string; quick: boolean; files: FileHashDto[] } } | { key: "fs.listDir"; input: { path: string | null; no_cache: boolean | null }; result: { entries: DirEntryDto[] } } | { key: "fs.readFile"; input: { path: string; offset: number | null; limit: number | null }; result: { text: string; size_bytes: number } } | { key: "fs.search"; input: { path: string; query: string; case_insensitive: boolean | null; max_matches: number | null; export: string | null }; result: { matches: SearchMatchDto[]; total_matches: number; truncated: boolean; files_scanned: number; files_skipped: number; export_path: string | null; export_truncated: boolean } } | { key: "fs.statBatch"; input: { paths: string[] }; result: { results: PathStatDto[] } } | { key: "instance.configSchema"; input: { instance_id: string; file: string | null }; result: { keys: ConfigKeyDto[] } } | { key: "instance.deletePreview"; input: { instance_id: string }; result: { instance_id: string; path: string; size_bytes: string } } | { key: "instance.divergence"; input: { instance_id: string }; result: { golden_instance_id: string; shared_bytes: string; diverged_bytes: string; diverged_files: number; removed_files: number } } | { key: "instance.envReport"; input: { instance_id: string; backup_path: string | null }; result: { current: EnvReportDto | null; backup: EnvReportDto | null; differences: string[] } } | { key: "instance.frpStats"; input: { instance_id: string }; result: { configured: boolean; proxies: FrpProxyStatsDto[]; sampled_at_unix_ms: string | null } } | { key: "instance.frpStatus"; input: { instance_id: string }; result: { configured: boolean; state: string; proxies: FrpProxyStatusDto[]; last_error: string | null; updated_at_unix_ms: string; endpoints: string[]; active_endpoint: string | null; failovers: FrpFailoverEventDto[]; security: FrpSecurityPostureDto | null } } | { key: "instance.get"; input: { instance_id: string }; result: { config: InstanceConfigDto; status: ProcessStatusDto | null } } | { key: "instance.lastCrash"; input: { instance_id: string }; result: { process_id: string; template_id: string; exited_at_unix_ms: string; runtime_ms: string; exit_code: number | null; signal: number | null; exit_category: string | null; exit_reason: string | null; hs_err_path: string | null; lines: string[]; path: string; start_request_id: string | null } | null } | { key: "instance.list"; input: null; result: ({ config: InstanceConfigDto; status: ProcessStatusDto | null })[] } | { key: "instance.managedFiles"; input: { instance_id: string }; result: { files: ManagedFileDto[] } } | { key: "instance.perfAudit"; input: { instance_id: string }; result: { files: string[]; findings: PerfFindingDto[] } } | { key: "instance.playerInventory"; input: { instance_id: string; player: string; backup_path: string | null }; result: { uuid: string; name: string | null; backup_path: string | null; items: InventoryItemDto[]; ender_items: InventoryItemDto[]; xp_level: number } } | { key: "instance.playerPositions"; input: { instance_id: string; player: string | null }; result: ({ uuid: string; name: string | null; dimension: string; x: number; y: number; z: number; yaw: number; pitch: number; health: number; xp_level: number; game_mode: string | null; last_death: BlockPositionDto | null; respawn: BlockPositionDto | null; saved_at_unix_ms: string; error: string | null })[] } | { key: "instance.playerStats"; input: { instance_id: string; player: string | null; top_blocks: number | null }; result: { players: PlayerStatsDto[]; totals: PlayerStatsDto } } | { key: "instance.propertyProfiles"; input: null; result: ({ name: string; description: string | null; builtin: boolean; values: PropertyValueDto[] })[] } | { key: "instance.verifyJar"; input: { instance_id: string }; result: { state: string; provider: string | null; source_url: string | null; version: string | null; expected_sha1: string | null; expected_size: string | null; actual_sha1: string | null; actual_size: string; recorded_at_unix_ms: string | null } } | { key: "instance.webMapStatus"; input: { instance_id: string }; result: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean } } | { key: "log.tailFile"; input: { path: string; cursor: string | null; limit_bytes: number | null; max_lines: number | null }; result: { lines: string[]; next_cursor: string } } | { key: "minecraft.versions"; input: null; result: { latest_release: string; latest_snapshot: string; versions: MinecraftVersionRef[]; is_stale: boolean; fetched_at: string } } | { key: "node.list"; input: null; result: ({ id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null; disabled_commands: string[]; node_id: string | null; labels: Partial<{ [key in string]: string }>; public_address: string | null })[] } | { key: "process.cacheStats"; input: null; result: { entries: CacheEntryDto[] } } | { key: "process.downloadQueue"; input: null; result: { queue_paused: boolean; jobs: DownloadQueueJobDto[] } } | { key: "process.list"; input: null; result: ({ process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null })[] } | { key: "process.logsTail"; input: { process_id: string; cursor: string | null; limit: number | null }; result: { lines: string[]; next_cursor: string } } | { key: "process.status"; input: { process_id: string }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "process.templates"; input: null; result: { template_id: string; display_name: string; params: TemplateParamDto[] }[] } | { key: "session.list"; input: null; result: { sessions: PanelSessionDto[] } } | { key: "settings.status"; input: null; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "update.check"; input: null; result: { current_version: string; latest: UpdateLatestReleaseDto | null; update_available: boolean; can_trigger_update: boolean } }; mutations: { key: "agent.scheduleReload"; input: null; result: { poll_every_sec: string; schedules: BackupScheduleDto[]; errors: BackupScheduleErrorDto[]; changed: string[] } } | { key: "agent.selftest"; input: { skip_network: boolean | null; port_start: number | null }; result: { ok: boolean; checks: SelfTestCheckDto[]; duration_ms: string } } | { key: "agent.setLogLevel"; input: { filter: string | null }; result: { filter: string; previous: string | null } } | { key: "agent.supportBundle"; input: { instance_ids: string[] | null; console_lines: number | null; keep_ip_addresses: boolean | null }; result: { path: string; size_bytes: string; files: string[]; redactions: number; data_base64: string | null } } | { key: "frp.create"; input: { name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string }; result: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string } } | { key: "frp.delete"; input: { id: string }; result: { ok: boolean } } | { key: "frp.update"; input: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string }; result: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string } } | { key: "fs.searchExport"; input: { path: string; query: string; case_insensitive: boolean | null; max_matches: number | null; format: string }; result: { matches: SearchMatchDto[]; total_matches: number; truncated: boolean; files_scanned: number; files_skipped: number; export_path: string | null; export_truncated: boolean } } | { key: "instance.acceptJar"; input: { instance_id: string }; result: { state: string; provider: string | null; source_url: string | null; version: string | null; expected_sha1: string | null; expected_size: string | null; actual_sha1: string | null; actual_size: string; recorded_at_unix_ms: string | null } } | { key: "instance.applyPropertyProfiles"; input: { instance_id: string; profiles: string[]; dry_run: boolean | null }; result: { changes: PropertyChangeDto[]; applied: boolean; restart_required: boolean } } | { key: "instance.create"; input: { template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }; result: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null } } | { key: "instance.createFromGolden"; input: { golden_instance_id: string; display_name: string | null }; result: { config: InstanceConfigDto; reflinked_files: number; linked_files: number; copied_files: number; size_bytes: string; elapsed_ms: string } } | { key: "instance.delete"; input: { instance_id: string }; result: { ok: boolean } } | { key: "instance.deletePropertyProfile"; input: { name: string }; result: { deleted: boolean } } | { key: "instance.diagnostics"; input: { instance_id: string; max_lines: number | null; limit_bytes: number | null }; result: { instance_id: string; fetched_at_unix_ms: string; request_id: string; instance_json: string | null; run_json: string | null; console_log_lines: string[] } } | { key: "instance.exposeWebMap"; input: { instance_id: string; domain: string | null; public_port: number | null; remove: boolean | null }; result: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean } } | { key: "instance.frpAdmin"; input: { instance_id: string; action: string; frp_config: string | null }; result: { output: string } } | { key: "instance.hibernate"; input: { instance_id: string; timeout_ms: number | null }; result: { status: ProcessStatusDto; checkpointed: boolean; fallback_reason: string | null; checkpoint_bytes: string; elapsed_ms: string } } | { key: "instance.importSaveFromUrl"; input: { instance_id: string; url: string }; result: { ok: boolean; message: string; installed_path: string; backup_path: string } } | { key: "instance.installWebMap"; input: { instance_id: string; kind: string; port: number | null; minecraft_version: string | null; loader: string | null }; result: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean } } | { key: "instance.manageFile"; input: { instance_id: string; path: string; policy: string | null; content: string | null }; result: { path: string; policy: string; sha256: string; state: string; actual_sha256: string | null } } | { key: "instance.mapPreview"; input: { instance_id: string; dimension: string | null; zoom: number | null; min_x: number | null; min_z: number | null; max_x: number | null; max_z: number | null }; result: { path: string; png_base64: string; width: number; height: number; zoom: number; min_x: number; min_z: number; max_x: number; max_z: number; chunks_rendered: number; chunks_flat: number; dimension: string; seed: string | null } } | { key: "instance.restart"; input: { instance_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "instance.restorePlayerData"; input: { instance_id: string; player: string; backup_path: string }; result: { uuid: string; previous_path: string | null; items: number; ender_items: number } } | { key: "instance.savePropertyProfile"; input: { name: string; description: string | null; values: PropertyValueDto[] }; result: { name: string; description: string | null; builtin: boolean; values: PropertyValueDto[] } } | { key: "instance.setGolden"; input: { instance_id: string; golden: boolean }; result: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null } } | { key: "instance.start"; input: { instance_id: string }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "instance.stop"; input: { instance_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "instance.unmanageFile"; input: { instance_id: string; path: string }; result: { removed: boolean } } | { key: "instance.update"; input: { instance_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }; result: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null } } | { key: "instance.validateStart"; input: { instance_id: string }; result: { ok: boolean; verdict: string; message: string; jar_path: string | null; main_class: string | null; exit_code: number | null; timed_out: boolean; network_isolated: boolean; duration_ms: string; output: string[] } } | { key: "log.paste"; input: { path: string; filter: string | null; max_lines: number | null }; result: { url: string; raw_url: string | null; service: string; lines: number; bytes: string; redactions: number; truncated: boolean } } | { key: "node.create"; input: { name: string }; result: { node: NodeDto; connect_token: string } } | { key: "node.setEnabled"; input: { node_id: string; enabled: boolean }; result: { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null; disabled_commands: string[]; node_id: string | null; labels: Partial<{ [key in string]: string }>; public_address: string | null } } | { key: "process.clearCache"; input: { keys: string[] }; result: { ok: boolean; freed_bytes: string; cleared: CacheEntryDto[] } } | { key: "process.downloadQueueCancelJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueClearHistory"; input: null; result: { ok: boolean } } | { key: "process.downloadQueueEnqueue"; input: { target: string; template_id: string; version: string; params: Partial<{ [key in string]: string }> }; result: { ok: boolean } } | { key: "process.downloadQueueMove"; input: { job_id: string; direction: number }; result: { ok: boolean } } | { key: "process.downloadQueuePauseJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueResumeJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueRetryJob"; input: { job_id: string }; result: { ok: boolean } } | { key: "process.downloadQueueSetPaused"; input: { paused: boolean }; result: { ok: boolean } } | { key: "process.start"; input: { template_id: string; params: Partial<{ [key in string]: string }> }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "process.stop"; input: { process_id: string; timeout_ms: number | null }; result: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null } } | { key: "process.warmCache"; input: { template_id: string; params: Partial<{ [key in string]: string }> }; result: { ok: boolean; message: string } } | { key: "session.disconnect"; input: { session_id: string; reason: string | null }; result: { disconnected: boolean } } | { key: "settings.setCurseforgeApiKey"; input: { key: string }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "settings.setDstDefaultKleiKey"; input: { key: string }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "settings.setSteamcmdCredentials"; input: { username: string; password: string; steam_guard_code: string | null; shared_secret: string | null; mafile_json: string | null }; result: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null } } | { key: "update.trigger"; input: null; result: { ok: boolean; message: string } }; subscriptions: never }
 
 export type ProcessResourcesDto = { cpu_percent_x100: number; rss_bytes: string; read_bytes: string; write_bytes: string }
 
-export type ProcessStatusDto = { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }
+export type ProcessStatusDto = { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }
+
+export type PropertyChangeDto = { key: string; old_value: string | null; new_value: string }
+
+export type PropertyValueDto = { key: string; value: string }
+
+export type SearchMatchDto = { path: string; line: number; text: string }
+
+export type SelfTestCheckDto = { name: string; outcome: string; message: string; duration_ms: string }
 
 export type TemplateParamDto = { key: string; label: string; kind: ParamTypeDto; required: boolean; default_value: string; min_int: number | null; max_int: number | null; enum_values: string[]; secret: boolean; placeholder: string | null; help: string | null; advanced: boolean }
 
@@ -37,6 +91,12 @@ export type UpdateLatestReleaseDto = { tag: string; version: string | null; url:
 export type Procedures = {
 	agent: {
 	health: { kind: "query", input: null, output: { status: string; agent_version: string }, error: unknown },
+	nodeInfo: { kind: "query", input: null, output: { node_id: string; name: string; labels: Partial<{ [key in string]: string }>; public_address: string | null; agent_version: string; os: string; arch: string; cpus: number; data_root: string; checkpoint_supported: boolean; checkpoint_unsupported_reason: string | null }, error: unknown },
+	placement: { kind: "query", input: { memory_mb: number | null; disk_mb: number | null; cpu_millicores: number | null }, output: { fits: boolean; score: number; reasons: string[]; memory_budget_mb: number; memory_available_mb: number; memory_committed_mb: number; cpu_millicores: number; cpu_committed_millicores: number; disk_usable_mb: number; instances: number }, error: unknown },
+	scheduleReload: { kind: "mutation", input: null, output: { poll_every_sec: string; schedules: BackupScheduleDto[]; errors: BackupScheduleErrorDto[]; changed: string[] }, error: unknown },
+	selftest: { kind: "mutation", input: { skip_network: boolean | null; port_start: number | null }, output: { ok: boolean; checks: SelfTestCheckDto[]; duration_ms: string }, error: unknown },
+	setLogLevel: { kind: "mutation", input: { filter: string | null }, output: { filter: string; previous: string | null }, error: unknown },
+	supportBundle: { kind: "mutation", input: { instance_ids: string[] | null; console_lines: number | null; keep_ip_addresses: boolean | null }, output: { path: string; size_bytes: string; files: string[]; redactions: number; data_base64: string | null }, error: unknown },
 },
 	control: {
 	diagnostics: { kind: "query", input: null, output: { fetched_at_unix_ms: string; request_id: string; control_version: string; read_only: boolean; agent: AgentHealthFullDto; fs: FsCapabilitiesOutput; cache: CacheStatsOutput; agent_log_path: string | null; agent_log_lines: string[] }, error: unknown },
@@ -49,33 +109,58 @@ export type Procedures = {
 	update: { kind: "mutation", input: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string }, output: { id: string; name: string; server_addr: string | null; server_port: number | null; allocatable_ports: string | null; token: string | null; config: string; latency_ms: number | null; created_at: string; updated_at: string }, error: unknown },
 },
 	fs: {
-	capabilities: { kind: "query", input: null, output: { write_enabled: boolean }, error: unknown },
-	listDir: { kind: "query", input: { path: string | null }, output: { entries: DirEntryDto[] }, error: unknown },
+	capabilities: { kind: "query", input: null, output: { write_enabled: boolean; disabled_commands: string[]; backup_protect_hours: number }, error: unknown },
+	hash: { kind: "query", input: { path: string; algorithm: string | null; quick: boolean | null; quick_bytes: number | null; workers: number | null }, output: { algorithm: string; quick: boolean; files: FileHashDto[] }, error: unknown },
+	listDir: { kind: "query", input: { path: string | null; no_cache: boolean | null }, output: { entries: DirEntryDto[] }, error: unknown },
 	readFile: { kind: "query", input: { path: string; offset: number | null; limit: number | null }, output: { text: string; size_bytes: number }, error: unknown },
+	search: { kind: "query", input: { path: string; query: string; case_insensitive: boolean | null; max_matches: number | null; export: string | null }, output: { matches: SearchMatchDto[]; total_matches: number; truncated: boolean; files_scanned: number; files_skipped: number; export_path: string | null; export_truncated: boolean }, error: unknown },
+	searchExport: { kind: "mutation", input: { path: string; query: string; case_insensitive: boolean | null; max_matches: number | null; format: string }, output: { matches: SearchMatchDto[]; total_matches: number; truncated: boolean; files_scanned: number; files_skipped: number; export_path: string | null; export_truncated: boolean }, error: unknown },
+	statBatch: { kind: "query", input: { paths: string[] }, output: { results: PathStatDto[] }, error: unknown },
 },
 	instance: {
-	create: { kind: "mutation", input: { template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, output: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, error: unknown },
+	acceptJar: { kind: "mutation", input: { instance_id: string }, output: { state: string; provider: string | null; source_url: string | null; version: string | null; expected_sha1: string | null; expected_size: string | null; actual_sha1: string | null; actual_size: string; recorded_at_unix_ms: string | null }, error: unknown },
+	create: { kind: "mutation", input: { template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, output: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null }, error: unknown },
+	createFromGolden: { kind: "mutation", input: { golden_instance_id: string; display_name: string | null }, output: { config: InstanceConfigDto; reflinked_files: number; linked_files: number; copied_files: number; size_bytes: string; elapsed_ms: string }, error: unknown },
 	delete: { kind: "mutation", input: { instance_id: string }, output: { ok: boolean }, error: unknown },
 	deletePreview: { kind: "query", input: { instance_id: string }, output: { instance_id: string; path: string; size_bytes: string }, error: unknown },
 	diagnostics: { kind: "mutation", input: { instance_id: string; max_lines: number | null; limit_bytes: number | null }, output: { instance_id: string; fetched_at_unix_ms: string; request_id: string; instance_json: string | null; run_json: string | null; console_log_lines: string[] }, error: unknown },
+	divergence: { kind: "query", input: { instance_id: string }, output: { golden_instance_id: string; shared_bytes: string; diverged_bytes: string; diverged_files: number; removed_files: number }, error: unknown },
+	envReport: { kind: "query", input: { instance_id: string; backup_path: string | null }, output: { current: EnvReportDto | null; backup: EnvReportDto | null; differences: string[] }, error: unknown },
+	exposeWebMap: { kind: "mutation", input: { instance_id: string; domain: string | null; public_port: number | null; remove: boolean | null }, output: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean }, error: unknown },
+	frpAdmin: { kind: "mutation", input: { instance_id: string; action: string; frp_config: string | null }, output: { output: string }, error: unknown },
+	frpStats: { kind: "query", input: { instance_id: string }, output: { configured: boolean; proxies: FrpProxyStatsDto[]; sampled_at_unix_ms: string | null }, error: unknown },
+	frpStatus: { kind: "query", input: { instance_id: string }, output: { configured: boolean; state: string; proxies: FrpProxyStatusDto[]; last_error: string | null; updated_at_unix_ms: string; endpoints: string[]; active_endpoint: string | null; failovers: FrpFailoverEventDto[]; security: FrpSecurityPostureDto | null }, error: unknown },
 	get: { kind: "query", input: { instance_id: string }, output: { config: InstanceConfigDto; status: ProcessStatusDto | null }, error: unknown },
+	hibernate: { kind: "mutation", input: { instance_id: string; timeout_ms: number | null }, output: { status: ProcessStatusDto; checkpointed: boolean; fallback_reason: string | null; checkpoint_bytes: string; elapsed_ms: string }, error: unknown },
 	importSaveFromUrl: { kind: "mutation", input: { instance_id: string; url: string }, output: { ok: boolean; message: string; installed_path: string; backup_path: string }, error: unknown },
+	installWebMap: { kind: "mutation", input: { instance_id: string; kind: string; port: number | null; minecraft_version: string | null; loader: string | null }, output: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean }, error: unknown },
+	lastCrash: { kind: "query", input: { instance_id: string }, output: { process_id: string; template_id: string; exited_at_unix_ms: string; runtime_ms: string; exit_code: number | null; signal: number | null; exit_category: string | null; exit_reason: string | null; hs_err_path: string | null; lines: string[]; path: string; start_request_id: string | null } | null, error: unknown },
 	list: { kind: "query", input: null, output: ({ config: InstanceConfigDto; status: ProcessStatusDto | null })[], error: unknown },
-	restart: { kind: "mutation", input: { instance_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
-	start: { kind: "mutation", input: { instance_id: string }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
-	stop: { kind: "mutation", input: { instance_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
-	update: { kind: "mutation", input: { instance_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, output: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, error: unknown },
+	mapPreview: { kind: "mutation", input: { instance_id: string; dimension: string | null; zoom: number | null; min_x: number | null; min_z: number | null; max_x: number | null; max_z: number | null }, output: { path: string; png_base64: string; width: number; height: number; zoom: number; min_x: number; min_z: number; max_x: number; max_z: number; chunks_rendered: number; chunks_flat: number; dimension: string; seed: string | null }, error: unknown },
+	playerInventory: { kind: "query", input: { instance_id: string; player: string; backup_path: string | null }, output: { uuid: string; name: string | null; backup_path: string | null; items: InventoryItemDto[]; ender_items: InventoryItemDto[]; xp_level: number }, error: unknown },
+	playerPositions: { kind: "query", input: { instance_id: string; player: string | null }, output: ({ uuid: string; name: string | null; dimension: string; x: number; y: number; z: number; yaw: number; pitch: number; health: number; xp_level: number; game_mode: string | null; last_death: BlockPositionDto | null; respawn: BlockPositionDto | null; saved_at_unix_ms: string; error: string | null })[], error: unknown },
+	playerStats: { kind: "query", input: { instance_id: string; player: string | null; top_blocks: number | null }, output: { players: PlayerStatsDto[]; totals: PlayerStatsDto }, error: unknown },
+	restart: { kind: "mutation", input: { instance_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
+	restorePlayerData: { kind: "mutation", input: { instance_id: string; player: string; backup_path: string }, output: { uuid: string; previous_path: string | null; items: number; ender_items: number }, error: unknown },
+	setGolden: { kind: "mutation", input: { instance_id: string; golden: boolean }, output: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null }, error: unknown },
+	start: { kind: "mutation", input: { instance_id: string }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
+	stop: { kind: "mutation", input: { instance_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
+	update: { kind: "mutation", input: { instance_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null }, output: { instance_id: string; template_id: string; params: Partial<{ [key in string]: string }>; display_name: string | null; golden: boolean; derived_from: string | null; node_id: string | null; node_name: string | null }, error: unknown },
+	validateStart: { kind: "mutation", input: { instance_id: string }, output: { ok: boolean; verdict: string; message: string; jar_path: string | null; main_class: string | null; exit_code: number | null; timed_out: boolean; network_isolated: boolean; duration_ms: string; output: string[] }, error: unknown },
+	verifyJar: { kind: "query", input: { instance_id: string }, output: { state: string; provider: string | null; source_url: string | null; version: string | null; expected_sha1: string | null; expected_size: string | null; actual_sha1: string | null; actual_size: string; recorded_at_unix_ms: string | null }, error: unknown },
+	webMapStatus: { kind: "query", input: { instance_id: string }, output: { installed: boolean; kind: string | null; version_number: string | null; mod_path: string | null; port: number; config_port: number | null; listening: boolean; local_url: string | null; exposed: boolean; public_url: string | null; auth_user: string | null; auth_password: string | null; proxy_state: string | null; proxy_error: string | null; reloaded: boolean }, error: unknown },
 },
 	log: {
+	paste: { kind: "mutation", input: { path: string; filter: string | null; max_lines: number | null }, output: { url: string; raw_url: string | null; service: string; lines: number; bytes: string; redactions: number; truncated: boolean }, error: unknown },
 	tailFile: { kind: "query", input: { path: string; cursor: string | null; limit_bytes: number | null; max_lines: number | null }, output: { lines: string[]; next_cursor: string }, error: unknown },
 },
 	minecraft: {
-	versions: { kind: "query", input: null, output: { latest_release: string; latest_snapshot: string; versions: MinecraftVersionRef[] }, error: unknown },
+	versions: { kind: "query", input: null, output: { latest_release: string; latest_snapshot: string; versions: MinecraftVersionRef[]; is_stale: boolean; fetched_at: string }, error: unknown },
 },
 	node: {
 	create: { kind: "mutation", input: { name: string }, output: { node: NodeDto; connect_token: string }, error: unknown },
-	list: { kind: "query", input: null, output: ({ id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null })[], error: unknown },
-	setEnabled: { kind: "mutation", input: { node_id: string; enabled: boolean }, output: { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null }, error: unknown },
+	list: { kind: "query", input: null, output: ({ id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null; disabled_commands: string[]; node_id: string | null; labels: Partial<{ [key in string]: string }>; public_address: string | null })[], error: unknown },
+	setEnabled: { kind: "mutation", input: { node_id: string; enabled: boolean }, output: { id: string; name: string; endpoint: string; has_connect_token: boolean; enabled: boolean; last_seen_at: string | null; agent_version: string | null; last_error: string | null; disabled_commands: string[]; node_id: string | null; labels: Partial<{ [key in string]: string }>; public_address: string | null }, error: unknown },
 },
 	process: {
 	cacheStats: { kind: "query", input: null, output: { entries: CacheEntryDto[] }, error: unknown },
@@ -89,13 +174,17 @@ export type Procedures = {
 	downloadQueueResumeJob: { kind: "mutation", input: { job_id: string }, output: { ok: boolean }, error: unknown },
 	downloadQueueRetryJob: { kind: "mutation", input: { job_id: string }, output: { ok: boolean }, error: unknown },
 	downloadQueueSetPaused: { kind: "mutation", input: { paused: boolean }, output: { ok: boolean }, error: unknown },
-	list: { kind: "query", input: null, output: ({ process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null })[], error: unknown },
+	list: { kind: "query", input: null, output: ({ process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null })[], error: unknown },
 	logsTail: { kind: "query", input: { process_id: string; cursor: string | null; limit: number | null }, output: { lines: string[]; next_cursor: string }, error: unknown },
-	start: { kind: "mutation", input: { template_id: string; params: Partial<{ [key in string]: string }> }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
-	status: { kind: "query", input: { process_id: string }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
-	stop: { kind: "mutation", input: { process_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null }, error: unknown },
+	start: { kind: "mutation", input: { template_id: string; params: Partial<{ [key in string]: string }> }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
+	status: { kind: "query", input: { process_id: string }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
+	stop: { kind: "mutation", input: { process_id: string; timeout_ms: number | null }, output: { process_id: string; template_id: string; state: string; pid: number | null; exit_code: number | null; message: string | null; resources: ProcessResourcesDto | null; exit_category: string | null; exit_reason: string | null }, error: unknown },
 	templates: { kind: "query", input: null, output: { template_id: string; display_name: string; params: TemplateParamDto[] }[], error: unknown },
 	warmCache: { kind: "mutation", input: { template_id: string; params: Partial<{ [key in string]: string }> }, output: { ok: boolean; message: string }, error: unknown },
+},
+	session: {
+	disconnect: { kind: "mutation", input: { session_id: string; reason: string | null }, output: { disconnected: boolean }, error: unknown },
+	list: { kind: "query", input: null, output: { sessions: PanelSessionDto[] }, error: unknown },
 },
 	settings: {
 	setCurseforgeApiKey: { kind: "mutation", input: { key: string }, output: { dst_default_klei_key_set: boolean; curseforge_api_key_set: boolean; steamcmd_username_set: boolean; steamcmd_password_set: boolean; steamcmd_shared_secret_set: boolean; steamcmd_account_name: string | null }, error: unknown },
@@ -175,6 +175,14 @@ export default function ControlDiagnosticsModal(props: ControlDiagnosticsModalPr
                                 <div class="font-mono text-[11px]">{formatBytes(Number(d().agent.data_root_free_bytes))}</div>
                               </div>
                             </Show>
+                            <Show when={d().agent.frp?.tunnels}>
+                              <div class="flex items-center justify-between gap-3">
+                                <div class="text-slate-500 dark:text-slate-400">FRP tunnels</div>
+                                <div class="font-mono text-[11px]">
+                                  {d().agent.frp?.tunnels_running}/{d().agent.frp?.tunnels} up · {d().agent.frp?.cur_conns} conns
+                                </div>
+                              </div>
+                            </Show>
                             <Show when={d().agent.error}>
                               <div class="rounded-lg border border-rose-200 bg-rose-50 px-3 py-2 text-[12px] text-rose-800 dark:border-rose-900/40 dark:bg-rose-950/20 dark:text-rose-200">
                                 {d().agent.error}
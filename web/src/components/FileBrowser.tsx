@@ -240,7 +240,7 @@ export function FileBrowser(props: FileBrowserProps) {
   }
 
   const fsList = rspc.createQuery(
-    () => ['fs.listDir', { path: path() ? path() : null }],
+    () => ['fs.listDir', { path: path() ? path() : null, no_cache: null }],
     () => ({ enabled: props.enabled, refetchOnWindowFocus: false, staleTime: 0 }),
   )
 
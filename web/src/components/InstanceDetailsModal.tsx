@@ -485,6 +485,14 @@ export default function InstanceDetailsModal(props: InstanceDetailsModalProps) {
                                 <div class="font-mono text-[11px]">{status()?.exit_code}</div>
                               </div>
                             </Show>
+                            <Show when={status()?.exit_category != null}>
+                              <div class="flex items-center justify-between gap-3">
+                                <div class="text-slate-500 dark:text-slate-400">Exit reason</div>
+                                <div class="font-mono text-[11px]" title={status()?.exit_reason ?? ''}>
+                                  {status()?.exit_category}
+                                </div>
+                              </div>
+                            </Show>
                           </div>
 
                           <Show when={status()?.message != null}>
@@ -4,6 +4,7 @@ import { createSolidQueryHooks } from '@rspc/solid-query'
 
 import type { ProceduresLegacy } from './bindings'
 import { getCsrfTokenFromCookie, refreshSession } from './auth'
+import { parseMessageKey, type MessageKey } from './app/helpers/agentErrors'
 
 export type AlloyApiErrorData = {
   code: string
@@ -11,6 +12,8 @@ export type AlloyApiErrorData = {
   request_id: string
   field_errors?: Record<string, string>
   hint?: string | null
+  message_key?: MessageKey | null
+  hint_key?: MessageKey | null
 }
 
 export class AlloyApiError extends Error {
@@ -65,7 +68,9 @@ function parseApiErrorFromLegacyMessage(raw: string): AlloyApiErrorData | null {
     const request_id = typeof value.request_id === 'string' ? value.request_id : ''
     const field_errors = parseFieldErrors((value as any).field_errors ?? (value as any).fieldErrors)
     const hint = typeof (value as any).hint === 'string' ? (value as any).hint : null
-    return { code: value.code, message: value.message, request_id, field_errors, hint }
+    const message_key = parseMessageKey((value as any).message_key)
+    const hint_key = parseMessageKey((value as any).hint_key)
+    return { code: value.code, message: value.message, request_id, field_errors, hint, message_key, hint_key }
   } catch {
     return null
   }
@@ -139,8 +144,10 @@ class AlloyFetchTransport {
 
       const field_errors = parseFieldErrors((data as any).field_errors ?? (data as any).fieldErrors)
       const hint = typeof data.hint === 'string' ? data.hint : null
+      const message_key = parseMessageKey((data as any).message_key)
+      const hint_key = parseMessageKey((data as any).hint_key)
 
-      return new AlloyApiError({ code, message, request_id, field_errors, hint })
+      return new AlloyApiError({ code, message, request_id, field_errors, hint, message_key, hint_key })
     }
 
     if (typeof data === 'string' && data.trim()) {
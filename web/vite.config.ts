@@ -16,6 +16,11 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
       },
+      // Live console/metrics/event streams.
+      '/topics': {
+        target: 'ws://localhost:8080',
+        ws: true,
+      },
     },
   },
 })